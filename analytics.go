@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// Playback analytics rollup settings.
+const (
+	// analyticsRollupDays is how many days, counting today, each rollup
+	// recomputes. Events arrive up to maxPlaybackEventAge late, so a day
+	// can change until the next one ends; the extra day picks up what
+	// arrived after that day's last run.
+	analyticsRollupDays = 3
+	// playbackEventRetention is how long raw events are kept after they
+	// occurred. Their days are long final by then.
+	playbackEventRetention = 30 * 24 * time.Hour
+	analyticsDayFormat     = time.DateOnly
+)
+
+// rollUpPlaybackAnalytics recomputes the daily rollups of the days that can
+// still get events. Each day is recomputed from all of its events, so a run
+// that fails part way is made good by the next.
+func (cfg *apiConfig) rollUpPlaybackAnalytics() error {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for i := analyticsRollupDays - 1; i >= 0; i-- {
+		day := today.AddDate(0, 0, -i)
+		err := cfg.rollUpPlaybackDay(day)
+		if err != nil {
+			return fmt.Errorf("couldn't roll up %s: %w", day.Format(analyticsDayFormat), err)
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) rollUpPlaybackDay(day time.Time) error {
+	end := day.AddDate(0, 0, 1)
+	videoIDs, err := cfg.db.GetPlaybackEventVideoIDs(day, end)
+	if err != nil {
+		return err
+	}
+	videos, err := cfg.db.GetVideosByIDs(videoIDs)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	rollups := []database.VideoAnalyticsDay{}
+	for _, video := range videos {
+		events, err := cfg.db.GetPlaybackEvents(video.ID, day, end)
+		if err != nil {
+			return err
+		}
+		rollup := rollUpPlaybackEvents(events, video.DurationSeconds)
+		if rollup.Views == 0 {
+			continue
+		}
+		rollup.VideoID = video.ID
+		rollup.Day = day.Format(analyticsDayFormat)
+		rollup.UpdatedAt = now
+		rollups = append(rollups, rollup)
+	}
+
+	err = cfg.db.ReplaceVideoAnalyticsDay(day.Format(analyticsDayFormat), rollups)
+	if err != nil {
+		return err
+	}
+	logging.Debugf("Rolled up playback of %d videos on %s", len(rollups), day.Format(analyticsDayFormat))
+	return nil
+}
+
+// rollUpPlaybackEvents works out the views, watch time and retention of one
+// video's events, grouped by session. A view is a session that played.
+// Watch time is how far the position moved forward while playing, so
+// seeking ahead doesn't count and rewatching does. Retention counts how far
+// into the video each view got, and needs the video's duration.
+func rollUpPlaybackEvents(events []database.PlaybackEvent, durationSeconds *float64) database.VideoAnalyticsDay {
+	rollup := database.VideoAnalyticsDay{Retention: make([]int, database.RetentionPoints)}
+
+	for start := 0; start < len(events); {
+		end := start
+		for end < len(events) && events[end].SessionID == events[start].SessionID {
+			end++
+		}
+
+		played, playing := false, false
+		position, furthest, watched := 0.0, 0.0, 0.0
+		for _, event := range events[start:end] {
+			if playing && event.PositionSeconds > position {
+				watched += event.PositionSeconds - position
+			}
+			position = event.PositionSeconds
+			furthest = max(furthest, position)
+
+			switch event.Type {
+			case database.PlaybackEventPlay:
+				played, playing = true, true
+			case database.PlaybackEventProgress:
+				// Players only report progress while playing
+				played, playing = true, true
+				if durationSeconds != nil {
+					furthest = max(furthest, *durationSeconds*float64(event.Quartile)/100)
+				}
+			case database.PlaybackEventPause:
+				playing = false
+			case database.PlaybackEventComplete:
+				playing = false
+				if durationSeconds != nil {
+					furthest = max(furthest, *durationSeconds)
+				}
+			}
+		}
+		start = end
+
+		if !played {
+			continue
+		}
+		rollup.Views++
+		rollup.WatchSeconds += watched
+		if durationSeconds == nil || *durationSeconds <= 0 {
+			continue
+		}
+		for i := range rollup.Retention {
+			// A little slack so a view that ended on a point counts for it
+			if furthest+0.01 >= *durationSeconds*float64(i)/float64(database.RetentionPoints-1) {
+				rollup.Retention[i]++
+			}
+		}
+	}
+	return rollup
+}
+
+func (cfg *apiConfig) prunePlaybackEvents() error {
+	return cfg.db.DeletePlaybackEventsBefore(time.Now().Add(-playbackEventRetention))
+}
+
+// analyticsCacheKey is where a video's report for a range of days is
+// cached.
+func analyticsCacheKey(videoID uuid.UUID, first, last string) string {
+	return "analytics:" + videoID.String() + ":" + first + ":" + last
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// Analytics export settings.
+const (
+	analyticsExportPage    = 1000
+	analyticsExportsShown  = 50
+	maxAnalyticsExportDays = 366
+	analyticsExportCSV     = "csv"
+)
+
+var analyticsExportDatasets = []string{
+	database.AnalyticsDatasetPlaybackEvents,
+	database.AnalyticsDatasetDaily,
+	database.AnalyticsDatasetBillingUsage,
+}
+
+// startAnalyticsExport writes an export's file in the background.
+func (cfg *apiConfig) startAnalyticsExport(export database.AnalyticsExport) {
+	go func() {
+		start := time.Now()
+		rows, err := cfg.runAnalyticsExport(export)
+		if err != nil {
+			logging.Errorf("Analytics export %s failed: %v", export.ID, err)
+			err = cfg.db.FailAnalyticsExport(export.ID, err.Error())
+			if err != nil {
+				logging.Warnf("Couldn't record failure of analytics export %s: %v", export.ID, err)
+			}
+			return
+		}
+		logging.Infof("Exported %d rows of %s for %s to %s in %s", rows, export.Dataset, export.FromDay, export.ToDay,
+			time.Since(start).Round(time.Millisecond))
+	}()
+}
+
+// resumeAnalyticsExports starts again every export left pending by a
+// previous process. Nothing of a half-written export is kept, so it's
+// simply written from the start.
+func (cfg *apiConfig) resumeAnalyticsExports() error {
+	exports, err := cfg.db.GetPendingAnalyticsExports()
+	if err != nil {
+		return err
+	}
+	for _, export := range exports {
+		logging.Infof("Resuming analytics export %s", export.ID)
+		cfg.startAnalyticsExport(export)
+	}
+	return nil
+}
+
+// runAnalyticsExport writes an export's rows to a temporary CSV file and
+// uploads it, returning how many rows it had.
+func (cfg *apiConfig) runAnalyticsExport(export database.AnalyticsExport) (int64, error) {
+	file, err := os.CreateTemp("", tempFilePrefix+"analytics-export-*.csv")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	rows, err := cfg.writeAnalyticsCSV(file, export)
+	if err != nil {
+		return 0, err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+
+	bucket := cfg.analyticsExportBucket
+	key := cfg.analyticsExportKey(export)
+	contentType := "text/csv; charset=utf-8"
+	disposition := fmt.Sprintf(`attachment; filename="%s_%s_%s.csv"`, export.Dataset, export.FromDay, export.ToDay)
+	_, err = cfg.storage.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:             &bucket,
+		Key:                &key,
+		Body:               file,
+		ContentType:        &contentType,
+		ContentDisposition: &disposition,
+		ChecksumAlgorithm:  cfg.checksumAlgorithm,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to upload export %s: %w", key, err)
+	}
+
+	err = cfg.db.CompleteAnalyticsExport(export.ID, fmt.Sprintf("%s,%s", bucket, key), rows)
+	if err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
+
+// analyticsExportKey is where an export's file is stored, under
+// ANALYTICS_EXPORT_PREFIX.
+func (cfg *apiConfig) analyticsExportKey(export database.AnalyticsExport) string {
+	return fmt.Sprintf("%s%s/%s_%s_%s.%s", cfg.analyticsExportPrefix, export.Dataset, export.FromDay, export.ToDay,
+		export.ID, export.Format)
+}
+
+// writeAnalyticsCSV writes an export's header and rows, a page at a time.
+func (cfg *apiConfig) writeAnalyticsCSV(w io.Writer, export database.AnalyticsExport) (int64, error) {
+	from, err := time.Parse(analyticsDayFormat, export.FromDay)
+	if err != nil {
+		return 0, err
+	}
+	to, err := time.Parse(analyticsDayFormat, export.ToDay)
+	if err != nil {
+		return 0, err
+	}
+
+	out := csv.NewWriter(w)
+	var rows int64
+	switch export.Dataset {
+	case database.AnalyticsDatasetPlaybackEvents:
+		rows, err = cfg.writePlaybackEventsCSV(out, from, to.AddDate(0, 0, 1))
+	case database.AnalyticsDatasetDaily:
+		rows, err = cfg.writeAnalyticsDaysCSV(out, from, to)
+	case database.AnalyticsDatasetBillingUsage:
+		rows, err = cfg.writeBillingUsageCSV(out, export.FromDay, export.ToDay)
+	default:
+		err = fmt.Errorf("unknown dataset %q", export.Dataset)
+	}
+	if err != nil {
+		return 0, err
+	}
+	out.Flush()
+	return rows, out.Error()
+}
+
+// writePlaybackEventsCSV writes every event that occurred in [from, to).
+func (cfg *apiConfig) writePlaybackEventsCSV(out *csv.Writer, from, to time.Time) (int64, error) {
+	err := out.Write([]string{"id", "video_id", "session_id", "type", "quartile", "position_seconds", "occurred_at", "received_at"})
+	if err != nil {
+		return 0, err
+	}
+
+	var rows int64
+	var after int64
+	for {
+		events, err := cfg.db.Replica().GetPlaybackEventsAfter(after, from, to, analyticsExportPage)
+		if err != nil {
+			return rows, err
+		}
+		for _, event := range events {
+			err := out.Write([]string{
+				strconv.FormatInt(event.ID, 10),
+				event.VideoID.String(),
+				event.SessionID.String(),
+				event.Type,
+				strconv.Itoa(event.Quartile),
+				strconv.FormatFloat(event.PositionSeconds, 'f', -1, 64),
+				event.OccurredAt.UTC().Format(time.RFC3339Nano),
+				event.ReceivedAt.UTC().Format(time.RFC3339Nano),
+			})
+			if err != nil {
+				return rows, err
+			}
+			rows++
+		}
+		if len(events) < analyticsExportPage {
+			return rows, nil
+		}
+		after = events[len(events)-1].ID
+	}
+}
+
+// writeAnalyticsDaysCSV writes every video's rollup for each day from first
+// to last. Retention is spread over a column per point, counting the views
+// that got that far.
+func (cfg *apiConfig) writeAnalyticsDaysCSV(out *csv.Writer, first, last time.Time) (int64, error) {
+	header := []string{"day", "video_id", "views", "watch_seconds", "average_view_duration_seconds"}
+	for i := range database.RetentionPoints {
+		header = append(header, fmt.Sprintf("retained_views_%d", i*100/(database.RetentionPoints-1)))
+	}
+	header = append(header, "updated_at")
+	err := out.Write(header)
+	if err != nil {
+		return 0, err
+	}
+
+	var rows int64
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		after := uuid.Nil
+		for {
+			rollups, err := cfg.db.Replica().GetVideoAnalyticsDaysAfter(day.Format(analyticsDayFormat), after, analyticsExportPage)
+			if err != nil {
+				return rows, err
+			}
+			for _, rollup := range rollups {
+				record := []string{
+					rollup.Day,
+					rollup.VideoID.String(),
+					strconv.Itoa(rollup.Views),
+					strconv.FormatFloat(rollup.WatchSeconds, 'f', -1, 64),
+					strconv.FormatFloat(rollup.WatchSeconds/float64(rollup.Views), 'f', -1, 64),
+				}
+				for i := range database.RetentionPoints {
+					views := 0
+					if i < len(rollup.Retention) {
+						views = rollup.Retention[i]
+					}
+					record = append(record, strconv.Itoa(views))
+				}
+				record = append(record, rollup.UpdatedAt.UTC().Format(time.RFC3339Nano))
+				err := out.Write(record)
+				if err != nil {
+					return rows, err
+				}
+				rows++
+			}
+			if len(rollups) < analyticsExportPage {
+				break
+			}
+			after = rollups[len(rollups)-1].VideoID
+		}
+	}
+	return rows, nil
+}
+
+// writeBillingUsageCSV writes each user's usage over the days from first
+// to last, both in billing units and as metered.
+func (cfg *apiConfig) writeBillingUsageCSV(out *csv.Writer, first, last string) (int64, error) {
+	err := out.Write([]string{"user_id", "from", "to", "storage_gb_days", "transcode_minutes", "egress_gb",
+		"storage_byte_days", "transcode_seconds", "egress_bytes"})
+	if err != nil {
+		return 0, err
+	}
+
+	var rows int64
+	after := uuid.Nil
+	for {
+		totals, err := cfg.db.Replica().GetUsageTotals(first, last, after, analyticsExportPage)
+		if err != nil {
+			return rows, err
+		}
+		for _, total := range totals {
+			usage := newBillingUsage(total)
+			err := out.Write([]string{
+				usage.UserID.String(),
+				first,
+				last,
+				strconv.FormatFloat(usage.StorageGBDays, 'f', -1, 64),
+				strconv.FormatFloat(usage.TranscodeMinutes, 'f', -1, 64),
+				strconv.FormatFloat(usage.EgressGB, 'f', -1, 64),
+				strconv.FormatFloat(usage.StorageByteDays, 'f', -1, 64),
+				strconv.FormatFloat(usage.TranscodeSeconds, 'f', -1, 64),
+				strconv.FormatFloat(usage.EgressBytes, 'f', -1, 64),
+			})
+			if err != nil {
+				return rows, err
+			}
+			rows++
+		}
+		if len(totals) < analyticsExportPage {
+			return rows, nil
+		}
+		after = totals[len(totals)-1].UserID
+	}
+}
+
+// exportAnalyticsDaily exports both datasets for the newest day the rollup
+// job no longer recomputes, so the files never go stale.
+func (cfg *apiConfig) exportAnalyticsDaily() error {
+	day := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -analyticsRollupDays).Format(analyticsDayFormat)
+	for _, dataset := range []string{database.AnalyticsDatasetPlaybackEvents, database.AnalyticsDatasetDaily} {
+		export, err := cfg.db.CreateAnalyticsExport(database.CreateAnalyticsExportParams{
+			Dataset:     dataset,
+			Format:      analyticsExportCSV,
+			FromDay:     day,
+			ToDay:       day,
+			RequestedBy: "schedule",
+		})
+		if err != nil {
+			return err
+		}
+		_, err = cfg.runAnalyticsExport(export)
+		if err != nil {
+			cfg.db.FailAnalyticsExport(export.ID, err.Error())
+			return fmt.Errorf("couldn't export %s for %s: %w", dataset, day, err)
+		}
+	}
+	return nil
+}
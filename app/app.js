@@ -5,6 +5,12 @@ document.addEventListener('DOMContentLoaded', async () => {
     document.getElementById('auth-section').style.display = 'none';
     document.getElementById('video-section').style.display = 'block';
     await getVideos();
+
+    // Links in emails open a video directly
+    const linkedVideoID = new URLSearchParams(window.location.search).get('video');
+    if (linkedVideoID) {
+      await videoStateHandler(linkedVideoID);
+    }
   } else {
     document.getElementById('auth-section').style.display = 'block';
     document.getElementById('video-section').style.display = 'none';
@@ -188,18 +194,25 @@ const videoStateHandler = createVideoStateHandler();
 
 async function getVideos() {
   try {
-    const res = await fetch('/api/videos', {
-      method: 'GET',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
-    });
-    if (!res.ok) {
+    // Follow next_cursor until every page has been fetched
+    const videos = [];
+    let cursor = null;
+    do {
+      const url = cursor ? `/api/videos?cursor=${encodeURIComponent(cursor)}` : '/api/videos';
+      const res = await fetch(url, {
+        method: 'GET',
+        headers: {
+          Authorization: `Bearer ${localStorage.getItem('token')}`,
+        },
+      });
       const data = await res.json();
-      throw new Error(`Failed to get videos. Error: ${data.error}`);
-    }
+      if (!res.ok) {
+        throw new Error(`Failed to get videos. Error: ${data.error}`);
+      }
+      videos.push(...data.videos);
+      cursor = data.next_cursor;
+    } while (cursor);
 
-    const videos = await res.json();
     const videoList = document.getElementById('video-list');
     videoList.innerHTML = '';
     for (const video of videos) {
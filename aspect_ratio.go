@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// aspectRatioCategories are the display shapes clients badge specially.
+// Anything not within aspectRatioTolerance of one of these is "other".
+var aspectRatioCategories = []struct {
+	name  string
+	ratio float64
+}{
+	{"16:9", 16.0 / 9.0},
+	{"9:16", 9.0 / 16.0},
+	{"4:3", 4.0 / 3.0},
+	{"3:4", 3.0 / 4.0},
+	{"1:1", 1},
+	{"21:9", 64.0 / 27.0},
+}
+
+// aspectRatioTolerance is relative, so it is equally strict for wide and tall
+// videos and doesn't let 4:3 and 16:9 windows overlap.
+const aspectRatioTolerance = 0.02
+
+// displayRatio is the shape the video is shown at: the coded size scaled by
+// the sample (pixel) aspect ratio. Anamorphic 1440x1080 with a 4:3 SAR is
+// displayed as 16:9. An unknown SAR (0 or missing) is treated as square pixels.
+func displayRatio(width, height, sarNum, sarDen int) float64 {
+	if width <= 0 || height <= 0 {
+		return 0
+	}
+	if sarNum <= 0 || sarDen <= 0 {
+		sarNum, sarDen = 1, 1
+	}
+	return float64(width) * float64(sarNum) / (float64(height) * float64(sarDen))
+}
+
+// aspectRatioCategory returns the named shape closest to the display ratio, or
+// "other" if none is close enough.
+func aspectRatioCategory(width, height, sarNum, sarDen int) string {
+	ratio := displayRatio(width, height, sarNum, sarDen)
+	if ratio == 0 {
+		return "other"
+	}
+	for _, category := range aspectRatioCategories {
+		if math.Abs(ratio-category.ratio)/category.ratio <= aspectRatioTolerance {
+			return category.name
+		}
+	}
+	return "other"
+}
+
+// aspectRatioFilter selects the videos listed under an aspect ratio
+// category. "other" excludes every named category instead.
+func aspectRatioFilter(filter *database.VideoFilter, category string) {
+	ranges := make([]database.RatioRange, 0, len(aspectRatioCategories))
+	for _, c := range aspectRatioCategories {
+		r := database.RatioRange{
+			Min: c.ratio * (1 - aspectRatioTolerance),
+			Max: c.ratio * (1 + aspectRatioTolerance),
+		}
+		if c.name == category {
+			filter.AspectRatios = []database.RatioRange{r}
+			return
+		}
+		ranges = append(ranges, r)
+	}
+	filter.ExcludeAspectRatios = ranges
+}
+
+// aspectRatioCategoryNames lists the values of the aspect_ratio filter.
+func aspectRatioCategoryNames() []string {
+	names := []string{}
+	for _, c := range aspectRatioCategories {
+		names = append(names, c.name)
+	}
+	return append(names, "other")
+}
+
+// withDisplayFields fills in the fields the API derives from stored values.
+func withDisplayFields(video database.Video) database.Video {
+	video.ThumbnailPendingReview = video.PendingThumbnailURL != nil
+	if video.Width == nil || video.Height == nil {
+		return video
+	}
+	video.AspectRatio = aspectRatioCategory(*video.Width, *video.Height, video.SampleAspectNum, video.SampleAspectDen)
+	return video
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// trackAsset records an object derived from a video so it's cleaned up with
+// the video. Failures are only logged: the worst case is an object that
+// outlives its video.
+func (cfg *apiConfig) trackAsset(videoID uuid.UUID, kind database.AssetKind, storage database.AssetStorage, location string) {
+	err := cfg.db.CreateAsset(database.CreateAssetParams{
+		VideoID:  videoID,
+		Kind:     kind,
+		Storage:  storage,
+		Location: location,
+	})
+	if err != nil {
+		logging.Warnf("Couldn't track %s %s for video %s: %v", kind, location, videoID, err)
+	}
+}
+
+// thumbnailAsset works out where a stored thumbnail URL points. Placeholder
+// and other external URLs aren't stored objects.
+func (cfg *apiConfig) thumbnailAsset(thumbnailURL string) (database.AssetStorage, string, bool) {
+	if isBucketKey(thumbnailURL) {
+		return database.AssetStorageS3, thumbnailURL, true
+	}
+	if filename, ok := strings.CutPrefix(thumbnailURL, cfg.assetURL("")); ok && filename != "" {
+		return database.AssetStorageLocal, filename, true
+	}
+	return "", "", false
+}
+
+// storedImageURL is the URL an image stored at location is recorded under,
+// the reverse of thumbnailAsset.
+func (cfg *apiConfig) storedImageURL(storage database.AssetStorage, location string) string {
+	if storage == database.AssetStorageLocal {
+		return cfg.assetURL(location)
+	}
+	return location
+}
+
+// trackThumbnail records the video's current thumbnail as an asset.
+func (cfg *apiConfig) trackThumbnail(video database.Video) {
+	if video.ThumbnailURL == nil {
+		return
+	}
+	if storage, location, ok := cfg.thumbnailAsset(*video.ThumbnailURL); ok {
+		cfg.trackAsset(video.ID, database.AssetKindThumbnail, storage, location)
+	}
+}
+
+// releaseThumbnail releases a thumbnail the video no longer uses.
+func (cfg *apiConfig) releaseThumbnail(videoID uuid.UUID, thumbnailURL *string) {
+	if thumbnailURL == nil {
+		return
+	}
+	storage, location, ok := cfg.thumbnailAsset(*thumbnailURL)
+	if !ok {
+		return
+	}
+	err := cfg.releaseAsset(database.CreateAssetParams{
+		VideoID:  videoID,
+		Kind:     database.AssetKindThumbnail,
+		Storage:  storage,
+		Location: location,
+	})
+	if err != nil {
+		logging.Warnf("Couldn't delete previous thumbnail for video %s: %v", videoID, err)
+	}
+}
+
+// releaseAsset drops a video's reference to an object, deleting the object
+// itself once nothing else references it. If the delete fails the reference
+// is kept so the orphan sweep can retry. Assets of a video under legal hold
+// are kept, reference and all, returning database.ErrLegalHold.
+func (cfg *apiConfig) releaseAsset(asset database.CreateAssetParams) error {
+	video, err := cfg.db.GetVideo(asset.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.LegalHold {
+		return database.ErrLegalHold
+	}
+
+	others, err := cfg.db.CountOtherAssetReferences(asset)
+	if err != nil {
+		return err
+	}
+	// Avatars and banners can share a content-addressed image too
+	profiles, err := cfg.db.CountProfileImageReferences(cfg.storedImageURL(asset.Storage, asset.Location))
+	if err != nil {
+		return err
+	}
+	if others == 0 && profiles == 0 {
+		err = cfg.deleteStoredObject(asset.Storage, asset.Location)
+		if err != nil {
+			return err
+		}
+	}
+	return cfg.db.DeleteAsset(asset)
+}
+
+func (cfg *apiConfig) deleteStoredObject(storage database.AssetStorage, location string) error {
+	switch storage {
+	case database.AssetStorageS3:
+		bucket, key, err := parseBucketKey(location)
+		if err != nil {
+			return err
+		}
+		cfg.forgetPresignedURLs(bucket, key)
+		return cfg.deleteObject(bucket, key)
+	case database.AssetStorageLocal:
+		if location != filepath.Base(location) {
+			return fmt.Errorf("invalid asset filename %q", location)
+		}
+		err := os.Remove(filepath.Join(cfg.assetsRoot, location))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown asset storage %q", storage)
+	}
+}
+
+// deleteVideoAssets deletes every object derived from a video: originals,
+// renditions, thumbnails, thumbnail candidates and captions. Videos
+// processed before assets were tracked still have their original and
+// rendition removed.
+// Every object is attempted; the errors are joined. Nothing is deleted for a
+// video under legal hold.
+func (cfg *apiConfig) deleteVideoAssets(video database.Video) error {
+	if video.LegalHold {
+		return database.ErrLegalHold
+	}
+	assets, err := cfg.db.GetAssetsForVideo(video.ID)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	tracked := map[string]bool{}
+	for _, asset := range assets {
+		tracked[asset.Location] = true
+		err := cfg.releaseAsset(asset.CreateAssetParams)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("couldn't delete %s %s: %w", asset.Kind, asset.Location, err))
+		}
+	}
+
+	for _, location := range []*string{video.OriginalURL, video.VideoURL} {
+		if location == nil || tracked[*location] {
+			continue
+		}
+		err := cfg.deleteStoredObject(database.AssetStorageS3, *location)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("couldn't delete %s: %w", *location, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// collectOrphanedAssets retries deleting objects whose video is already gone.
+func (cfg *apiConfig) collectOrphanedAssets() error {
+	assets, err := cfg.db.GetOrphanedAssets()
+	if err != nil {
+		return fmt.Errorf("couldn't list orphaned assets: %w", err)
+	}
+	for _, asset := range assets {
+		err := cfg.releaseAsset(asset.CreateAssetParams)
+		if err != nil {
+			logging.Warnf("Couldn't delete orphaned %s %s: %v", asset.Kind, asset.Location, err)
+		}
+	}
+	return nil
+}
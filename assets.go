@@ -1,7 +1,11 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"os"
+	"path/filepath"
 )
 
 func (cfg apiConfig) ensureAssetsDir() error {
@@ -10,3 +14,43 @@ func (cfg apiConfig) ensureAssetsDir() error {
 	}
 	return nil
 }
+
+// writeContentAddressedAsset stores data in the assets root under a name
+// derived from its SHA-256 hash, so a changed image always gets a new URL and
+// caches never serve a stale copy. The file is written to a temporary name
+// and renamed into place, so the returned filename is never seen half-written.
+func (cfg apiConfig) writeContentAddressedAsset(data []byte, fileExtension string) (string, error) {
+	sum := sha256.Sum256(data)
+	filename := fmt.Sprintf("%s.%s", hex.EncodeToString(sum[:]), fileExtension)
+	filePath := filepath.Join(cfg.assetsRoot, filename)
+
+	// Identical content is already stored under the same name
+	if _, err := os.Stat(filePath); err == nil {
+		return filename, nil
+	}
+
+	tempFile, err := os.CreateTemp(cfg.assetsRoot, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tempFile.Name())
+
+	_, err = tempFile.Write(data)
+	if err != nil {
+		tempFile.Close()
+		return "", err
+	}
+	err = tempFile.Close()
+	if err != nil {
+		return "", err
+	}
+	err = os.Chmod(tempFile.Name(), 0644)
+	if err != nil {
+		return "", err
+	}
+	err = os.Rename(tempFile.Name(), filePath)
+	if err != nil {
+		return "", err
+	}
+	return filename, nil
+}
@@ -0,0 +1,783 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	azureAPIVersion   = "2022-11-02"
+	azureStorageScope = "https://storage.azure.com/"
+	azureIMDSEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+	azureTimeFormat   = "2006-01-02T15:04:05Z"
+	// azureMaxDelegationKeyAge is the longest a user delegation key, and
+	// so a SAS signed with it, can be valid for
+	azureMaxDelegationKeyAge = 7 * 24 * time.Hour
+	// azureClockSkew backdates SAS start times so clocks running a little
+	// behind still accept them
+	azureClockSkew = 5 * time.Minute
+)
+
+// azureStorage is objectStorage on Azure Blob Storage, with buckets as
+// containers. Requests are authorized with the account key from a
+// connection string, or with tokens for a managed identity, and presigned
+// downloads are SAS tokens signed with the account key or a user
+// delegation key. Multipart uploads stage the blocks of a block blob and
+// commit them as its block list. Azure has no browser form uploads, S3's
+// storage classes or S3's checksums.
+type azureStorage struct {
+	endpoint *url.URL
+	account  string
+	// accountKey is nil when authorizing with identity
+	accountKey []byte
+	identity   *azureManagedIdentity
+	client     *http.Client
+
+	mu            sync.Mutex
+	delegationKey *azureDelegationKey
+}
+
+// newAzureStorage uses the blob service at endpoint, authorizing with
+// accountKey if it's set and with identity if it isn't.
+func newAzureStorage(endpoint, account string, accountKey []byte, identity *azureManagedIdentity) (*azureStorage, error) {
+	endpointURL, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || endpointURL.Host == "" {
+		return nil, fmt.Errorf("invalid blob endpoint %q", endpoint)
+	}
+	if account == "" {
+		return nil, errors.New("no storage account name")
+	}
+	if accountKey == nil && identity == nil {
+		return nil, errors.New("no account key or managed identity to authorize with")
+	}
+	return &azureStorage{
+		endpoint:   endpointURL,
+		account:    account,
+		accountKey: accountKey,
+		identity:   identity,
+		client:     &http.Client{},
+	}, nil
+}
+
+// newAzureStorageFromConnectionString uses the account, key and blob
+// endpoint of a storage account connection string.
+func newAzureStorageFromConnectionString(connectionString string) (*azureStorage, error) {
+	fields := map[string]string{}
+	for _, part := range strings.Split(connectionString, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok {
+			fields[strings.ToLower(name)] = value
+		}
+	}
+	account := fields["accountname"]
+	if account == "" || fields["accountkey"] == "" {
+		return nil, errors.New("connection string must have an AccountName and AccountKey")
+	}
+	accountKey, err := base64.StdEncoding.DecodeString(fields["accountkey"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid AccountKey: %w", err)
+	}
+
+	endpoint := fields["blobendpoint"]
+	if endpoint == "" {
+		protocol := fields["defaultendpointsprotocol"]
+		if protocol == "" {
+			protocol = "https"
+		}
+		suffix := fields["endpointsuffix"]
+		if suffix == "" {
+			suffix = "core.windows.net"
+		}
+		endpoint = protocol + "://" + account + ".blob." + suffix
+	}
+	return newAzureStorage(endpoint, account, accountKey, nil)
+}
+
+// azureManagedIdentity gets tokens for the identity Azure gives the VM,
+// container or App Service the app runs on.
+type azureManagedIdentity struct {
+	endpoint string
+	// header is App Service's IDENTITY_HEADER, empty for the VM metadata
+	// service
+	header   string
+	clientID string
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// newAzureManagedIdentity gets tokens from App Service's identityEndpoint
+// if it's set, or else from the VM metadata service. clientID picks a
+// user-assigned identity over the system-assigned one.
+func newAzureManagedIdentity(clientID, identityEndpoint, identityHeader string) *azureManagedIdentity {
+	if identityEndpoint == "" {
+		return &azureManagedIdentity{endpoint: azureIMDSEndpoint, clientID: clientID}
+	}
+	return &azureManagedIdentity{endpoint: identityEndpoint, header: identityHeader, clientID: clientID}
+}
+
+// accessToken returns a token for Azure Storage, getting a new one when
+// the last has expired.
+func (m *azureManagedIdentity) accessToken(ctx context.Context, client *http.Client) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token != "" && time.Now().Before(m.expiry) {
+		return m.token, nil
+	}
+
+	query := url.Values{"resource": {azureStorageScope}}
+	if m.clientID != "" {
+		query.Set("client_id", m.clientID)
+	}
+	if m.header == "" {
+		query.Set("api-version", "2018-02-01")
+	} else {
+		query.Set("api-version", "2019-08-01")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if m.header == "" {
+		req.Header.Set("Metadata", "true")
+	} else {
+		req.Header.Set("X-IDENTITY-HEADER", m.header)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get a managed identity token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("couldn't get a managed identity token: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	// expires_on is seconds since the epoch, sent as a string
+	token := struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresOn   json.Number `json:"expires_on"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", fmt.Errorf("couldn't parse managed identity token: %w", err)
+	}
+	expiresOn, err := token.ExpiresOn.Int64()
+	if err != nil {
+		return "", fmt.Errorf("couldn't parse managed identity token expiry: %w", err)
+	}
+	// Refresh a minute early so a token doesn't expire mid-request
+	m.token, m.expiry = token.AccessToken, time.Unix(expiresOn, 0).Add(-time.Minute)
+	return m.token, nil
+}
+
+// blobURL returns the URL of a blob, of its container when blob is empty,
+// or of the blob service when both are.
+func (s *azureStorage) blobURL(container, blob string, query url.Values) *url.URL {
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + container
+	if blob != "" {
+		u.Path += "/" + blob
+	}
+	u.RawPath = ""
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// sharedKeySignature returns the Shared Key signature of a request.
+func (s *azureStorage) sharedKeySignature(req *http.Request) string {
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+	header := req.Header
+	lines := []string{
+		req.Method,
+		header.Get("Content-Encoding"),
+		header.Get("Content-Language"),
+		contentLength,
+		header.Get("Content-MD5"),
+		header.Get("Content-Type"),
+		// The date is sent as x-ms-date instead
+		"",
+		header.Get("If-Modified-Since"),
+		header.Get("If-Match"),
+		header.Get("If-None-Match"),
+		header.Get("If-Unmodified-Since"),
+		header.Get("Range"),
+	}
+
+	var names []string
+	for name := range header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-ms-") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lines = append(lines, name+":"+strings.TrimSpace(header.Get(name)))
+	}
+
+	resource := "/" + s.account + req.URL.EscapedPath()
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for name := range query {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		values := slices.Clone(query[name])
+		sort.Strings(values)
+		resource += "\n" + strings.ToLower(name) + ":" + strings.Join(values, ",")
+	}
+	lines = append(lines, resource)
+
+	mac := hmac.New(sha256.New, s.accountKey)
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// do sends an authorized request to the blob service, returning an error
+// for any response that isn't a success. Azure needs the length of every
+// body, so one of unknown length is read into memory first.
+func (s *azureStorage) do(ctx context.Context, method, container, blob string, query url.Values, header http.Header, body io.Reader, length int64) (*http.Response, error) {
+	if body != nil && length < 0 {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		body, length = bytes.NewReader(data), int64(len(data))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.blobURL(container, blob, query).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.ContentLength = length
+		if length == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	if s.accountKey != nil {
+		req.Header.Set("Authorization", "SharedKey "+s.account+":"+s.sharedKeySignature(req))
+	} else {
+		token, err := s.identity.accessToken(ctx, s.client)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, azureResponseError(method, blob, resp)
+	}
+	return resp, nil
+}
+
+// doXML sends a request like do, and decodes the XML response into v.
+func (s *azureStorage) doXML(ctx context.Context, method, container, blob string, query url.Values, header http.Header, body []byte, v any) error {
+	resp, err := s.do(ctx, method, container, blob, query, header, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	err = xml.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("couldn't parse Azure response: %w", err)
+	}
+	return nil
+}
+
+// azureResponseError returns the error the SDK would return for an S3
+// response like resp, so callers handle both the same way.
+func azureResponseError(method, blob string, resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	body := struct {
+		Code    string
+		Message string
+	}{}
+	xml.Unmarshal(data, &body)
+	code := resp.Header.Get("x-ms-error-code")
+	if code == "" {
+		code = body.Code
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound && method == http.MethodHead:
+		return storageResponseError(resp.StatusCode, &types.NotFound{})
+	case code == "BlobNotFound":
+		return noSuchKey(blob)
+	}
+	if code == "" {
+		code = resp.Status
+	}
+	if body.Message == "" {
+		body.Message = strings.TrimSpace(string(data))
+	}
+	return storageResponseError(resp.StatusCode, &smithy.GenericAPIError{Code: code, Message: body.Message})
+}
+
+// azureUnsupported checks an object isn't asked for with one of S3's
+// storage classes or checksum algorithms.
+func azureUnsupported(class types.StorageClass, algorithm types.ChecksumAlgorithm) error {
+	if class != "" && class != types.StorageClassStandard {
+		return storageResponseError(http.StatusBadRequest, fmt.Errorf("storage class %s isn't supported on Azure", class))
+	}
+	if algorithm != "" {
+		return storageResponseError(http.StatusBadRequest, fmt.Errorf("checksum algorithm %s isn't supported on Azure", algorithm))
+	}
+	return nil
+}
+
+// azureBlobHeader returns the request headers setting a blob's stored
+// headers.
+func azureBlobHeader(contentType, cacheControl, contentDisposition, contentLanguage *string) http.Header {
+	header := http.Header{}
+	for name, value := range map[string]*string{
+		"x-ms-blob-content-type":        contentType,
+		"x-ms-blob-cache-control":       cacheControl,
+		"x-ms-blob-content-disposition": contentDisposition,
+		"x-ms-blob-content-language":    contentLanguage,
+	} {
+		if aws.ToString(value) != "" {
+			header.Set(name, *value)
+		}
+	}
+	return header
+}
+
+// parseAzureBlob reads what a GET or HEAD response says about a blob.
+func parseAzureBlob(resp *http.Response) objectProperties {
+	object := parseObjectProperties(resp)
+	// Azure encrypts every blob at rest
+	if resp.Header.Get("x-ms-server-encrypted") == "true" {
+		object.serverSideEncryption = types.ServerSideEncryptionAes256
+	}
+	return object
+}
+
+func (s *azureStorage) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	header := http.Header{}
+	if params.Range != nil {
+		header.Set("Range", *params.Range)
+	}
+	resp, err := s.do(ctx, http.MethodGet, aws.ToString(params.Bucket), aws.ToString(params.Key), nil, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return parseAzureBlob(resp).getObjectOutput(resp.Body), nil
+}
+
+func (s *azureStorage) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	err := azureUnsupported(params.StorageClass, params.ChecksumAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	header := azureBlobHeader(params.ContentType, params.CacheControl, params.ContentDisposition, params.ContentLanguage)
+	header.Set("x-ms-blob-type", "BlockBlob")
+	body := params.Body
+	if body == nil {
+		body = strings.NewReader("")
+	}
+	resp, err := s.do(ctx, http.MethodPut, aws.ToString(params.Bucket), aws.ToString(params.Key), nil, header, body, requestBodyLength(body, params.ContentLength))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &s3.PutObjectOutput{ETag: optionalString(resp.Header.Get("ETag"))}, nil
+}
+
+func (s *azureStorage) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	resp, err := s.do(ctx, http.MethodHead, aws.ToString(params.Bucket), aws.ToString(params.Key), nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return parseAzureBlob(resp).headObjectOutput(), nil
+}
+
+func (s *azureStorage) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	resp, err := s.do(ctx, http.MethodDelete, aws.ToString(params.Bucket), aws.ToString(params.Key), nil, nil, nil, 0)
+	// Deleting a missing object succeeds, as it does on S3
+	var apiErr *types.NoSuchKey
+	if errors.As(err, &apiErr) {
+		return &s3.DeleteObjectOutput{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// CopyObject copies with Copy Blob, reading the source through a SAS so it
+// works however requests are authorized, and waits for copies Azure
+// finishes in the background.
+func (s *azureStorage) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	err := azureUnsupported(params.StorageClass, params.ChecksumAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	source, err := url.PathUnescape(aws.ToString(params.CopySource))
+	if err != nil {
+		return nil, storageResponseError(http.StatusBadRequest, err)
+	}
+	srcContainer, srcBlob, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	sourceURL, err := s.presignGet(ctx, srcContainer, srcBlob, time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	container, blob := aws.ToString(params.Bucket), aws.ToString(params.Key)
+	resp, err := s.do(ctx, http.MethodPut, container, blob, nil, http.Header{"X-Ms-Copy-Source": {sourceURL}}, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	for status := resp.Header.Get("x-ms-copy-status"); status != "success"; status = resp.Header.Get("x-ms-copy-status") {
+		if status != "pending" {
+			return nil, fmt.Errorf("copy of %s %s: %s", source, status, resp.Header.Get("x-ms-copy-status-description"))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+		resp, err = s.do(ctx, http.MethodHead, container, blob, nil, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+	}
+
+	// Set Blob Properties replaces all of the stored headers, the same as
+	// S3's REPLACE directive
+	if params.MetadataDirective == types.MetadataDirectiveReplace {
+		header := azureBlobHeader(params.ContentType, params.CacheControl, params.ContentDisposition, params.ContentLanguage)
+		resp, err = s.do(ctx, http.MethodPut, container, blob, url.Values{"comp": {"properties"}}, header, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+	}
+	lastModified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &s3.CopyObjectOutput{CopyObjectResult: &types.CopyObjectResult{
+		ETag:         optionalString(resp.Header.Get("ETag")),
+		LastModified: aws.Time(lastModified),
+	}}, nil
+}
+
+// azureUpload is what committing a staged block blob needs. Azure only
+// keeps the blocks, so the rest is carried in the upload ID.
+type azureUpload struct {
+	// Token prefixes the upload's block IDs, telling them apart from
+	// another upload's to the same blob
+	Token              string `json:"t"`
+	ContentType        string `json:"ct,omitempty"`
+	CacheControl       string `json:"cc,omitempty"`
+	ContentDisposition string `json:"cd,omitempty"`
+	ContentLanguage    string `json:"cl,omitempty"`
+}
+
+func (u azureUpload) id() (string, error) {
+	data, err := json.Marshal(u)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func parseAzureUploadID(uploadID string) (azureUpload, error) {
+	upload := azureUpload{}
+	data, err := base64.RawURLEncoding.DecodeString(uploadID)
+	if err == nil {
+		err = json.Unmarshal(data, &upload)
+	}
+	if err != nil || upload.Token == "" {
+		return upload, noSuchUpload(uploadID)
+	}
+	return upload, nil
+}
+
+// blockID returns the ID of a part's block. Every block ID of a blob must
+// be the same length.
+func (u azureUpload) blockID(partNumber int32) string {
+	return base64.StdEncoding.EncodeToString(fmt.Appendf(nil, "%s-%05d", u.Token, partNumber))
+}
+
+// partNumber returns the part a block ID is for, if it's one of this
+// upload's.
+func (u azureUpload) partNumber(blockID string) (int32, bool) {
+	name, err := base64.StdEncoding.DecodeString(blockID)
+	if err != nil {
+		return 0, false
+	}
+	suffix, ok := strings.CutPrefix(string(name), u.Token+"-")
+	if !ok {
+		return 0, false
+	}
+	partNumber, err := strconv.ParseInt(suffix, 10, 32)
+	return int32(partNumber), err == nil
+}
+
+func (s *azureStorage) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	err := azureUnsupported(params.StorageClass, params.ChecksumAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	uploadID, err := azureUpload{
+		Token:              rand.Text(),
+		ContentType:        aws.ToString(params.ContentType),
+		CacheControl:       aws.ToString(params.CacheControl),
+		ContentDisposition: aws.ToString(params.ContentDisposition),
+		ContentLanguage:    aws.ToString(params.ContentLanguage),
+	}.id()
+	if err != nil {
+		return nil, err
+	}
+	return &s3.CreateMultipartUploadOutput{Bucket: params.Bucket, Key: params.Key, UploadId: &uploadID}, nil
+}
+
+func (s *azureStorage) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	upload, err := parseAzureUploadID(aws.ToString(params.UploadId))
+	if err != nil {
+		return nil, err
+	}
+	blockID := upload.blockID(aws.ToInt32(params.PartNumber))
+	body := params.Body
+	if body == nil {
+		body = strings.NewReader("")
+	}
+	query := url.Values{"comp": {"block"}, "blockid": {blockID}}
+	resp, err := s.do(ctx, http.MethodPut, aws.ToString(params.Bucket), aws.ToString(params.Key), query, nil, body, requestBodyLength(body, params.ContentLength))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	// Blocks have no ETag, and are committed by ID
+	return &s3.UploadPartOutput{ETag: &blockID}, nil
+}
+
+func (s *azureStorage) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	upload, err := parseAzureUploadID(aws.ToString(params.UploadId))
+	if err != nil {
+		return nil, err
+	}
+	blockList := struct {
+		XMLName xml.Name `xml:"BlockList"`
+		Latest  []string `xml:"Latest"`
+	}{}
+	if params.MultipartUpload != nil {
+		for _, part := range params.MultipartUpload.Parts {
+			blockList.Latest = append(blockList.Latest, upload.blockID(aws.ToInt32(part.PartNumber)))
+		}
+	}
+	body, err := xml.Marshal(blockList)
+	if err != nil {
+		return nil, err
+	}
+
+	header := azureBlobHeader(optionalString(upload.ContentType), optionalString(upload.CacheControl), optionalString(upload.ContentDisposition), optionalString(upload.ContentLanguage))
+	resp, err := s.do(ctx, http.MethodPut, aws.ToString(params.Bucket), aws.ToString(params.Key), url.Values{"comp": {"blocklist"}}, header, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &s3.CompleteMultipartUploadOutput{
+		Bucket: params.Bucket,
+		Key:    params.Key,
+		ETag:   optionalString(resp.Header.Get("ETag")),
+	}, nil
+}
+
+// AbortMultipartUpload does nothing: blocks can't be removed without
+// deleting the blob, and Azure discards uncommitted blocks after a week.
+func (s *azureStorage) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	_, err := parseAzureUploadID(aws.ToString(params.UploadId))
+	if err != nil {
+		return nil, err
+	}
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+// ListParts lists the upload's uncommitted blocks, all on one page.
+func (s *azureStorage) ListParts(ctx context.Context, params *s3.ListPartsInput, optFns ...func(*s3.Options)) (*s3.ListPartsOutput, error) {
+	upload, err := parseAzureUploadID(aws.ToString(params.UploadId))
+	if err != nil {
+		return nil, err
+	}
+	output := &s3.ListPartsOutput{
+		Bucket:      params.Bucket,
+		Key:         params.Key,
+		UploadId:    params.UploadId,
+		IsTruncated: aws.Bool(false),
+	}
+	blockList := struct {
+		Blocks []struct {
+			Name string
+			Size int64
+		} `xml:"UncommittedBlocks>Block"`
+	}{}
+	query := url.Values{"comp": {"blocklist"}, "blocklisttype": {"uncommitted"}}
+	err = s.doXML(ctx, http.MethodGet, aws.ToString(params.Bucket), aws.ToString(params.Key), query, nil, nil, &blockList)
+	// A blob with no blocks yet doesn't exist
+	var apiErr *types.NoSuchKey
+	if errors.As(err, &apiErr) {
+		return output, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range blockList.Blocks {
+		partNumber, ok := upload.partNumber(block.Name)
+		if !ok {
+			continue
+		}
+		output.Parts = append(output.Parts, types.Part{
+			PartNumber: aws.Int32(partNumber),
+			ETag:       aws.String(block.Name),
+			Size:       aws.Int64(block.Size),
+		})
+	}
+	slices.SortFunc(output.Parts, func(a, b types.Part) int { return int(*a.PartNumber - *b.PartNumber) })
+	return output, nil
+}
+
+// ListMultipartUploads never lists any: Azure can't list staged blocks by
+// upload, and cleans them up itself.
+func (s *azureStorage) ListMultipartUploads(ctx context.Context, params *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	return &s3.ListMultipartUploadsOutput{Bucket: params.Bucket, IsTruncated: aws.Bool(false)}, nil
+}
+
+// azureDelegationKey is a user delegation key, which signs SAS tokens for
+// a managed identity.
+type azureDelegationKey struct {
+	SignedOid     string
+	SignedTid     string
+	SignedStart   string
+	SignedExpiry  string
+	SignedService string
+	SignedVersion string
+	Value         string
+	expiry        time.Time
+}
+
+// userDelegationKey returns a delegation key valid until at least expiry,
+// getting one valid for as long as allowed when the last isn't.
+func (s *azureStorage) userDelegationKey(ctx context.Context, expiry time.Time) (*azureDelegationKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.delegationKey != nil && !s.delegationKey.expiry.Before(expiry) {
+		return s.delegationKey, nil
+	}
+
+	now := time.Now().UTC()
+	keyExpiry := now.Add(azureMaxDelegationKeyAge)
+	if keyExpiry.Before(expiry) {
+		return nil, fmt.Errorf("SAS tokens for a managed identity can't be valid for more than %s", azureMaxDelegationKeyAge)
+	}
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"KeyInfo"`
+		Start   string
+		Expiry  string
+	}{Start: now.Add(-azureClockSkew).Format(azureTimeFormat), Expiry: keyExpiry.Format(azureTimeFormat)})
+	if err != nil {
+		return nil, err
+	}
+	key := &azureDelegationKey{expiry: keyExpiry}
+	err = s.doXML(ctx, http.MethodPost, "", "", url.Values{"restype": {"service"}, "comp": {"userdelegationkey"}}, nil, body, key)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get a user delegation key: %w", err)
+	}
+	s.delegationKey = key
+	return key, nil
+}
+
+// presignGet returns the blob's URL with a read-only SAS token, a service
+// SAS signed with the account key or a user delegation SAS signed with a
+// delegation key.
+func (s *azureStorage) presignGet(ctx context.Context, container, blob string, expires time.Duration) (string, error) {
+	now := time.Now().UTC()
+	start := now.Add(-azureClockSkew).Format(azureTimeFormat)
+	expiry := now.Add(expires)
+	query := url.Values{
+		"sp": {"r"},
+		"st": {start},
+		"se": {expiry.Format(azureTimeFormat)},
+		"sr": {"b"},
+		"sv": {azureAPIVersion},
+	}
+	protocol := ""
+	if s.endpoint.Scheme == "https" {
+		protocol = "https"
+		query.Set("spr", protocol)
+	}
+	resource := "/blob/" + s.account + "/" + container + "/" + blob
+
+	var fields []string
+	signingKey := s.accountKey
+	if s.accountKey != nil {
+		fields = []string{"r", start, query.Get("se"), resource, "", "", protocol, azureAPIVersion, "b", "", "", "", "", "", "", ""}
+	} else {
+		key, err := s.userDelegationKey(ctx, expiry)
+		if err != nil {
+			return "", err
+		}
+		signingKey, err = base64.StdEncoding.DecodeString(key.Value)
+		if err != nil {
+			return "", fmt.Errorf("invalid user delegation key: %w", err)
+		}
+		query.Set("skoid", key.SignedOid)
+		query.Set("sktid", key.SignedTid)
+		query.Set("skt", key.SignedStart)
+		query.Set("ske", key.SignedExpiry)
+		query.Set("sks", key.SignedService)
+		query.Set("skv", key.SignedVersion)
+		fields = []string{"r", start, query.Get("se"), resource,
+			key.SignedOid, key.SignedTid, key.SignedStart, key.SignedExpiry, key.SignedService, key.SignedVersion,
+			"", "", "", "", protocol, azureAPIVersion, "b", "", "", "", "", "", "", ""}
+	}
+
+	mac := hmac.New(sha256.New, signingKey)
+	mac.Write([]byte(strings.Join(fields, "\n")))
+	query.Set("sig", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return s.blobURL(container, blob, query).String(), nil
+}
+
+func (s *azureStorage) presignPost(ctx context.Context, bucket, key string, expires time.Duration, conditions []any) (*s3.PresignedPostRequest, error) {
+	return nil, errPresignedPostUnsupported
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const testAzureAccount = "tubely"
+
+var testAzureKey = []byte("test-account-key")
+
+// fakeAzure serves enough of the blob service for whole blobs and staged
+// block blobs, checking each request's Shared Key signature.
+type fakeAzure struct {
+	storage *azureStorage
+	mu      sync.Mutex
+	blobs   map[string]fakeAzureBlob
+	// blocks are the uncommitted blocks of each blob, by block ID
+	blocks map[string]map[string][]byte
+}
+
+type fakeAzureBlob struct {
+	body        []byte
+	contentType string
+}
+
+func (f *fakeAzure) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "SharedKey "+testAzureAccount+":"+f.storage.sharedKeySignature(r) {
+		w.Header().Set("x-ms-error-code", "AuthenticationFailed")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	blob, ok := f.blobs[r.URL.Path]
+	switch {
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		body, _ := io.ReadAll(r.Body)
+		if f.blocks[r.URL.Path] == nil {
+			f.blocks[r.URL.Path] = map[string][]byte{}
+		}
+		f.blocks[r.URL.Path][query.Get("blockid")] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		blockList := struct {
+			Latest []string
+		}{}
+		xml.NewDecoder(r.Body).Decode(&blockList)
+		committed := fakeAzureBlob{contentType: r.Header.Get("x-ms-blob-content-type")}
+		for _, id := range blockList.Latest {
+			committed.body = append(committed.body, f.blocks[r.URL.Path][id]...)
+		}
+		f.blobs[r.URL.Path] = committed
+		delete(f.blocks, r.URL.Path)
+		w.Header().Set("ETag", `"committed"`)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && query.Get("comp") == "blocklist":
+		if f.blocks[r.URL.Path] == nil {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("<BlockList><UncommittedBlocks>"))
+		for id, body := range f.blocks[r.URL.Path] {
+			w.Write([]byte("<Block><Name>" + id + "</Name><Size>" + strconv.Itoa(len(body)) + "</Size></Block>"))
+		}
+		w.Write([]byte("</UncommittedBlocks></BlockList>"))
+	case r.Method == http.MethodPut:
+		if r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+			http.Error(w, "missing blob type", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		f.blobs[r.URL.Path] = fakeAzureBlob{body: body, contentType: r.Header.Get("x-ms-blob-content-type")}
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusCreated)
+	case !ok:
+		w.Header().Set("x-ms-error-code", "BlobNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		w.Header().Set("Content-Type", blob.contentType)
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("x-ms-server-encrypted", "true")
+		w.Write(blob.body)
+	case r.Method == http.MethodDelete:
+		delete(f.blobs, r.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func newTestAzureStorage(t *testing.T) (*azureStorage, *fakeAzure) {
+	t.Helper()
+	fake := &fakeAzure{blobs: map[string]fakeAzureBlob{}, blocks: map[string]map[string][]byte{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	storage, err := newAzureStorage(server.URL+"/"+testAzureAccount, testAzureAccount, testAzureKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.storage = storage
+	return storage, fake
+}
+
+func TestNewAzureStorageFromConnectionString(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(testAzureKey)
+	tests := []struct {
+		connectionString string
+		wantEndpoint     string
+	}{
+		{"DefaultEndpointsProtocol=https;AccountName=tubely;AccountKey=" + key + ";EndpointSuffix=core.windows.net", "https://tubely.blob.core.windows.net"},
+		{"AccountName=tubely;AccountKey=" + key, "https://tubely.blob.core.windows.net"},
+		{"DefaultEndpointsProtocol=http;AccountName=tubely;AccountKey=" + key + ";BlobEndpoint=http://127.0.0.1:10000/tubely;", "http://127.0.0.1:10000/tubely"},
+	}
+	for _, tt := range tests {
+		storage, err := newAzureStorageFromConnectionString(tt.connectionString)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.connectionString, err)
+		}
+		if storage.endpoint.String() != tt.wantEndpoint || storage.account != "tubely" || string(storage.accountKey) != string(testAzureKey) {
+			t.Errorf("%q: got account %s at %s, want tubely at %s", tt.connectionString, storage.account, storage.endpoint, tt.wantEndpoint)
+		}
+	}
+
+	for _, connectionString := range []string{"AccountName=tubely", "AccountName=tubely;AccountKey=not base64!"} {
+		if _, err := newAzureStorageFromConnectionString(connectionString); err == nil {
+			t.Errorf("accepted connection string %q", connectionString)
+		}
+	}
+}
+
+func TestAzureStorageObjects(t *testing.T) {
+	storage, fake := newTestAzureStorage(t)
+	ctx := context.Background()
+	bucket, key := aws.String(testBucket), aws.String("videos/a video.mp4")
+
+	_, err := storage.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      bucket,
+		Key:         key,
+		Body:        strings.NewReader("video"),
+		ContentType: aws.String("video/mp4"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.blobs["/"+testAzureAccount+"/"+testBucket+"/videos/a video.mp4"]; !ok {
+		t.Fatalf("stored %v, want the video", fake.blobs)
+	}
+
+	head, err := storage.HeadObject(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToString(head.ContentType) != "video/mp4" || aws.ToInt64(head.ContentLength) != 5 || head.ServerSideEncryption != types.ServerSideEncryptionAes256 {
+		t.Errorf("head is %s with %d bytes encrypted with %q, want video/mp4 with 5 encrypted with AES256", aws.ToString(head.ContentType), aws.ToInt64(head.ContentLength), head.ServerSideEncryption)
+	}
+
+	_, err = storage.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = storage.GetObject(ctx, &s3.GetObjectInput{Bucket: bucket, Key: key})
+	var noSuchKeyErr *types.NoSuchKey
+	if !errors.As(err, &noSuchKeyErr) {
+		t.Errorf("get of a deleted blob returned %v, want NoSuchKey", err)
+	}
+	_, err = storage.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: key})
+	if err != nil {
+		t.Errorf("deleting a missing blob returned %v", err)
+	}
+
+	_, err = storage.PutObject(ctx, &s3.PutObjectInput{Bucket: bucket, Key: key, ChecksumAlgorithm: types.ChecksumAlgorithmCrc32c})
+	if err == nil {
+		t.Error("PutObject accepted an S3 checksum algorithm")
+	}
+}
+
+func TestAzureStorageMultipartUpload(t *testing.T) {
+	storage, fake := newTestAzureStorage(t)
+	ctx := context.Background()
+	bucket, key := aws.String(testBucket), aws.String("videos/large.mp4")
+
+	upload, err := storage.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: bucket, Key: key, ContentType: aws.String("video/mp4")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts, err := storage.ListParts(ctx, &s3.ListPartsInput{Bucket: bucket, Key: key, UploadId: upload.UploadId})
+	if err != nil || len(parts.Parts) != 0 {
+		t.Fatalf("listed %v (%v) before any parts were uploaded", parts, err)
+	}
+
+	completed := []types.CompletedPart{}
+	for i, body := range []string{"first ", "second"} {
+		part, err := storage.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     bucket,
+			Key:        key,
+			UploadId:   upload.UploadId,
+			PartNumber: aws.Int32(int32(i + 1)),
+			Body:       strings.NewReader(body),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		completed = append(completed, types.CompletedPart{PartNumber: aws.Int32(int32(i + 1)), ETag: part.ETag})
+	}
+	// A block from another upload to the same blob isn't one of its parts
+	fake.blocks["/"+testAzureAccount+"/"+testBucket+"/videos/large.mp4"][base64.StdEncoding.EncodeToString([]byte("other"))] = []byte("other")
+
+	parts, err = storage.ListParts(ctx, &s3.ListPartsInput{Bucket: bucket, Key: key, UploadId: upload.UploadId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts.Parts) != 2 || aws.ToInt32(parts.Parts[0].PartNumber) != 1 || aws.ToInt64(parts.Parts[1].Size) != 6 {
+		t.Fatalf("listed parts %+v, want parts 1 and 2", parts.Parts)
+	}
+
+	_, err = storage.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          bucket,
+		Key:             key,
+		UploadId:        upload.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, err := storage.GetObject(ctx, &s3.GetObjectInput{Bucket: bucket, Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(object.Body)
+	object.Body.Close()
+	if string(body) != "first second" || aws.ToString(object.ContentType) != "video/mp4" {
+		t.Errorf("got %q as %s, want the parts in order as video/mp4", body, aws.ToString(object.ContentType))
+	}
+
+	_, err = storage.UploadPart(ctx, &s3.UploadPartInput{Bucket: bucket, Key: key, UploadId: aws.String("bogus"), PartNumber: aws.Int32(1)})
+	var noSuchUploadErr *types.NoSuchUpload
+	if !errors.As(err, &noSuchUploadErr) {
+		t.Errorf("uploading to a bogus upload ID returned %v, want NoSuchUpload", err)
+	}
+}
+
+func TestAzurePresign(t *testing.T) {
+	storage, _ := newTestAzureStorage(t)
+	ctx := context.Background()
+
+	presigned, err := storage.presignGet(ctx, testBucket, "videos/a video.mp4", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(presigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("sp") != "r" || query.Get("sr") != "b" || query.Has("spr") {
+		t.Errorf("presigned query %s, want a read-only blob SAS over http", u.RawQuery)
+	}
+	stringToSign := strings.Join([]string{"r", query.Get("st"), query.Get("se"), "/blob/" + testAzureAccount + "/" + testBucket + "/videos/a video.mp4",
+		"", "", "", azureAPIVersion, "b", "", "", "", "", "", "", ""}, "\n")
+	mac := hmac.New(sha256.New, testAzureKey)
+	mac.Write([]byte(stringToSign))
+	if query.Get("sig") != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("SAS signature %s doesn't match", query.Get("sig"))
+	}
+
+	_, err = storage.presignPost(ctx, testBucket, "key", time.Hour, nil)
+	if !errors.Is(err, errPresignedPostUnsupported) {
+		t.Errorf("presignPost returned %v, want errPresignedPostUnsupported", err)
+	}
+}
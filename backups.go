@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/backup"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// Backup settings.
+const (
+	backupTimeout = time.Hour
+	// backupAssetsPage is how many asset records are read at a time for
+	// the manifest
+	backupAssetsPage = 1000
+	// backupKeyTimeFormat names backups so they sort by when they were taken
+	backupKeyTimeFormat = "20060102T150405Z"
+	backupsShown        = 50
+)
+
+// createBackup snapshots the database, archives it with the files of the
+// assets root and a manifest of the stored objects, encrypted when a
+// backup passphrase is set, uploads the archive to the backup prefix and
+// records it.
+func (cfg *apiConfig) createBackup(ctx context.Context, requestedBy string) (database.Backup, error) {
+	ctx, cancel := context.WithTimeout(ctx, backupTimeout)
+	defer cancel()
+	start := time.Now()
+	createdAt := start.UTC()
+
+	dir, err := os.MkdirTemp("", "tubely-backup-")
+	if err != nil {
+		return database.Backup{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	snapshotPath := filepath.Join(dir, backup.DatabaseName)
+	err = cfg.db.Snapshot(ctx, snapshotPath)
+	if err != nil {
+		return database.Backup{}, fmt.Errorf("couldn't snapshot database: %w", err)
+	}
+	objects, err := cfg.backupObjects()
+	if err != nil {
+		return database.Backup{}, err
+	}
+	assets, err := backupAssetFiles(cfg.assetsRoot)
+	if err != nil {
+		return database.Backup{}, fmt.Errorf("couldn't list assets: %w", err)
+	}
+
+	archivePath := filepath.Join(dir, "archive")
+	archive, err := os.Create(archivePath)
+	if err != nil {
+		return database.Backup{}, err
+	}
+	defer archive.Close()
+	w, err := backup.NewWriter(archive, cfg.backupPassphrase)
+	if err != nil {
+		return database.Backup{}, err
+	}
+	err = w.WriteManifest(backup.Manifest{CreatedAt: createdAt, Assets: len(assets), Objects: objects})
+	if err != nil {
+		return database.Backup{}, err
+	}
+	err = w.AddFile(backup.DatabaseName, snapshotPath)
+	if err != nil {
+		return database.Backup{}, err
+	}
+	for _, filename := range assets {
+		err = w.AddFile(backup.AssetsDir+filename, filepath.Join(cfg.assetsRoot, filename))
+		if err != nil {
+			return database.Backup{}, fmt.Errorf("couldn't archive asset %s: %w", filename, err)
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		return database.Backup{}, err
+	}
+
+	info, err := archive.Stat()
+	if err != nil {
+		return database.Backup{}, err
+	}
+	_, err = archive.Seek(0, 0)
+	if err != nil {
+		return database.Backup{}, err
+	}
+	encrypted := cfg.backupPassphrase != ""
+	key := cfg.backupPrefix + "tubely-" + createdAt.Format(backupKeyTimeFormat) + ".tar.gz"
+	contentType := "application/gzip"
+	if encrypted {
+		key += ".enc"
+		contentType = "application/octet-stream"
+	}
+	_, err = cfg.storage.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(cfg.backupBucket),
+		Key:           aws.String(key),
+		Body:          archive,
+		ContentLength: aws.Int64(info.Size()),
+		ContentType:   aws.String(contentType),
+	})
+	if err != nil {
+		return database.Backup{}, fmt.Errorf("couldn't upload backup: %w", err)
+	}
+
+	record, err := cfg.db.CreateBackup(createdAt, database.CreateBackupParams{
+		Bucket:      cfg.backupBucket,
+		Key:         key,
+		Size:        info.Size(),
+		Encrypted:   encrypted,
+		Assets:      len(assets),
+		Objects:     len(objects),
+		RequestedBy: requestedBy,
+	})
+	if err != nil {
+		return database.Backup{}, fmt.Errorf("couldn't record backup %s: %w", key, err)
+	}
+	logging.Infof("Backed up the database, %d assets and a manifest of %d objects to %s/%s in %s",
+		len(assets), len(objects), cfg.backupBucket, key, time.Since(start).Round(time.Millisecond))
+	if !encrypted {
+		logging.Warnf("Backup %s isn't encrypted, set BACKUP_PASSPHRASE to encrypt backups", key)
+	}
+	return record, nil
+}
+
+// backUpOnSchedule takes a backup and then prunes those past the retention
+// period. Nothing is pruned when the backup fails, so a broken backup
+// never costs the last good one.
+func (cfg *apiConfig) backUpOnSchedule() error {
+	_, err := cfg.createBackup(context.Background(), "schedule")
+	if err != nil {
+		return err
+	}
+	return cfg.pruneBackups(time.Now().Add(-cfg.backupRetention))
+}
+
+// pruneBackups deletes the backups taken before cutoff, other than the
+// newest one, and their records.
+func (cfg *apiConfig) pruneBackups(cutoff time.Time) error {
+	backups, err := cfg.db.GetExpiredBackups(cutoff)
+	if err != nil {
+		return fmt.Errorf("couldn't get expired backups: %w", err)
+	}
+	for _, expired := range backups {
+		ctx, cancel := context.WithTimeout(context.Background(), cloudRequestTimeout)
+		_, err := cfg.storage.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(expired.Bucket),
+			Key:    aws.String(expired.Key),
+		})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("couldn't delete backup %s/%s: %w", expired.Bucket, expired.Key, err)
+		}
+		err = cfg.db.DeleteBackup(expired.ID)
+		if err != nil {
+			return err
+		}
+	}
+	if len(backups) > 0 {
+		logging.Infof("Pruned %d backups taken before %s", len(backups), cutoff.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// backupObjects lists every stored object the asset records refer to.
+// Local assets are in the archive itself.
+func (cfg *apiConfig) backupObjects() ([]backup.Object, error) {
+	objects := []backup.Object{}
+	afterID := uuid.Nil
+	for {
+		assets, err := cfg.db.GetAssets(afterID, backupAssetsPage)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get assets: %w", err)
+		}
+		for _, asset := range assets {
+			if asset.Storage != database.AssetStorageS3 {
+				continue
+			}
+			bucket, key, err := parseBucketKey(asset.Location)
+			if err != nil {
+				logging.Warnf("Leaving asset %s out of the backup manifest: %v", asset.ID, err)
+				continue
+			}
+			objects = append(objects, backup.Object{
+				VideoID:      asset.VideoID,
+				Kind:         string(asset.Kind),
+				Bucket:       bucket,
+				Key:          key,
+				Size:         asset.Size,
+				StorageClass: asset.StorageClass,
+			})
+		}
+		if len(assets) < backupAssetsPage {
+			return objects, nil
+		}
+		afterID = assets[len(assets)-1].ID
+	}
+}
+
+// backupAssetFiles returns the names of the files in the assets root,
+// leaving out uploads still being written.
+func backupAssetFiles(assetsRoot string) ([]string, error) {
+	entries, err := os.ReadDir(assetsRoot)
+	if err != nil {
+		return nil, err
+	}
+	filenames := []string{}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		filenames = append(filenames, entry.Name())
+	}
+	return filenames, nil
+}
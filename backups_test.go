@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/backup"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestBackupRestore(t *testing.T) {
+	cfg, storage, _ := newTestConfig(t)
+	cfg.backupBucket, cfg.backupPrefix, cfg.backupPassphrase = testBucket, "backups/", "correct horse"
+	userID, _ := createTestUser(t, cfg, "frank@example.com")
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Demo", UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.trackAsset(video.ID, database.AssetKindOriginal, database.AssetStorageS3, testBucket+",originals/demo.mp4")
+	filename, err := cfg.writeContentAddressedAsset([]byte("WEBVTT\n"), "vtt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.trackAsset(video.ID, database.AssetKindCaption, database.AssetStorageLocal, filename)
+
+	result, err := cfg.createBackup(context.Background(), "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Encrypted || result.Assets != 1 || result.Objects != 1 {
+		t.Errorf("backup = %+v, want encrypted with 1 asset and 1 object", result)
+	}
+	object, ok := storage.object(testBucket, result.Key)
+	if !ok {
+		t.Fatalf("no backup at %s in %v", result.Key, storage.names())
+	}
+
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "restored.db")
+	assetsDir := filepath.Join(dir, "assets")
+	err = os.Mkdir(assetsDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = backup.Restore(bytes.NewReader(object.data), "", dbPath, assetsDir)
+	if !errors.Is(err, backup.ErrEncrypted) {
+		t.Errorf("restoring without the passphrase: err = %v, want %v", err, backup.ErrEncrypted)
+	}
+	_, err = backup.Restore(bytes.NewReader(object.data), "wrong", dbPath, assetsDir)
+	if !errors.Is(err, backup.ErrDecrypt) {
+		t.Errorf("restoring with the wrong passphrase: err = %v, want %v", err, backup.ErrDecrypt)
+	}
+
+	manifest, err := backup.Restore(bytes.NewReader(object.data), "correct horse", dbPath, assetsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Objects) != 1 || manifest.Objects[0].Key != "originals/demo.mp4" || manifest.Objects[0].VideoID != video.ID {
+		t.Errorf("manifest objects = %+v, want originals/demo.mp4", manifest.Objects)
+	}
+	caption, err := os.ReadFile(filepath.Join(assetsDir, filename))
+	if err != nil || string(caption) != "WEBVTT\n" {
+		t.Errorf("restored caption = %q, %v", caption, err)
+	}
+	// With a passphrase, an archive swapped in unencrypted isn't restored
+	cfg.backupPassphrase = ""
+	plain, err := cfg.createBackup(context.Background(), "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, ok = storage.object(testBucket, plain.Key)
+	if !ok {
+		t.Fatalf("no backup at %s in %v", plain.Key, storage.names())
+	}
+	_, err = backup.Restore(bytes.NewReader(object.data), "correct horse", filepath.Join(dir, "plain.db"), assetsDir)
+	if !errors.Is(err, backup.ErrNotEncrypted) {
+		t.Errorf("restoring an unencrypted backup with the passphrase: err = %v, want %v", err, backup.ErrNotEncrypted)
+	}
+
+	restored, err := database.NewClient(dbPath, database.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := restored.GetVideo(video.ID)
+	if err != nil || got.Title != "Demo" {
+		t.Errorf("restored video = %+v, %v", got, err)
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	cfg, storage, _ := newTestConfig(t)
+	now := time.Now()
+	var keys []string
+	for _, age := range []time.Duration{40, 35, 1} {
+		createdAt := now.Add(-age * 24 * time.Hour)
+		key := "backups/tubely-" + createdAt.UTC().Format(backupKeyTimeFormat) + ".tar.gz"
+		_, err := storage.PutObject(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String(testBucket),
+			Key:    aws.String(key),
+			Body:   strings.NewReader("archive"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = cfg.db.CreateBackup(createdAt, database.CreateBackupParams{Bucket: testBucket, Key: key, RequestedBy: "schedule"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+
+	err := cfg.pruneBackups(now.Add(-30 * 24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range keys {
+		_, stored := storage.object(testBucket, key)
+		if want := i == 2; stored != want {
+			t.Errorf("%s stored = %v, want %v", key, stored, want)
+		}
+	}
+
+	// The newest backup is kept however old it gets
+	err = cfg.pruneBackups(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups, err := cfg.db.GetBackups(backupsShown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 || backups[0].Key != keys[2] {
+		t.Errorf("backups left = %+v, want only %s", backups, keys[2])
+	}
+	if _, stored := storage.object(testBucket, keys[2]); !stored {
+		t.Errorf("newest backup %s was deleted", keys[2])
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// objectClass groups stored objects that share a bucket, and so a lifecycle
+// policy.
+type objectClass string
+
+const (
+	objectClassOriginal  objectClass = "originals"
+	objectClassRendition objectClass = "renditions"
+	objectClassThumbnail objectClass = "thumbnails"
+)
+
+// bucketFor returns the bucket new objects of a class are stored in. Classes
+// without a bucket of their own use S3_BUCKET. Existing objects are always
+// read from the bucket recorded alongside their key.
+func (cfg *apiConfig) bucketFor(class objectClass) string {
+	if bucket := cfg.s3ClassBuckets[class]; bucket != "" {
+		return bucket
+	}
+	return cfg.s3Bucket
+}
+
+// isBucketKey reports whether a stored URL is a "bucket,key" S3 location
+// rather than a plain URL.
+func isBucketKey(location string) bool {
+	return !strings.Contains(location, "://")
+}
+
+// uploadImageObject stores an image in the thumbnails bucket under a
+// content-addressed key below keyPrefix and returns its "bucket,key"
+// location.
+func (cfg *apiConfig) uploadImageObject(data []byte, mediaType, fileExtension, keyPrefix string) (string, error) {
+	bucket := cfg.bucketFor(objectClassThumbnail)
+	sum := sha256.Sum256(data)
+	key := fmt.Sprintf("%s/%s.%s", keyPrefix, hex.EncodeToString(sum[:]), fileExtension)
+	cacheControl := "public, max-age=31536000, immutable"
+
+	_, err := cfg.storage.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:            &bucket,
+		Key:               &key,
+		Body:              bytes.NewReader(data),
+		ContentType:       &mediaType,
+		CacheControl:      &cacheControl,
+		ChecksumAlgorithm: cfg.checksumAlgorithm,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload image %s: %w", key, err)
+	}
+	return fmt.Sprintf("%s,%s", bucket, key), nil
+}
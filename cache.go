@@ -1,10 +1,78 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"fmt"
+	"time"
 
-func noCacheMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Cache-Control", "no-store")
-		next.ServeHTTP(w, r)
-	})
+	"github.com/redis/go-redis/v9"
+)
+
+// presignCacheFraction is the part of a presigned URL's validity it's
+// reused for once cached, so clients always get at least three quarters of
+// PRESIGN_EXPIRY out of it.
+const presignCacheFraction = 4
+
+// cacheTimeout bounds each cache round trip, so a slow Redis costs a cache
+// miss rather than a slow response.
+const cacheTimeout = 200 * time.Millisecond
+
+// newRedisClient connects to the Redis server at redisURL, a URL like
+// "redis://:password@localhost:6379/0".
+func newRedisClient(redisURL string) (*redis.Client, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("couldn't connect to Redis: %w", err)
+	}
+	return client, nil
+}
+
+func presignCacheKey(bucket, key string) string {
+	return "presign:" + bucket + "/" + key
+}
+
+// presignGetURL presigns a download of an object, reusing a URL signed
+// earlier by any instance while it has plenty of time left.
+func (cfg *apiConfig) presignGetURL(client objectPresigner, bucket, key string) (string, error) {
+	if cfg.cache == nil {
+		return generatePresignedURL(client, bucket, key, cfg.presignExpiry())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cacheTimeout)
+	defer cancel()
+	cached, ok, err := cfg.cache.Get(ctx, presignCacheKey(bucket, key))
+	if err == nil && ok {
+		return string(cached), nil
+	}
+
+	expiry := cfg.presignExpiry()
+	presignedURL, err := generatePresignedURL(client, bucket, key, expiry)
+	if err != nil {
+		return "", err
+	}
+	cfg.cache.Set(ctx, presignCacheKey(bucket, key), []byte(presignedURL), expiry/presignCacheFraction)
+	return presignedURL, nil
+}
+
+// forgetPresignedURLs drops the cached URLs of a deleted object, including
+// those signed for its replicas.
+func (cfg *apiConfig) forgetPresignedURLs(bucket, key string) {
+	if cfg.cache == nil {
+		return
+	}
+	keys := []string{presignCacheKey(bucket, key)}
+	if bucket == cfg.s3Bucket {
+		for _, replica := range cfg.s3Replicas {
+			keys = append(keys, presignCacheKey(replica.bucket, key))
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cacheTimeout)
+	defer cancel()
+	cfg.cache.Delete(ctx, keys...)
 }
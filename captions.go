@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captions"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+)
+
+// transcriberOptions configures the transcriber picked by provider.
+type transcriberOptions struct {
+	Provider       string
+	WhisperBinary  string
+	WhisperModel   string
+	OpenAIBaseURL  string
+	OpenAIAPIKey   string
+	OpenAIModel    string
+	RequestTimeout time.Duration
+}
+
+// newTranscriber builds a transcriber by name: "none", "whisper_cpp" or
+// "openai". It returns nil when transcription is disabled.
+func newTranscriber(opts transcriberOptions) (captions.Transcriber, error) {
+	switch opts.Provider {
+	case "", "none":
+		return nil, nil
+	case "whisper_cpp":
+		if opts.WhisperModel == "" {
+			return nil, errors.New("WHISPER_CPP_MODEL must be set")
+		}
+		return captions.NewWhisperCPP(opts.WhisperBinary, opts.WhisperModel), nil
+	case "openai":
+		if opts.OpenAIAPIKey == "" {
+			return nil, errors.New("OPENAI_API_KEY must be set")
+		}
+		client := &http.Client{Timeout: opts.RequestTimeout}
+		return captions.NewOpenAI(client, opts.OpenAIBaseURL, opts.OpenAIAPIKey, opts.OpenAIModel), nil
+	default:
+		return nil, fmt.Errorf("unknown transcription provider %q", opts.Provider)
+	}
+}
+
+// transcribeVideo generates a caption track from the first audio track of a
+// processed video and stores it, replacing any caption previously
+// transcribed in the same language. Failures are only logged: captions are
+// optional and never hold up a video.
+func (cfg *apiConfig) transcribeVideo(video database.Video, sourcePath string) {
+	if cfg.transcriber == nil || len(video.AudioTracks) == 0 {
+		return
+	}
+
+	audioPath, err := cfg.transcoder.ExtractAudio(context.TODO(), sourcePath)
+	if err != nil {
+		logging.Warnf("Couldn't extract audio to transcribe video %s: %v", video.ID, err)
+		return
+	}
+	defer os.Remove(audioPath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+	transcript, err := cfg.transcriber.Transcribe(ctx, audioPath)
+	if err != nil {
+		logging.Warnf("Couldn't transcribe video %s: %v", video.ID, err)
+		return
+	}
+	if len(transcript.Cues) == 0 {
+		logging.Infof("No speech found in video %s", video.ID)
+		return
+	}
+
+	_, err = cfg.storeCaption(database.CreateCaptionParams{
+		VideoID:  video.ID,
+		Language: transcript.Language,
+		Source:   database.CaptionSourceTranscription,
+	}, captions.FormatVTT(transcript.Cues))
+	if err != nil {
+		logging.Warnf("Couldn't store captions for video %s: %v", video.ID, err)
+		return
+	}
+	logging.Infof("Transcribed video %s (language %q, %d cues)", video.ID, transcript.Language, len(transcript.Cues))
+}
+
+// storeCaption writes a VTT file to the assets root and records it as the
+// video's track in params.Language. A track it replaces is released.
+func (cfg *apiConfig) storeCaption(params database.CreateCaptionParams, vtt []byte) (database.Caption, error) {
+	previous, err := cfg.db.GetCaption(params.VideoID, params.Language)
+	if err != nil {
+		return database.Caption{}, err
+	}
+
+	params.Filename, err = cfg.writeContentAddressedAsset(vtt, "vtt")
+	if err != nil {
+		return database.Caption{}, err
+	}
+	cfg.trackAsset(params.VideoID, database.AssetKindCaption, database.AssetStorageLocal, params.Filename)
+
+	caption, err := cfg.db.CreateCaption(params)
+	if err != nil {
+		return database.Caption{}, err
+	}
+
+	if previous.Filename != "" && previous.Filename != caption.Filename {
+		err := cfg.releaseAsset(database.CreateAssetParams{
+			VideoID:  params.VideoID,
+			Kind:     database.AssetKindCaption,
+			Storage:  database.AssetStorageLocal,
+			Location: previous.Filename,
+		})
+		if err != nil {
+			logging.Warnf("Couldn't delete previous captions for video %s: %v", params.VideoID, err)
+		}
+	}
+	return caption, nil
+}
+
+// captionLanguage matches the language codes captions can be translated
+// into, e.g. "fr" or "pt-BR".
+var captionLanguage = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z]{2,4})?$`)
+
+// translatorOptions configures the translator picked by provider.
+type translatorOptions struct {
+	Provider          string
+	DeepLBaseURL      string
+	DeepLAPIKey       string
+	LibreTranslateURL string
+	LibreTranslateKey string
+	RequestTimeout    time.Duration
+}
+
+// newTranslator builds a translator by name: "none", "deepl" or
+// "libretranslate". It returns nil when translation is disabled.
+func newTranslator(opts translatorOptions) (captions.Translator, error) {
+	client := &http.Client{Timeout: opts.RequestTimeout}
+	switch opts.Provider {
+	case "", "none":
+		return nil, nil
+	case "deepl":
+		if opts.DeepLAPIKey == "" {
+			return nil, errors.New("DEEPL_API_KEY must be set")
+		}
+		return captions.NewDeepL(client, opts.DeepLBaseURL, opts.DeepLAPIKey), nil
+	case "libretranslate":
+		if opts.LibreTranslateURL == "" {
+			return nil, errors.New("LIBRETRANSLATE_URL must be set")
+		}
+		return captions.NewLibreTranslate(client, opts.LibreTranslateURL, opts.LibreTranslateKey), nil
+	default:
+		return nil, fmt.Errorf("unknown translation provider %q", opts.Provider)
+	}
+}
+
+// translateCaption machine-translates a caption track and stores the result
+// as the video's track in targetLanguage.
+func (cfg *apiConfig) translateCaption(ctx context.Context, source database.Caption, targetLanguage string) (database.Caption, error) {
+	vtt, err := os.ReadFile(filepath.Join(cfg.assetsRoot, source.Filename))
+	if err != nil {
+		return database.Caption{}, fmt.Errorf("couldn't read captions: %w", err)
+	}
+	cues, err := captions.ParseVTT(vtt)
+	if err != nil {
+		return database.Caption{}, fmt.Errorf("couldn't parse captions: %w", err)
+	}
+
+	translated, err := captions.TranslateCues(ctx, cfg.translator, cues, source.Language, targetLanguage)
+	if err != nil {
+		return database.Caption{}, fmt.Errorf("couldn't translate captions: %w", err)
+	}
+
+	return cfg.storeCaption(database.CreateCaptionParams{
+		VideoID:        source.VideoID,
+		Language:       targetLanguage,
+		Source:         database.CaptionSourceTranslation,
+		TranslatedFrom: source.Language,
+	}, captions.FormatVTT(translated))
+}
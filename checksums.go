@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// parseChecksumAlgorithm parses S3_CHECKSUM_ALGORITHM. "none" returns an
+// empty algorithm, leaving checksums to the SDK's defaults for requests that
+// require them.
+func parseChecksumAlgorithm(value string) (types.ChecksumAlgorithm, error) {
+	switch strings.ToLower(value) {
+	case "", "crc32c":
+		return types.ChecksumAlgorithmCrc32c, nil
+	case "sha256":
+		return types.ChecksumAlgorithmSha256, nil
+	case "none":
+		return "", nil
+	default:
+		return "", fmt.Errorf("unsupported checksum algorithm %q, expected crc32c, sha256 or none", value)
+	}
+}
+
+// multipartChecksumType is how S3 combines part checksums into the object's
+// checksum. CRC32C can be combined into a checksum of the whole object, the
+// same one a single PutObject would get; SHA-256 can only be a checksum of
+// the part checksums.
+func multipartChecksumType(algorithm types.ChecksumAlgorithm) types.ChecksumType {
+	switch algorithm {
+	case types.ChecksumAlgorithmCrc32c:
+		return types.ChecksumTypeFullObject
+	case types.ChecksumAlgorithmSha256:
+		return types.ChecksumTypeComposite
+	default:
+		return ""
+	}
+}
+
+// s3Checksums holds the checksum fields S3 returns on an object or part.
+// At most one is normally set.
+type s3Checksums struct {
+	CRC32     *string
+	CRC32C    *string
+	CRC64NVME *string
+	SHA1      *string
+	SHA256    *string
+}
+
+// format returns the checksum as "ALGORITHM:value", as stored on videos, or
+// nil if S3 didn't return one.
+func (c s3Checksums) format() *string {
+	for _, checksum := range []struct {
+		algorithm types.ChecksumAlgorithm
+		value     *string
+	}{
+		{types.ChecksumAlgorithmCrc32c, c.CRC32C},
+		{types.ChecksumAlgorithmSha256, c.SHA256},
+		{types.ChecksumAlgorithmCrc64nvme, c.CRC64NVME},
+		{types.ChecksumAlgorithmCrc32, c.CRC32},
+		{types.ChecksumAlgorithmSha1, c.SHA1},
+	} {
+		if checksum.value != nil && *checksum.value != "" {
+			formatted := fmt.Sprintf("%s:%s", checksum.algorithm, *checksum.value)
+			return &formatted
+		}
+	}
+	return nil
+}
+
+// value returns the checksum computed with algorithm, or "" if there isn't
+// one.
+func (c s3Checksums) value(algorithm types.ChecksumAlgorithm) string {
+	var value *string
+	switch algorithm {
+	case types.ChecksumAlgorithmCrc32:
+		value = c.CRC32
+	case types.ChecksumAlgorithmCrc32c:
+		value = c.CRC32C
+	case types.ChecksumAlgorithmCrc64nvme:
+		value = c.CRC64NVME
+	case types.ChecksumAlgorithmSha1:
+		value = c.SHA1
+	case types.ChecksumAlgorithmSha256:
+		value = c.SHA256
+	}
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+// completedPart builds a part for CompleteMultipartUpload, carrying its
+// checksum in the field for algorithm.
+func completedPart(partNumber int32, etag *string, algorithm types.ChecksumAlgorithm, checksum string) types.CompletedPart {
+	part := types.CompletedPart{ETag: etag, PartNumber: &partNumber}
+	if checksum == "" {
+		return part
+	}
+	switch algorithm {
+	case types.ChecksumAlgorithmCrc32c:
+		part.ChecksumCRC32C = &checksum
+	case types.ChecksumAlgorithmSha256:
+		part.ChecksumSHA256 = &checksum
+	}
+	return part
+}
+
+// headChecksums returns the checksums from a HeadObject made with
+// ChecksumMode enabled.
+func headChecksums(head *s3.HeadObjectOutput) s3Checksums {
+	return s3Checksums{
+		CRC32:     head.ChecksumCRC32,
+		CRC32C:    head.ChecksumCRC32C,
+		CRC64NVME: head.ChecksumCRC64NVME,
+		SHA1:      head.ChecksumSHA1,
+		SHA256:    head.ChecksumSHA256,
+	}
+}
+
+// storedChecksum returns the checksum S3 holds for an object, or nil if it
+// doesn't hold one.
+func (cfg *apiConfig) storedChecksum(ctx context.Context, bucket, key string) (*string, error) {
+	head, err := cfg.storage.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       &bucket,
+		Key:          &key,
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checksum of %s: %w", key, err)
+	}
+	return headChecksums(head).format(), nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// Cloud import settings.
+const (
+	// cloudLinkTTL is how long a user has to approve linking their account
+	cloudLinkTTL = 10 * time.Minute
+	// cloudRequestTimeout bounds API requests other than downloads
+	cloudRequestTimeout = 30 * time.Second
+	// cloudTokenMargin is how long before it expires an access token is
+	// refreshed, so it doesn't expire mid-request
+	cloudTokenMargin = time.Minute
+	// cloudLinkCookie holds the nonce of the link a browser started, so a
+	// link approved in another browser isn't accepted
+	cloudLinkCookie = "tubely_cloud_link"
+)
+
+// cloudServices are the cloud storage services in the order they're
+// offered.
+var cloudServices = []string{ingest.CloudGoogleDrive, ingest.CloudDropbox}
+
+// cloudServiceNames are for messages.
+var cloudServiceNames = map[string]string{
+	ingest.CloudGoogleDrive: "Google Drive",
+	ingest.CloudDropbox:     "Dropbox",
+}
+
+// cloudCallbackURL is where a service sends users back to after they
+// approve linking their account. It has to be registered with the service.
+func (cfg *apiConfig) cloudCallbackURL(service string) string {
+	return cfg.appBaseURL + "/api/linked_accounts/" + service + "/callback"
+}
+
+// cloudLinkState returns the OAuth state for a user linking an account,
+// which ties the service's callback to them and the browser holding the
+// nonce.
+func (cfg *apiConfig) cloudLinkState(userID uuid.UUID, service, nonce string, expiresAt time.Time) string {
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	return userID.String() + "." + expires + "." + cfg.signCloudLink(userID.String(), service, nonce, expires)
+}
+
+// verifyCloudLinkState returns the user a state was issued to, or false if
+// it's forged, expired, for another service or from another browser.
+func (cfg *apiConfig) verifyCloudLinkState(state, service, nonce string) (uuid.UUID, bool) {
+	parts := strings.Split(state, ".")
+	if len(parts) != 3 || nonce == "" {
+		return uuid.Nil, false
+	}
+	userID, err := uuid.Parse(parts[0])
+	if err != nil {
+		return uuid.Nil, false
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return uuid.Nil, false
+	}
+	expected := cfg.signCloudLink(parts[0], service, nonce, parts[1])
+	return userID, hmac.Equal([]byte(expected), []byte(parts[2]))
+}
+
+func (cfg *apiConfig) signCloudLink(parts ...string) string {
+	// A key derived from the JWT secret, so it isn't used for anything else
+	key := hmac.New(sha256.New, []byte(cfg.jwtSecret))
+	key.Write([]byte("tubely cloud link"))
+	mac := hmac.New(sha256.New, key.Sum(nil))
+	mac.Write([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// linkedAccountToken returns a linked account's access token, refreshing it
+// first if it's about to expire.
+func (cfg *apiConfig) linkedAccountToken(ctx context.Context, cloud ingest.Cloud, account database.LinkedAccount) (ingest.Token, error) {
+	token, err := cfg.linkedAccountTokens(account)
+	if err != nil {
+		return ingest.Token{}, err
+	}
+	if account.ExpiresAt == nil {
+		return token, nil
+	}
+	token.Expiry = *account.ExpiresAt
+	if time.Until(token.Expiry) > cloudTokenMargin {
+		return token, nil
+	}
+
+	token, err = cloud.Refresh(ctx, token)
+	if err != nil {
+		return ingest.Token{}, fmt.Errorf("couldn't refresh token: %w", err)
+	}
+	var expiresAt *time.Time
+	if !token.Expiry.IsZero() {
+		expiresAt = &token.Expiry
+	}
+	accessToken, refreshToken, err := cfg.sealCloudTokens(token)
+	if err != nil {
+		return ingest.Token{}, err
+	}
+	err = cfg.db.UpdateLinkedAccountToken(account.ID, accessToken, refreshToken, expiresAt)
+	if err != nil {
+		return ingest.Token{}, fmt.Errorf("couldn't store refreshed token: %w", err)
+	}
+	return token, nil
+}
+
+// cloudFileImportable reports whether a file in a linked account is a video
+// the pipeline accepts.
+func cloudFileImportable(file ingest.CloudFile) bool {
+	if file.Folder || file.Size == 0 {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(file.Name))
+	return slices.Contains(importableExtensions, ext) || file.MimeType == "video/mp4"
+}
+
+// importCloudFile downloads a claimed file from a linked account and queues
+// it for processing, recording the outcome on the import.
+func (cfg *apiConfig) importCloudFile(cloud ingest.Cloud, account database.LinkedAccount, file ingest.CloudFile, claimed database.Import) {
+	ctx, cancel := context.WithTimeout(context.Background(), importRunTimeout)
+	defer cancel()
+
+	open := func(ctx context.Context) (io.ReadCloser, error) {
+		// The account is read again, since it may have been unlinked or
+		// its token refreshed since the import was claimed
+		account, err := cfg.db.GetLinkedAccount(account.UserID, account.Provider)
+		if err != nil {
+			return nil, err
+		}
+		if account.ID == uuid.Nil {
+			return nil, errors.New("the account was unlinked")
+		}
+		token, err := cfg.linkedAccountToken(ctx, cloud, account)
+		if err != nil {
+			return nil, err
+		}
+		return cloud.Open(ctx, token, file.ID)
+	}
+	status, videoID, err := cfg.importFile(ctx, claimed, file.Name, open)
+	if videoID != nil {
+		// Counted like an upload, since the user asked for it
+		recordErr := cfg.db.RecordUpload(claimed.UserID, *videoID)
+		if recordErr != nil {
+			logging.Warnf("Couldn't record upload of video %s: %v", *videoID, recordErr)
+		}
+	}
+	var importErr *string
+	if err != nil {
+		logging.Warnf("Couldn't import %s: %v", claimed.URL, err)
+		msg := err.Error()
+		importErr = &msg
+	}
+	err = cfg.db.FinishImport(claimed.ID, status, videoID, importErr)
+	if err != nil {
+		logging.Errorf("Couldn't record import of %s: %v", claimed.URL, err)
+	}
+}
+
+// revokeLinkedAccounts revokes the tokens of a user's linked accounts, as
+// far as the services can be reached.
+func (cfg *apiConfig) revokeLinkedAccounts(userID uuid.UUID) {
+	accounts, err := cfg.db.GetLinkedAccounts(userID)
+	if err != nil {
+		logging.Warnf("Couldn't get linked accounts of user %s: %v", userID, err)
+		return
+	}
+	for _, account := range accounts {
+		cloud := cfg.clouds[account.Provider]
+		if cloud == nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), cloudRequestTimeout)
+		token, err := cfg.linkedAccountTokens(account)
+		if err == nil {
+			err = cloud.Revoke(ctx, token)
+		}
+		cancel()
+		if err != nil {
+			logging.Warnf("Couldn't revoke %s token of user %s: %v", account.Provider, userID, err)
+		}
+	}
+}
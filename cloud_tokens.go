@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// Linked accounts' OAuth tokens are stored sealed with AES-256-GCM, under a
+// key derived from the JWT secret, so a copy of the database or a backup of
+// it doesn't give access to users' cloud storage. Sealed tokens start with
+// sealedTokenPrefix; changing JWT_SECRET means relinking every account.
+const (
+	sealedTokenPrefix = "sealed:v1:"
+	// linkedAccountsPage is how many linked accounts are sealed at a time
+	linkedAccountsPage = 100
+)
+
+func (cfg *apiConfig) cloudTokenAEAD() (cipher.AEAD, error) {
+	// A key derived from the JWT secret, so it isn't used for anything else
+	key := hmac.New(sha256.New, []byte(cfg.jwtSecret))
+	key.Write([]byte("tubely linked account tokens"))
+	block, err := aes.NewCipher(key.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealCloudToken encrypts a token for storing. An empty token, as when a
+// service issues no refresh token, stays empty.
+func (cfg *apiConfig) sealCloudToken(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+	aead, err := cfg.cloudTokenAEAD()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(token), nil)
+	return sealedTokenPrefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// openCloudToken decrypts a stored token. Tokens stored before they were
+// sealed are returned as they are.
+func (cfg *apiConfig) openCloudToken(stored string) (string, error) {
+	encoded, ok := strings.CutPrefix(stored, sealedTokenPrefix)
+	if !ok {
+		return stored, nil
+	}
+	aead, err := cfg.cloudTokenAEAD()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("malformed sealed token")
+	}
+	token, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("couldn't open token, JWT_SECRET may have changed since the account was linked")
+	}
+	return string(token), nil
+}
+
+// sealCloudTokens encrypts both of a token's parts for storing.
+func (cfg *apiConfig) sealCloudTokens(token ingest.Token) (string, string, error) {
+	accessToken, err := cfg.sealCloudToken(token.AccessToken)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := cfg.sealCloudToken(token.RefreshToken)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+// linkedAccountTokens decrypts a linked account's stored tokens.
+func (cfg *apiConfig) linkedAccountTokens(account database.LinkedAccount) (ingest.Token, error) {
+	accessToken, err := cfg.openCloudToken(account.AccessToken)
+	if err != nil {
+		return ingest.Token{}, err
+	}
+	refreshToken, err := cfg.openCloudToken(account.RefreshToken)
+	if err != nil {
+		return ingest.Token{}, err
+	}
+	return ingest.Token{AccessToken: accessToken, RefreshToken: refreshToken}, nil
+}
+
+// sealStoredCloudTokens seals the tokens of accounts linked before tokens
+// were sealed.
+func (cfg *apiConfig) sealStoredCloudTokens() error {
+	sealed := 0
+	after := uuid.Nil
+	for {
+		accounts, err := cfg.db.GetLinkedAccountsAfter(after, linkedAccountsPage)
+		if err != nil {
+			return fmt.Errorf("couldn't get linked accounts: %w", err)
+		}
+		for _, account := range accounts {
+			if !cloudTokenUnsealed(account.AccessToken) && !cloudTokenUnsealed(account.RefreshToken) {
+				continue
+			}
+			token, err := cfg.linkedAccountTokens(account)
+			if err != nil {
+				return err
+			}
+			accessToken, refreshToken, err := cfg.sealCloudTokens(token)
+			if err != nil {
+				return err
+			}
+			err = cfg.db.UpdateLinkedAccountToken(account.ID, accessToken, refreshToken, account.ExpiresAt)
+			if err != nil {
+				return fmt.Errorf("couldn't seal tokens of linked account %s: %w", account.ID, err)
+			}
+			sealed++
+		}
+		if len(accounts) < linkedAccountsPage {
+			break
+		}
+		after = accounts[len(accounts)-1].ID
+	}
+	if sealed > 0 {
+		logging.Infof("Sealed the stored tokens of %d linked accounts", sealed)
+	}
+	return nil
+}
+
+func cloudTokenUnsealed(stored string) bool {
+	return stored != "" && !strings.HasPrefix(stored, sealedTokenPrefix)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/backup"
+)
+
+// restoreCheckTimeout bounds checking one object of a restored backup.
+const restoreCheckTimeout = 30 * time.Second
+
+// backupResult is the server's answer to a backup.
+type backupResult struct {
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	CreatedAt time.Time `json:"created_at"`
+	Size      int64     `json:"size"`
+	Encrypted bool      `json:"encrypted"`
+	Assets    int       `json:"assets"`
+	Objects   int       `json:"objects"`
+}
+
+// backup has the server back itself up and prints where the archive went.
+func (c client) backup() error {
+	var result backupResult
+	err := c.do(http.MethodPost, "/api/admin/backups", nil, &result)
+	if err != nil {
+		return err
+	}
+	encrypted := ""
+	if result.Encrypted {
+		encrypted = "encrypted "
+	}
+	fmt.Printf("Wrote %sbackup %s/%s (%d bytes) with %d assets and a manifest of %d objects\n",
+		encrypted, result.Bucket, result.Key, result.Size, result.Assets, result.Objects)
+	return nil
+}
+
+// restore rebuilds the database and assets root from a backup, the newest
+// one under BACKUP_PREFIX unless a key or file is given, then checks that
+// the objects the backup's manifest lists are still stored. It reads the
+// backup's bucket and the server's paths like the server does, and runs
+// without the server, which mustn't have the database open.
+func restore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	file := flags.String("file", "", "restore from a downloaded archive instead of S3")
+	force := flags.Bool("force", false, "replace an existing database")
+	flags.Parse(args)
+	if flags.NArg() > 1 || (flags.NArg() == 1 && *file != "") {
+		return errors.New("give at most one backup key, or --file")
+	}
+
+	dbPath := os.Getenv("DB_PATH")
+	assetsRoot := os.Getenv("ASSETS_ROOT")
+	if dbPath == "" || assetsRoot == "" {
+		return errors.New("DB_PATH and ASSETS_ROOT must be set")
+	}
+	if _, err := os.Stat(dbPath); err == nil && !*force {
+		return fmt.Errorf("%s already exists, stop the server and give --force to replace it", dbPath)
+	}
+	err := os.MkdirAll(assetsRoot, 0755)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	// gcs, azure and local storage have no S3 API to check objects with
+	checkObjects := os.Getenv("STORAGE_BACKEND") == "" || os.Getenv("STORAGE_BACKEND") == "s3"
+	var s3Client *s3.Client
+	if *file == "" || checkObjects {
+		s3Client, err = newRestoreS3Client(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	var archive io.ReadCloser
+	if *file != "" {
+		archive, err = os.Open(*file)
+		if err != nil {
+			return err
+		}
+	} else {
+		bucket, key := backupBucket(), flags.Arg(0)
+		if key == "" {
+			key, err = latestBackup(ctx, s3Client, bucket)
+			if err != nil {
+				return err
+			}
+		}
+		fmt.Printf("Restoring %s/%s\n", bucket, key)
+		output, err := s3Client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		if err != nil {
+			return fmt.Errorf("couldn't download backup: %w", err)
+		}
+		archive = output.Body
+	}
+	defer archive.Close()
+
+	manifest, err := backup.Restore(archive, os.Getenv("BACKUP_PASSPHRASE"), dbPath, assetsRoot)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored the database to %s and %d assets to %s from the backup of %s\n",
+		dbPath, manifest.Assets, assetsRoot, manifest.CreatedAt.Format(time.RFC3339))
+
+	if !checkObjects {
+		fmt.Printf("Skipped checking %d objects, since STORAGE_BACKEND isn't s3\n", len(manifest.Objects))
+		return nil
+	}
+	missing := 0
+	for _, object := range manifest.Objects {
+		checkCtx, cancel := context.WithTimeout(ctx, restoreCheckTimeout)
+		_, err := s3Client.HeadObject(checkCtx, &s3.HeadObjectInput{Bucket: aws.String(object.Bucket), Key: aws.String(object.Key)})
+		cancel()
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NotFound" || apiErr.ErrorCode() == "NoSuchKey") {
+			fmt.Printf("%s %s %s/%s: missing\n", object.VideoID, object.Kind, object.Bucket, object.Key)
+			missing++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("couldn't check %s/%s: %w", object.Bucket, object.Key, err)
+		}
+	}
+	if missing > 0 {
+		fmt.Printf("%d of %d objects are missing\n", missing, len(manifest.Objects))
+		return errFindings
+	}
+	fmt.Printf("All %d objects are stored\n", len(manifest.Objects))
+	return nil
+}
+
+// newRestoreS3Client connects to S3 with the SDK's default credentials.
+// AWS_ENDPOINT_URL_S3 points it at S3-compatible providers.
+func newRestoreS3Client(ctx context.Context) (*s3.Client, error) {
+	region := os.Getenv("S3_REGION")
+	if region == "" {
+		return nil, errors.New("S3_REGION must be set to restore from S3, or give --file")
+	}
+	pathStyle := false
+	if v := os.Getenv("S3_PATH_STYLE"); v != "" {
+		var err error
+		pathStyle, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, errors.New("S3_PATH_STYLE must be true or false")
+		}
+	}
+	sdkConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+	return s3.NewFromConfig(sdkConfig, func(o *s3.Options) {
+		o.UsePathStyle = pathStyle
+	}), nil
+}
+
+// backupBucket is where the server writes backups.
+func backupBucket() string {
+	if bucket := os.Getenv("BACKUP_BUCKET"); bucket != "" {
+		return bucket
+	}
+	return os.Getenv("S3_BUCKET")
+}
+
+// backupPrefix is the prefix the server writes backups under.
+func backupPrefix() string {
+	prefix, ok := os.LookupEnv("BACKUP_PREFIX")
+	if !ok {
+		prefix = "backups/"
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// latestBackup returns the key of the newest backup in the bucket. Backup
+// keys sort by when they were taken.
+func latestBackup(ctx context.Context, s3Client *s3.Client, bucket string) (string, error) {
+	prefix := backupPrefix()
+	keys := []string{}
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix + "tubely-"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return "", fmt.Errorf("couldn't list backups: %w", err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, aws.ToString(object.Key))
+		}
+	}
+	if len(keys) == 0 {
+		return "", fmt.Errorf("no backups under %s/%s", bucket, prefix)
+	}
+	return slices.Max(keys), nil
+}
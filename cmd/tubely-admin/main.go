@@ -0,0 +1,297 @@
+// Command tubely-admin runs maintenance tasks against a Tubely server, so
+// operators don't need one-off scripts. Tasks go through the admin API and
+// run on the server, under the same locks, cache and processing queue as
+// the work it does on its own. Its seed command fills a local server with
+// demo data through the same API the app uses, without the API key, and
+// its restore command rebuilds an instance from a backup while the server
+// is stopped.
+//
+// It reads ADMIN_API_KEY and PORT like the server does, from the
+// environment or CONFIG_FILE (.env by default). TUBELY_URL points it at a
+// server other than the one on localhost. restore reads DB_PATH,
+// ASSETS_ROOT and the backup and S3 settings the same way.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+)
+
+const usage = `Usage: tubely-admin <command> [arguments]
+
+Commands:
+  purge-orphans            delete stored objects whose video no longer exists
+  reprocess --all-failed   reprocess every video whose latest job failed
+  reprocess <videoID>...   reprocess the given videos
+  verify-integrity         check stored objects against their records, and
+                           exit with status 1 if any don't match
+  recount-usage            correct recorded sizes from what's stored, and
+                           update storage metering and quota alerts
+  abort-stale-multiparts   abort multipart uploads nothing refers to
+  seed                     create demo users with processed videos and
+                           thumbnails on a local server
+  run <job>                run any scheduled job now
+  backup                   back up the database and assets to the backup
+                           prefix, with a manifest of the stored objects
+  restore [--force] [--file <archive>] [<key>]
+                           rebuild the database and assets from the newest
+                           backup, or the given one, with the server
+                           stopped, and exit with status 1 if any objects
+                           it lists are missing
+`
+
+// requestTimeout bounds a single request. Tasks run while the request
+// waits, so it's long.
+const requestTimeout = time.Hour
+
+// errFindings makes the command exit with status 1 after it's printed
+// what it found.
+var errFindings = errors.New("found problems")
+
+type client struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+}
+
+func main() {
+	configFile := os.Getenv("CONFIG_FILE")
+	if configFile == "" {
+		configFile = ".env"
+	}
+	godotenv.Load(configFile)
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	baseURL := os.Getenv("TUBELY_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:" + os.Getenv("PORT")
+	}
+	c := client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  os.Getenv("ADMIN_API_KEY"),
+		http:    &http.Client{Timeout: requestTimeout},
+	}
+	command, args := os.Args[1], os.Args[2:]
+	// Seeding signs up and logs in as its demo users instead, and restoring
+	// runs without the server
+	if c.apiKey == "" && command != "seed" && command != "restore" {
+		fmt.Fprintln(os.Stderr, "ADMIN_API_KEY is not set")
+		os.Exit(2)
+	}
+
+	var err error
+	switch command {
+	case "purge-orphans":
+		err = c.runJob("orphaned_asset_gc")
+	case "reprocess":
+		err = c.reprocess(args)
+	case "verify-integrity":
+		err = c.verifyIntegrity()
+	case "recount-usage":
+		err = c.runJob("usage_recount")
+	case "abort-stale-multiparts":
+		err = c.runJob("stale_multipart_abort")
+	case "seed":
+		err = c.seed(args)
+	case "backup":
+		err = c.backup()
+	case "restore":
+		err = restore(args)
+	case "run":
+		if len(args) != 1 {
+			fmt.Fprint(os.Stderr, usage)
+			os.Exit(2)
+		}
+		err = c.runJob(args[0])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", command, usage)
+		os.Exit(2)
+	}
+	if errors.Is(err, errFindings) {
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", command, err)
+		os.Exit(1)
+	}
+}
+
+// runJob runs a scheduled job on the server and waits for it to finish.
+func (c client) runJob(name string) error {
+	var run database.ScheduledRun
+	err := c.do(http.MethodPost, "/api/admin/scheduled_jobs/"+name+"/run", nil, &run)
+	if err != nil {
+		return err
+	}
+	took := time.Duration(0)
+	if run.FinishedAt != nil {
+		took = run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond)
+	}
+	if run.Error != nil {
+		return fmt.Errorf("%s failed after %s on %s: %s", name, took, run.Instance, *run.Error)
+	}
+	fmt.Printf("%s finished in %s on %s\n", name, took, run.Instance)
+	return nil
+}
+
+func (c client) reprocess(args []string) error {
+	flags := flag.NewFlagSet("reprocess", flag.ExitOnError)
+	allFailed := flags.Bool("all-failed", false, "reprocess every video whose latest job failed")
+	flags.Parse(args)
+
+	if *allFailed {
+		if flags.NArg() > 0 {
+			return errors.New("give either --all-failed or video IDs, not both")
+		}
+		var resp struct {
+			Queued  []database.Job `json:"queued"`
+			Skipped []struct {
+				VideoID uuid.UUID `json:"video_id"`
+				Reason  string    `json:"reason"`
+			} `json:"skipped"`
+		}
+		err := c.do(http.MethodPost, "/api/admin/videos/reprocess_failed", nil, &resp)
+		if err != nil {
+			return err
+		}
+		for _, job := range resp.Queued {
+			fmt.Printf("queued %s as job %s\n", job.VideoID, job.ID)
+		}
+		for _, skipped := range resp.Skipped {
+			fmt.Printf("skipped %s: %s\n", skipped.VideoID, skipped.Reason)
+		}
+		fmt.Printf("%d queued, %d skipped\n", len(resp.Queued), len(resp.Skipped))
+		return nil
+	}
+
+	if flags.NArg() == 0 {
+		return errors.New("give --all-failed or at least one video ID")
+	}
+	failed := false
+	for _, arg := range flags.Args() {
+		videoID, err := uuid.Parse(arg)
+		if err != nil {
+			return fmt.Errorf("invalid video ID %q", arg)
+		}
+		var job database.Job
+		err = c.do(http.MethodPost, "/api/admin/videos/"+videoID.String()+"/reprocess", nil, &job)
+		if err != nil {
+			fmt.Printf("skipped %s: %v\n", videoID, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("queued %s as job %s\n", videoID, job.ID)
+	}
+	if failed {
+		return errFindings
+	}
+	return nil
+}
+
+// verifyIntegrity runs the storage audit and prints what it found.
+func (c client) verifyIntegrity() error {
+	err := c.runJob("storage_audit")
+	if err != nil {
+		return err
+	}
+	var findings []database.StorageAuditFinding
+	err = c.do(http.MethodGet, "/api/admin/storage_audit", nil, &findings)
+	if err != nil {
+		return err
+	}
+	for _, finding := range findings {
+		line := fmt.Sprintf("%s %s %s: %s", finding.VideoID, finding.Field, finding.Location, finding.Problem)
+		if finding.Detail != nil {
+			line += " (" + *finding.Detail + ")"
+		}
+		fmt.Println(line)
+	}
+	if len(findings) > 0 {
+		fmt.Printf("%d problems found\n", len(findings))
+		return errFindings
+	}
+	fmt.Println("Every stored object matches its record")
+	return nil
+}
+
+// apiError is an error response from the server.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// do sends an admin API request and decodes the JSON response into out.
+func (c client) do(method, path string, body any, out any) error {
+	return c.send(method, path, "ApiKey "+c.apiKey, body, out)
+}
+
+// send sends a JSON request with the given Authorization header, if any, and decodes
+// the JSON response into out, if it's not nil.
+func (c client) send(method, path, authorization string, body any, out any) error {
+	var reqBody io.Reader
+	contentType := ""
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+	return c.sendBody(method, path, authorization, contentType, reqBody, out)
+}
+
+func (c client) sendBody(method, path, authorization, contentType string, body io.Reader, out any) error {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		if errResp.Error == "" {
+			errResp.Error = resp.Status
+		}
+		return &apiError{status: resp.StatusCode, message: fmt.Sprintf("%s %s: %s", method, path, errResp.Error)}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
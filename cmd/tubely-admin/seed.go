@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// samples are tiny H.264 MP4s, 128x72 and 72x128 and four seconds long, and
+// PNG thumbnails for them.
+//
+//go:embed samples
+var samples embed.FS
+
+// Processing of a seeded video is polled until it finishes.
+const (
+	seedPollInterval = 500 * time.Millisecond
+	seedJobTimeout   = 5 * time.Minute
+)
+
+type seedVideo struct {
+	title       string
+	description string
+	file        string
+	thumbnail   string
+	public      bool
+}
+
+type seedUser struct {
+	email  string
+	videos []seedVideo
+}
+
+var seedUsers = []seedUser{
+	{
+		email: "creator@example.com",
+		videos: []seedVideo{
+			{
+				title:       "Landscape sample",
+				description: "A short landscape clip for trying out playback and the feed.",
+				file:        "samples/landscape.mp4",
+				thumbnail:   "samples/thumbnail-1.png",
+				public:      true,
+			},
+			{
+				title:       "Portrait sample",
+				description: "A short portrait clip for checking vertical playback.",
+				file:        "samples/portrait.mp4",
+				thumbnail:   "samples/thumbnail-2.png",
+				public:      true,
+			},
+			{
+				title:       "Unlisted sample",
+				description: "Only people with the link can see this one.",
+				file:        "samples/landscape.mp4",
+				thumbnail:   "samples/thumbnail-3.png",
+			},
+		},
+	},
+	{
+		email: "viewer@example.com",
+		videos: []seedVideo{
+			{
+				title:       "Viewer's first upload",
+				description: "A video from a second account, to follow and like.",
+				file:        "samples/portrait.mp4",
+				thumbnail:   "samples/thumbnail-1.png",
+				public:      true,
+			},
+		},
+	},
+}
+
+// seed signs up the demo users, or logs in as them if they already exist,
+// and uploads their videos and thumbnails. The videos go through the same
+// processing as any other upload. Videos a user already has a video with
+// the title of are skipped, so seeding again only fills in what's missing.
+func (c client) seed(args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	password := flags.String("password", "tubely-demo", "password of the demo users")
+	flags.Parse(args)
+
+	if !isLocalURL(c.baseURL) {
+		return fmt.Errorf("seeding only runs against a server on this machine, not %s", c.baseURL)
+	}
+
+	for _, user := range seedUsers {
+		token, err := c.seedLogin(user.email, *password)
+		if err != nil {
+			return err
+		}
+		existing, err := c.seedTitles(token)
+		if err != nil {
+			return err
+		}
+		for _, video := range user.videos {
+			if existing[video.title] {
+				fmt.Printf("%s already has %q\n", user.email, video.title)
+				continue
+			}
+			err := c.seedUpload(token, video)
+			if err != nil {
+				return fmt.Errorf("couldn't seed %q: %w", video.title, err)
+			}
+			fmt.Printf("seeded %q for %s\n", video.title, user.email)
+		}
+	}
+
+	emails := make([]string, len(seedUsers))
+	for i, user := range seedUsers {
+		emails[i] = user.email
+	}
+	fmt.Printf("Log in as %s with password %q\n", strings.Join(emails, " or "), *password)
+	return nil
+}
+
+// isLocalURL reports whether rawURL points at a loopback address, so demo
+// accounts with a well-known password can't end up on a shared server.
+func isLocalURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	if u.Hostname() == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
+
+// seedLogin logs in as a demo user, signing them up first if they don't
+// exist, and returns their access token.
+func (c client) seedLogin(email, password string) (string, error) {
+	credentials := map[string]string{"email": email, "password": password}
+	var login struct {
+		Token string `json:"token"`
+	}
+	err := c.send(http.MethodPost, "/api/login", "", credentials, &login)
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.status == http.StatusUnauthorized {
+		err = c.send(http.MethodPost, "/api/users", "", credentials, nil)
+		if err != nil {
+			return "", err
+		}
+		fmt.Printf("created %s\n", email)
+		err = c.send(http.MethodPost, "/api/login", "", credentials, &login)
+	}
+	if err != nil {
+		return "", err
+	}
+	return login.Token, nil
+}
+
+// seedTitles returns the titles of a user's videos.
+func (c client) seedTitles(token string) (map[string]bool, error) {
+	titles := map[string]bool{}
+	videosPath := "/api/videos"
+	for {
+		var page struct {
+			Videos     []database.Video `json:"videos"`
+			NextCursor *string          `json:"next_cursor"`
+		}
+		err := c.send(http.MethodGet, videosPath, "Bearer "+token, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, video := range page.Videos {
+			titles[video.Title] = true
+		}
+		if page.NextCursor == nil {
+			return titles, nil
+		}
+		videosPath = "/api/videos?cursor=" + url.QueryEscape(*page.NextCursor)
+	}
+}
+
+// seedUpload creates a video, uploads its file and waits for it to be
+// processed, then uploads its thumbnail and publishes it if it's public.
+func (c client) seedUpload(token string, seed seedVideo) error {
+	auth := "Bearer " + token
+	var video database.Video
+	err := c.send(http.MethodPost, "/api/videos", auth, map[string]string{
+		"title":       seed.title,
+		"description": seed.description,
+	}, &video)
+	if err != nil {
+		return err
+	}
+
+	var job database.Job
+	err = c.sendFile(http.MethodPost, "/api/video_upload/"+video.ID.String(), auth, "video", seed.file, "video/mp4", &job)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(seedJobTimeout)
+	for job.Status != database.JobStatusDone {
+		if job.Status == database.JobStatusFailed {
+			if job.Error != nil {
+				return fmt.Errorf("processing failed: %s", *job.Error)
+			}
+			return errors.New("processing failed")
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("processing didn't finish within %s", seedJobTimeout)
+		}
+		time.Sleep(seedPollInterval)
+		err = c.send(http.MethodGet, "/api/videos/"+video.ID.String()+"/status", auth, nil, &job)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.sendFile(http.MethodPost, "/api/thumbnail_upload/"+video.ID.String(), auth, "thumbnail", seed.thumbnail, "image/png", nil)
+	if err != nil {
+		return err
+	}
+	if seed.public {
+		return c.send(http.MethodPatch, "/api/videos/"+video.ID.String(), auth, map[string]string{
+			"visibility": database.VisibilityPublic,
+		}, nil)
+	}
+	return nil
+}
+
+// sendFile uploads a bundled sample as the only file of a multipart form.
+func (c client) sendFile(method, endpoint, authorization, field, name, contentType string, out any) error {
+	data, err := samples.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, field, path.Base(name)))
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	err = form.Close()
+	if err != nil {
+		return err
+	}
+	return c.sendBody(method, endpoint, authorization, form.FormDataContentType(), &body, out)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	// maxCustomMetadataBytes caps the encoded size of a video's custom
+	// metadata.
+	maxCustomMetadataBytes = 4 << 10
+	maxCustomMetadataKeys  = 50
+)
+
+// customMetadataKey matches the keys custom metadata may use. They also
+// appear in listing filters as "metadata.<key>".
+var customMetadataKey = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// mergeCustomMetadata applies a patch to a video's custom metadata: keys set
+// to null are removed, every other key is set. The result is validated.
+func mergeCustomMetadata(existing database.CustomMetadata, patch map[string]json.RawMessage) (database.CustomMetadata, error) {
+	merged := database.CustomMetadata{}
+	for key, value := range existing {
+		merged[key] = value
+	}
+
+	for key, raw := range patch {
+		if !customMetadataKey.MatchString(key) {
+			return nil, fmt.Errorf("invalid metadata key %q: use up to 64 letters, digits, '_' or '-'", key)
+		}
+		if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			delete(merged, key)
+			continue
+		}
+
+		var value any
+		err := json.Unmarshal(raw, &value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for metadata key %q: %w", key, err)
+		}
+		switch value.(type) {
+		case string, float64, bool:
+		default:
+			return nil, fmt.Errorf("metadata key %q must be a string, number or boolean", key)
+		}
+		merged[key] = value
+	}
+
+	if len(merged) > maxCustomMetadataKeys {
+		return nil, fmt.Errorf("custom metadata can have at most %d keys", maxCustomMetadataKeys)
+	}
+	dat, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+	if len(dat) > maxCustomMetadataBytes {
+		return nil, fmt.Errorf("custom metadata can be at most %d bytes", maxCustomMetadataBytes)
+	}
+	if len(merged) == 0 {
+		return nil, nil
+	}
+	return merged, nil
+}
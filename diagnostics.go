@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// startedAt is when the process started, for the uptime in runtime stats.
+var startedAt = time.Now()
+
+// registerDiagnosticsRoutes mounts net/http/pprof and the runtime stats
+// endpoint under /api/admin. pprof works out the profile from a path starting
+// with /debug/pprof/, so the /api/admin prefix is stripped before it runs.
+func (cfg *apiConfig) registerDiagnosticsRoutes(mux *http.ServeMux) {
+	profiles := http.NewServeMux()
+	profiles.HandleFunc("/debug/pprof/", pprof.Index)
+	profiles.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	profiles.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	profiles.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	profiles.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/api/admin/debug/pprof/", cfg.adminMiddleware(http.StripPrefix("/api/admin", profiles).ServeHTTP))
+
+	mux.HandleFunc("GET /api/admin/runtime", cfg.adminMiddleware(cfg.handlerAdminRuntime))
+}
+
+func (cfg *apiConfig) handlerAdminRuntime(w http.ResponseWriter, r *http.Request) {
+	type memory struct {
+		HeapAllocBytes  uint64 `json:"heap_alloc_bytes"`
+		HeapInuseBytes  uint64 `json:"heap_inuse_bytes"`
+		HeapObjects     uint64 `json:"heap_objects"`
+		StackInuseBytes uint64 `json:"stack_inuse_bytes"`
+		SysBytes        uint64 `json:"sys_bytes"`
+		TotalAllocBytes uint64 `json:"total_alloc_bytes"`
+	}
+	type gc struct {
+		Count             uint32  `json:"count"`
+		PauseTotalSeconds float64 `json:"pause_total_seconds"`
+		LastPauseSeconds  float64 `json:"last_pause_seconds"`
+		NextTargetBytes   uint64  `json:"next_target_bytes"`
+	}
+	type response struct {
+		GoVersion     string  `json:"go_version"`
+		UptimeSeconds float64 `json:"uptime_seconds"`
+		Goroutines    int     `json:"goroutines"`
+		CPUs          int     `json:"cpus"`
+		GOMAXPROCS    int     `json:"gomaxprocs"`
+		CgoCalls      int64   `json:"cgo_calls"`
+		Memory        memory  `json:"memory"`
+		GC            gc      `json:"gc"`
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	respondWithJSON(w, http.StatusOK, response{
+		GoVersion:     runtime.Version(),
+		UptimeSeconds: time.Since(startedAt).Seconds(),
+		Goroutines:    runtime.NumGoroutine(),
+		CPUs:          runtime.NumCPU(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		CgoCalls:      runtime.NumCgoCall(),
+		Memory: memory{
+			HeapAllocBytes:  stats.HeapAlloc,
+			HeapInuseBytes:  stats.HeapInuse,
+			HeapObjects:     stats.HeapObjects,
+			StackInuseBytes: stats.StackInuse,
+			SysBytes:        stats.Sys,
+			TotalAllocBytes: stats.TotalAlloc,
+		},
+		GC: gc{
+			Count:             stats.NumGC,
+			PauseTotalSeconds: time.Duration(stats.PauseTotalNs).Seconds(),
+			LastPauseSeconds:  time.Duration(stats.PauseNs[(stats.NumGC+255)%256]).Seconds(),
+			NextTargetBytes:   stats.NextGC,
+		},
+	})
+}
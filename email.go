@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mail"
+)
+
+// Email delivery limits.
+const (
+	emailQueueSize   = 100
+	emailSendTimeout = 30 * time.Second
+)
+
+const emailFooter = "\n\nYou can turn these emails off in your notification preferences.\n"
+
+// mailerOptions configures the mailer picked by provider.
+type mailerOptions struct {
+	Provider     string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	From         string
+}
+
+// newMailer builds a mailer by name: "none" or "smtp". It returns nil when
+// email is disabled.
+func newMailer(opts mailerOptions) (mail.Mailer, error) {
+	switch opts.Provider {
+	case "", "none":
+		return nil, nil
+	case "smtp":
+		if opts.SMTPHost == "" {
+			return nil, errors.New("SMTP_HOST must be set")
+		}
+		if opts.From == "" {
+			return nil, errors.New("MAIL_FROM must be set")
+		}
+		return mail.NewSMTP(opts.SMTPHost, opts.SMTPPort, opts.SMTPUsername, opts.SMTPPassword, opts.From), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", opts.Provider)
+	}
+}
+
+// emailChannel emails the user at their account's address.
+type emailChannel struct {
+	mailer mail.Mailer
+	queue  *deliveryQueue
+}
+
+func newEmailChannel(mailer mail.Mailer) emailChannel {
+	return emailChannel{mailer: mailer, queue: startDeliveryQueue("email", emailQueueSize, emailSendTimeout)}
+}
+
+func (c emailChannel) deliver(ctx context.Context, n notification) error {
+	message := mail.Message{
+		To:      n.user.Email,
+		Subject: n.subject,
+		Body:    n.body + emailFooter,
+	}
+	return c.queue.enqueue(fmt.Sprintf("%s email %s", n.event.Type, n.event.ID), func(ctx context.Context) error {
+		return c.mailer.Send(ctx, message)
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// errorCode is the machine-readable counterpart of an error message. Clients
+// should branch on the code; the message is for people and may change.
+type errorCode string
+
+const (
+	errCodeInvalidRequest    errorCode = "invalid_request"
+	errCodeInvalidParameters errorCode = "invalid_parameters"
+	errCodeUnauthorized      errorCode = "unauthorized"
+	errCodeForbidden         errorCode = "forbidden"
+	errCodeNotFound          errorCode = "not_found"
+	errCodeConflict          errorCode = "conflict"
+	errCodeGone              errorCode = "gone"
+	errCodeUnprocessable     errorCode = "unprocessable"
+	errCodeRateLimited       errorCode = "rate_limited"
+	errCodeQuotaExceeded     errorCode = "quota_exceeded"
+	errCodeInternal          errorCode = "internal_error"
+	errCodeNotImplemented    errorCode = "not_implemented"
+	errCodeUpstreamFailed    errorCode = "upstream_failed"
+
+	errCodeVideoTooLarge        errorCode = "video_too_large"
+	errCodeUnsupportedContainer errorCode = "unsupported_container"
+	errCodeUnsupportedImage     errorCode = "unsupported_image_type"
+	errCodeInvalidAudioTrack    errorCode = "invalid_audio_track"
+	errCodeProcessingFailed     errorCode = "processing_failed"
+	errCodeProcessingInProgress errorCode = "processing_in_progress"
+	errCodeStorageFailed        errorCode = "storage_failed"
+	errCodeChecksumMismatch     errorCode = "checksum_mismatch"
+	errCodeModerationRejected   errorCode = "moderation_rejected"
+	errCodeLegalHold            errorCode = "legal_hold"
+	errCodeVideoBlocked         errorCode = "video_blocked"
+
+	errCodeInvalidCredentials errorCode = "invalid_credentials"
+	errCodeShareExpired       errorCode = "share_expired"
+	errCodeShareViewsExceeded errorCode = "share_views_exceeded"
+	errCodePasswordRequired   errorCode = "password_required"
+	errCodeIncorrectPassword  errorCode = "incorrect_password"
+
+	errCodeLinkedAccountExpired errorCode = "linked_account_expired"
+)
+
+// defaultErrorCode is the code for errors that don't have a more specific one.
+func defaultErrorCode(status int) errorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return errCodeInvalidRequest
+	case http.StatusUnauthorized:
+		return errCodeUnauthorized
+	case http.StatusForbidden:
+		return errCodeForbidden
+	case http.StatusNotFound:
+		return errCodeNotFound
+	case http.StatusConflict:
+		return errCodeConflict
+	case http.StatusGone:
+		return errCodeGone
+	case http.StatusRequestEntityTooLarge:
+		return errCodeVideoTooLarge
+	case http.StatusUnprocessableEntity:
+		return errCodeUnprocessable
+	case http.StatusTooManyRequests:
+		return errCodeRateLimited
+	case http.StatusNotImplemented:
+		return errCodeNotImplemented
+	case http.StatusBadGateway:
+		return errCodeUpstreamFailed
+	}
+	return errCodeInternal
+}
+
+// jobError is an error that failed a processing job, with the code recorded
+// on the job.
+type jobError struct {
+	code errorCode
+	err  error
+}
+
+func (e *jobError) Error() string { return e.err.Error() }
+
+func (e *jobError) Unwrap() error { return e.err }
+
+// withJobErrorCode classifies err for the job it fails.
+func withJobErrorCode(code errorCode, err error) error {
+	return &jobError{code: code, err: err}
+}
+
+// jobErrorCode returns the code err was classified with, or
+// processing_failed.
+func jobErrorCode(err error) errorCode {
+	var jobErr *jobError
+	if errors.As(err, &jobErr) {
+		return jobErr.code
+	}
+	return errCodeProcessingFailed
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/errreport"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// requestIDHeader carries a request's ID. A client or proxy may set it, and
+// it's always echoed on the response so users can quote it.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds IDs taken from the client.
+const maxRequestIDLength = 128
+
+// reportingWriter carries what's known about a request to the code writing
+// its error responses, so 5XX errors can be reported with it.
+type reportingWriter struct {
+	http.ResponseWriter
+	cfg         *apiConfig
+	r           *http.Request
+	requestID   string
+	wroteHeader bool
+}
+
+func (w *reportingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *reportingWriter) WriteHeader(code int) {
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *reportingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush and Hijack pass through for the event stream and WebSocket handlers,
+// which check for them directly.
+func (w *reportingWriter) Flush() {
+	w.wroteHeader = true
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *reportingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.wroteHeader = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// tags identifies the request, and the user and video it was for when the
+// route and credentials say so. A token that doesn't validate is ignored.
+func (w *reportingWriter) tags() map[string]string {
+	tags := map[string]string{"request_id": w.requestID}
+	if videoID := w.r.PathValue("videoID"); videoID != "" {
+		tags["video_id"] = videoID
+	}
+	if token, err := auth.GetBearerToken(w.r.Header); err == nil {
+		if userID, err := auth.ValidateJWT(token, w.cfg.jwtSecret); err == nil {
+			tags["user_id"] = userID.String()
+		}
+	}
+	return tags
+}
+
+func (w *reportingWriter) report(event errreport.Event) {
+	event.Tags = w.tags()
+	event.Method = w.r.Method
+	event.URL = w.r.URL.String()
+	w.cfg.reportError(event)
+}
+
+// errorReportingMiddleware gives every request an ID, reports panics and
+// turns them into 500s when nothing was written yet. It runs inside
+// localeMiddleware so those 500s are translated.
+func (cfg *apiConfig) errorReportingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		rw := &reportingWriter{ResponseWriter: w, cfg: cfg, r: r, requestID: requestID}
+
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// The server aborts the response quietly for this one
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			pcs := make([]uintptr, 64)
+			n := runtime.Callers(3, pcs)
+			msg := fmt.Sprintf("panic: %v", recovered)
+			logging.Errorf("Request %s %s panicked: %v\n%s", r.Method, r.URL.Path, recovered, debug.Stack())
+			err, _ := recovered.(error)
+			rw.report(errreport.Event{Message: msg, Err: err, Stack: pcs[:n], Panic: true})
+			if !rw.wroteHeader {
+				respondWithJSON(rw, http.StatusInternalServerError, errorResponse{
+					Error: localize(rw, "Internal server error"),
+					Code:  errCodeInternal,
+				})
+			}
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// reportResponseError reports a 5XX error response, with what's known about
+// the request being responded to.
+func reportResponseError(w http.ResponseWriter, msg string, err error) {
+	for {
+		if rw, ok := w.(*reportingWriter); ok {
+			rw.report(errreport.Event{Message: msg, Err: err})
+			return
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = unwrapper.Unwrap()
+	}
+}
+
+// reportError sends an event to the error reporter, if one is configured.
+func (cfg *apiConfig) reportError(event errreport.Event) {
+	if cfg.errorReporter == nil {
+		return
+	}
+	cfg.errorReporter.Report(event)
+}
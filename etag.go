@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// weakETag builds a weak ETag from the parts a response depends on.
+func weakETag(parts ...any) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(hash, "%v\x00", part)
+	}
+	return fmt.Sprintf(`W/"%s"`, hex.EncodeToString(hash.Sum(nil))[:32])
+}
+
+// videoETag is the ETag of a video's metadata as shown to a viewer. The
+// presigned URLs in it are re-signed on every request, so it also changes
+// every half presign expiry: a client that revalidates keeps URLs that are
+// still good for at least that long.
+func (cfg *apiConfig) videoETag(video database.Video, isOwner bool, regionHint string) string {
+	window := max(cfg.presignExpiry()/2, time.Second)
+	var variantID string
+	if video.ThumbnailVariantID != nil {
+		variantID = video.ThumbnailVariantID.String()
+	}
+	return weakETag(video.ID, video.Version, isOwner, variantID, regionHint, time.Now().UnixNano()/int64(window))
+}
+
+// jobETag is the ETag of a processing job.
+func jobETag(job database.Job) string {
+	return weakETag(job.ID, job.Version)
+}
+
+// checkNotModified sets the response's ETag and answers 304 Not Modified if
+// the request's If-None-Match already has it. It returns false when it has
+// responded.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	// Responses depend on the caller, so only the client may cache them
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return true
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return false
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, using the
+// weak comparison RFC 9110 requires for it.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
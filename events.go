@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+)
+
+// eventPublishTimeout bounds how long publishing an event can hold up the
+// action that triggered it.
+const eventPublishTimeout = 10 * time.Second
+
+// eventBusOptions configure the event bus transport.
+type eventBusOptions struct {
+	// Transport is "inprocess" (the default), "sqs" or "nats".
+	Transport   string
+	Region      string
+	SQSQueueURL string
+	NATSURL     string
+	NATSSubject string
+}
+
+func newEventBus(opts eventBusOptions) (events.Bus, error) {
+	switch opts.Transport {
+	case "", "inprocess":
+		return events.NewInProcess(), nil
+	case "sqs":
+		if opts.SQSQueueURL == "" {
+			return nil, fmt.Errorf("the sqs event bus needs a queue URL")
+		}
+		sdkConfig, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(opts.Region))
+		if err != nil {
+			return nil, err
+		}
+		return events.NewSQS(sqs.NewFromConfig(sdkConfig), opts.SQSQueueURL), nil
+	case "nats":
+		conn, err := nats.Connect(opts.NATSURL, nats.Name("tubely"))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't connect to NATS: %w", err)
+		}
+		return events.NewNATS(conn, opts.NATSSubject), nil
+	default:
+		return nil, fmt.Errorf("unknown event bus transport %q", opts.Transport)
+	}
+}
+
+// publishEvent announces an event on the bus. Failures are only logged so
+// the action that triggered the event isn't affected.
+func (cfg *apiConfig) publishEvent(eventType string, userID uuid.UUID, data any) {
+	event, err := events.New(eventType, userID, data)
+	if err != nil {
+		logging.Warnf("Couldn't create %s event: %v", eventType, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), eventPublishTimeout)
+	defer cancel()
+	err = cfg.events.Publish(ctx, event)
+	if err != nil {
+		logging.Warnf("Couldn't publish %s event %s: %v", eventType, event.ID, err)
+	}
+}
+
+// publishVideoEvent announces an event about a video to its owner,
+// including the video's ID and title in the data.
+func (cfg *apiConfig) publishVideoEvent(videoID uuid.UUID, eventType string, data map[string]any) {
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		logging.Warnf("Couldn't get video %s for %s event: %v", videoID, eventType, err)
+		return
+	}
+	data["video_id"] = video.ID
+	data["title"] = video.Title
+	cfg.publishEvent(eventType, video.UserID, data)
+}
+
+// subscribeEventHandlers connects the features that react to events.
+func (cfg *apiConfig) subscribeEventHandlers() {
+	cfg.events.Subscribe(cfg.notifier.handleEvent)
+	cfg.events.Subscribe(cfg.handleVideoPublished)
+	cfg.events.Subscribe(cfg.handleStorageChanged)
+}
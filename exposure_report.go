@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// defaultPresignedWithin is how far back the exposure report looks for
+// presigned URLs unless presigned_within says otherwise.
+const defaultPresignedWithin = 24 * time.Hour
+
+// exposedVideo is how one video can be reached by people other than its
+// owner.
+type exposedVideo struct {
+	ID             uuid.UUID  `json:"id"`
+	UserID         uuid.UUID  `json:"user_id"`
+	OrganizationID *uuid.UUID `json:"organization_id"`
+	Visibility     string     `json:"visibility"`
+	// Held videos are hidden from everyone but their owner
+	ModerationStatus string `json:"moderation_status"`
+	ActiveShares     int64  `json:"active_shares"`
+	// LastPresignedAt is only set if it's within the report's window
+	LastPresignedAt *time.Time `json:"last_presigned_at"`
+	// UnencryptedObjects are the fields whose objects the last storage audit
+	// found unencrypted, e.g. "video_url"
+	UnencryptedObjects []string `json:"unencrypted_objects"`
+}
+
+type exposureSummary struct {
+	Videos             int `json:"videos"`
+	Public             int `json:"public"`
+	Unlisted           int `json:"unlisted"`
+	WithActiveShares   int `json:"with_active_shares"`
+	RecentlyPresigned  int `json:"recently_presigned"`
+	UnencryptedObjects int `json:"unencrypted_objects"`
+}
+
+type exposureReport struct {
+	GeneratedAt    time.Time `json:"generated_at"`
+	PresignedSince time.Time `json:"presigned_since"`
+	// EncryptionCheckedAt is when the storage audit the encryption findings
+	// come from finished, or nil if it hasn't completed yet
+	EncryptionCheckedAt *time.Time      `json:"encryption_checked_at"`
+	Summary             exposureSummary `json:"summary"`
+	Videos              []exposedVideo  `json:"videos"`
+}
+
+// handlerAdminExposureReport gathers, for every video, what exposes it:
+// its visibility, working share links, recently presigned URLs and stored
+// objects without encryption at rest. Encryption isn't checked here; it
+// comes from the latest storage audit.
+func (cfg *apiConfig) handlerAdminExposureReport(w http.ResponseWriter, r *http.Request) {
+	presignedWithin := defaultPresignedWithin
+	if value := r.URL.Query().Get("presigned_within"); value != "" {
+		seconds, _ := strconv.ParseFloat(value, 64)
+		presignedWithin = time.Duration(seconds * float64(time.Second))
+	}
+
+	now := time.Now().UTC()
+	report := exposureReport{
+		GeneratedAt:    now,
+		PresignedSince: now.Add(-presignedWithin),
+		Videos:         []exposedVideo{},
+	}
+
+	shareCounts, err := cfg.db.GetActiveShareCounts(now)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get shares", err)
+		return
+	}
+	presignedAt, err := cfg.db.GetVideosPresignedSince(report.PresignedSince)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get presigned URL history", err)
+		return
+	}
+	findings, err := cfg.db.GetStorageAuditFindings()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage audit findings", err)
+		return
+	}
+	unencrypted := map[uuid.UUID][]string{}
+	for _, finding := range findings {
+		if finding.Problem == database.StorageProblemUnencrypted {
+			unencrypted[finding.VideoID] = append(unencrypted[finding.VideoID], finding.Field)
+		}
+	}
+	runs, err := cfg.db.GetScheduledRuns("storage_audit", scheduledRunsShown)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get scheduled runs", err)
+		return
+	}
+	for _, run := range runs {
+		if run.FinishedAt != nil && run.Error == nil {
+			report.EncryptionCheckedAt = run.FinishedAt
+			break
+		}
+	}
+
+	after := uuid.Nil
+	for {
+		videos, err := cfg.db.GetVideosAfter(after, storageAuditBatchSize)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+			return
+		}
+		for _, video := range videos {
+			exposed := exposedVideo{
+				ID:                 video.ID,
+				UserID:             video.UserID,
+				OrganizationID:     video.OrganizationID,
+				Visibility:         video.Visibility,
+				ModerationStatus:   video.ModerationStatus,
+				ActiveShares:       shareCounts[video.ID],
+				UnencryptedObjects: unencrypted[video.ID],
+			}
+			if exposed.UnencryptedObjects == nil {
+				exposed.UnencryptedObjects = []string{}
+			}
+			if at, ok := presignedAt[video.ID]; ok {
+				exposed.LastPresignedAt = &at
+			}
+			report.Videos = append(report.Videos, exposed)
+
+			report.Summary.Videos++
+			switch video.Visibility {
+			case database.VisibilityPublic:
+				report.Summary.Public++
+			case database.VisibilityUnlisted:
+				report.Summary.Unlisted++
+			}
+			if exposed.ActiveShares > 0 {
+				report.Summary.WithActiveShares++
+			}
+			if exposed.LastPresignedAt != nil {
+				report.Summary.RecentlyPresigned++
+			}
+			report.Summary.UnencryptedObjects += len(exposed.UnencryptedObjects)
+		}
+		if len(videos) < storageAuditBatchSize {
+			break
+		}
+		after = videos[len(videos)-1].ID
+	}
+
+	respondWithJSON(w, http.StatusOK, report)
+}
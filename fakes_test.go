@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/lock"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/google/uuid"
+)
+
+// memoryObject is an object held by memoryStorage.
+type memoryObject struct {
+	data        []byte
+	contentType string
+}
+
+// memoryUpload is a multipart upload in progress in memoryStorage.
+type memoryUpload struct {
+	bucket, key string
+	contentType string
+	parts       map[int32][]byte
+}
+
+// memoryStorage is objectStorage in memory. Calls to the methods it doesn't
+// implement panic, through the nil embedded interface.
+type memoryStorage struct {
+	objectStorage
+
+	mu      sync.Mutex
+	objects map[string]memoryObject
+	uploads map[string]*memoryUpload
+}
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{objects: map[string]memoryObject{}, uploads: map[string]*memoryUpload{}}
+}
+
+func memoryObjectName(bucket, key *string) string {
+	return aws.ToString(bucket) + "/" + aws.ToString(key)
+}
+
+// object returns the object stored under bucket and key.
+func (m *memoryStorage) object(bucket, key string) (memoryObject, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	object, ok := m.objects[memoryObjectName(&bucket, &key)]
+	return object, ok
+}
+
+// names lists the stored objects as "bucket/key", sorted.
+func (m *memoryStorage) names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.objects))
+	for name := range m.objects {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (m *memoryStorage) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[memoryObjectName(params.Bucket, params.Key)] = memoryObject{data: data, contentType: aws.ToString(params.ContentType)}
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *memoryStorage) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	object, ok := m.objects[memoryObjectName(params.Bucket, params.Key)]
+	if !ok {
+		return nil, storageResponseError(http.StatusNotFound, &types.NoSuchKey{})
+	}
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(object.data)),
+		ContentLength: aws.Int64(int64(len(object.data))),
+		ContentType:   optionalString(object.contentType),
+	}, nil
+}
+
+func (m *memoryStorage) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	object, ok := m.objects[memoryObjectName(params.Bucket, params.Key)]
+	if !ok {
+		return nil, storageResponseError(http.StatusNotFound, &types.NotFound{})
+	}
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(object.data))),
+		ContentType:   optionalString(object.contentType),
+	}, nil
+}
+
+func (m *memoryStorage) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, memoryObjectName(params.Bucket, params.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *memoryStorage) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	uploadID := uuid.NewString()
+	m.uploads[uploadID] = &memoryUpload{
+		bucket:      aws.ToString(params.Bucket),
+		key:         aws.ToString(params.Key),
+		contentType: aws.ToString(params.ContentType),
+		parts:       map[int32][]byte{},
+	}
+	return &s3.CreateMultipartUploadOutput{UploadId: &uploadID}, nil
+}
+
+func (m *memoryStorage) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	data, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, ok := m.uploads[aws.ToString(params.UploadId)]
+	if !ok {
+		return nil, storageResponseError(http.StatusNotFound, &types.NoSuchUpload{})
+	}
+	upload.parts[aws.ToInt32(params.PartNumber)] = data
+	etag := fmt.Sprintf(`"%d"`, aws.ToInt32(params.PartNumber))
+	return &s3.UploadPartOutput{ETag: &etag}, nil
+}
+
+func (m *memoryStorage) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	upload, ok := m.uploads[aws.ToString(params.UploadId)]
+	if !ok {
+		return nil, storageResponseError(http.StatusNotFound, &types.NoSuchUpload{})
+	}
+	var data []byte
+	for _, part := range params.MultipartUpload.Parts {
+		partData, ok := upload.parts[aws.ToInt32(part.PartNumber)]
+		if !ok {
+			return nil, storageResponseError(http.StatusBadRequest, &types.InvalidObjectState{})
+		}
+		data = append(data, partData...)
+	}
+	delete(m.uploads, aws.ToString(params.UploadId))
+	m.objects[upload.bucket+"/"+upload.key] = memoryObject{data: data, contentType: upload.contentType}
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (m *memoryStorage) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.uploads, aws.ToString(params.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (m *memoryStorage) presignGet(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
+	return fmt.Sprintf("https://storage.test/%s/%s?expires=%d", bucket, key, int(expires.Seconds())), nil
+}
+
+func (m *memoryStorage) presignPost(ctx context.Context, bucket, key string, expires time.Duration, conditions []any) (*s3.PresignedPostRequest, error) {
+	return &s3.PresignedPostRequest{
+		URL:    "https://storage.test/" + bucket,
+		Values: map[string]string{"key": key, "policy": "test-policy"},
+	}, nil
+}
+
+// stubMedia is media.Prober and media.Transcoder without ffmpeg. Every file
+// probes as result, and remuxing copies the file unchanged.
+type stubMedia struct {
+	result media.ProbeResult
+
+	mu     sync.Mutex
+	probed []string
+}
+
+// newStubMedia probes every file as a four second 1920x1080 H.264 video
+// with one AAC track.
+func newStubMedia() *stubMedia {
+	var result media.ProbeResult
+	result.Streams = []media.Stream{
+		{Index: 0, CodecType: "video", CodecName: "h264", Width: 1920, Height: 1080},
+		{Index: 1, CodecType: "audio", CodecName: "aac", Channels: 2},
+	}
+	result.Format.Duration = "4.000000"
+	return &stubMedia{result: result}
+}
+
+func (s *stubMedia) Probe(ctx context.Context, path string) (media.ProbeResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.probed = append(s.probed, path)
+	return s.result, nil
+}
+
+func (s *stubMedia) FastStart(ctx context.Context, path string, audioTracks []int, duration time.Duration, onProgress func(percent int)) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	outputPath := path + ".processing"
+	err = os.WriteFile(outputPath, data, 0o600)
+	if err != nil {
+		return "", err
+	}
+	if onProgress != nil {
+		onProgress(100)
+	}
+	return outputPath, nil
+}
+
+func (s *stubMedia) ExtractFrame(ctx context.Context, path string, offset time.Duration, outputPath string) error {
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return jpeg.Encode(file, image.NewRGBA(image.Rect(0, 0, 16, 9)), nil)
+}
+
+func (s *stubMedia) ExtractAudio(ctx context.Context, path string) (string, error) {
+	return "", fmt.Errorf("stub media has no audio to extract")
+}
+
+// testBucket is the bucket of the configs made by newTestConfig.
+const testBucket = "tubely-test"
+
+// newTestConfig returns a config backed by a fresh database, memoryStorage
+// and stubMedia, without workers or background jobs. Jobs are run by
+// calling runJob.
+func newTestConfig(t *testing.T) (*apiConfig, *memoryStorage, *stubMedia) {
+	t.Helper()
+	dir := t.TempDir()
+	// Uploads are written to temporary files until they're processed
+	t.Setenv("TMPDIR", dir)
+
+	db, err := database.NewClient(filepath.Join(dir, "tubely.db"), database.Options{})
+	if err != nil {
+		t.Fatalf("couldn't create database: %v", err)
+	}
+	storage := newMemoryStorage()
+	stub := newStubMedia()
+	cfg := &apiConfig{
+		db:                  db,
+		jwtSecret:           "test-secret",
+		platform:            "dev",
+		assetsRoot:          filepath.Join(dir, "assets"),
+		s3Bucket:            testBucket,
+		s3Region:            "us-east-2",
+		port:                "8091",
+		storageBackend:      storageBackendS3,
+		storage:             storage,
+		prober:              stub,
+		transcoder:          stub,
+		jobQueue:            make(chan uuid.UUID, 16),
+		settings:            &runtimeSettings{},
+		moderator:           moderation.Noop{},
+		thumbnailClassifier: moderation.Noop{},
+		events:              events.NewInProcess(),
+		notifications:       newNotificationHub(),
+		appBaseURL:          "http://localhost:8091",
+		s3Provider:          s3Providers[0],
+		s3ClassBuckets:      map[objectClass]string{},
+		uploadPartSize:      minUploadPartSize,
+		uploadConcurrency:   1,
+
+		thumbnailJPEGQuality: 85,
+
+		locks:    lock.NewLocal(),
+		features: newFeatureFlags(db, nil),
+	}
+	cfg.settings.presignExpiry.Store(int64(time.Hour))
+	err = cfg.ensureAssetsDir()
+	if err != nil {
+		t.Fatalf("couldn't create assets directory: %v", err)
+	}
+	return cfg, storage, stub
+}
+
+// createTestUser signs up a user and returns their ID and an access token.
+func createTestUser(t *testing.T, cfg *apiConfig, email string) (uuid.UUID, string) {
+	t.Helper()
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: email, Password: "password"})
+	if err != nil {
+		t.Fatalf("couldn't create user: %v", err)
+	}
+	token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatalf("couldn't make token: %v", err)
+	}
+	return user.ID, token
+}
+
+// createTestVideo creates a video owned by userID.
+func createTestVideo(t *testing.T, cfg *apiConfig, userID uuid.UUID) database.Video {
+	t.Helper()
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Test video", Description: "A video", UserID: userID})
+	if err != nil {
+		t.Fatalf("couldn't create video: %v", err)
+	}
+	return video
+}
+
+// newUploadRequest builds a POST of a multipart form with a single file,
+// authorized with token and with the videoID path value set.
+func newUploadRequest(t *testing.T, target, token string, videoID uuid.UUID, field, filename, contentType string, data []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, field, filename))
+	header.Set("Content-Type", contentType)
+	part, err := form.CreatePart(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.Close()
+
+	r, err := http.NewRequest(http.MethodPost, target, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.SetPathValue("videoID", videoID.String())
+	return r
+}
+
+// hasPrefixIn reports whether any name starts with prefix.
+func hasPrefixIn(names []string, prefix string) bool {
+	return slices.ContainsFunc(names, func(name string) bool {
+		return strings.HasPrefix(name, prefix)
+	})
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// knownFeatures are the features being rolled out behind flags. A feature
+// is added here along with the code that checks cfg.features.enabled for
+// it, and removed once it's fully rolled out.
+var knownFeatures = []string{}
+
+// featureFlagRefreshInterval is how long flags are used before being read
+// from the database again, so a change made on another instance applies
+// within about that long.
+const featureFlagRefreshInterval = 10 * time.Second
+
+// Where a flag's effective setting comes from
+const (
+	featureSourceDatabase = "database"
+	featureSourceConfig   = "config"
+	featureSourceDefault  = "default"
+)
+
+// featureFlags decides which features are on for a user. Flags stored in
+// the database take precedence over the defaults from FEATURE_FLAGS; a flag
+// in neither is off.
+type featureFlags struct {
+	db       database.Client
+	defaults map[string]database.FeatureFlag
+
+	mu       sync.Mutex
+	stored   map[string]database.FeatureFlag
+	loadedAt time.Time
+}
+
+func newFeatureFlags(db database.Client, defaults map[string]database.FeatureFlag) *featureFlags {
+	return &featureFlags{db: db, defaults: defaults}
+}
+
+// parseFeatureFlagDefaults parses FEATURE_FLAGS, a comma-separated list of
+// "feature=setting" pairs where the setting is on, off or a rollout
+// percentage.
+func parseFeatureFlagDefaults(value string) (map[string]database.FeatureFlag, error) {
+	defaults := map[string]database.FeatureFlag{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, setting, ok := strings.Cut(entry, "=")
+		name, setting = strings.TrimSpace(name), strings.TrimSpace(setting)
+		if !ok {
+			return nil, fmt.Errorf("invalid flag %q, expected feature=setting", entry)
+		}
+		if !slices.Contains(knownFeatures, name) {
+			return nil, fmt.Errorf("unknown feature %q", name)
+		}
+		flag := database.FeatureFlag{Name: name, UserIDs: database.UUIDList{}}
+		switch setting {
+		case "on":
+			flag.Enabled, flag.Percentage = true, 100
+		case "off":
+		default:
+			percentage, err := strconv.Atoi(strings.TrimSuffix(setting, "%"))
+			if err != nil || percentage < 0 || percentage > 100 {
+				return nil, fmt.Errorf("invalid setting for %s, expected on, off or a percentage", name)
+			}
+			flag.Enabled, flag.Percentage = true, percentage
+		}
+		defaults[name] = flag
+	}
+	return defaults, nil
+}
+
+// storedFlags returns the flags in the database, re-reading them once
+// they're older than featureFlagRefreshInterval. If that fails the previous
+// ones are kept.
+func (f *featureFlags) storedFlags() map[string]database.FeatureFlag {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stored != nil && time.Since(f.loadedAt) < featureFlagRefreshInterval {
+		return f.stored
+	}
+	flags, err := f.db.GetFeatureFlags()
+	if err != nil {
+		logging.Warnf("Couldn't load feature flags: %v", err)
+		if f.stored == nil {
+			return map[string]database.FeatureFlag{}
+		}
+		return f.stored
+	}
+	f.stored = map[string]database.FeatureFlag{}
+	for _, flag := range flags {
+		f.stored[flag.Name] = flag
+	}
+	f.loadedAt = time.Now()
+	return f.stored
+}
+
+// invalidate makes the next check re-read the database, after a flag on
+// this instance was changed.
+func (f *featureFlags) invalidate() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stored = nil
+}
+
+// flag returns a feature's effective setting and where it comes from.
+func (f *featureFlags) flag(name string) (database.FeatureFlag, string) {
+	if flag, ok := f.storedFlags()[name]; ok {
+		return flag, featureSourceDatabase
+	}
+	if flag, ok := f.defaults[name]; ok {
+		return flag, featureSourceConfig
+	}
+	return database.FeatureFlag{Name: name, UserIDs: database.UUIDList{}}, featureSourceDefault
+}
+
+func (f *featureFlags) enabled(name string, userID uuid.UUID) bool {
+	flag, _ := f.flag(name)
+	if !flag.Enabled {
+		return false
+	}
+	if slices.Contains(flag.UserIDs, userID) {
+		return true
+	}
+	return featureBucket(name, userID) < flag.Percentage
+}
+
+// enabledFor lists the features on for a user.
+func (f *featureFlags) enabledFor(userID uuid.UUID) []string {
+	features := []string{}
+	for _, name := range knownFeatures {
+		if f.enabled(name, userID) {
+			features = append(features, name)
+		}
+	}
+	return features
+}
+
+// featureBucket places a user in one of 100 buckets for a feature. Raising
+// the percentage only ever adds users, and each feature buckets users
+// independently.
+func featureBucket(name string, userID uuid.UUID) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write([]byte{':'})
+	h.Write(userID[:])
+	return int(h.Sum32() % 100)
+}
@@ -0,0 +1,730 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	gcsDefaultEndpoint = "https://storage.googleapis.com"
+	gcsDefaultTokenURI = "https://oauth2.googleapis.com/token"
+	gcsScope           = "https://www.googleapis.com/auth/devstorage.read_write"
+	gcsSigningAlgo     = "GOOG4-RSA-SHA256"
+	// gcsMaxSignedExpiry is the longest a V4 signature can be valid for
+	gcsMaxSignedExpiry = 7 * 24 * time.Hour
+)
+
+// gcsStorage is objectStorage on Google Cloud Storage. It talks to the XML
+// API, whose multipart uploads work like S3's, with OAuth tokens for a
+// service account, and presigns V4 signed URLs and POST policies with the
+// service account's key. GCS has none of S3's storage classes, and
+// checksums every object with CRC32C, which is reported the way S3 reports
+// a full object CRC32C checksum.
+type gcsStorage struct {
+	endpoint    *url.URL
+	client      *http.Client
+	clientEmail string
+	tokenURI    string
+	key         *rsa.PrivateKey
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// gcsServiceAccount is the part of a service account key file that's used.
+type gcsServiceAccount struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+// newGCSStorage authenticates as the service account in the key file at
+// credentialsPath. endpoint is the XML API, GCS's own when it's empty.
+func newGCSStorage(credentialsPath, endpoint string) (*gcsStorage, error) {
+	data, err := os.ReadFile(credentialsPath)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read credentials: %w", err)
+	}
+	account := gcsServiceAccount{}
+	err = json.Unmarshal(data, &account)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse credentials: %w", err)
+	}
+	if account.Type != "service_account" || account.ClientEmail == "" {
+		return nil, errors.New("credentials must be a service account key, which signed URLs need")
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse service account key: %w", err)
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = gcsDefaultTokenURI
+	}
+
+	if endpoint == "" {
+		endpoint = gcsDefaultEndpoint
+	}
+	endpointURL, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || endpointURL.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", endpoint)
+	}
+	return &gcsStorage{
+		endpoint:    endpointURL,
+		client:      &http.Client{},
+		clientEmail: account.ClientEmail,
+		tokenURI:    account.TokenURI,
+		key:         key,
+	}, nil
+}
+
+// accessToken returns an OAuth token for the service account, exchanging a
+// signed JWT for a new one when the last has expired.
+func (s *gcsStorage) accessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Now().Before(s.tokenExpiry) {
+		return s.token, nil
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   s.clientEmail,
+		"scope": gcsScope,
+		"aud":   s.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(s.key)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("couldn't get an access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("couldn't get an access token: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	token := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", fmt.Errorf("couldn't parse access token: %w", err)
+	}
+	// Refresh a minute early so a token doesn't expire mid-request
+	s.token = token.AccessToken
+	s.tokenExpiry = now.Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return s.token, nil
+}
+
+// gcsEscape percent-encodes everything but unreserved characters, and
+// slashes if keepSlash is set, the way V4 signatures expect.
+func gcsEscape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', keepSlash && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// gcsQuery encodes query sorted by name, as V4 signatures expect.
+func gcsQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, gcsEscape(name, false)+"="+gcsEscape(value, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// objectURL returns the XML API URL of an object, or of the bucket when key
+// is empty.
+func (s *gcsStorage) objectURL(bucket, key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	rawPath := u.EscapedPath() + "/" + gcsEscape(bucket, false)
+	u.Path += "/" + bucket
+	if key != "" {
+		rawPath += "/" + gcsEscape(key, true)
+		u.Path += "/" + key
+	}
+	u.RawPath = rawPath
+	u.RawQuery = gcsQuery(query)
+	return &u
+}
+
+// do sends an authorized request to the XML API, returning an error for
+// any response that isn't a success. A length of -1 sends body chunked.
+func (s *gcsStorage) do(ctx context.Context, method, bucket, key string, query url.Values, header http.Header, body io.Reader, length int64) (*http.Response, error) {
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(bucket, key, query).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.ContentLength = length
+		if length == 0 {
+			req.Body = http.NoBody
+		}
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, gcsResponseError(method, key, resp)
+	}
+	return resp, nil
+}
+
+// doXML sends a request like do, and decodes the XML response into v.
+func (s *gcsStorage) doXML(ctx context.Context, method, bucket, key string, query url.Values, header http.Header, body []byte, v any) error {
+	resp, err := s.do(ctx, method, bucket, key, query, header, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	err = xml.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("couldn't parse GCS response: %w", err)
+	}
+	return nil
+}
+
+// gcsResponseError returns the error the SDK would return for an S3
+// response like resp, so callers handle both the same way.
+func gcsResponseError(method, key string, resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	body := struct {
+		Code    string
+		Message string
+	}{}
+	xml.Unmarshal(data, &body)
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound && method == http.MethodHead:
+		return storageResponseError(resp.StatusCode, &types.NotFound{})
+	case body.Code == "NoSuchKey":
+		return noSuchKey(key)
+	case body.Code == "NoSuchUpload":
+		return storageResponseError(resp.StatusCode, &types.NoSuchUpload{Message: aws.String(body.Message)})
+	}
+	if body.Code == "" {
+		body.Code, body.Message = resp.Status, strings.TrimSpace(string(data))
+	}
+	return storageResponseError(resp.StatusCode, &smithy.GenericAPIError{Code: body.Code, Message: body.Message})
+}
+
+// gcsStorageClass returns the x-goog-storage-class header for class.
+func gcsStorageClass(class types.StorageClass) (string, error) {
+	switch class {
+	case "", types.StorageClassStandard:
+		return string(class), nil
+	}
+	return "", storageResponseError(http.StatusBadRequest, fmt.Errorf("storage class %s isn't supported on GCS", class))
+}
+
+// gcsChecksumAlgorithm checks a requested checksum algorithm is one GCS
+// computes.
+func gcsChecksumAlgorithm(algorithm types.ChecksumAlgorithm) error {
+	if algorithm != "" && algorithm != types.ChecksumAlgorithmCrc32c {
+		return storageResponseError(http.StatusBadRequest, fmt.Errorf("checksum algorithm %s isn't supported on GCS", algorithm))
+	}
+	return nil
+}
+
+// gcsCRC32C returns the CRC32C checksum from a response's x-goog-hash
+// headers, if it has one.
+func gcsCRC32C(header http.Header) *string {
+	for _, value := range header.Values("x-goog-hash") {
+		for _, hash := range strings.Split(value, ",") {
+			checksum, ok := strings.CutPrefix(strings.TrimSpace(hash), "crc32c=")
+			if ok {
+				return &checksum
+			}
+		}
+	}
+	return nil
+}
+
+// storedCRC32C returns an object's CRC32C checksum, for responses that
+// don't include it.
+func (s *gcsStorage) storedCRC32C(ctx context.Context, bucket, key *string) (*string, error) {
+	head, err := s.HeadObject(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: key, ChecksumMode: types.ChecksumModeEnabled})
+	if err != nil {
+		return nil, err
+	}
+	return head.ChecksumCRC32C, nil
+}
+
+// gcsObjectHeader returns the request headers for an object's stored
+// headers and storage class.
+func gcsObjectHeader(contentType, cacheControl, contentDisposition, contentLanguage *string, class types.StorageClass) (http.Header, error) {
+	header := http.Header{}
+	for name, value := range map[string]*string{
+		"Content-Type":        contentType,
+		"Cache-Control":       cacheControl,
+		"Content-Disposition": contentDisposition,
+		"Content-Language":    contentLanguage,
+	} {
+		if aws.ToString(value) != "" {
+			header.Set(name, *value)
+		}
+	}
+	storageClass, err := gcsStorageClass(class)
+	if err != nil {
+		return nil, err
+	}
+	if storageClass != "" {
+		header.Set("x-goog-storage-class", storageClass)
+	}
+	return header, nil
+}
+
+// parseGCSObject reads what a GET or HEAD response says about an object.
+func parseGCSObject(resp *http.Response) objectProperties {
+	object := parseObjectProperties(resp)
+	// GCS encrypts every object at rest, with Google's keys unless the
+	// bucket or object has a Cloud KMS key
+	object.serverSideEncryption = types.ServerSideEncryptionAes256
+	if kmsKey := resp.Header.Get("x-goog-encryption-kms-key-name"); kmsKey != "" {
+		object.serverSideEncryption, object.kmsKeyID = types.ServerSideEncryptionAwsKms, &kmsKey
+	}
+	// Like S3, the standard storage class isn't reported
+	if class := resp.Header.Get("x-goog-storage-class"); class != string(types.StorageClassStandard) {
+		object.storageClass = types.StorageClass(class)
+	}
+	return object
+}
+
+func (s *gcsStorage) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	header := http.Header{}
+	if params.Range != nil {
+		header.Set("Range", *params.Range)
+	}
+	resp, err := s.do(ctx, http.MethodGet, aws.ToString(params.Bucket), aws.ToString(params.Key), nil, header, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return parseGCSObject(resp).getObjectOutput(resp.Body), nil
+}
+
+func (s *gcsStorage) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	err := gcsChecksumAlgorithm(params.ChecksumAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	header, err := gcsObjectHeader(params.ContentType, params.CacheControl, params.ContentDisposition, params.ContentLanguage, params.StorageClass)
+	if err != nil {
+		return nil, err
+	}
+	body := params.Body
+	if body == nil {
+		body = strings.NewReader("")
+	}
+	resp, err := s.do(ctx, http.MethodPut, aws.ToString(params.Bucket), aws.ToString(params.Key), nil, header, body, requestBodyLength(body, params.ContentLength))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &s3.PutObjectOutput{ETag: optionalString(resp.Header.Get("ETag")), ChecksumCRC32C: gcsCRC32C(resp.Header)}, nil
+}
+
+func (s *gcsStorage) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	resp, err := s.do(ctx, http.MethodHead, aws.ToString(params.Bucket), aws.ToString(params.Key), nil, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	output := parseGCSObject(resp).headObjectOutput()
+	if params.ChecksumMode == types.ChecksumModeEnabled {
+		output.ChecksumCRC32C = gcsCRC32C(resp.Header)
+	}
+	return output, nil
+}
+
+func (s *gcsStorage) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	resp, err := s.do(ctx, http.MethodDelete, aws.ToString(params.Bucket), aws.ToString(params.Key), nil, nil, nil, 0)
+	// Deleting a missing object succeeds, as it does on S3
+	var apiErr *types.NoSuchKey
+	if errors.As(err, &apiErr) {
+		return &s3.DeleteObjectOutput{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (s *gcsStorage) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	err := gcsChecksumAlgorithm(params.ChecksumAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	if params.MetadataDirective == types.MetadataDirectiveReplace {
+		var err error
+		header, err = gcsObjectHeader(params.ContentType, params.CacheControl, params.ContentDisposition, params.ContentLanguage, params.StorageClass)
+		if err != nil {
+			return nil, err
+		}
+		header.Set("x-goog-metadata-directive", "REPLACE")
+	} else {
+		storageClass, err := gcsStorageClass(params.StorageClass)
+		if err != nil {
+			return nil, err
+		}
+		if storageClass != "" {
+			header.Set("x-goog-storage-class", storageClass)
+		}
+	}
+	// CopySource is already escaped, the same as for S3
+	header.Set("x-goog-copy-source", "/"+strings.TrimPrefix(aws.ToString(params.CopySource), "/"))
+
+	result := struct {
+		LastModified time.Time
+		ETag         string
+	}{}
+	err = s.doXML(ctx, http.MethodPut, aws.ToString(params.Bucket), aws.ToString(params.Key), nil, header, nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := s.storedCRC32C(ctx, params.Bucket, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.CopyObjectOutput{CopyObjectResult: &types.CopyObjectResult{
+		ETag:           aws.String(result.ETag),
+		LastModified:   aws.Time(result.LastModified),
+		ChecksumCRC32C: checksum,
+	}}, nil
+}
+
+func (s *gcsStorage) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	err := gcsChecksumAlgorithm(params.ChecksumAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	header, err := gcsObjectHeader(params.ContentType, params.CacheControl, params.ContentDisposition, params.ContentLanguage, params.StorageClass)
+	if err != nil {
+		return nil, err
+	}
+	result := struct {
+		Bucket   string
+		Key      string
+		UploadID string `xml:"UploadId"`
+	}{}
+	err = s.doXML(ctx, http.MethodPost, aws.ToString(params.Bucket), aws.ToString(params.Key), url.Values{"uploads": {""}}, header, nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.CreateMultipartUploadOutput{
+		Bucket:   params.Bucket,
+		Key:      params.Key,
+		UploadId: aws.String(result.UploadID),
+	}, nil
+}
+
+func (s *gcsStorage) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	query := url.Values{
+		"partNumber": {strconv.Itoa(int(aws.ToInt32(params.PartNumber)))},
+		"uploadId":   {aws.ToString(params.UploadId)},
+	}
+	body := params.Body
+	if body == nil {
+		body = strings.NewReader("")
+	}
+	resp, err := s.do(ctx, http.MethodPut, aws.ToString(params.Bucket), aws.ToString(params.Key), query, nil, body, requestBodyLength(body, params.ContentLength))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &s3.UploadPartOutput{ETag: optionalString(resp.Header.Get("ETag")), ChecksumCRC32C: gcsCRC32C(resp.Header)}, nil
+}
+
+// gcsPart is an uploaded part of a multipart upload.
+type gcsPart struct {
+	PartNumber   int32
+	ETag         string
+	Size         int64
+	LastModified time.Time
+}
+
+func (s *gcsStorage) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	request := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []struct {
+			PartNumber int32
+			ETag       string
+		} `xml:"Part"`
+	}{}
+	if params.MultipartUpload != nil {
+		for _, part := range params.MultipartUpload.Parts {
+			request.Parts = append(request.Parts, struct {
+				PartNumber int32
+				ETag       string
+			}{aws.ToInt32(part.PartNumber), aws.ToString(part.ETag)})
+		}
+	}
+	body, err := xml.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	result := struct {
+		Bucket string
+		Key    string
+		ETag   string
+	}{}
+	err = s.doXML(ctx, http.MethodPost, aws.ToString(params.Bucket), aws.ToString(params.Key), url.Values{"uploadId": {aws.ToString(params.UploadId)}}, nil, body, &result)
+	if err != nil {
+		return nil, err
+	}
+	// The checksum of the assembled object isn't part of the response
+	checksum, err := s.storedCRC32C(ctx, params.Bucket, params.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.CompleteMultipartUploadOutput{
+		Bucket:         params.Bucket,
+		Key:            params.Key,
+		ETag:           aws.String(result.ETag),
+		ChecksumCRC32C: checksum,
+	}, nil
+}
+
+func (s *gcsStorage) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	resp, err := s.do(ctx, http.MethodDelete, aws.ToString(params.Bucket), aws.ToString(params.Key), url.Values{"uploadId": {aws.ToString(params.UploadId)}}, nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (s *gcsStorage) ListParts(ctx context.Context, params *s3.ListPartsInput, optFns ...func(*s3.Options)) (*s3.ListPartsOutput, error) {
+	query := url.Values{"uploadId": {aws.ToString(params.UploadId)}}
+	if params.PartNumberMarker != nil {
+		query.Set("part-number-marker", *params.PartNumberMarker)
+	}
+	if params.MaxParts != nil {
+		query.Set("max-parts", strconv.Itoa(int(*params.MaxParts)))
+	}
+	result := struct {
+		IsTruncated          bool
+		NextPartNumberMarker string
+		Parts                []gcsPart `xml:"Part"`
+	}{}
+	err := s.doXML(ctx, http.MethodGet, aws.ToString(params.Bucket), aws.ToString(params.Key), query, nil, nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	output := &s3.ListPartsOutput{
+		Bucket:               params.Bucket,
+		Key:                  params.Key,
+		UploadId:             params.UploadId,
+		IsTruncated:          aws.Bool(result.IsTruncated),
+		NextPartNumberMarker: optionalString(result.NextPartNumberMarker),
+		// Every upload is checksummed with CRC32C
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32c,
+	}
+	for _, part := range result.Parts {
+		output.Parts = append(output.Parts, types.Part{
+			PartNumber:   aws.Int32(part.PartNumber),
+			ETag:         aws.String(part.ETag),
+			Size:         aws.Int64(part.Size),
+			LastModified: aws.Time(part.LastModified),
+		})
+	}
+	return output, nil
+}
+
+func (s *gcsStorage) ListMultipartUploads(ctx context.Context, params *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	query := url.Values{"uploads": {""}}
+	for name, value := range map[string]*string{
+		"key-marker":       params.KeyMarker,
+		"upload-id-marker": params.UploadIdMarker,
+		"prefix":           params.Prefix,
+	} {
+		if value != nil {
+			query.Set(name, *value)
+		}
+	}
+	result := struct {
+		IsTruncated        bool
+		NextKeyMarker      string
+		NextUploadIDMarker string `xml:"NextUploadIdMarker"`
+		Uploads            []struct {
+			Key       string
+			UploadID  string `xml:"UploadId"`
+			Initiated time.Time
+		} `xml:"Upload"`
+	}{}
+	err := s.doXML(ctx, http.MethodGet, aws.ToString(params.Bucket), "", query, nil, nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	output := &s3.ListMultipartUploadsOutput{
+		Bucket:             params.Bucket,
+		IsTruncated:        aws.Bool(result.IsTruncated),
+		NextKeyMarker:      optionalString(result.NextKeyMarker),
+		NextUploadIdMarker: optionalString(result.NextUploadIDMarker),
+	}
+	for _, upload := range result.Uploads {
+		output.Uploads = append(output.Uploads, types.MultipartUpload{
+			Key:       aws.String(upload.Key),
+			UploadId:  aws.String(upload.UploadID),
+			Initiated: aws.Time(upload.Initiated),
+		})
+	}
+	return output, nil
+}
+
+// sign returns the hex RSA signature of a V4 string to sign or policy.
+func (s *gcsStorage) sign(value string) (string, error) {
+	digest := sha256.Sum256([]byte(value))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(signature), nil
+}
+
+// credentialScope returns the X-Goog-Date and X-Goog-Credential of a V4
+// signature made at now, and its scope.
+func (s *gcsStorage) credentialScope(now time.Time) (date, credential, scope string) {
+	now = now.UTC()
+	scope = now.Format("20060102") + "/auto/storage/goog4_request"
+	return now.Format("20060102T150405Z"), s.clientEmail + "/" + scope, scope
+}
+
+func (s *gcsStorage) presignGet(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
+	if expires > gcsMaxSignedExpiry {
+		return "", fmt.Errorf("GCS signed URLs can't be valid for more than %s", gcsMaxSignedExpiry)
+	}
+	date, credential, scope := s.credentialScope(time.Now())
+	u := s.objectURL(bucket, key, url.Values{
+		"X-Goog-Algorithm":     {gcsSigningAlgo},
+		"X-Goog-Credential":    {credential},
+		"X-Goog-Date":          {date},
+		"X-Goog-Expires":       {strconv.Itoa(int(expires.Seconds()))},
+		"X-Goog-SignedHeaders": {"host"},
+	})
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		u.RawQuery,
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	signature, err := s.sign(strings.Join([]string{gcsSigningAlgo, date, scope, hex.EncodeToString(digest[:])}, "\n"))
+	if err != nil {
+		return "", err
+	}
+	u.RawQuery += "&X-Goog-Signature=" + signature
+	return u.String(), nil
+}
+
+// gcsPostPolicy is a V4 POST policy document.
+type gcsPostPolicy struct {
+	Conditions []any  `json:"conditions"`
+	Expiration string `json:"expiration"`
+}
+
+func (s *gcsStorage) presignPost(ctx context.Context, bucket, key string, expires time.Duration, conditions []any) (*s3.PresignedPostRequest, error) {
+	if expires > gcsMaxSignedExpiry {
+		return nil, fmt.Errorf("GCS POST policies can't be valid for more than %s", gcsMaxSignedExpiry)
+	}
+	now := time.Now()
+	date, credential, _ := s.credentialScope(now)
+	values := map[string]string{
+		"key":               key,
+		"x-goog-algorithm":  gcsSigningAlgo,
+		"x-goog-credential": credential,
+		"x-goog-date":       date,
+	}
+	conditions = append(slices.Clone(conditions),
+		map[string]string{"bucket": bucket},
+		map[string]string{"x-goog-algorithm": gcsSigningAlgo},
+		map[string]string{"x-goog-credential": credential},
+		map[string]string{"x-goog-date": date},
+	)
+	policyJSON, err := json.Marshal(gcsPostPolicy{
+		Conditions: conditions,
+		Expiration: now.Add(expires).UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, err
+	}
+	values["policy"] = base64.StdEncoding.EncodeToString(policyJSON)
+	values["x-goog-signature"], err = s.sign(values["policy"])
+	if err != nil {
+		return nil, err
+	}
+	return &s3.PresignedPostRequest{URL: s.objectURL(bucket, "", nil).String(), Values: values}, nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fakeGCS serves the OAuth token endpoint and enough of the XML API for
+// whole objects.
+type fakeGCS struct {
+	mu      sync.Mutex
+	objects map[string]fakeGCSObject
+	tokens  int
+}
+
+type fakeGCSObject struct {
+	body        []byte
+	contentType string
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/token" {
+		if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || r.FormValue("assertion") == "" {
+			http.Error(w, "bad grant", http.StatusBadRequest)
+			return
+		}
+		f.tokens++
+		w.Write([]byte(`{"access_token": "test-token", "expires_in": 3600}`))
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	object, ok := f.objects[r.URL.Path]
+	if !ok && r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`))
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		f.objects[r.URL.Path] = fakeGCSObject{body: body, contentType: r.Header.Get("Content-Type")}
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", object.contentType)
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("x-goog-storage-class", "STANDARD")
+		w.Header().Add("x-goog-hash", "crc32c=n5MJBA==")
+		w.Header().Add("x-goog-hash", "md5=mLBxvMsnAjzDMO6GjN0n4A==")
+		w.Write(object.body)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// newTestGCSStorage returns GCS storage on a fake, with a new service
+// account key.
+func newTestGCSStorage(t *testing.T) (*gcsStorage, *fakeGCS, *rsa.PrivateKey) {
+	t.Helper()
+	fake := &fakeGCS{objects: map[string]fakeGCSObject{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	credentials, err := json.Marshal(gcsServiceAccount{
+		Type:        "service_account",
+		ClientEmail: "tubely@project.iam.gserviceaccount.com",
+		PrivateKey:  string(keyPEM),
+		TokenURI:    server.URL + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialsPath := filepath.Join(t.TempDir(), "credentials.json")
+	err = os.WriteFile(credentialsPath, credentials, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := newGCSStorage(credentialsPath, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage, fake, key
+}
+
+func TestGCSStorageObjects(t *testing.T) {
+	storage, fake, _ := newTestGCSStorage(t)
+	ctx := context.Background()
+	bucket, key := aws.String(testBucket), aws.String("videos/a video.mp4")
+
+	_, err := storage.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      bucket,
+		Key:         key,
+		Body:        strings.NewReader("video"),
+		ContentType: aws.String("video/mp4"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.objects["/"+testBucket+"/videos/a video.mp4"]; !ok {
+		t.Fatalf("stored %v, want the video", fake.objects)
+	}
+
+	head, err := storage.HeadObject(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: key, ChecksumMode: types.ChecksumModeEnabled})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := aws.ToString(head.ChecksumCRC32C); got != "n5MJBA==" {
+		t.Errorf("head has checksum %q, want the CRC32C from x-goog-hash", got)
+	}
+	if aws.ToString(head.ContentType) != "video/mp4" || aws.ToInt64(head.ContentLength) != 5 {
+		t.Errorf("head is %s with %d bytes, want video/mp4 with 5", aws.ToString(head.ContentType), aws.ToInt64(head.ContentLength))
+	}
+	if head.StorageClass != "" || head.ServerSideEncryption != types.ServerSideEncryptionAes256 {
+		t.Errorf("head is in class %q encrypted with %q, want the standard class encrypted with AES256", head.StorageClass, head.ServerSideEncryption)
+	}
+
+	object, err := storage.GetObject(ctx, &s3.GetObjectInput{Bucket: bucket, Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(object.Body)
+	object.Body.Close()
+	if string(body) != "video" {
+		t.Errorf("got %q, want video", body)
+	}
+
+	_, err = storage.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = storage.HeadObject(ctx, &s3.HeadObjectInput{Bucket: bucket, Key: key})
+	var responseErr *awshttp.ResponseError
+	if !errors.As(err, &responseErr) || responseErr.HTTPStatusCode() != http.StatusNotFound {
+		t.Errorf("head of a deleted object returned %v, want a 404", err)
+	}
+	_, err = storage.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: bucket, Key: key})
+	if err != nil {
+		t.Errorf("deleting a missing object returned %v", err)
+	}
+
+	_, err = storage.PutObject(ctx, &s3.PutObjectInput{Bucket: bucket, Key: key, StorageClass: types.StorageClassGlacier})
+	if err == nil {
+		t.Error("PutObject accepted an S3 storage class")
+	}
+	if fake.tokens != 1 {
+		t.Errorf("got %d access tokens, want 1", fake.tokens)
+	}
+}
+
+func TestGCSPresignGet(t *testing.T) {
+	storage, _, key := newTestGCSStorage(t)
+
+	presigned, err := storage.presignGet(context.Background(), testBucket, "videos/a video.mp4", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(presigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.EscapedPath() != "/"+testBucket+"/videos/a%20video.mp4" {
+		t.Errorf("presigned path %s", u.EscapedPath())
+	}
+	query := u.Query()
+	if query.Get("X-Goog-Expires") != "3600" || !strings.HasPrefix(query.Get("X-Goog-Credential"), "tubely@project.iam.gserviceaccount.com/") {
+		t.Errorf("presigned query %s", u.RawQuery)
+	}
+
+	// The signature covers the canonical request without it
+	signature, err := hex.DecodeString(query.Get("X-Goog-Signature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query.Del("X-Goog-Signature")
+	canonicalRequest := strings.Join([]string{"GET", u.EscapedPath(), gcsQuery(query), "host:" + u.Host + "\n", "host", "UNSIGNED-PAYLOAD"}, "\n")
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	credential := strings.SplitN(query.Get("X-Goog-Credential"), "/", 2)
+	stringToSign := strings.Join([]string{gcsSigningAlgo, query.Get("X-Goog-Date"), credential[1], hex.EncodeToString(digest[:])}, "\n")
+	signed := sha256.Sum256([]byte(stringToSign))
+	err = rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, signed[:], signature)
+	if err != nil {
+		t.Errorf("signature doesn't verify: %v", err)
+	}
+
+	_, err = storage.presignGet(context.Background(), testBucket, "key", 8*24*time.Hour)
+	if err == nil {
+		t.Error("presigned a URL for longer than GCS allows")
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// deleteUserAccount deletes every video the user owns along with all of its
+// stored objects, revokes their refresh tokens, removes the user record and
+// records an audit event. Objects that can't be deleted right away are left
+// to the asset GC. Videos the user created for an organization stay with the
+// organization. Accounts with a video under legal hold aren't deleted at
+// all; database.ErrLegalHold is returned.
+func (cfg *apiConfig) deleteUserAccount(userID uuid.UUID, actor string) error {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't get user: %w", err)
+	}
+	allVideos, err := cfg.db.GetVideos(userID, database.VideoFilter{})
+	if err != nil {
+		return fmt.Errorf("couldn't get videos: %w", err)
+	}
+	videos := []database.Video{}
+	for _, video := range allVideos {
+		if video.OrganizationID == nil {
+			if video.LegalHold {
+				return fmt.Errorf("couldn't delete video %s: %w", video.ID, database.ErrLegalHold)
+			}
+			videos = append(videos, video)
+		}
+	}
+
+	failedAssets := 0
+	for _, video := range videos {
+		err := cfg.deleteVideoAssets(video)
+		if err != nil {
+			failedAssets++
+			logging.Warnf("Couldn't delete all assets of video %s: %v", video.ID, err)
+		}
+		err = cfg.db.DeleteVideo(video.ID)
+		if err != nil {
+			return fmt.Errorf("couldn't delete video %s: %w", video.ID, err)
+		}
+	}
+
+	// Discard uploads that were never finalized
+	sessions, err := cfg.db.GetOpenUploadSessionsForUser(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't list upload sessions: %w", err)
+	}
+	for _, session := range sessions {
+		cfg.closeUploadSession(&session, database.UploadSessionStatusAborted)
+	}
+	err = cfg.db.DeleteUploadSessionsForUser(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't delete upload sessions: %w", err)
+	}
+	err = cfg.db.DeleteWebhooksForUser(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't delete webhooks: %w", err)
+	}
+	cfg.revokeLinkedAccounts(userID)
+	err = cfg.db.DeleteLinkedAccountsForUser(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't unlink accounts: %w", err)
+	}
+	err = cfg.db.DeleteVideoExportsForUser(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't delete video exports: %w", err)
+	}
+	err = cfg.db.DeleteOrganizationMemberships(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't remove organization memberships: %w", err)
+	}
+	err = cfg.db.DeleteLikesForUser(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't delete likes: %w", err)
+	}
+	err = cfg.db.DeleteFollowsForUser(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't delete follows: %w", err)
+	}
+	err = cfg.db.DeleteVideoTransfersForUser(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't delete video transfers: %w", err)
+	}
+	err = cfg.db.DeleteFiledTakedownsForUser(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't withdraw takedowns: %w", err)
+	}
+	err = cfg.db.DeleteVideoReportsForUser(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't delete video reports: %w", err)
+	}
+	err = cfg.db.DeleteNotificationSettingsForUser(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't delete notification settings: %w", err)
+	}
+	err = cfg.db.DeleteRefreshTokensForUser(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't revoke refresh tokens: %w", err)
+	}
+	err = cfg.db.DeleteUser(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't delete user: %w", err)
+	}
+	// Released once the user is gone, so their own references don't count
+	if user != nil {
+		for _, kind := range profileImageKinds {
+			if imageURL := kind.get(*user); imageURL != nil {
+				cfg.releaseProfileImage(*imageURL)
+			}
+		}
+	}
+
+	details, err := json.Marshal(map[string]int{
+		"videos_deleted":             len(videos),
+		"videos_with_pending_assets": failedAssets,
+	})
+	if err != nil {
+		return err
+	}
+	err = cfg.db.CreateAuditEvent(database.CreateAuditEventParams{
+		Actor:     actor,
+		Action:    database.AuditActionUserDeleted,
+		SubjectID: userID,
+		Details:   details,
+	})
+	if err != nil {
+		// The account is already gone; don't report the deletion as failed
+		logging.Warnf("Couldn't record audit event for deleting user %s: %v", userID, err)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) handlerUsersDeleteMe(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	err = cfg.deleteUserAccount(user.ID, "user:"+user.ID.String())
+	if errors.Is(err, database.ErrLegalHold) {
+		respondWithErrorCode(w, http.StatusConflict, errCodeLegalHold, "Account has a video under legal hold", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete account", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerAdminUserDelete(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	err = cfg.deleteUserAccount(user.ID, "admin")
+	if errors.Is(err, database.ErrLegalHold) {
+		respondWithErrorCode(w, http.StatusConflict, errCodeLegalHold, "Account has a video under legal hold", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete account", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// adminMiddleware only lets requests through that carry the configured admin
+// API key as "Authorization: ApiKey <key>". Admin routes are disabled entirely
+// when no key is configured.
+func (cfg *apiConfig) adminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.adminAPIKey == "" {
+			respondWithError(w, http.StatusForbidden, "Admin API is disabled", nil)
+			return
+		}
+		apiKey, err := auth.GetAPIKey(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find admin API key", err)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.adminAPIKey)) != 1 {
+			respondWithError(w, http.StatusUnauthorized, "Invalid admin API key", nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (cfg *apiConfig) handlerAdminVideoReprocess(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		AudioTracks []int `json:"audio_tracks"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	// The body is optional; without one every audio track is kept
+	params := parameters{}
+	if r.ContentLength != 0 {
+		decoder := json.NewDecoder(r.Body)
+		err = decoder.Decode(&params)
+		if err != nil && !errors.Is(err, io.EOF) {
+			respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+			return
+		}
+	}
+	for _, index := range params.AudioTracks {
+		if index < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid audio track selection", nil)
+			return
+		}
+	}
+
+	// Hold the video until it's updated
+	videoLock, ok := cfg.lockVideo(w, videoID)
+	if !ok {
+		return
+	}
+	defer videoLock.Release()
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	// Reprocessing replaces the rendition
+	if !checkNotOnLegalHold(w, video) {
+		return
+	}
+	if video.OriginalURL == nil && video.VideoURL == nil {
+		respondWithError(w, http.StatusConflict, "Video has no stored source to reprocess", nil)
+		return
+	}
+
+	// The worker fetches the source from S3 because the job has no local file
+	job, err := cfg.db.CreateJob(database.CreateJobParams{
+		VideoID:     video.ID,
+		MediaType:   "video/mp4",
+		AudioTracks: params.AudioTracks,
+	})
+	if errors.Is(err, database.ErrJobInProgress) {
+		respondWithProcessingConflict(w, job)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create processing job", err)
+		return
+	}
+	cfg.enqueueJob(job.ID)
+
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// handlerAdminVideosReprocessFailed queues reprocessing for every video
+// whose latest job failed, reporting the videos it had to skip and why.
+func (cfg *apiConfig) handlerAdminVideosReprocessFailed(w http.ResponseWriter, r *http.Request) {
+	type skippedVideo struct {
+		VideoID uuid.UUID `json:"video_id"`
+		Reason  string    `json:"reason"`
+	}
+	type response struct {
+		Queued  []database.Job `json:"queued"`
+		Skipped []skippedVideo `json:"skipped"`
+	}
+
+	videoIDs, err := cfg.db.GetVideoIDsWithFailedJobs()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get failed jobs", err)
+		return
+	}
+
+	resp := response{Queued: []database.Job{}, Skipped: []skippedVideo{}}
+	for _, videoID := range videoIDs {
+		job, err := cfg.reprocessVideo(videoID)
+		if err != nil {
+			resp.Skipped = append(resp.Skipped, skippedVideo{VideoID: videoID, Reason: err.Error()})
+			continue
+		}
+		resp.Queued = append(resp.Queued, job)
+	}
+	respondWithJSON(w, http.StatusAccepted, resp)
+}
+
+// reprocessVideo queues a job reprocessing a video from its stored source,
+// keeping every audio track.
+func (cfg *apiConfig) reprocessVideo(videoID uuid.UUID) (database.Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), videoLockWait)
+	defer cancel()
+	l, err := cfg.locks.Acquire(ctx, videoLockKey(videoID), videoLockTTL)
+	if err != nil {
+		return database.Job{}, fmt.Errorf("couldn't lock video: %w", err)
+	}
+	defer l.Release()
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return database.Job{}, err
+	}
+	if video.ID == uuid.Nil {
+		return database.Job{}, errors.New("video no longer exists")
+	}
+	if video.LegalHold {
+		return database.Job{}, database.ErrLegalHold
+	}
+	if video.OriginalURL == nil && video.VideoURL == nil {
+		return database.Job{}, errors.New("video has no stored source to reprocess")
+	}
+
+	job, err := cfg.db.CreateJob(database.CreateJobParams{
+		VideoID:   video.ID,
+		MediaType: "video/mp4",
+	})
+	if err != nil {
+		return database.Job{}, err
+	}
+	cfg.enqueueJob(job.ID)
+	return job, nil
+}
+
+func (cfg *apiConfig) handlerAdminModerationQueue(w http.ResponseWriter, r *http.Request) {
+	videos, err := cfg.db.GetVideosByModerationStatus(database.ModerationStatusPendingReview)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	signedVideos := make([]database.Video, len(videos))
+	for i, video := range videos {
+		signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+			return
+		}
+		signedVideos[i] = signedVideo
+	}
+
+	respondWithJSON(w, http.StatusOK, signedVideos)
+}
+
+func (cfg *apiConfig) handlerAdminVideoModerate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// Decision is "approve" or "reject".
+		Decision string `json:"decision"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	var status string
+	switch params.Decision {
+	case "approve":
+		status = database.ModerationStatusApproved
+	case "reject":
+		status = database.ModerationStatusRejected
+	default:
+		respondWithError(w, http.StatusBadRequest, "Decision must be approve or reject", nil)
+		return
+	}
+
+	// Hold the video until it's updated
+	videoLock, ok := cfg.lockVideo(w, videoID)
+	if !ok {
+		return
+	}
+	defer videoLock.Release()
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+
+	previousStatus := video.ModerationStatus
+	video.ModerationStatus = status
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.announceIfPublished(video.ID)
+
+	details, err := json.Marshal(map[string]string{"from": previousStatus, "to": status})
+	if err == nil {
+		err = cfg.db.CreateAuditEvent(database.CreateAuditEventParams{
+			Actor:     "admin",
+			Action:    database.AuditActionVideoModerated,
+			SubjectID: video.ID,
+			Details:   details,
+		})
+	}
+	if err != nil {
+		logging.Warnf("Couldn't record audit event for moderating video %s: %v", video.ID, err)
+	}
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
+
+type pendingThumbnailResponse struct {
+	database.Video
+	PendingThumbnailURL    string                    `json:"pending_thumbnail_url"`
+	PendingThumbnailLabels database.ModerationLabels `json:"pending_thumbnail_labels"`
+}
+
+func (cfg *apiConfig) handlerAdminThumbnailModerationQueue(w http.ResponseWriter, r *http.Request) {
+	videos, err := cfg.db.GetVideosWithPendingThumbnail()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	responses := make([]pendingThumbnailResponse, len(videos))
+	for i, video := range videos {
+		signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+			return
+		}
+		pendingURL, err := cfg.signThumbnailURL(*video.PendingThumbnailURL, clientRegionHint(r))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+			return
+		}
+		responses[i] = pendingThumbnailResponse{
+			Video:                  signedVideo,
+			PendingThumbnailURL:    pendingURL,
+			PendingThumbnailLabels: video.PendingThumbnailLabels,
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, responses)
+}
+
+func (cfg *apiConfig) handlerAdminThumbnailModerate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// Decision is "approve" or "reject".
+		Decision string `json:"decision"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Decision != "approve" && params.Decision != "reject" {
+		respondWithError(w, http.StatusBadRequest, "Decision must be approve or reject", nil)
+		return
+	}
+
+	// Hold the video until it's updated
+	videoLock, ok := cfg.lockVideo(w, videoID)
+	if !ok {
+		return
+	}
+	defer videoLock.Release()
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.PendingThumbnailURL == nil {
+		respondWithError(w, http.StatusConflict, "Video has no thumbnail pending review", nil)
+		return
+	}
+
+	pendingURL := video.PendingThumbnailURL
+	previousThumbnailURL := video.ThumbnailURL
+	video.PendingThumbnailURL = nil
+	video.PendingThumbnailLabels = nil
+	if params.Decision == "approve" {
+		video.ThumbnailURL = pendingURL
+		// The sizes described the thumbnail being replaced
+		video.ThumbnailOriginalSize = nil
+		video.ThumbnailSize = nil
+	}
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	// Release whichever thumbnail lost, unless it's still the one in use
+	released := pendingURL
+	if params.Decision == "approve" {
+		released = previousThumbnailURL
+	}
+	if released != nil && (video.ThumbnailURL == nil || *released != *video.ThumbnailURL) {
+		cfg.releaseThumbnail(video.ID, released)
+	}
+
+	details, err := json.Marshal(map[string]string{"decision": params.Decision, "thumbnail_url": *pendingURL})
+	if err == nil {
+		err = cfg.db.CreateAuditEvent(database.CreateAuditEventParams{
+			Actor:     "admin",
+			Action:    database.AuditActionThumbnailModerated,
+			SubjectID: video.ID,
+			Details:   details,
+		})
+	}
+	if err != nil {
+		logging.Warnf("Couldn't record audit event for moderating thumbnail of video %s: %v", video.ID, err)
+	}
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
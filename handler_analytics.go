@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Playback event ingestion limits.
+const (
+	maxPlaybackEventBatch = 100
+	// Players may queue events while offline, but not for long, and their
+	// clocks may run a little fast
+	maxPlaybackEventAge  = 24 * time.Hour
+	maxPlaybackClockSkew = 5 * time.Minute
+	// playbackPositionSlack allows positions a little past the duration
+	// ffprobe measured
+	playbackPositionSlack = 1.0
+)
+
+var playbackEventTypes = []string{
+	database.PlaybackEventPlay,
+	database.PlaybackEventPause,
+	database.PlaybackEventProgress,
+	database.PlaybackEventComplete,
+}
+
+// handlerAnalyticsEvents records a batch of events from a video player, for
+// working out watch time. Like view counting it needs no authentication.
+// Either every event in the batch is valid and recorded, or none is.
+func (cfg *apiConfig) handlerAnalyticsEvents(w http.ResponseWriter, r *http.Request) {
+	type playbackEvent struct {
+		VideoID         uuid.UUID  `json:"video_id"`
+		SessionID       uuid.UUID  `json:"session_id"`
+		Type            string     `json:"type"`
+		Quartile        int        `json:"quartile"`
+		PositionSeconds *float64   `json:"position_seconds"`
+		OccurredAt      *time.Time `json:"occurred_at"`
+	}
+	type parameters struct {
+		Events []playbackEvent `json:"events"`
+	}
+	type response struct {
+		Accepted int `json:"accepted"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.Events) == 0 || len(params.Events) > maxPlaybackEventBatch {
+		respondWithFieldErrors(w, http.StatusBadRequest, "Invalid request parameters", []fieldError{
+			{Field: "events", In: paramInBody, Message: fmt.Sprintf("must hold between 1 and %d events", maxPlaybackEventBatch)},
+		})
+		return
+	}
+
+	videoIDs := []uuid.UUID{}
+	seen := map[uuid.UUID]bool{}
+	for _, event := range params.Events {
+		if !seen[event.VideoID] {
+			seen[event.VideoID] = true
+			videoIDs = append(videoIDs, event.VideoID)
+		}
+	}
+	videos, err := cfg.db.Replica().GetVideosByIDs(videoIDs)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	videosByID := make(map[uuid.UUID]database.Video, len(videos))
+	for _, video := range videos {
+		videosByID[video.ID] = video
+	}
+
+	now := time.Now().UTC()
+	fields := []fieldError{}
+	invalid := func(i int, field, msg string) {
+		fields = append(fields, fieldError{Field: fmt.Sprintf("events[%d].%s", i, field), In: paramInBody, Message: msg})
+	}
+	events := make([]database.PlaybackEvent, 0, len(params.Events))
+	for i, event := range params.Events {
+		video, ok := videosByID[event.VideoID]
+		if !ok || video.VideoURL == nil {
+			invalid(i, "video_id", "must be a video that can be played")
+		}
+		if event.SessionID == uuid.Nil {
+			invalid(i, "session_id", "must be a UUID")
+		}
+		if msg := checkOneOf(playbackEventTypes)(event.Type); msg != "" {
+			invalid(i, "type", msg)
+		}
+		switch {
+		case event.Type == database.PlaybackEventProgress && event.Quartile != 25 && event.Quartile != 50 && event.Quartile != 75:
+			invalid(i, "quartile", "must be 25, 50 or 75")
+		case event.Type != database.PlaybackEventProgress && event.Quartile != 0:
+			invalid(i, "quartile", "is only allowed on progress events")
+		}
+		switch position := event.PositionSeconds; {
+		case position == nil:
+			invalid(i, "position_seconds", "is required")
+		case *position < 0 || math.IsNaN(*position) || math.IsInf(*position, 0):
+			invalid(i, "position_seconds", "must be a number of seconds")
+		case ok && video.DurationSeconds != nil && *position > *video.DurationSeconds+playbackPositionSlack:
+			invalid(i, "position_seconds", "must be within the video")
+		}
+		occurredAt := now
+		if event.OccurredAt != nil {
+			occurredAt = event.OccurredAt.UTC()
+			if occurredAt.After(now.Add(maxPlaybackClockSkew)) {
+				invalid(i, "occurred_at", "must not be in the future")
+			} else if occurredAt.Before(now.Add(-maxPlaybackEventAge)) {
+				invalid(i, "occurred_at", "must be within the last 24 hours")
+			}
+		}
+		if len(fields) > 0 {
+			continue
+		}
+		events = append(events, database.PlaybackEvent{
+			VideoID:         event.VideoID,
+			SessionID:       event.SessionID,
+			Type:            event.Type,
+			Quartile:        event.Quartile,
+			PositionSeconds: *event.PositionSeconds,
+			OccurredAt:      occurredAt,
+			ReceivedAt:      now,
+		})
+	}
+	if len(fields) > 0 {
+		respondWithFieldErrors(w, http.StatusBadRequest, "Invalid request parameters", fields)
+		return
+	}
+
+	err = cfg.db.CreatePlaybackEvents(events)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record playback events", err)
+		return
+	}
+	for _, event := range events {
+		playbackEventsRecorded.With(event.Type).Inc()
+	}
+
+	respondWithJSON(w, http.StatusAccepted, response{Accepted: len(events)})
+}
+
+// Analytics report limits.
+const (
+	defaultAnalyticsDays = 28
+	maxAnalyticsDays     = 366
+	// analyticsCacheTTL is how long a report is reused for. Rollups only
+	// change every run of the rollup job anyway.
+	analyticsCacheTTL = 5 * time.Minute
+)
+
+type analyticsDay struct {
+	Date                       string  `json:"date"`
+	Views                      int     `json:"views"`
+	WatchTimeSeconds           float64 `json:"watch_time_seconds"`
+	AverageViewDurationSeconds float64 `json:"average_view_duration_seconds"`
+}
+
+// retentionPoint is the share of views that got at least PositionPercent of
+// the way through the video.
+type retentionPoint struct {
+	PositionPercent int     `json:"position_percent"`
+	ViewersPercent  float64 `json:"viewers_percent"`
+}
+
+type videoAnalyticsReport struct {
+	VideoID                    uuid.UUID        `json:"video_id"`
+	From                       string           `json:"from"`
+	To                         string           `json:"to"`
+	Views                      int              `json:"views"`
+	WatchTimeSeconds           float64          `json:"watch_time_seconds"`
+	AverageViewDurationSeconds float64          `json:"average_view_duration_seconds"`
+	Days                       []analyticsDay   `json:"days"`
+	Retention                  []retentionPoint `json:"retention"`
+	// UpdatedAt is when the newest day in the report was last rolled up.
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// handlerVideoAnalytics reports a video's watch time, average view duration
+// and retention for a range of days, from the daily rollups. The range
+// defaults to the last 28 days, including today so far.
+func (cfg *apiConfig) handlerVideoAnalytics(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	canEdit, err := cfg.userCanEditVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusForbidden, "You can't view this video's analytics", nil)
+		return
+	}
+
+	// The route validates both dates
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if s := r.URL.Query().Get("to"); s != "" {
+		to, _ = time.Parse(time.DateOnly, s)
+	}
+	from := to.AddDate(0, 0, -(defaultAnalyticsDays - 1))
+	if s := r.URL.Query().Get("from"); s != "" {
+		from, _ = time.Parse(time.DateOnly, s)
+	}
+	if from.After(to) || to.Sub(from) >= maxAnalyticsDays*24*time.Hour {
+		respondWithFieldErrors(w, http.StatusBadRequest, "Invalid request parameters", []fieldError{
+			{Field: "from", In: paramInQuery, Message: fmt.Sprintf("must be on or before to, and at most %d days before it", maxAnalyticsDays-1)},
+		})
+		return
+	}
+
+	report, err := cfg.videoAnalyticsReport(video, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video analytics", err)
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(analyticsCacheTTL.Seconds())))
+	respondWithJSON(w, http.StatusOK, report)
+}
+
+// videoAnalyticsReport builds a video's report for the days from first to
+// last, reusing one any instance built within analyticsCacheTTL.
+func (cfg *apiConfig) videoAnalyticsReport(video database.Video, first, last string) (videoAnalyticsReport, error) {
+	key := analyticsCacheKey(video.ID, first, last)
+	if cfg.cache != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), cacheTimeout)
+		cached, ok, err := cfg.cache.Get(ctx, key)
+		cancel()
+		var report videoAnalyticsReport
+		if err == nil && ok && json.Unmarshal(cached, &report) == nil {
+			return report, nil
+		}
+	}
+
+	days, err := cfg.db.Replica().GetVideoAnalyticsDays(video.ID, first, last)
+	if err != nil {
+		return videoAnalyticsReport{}, err
+	}
+	report := videoAnalyticsReport{
+		VideoID:   video.ID,
+		From:      first,
+		To:        last,
+		Days:      make([]analyticsDay, len(days)),
+		Retention: []retentionPoint{},
+	}
+	retention := make([]int, database.RetentionPoints)
+	for i, day := range days {
+		report.Views += day.Views
+		report.WatchTimeSeconds += day.WatchSeconds
+		report.Days[i] = analyticsDay{
+			Date:                       day.Day,
+			Views:                      day.Views,
+			WatchTimeSeconds:           day.WatchSeconds,
+			AverageViewDurationSeconds: day.WatchSeconds / float64(day.Views),
+		}
+		for j, views := range day.Retention {
+			if j < len(retention) {
+				retention[j] += views
+			}
+		}
+		if report.UpdatedAt == nil || day.UpdatedAt.After(*report.UpdatedAt) {
+			report.UpdatedAt = &day.UpdatedAt
+		}
+	}
+	if report.Views > 0 {
+		report.AverageViewDurationSeconds = report.WatchTimeSeconds / float64(report.Views)
+		for i, views := range retention {
+			report.Retention = append(report.Retention, retentionPoint{
+				PositionPercent: i * 100 / (database.RetentionPoints - 1),
+				ViewersPercent:  float64(views) * 100 / float64(report.Views),
+			})
+		}
+	}
+
+	if cfg.cache != nil {
+		dat, err := json.Marshal(report)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), cacheTimeout)
+			cfg.cache.Set(ctx, key, dat, analyticsCacheTTL)
+			cancel()
+		}
+	}
+	return report, nil
+}
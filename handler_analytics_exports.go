@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// analyticsExportResponse is an export with a presigned link to its file
+// once it's completed.
+type analyticsExportResponse struct {
+	database.AnalyticsExport
+	DownloadURL *string `json:"download_url"`
+}
+
+// handlerAdminAnalyticsExportCreate starts exporting a dataset's rows for a
+// range of UTC days to a CSV file in S3. Poll the export for its link.
+func (cfg *apiConfig) handlerAdminAnalyticsExportCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Dataset string `json:"dataset"`
+		Format  string `json:"format"`
+		From    string `json:"from"`
+		To      string `json:"to"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Format == "" {
+		params.Format = analyticsExportCSV
+	}
+
+	fields := []fieldError{}
+	if msg := checkOneOf(analyticsExportDatasets)(params.Dataset); msg != "" {
+		fields = append(fields, fieldError{Field: "dataset", In: paramInBody, Message: msg})
+	}
+	if msg := checkOneOf([]string{analyticsExportCSV})(params.Format); msg != "" {
+		fields = append(fields, fieldError{Field: "format", In: paramInBody, Message: msg})
+	}
+	from, fromErr := time.Parse(analyticsDayFormat, params.From)
+	if fromErr != nil {
+		fields = append(fields, fieldError{Field: "from", In: paramInBody, Message: "must be a date like 2006-01-02"})
+	}
+	to, toErr := time.Parse(analyticsDayFormat, params.To)
+	if toErr != nil {
+		fields = append(fields, fieldError{Field: "to", In: paramInBody, Message: "must be a date like 2006-01-02"})
+	}
+	if fromErr == nil && toErr == nil && (from.After(to) || to.Sub(from) >= maxAnalyticsExportDays*24*time.Hour) {
+		fields = append(fields, fieldError{Field: "from", In: paramInBody,
+			Message: fmt.Sprintf("must be on or before to, and at most %d days before it", maxAnalyticsExportDays-1)})
+	}
+	if len(fields) > 0 {
+		respondWithFieldErrors(w, http.StatusBadRequest, "Invalid request parameters", fields)
+		return
+	}
+
+	export, err := cfg.db.CreateAnalyticsExport(database.CreateAnalyticsExportParams{
+		Dataset:     params.Dataset,
+		Format:      params.Format,
+		FromDay:     params.From,
+		ToDay:       params.To,
+		RequestedBy: "admin",
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create analytics export", err)
+		return
+	}
+	cfg.startAnalyticsExport(export)
+
+	respondWithJSON(w, http.StatusAccepted, analyticsExportResponse{AnalyticsExport: export})
+}
+
+// handlerAdminAnalyticsExportsList lists the most recent exports, newest
+// first.
+func (cfg *apiConfig) handlerAdminAnalyticsExportsList(w http.ResponseWriter, r *http.Request) {
+	exports, err := cfg.db.GetAnalyticsExports(analyticsExportsShown)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get analytics exports", err)
+		return
+	}
+
+	response := make([]analyticsExportResponse, len(exports))
+	for i, export := range exports {
+		response[i], err = cfg.analyticsExportResponse(export)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+			return
+		}
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) handlerAdminAnalyticsExportGet(w http.ResponseWriter, r *http.Request) {
+	// The route validates the ID
+	exportID, _ := uuid.Parse(r.PathValue("exportID"))
+
+	export, err := cfg.db.GetAnalyticsExport(exportID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get analytics export", err)
+		return
+	}
+	if export.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Analytics export not found", nil)
+		return
+	}
+
+	response, err := cfg.analyticsExportResponse(export)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, response)
+}
+
+func (cfg *apiConfig) analyticsExportResponse(export database.AnalyticsExport) (analyticsExportResponse, error) {
+	response := analyticsExportResponse{AnalyticsExport: export}
+	if export.Location == nil {
+		return response, nil
+	}
+	bucket, key, err := parseBucketKey(*export.Location)
+	if err != nil {
+		return response, err
+	}
+	downloadURL, err := cfg.presignGetURL(cfg.storage, bucket, key)
+	if err != nil {
+		return response, err
+	}
+	response.DownloadURL = &downloadURL
+	return response, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import "net/http"
+
+// handlerAdminBackupCreate backs up the database and assets to the backup
+// prefix, and responds once the archive is uploaded. tubely-admin restore
+// rebuilds an instance from it.
+func (cfg *apiConfig) handlerAdminBackupCreate(w http.ResponseWriter, r *http.Request) {
+	backup, err := cfg.createBackup(r.Context(), "admin")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create backup", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, backup)
+}
+
+// handlerAdminBackupsList lists the most recent backups that haven't been
+// pruned, newest first.
+func (cfg *apiConfig) handlerAdminBackupsList(w http.ResponseWriter, r *http.Request) {
+	backups, err := cfg.db.GetBackups(backupsShown)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get backups", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, backups)
+}
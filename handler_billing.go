@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// billingUsageCursorSort names the user ID order billing usage is paged in.
+const billingUsageCursorSort = "user"
+
+// handlerAdminBillingUsage reports each user's usage for a month, in the
+// units it's billed in. The current month is reported so far; months are
+// final once the billing_usage_export job has exported them.
+func (cfg *apiConfig) handlerAdminBillingUsage(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Month      string         `json:"month"`
+		From       string         `json:"from"`
+		To         string         `json:"to"`
+		Users      []billingUsage `json:"users"`
+		NextCursor *string        `json:"next_cursor"`
+	}
+
+	// The route validates the month
+	month := r.URL.Query().Get("month")
+	first, last, _ := billingMonthDays(month)
+
+	after, limit := pageParams(r)
+	if !checkCursorOrder(w, after, billingUsageCursorSort, true) {
+		return
+	}
+	afterUserID := uuid.Nil
+	if after != nil {
+		afterUserID = after.ID
+	}
+	totals, err := cfg.db.Replica().GetUsageTotals(first, last, afterUserID, limit+1)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get usage", err)
+		return
+	}
+
+	var nextCursor *string
+	if len(totals) > limit {
+		totals = totals[:limit]
+		cursor := encodeCursor(billingUsageCursorSort, true, float64(0), totals[len(totals)-1].UserID)
+		nextCursor = &cursor
+	}
+	users := make([]billingUsage, len(totals))
+	for i, total := range totals {
+		users[i] = newBillingUsage(total)
+	}
+	respondWithJSON(w, http.StatusOK, response{Month: month, From: first, To: last, Users: users, NextCursor: nextCursor})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type captionResponse struct {
+	database.Caption
+	URL string `json:"url"`
+}
+
+func (cfg *apiConfig) handlerCaptionsList(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+
+	// Videos held by moderation are only visible to their owner
+	if video.ModerationStatus == database.ModerationStatusPendingReview || video.ModerationStatus == database.ModerationStatusRejected {
+		var userID uuid.UUID
+		token, err := auth.GetBearerToken(r.Header)
+		if err == nil {
+			userID, err = auth.ValidateJWT(token, cfg.jwtSecret)
+		}
+		if err != nil || userID != video.UserID {
+			respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+			return
+		}
+	}
+	if !checkNotBlocked(w, video) {
+		return
+	}
+
+	captions, err := cfg.db.GetCaptions(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get captions", err)
+		return
+	}
+
+	responses := make([]captionResponse, len(captions))
+	for i, caption := range captions {
+		responses[i] = captionResponse{
+			Caption: caption,
+			URL:     cfg.assetURL(caption.Filename),
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, responses)
+}
+
+// maxCaptionTranslations caps how many languages one request can ask for.
+const maxCaptionTranslations = 10
+
+func (cfg *apiConfig) handlerCaptionsTranslate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// SourceLanguage picks the track to translate. It defaults to the
+		// transcribed track.
+		SourceLanguage  string   `json:"source_language"`
+		TargetLanguages []string `json:"target_languages"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	if cfg.translator == nil {
+		respondWithError(w, http.StatusNotImplemented, "Caption translation isn't configured", nil)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.TargetLanguages) == 0 || len(params.TargetLanguages) > maxCaptionTranslations {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Between 1 and %d target languages are required", maxCaptionTranslations), nil)
+		return
+	}
+	for _, language := range params.TargetLanguages {
+		if !captionLanguage.MatchString(language) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid language %q", language), nil)
+			return
+		}
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	canEdit, err := cfg.userCanEditVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized to update this video", nil)
+		return
+	}
+	if !checkNotOnLegalHold(w, video) {
+		return
+	}
+
+	captions, err := cfg.db.GetCaptions(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get captions", err)
+		return
+	}
+	var source database.Caption
+	for _, caption := range captions {
+		if params.SourceLanguage == "" && caption.Source == database.CaptionSourceTranscription ||
+			params.SourceLanguage != "" && caption.Language == params.SourceLanguage {
+			source = caption
+			break
+		}
+	}
+	if source.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "No caption track to translate from", nil)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+	responses := []captionResponse{}
+	for _, language := range params.TargetLanguages {
+		if language == source.Language {
+			continue
+		}
+		caption, err := cfg.translateCaption(ctx, source, language)
+		if err != nil {
+			respondWithError(w, http.StatusBadGateway, fmt.Sprintf("Couldn't translate captions to %s", language), err)
+			return
+		}
+		responses = append(responses, captionResponse{
+			Caption: caption,
+			URL:     cfg.assetURL(caption.Filename),
+		})
+	}
+
+	respondWithJSON(w, http.StatusCreated, responses)
+}
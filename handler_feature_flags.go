@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// handlerFeatures lists the features on for the signed-in user, so clients
+// can show what's being rolled out to them.
+func (cfg *apiConfig) handlerFeatures(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Features []string `json:"features"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{Features: cfg.features.enabledFor(userID)})
+}
+
+type featureFlagResponse struct {
+	database.FeatureFlag
+	// Source is where the setting comes from: database, config or default.
+	Source string `json:"source"`
+}
+
+func (cfg *apiConfig) featureFlagResponse(name string) featureFlagResponse {
+	flag, source := cfg.features.flag(name)
+	return featureFlagResponse{FeatureFlag: flag, Source: source}
+}
+
+func (cfg *apiConfig) handlerAdminFeatureFlagsList(w http.ResponseWriter, r *http.Request) {
+	flags := make([]featureFlagResponse, 0, len(knownFeatures))
+	for _, name := range knownFeatures {
+		flags = append(flags, cfg.featureFlagResponse(name))
+	}
+	respondWithJSON(w, http.StatusOK, flags)
+}
+
+// handlerAdminFeatureFlagSet stores a flag, overriding its FEATURE_FLAGS
+// default.
+func (cfg *apiConfig) handlerAdminFeatureFlagSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Enabled    bool              `json:"enabled"`
+		Percentage int               `json:"percentage"`
+		UserIDs    database.UUIDList `json:"user_ids"`
+	}
+
+	name := r.PathValue("name")
+	if !slices.Contains(knownFeatures, name) {
+		respondWithError(w, http.StatusNotFound, "Unknown feature", nil)
+		return
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Percentage < 0 || params.Percentage > 100 {
+		respondWithError(w, http.StatusBadRequest, "Percentage must be between 0 and 100", nil)
+		return
+	}
+
+	_, err = cfg.db.SetFeatureFlag(database.FeatureFlag{
+		Name:       name,
+		Enabled:    params.Enabled,
+		Percentage: params.Percentage,
+		UserIDs:    params.UserIDs,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save feature flag", err)
+		return
+	}
+	cfg.features.invalidate()
+
+	respondWithJSON(w, http.StatusOK, cfg.featureFlagResponse(name))
+}
+
+// handlerAdminFeatureFlagDelete drops a stored flag, going back to its
+// FEATURE_FLAGS default.
+func (cfg *apiConfig) handlerAdminFeatureFlagDelete(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !slices.Contains(knownFeatures, name) {
+		respondWithError(w, http.StatusNotFound, "Unknown feature", nil)
+		return
+	}
+
+	err := cfg.db.DeleteFeatureFlag(name)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete feature flag", err)
+		return
+	}
+	cfg.features.invalidate()
+
+	respondWithJSON(w, http.StatusOK, cfg.featureFlagResponse(name))
+}
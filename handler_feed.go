@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxFeedItems is how many of the newest videos a feed lists.
+const maxFeedItems = 100
+
+const itunesNamespace = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Itunes  string     `xml:"xmlns:itunes,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Description string       `xml:"description,omitempty"`
+	GUID        rssGUID      `xml:"guid"`
+	PubDate     string       `xml:"pubDate"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+	Duration    string       `xml:"itunes:duration,omitempty"`
+	Image       *itunesImage `xml:"itunes:image"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+// mediaURL is a stable URL for a video's media that redirects to a fresh
+// presigned URL, so it can be published in feeds.
+func (cfg *apiConfig) mediaURL(videoID uuid.UUID) string {
+	return fmt.Sprintf("http://localhost:%s/api/videos/%s/media", cfg.port, videoID)
+}
+
+// handlerUserFeed serves a podcast-style RSS feed of a user's public videos.
+func (cfg *apiConfig) handlerUserFeed(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	videos, err := cfg.db.Replica().GetFeedVideos(userID, maxFeedItems)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	// The feed doesn't expose the user's email address
+	feed := rssFeed{
+		Version: "2.0",
+		Itunes:  itunesNamespace,
+		Channel: rssChannel{
+			Title:       "Tubely videos",
+			Link:        fmt.Sprintf("http://localhost:%s/api/users/%s/feed.rss", cfg.port, userID),
+			Description: "Public videos published on Tubely",
+			Items:       []rssItem{},
+		},
+	}
+	for _, video := range videos {
+		item := rssItem{
+			Title:       video.Title,
+			Description: video.Description,
+			GUID:        rssGUID{Value: video.ID.String()},
+			PubDate:     video.CreatedAt.UTC().Format(time.RFC1123Z),
+			Enclosure: rssEnclosure{
+				URL:  cfg.mediaURL(video.ID),
+				Type: "video/mp4",
+			},
+		}
+		// Videos processed before sizes were recorded report 0, which feed
+		// readers treat as unknown
+		if video.VideoSize != nil {
+			item.Enclosure.Length = *video.VideoSize
+		}
+		if video.DurationSeconds != nil {
+			item.Duration = strconv.Itoa(int(math.Round(*video.DurationSeconds)))
+		}
+		if video.ThumbnailURL != nil {
+			thumbnailURL, err := cfg.signThumbnailURL(*video.ThumbnailURL, clientRegionHint(r))
+			if err == nil {
+				item.Image = &itunesImage{Href: thumbnailURL}
+			}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	dat, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't encode feed", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(xml.Header))
+	w.Write(dat)
+}
+
+// handlerVideoMedia redirects to a presigned URL for the processed video.
+// Like the video itself, it is public unless the video is held by
+// moderation.
+func (cfg *apiConfig) handlerVideoMedia(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.Replica().GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.ModerationStatus == database.ModerationStatusPendingReview || video.ModerationStatus == database.ModerationStatusRejected {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	if !checkNotBlocked(w, video) {
+		return
+	}
+	if video.VideoURL == nil || *video.VideoURL == "" {
+		respondWithError(w, http.StatusNotFound, "Video hasn't been processed yet", nil)
+		return
+	}
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+
+	err = cfg.db.RecordVideoView(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record view", err)
+		return
+	}
+	cfg.meterEgress(video)
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, *signedVideo.VideoURL, http.StatusFound)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type followResponse struct {
+	Following     bool `json:"following"`
+	FollowerCount int  `json:"follower_count"`
+}
+
+// handlerFollow subscribes the user to a creator's new videos.
+func (cfg *apiConfig) handlerFollow(w http.ResponseWriter, r *http.Request) {
+	cfg.setFollowing(w, r, true)
+}
+
+func (cfg *apiConfig) handlerUnfollow(w http.ResponseWriter, r *http.Request) {
+	cfg.setFollowing(w, r, false)
+}
+
+func (cfg *apiConfig) setFollowing(w http.ResponseWriter, r *http.Request, following bool) {
+	creatorID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	if following {
+		if creatorID == userID {
+			respondWithError(w, http.StatusBadRequest, "You can't follow yourself", nil)
+			return
+		}
+		creator, err := cfg.db.GetUser(creatorID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		if creator == nil {
+			respondWithError(w, http.StatusNotFound, "User not found", nil)
+			return
+		}
+		err = cfg.db.FollowCreator(userID, creatorID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't follow user", err)
+			return
+		}
+	} else {
+		err = cfg.db.UnfollowCreator(userID, creatorID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't unfollow user", err)
+			return
+		}
+	}
+
+	followerCount, err := cfg.db.CountFollowers(creatorID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count followers", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, followResponse{
+		Following:     following,
+		FollowerCount: followerCount,
+	})
+}
+
+// handlerSubscriptionVideos lists the published videos of everyone the user
+// follows, newest first.
+func (cfg *apiConfig) handlerSubscriptionVideos(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Videos     []database.Video `json:"videos"`
+		NextCursor *string          `json:"next_cursor"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	filter := database.VideoFilter{
+		Sort:       database.VideoSortCreatedAt,
+		Published:  true,
+		FollowedBy: &userID,
+	}
+
+	// Fetch one extra to know whether there's another page
+	after, limit := pageParams(r)
+	if !checkCursorOrder(w, after, string(filter.Sort), filter.Ascending) {
+		return
+	}
+	if after != nil {
+		filter.After = &after.Cursor
+	}
+	filter.Limit = limit + 1
+
+	videos, err := cfg.db.Replica().GetVideos(userID, filter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	var nextCursor *string
+	if len(videos) > limit {
+		videos = videos[:limit]
+		last := videos[limit-1]
+		cursor := encodeCursor(string(filter.Sort), filter.Ascending, last.SortKey(filter.Sort), last.ID)
+		nextCursor = &cursor
+	}
+
+	signedVideos := make([]database.Video, len(videos))
+	for i, video := range videos {
+		signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+			return
+		}
+		signedVideos[i] = signedVideo
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Videos:     signedVideos,
+		NextCursor: nextCursor,
+	})
+}
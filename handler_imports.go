@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// handlerAdminImportCreate imports the videos in a directory on the import
+// source for a user, in the background. Progress shows in the import list.
+func (cfg *apiConfig) handlerAdminImportCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// Path is the directory, the watched one if it's empty
+		Path  string `json:"path"`
+		Email string `json:"email"`
+	}
+	type response struct {
+		URL    string    `json:"url"`
+		UserID uuid.UUID `json:"user_id"`
+	}
+
+	if cfg.importSource == nil {
+		respondWithError(w, http.StatusNotImplemented, "Imports aren't configured", nil)
+		return
+	}
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	fields := []fieldError{}
+	if params.Path == "" {
+		params.Path = cfg.importDir
+	} else if !strings.HasPrefix(params.Path, "/") {
+		fields = append(fields, fieldError{Field: "path", In: paramInBody, Message: "must be an absolute path"})
+	}
+	if params.Email == "" {
+		fields = append(fields, fieldError{Field: "email", In: paramInBody, Message: "is required"})
+	}
+	if len(fields) > 0 {
+		respondWithFieldErrors(w, http.StatusBadRequest, "Invalid request parameters", fields)
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	dir := path.Clean(params.Path)
+	go func() {
+		err := cfg.importDirectory(dir, user.ID)
+		if err != nil {
+			logging.Errorf("Import from %s failed: %v", cfg.importSource.URL(dir), err)
+		}
+	}()
+	respondWithJSON(w, http.StatusAccepted, response{URL: cfg.importSource.URL(dir), UserID: user.ID})
+}
+
+// handlerAdminImportsList lists the most recently updated imports.
+func (cfg *apiConfig) handlerAdminImportsList(w http.ResponseWriter, r *http.Request) {
+	imports, err := cfg.db.GetImports(importsShown)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get imports", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, imports)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type likeResponse struct {
+	Liked     bool  `json:"liked"`
+	LikeCount int64 `json:"like_count"`
+}
+
+func (cfg *apiConfig) handlerVideoLike(w http.ResponseWriter, r *http.Request) {
+	cfg.setVideoLike(w, r, true)
+}
+
+func (cfg *apiConfig) handlerVideoUnlike(w http.ResponseWriter, r *http.Request) {
+	cfg.setVideoLike(w, r, false)
+}
+
+// setVideoLike likes or unlikes a video for the signed-in user. Both are
+// idempotent, so clients can retry them freely.
+func (cfg *apiConfig) setVideoLike(w http.ResponseWriter, r *http.Request, liked bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	// Videos held by moderation can't be liked
+	if video.ModerationStatus == database.ModerationStatusPendingReview || video.ModerationStatus == database.ModerationStatusRejected {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return
+	}
+	if !checkNotBlocked(w, video) {
+		return
+	}
+
+	if liked {
+		_, err = cfg.db.LikeVideo(videoID, userID)
+	} else {
+		_, err = cfg.db.UnlikeVideo(videoID, userID)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update like", err)
+		return
+	}
+
+	video, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, likeResponse{
+		Liked:     liked,
+		LikeCount: video.LikeCount,
+	})
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// userCloud returns the service named in the path and the authenticated
+// user. It responds with an error and returns false if the user isn't
+// authenticated or the service isn't configured.
+func (cfg *apiConfig) userCloud(w http.ResponseWriter, r *http.Request) (ingest.Cloud, uuid.UUID, bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return nil, uuid.Nil, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return nil, uuid.Nil, false
+	}
+
+	service := r.PathValue("service")
+	cloud := cfg.clouds[service]
+	if cloud == nil {
+		respondWithError(w, http.StatusNotImplemented, fmt.Sprintf("%s imports aren't configured", cloudServiceNames[service]), nil)
+		return nil, uuid.Nil, false
+	}
+	return cloud, userID, true
+}
+
+// userLinkedAccount is userCloud for routes that need the user's account on
+// the service, and returns a token for it.
+func (cfg *apiConfig) userLinkedAccount(w http.ResponseWriter, r *http.Request) (ingest.Cloud, database.LinkedAccount, ingest.Token, bool) {
+	cloud, userID, ok := cfg.userCloud(w, r)
+	if !ok {
+		return nil, database.LinkedAccount{}, ingest.Token{}, false
+	}
+	account, err := cfg.db.GetLinkedAccount(userID, cloud.Name())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get linked account", err)
+		return nil, database.LinkedAccount{}, ingest.Token{}, false
+	}
+	if account.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Account isn't linked", nil)
+		return nil, database.LinkedAccount{}, ingest.Token{}, false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), cloudRequestTimeout)
+	defer cancel()
+	token, err := cfg.linkedAccountToken(ctx, cloud, account)
+	if err != nil {
+		respondWithCloudError(w, "Couldn't refresh the account's token", err)
+		return nil, database.LinkedAccount{}, ingest.Token{}, false
+	}
+	return cloud, account, token, true
+}
+
+// respondWithCloudError responds to a failed request to a cloud storage
+// service. Accounts whose authorization has lapsed have their own code, so
+// clients know to link them again.
+func respondWithCloudError(w http.ResponseWriter, msg string, err error) {
+	switch {
+	case errors.Is(err, ingest.ErrUnauthorized):
+		respondWithErrorCode(w, http.StatusConflict, errCodeLinkedAccountExpired, "Account needs to be linked again", err)
+	case errors.Is(err, fs.ErrNotExist):
+		respondWithError(w, http.StatusNotFound, "File not found", err)
+	default:
+		respondWithError(w, http.StatusBadGateway, msg, err)
+	}
+}
+
+// handlerLinkedAccountsList lists the services the user has linked
+// accounts on.
+func (cfg *apiConfig) handlerLinkedAccountsList(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	accounts, err := cfg.db.GetLinkedAccounts(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get linked accounts", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, accounts)
+}
+
+// handlerLinkedAccountStart begins linking an account. The client sends
+// the user to the returned URL to approve it, and the service sends them
+// back to the callback.
+func (cfg *apiConfig) handlerLinkedAccountStart(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		AuthURL string `json:"auth_url"`
+	}
+
+	cloud, userID, ok := cfg.userCloud(w, r)
+	if !ok {
+		return
+	}
+	nonce, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start linking account", err)
+		return
+	}
+
+	expiresAt := time.Now().Add(cloudLinkTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     cloudLinkCookie,
+		Value:    nonce,
+		Path:     "/api/linked_accounts/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.appBaseURL, "https://"),
+		// The callback is a top-level navigation from the service's site
+		SameSite: http.SameSiteLaxMode,
+	})
+	state := cfg.cloudLinkState(userID, cloud.Name(), nonce, expiresAt)
+	respondWithJSON(w, http.StatusOK, response{AuthURL: cloud.AuthURL(cfg.cloudCallbackURL(cloud.Name()), state)})
+}
+
+// handlerLinkedAccountCallback is where a service sends the user back to.
+// It links the account and sends the user to the app, with linked_account
+// or link_error in the query saying how it went.
+func (cfg *apiConfig) handlerLinkedAccountCallback(w http.ResponseWriter, r *http.Request) {
+	service := r.PathValue("service")
+	cloud := cfg.clouds[service]
+	if cloud == nil {
+		respondWithError(w, http.StatusNotImplemented, fmt.Sprintf("%s imports aren't configured", cloudServiceNames[service]), nil)
+		return
+	}
+
+	cookie, err := r.Cookie(cloudLinkCookie)
+	nonce := ""
+	if err == nil {
+		nonce = cookie.Value
+	}
+	query := r.URL.Query()
+	userID, ok := cfg.verifyCloudLinkState(query.Get("state"), service, nonce)
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Link expired or was started in another browser", nil)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: cloudLinkCookie, Path: "/api/linked_accounts/", MaxAge: -1})
+
+	redirect := func(key, value string) {
+		http.Redirect(w, r, cfg.appBaseURL+"/app/?"+url.Values{key: {value}}.Encode(), http.StatusFound)
+	}
+	// The user turned it down, or the service refused
+	if linkErr := query.Get("error"); linkErr != "" {
+		redirect("link_error", linkErr)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cloudRequestTimeout)
+	defer cancel()
+	token, err := cloud.Exchange(ctx, cfg.cloudCallbackURL(service), query.Get("code"))
+	if err != nil {
+		respondWithCloudError(w, "Couldn't link account", err)
+		return
+	}
+	email, err := cloud.Account(ctx, token)
+	if err != nil {
+		respondWithCloudError(w, "Couldn't get account", err)
+		return
+	}
+	var expiresAt *time.Time
+	if !token.Expiry.IsZero() {
+		expiresAt = &token.Expiry
+	}
+	accessToken, refreshToken, err := cfg.sealCloudTokens(token)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't link account", err)
+		return
+	}
+	_, err = cfg.db.LinkAccount(database.LinkAccountParams{
+		UserID:       userID,
+		Provider:     service,
+		Email:        email,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't link account", err)
+		return
+	}
+	redirect("linked_account", service)
+}
+
+// handlerLinkedAccountDelete unlinks an account, revoking its tokens.
+func (cfg *apiConfig) handlerLinkedAccountDelete(w http.ResponseWriter, r *http.Request) {
+	cloud, userID, ok := cfg.userCloud(w, r)
+	if !ok {
+		return
+	}
+	account, err := cfg.db.GetLinkedAccount(userID, cloud.Name())
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get linked account", err)
+		return
+	}
+	if account.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Account isn't linked", nil)
+		return
+	}
+
+	// The account is unlinked even if the service can't be reached, and
+	// the user can revoke access from the service's settings
+	ctx, cancel := context.WithTimeout(r.Context(), cloudRequestTimeout)
+	defer cancel()
+	token, err := cfg.linkedAccountTokens(account)
+	if err == nil {
+		err = cloud.Revoke(ctx, token)
+	}
+	if err != nil {
+		logging.Warnf("Couldn't revoke %s token of user %s: %v", cloud.Name(), userID, err)
+	}
+	err = cfg.db.DeleteLinkedAccount(account.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't unlink account", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerLinkedAccountFiles lists a folder in a linked account, so the user
+// can pick videos to import. folder is a folder's id, the top folder when
+// it's left out.
+func (cfg *apiConfig) handlerLinkedAccountFiles(w http.ResponseWriter, r *http.Request) {
+	type file struct {
+		ingest.CloudFile
+		// Importable files are videos that can be imported
+		Importable bool `json:"importable"`
+	}
+	type response struct {
+		Files      []file  `json:"files"`
+		NextCursor *string `json:"next_cursor"`
+	}
+
+	cloud, _, token, ok := cfg.userLinkedAccount(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), cloudRequestTimeout)
+	defer cancel()
+	page, err := cloud.List(ctx, token, r.URL.Query().Get("folder"), r.URL.Query().Get("cursor"))
+	if err != nil {
+		respondWithCloudError(w, "Couldn't list files", err)
+		return
+	}
+
+	resp := response{Files: []file{}}
+	for _, f := range page.Files {
+		resp.Files = append(resp.Files, file{CloudFile: f, Importable: cloudFileImportable(f)})
+	}
+	if page.Cursor != "" {
+		resp.NextCursor = &page.Cursor
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerLinkedAccountImport imports a file from a linked account as a new
+// video, downloading it in the background. Progress shows in the user's
+// import list and then on the video.
+func (cfg *apiConfig) handlerLinkedAccountImport(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		FileID string `json:"file_id"`
+	}
+
+	cloud, account, token, ok := cfg.userLinkedAccount(w, r)
+	if !ok {
+		return
+	}
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.FileID == "" {
+		respondWithFieldErrors(w, http.StatusBadRequest, "Invalid request parameters", []fieldError{
+			{Field: "file_id", In: paramInBody, Message: "is required"},
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), cloudRequestTimeout)
+	defer cancel()
+	file, err := cloud.Stat(ctx, token, params.FileID)
+	if err != nil {
+		respondWithCloudError(w, "Couldn't get file", err)
+		return
+	}
+	if !cloudFileImportable(file) {
+		respondWithFieldErrors(w, http.StatusUnprocessableEntity, "File can't be imported", []fieldError{
+			{Field: "file_id", In: paramInBody, Message: "must be an MP4 video"},
+		})
+		return
+	}
+	if file.Size > maxVideoUploadSize {
+		respondWithTooLarge(w, maxVideoUploadSize)
+		return
+	}
+	if !cfg.checkUploadQuota(w, account.UserID) || !cfg.checkStorageQuota(w, account.UserID) {
+		return
+	}
+
+	claimed, err := cfg.db.ClaimImport(database.CreateImportParams{
+		Source:     cloud.Name(),
+		URL:        cloud.FileURL(account.Email, file.ID),
+		Size:       file.Size,
+		ModifiedAt: file.ModifiedAt,
+		UserID:     account.UserID,
+	}, importMaxAttempts, time.Now().Add(-importRunTimeout))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create import", err)
+		return
+	}
+	if claimed.ID == uuid.Nil {
+		respondWithError(w, http.StatusConflict, "File is already imported or being imported", nil)
+		return
+	}
+
+	go cfg.importCloudFile(cloud, account, file, claimed)
+	respondWithJSON(w, http.StatusAccepted, claimed)
+}
+
+// handlerImportsList lists the user's most recently updated imports.
+func (cfg *apiConfig) handlerImportsList(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	imports, err := cfg.db.GetImportsForUser(userID, importsShown)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get imports", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, imports)
+}
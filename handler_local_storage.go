@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// handlerLocalStorageGet serves an object from local storage to anyone with
+// a URL presigned for it that hasn't expired, like an S3 presigned URL.
+// Range requests are answered so videos can be seeked.
+func (cfg *apiConfig) handlerLocalStorageGet(w http.ResponseWriter, r *http.Request) {
+	bucket, key := r.PathValue("bucket"), r.PathValue("key")
+	if !cfg.localStorage.verifyGet(bucket, key, r.URL.Query()) {
+		respondWithError(w, http.StatusForbidden, "Invalid or expired signature", nil)
+		return
+	}
+	objectPath, info, meta, err := cfg.localStorage.stat(bucket, key)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Object not found", err)
+		return
+	}
+	file, err := os.Open(objectPath)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Object not found", err)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	contentType := meta.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", meta.ETag)
+	for name, value := range map[string]string{
+		"Cache-Control":       meta.CacheControl,
+		"Content-Disposition": meta.ContentDisposition,
+		"Content-Language":    meta.ContentLanguage,
+	} {
+		if value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	http.ServeContent(w, r, "", info.ModTime(), file)
+}
+
+// handlerLocalStoragePost accepts a browser upload to local storage with the
+// fields of a presigned POST, like an S3 POST policy upload. The file must
+// be the last field.
+func (cfg *apiConfig) handlerLocalStoragePost(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	reader, err := r.MultipartReader()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse form", err)
+		return
+	}
+
+	fields := map[string]string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			respondWithError(w, http.StatusBadRequest, "Couldn't parse form", errors.New("no file field"))
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Couldn't parse form", err)
+			return
+		}
+		name := strings.ToLower(part.FormName())
+		if name != "file" {
+			value, err := io.ReadAll(io.LimitReader(part, maxUploadFieldSize))
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "Couldn't parse form", err)
+				return
+			}
+			fields[name] = string(value)
+			continue
+		}
+
+		sizes, err := cfg.localStorage.verifyPost(bucket, fields)
+		if err != nil {
+			respondWithError(w, http.StatusForbidden, "Upload doesn't match its policy", err)
+			return
+		}
+		key := fields["key"]
+		_, size, err := cfg.localStorage.store(bucket, key, part, localObjectMeta{
+			ContentType:        fields["content-type"],
+			CacheControl:       fields["cache-control"],
+			ContentDisposition: fields["content-disposition"],
+			ContentLanguage:    fields["content-language"],
+			ChecksumAlgorithm:  cfg.checksumAlgorithm,
+		}, sizes.max)
+		if errors.Is(err, errLocalUploadTooLarge) {
+			respondWithTooLarge(w, sizes.max)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't store upload", err)
+			return
+		}
+		if size < sizes.min {
+			cfg.deleteObject(bucket, key)
+			respondWithError(w, http.StatusBadRequest, "Upload is smaller than its policy allows", nil)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+)
+
+type logLevelResponse struct {
+	Level       string `json:"level"`
+	SampleEvery int    `json:"sample_every"`
+}
+
+func currentLogLevel() logLevelResponse {
+	return logLevelResponse{
+		Level:       logging.GetLevel().String(),
+		SampleEvery: logging.GetSampling(),
+	}
+}
+
+func (cfg *apiConfig) handlerAdminLogLevelGet(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, currentLogLevel())
+}
+
+// handlerAdminLogLevelSet changes the log level and sampling until the next
+// restart or settings reload. Fields left out are unchanged.
+func (cfg *apiConfig) handlerAdminLogLevelSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Level       *string `json:"level"`
+		SampleEvery *int    `json:"sample_every"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	var level logging.Level
+	if params.Level != nil {
+		level, err = logging.ParseLevel(*params.Level)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Level must be debug, info, warn or error", err)
+			return
+		}
+	}
+	if params.SampleEvery != nil && *params.SampleEvery < 1 {
+		respondWithError(w, http.StatusBadRequest, "Sample every must be a positive integer", nil)
+		return
+	}
+
+	if params.Level != nil {
+		logging.SetLevel(level)
+	}
+	if params.SampleEvery != nil {
+		logging.SetSampling(*params.SampleEvery)
+	}
+	// Logged without a level so the change is recorded whatever the level
+	log.Printf("Log level set to %s, sampling every %d", logging.GetLevel(), logging.GetSampling())
+
+	respondWithJSON(w, http.StatusOK, currentLogLevel())
+}
@@ -30,13 +30,13 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 
 	user, err := cfg.db.GetUserByEmail(params.Email)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
+		respondWithErrorCode(w, http.StatusUnauthorized, errCodeInvalidCredentials, "Incorrect email or password", err)
 		return
 	}
 
 	err = auth.CheckPasswordHash(params.Password, user.Password)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Incorrect email or password", err)
+		respondWithErrorCode(w, http.StatusUnauthorized, errCodeInvalidCredentials, "Incorrect email or password", err)
 		return
 	}
 
@@ -66,8 +66,14 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	signedUser, err := cfg.dbUserToSignedUser(user, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+
 	respondWithJSON(w, http.StatusOK, response{
-		User:         user,
+		User:         signedUser,
 		Token:        accessToken,
 		RefreshToken: refreshToken,
 	})
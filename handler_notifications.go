@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/gorilla/websocket"
+)
+
+// Notification socket timings. Pings keep idle connections open through
+// proxies and detect clients that went away.
+const (
+	notificationWriteTimeout = 10 * time.Second
+	notificationPongTimeout  = 60 * time.Second
+	notificationPingInterval = 30 * time.Second
+)
+
+// The default origin check only accepts pages served from the same host
+var notificationUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// handlerNotifications upgrades to a WebSocket that pushes the user's events
+// as JSON messages, such as a video finishing or failing processing. The
+// JWT can be passed as a "token" query parameter, since browsers can't set
+// headers on WebSocket requests.
+func (cfg *apiConfig) handlerNotifications(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	// Upgrade writes its own error response
+	conn, err := notificationUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logging.Warnf("Couldn't upgrade notification socket: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	client := cfg.notifications.register(userID)
+	defer cfg.notifications.unregister(userID, client)
+
+	// Clients only send control frames; reading handles pongs and notices
+	// when the connection closes
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(notificationPongTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(notificationPongTimeout))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(notificationPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case message := <-client.send:
+			conn.SetWriteDeadline(time.Now().Add(notificationWriteTimeout))
+			if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(notificationWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-client.closed:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too slow"), time.Now().Add(notificationWriteTimeout))
+			return
+		case <-readDone:
+			return
+		}
+	}
+}
+
+// notificationChannelPreference is how a user set up one channel.
+type notificationChannelPreference struct {
+	Enabled bool `json:"enabled"`
+	// Available is false for channels this server can't deliver on, such
+	// as email when no mailer is configured
+	Available bool `json:"available"`
+	// WebhookURL is only set for the slack channel
+	WebhookURL *string `json:"webhook_url,omitempty"`
+}
+
+type notificationPreferencesResponse struct {
+	Channels map[string]notificationChannelPreference `json:"channels"`
+	// Events has whether each event type goes out on each channel, by the
+	// user's choice or by default
+	Events map[string]map[string]bool `json:"events"`
+}
+
+func (cfg *apiConfig) notificationPreferences(settings database.NotificationSettings) notificationPreferencesResponse {
+	available := cfg.notifier.availableNotificationChannels()
+	response := notificationPreferencesResponse{
+		Channels: map[string]notificationChannelPreference{},
+		Events:   map[string]map[string]bool{},
+	}
+	for _, channel := range notificationChannels {
+		setting, ok := settings.Channels[channel]
+		preference := notificationChannelPreference{
+			Enabled:   !ok || setting.Enabled,
+			Available: slices.Contains(available, channel),
+		}
+		if channel == channelSlack {
+			webhookURL := setting.Target
+			preference.WebhookURL = &webhookURL
+		}
+		response.Channels[channel] = preference
+	}
+	for _, eventType := range notificationEventTypes() {
+		response.Events[eventType] = map[string]bool{}
+		for _, channel := range notificationChannels {
+			response.Events[eventType][channel] = wantsNotification(settings, eventType, channel)
+		}
+	}
+	return response
+}
+
+func (cfg *apiConfig) handlerNotificationPreferencesGet(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	settings, err := cfg.db.GetNotificationSettings(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get notification preferences", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.notificationPreferences(settings))
+}
+
+// handlerNotificationPreferencesUpdate changes how the user is notified:
+// turning channels on or off, connecting Slack, and choosing the channels
+// each event type goes out on. Anything left out keeps its current value.
+func (cfg *apiConfig) handlerNotificationPreferencesUpdate(w http.ResponseWriter, r *http.Request) {
+	type channelParameters struct {
+		Enabled *bool `json:"enabled"`
+		// WebhookURL connects the slack channel, or disconnects it when
+		// empty
+		WebhookURL *string `json:"webhook_url"`
+	}
+	type parameters struct {
+		Channels map[string]channelParameters `json:"channels"`
+		Events   map[string]map[string]bool   `json:"events"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	settings, err := cfg.db.GetNotificationSettings(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get notification preferences", err)
+		return
+	}
+	for channel, change := range params.Channels {
+		if !slices.Contains(notificationChannels, channel) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown channel %q", channel), nil)
+			return
+		}
+		setting, ok := settings.Channels[channel]
+		if !ok {
+			setting.Enabled = true
+		}
+		if change.Enabled != nil {
+			setting.Enabled = *change.Enabled
+		}
+		if change.WebhookURL != nil {
+			if channel != channelSlack {
+				respondWithError(w, http.StatusBadRequest, "Only the slack channel takes a webhook URL", nil)
+				return
+			}
+			if *change.WebhookURL != "" && !validSlackWebhookURL(*change.WebhookURL) {
+				respondWithError(w, http.StatusBadRequest, "Slack webhook URL must be an https://hooks.slack.com URL", nil)
+				return
+			}
+			setting.Target = *change.WebhookURL
+		}
+		settings.Channels[channel] = setting
+	}
+	for eventType, channels := range params.Events {
+		if _, ok := notificationTypes[eventType]; !ok {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown event %q", eventType), nil)
+			return
+		}
+		for channel, enabled := range channels {
+			if !slices.Contains(notificationChannels, channel) {
+				respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown channel %q", channel), nil)
+				return
+			}
+			if settings.Events[eventType] == nil {
+				settings.Events[eventType] = map[string]bool{}
+			}
+			settings.Events[eventType][channel] = enabled
+		}
+	}
+
+	err = cfg.db.SetNotificationSettings(userID, settings)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update notification preferences", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.notificationPreferences(settings))
+}
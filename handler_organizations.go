@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxOrganizationNameLength = 100
+
+func (cfg *apiConfig) handlerOrganizationCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name string `json:"name"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	name := strings.TrimSpace(params.Name)
+	if name == "" || len([]rune(name)) > maxOrganizationNameLength {
+		respondWithError(w, http.StatusBadRequest, "Organization name must be 1-100 characters", nil)
+		return
+	}
+
+	// The creator becomes the organization's first owner
+	org, err := cfg.db.CreateOrganization(name, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create organization", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, database.OrganizationMembership{
+		Organization: org,
+		Role:         database.OrganizationRoleOwner,
+	})
+}
+
+func (cfg *apiConfig) handlerOrganizationsList(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	memberships, err := cfg.db.GetOrganizationsForUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organizations", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, memberships)
+}
+
+func (cfg *apiConfig) handlerOrganizationMembersList(w http.ResponseWriter, r *http.Request) {
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	// Any member can see who else is in the organization
+	role, err := cfg.db.GetOrganizationRole(orgID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization role", err)
+		return
+	}
+	if role == "" {
+		respondWithError(w, http.StatusNotFound, "Organization not found", nil)
+		return
+	}
+
+	members, err := cfg.db.GetOrganizationMembers(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization members", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+// handlerOrganizationMemberSet adds a registered user to the organization by
+// email, or changes the role of an existing member.
+func (cfg *apiConfig) handlerOrganizationMemberSet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string                    `json:"email"`
+		Role  database.OrganizationRole `json:"role"`
+	}
+
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	role, err := cfg.db.GetOrganizationRole(orgID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization role", err)
+		return
+	}
+	if role == "" {
+		respondWithError(w, http.StatusNotFound, "Organization not found", nil)
+		return
+	}
+	if role != database.OrganizationRoleOwner {
+		respondWithError(w, http.StatusForbidden, "Only organization owners can manage members", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !validOrganizationRole(params.Role) {
+		respondWithError(w, http.StatusBadRequest, "Role must be owner, editor or viewer", nil)
+		return
+	}
+
+	member, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if member.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	// Demoting the last owner would leave nobody able to manage members
+	if params.Role != database.OrganizationRoleOwner {
+		ok, err := cfg.keepsAnOwner(orgID, member.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't count organization owners", err)
+			return
+		}
+		if !ok {
+			respondWithError(w, http.StatusConflict, "An organization must keep at least one owner", nil)
+			return
+		}
+	}
+
+	err = cfg.db.SetOrganizationMember(orgID, member.ID, params.Role)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update organization member", err)
+		return
+	}
+
+	members, err := cfg.db.GetOrganizationMembers(orgID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization members", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, members)
+}
+
+// handlerOrganizationMemberDelete removes a member. Owners can remove anyone;
+// other members can only remove themselves.
+func (cfg *apiConfig) handlerOrganizationMemberDelete(w http.ResponseWriter, r *http.Request) {
+	orgID, err := uuid.Parse(r.PathValue("orgID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+		return
+	}
+	memberID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	role, err := cfg.db.GetOrganizationRole(orgID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get organization role", err)
+		return
+	}
+	if role == "" {
+		respondWithError(w, http.StatusNotFound, "Organization not found", nil)
+		return
+	}
+	if role != database.OrganizationRoleOwner && memberID != userID {
+		respondWithError(w, http.StatusForbidden, "Only organization owners can manage members", nil)
+		return
+	}
+
+	ok, err := cfg.keepsAnOwner(orgID, memberID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count organization owners", err)
+		return
+	}
+	if !ok {
+		respondWithError(w, http.StatusConflict, "An organization must keep at least one owner", nil)
+		return
+	}
+
+	err = cfg.db.DeleteOrganizationMember(orgID, memberID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove organization member", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// keepsAnOwner reports whether the organization still has an owner once
+// memberID stops being one.
+func (cfg *apiConfig) keepsAnOwner(orgID, memberID uuid.UUID) (bool, error) {
+	role, err := cfg.db.GetOrganizationRole(orgID, memberID)
+	if err != nil {
+		return false, err
+	}
+	if role != database.OrganizationRoleOwner {
+		return true, nil
+	}
+	owners, err := cfg.db.CountOrganizationOwners(orgID)
+	if err != nil {
+		return false, err
+	}
+	return owners > 1, nil
+}
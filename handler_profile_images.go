@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// maxProfileImageUploadSize caps avatar and banner uploads before they're
+// scaled down.
+const maxProfileImageUploadSize = 10 << 20
+
+// profileImageKind is one of the images on a user's profile. Uploads are
+// scaled down to fit within maxWidth by maxHeight.
+type profileImageKind struct {
+	// name is also the form field the image is uploaded in
+	name      string
+	maxWidth  int
+	maxHeight int
+}
+
+var (
+	profileImageAvatar           = profileImageKind{name: "avatar", maxWidth: 512, maxHeight: 512}
+	profileImageBanner           = profileImageKind{name: "banner", maxWidth: 2560, maxHeight: 1440}
+	profileImageDefaultThumbnail = profileImageKind{name: "default_thumbnail", maxWidth: 1280, maxHeight: 720}
+)
+
+var profileImageKinds = []profileImageKind{profileImageAvatar, profileImageBanner, profileImageDefaultThumbnail}
+
+func (kind profileImageKind) get(user database.User) *string {
+	switch kind {
+	case profileImageBanner:
+		return user.BannerURL
+	case profileImageDefaultThumbnail:
+		return user.DefaultThumbnailURL
+	default:
+		return user.AvatarURL
+	}
+}
+
+func (kind profileImageKind) set(user *database.User, imageURL *string) {
+	switch kind {
+	case profileImageBanner:
+		user.BannerURL = imageURL
+	case profileImageDefaultThumbnail:
+		user.DefaultThumbnailURL = imageURL
+	default:
+		user.AvatarURL = imageURL
+	}
+}
+
+func (cfg *apiConfig) handlerUploadAvatar(w http.ResponseWriter, r *http.Request) {
+	if user, ok := cfg.uploadProfileImage(w, r, profileImageAvatar); ok {
+		cfg.respondWithSignedUser(w, r, user)
+	}
+}
+
+func (cfg *apiConfig) handlerUploadBanner(w http.ResponseWriter, r *http.Request) {
+	if user, ok := cfg.uploadProfileImage(w, r, profileImageBanner); ok {
+		cfg.respondWithSignedUser(w, r, user)
+	}
+}
+
+func (cfg *apiConfig) handlerDeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	if user, ok := cfg.deleteProfileImage(w, r, profileImageAvatar); ok {
+		cfg.respondWithSignedUser(w, r, user)
+	}
+}
+
+func (cfg *apiConfig) handlerDeleteBanner(w http.ResponseWriter, r *http.Request) {
+	if user, ok := cfg.deleteProfileImage(w, r, profileImageBanner); ok {
+		cfg.respondWithSignedUser(w, r, user)
+	}
+}
+
+// uploadProfileImage replaces one of the user's profile images with the
+// uploaded JPEG or PNG, scaled down and optimized like thumbnails are, and
+// returns the updated user. Images are shown without review, so flagged ones
+// aren't accepted. If it fails it responds itself and returns false.
+func (cfg *apiConfig) uploadProfileImage(w http.ResponseWriter, r *http.Request, kind profileImageKind) (database.User, bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.User{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.User{}, false
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxProfileImageUploadSize)
+	const maxMemory = 10 << 20
+	err = r.ParseMultipartForm(maxMemory)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse form", err)
+		return database.User{}, false
+	}
+	file, header, err := r.FormFile(kind.name)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't get file from form", err)
+		return database.User{}, false
+	}
+	defer file.Close()
+
+	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type header", err)
+		return database.User{}, false
+	}
+	var fileExtension string
+	switch mediaType {
+	case "image/jpeg":
+		fileExtension = "jpg"
+	case "image/png":
+		fileExtension = "png"
+	default:
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeUnsupportedImage, "Invalid file type. Only JPEG and PNG images are allowed", nil)
+		return database.User{}, false
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return database.User{}, false
+	}
+
+	originalData, err := io.ReadAll(file)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read file", err)
+		return database.User{}, false
+	}
+	data, err := fitImage(originalData, mediaType, kind.maxWidth, kind.maxHeight, cfg.thumbnailJPEGQuality)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid image data", err)
+		return database.User{}, false
+	}
+	logging.Debugf("Scaled %s for user %s: %d -> %d bytes", kind.name, userID, len(originalData), len(data))
+
+	if flagged, _ := cfg.classifyImage(fmt.Sprintf("%s for user %s", kind.name, userID), data); flagged {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, errCodeModerationRejected, "Image was flagged by moderation", nil)
+		return database.User{}, false
+	}
+
+	imageURL, err := cfg.storeImage(data, mediaType, fileExtension, "profile_images")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store image", err)
+		return database.User{}, false
+	}
+
+	previousURL := kind.get(*user)
+	kind.set(user, &imageURL)
+	err = cfg.db.UpdateUserProfileImages(*user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return database.User{}, false
+	}
+	if previousURL != nil && *previousURL != imageURL {
+		cfg.releaseProfileImage(*previousURL)
+	}
+
+	return *user, true
+}
+
+// deleteProfileImage removes one of the user's profile images and returns
+// the updated user. If it fails it responds itself and returns false.
+func (cfg *apiConfig) deleteProfileImage(w http.ResponseWriter, r *http.Request, kind profileImageKind) (database.User, bool) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.User{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.User{}, false
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return database.User{}, false
+	}
+	previousURL := kind.get(*user)
+	if previousURL == nil {
+		return *user, true
+	}
+
+	kind.set(user, nil)
+	err = cfg.db.UpdateUserProfileImages(*user)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update user", err)
+		return database.User{}, false
+	}
+	cfg.releaseProfileImage(*previousURL)
+
+	return *user, true
+}
+
+func (cfg *apiConfig) respondWithSignedUser(w http.ResponseWriter, r *http.Request, user database.User) {
+	signedUser, err := cfg.dbUserToSignedUser(user, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, signedUser)
+}
+
+// dbUserToSignedUser presigns the user's stored profile images.
+func (cfg *apiConfig) dbUserToSignedUser(user database.User, regionHint string) (database.User, error) {
+	for _, kind := range profileImageKinds {
+		imageURL := kind.get(user)
+		if imageURL == nil {
+			continue
+		}
+		signedURL, err := cfg.signThumbnailURL(*imageURL, regionHint)
+		if err != nil {
+			return database.User{}, err
+		}
+		kind.set(&user, &signedURL)
+	}
+	return user, nil
+}
+
+// releaseProfileImage deletes a profile image no user or video refers to
+// any more. Failures are only logged: the worst case is an orphaned image.
+func (cfg *apiConfig) releaseProfileImage(imageURL string) {
+	storage, location, ok := cfg.thumbnailAsset(imageURL)
+	if !ok {
+		return
+	}
+	profiles, err := cfg.db.CountProfileImageReferences(imageURL)
+	if err != nil {
+		logging.Warnf("Couldn't check references to image %s: %v", imageURL, err)
+		return
+	}
+	// No asset belongs to the nil video, so every reference is counted
+	assets, err := cfg.db.CountOtherAssetReferences(database.CreateAssetParams{
+		VideoID:  uuid.Nil,
+		Storage:  storage,
+		Location: location,
+	})
+	if err != nil {
+		logging.Warnf("Couldn't check references to image %s: %v", imageURL, err)
+		return
+	}
+	if profiles > 0 || assets > 0 {
+		return
+	}
+	err = cfg.deleteStoredObject(storage, location)
+	if err != nil {
+		logging.Warnf("Couldn't delete image %s: %v", imageURL, err)
+	}
+}
@@ -19,7 +19,7 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	}
 
 	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
-	if err != nil {
+	if err != nil || user == nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
 		return
 	}
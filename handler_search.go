@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxSearchQueryLength caps the q parameter of a search, in bytes.
+const maxSearchQueryLength = 200
+
+// searchCursorSort is the order search cursors are issued for. Relevance
+// has no key to continue after, so their key is how many hits were served.
+const searchCursorSort = "relevance"
+
+// handlerSearchVideos finds published videos whose title, tags or
+// description match the q parameter, most relevant first. Like channel
+// pages, it needs no authentication.
+func (cfg *apiConfig) handlerSearchVideos(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Videos     []database.Video `json:"videos"`
+		NextCursor *string          `json:"next_cursor"`
+	}
+
+	if cfg.searchIndexer == nil {
+		respondWithError(w, http.StatusNotImplemented, "Search isn't configured", nil)
+		return
+	}
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	// Fetch one extra to know whether there's another page
+	after, limit := pageParams(r)
+	if !checkCursorOrder(w, after, searchCursorSort, false) {
+		return
+	}
+	offset := 0
+	if after != nil {
+		if served, ok := after.Key.(float64); ok && served > 0 {
+			offset = int(served)
+		}
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), searchIndexTimeout)
+	defer cancel()
+	hits, err := cfg.searchIndexer.index.Search(ctx, query, offset, limit+1)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search videos", err)
+		return
+	}
+	var nextCursor *string
+	if len(hits) > limit {
+		hits = hits[:limit]
+		cursor := encodeCursor(searchCursorSort, false, float64(offset+limit), uuid.Nil)
+		nextCursor = &cursor
+	}
+
+	ids := make([]uuid.UUID, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	videos, err := cfg.db.Replica().GetVideosByIDs(ids)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	videosByID := make(map[uuid.UUID]database.Video, len(videos))
+	for _, video := range videos {
+		videosByID[video.ID] = video
+	}
+
+	// The index can lag behind the database, so hits that aren't published
+	// any more are left out rather than shown
+	signedVideos := []database.Video{}
+	for _, hit := range hits {
+		video, ok := videosByID[hit.ID]
+		if !ok || !video.IsPublished() {
+			continue
+		}
+		signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+			return
+		}
+		signedVideos = append(signedVideos, signedVideo)
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Videos:     signedVideos,
+		NextCursor: nextCursor,
+	})
+}
+
+// handlerAdminSearchReindex empties the search index and indexes every
+// published video again in the background, to recover from changes the
+// index missed.
+func (cfg *apiConfig) handlerAdminSearchReindex(w http.ResponseWriter, r *http.Request) {
+	if cfg.searchIndexer == nil {
+		respondWithError(w, http.StatusNotImplemented, "Search isn't configured", nil)
+		return
+	}
+	if !cfg.searchIndexer.rebuildInBackground(cfg.db) {
+		respondWithError(w, http.StatusConflict, "The search index is already being rebuilt", nil)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// querySearchText requires the text to search for.
+func querySearchText(name string) paramRule {
+	return paramRule{in: paramInQuery, name: name, required: true, check: func(value string) string {
+		if strings.TrimSpace(value) == "" {
+			return "must not be blank"
+		}
+		if len(value) > maxSearchQueryLength {
+			return fmt.Sprintf("must be at most %d bytes", maxSearchQueryLength)
+		}
+		return ""
+	}}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Limits on share links
+const (
+	defaultShareExpiry = 7 * 24 * time.Hour
+	maxShareExpiry     = 30 * 24 * time.Hour
+	// bcrypt ignores anything past 72 bytes
+	maxSharePasswordLength = 72
+)
+
+// sharePasswordHeader carries the password for password-protected links.
+const sharePasswordHeader = "X-Share-Password"
+
+type shareResponse struct {
+	database.Share
+	URL               string `json:"url"`
+	PasswordProtected bool   `json:"password_protected"`
+}
+
+func (cfg *apiConfig) shareURL(token string) string {
+	return fmt.Sprintf("http://localhost:%s/api/shares/%s", cfg.port, token)
+}
+
+func (cfg *apiConfig) newShareResponse(share database.Share) shareResponse {
+	return shareResponse{
+		Share:             share,
+		URL:               cfg.shareURL(share.Token),
+		PasswordProtected: share.PasswordHash != nil,
+	}
+}
+
+func (cfg *apiConfig) handlerShareCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// ExpiresInSeconds defaults to a week and is capped at 30 days
+		ExpiresInSeconds *int64  `json:"expires_in_seconds"`
+		MaxViews         *int64  `json:"max_views"`
+		Password         *string `json:"password"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	canEdit, err := cfg.userCanEditVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized to share this video", nil)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	expiry := defaultShareExpiry
+	if params.ExpiresInSeconds != nil {
+		expiry = time.Duration(*params.ExpiresInSeconds) * time.Second
+		if expiry <= 0 || expiry > maxShareExpiry {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("expires_in_seconds must be between 1 and %d", int64(maxShareExpiry.Seconds())), nil)
+			return
+		}
+	}
+	if params.MaxViews != nil && *params.MaxViews < 1 {
+		respondWithError(w, http.StatusBadRequest, "max_views must be at least 1", nil)
+		return
+	}
+
+	var passwordHash *string
+	if params.Password != nil {
+		if *params.Password == "" || len(*params.Password) > maxSharePasswordLength {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Password must be 1-%d bytes", maxSharePasswordLength), nil)
+			return
+		}
+		hash, err := auth.HashPassword(*params.Password)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+			return
+		}
+		passwordHash = &hash
+	}
+
+	shareToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share token", err)
+		return
+	}
+
+	share, err := cfg.db.CreateShare(database.CreateShareParams{
+		VideoID:      video.ID,
+		Token:        shareToken,
+		ExpiresAt:    time.Now().Add(expiry),
+		MaxViews:     params.MaxViews,
+		PasswordHash: passwordHash,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create share", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, cfg.newShareResponse(share))
+}
+
+func (cfg *apiConfig) handlerSharesList(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	canEdit, err := cfg.userCanEditVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized to view this video's shares", nil)
+		return
+	}
+
+	shares, err := cfg.db.GetShares(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get shares", err)
+		return
+	}
+
+	responses := make([]shareResponse, len(shares))
+	for i, share := range shares {
+		responses[i] = cfg.newShareResponse(share)
+	}
+
+	respondWithJSON(w, http.StatusOK, responses)
+}
+
+// handlerShareDelete revokes a share link.
+func (cfg *apiConfig) handlerShareDelete(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	shareID, err := uuid.Parse(r.PathValue("shareID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid share ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	canEdit, err := cfg.userCanEditVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized to update this video", nil)
+		return
+	}
+
+	share, err := cfg.db.GetShare(shareID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share", err)
+		return
+	}
+	if share.ID == uuid.Nil || share.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Share not found", nil)
+		return
+	}
+
+	err = cfg.db.DeleteShare(share.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete share", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// openShare validates a share token and password and counts the view. It
+// responds with an error and returns false if the link can't be used.
+func (cfg *apiConfig) openShare(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	share, err := cfg.db.GetShareByToken(r.PathValue("token"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get share", err)
+		return database.Video{}, false
+	}
+	if share.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Share not found", nil)
+		return database.Video{}, false
+	}
+
+	now := time.Now()
+	if !share.ExpiresAt.After(now) {
+		respondWithErrorCode(w, http.StatusGone, errCodeShareExpired, "Share link has expired", nil)
+		return database.Video{}, false
+	}
+
+	// Check the password before counting anything, so wrong guesses don't
+	// use up the link's views
+	if share.PasswordHash != nil {
+		password := r.Header.Get(sharePasswordHeader)
+		if password == "" {
+			respondWithErrorCode(w, http.StatusUnauthorized, errCodePasswordRequired, "Share link requires a password", nil)
+			return database.Video{}, false
+		}
+		err := auth.CheckPasswordHash(password, *share.PasswordHash)
+		if err != nil {
+			respondWithErrorCode(w, http.StatusUnauthorized, errCodeIncorrectPassword, "Incorrect share password", nil)
+			return database.Video{}, false
+		}
+	}
+
+	video, err := cfg.db.GetVideo(share.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	// Videos held by moderation can't be shared
+	if video.ModerationStatus == database.ModerationStatusPendingReview || video.ModerationStatus == database.ModerationStatusRejected {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return database.Video{}, false
+	}
+	if !checkNotBlocked(w, video) {
+		return database.Video{}, false
+	}
+
+	counted, err := cfg.db.RecordShareView(share.ID, now)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record share view", err)
+		return database.Video{}, false
+	}
+	if !counted {
+		respondWithErrorCode(w, http.StatusGone, errCodeShareViewsExceeded, "Share link has no views left", nil)
+		return database.Video{}, false
+	}
+
+	return video, true
+}
+
+// handlerShareGet is the public side of a share link: it returns the video
+// with presigned URLs. Each request counts as one view.
+func (cfg *apiConfig) handlerShareGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.openShare(w, r)
+	if !ok {
+		return
+	}
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
+
+// handlerShareStream redirects a share link straight to the presigned video,
+// so it can be used as a player source. Each request counts as one view.
+func (cfg *apiConfig) handlerShareStream(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.openShare(w, r)
+	if !ok {
+		return
+	}
+	if video.VideoURL == nil || *video.VideoURL == "" {
+		respondWithError(w, http.StatusConflict, "Video hasn't been processed yet", nil)
+		return
+	}
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+
+	err = cfg.db.RecordVideoView(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record view", err)
+		return
+	}
+	cfg.meterEgress(video)
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, *signedVideo.VideoURL, http.StatusFound)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxStorageMigrationAgeDays bounds older_than_days.
+const maxStorageMigrationAgeDays = 36500
+
+// handlerAdminStorageMigrationCreate starts moving the S3 objects of videos
+// created more than older_than_days ago to another storage class,
+// optionally only one user's or one object class. Poll the migration for
+// its progress. Videos under legal hold are left alone, as are objects the
+// asset sizing job hasn't found yet.
+func (cfg *apiConfig) handlerAdminStorageMigrationCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		StorageClass  string     `json:"storage_class"`
+		OlderThanDays int        `json:"older_than_days"`
+		UserID        *uuid.UUID `json:"user_id"`
+		ObjectClass   string     `json:"object_class"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	fields := []fieldError{}
+	// Storage classes are S3's, which GCS, Azure and some S3-compatible
+	// providers don't have
+	unsupportedBy := ""
+	if cfg.storageBackend == storageBackendGCS || cfg.storageBackend == storageBackendAzure {
+		unsupportedBy = cfg.storageBackend
+	} else if !cfg.s3Provider.storageClasses {
+		unsupportedBy = cfg.s3Provider.name
+	}
+	if unsupportedBy != "" {
+		fields = append(fields, fieldError{Field: "storage_class", In: paramInBody,
+			Message: fmt.Sprintf("isn't supported by the %s provider", unsupportedBy)})
+	} else if msg := checkOneOf(storageClasses)(params.StorageClass); msg != "" {
+		fields = append(fields, fieldError{Field: "storage_class", In: paramInBody, Message: msg})
+	}
+	if msg := checkIntRange(0, maxStorageMigrationAgeDays)(strconv.Itoa(params.OlderThanDays)); msg != "" {
+		fields = append(fields, fieldError{Field: "older_than_days", In: paramInBody, Message: msg})
+	}
+	classNames := make([]string, len(objectClasses))
+	for i, class := range objectClasses {
+		classNames[i] = string(class)
+	}
+	if params.ObjectClass != "" {
+		if msg := checkOneOf(classNames)(params.ObjectClass); msg != "" {
+			fields = append(fields, fieldError{Field: "object_class", In: paramInBody, Message: msg})
+		}
+	}
+	if slices.Contains(archiveStorageClasses, params.StorageClass) && params.ObjectClass != string(objectClassOriginal) {
+		fields = append(fields, fieldError{Field: "object_class", In: paramInBody,
+			Message: fmt.Sprintf("must be %s for %s", objectClassOriginal, params.StorageClass)})
+	}
+	if len(fields) > 0 {
+		respondWithFieldErrors(w, http.StatusBadRequest, "Invalid request parameters", fields)
+		return
+	}
+
+	migration, err := cfg.db.CreateStorageMigration(database.CreateStorageMigrationParams{
+		StorageClass:  params.StorageClass,
+		CreatedBefore: time.Now().AddDate(0, 0, -params.OlderThanDays),
+		UserID:        params.UserID,
+		ObjectClass:   params.ObjectClass,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create storage migration", err)
+		return
+	}
+	cfg.startStorageMigration(migration)
+
+	respondWithJSON(w, http.StatusAccepted, migration)
+}
+
+// handlerAdminStorageMigrationsList lists the most recent migrations,
+// newest first.
+func (cfg *apiConfig) handlerAdminStorageMigrationsList(w http.ResponseWriter, r *http.Request) {
+	migrations, err := cfg.db.GetStorageMigrations(storageMigrationsShown)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage migrations", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, migrations)
+}
+
+func (cfg *apiConfig) handlerAdminStorageMigrationGet(w http.ResponseWriter, r *http.Request) {
+	// The route validates the ID
+	migrationID, _ := uuid.Parse(r.PathValue("migrationID"))
+
+	migration, err := cfg.db.GetStorageMigration(migrationID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage migration", err)
+		return
+	}
+	if migration.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Storage migration not found", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, migration)
+}
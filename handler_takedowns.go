@@ -0,0 +1,391 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// maxTakedownReasonLength caps the text of a claim or a dispute.
+const maxTakedownReasonLength = 5000
+
+// takedownDecision is a move an admin can make on a takedown.
+type takedownDecision struct {
+	from   []string
+	to     string
+	action string
+}
+
+var takedownDecisions = map[string]takedownDecision{
+	"block":     {from: []string{database.TakedownStatusFiled}, to: database.TakedownStatusBlocked, action: database.AuditActionTakedownBlocked},
+	"reject":    {from: []string{database.TakedownStatusFiled}, to: database.TakedownStatusRejected, action: database.AuditActionTakedownRejected},
+	"reinstate": {from: []string{database.TakedownStatusBlocked, database.TakedownStatusDisputed}, to: database.TakedownStatusReinstated, action: database.AuditActionTakedownReinstated},
+	"uphold":    {from: []string{database.TakedownStatusDisputed}, to: database.TakedownStatusUpheld, action: database.AuditActionTakedownUpheld},
+}
+
+// checkNotBlocked refuses to show a video blocked by a takedown. It returns
+// false when it has responded.
+func checkNotBlocked(w http.ResponseWriter, video database.Video) bool {
+	if !video.Blocked {
+		return true
+	}
+	respondWithErrorCode(w, http.StatusUnavailableForLegalReasons, errCodeVideoBlocked, "Video is unavailable because of a takedown", nil)
+	return false
+}
+
+// handlerTakedownCreate files a claim against someone else's video. The
+// video stays up until an admin reviews the claim.
+func (cfg *apiConfig) handlerTakedownCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason string `json:"reason"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Reason = strings.TrimSpace(params.Reason)
+	if params.Reason == "" || len(params.Reason) > maxTakedownReasonLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Reason must be between 1 and %d characters", maxTakedownReasonLength), nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID == userID {
+		respondWithError(w, http.StatusBadRequest, "You can't file a takedown against your own video", nil)
+		return
+	}
+	open, err := cfg.db.HasOpenTakedown(video.ID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check takedowns", err)
+		return
+	}
+	if open {
+		respondWithError(w, http.StatusConflict, "You already have an open takedown against this video", nil)
+		return
+	}
+
+	takedown, err := cfg.db.CreateTakedown(database.CreateTakedownParams{
+		VideoID:        video.ID,
+		ClaimantUserID: userID,
+		Reason:         params.Reason,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create takedown", err)
+		return
+	}
+	cfg.auditTakedown(fmt.Sprintf("user:%s", userID), database.AuditActionTakedownFiled, takedown)
+
+	respondWithJSON(w, http.StatusCreated, takedown)
+}
+
+// handlerVideoTakedownsList shows an owner the takedowns filed against
+// their video.
+func (cfg *apiConfig) handlerVideoTakedownsList(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized to view this video's takedowns", nil)
+		return
+	}
+
+	takedowns, err := cfg.db.GetTakedownsForVideo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get takedowns", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, takedowns)
+}
+
+// handlerTakedownGet shows a takedown to its claimant or the video's owner.
+func (cfg *apiConfig) handlerTakedownGet(w http.ResponseWriter, r *http.Request) {
+	takedown, _, ok := cfg.getTakedownForParty(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, takedown)
+}
+
+// handlerTakedownDispute lets the owner contest a block. The video stays
+// blocked while an admin reviews the dispute.
+func (cfg *apiConfig) handlerTakedownDispute(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason string `json:"reason"`
+	}
+
+	takedown, video, ok := cfg.getTakedownForParty(w, r)
+	if !ok {
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusForbidden, "Only the video's owner can dispute a takedown", nil)
+		return
+	}
+	userID := video.UserID
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	params.Reason = strings.TrimSpace(params.Reason)
+	if params.Reason == "" || len(params.Reason) > maxTakedownReasonLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Reason must be between 1 and %d characters", maxTakedownReasonLength), nil)
+		return
+	}
+
+	updated, err := cfg.db.UpdateTakedownStatus(takedown.ID, database.TakedownStatusBlocked, database.TakedownStatusDisputed, &params.Reason, nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update takedown", err)
+		return
+	}
+	if !updated {
+		respondWithErrorCode(w, http.StatusConflict, errCodeConflict, "Only blocked takedowns can be disputed", nil)
+		return
+	}
+	cfg.auditTakedown(fmt.Sprintf("user:%s", userID), database.AuditActionTakedownDisputed, takedown)
+
+	takedown, err = cfg.db.GetTakedown(takedown.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get takedown", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, takedown)
+}
+
+// getTakedownForParty loads the takedown in the path for its claimant or
+// the owner of its video, responding 404 to anyone else. The video returned
+// is only set for the owner. It returns false when it has responded.
+func (cfg *apiConfig) getTakedownForParty(w http.ResponseWriter, r *http.Request) (database.Takedown, database.Video, bool) {
+	takedownID, err := uuid.Parse(r.PathValue("takedownID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid takedown ID", err)
+		return database.Takedown{}, database.Video{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Takedown{}, database.Video{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Takedown{}, database.Video{}, false
+	}
+
+	takedown, err := cfg.db.GetTakedown(takedownID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get takedown", err)
+		return database.Takedown{}, database.Video{}, false
+	}
+	if takedown.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Takedown not found", nil)
+		return database.Takedown{}, database.Video{}, false
+	}
+	video, err := cfg.db.GetVideo(takedown.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Takedown{}, database.Video{}, false
+	}
+	if video.ID != uuid.Nil && video.UserID == userID {
+		return takedown, video, true
+	}
+	// Other users' takedowns look the same as missing ones
+	if takedown.ClaimantUserID != userID {
+		respondWithError(w, http.StatusNotFound, "Takedown not found", nil)
+		return database.Takedown{}, database.Video{}, false
+	}
+	return takedown, database.Video{}, true
+}
+
+// handlerAdminTakedownsList is the review queue, optionally limited to one
+// state with ?status=.
+func (cfg *apiConfig) handlerAdminTakedownsList(w http.ResponseWriter, r *http.Request) {
+	takedowns, err := cfg.db.GetTakedowns(r.URL.Query().Get("status"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get takedowns", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, takedowns)
+}
+
+// handlerAdminTakedownGet returns a takedown with its history: every step
+// of the claim and dispute, who took it and when.
+func (cfg *apiConfig) handlerAdminTakedownGet(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		database.Takedown
+		History []database.AuditEvent `json:"history"`
+	}
+
+	takedownID, err := uuid.Parse(r.PathValue("takedownID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid takedown ID", err)
+		return
+	}
+	takedown, err := cfg.db.GetTakedown(takedownID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get takedown", err)
+		return
+	}
+	if takedown.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Takedown not found", nil)
+		return
+	}
+	history, err := cfg.db.GetAuditEventsForSubject(takedown.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get takedown history", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{Takedown: takedown, History: history})
+}
+
+// handlerAdminTakedownDecide records an admin's decision on a takedown and
+// blocks or reinstates the video to match. The owner is notified when their
+// video is blocked or comes back. URLs handed out before a block stay valid
+// until they expire.
+func (cfg *apiConfig) handlerAdminTakedownDecide(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Decision string  `json:"decision"`
+		Note     *string `json:"note"`
+	}
+
+	takedownID, err := uuid.Parse(r.PathValue("takedownID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid takedown ID", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	decision, ok := takedownDecisions[params.Decision]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, "Decision must be block, reject, reinstate or uphold", nil)
+		return
+	}
+
+	takedown, err := cfg.db.GetTakedown(takedownID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get takedown", err)
+		return
+	}
+	if takedown.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Takedown not found", nil)
+		return
+	}
+	if !slices.Contains(decision.from, takedown.Status) {
+		respondWithErrorCode(w, http.StatusConflict, errCodeConflict, fmt.Sprintf("A %s takedown can't be decided that way", takedown.Status), nil)
+		return
+	}
+
+	before, err := cfg.db.GetVideo(takedown.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	updated, err := cfg.db.UpdateTakedownStatus(takedown.ID, takedown.Status, decision.to, nil, params.Note)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update takedown", err)
+		return
+	}
+	if !updated {
+		respondWithErrorCode(w, http.StatusConflict, errCodeConflict, "Takedown was changed by another request", nil)
+		return
+	}
+	cfg.auditTakedown("admin", decision.action, takedown)
+
+	// Other takedowns can keep a video blocked after one is settled
+	after, err := cfg.db.GetVideo(takedown.VideoID)
+	if err != nil {
+		logging.Warnf("Couldn't get video %s after takedown %s: %v", takedown.VideoID, takedown.ID, err)
+	} else if after.ID != uuid.Nil && after.Blocked != before.Blocked {
+		eventType := events.TypeVideoReinstated
+		if after.Blocked {
+			eventType = events.TypeVideoBlocked
+		}
+		cfg.publishVideoEvent(after.ID, eventType, map[string]any{"takedown_id": takedown.ID})
+		cfg.announceIfPublished(after.ID)
+	}
+
+	takedown, err = cfg.db.GetTakedown(takedown.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get takedown", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, takedown)
+}
+
+// auditTakedown records a step of a takedown against it, so its history
+// can be read back in order.
+func (cfg *apiConfig) auditTakedown(actor, action string, takedown database.Takedown) {
+	details, err := json.Marshal(map[string]any{"video_id": takedown.VideoID})
+	if err == nil {
+		err = cfg.db.CreateAuditEvent(database.CreateAuditEventParams{
+			Actor:     actor,
+			Action:    action,
+			SubjectID: takedown.ID,
+			Details:   details,
+		})
+	}
+	if err != nil {
+		logging.Warnf("Couldn't record audit event for takedown %s: %v", takedown.ID, err)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type thumbnailCandidateResponse struct {
+	database.ThumbnailCandidate
+	URL      string  `json:"url"`
+	OffsetS  float64 `json:"offset_seconds"`
+	Selected bool    `json:"selected"`
+}
+
+func (cfg *apiConfig) handlerThumbnailCandidatesList(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	canView, err := cfg.userCanViewVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canView {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized to view this video", nil)
+		return
+	}
+
+	candidates, err := cfg.db.GetThumbnailCandidates(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidates", err)
+		return
+	}
+
+	responses := make([]thumbnailCandidateResponse, len(candidates))
+	for i, candidate := range candidates {
+		url := cfg.assetURL(candidate.Filename)
+		responses[i] = thumbnailCandidateResponse{
+			ThumbnailCandidate: candidate,
+			URL:                url,
+			OffsetS:            candidate.Offset.Seconds(),
+			Selected:           video.ThumbnailURL != nil && *video.ThumbnailURL == url,
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, responses)
+}
+
+func (cfg *apiConfig) handlerThumbnailCandidateSelect(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	candidateIDString := r.PathValue("candidateID")
+	candidateID, err := uuid.Parse(candidateIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid candidate ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	// Hold the video until it's updated
+	videoLock, ok := cfg.lockVideo(w, videoID)
+	if !ok {
+		return
+	}
+	defer videoLock.Release()
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	canEdit, err := cfg.userCanEditVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized to update this video", nil)
+		return
+	}
+	if !checkNotOnLegalHold(w, video) {
+		return
+	}
+
+	candidate, err := cfg.db.GetThumbnailCandidate(candidateID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidate", err)
+		return
+	}
+	if candidate.ID == uuid.Nil || candidate.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Thumbnail candidate not found", nil)
+		return
+	}
+
+	thumbnailURL := cfg.assetURL(candidate.Filename)
+
+	// Flagged candidates wait for a moderator instead of being published
+	if candidate.Flagged {
+		cfg.holdThumbnail(&video, thumbnailURL, nil)
+		err = cfg.db.UpdateVideo(video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+		signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+			return
+		}
+		respondWithJSON(w, http.StatusAccepted, signedVideo)
+		return
+	}
+
+	previousThumbnailURL := video.ThumbnailURL
+	video.ThumbnailURL = &thumbnailURL
+	// Candidates are extracted frames, not optimized uploads
+	video.ThumbnailOriginalSize = nil
+	video.ThumbnailSize = nil
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.trackThumbnail(video)
+	if previousThumbnailURL != nil && *previousThumbnailURL != thumbnailURL {
+		cfg.releaseThumbnail(video.ID, previousThumbnailURL)
+	}
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
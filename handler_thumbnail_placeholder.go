@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// placeholderThumbnailURL is where videos without a thumbnail point to.
+func (cfg *apiConfig) placeholderThumbnailURL(videoID uuid.UUID) string {
+	return fmt.Sprintf("http://localhost:%s/api/videos/%s/placeholder.svg", cfg.port, videoID)
+}
+
+// handlerThumbnailPlaceholder renders a 16:9 SVG with the title's initials on
+// a background color derived from the video ID, so it stays stable.
+func (cfg *apiConfig) handlerThumbnailPlaceholder(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+
+	sum := sha256.Sum256(video.ID[:])
+	hue := int(sum[0]) * 360 / 256
+
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="1280" height="720" viewBox="0 0 1280 720">`+
+		`<rect width="1280" height="720" fill="hsl(%d, 45%%, 45%%)"/>`+
+		`<text x="640" y="360" dy="0.35em" text-anchor="middle" font-family="sans-serif" font-size="280" font-weight="bold" fill="#ffffff">%s</text>`+
+		`</svg>`, hue, html.EscapeString(titleInitials(video.Title)))
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(svg))
+}
+
+// titleInitials returns the first letter of up to two words of the title.
+func titleInitials(title string) string {
+	var initials []rune
+	for _, word := range strings.Fields(title) {
+		for _, r := range word {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				initials = append(initials, unicode.ToUpper(r))
+				break
+			}
+		}
+		if len(initials) == 2 {
+			break
+		}
+	}
+	if len(initials) == 0 {
+		return "?"
+	}
+	return string(initials)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// maxThumbnailVariantWeight bounds the weight a variant can be given.
+const maxThumbnailVariantWeight = 100
+
+type thumbnailVariantResponse struct {
+	database.ThumbnailVariant
+	// ClickThroughRate is clicks per impression, or 0 before any impression.
+	ClickThroughRate float64 `json:"click_through_rate"`
+}
+
+func (cfg *apiConfig) thumbnailVariantResponse(variant database.ThumbnailVariant, regionHint string) (thumbnailVariantResponse, error) {
+	thumbnailURL, err := cfg.signThumbnailURL(variant.ThumbnailURL, regionHint)
+	if err != nil {
+		return thumbnailVariantResponse{}, err
+	}
+	variant.ThumbnailURL = thumbnailURL
+
+	response := thumbnailVariantResponse{ThumbnailVariant: variant}
+	if variant.Impressions > 0 {
+		response.ClickThroughRate = float64(variant.Clicks) / float64(variant.Impressions)
+	}
+	return response, nil
+}
+
+func (cfg *apiConfig) handlerThumbnailVariantCreate(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	// Parse the form data
+	const maxMemory = 10 << 20
+	err = r.ParseMultipartForm(maxMemory)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't parse form", err)
+		return
+	}
+
+	weight := 1
+	if v := r.FormValue("weight"); v != "" {
+		weight, err = strconv.Atoi(v)
+		if err != nil || weight < 1 || weight > maxThumbnailVariantWeight {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Weight must be between 1 and %d", maxThumbnailVariantWeight), nil)
+			return
+		}
+	}
+
+	file, header, err := r.FormFile("thumbnail")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't get file from form", err)
+		return
+	}
+	defer file.Close()
+
+	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type header", err)
+		return
+	}
+	var fileExtension string
+	switch mediaType {
+	case "image/jpeg":
+		fileExtension = "jpg"
+	case "image/png":
+		fileExtension = "png"
+	default:
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeUnsupportedImage, "Invalid file type. Only JPEG and PNG images are allowed", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	canEdit, err := cfg.userCanEditVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized to update this video", nil)
+		return
+	}
+
+	variants, err := cfg.db.GetThumbnailVariants(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail variants", err)
+		return
+	}
+	if len(variants) >= maxThumbnailVariants {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("A video can have at most %d thumbnail variants", maxThumbnailVariants), nil)
+		return
+	}
+
+	originalData, err := io.ReadAll(file)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read file", err)
+		return
+	}
+	data, err := optimizeImage(originalData, mediaType, cfg.thumbnailJPEGQuality)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid image data", err)
+		return
+	}
+
+	// Variants are shown without review, so flagged images aren't accepted
+	if flagged, _ := cfg.classifyThumbnail(video.ID, data); flagged {
+		respondWithErrorCode(w, http.StatusUnprocessableEntity, errCodeModerationRejected, "Thumbnail was flagged by moderation", nil)
+		return
+	}
+
+	thumbnailURL, err := cfg.storeThumbnailImage(data, mediaType, fileExtension)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store thumbnail", err)
+		return
+	}
+	// Identical images share one stored object, and testing one against
+	// itself tells the creator nothing
+	for _, variant := range variants {
+		if variant.ThumbnailURL == thumbnailURL {
+			respondWithError(w, http.StatusConflict, "The video already has this thumbnail variant", nil)
+			return
+		}
+	}
+	storage, location, _ := cfg.thumbnailAsset(thumbnailURL)
+	cfg.trackAsset(video.ID, database.AssetKindThumbnailVariant, storage, location)
+
+	variant, err := cfg.db.CreateThumbnailVariant(database.CreateThumbnailVariantParams{
+		VideoID:      video.ID,
+		ThumbnailURL: thumbnailURL,
+		Weight:       weight,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create thumbnail variant", err)
+		return
+	}
+
+	response, err := cfg.thumbnailVariantResponse(variant, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, response)
+}
+
+func (cfg *apiConfig) handlerThumbnailVariantsList(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	canView, err := cfg.userCanViewVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canView {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized to view this video", nil)
+		return
+	}
+
+	variants, err := cfg.db.GetThumbnailVariants(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail variants", err)
+		return
+	}
+
+	responses := make([]thumbnailVariantResponse, len(variants))
+	for i, variant := range variants {
+		responses[i], err = cfg.thumbnailVariantResponse(variant, clientRegionHint(r))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, responses)
+}
+
+func (cfg *apiConfig) handlerThumbnailVariantDelete(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	variantIDString := r.PathValue("variantID")
+	variantID, err := uuid.Parse(variantIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid variant ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	canEdit, err := cfg.userCanEditVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized to update this video", nil)
+		return
+	}
+	if !checkNotOnLegalHold(w, video) {
+		return
+	}
+
+	variant, err := cfg.db.GetThumbnailVariant(variantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail variant", err)
+		return
+	}
+	if variant.ID == uuid.Nil || variant.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Thumbnail variant not found", nil)
+		return
+	}
+
+	err = cfg.db.DeleteThumbnailVariant(variant.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete thumbnail variant", err)
+		return
+	}
+	if storage, location, ok := cfg.thumbnailAsset(variant.ThumbnailURL); ok {
+		err = cfg.releaseAsset(database.CreateAssetParams{
+			VideoID:  video.ID,
+			Kind:     database.AssetKindThumbnailVariant,
+			Storage:  storage,
+			Location: location,
+		})
+		if err != nil {
+			logging.Warnf("Couldn't delete thumbnail variant %s: %v", variant.ID, err)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerThumbnailVariantClick records a click through a variant shown by
+// the video endpoint. It's unauthenticated, like the views it's counted
+// against.
+func (cfg *apiConfig) handlerThumbnailVariantClick(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+	variantIDString := r.PathValue("variantID")
+	variantID, err := uuid.Parse(variantIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid variant ID", err)
+		return
+	}
+
+	variant, err := cfg.db.GetThumbnailVariant(variantID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail variant", err)
+		return
+	}
+	if variant.ID == uuid.Nil || variant.VideoID != videoID {
+		respondWithError(w, http.StatusNotFound, "Thumbnail variant not found", nil)
+		return
+	}
+
+	err = cfg.db.RecordThumbnailClick(variant.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record click", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
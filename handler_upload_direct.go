@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// maxDirectUploadSize matches the limit on uploads through the API.
+const maxDirectUploadSize = maxVideoUploadSize
+
+// directUploadExpiry is how long a presigned upload policy stays valid.
+const directUploadExpiry = 15 * time.Minute
+
+// handlerVideoUploadURL hands out a presigned POST policy the client can
+// upload the video file to directly. The object key is chosen here and
+// remembered on the video, so finalizing can't point a video at someone
+// else's object. The policy only accepts that key, an MP4 content type and
+// a file up to maxDirectUploadSize, so a leaked policy can't be used to
+// store anything else in the bucket.
+func (cfg *apiConfig) handlerVideoUploadURL(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		URL    string `json:"url"`
+		Method string `json:"method"`
+		// Fields must be sent as form fields exactly as given, before the
+		// file field; the policy refuses uploads without them
+		Fields map[string]string `json:"fields"`
+		// MaxSize is the largest file the policy accepts, in bytes
+		MaxSize   int64     `json:"max_size"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	// Hold the video until it's updated
+	videoLock, ok := cfg.lockVideo(w, videoID)
+	if !ok {
+		return
+	}
+	defer videoLock.Release()
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	canEdit, err := cfg.userCanEditVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized to update this video", nil)
+		return
+	}
+	if !checkNotOnLegalHold(w, video) {
+		return
+	}
+	if !cfg.checkUploadQuota(w, userID) || !cfg.checkStorageQuota(w, userID) {
+		return
+	}
+
+	key, err := cfg.objectKey(video, renditionOriginal)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't choose object key", err)
+		return
+	}
+	bucket := cfg.bucketFor(objectClassOriginal)
+
+	headers := cfg.videoObjectHeaders(video, "video/mp4")
+	fields, conditions := directUploadPolicy(key, headers)
+	presigned, err := cfg.storage.presignPost(r.Context(), bucket, key, directUploadExpiry, conditions)
+	if errors.Is(err, errPresignedPostUnsupported) {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads aren't supported by the storage provider", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+	for name, value := range presigned.Values {
+		fields[name] = value
+	}
+
+	// Track the object right away so it's cleaned up with the video even if
+	// the upload is never finalized
+	uploadURL := fmt.Sprintf("%s,%s", bucket, key)
+	cfg.trackAsset(video.ID, database.AssetKindOriginal, database.AssetStorageS3, uploadURL)
+	previousUploadURL := video.PendingUploadURL
+	video.PendingUploadURL = &uploadURL
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	if previousUploadURL != nil && *previousUploadURL != uploadURL && !isOriginal(video, *previousUploadURL) {
+		cfg.releaseOriginal(video.ID, *previousUploadURL)
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		URL:       presigned.URL,
+		Method:    http.MethodPost,
+		Fields:    fields,
+		MaxSize:   maxDirectUploadSize,
+		ExpiresAt: time.Now().Add(directUploadExpiry).UTC(),
+	})
+}
+
+// directUploadPolicy returns the form fields a direct upload sends besides
+// the signature ones, and the policy conditions that pin them. S3 refuses
+// form fields the policy doesn't mention, so every field gets a condition.
+func directUploadPolicy(key string, headers objectHeaders) (map[string]string, []any) {
+	fields := map[string]string{
+		"Content-Type": headers.ContentType,
+	}
+	if headers.CacheControl != "" {
+		fields["Cache-Control"] = headers.CacheControl
+	}
+	if headers.ContentDisposition != "" {
+		fields["Content-Disposition"] = headers.ContentDisposition
+	}
+	if headers.ContentLanguage != "" {
+		fields["Content-Language"] = headers.ContentLanguage
+	}
+
+	conditions := []any{
+		map[string]string{"key": key},
+		[]any{"content-length-range", 1, maxDirectUploadSize},
+	}
+	for name, value := range fields {
+		conditions = append(conditions, map[string]string{name: value})
+	}
+	return fields, conditions
+}
+
+// handlerVideoFinalize is called once a direct upload has finished. It checks
+// the object made it to S3 and looks like a video, records it as the video's
+// original and starts processing it.
+func (cfg *apiConfig) handlerVideoFinalize(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	// Hold the video until it's updated
+	videoLock, ok := cfg.lockVideo(w, videoID)
+	if !ok {
+		return
+	}
+	defer videoLock.Release()
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	canEdit, err := cfg.userCanEditVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized to update this video", nil)
+		return
+	}
+	if !checkNotOnLegalHold(w, video) {
+		return
+	}
+	if !cfg.checkUploadQuota(w, userID) || !cfg.checkStorageQuota(w, userID) {
+		return
+	}
+	// The running job would be processing a replaced original
+	if !cfg.checkNotProcessing(w, video.ID) {
+		return
+	}
+	if video.PendingUploadURL == nil {
+		respondWithError(w, http.StatusConflict, "No direct upload in progress for this video", nil)
+		return
+	}
+
+	uploadURL := *video.PendingUploadURL
+	bucket, key, err := parseBucketKey(uploadURL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Invalid upload location", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	head, err := cfg.storage.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:       &bucket,
+		Key:          &key,
+		ChecksumMode: types.ChecksumModeEnabled,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Uploaded file not found", err)
+		return
+	}
+
+	// Validate size and type
+	if head.ContentLength == nil || *head.ContentLength == 0 || *head.ContentLength > maxDirectUploadSize {
+		cfg.rejectDirectUpload(video, uploadURL)
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeVideoTooLarge, fmt.Sprintf("Uploaded file must be between 1 and %d bytes", maxDirectUploadSize), nil)
+		return
+	}
+	var mediaType string
+	if head.ContentType != nil {
+		mediaType, _, _ = mime.ParseMediaType(*head.ContentType)
+	}
+	if mediaType != "video/mp4" {
+		cfg.rejectDirectUpload(video, uploadURL)
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeUnsupportedContainer, "Invalid file type. Only MP4 videos are allowed", nil)
+		return
+	}
+
+	// The upload replaces any earlier original
+	previousOriginalURL := video.OriginalURL
+	video.OriginalURL = &uploadURL
+	video.OriginalChecksum = headChecksums(head).format()
+	video.PendingUploadURL = nil
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	if previousOriginalURL != nil && *previousOriginalURL != uploadURL {
+		cfg.releaseOriginal(video.ID, *previousOriginalURL)
+	}
+
+	// Without a local source file the job fetches the original from S3
+	job, err := cfg.db.CreateJob(database.CreateJobParams{
+		VideoID:   video.ID,
+		MediaType: mediaType,
+	})
+	if errors.Is(err, database.ErrJobInProgress) {
+		respondWithProcessingConflict(w, job)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create processing job", err)
+		return
+	}
+	cfg.enqueueJob(job.ID)
+	cfg.recordUpload(w, userID, video.ID)
+
+	respondWithJSON(w, http.StatusAccepted, job)
+}
+
+// rejectDirectUpload deletes an upload that failed validation. The client
+// has to ask for a new upload URL to try again.
+func (cfg *apiConfig) rejectDirectUpload(video database.Video, uploadURL string) {
+	video.PendingUploadURL = nil
+	err := cfg.db.UpdateVideo(video)
+	if err != nil {
+		logging.Warnf("Couldn't clear rejected upload of video %s: %v", video.ID, err)
+		return
+	}
+	// A key template without {random} reuses the original's key
+	if !isOriginal(video, uploadURL) {
+		cfg.releaseOriginal(video.ID, uploadURL)
+	}
+}
+
+func isOriginal(video database.Video, location string) bool {
+	return video.OriginalURL != nil && *video.OriginalURL == location
+}
+
+// releaseOriginal releases an original the video no longer uses.
+func (cfg *apiConfig) releaseOriginal(videoID uuid.UUID, location string) {
+	err := cfg.releaseAsset(database.CreateAssetParams{
+		VideoID:  videoID,
+		Kind:     database.AssetKindOriginal,
+		Storage:  database.AssetStorageS3,
+		Location: location,
+	})
+	if err != nil {
+		logging.Warnf("Couldn't delete previous original for video %s: %v", videoID, err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerVideoUploadURL(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, userID)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/videos/"+video.ID.String()+"/upload_url", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.SetPathValue("videoID", video.ID.String())
+	w := httptest.NewRecorder()
+	cfg.handlerVideoUploadURL(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var response struct {
+		URL     string            `json:"url"`
+		Method  string            `json:"method"`
+		Fields  map[string]string `json:"fields"`
+		MaxSize int64             `json:"max_size"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.URL != "https://storage.test/"+testBucket || response.Method != http.MethodPost {
+		t.Errorf("upload to %s %s, want POST to the presigned URL", response.Method, response.URL)
+	}
+	if response.Fields["policy"] != "test-policy" || response.Fields["Content-Type"] != "video/mp4" {
+		t.Errorf("fields = %v, want the presigned ones and the content type", response.Fields)
+	}
+	if response.MaxSize != maxDirectUploadSize {
+		t.Errorf("max size = %d, want %d", response.MaxSize, maxDirectUploadSize)
+	}
+
+	// The key handed out is the only one finalizing accepts
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := testBucket + "," + response.Fields["key"]; video.PendingUploadURL == nil || *video.PendingUploadURL != want {
+		t.Errorf("pending upload = %v, want %s", video.PendingUploadURL, want)
+	}
+}
+
+func TestHandlerVideoUploadURLRequiresOwner(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	ownerID, _ := createTestUser(t, cfg, "owner@example.com")
+	_, otherToken := createTestUser(t, cfg, "other@example.com")
+	video := createTestVideo(t, cfg, ownerID)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/videos/"+video.ID.String()+"/upload_url", nil)
+	r.Header.Set("Authorization", "Bearer "+otherToken)
+	r.SetPathValue("videoID", video.ID.String())
+	w := httptest.NewRecorder()
+	cfg.handlerVideoUploadURL(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+	}
+}
@@ -0,0 +1,688 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// An upload session is created declaring the upload's size, type and
+// optionally its SHA-256, then sent in numbered parts, in order, and
+// finalized. Parts go straight into an S3 multipart upload, so any instance
+// can take the next one; a single part holding the whole file is a
+// single-shot upload. The video is only touched by finalizing, which
+// checks the declared size and checksum.
+
+// uploadSessionExpiry is how long a session accepts parts for.
+const uploadSessionExpiry = 24 * time.Hour
+
+// uploadSessionRetention is how long sessions are kept after they stop
+// accepting parts, so finalizing can be repeated.
+const uploadSessionRetention = 7 * 24 * time.Hour
+
+// maxUploadParts is the most parts S3 accepts in a multipart upload.
+const maxUploadParts = 10000
+
+var sha256Hex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+type uploadSessionResponse struct {
+	database.UploadSession
+	// NextPart is the number of the part to send next while the session is
+	// open, so an interrupted upload can carry on from there.
+	NextPart int `json:"next_part,omitempty"`
+}
+
+func newUploadSessionResponse(session database.UploadSession) uploadSessionResponse {
+	response := uploadSessionResponse{UploadSession: session}
+	if session.Status == database.UploadSessionStatusOpen && session.Received < session.Size {
+		response.NextPart = len(session.Parts) + 1
+	}
+	return response
+}
+
+// respondWithUploadSessionConflict rejects a request that doesn't fit the
+// session's current state, returning that state so the client can recover.
+func respondWithUploadSessionConflict(w http.ResponseWriter, status int, errCode errorCode, msg string, session database.UploadSession) {
+	type response struct {
+		errorResponse
+		Session uploadSessionResponse `json:"session"`
+	}
+	respondWithJSON(w, status, response{
+		errorResponse: errorResponse{
+			Error: localize(w, msg),
+			Code:  errCode,
+		},
+		Session: newUploadSessionResponse(session),
+	})
+}
+
+func (cfg *apiConfig) handlerUploadSessionCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// VideoID uploads to an existing video. Without it, finalizing
+		// creates a video with the given title, description and
+		// organization.
+		VideoID        *uuid.UUID `json:"video_id"`
+		Title          string     `json:"title"`
+		Description    string     `json:"description"`
+		OrganizationID *uuid.UUID `json:"organization_id"`
+		Size           int64      `json:"size"`
+		MediaType      string     `json:"media_type"`
+		ChecksumSHA256 string     `json:"checksum_sha256"`
+		AudioTracks    []int      `json:"audio_tracks"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Size > maxVideoUploadSize {
+		respondWithTooLarge(w, maxVideoUploadSize)
+		return
+	}
+	if params.Size <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Size must be a positive number of bytes", nil)
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(params.MediaType)
+	if err != nil || mediaType != "video/mp4" {
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeUnsupportedContainer, "Invalid file type. Only MP4 videos are allowed", err)
+		return
+	}
+	if params.ChecksumSHA256 != "" && !sha256Hex.MatchString(params.ChecksumSHA256) {
+		respondWithError(w, http.StatusBadRequest, "Checksum must be a hex-encoded SHA-256", nil)
+		return
+	}
+	for _, index := range params.AudioTracks {
+		if index < 0 {
+			respondWithError(w, http.StatusBadRequest, "Invalid audio track selection", nil)
+			return
+		}
+	}
+
+	// The video the upload is for, or the one finalizing will create
+	var video database.Video
+	if params.VideoID != nil {
+		video, err = cfg.db.GetVideo(*params.VideoID)
+		if err != nil || video.ID == uuid.Nil {
+			respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+			return
+		}
+		canEdit, err := cfg.userCanEditVideo(userID, video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+			return
+		}
+		if !canEdit {
+			respondWithError(w, http.StatusUnauthorized, "User not authorized to update this video", nil)
+			return
+		}
+		if !checkNotOnLegalHold(w, video) {
+			return
+		}
+		if !cfg.checkNotProcessing(w, video.ID) {
+			return
+		}
+	} else {
+		if params.OrganizationID != nil {
+			role, err := cfg.db.GetOrganizationRole(*params.OrganizationID, userID)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't get organization role", err)
+				return
+			}
+			if !role.CanEdit() {
+				respondWithError(w, http.StatusForbidden, "User not authorized to add videos to this organization", nil)
+				return
+			}
+		}
+		video = database.Video{
+			ID: uuid.New(),
+			CreateVideoParams: database.CreateVideoParams{
+				Title:          params.Title,
+				Description:    params.Description,
+				UserID:         userID,
+				OrganizationID: params.OrganizationID,
+			},
+		}
+	}
+	if !cfg.checkUploadQuota(w, userID) || !cfg.checkStorageQuota(w, userID) {
+		return
+	}
+
+	key, err := cfg.objectKey(video, renditionOriginal)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't choose object key", err)
+		return
+	}
+	bucket := cfg.bucketFor(objectClassOriginal)
+	headers := cfg.videoObjectHeaders(video, mediaType)
+	output, err := cfg.storage.CreateMultipartUpload(r.Context(), &s3.CreateMultipartUploadInput{
+		Bucket:             &bucket,
+		Key:                &key,
+		ContentType:        optionalString(headers.ContentType),
+		CacheControl:       optionalString(headers.CacheControl),
+		ContentDisposition: optionalString(headers.ContentDisposition),
+		ContentLanguage:    optionalString(headers.ContentLanguage),
+		ChecksumAlgorithm:  cfg.checksumAlgorithm,
+		ChecksumType:       multipartChecksumType(cfg.checksumAlgorithm),
+	})
+	if err != nil {
+		respondWithErrorCode(w, http.StatusBadGateway, errCodeStorageFailed, "Couldn't start upload", err)
+		return
+	}
+
+	session, err := cfg.db.CreateUploadSession(database.CreateUploadSessionParams{
+		UserID:            userID,
+		VideoID:           video.ID,
+		CreateVideo:       params.VideoID == nil,
+		Title:             params.Title,
+		Description:       params.Description,
+		OrganizationID:    params.OrganizationID,
+		Size:              params.Size,
+		MediaType:         mediaType,
+		ChecksumSHA256:    params.ChecksumSHA256,
+		AudioTracks:       params.AudioTracks,
+		ExpiresAt:         time.Now().Add(uploadSessionExpiry),
+		UploadBucket:      bucket,
+		UploadKey:         key,
+		UploadID:          *output.UploadId,
+		ChecksumAlgorithm: string(cfg.checksumAlgorithm),
+	})
+	if err != nil {
+		cfg.abortMultipartUpload(bucket, key, *output.UploadId)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload session", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, newUploadSessionResponse(session))
+}
+
+func (cfg *apiConfig) handlerUploadSessionGet(w http.ResponseWriter, r *http.Request) {
+	sessionID, userID, ok := cfg.uploadSessionRequest(w, r)
+	if !ok {
+		return
+	}
+
+	session, ok := cfg.getUploadSession(w, sessionID, userID)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, http.StatusOK, newUploadSessionResponse(session))
+}
+
+// handlerUploadSessionPart stores the next part of a session's upload. The
+// body must have a Content-Length, and every part but the last must be at
+// least the 5 MiB S3 requires.
+func (cfg *apiConfig) handlerUploadSessionPart(w http.ResponseWriter, r *http.Request) {
+	sessionID, userID, ok := cfg.uploadSessionRequest(w, r)
+	if !ok {
+		return
+	}
+	partNumber, err := strconv.Atoi(r.PathValue("partNumber"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid part number", err)
+		return
+	}
+
+	// Parts are taken one at a time so the checksum sees them in order
+	sessionLock, ok := cfg.lockUploadSession(w, sessionID)
+	if !ok {
+		return
+	}
+	defer sessionLock.Release()
+
+	session, ok := cfg.getOpenUploadSession(w, sessionID, userID)
+	if !ok {
+		return
+	}
+	if partNumber != len(session.Parts)+1 {
+		respondWithUploadSessionConflict(w, http.StatusConflict, errCodeConflict, "Parts must be uploaded in order", session)
+		return
+	}
+	remaining := session.Size - session.Received
+	if remaining == 0 {
+		respondWithUploadSessionConflict(w, http.StatusConflict, errCodeConflict, "Upload is already complete", session)
+		return
+	}
+	if r.ContentLength < 0 {
+		respondWithError(w, http.StatusLengthRequired, "Content-Length is required", nil)
+		return
+	}
+	if r.ContentLength == 0 || r.ContentLength > remaining {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Part must be between 1 and %d bytes", remaining), nil)
+		return
+	}
+	if r.ContentLength < minUploadPartSize && r.ContentLength != remaining {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Every part but the last must be at least %d bytes", minUploadPartSize), nil)
+		return
+	}
+
+	checksum, err := restoreUploadHash(session.HashState)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore upload checksum", err)
+		return
+	}
+
+	// The part is spooled to disk so the SDK can sign and retry it
+	tempFile, err := os.CreateTemp("", "tubely-session-part")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create temporary file", err)
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	src := &contextReader{ctx: r.Context(), r: r.Body}
+	n, err := io.Copy(io.MultiWriter(tempFile, checksum), src)
+	if r.Context().Err() != nil {
+		logging.Debugf("Client disconnected during part %d of upload session %s: %v", partNumber, session.ID, err)
+		return
+	}
+	if src.err != nil || (err == nil && n != r.ContentLength) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read part", src.err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't write to temporary file", err)
+		return
+	}
+	_, err = tempFile.Seek(0, io.SeekStart)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read temporary file", err)
+		return
+	}
+
+	start := time.Now()
+	output, err := cfg.storage.UploadPart(r.Context(), &s3.UploadPartInput{
+		Bucket:            &session.UploadBucket,
+		Key:               &session.UploadKey,
+		UploadId:          &session.UploadID,
+		PartNumber:        aws.Int32(int32(partNumber)),
+		Body:              tempFile,
+		ContentLength:     aws.Int64(n),
+		ChecksumAlgorithm: types.ChecksumAlgorithm(session.ChecksumAlgorithm),
+	})
+	if err != nil {
+		uploadPartFailures.Inc()
+		respondWithErrorCode(w, http.StatusBadGateway, errCodeStorageFailed, "Couldn't store part", err)
+		return
+	}
+	uploadPartDuration.Observe(time.Since(start).Seconds())
+	uploadPartBytes.Add(float64(n))
+
+	hashState, err := checksum.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload checksum", err)
+		return
+	}
+	partChecksum := s3Checksums{CRC32C: output.ChecksumCRC32C, SHA256: output.ChecksumSHA256}.value(types.ChecksumAlgorithm(session.ChecksumAlgorithm))
+	session.Parts = append(session.Parts, database.UploadPart{
+		Number:   int32(partNumber),
+		Size:     n,
+		ETag:     aws.ToString(output.ETag),
+		Checksum: partChecksum,
+	})
+	session.Received += n
+	session.HashState = hashState
+	err = cfg.db.UpdateUploadSession(session)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update upload session", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, newUploadSessionResponse(session))
+}
+
+// handlerUploadSessionFinalize checks the upload is complete and matches its
+// checksum, then records it as the video's original, creating the video if
+// the session was for a new one, and starts processing it. Finalizing a
+// finalized session returns the same result, and one that failed part way
+// through can be retried.
+func (cfg *apiConfig) handlerUploadSessionFinalize(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Session uploadSessionResponse `json:"session"`
+		Job     database.Job          `json:"job"`
+	}
+
+	sessionID, userID, ok := cfg.uploadSessionRequest(w, r)
+	if !ok {
+		return
+	}
+
+	sessionLock, ok := cfg.lockUploadSession(w, sessionID)
+	if !ok {
+		return
+	}
+	defer sessionLock.Release()
+
+	session, ok := cfg.getUploadSession(w, sessionID, userID)
+	if !ok {
+		return
+	}
+	if session.Status == database.UploadSessionStatusFinalized && session.JobID != nil {
+		job, err := cfg.db.GetJob(*session.JobID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get processing job", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, response{Session: newUploadSessionResponse(session), Job: job})
+		return
+	}
+	if !checkUploadSessionOpen(w, session) {
+		return
+	}
+	if session.Received != session.Size {
+		respondWithUploadSessionConflict(w, http.StatusConflict, errCodeConflict, "Upload is incomplete", session)
+		return
+	}
+
+	if session.UploadID != "" {
+		checksum, err := restoreUploadHash(session.HashState)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't restore upload checksum", err)
+			return
+		}
+		if session.ChecksumSHA256 != "" && hex.EncodeToString(checksum.Sum(nil)) != session.ChecksumSHA256 {
+			// The parts can't be sent again, so the session is no use
+			cfg.closeUploadSession(&session, database.UploadSessionStatusAborted)
+			respondWithUploadSessionConflict(w, http.StatusUnprocessableEntity, errCodeChecksumMismatch, "Uploaded data doesn't match the checksum", session)
+			return
+		}
+
+		algorithm := types.ChecksumAlgorithm(session.ChecksumAlgorithm)
+		parts := make([]types.CompletedPart, len(session.Parts))
+		for i, part := range session.Parts {
+			parts[i] = completedPart(part.Number, aws.String(part.ETag), algorithm, part.Checksum)
+		}
+		_, err = cfg.storage.CompleteMultipartUpload(r.Context(), &s3.CompleteMultipartUploadInput{
+			Bucket:          &session.UploadBucket,
+			Key:             &session.UploadKey,
+			UploadId:        &session.UploadID,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+			ChecksumType:    multipartChecksumType(algorithm),
+		})
+		if err != nil {
+			respondWithErrorCode(w, http.StatusBadGateway, errCodeStorageFailed, "Couldn't complete upload", err)
+			return
+		}
+		// From here a retry carries on with the completed object
+		session.UploadID = ""
+		err = cfg.db.UpdateUploadSession(session)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update upload session", err)
+			return
+		}
+	}
+	uploadURL := fmt.Sprintf("%s,%s", session.UploadBucket, session.UploadKey)
+
+	// Hold the video until it's updated
+	videoLock, ok := cfg.lockVideo(w, session.VideoID)
+	if !ok {
+		return
+	}
+	defer videoLock.Release()
+
+	video, err := cfg.db.GetVideo(session.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		if !session.CreateVideo {
+			cfg.closeUploadSession(&session, database.UploadSessionStatusAborted)
+			respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+			return
+		}
+		video, err = cfg.db.CreateVideoWithID(session.VideoID, database.CreateVideoParams{
+			Title:          session.Title,
+			Description:    session.Description,
+			UserID:         session.UserID,
+			OrganizationID: session.OrganizationID,
+		})
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+			return
+		}
+	} else {
+		canEdit, err := cfg.userCanEditVideo(userID, video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+			return
+		}
+		if !canEdit {
+			respondWithError(w, http.StatusUnauthorized, "User not authorized to update this video", nil)
+			return
+		}
+		if !checkNotOnLegalHold(w, video) {
+			return
+		}
+	}
+
+	// The upload replaces any earlier original
+	if !isOriginal(video, uploadURL) {
+		cfg.trackAsset(video.ID, database.AssetKindOriginal, database.AssetStorageS3, uploadURL)
+		originalChecksum, err := cfg.storedChecksum(r.Context(), session.UploadBucket, session.UploadKey)
+		if err != nil {
+			respondWithErrorCode(w, http.StatusBadGateway, errCodeStorageFailed, "Couldn't check uploaded file", err)
+			return
+		}
+		previousOriginalURL := video.OriginalURL
+		video.OriginalURL = &uploadURL
+		video.OriginalChecksum = originalChecksum
+		err = cfg.db.UpdateVideo(video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+		if previousOriginalURL != nil {
+			cfg.releaseOriginal(video.ID, *previousOriginalURL)
+		}
+	}
+
+	// Without a local source file the job fetches the original from S3
+	job, err := cfg.db.CreateJob(database.CreateJobParams{
+		VideoID:     video.ID,
+		MediaType:   session.MediaType,
+		AudioTracks: session.AudioTracks,
+	})
+	if errors.Is(err, database.ErrJobInProgress) {
+		respondWithProcessingConflict(w, job)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create processing job", err)
+		return
+	}
+	cfg.enqueueJob(job.ID)
+	cfg.recordUpload(w, userID, video.ID)
+
+	session.Status = database.UploadSessionStatusFinalized
+	session.JobID = &job.ID
+	err = cfg.db.UpdateUploadSession(session)
+	if err != nil {
+		logging.Warnf("Couldn't mark upload session %s finalized: %v", session.ID, err)
+	}
+
+	respondWithJSON(w, http.StatusAccepted, response{Session: newUploadSessionResponse(session), Job: job})
+}
+
+// handlerUploadSessionAbort discards an open session and whatever was
+// uploaded to it.
+func (cfg *apiConfig) handlerUploadSessionAbort(w http.ResponseWriter, r *http.Request) {
+	sessionID, userID, ok := cfg.uploadSessionRequest(w, r)
+	if !ok {
+		return
+	}
+
+	sessionLock, ok := cfg.lockUploadSession(w, sessionID)
+	if !ok {
+		return
+	}
+	defer sessionLock.Release()
+
+	session, ok := cfg.getOpenUploadSession(w, sessionID, userID)
+	if !ok {
+		return
+	}
+	cfg.closeUploadSession(&session, database.UploadSessionStatusAborted)
+
+	respondWithJSON(w, http.StatusOK, newUploadSessionResponse(session))
+}
+
+// uploadSessionRequest reads the session ID and user of a request about an
+// upload session.
+func (cfg *apiConfig) uploadSessionRequest(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	sessionID, err := uuid.Parse(r.PathValue("sessionID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload session ID", err)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return uuid.Nil, uuid.Nil, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return uuid.Nil, uuid.Nil, false
+	}
+	return sessionID, userID, true
+}
+
+// getUploadSession responds with a 404 unless the session exists and
+// belongs to the user.
+func (cfg *apiConfig) getUploadSession(w http.ResponseWriter, sessionID, userID uuid.UUID) (database.UploadSession, bool) {
+	session, err := cfg.db.GetUploadSession(sessionID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload session", err)
+		return database.UploadSession{}, false
+	}
+	if session.ID == uuid.Nil || session.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Couldn't get upload session", nil)
+		return database.UploadSession{}, false
+	}
+	return session, true
+}
+
+// getOpenUploadSession is getUploadSession for requests that need the
+// session to still accept data.
+func (cfg *apiConfig) getOpenUploadSession(w http.ResponseWriter, sessionID, userID uuid.UUID) (database.UploadSession, bool) {
+	session, ok := cfg.getUploadSession(w, sessionID, userID)
+	if !ok || !checkUploadSessionOpen(w, session) {
+		return database.UploadSession{}, false
+	}
+	return session, true
+}
+
+// checkUploadSessionOpen responds with an error unless the session still
+// accepts data. Sessions that ran out of time are gone for good.
+func checkUploadSessionOpen(w http.ResponseWriter, session database.UploadSession) bool {
+	switch {
+	case session.Status == database.UploadSessionStatusExpired,
+		session.Status == database.UploadSessionStatusOpen && time.Now().After(session.ExpiresAt):
+		respondWithUploadSessionConflict(w, http.StatusGone, errCodeGone, "Upload session has expired", session)
+		return false
+	case session.Status != database.UploadSessionStatusOpen:
+		respondWithUploadSessionConflict(w, http.StatusConflict, errCodeConflict, "Upload session is closed", session)
+		return false
+	}
+	return true
+}
+
+// restoreUploadHash returns a SHA-256 that has seen the parts hashState was
+// saved after.
+func restoreUploadHash(hashState []byte) (hash.Hash, error) {
+	checksum := sha256.New()
+	if len(hashState) == 0 {
+		return checksum, nil
+	}
+	err := checksum.(encoding.BinaryUnmarshaler).UnmarshalBinary(hashState)
+	if err != nil {
+		return nil, err
+	}
+	return checksum, nil
+}
+
+// closeUploadSession stops a session accepting data and discards what was
+// uploaded, unless it already became the video's original.
+func (cfg *apiConfig) closeUploadSession(session *database.UploadSession, status string) {
+	if session.UploadID != "" {
+		cfg.abortMultipartUpload(session.UploadBucket, session.UploadKey, session.UploadID)
+	} else {
+		uploadURL := fmt.Sprintf("%s,%s", session.UploadBucket, session.UploadKey)
+		video, err := cfg.db.GetVideo(session.VideoID)
+		if err != nil {
+			logging.Warnf("Couldn't get video %s to discard upload session %s: %v", session.VideoID, session.ID, err)
+		} else if !isOriginal(video, uploadURL) {
+			err := cfg.deleteObject(session.UploadBucket, session.UploadKey)
+			if err != nil {
+				logging.Warnf("Couldn't delete upload of session %s: %v", session.ID, err)
+			}
+		}
+	}
+
+	session.Status = status
+	session.UploadID = ""
+	err := cfg.db.UpdateUploadSession(*session)
+	if err != nil {
+		logging.Warnf("Couldn't close upload session %s: %v", session.ID, err)
+	}
+}
+
+func (cfg *apiConfig) abortMultipartUpload(bucket, key, uploadID string) {
+	_, err := cfg.storage.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+		Bucket:   &bucket,
+		Key:      &key,
+		UploadId: &uploadID,
+	})
+	if err != nil {
+		logging.Warnf("Couldn't abort upload %s: %v", uploadID, err)
+	}
+}
+
+// pruneUploadSessions discards sessions that expired while open, and
+// forgets those closed longer than uploadSessionRetention ago.
+func (cfg *apiConfig) pruneUploadSessions() error {
+	sessions, err := cfg.db.GetExpiredUploadSessions(time.Now())
+	if err != nil {
+		return fmt.Errorf("couldn't list expired upload sessions: %w", err)
+	}
+	for _, session := range sessions {
+		cfg.closeUploadSession(&session, database.UploadSessionStatusExpired)
+	}
+	return cfg.db.DeleteClosedUploadSessionsBefore(time.Now().Add(-uploadSessionRetention))
+}
@@ -1,16 +1,13 @@
 package main
 
 import (
-	"crypto/rand"
-	"encoding/base64"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
 	"github.com/google/uuid"
 )
 
@@ -65,10 +62,17 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	case "image/png":
 		fileExtension = "png"
 	default:
-		respondWithError(w, http.StatusBadRequest, "Invalid file type. Only JPEG and PNG images are allowed", nil)
+		respondWithErrorCode(w, http.StatusBadRequest, errCodeUnsupportedImage, "Invalid file type. Only JPEG and PNG images are allowed", nil)
 		return
 	}
 
+	// Hold the video until it's updated
+	videoLock, ok := cfg.lockVideo(w, videoID)
+	if !ok {
+		return
+	}
+	defer videoLock.Release()
+
 	// Get video metadata from database
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
@@ -76,43 +80,63 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Check if authenticated user is the video owner
-	if video.UserID != userID {
+	// Check if authenticated user owns the video, directly or as an editor of
+	// the organization it belongs to
+	canEdit, err := cfg.userCanEditVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canEdit {
 		respondWithError(w, http.StatusUnauthorized, "User not authorized to update this video", nil)
 		return
 	}
+	if !checkNotOnLegalHold(w, video) {
+		return
+	}
 
-	// Generate random filename using crypto/rand
-	randomBytes := make([]byte, 32)
-	_, err = rand.Read(randomBytes)
+	// Read the upload so it can be optimized before storing
+	originalData, err := io.ReadAll(file)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't generate random filename", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't read file", err)
 		return
 	}
-	randomString := base64.RawURLEncoding.EncodeToString(randomBytes)
-
-	// Create file path: /assets/<randomString>.<file_extension>
-	filename := fmt.Sprintf("%s.%s", randomString, fileExtension)
-	filePath := filepath.Join(cfg.assetsRoot, filename)
-
-	// Create the new file
-	newFile, err := os.Create(filePath)
+	data, err := optimizeImage(originalData, mediaType, cfg.thumbnailJPEGQuality)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create file", err)
+		respondWithError(w, http.StatusBadRequest, "Invalid image data", err)
 		return
 	}
-	defer newFile.Close()
+	logging.Debugf("Optimized thumbnail for video %s: %d -> %d bytes", videoID, len(originalData), len(data))
 
-	// Copy contents from multipart.File to the new file
-	_, err = io.Copy(newFile, file)
+	thumbnailURL, err := cfg.storeThumbnailImage(data, mediaType, fileExtension)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't write file", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't store thumbnail", err)
+		return
+	}
+
+	// Flagged thumbnails wait for a moderator instead of being published
+	if flagged, labels := cfg.classifyThumbnail(video.ID, data); flagged {
+		cfg.holdThumbnail(&video, thumbnailURL, labels)
+		err = cfg.db.UpdateVideo(video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+		signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+			return
+		}
+		respondWithJSON(w, http.StatusAccepted, signedVideo)
 		return
 	}
 
 	// Update video metadata with thumbnail URL
-	thumbnailURL := fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, filename)
+	previousThumbnailURL := video.ThumbnailURL
 	video.ThumbnailURL = &thumbnailURL
+	originalSize, optimizedSize := int64(len(originalData)), int64(len(data))
+	video.ThumbnailOriginalSize = &originalSize
+	video.ThumbnailSize = &optimizedSize
 
 	// Update the record in database
 	err = cfg.db.UpdateVideo(video)
@@ -120,7 +144,38 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
 		return
 	}
+	cfg.trackThumbnail(video)
+	cfg.checkStorageAlerts(video.UserID)
+	if previousThumbnailURL != nil && *previousThumbnailURL != thumbnailURL {
+		cfg.releaseThumbnail(video.ID, previousThumbnailURL)
+	}
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
 
 	// Respond with updated video metadata
-	respondWithJSON(w, http.StatusOK, video)
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
+
+// storeThumbnailImage stores a thumbnail with storeImage and returns the URL
+// to record for it.
+func (cfg *apiConfig) storeThumbnailImage(data []byte, mediaType, fileExtension string) (string, error) {
+	return cfg.storeImage(data, mediaType, fileExtension, "thumbnails")
+}
+
+// storeImage stores an image in S3, below keyPrefix, when thumbnails have
+// their own bucket, otherwise locally, and returns the URL to record for it.
+// Either way it's named by its content so a replaced image gets a new URL.
+func (cfg *apiConfig) storeImage(data []byte, mediaType, fileExtension, keyPrefix string) (string, error) {
+	if _, ok := cfg.s3ClassBuckets[objectClassThumbnail]; ok {
+		return cfg.uploadImageObject(data, mediaType, fileExtension, keyPrefix)
+	}
+	filename, err := cfg.writeContentAddressedAsset(data, fileExtension)
+	if err != nil {
+		return "", err
+	}
+	return cfg.assetURL(filename), nil
 }
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// testPNG returns a small PNG image.
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 32, 18)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestHandlerUploadThumbnail(t *testing.T) {
+	cfg, storage, _ := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, userID)
+
+	w := httptest.NewRecorder()
+	cfg.handlerUploadThumbnail(w, newUploadRequest(t, "/api/thumbnail_upload/"+video.ID.String(), token, video.ID, "thumbnail", "thumb.png", "image/png", testPNG(t)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var response database.Video
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a thumbnails bucket, thumbnails are served from the assets
+	// directory
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.ThumbnailURL == nil || !strings.HasPrefix(*video.ThumbnailURL, "http://localhost:8091/assets/") {
+		t.Fatalf("thumbnail URL = %v, want a local asset", video.ThumbnailURL)
+	}
+	if response.ThumbnailURL == nil || *response.ThumbnailURL != *video.ThumbnailURL {
+		t.Errorf("response thumbnail URL = %v, want %s", response.ThumbnailURL, *video.ThumbnailURL)
+	}
+	_, err = os.Stat(filepath.Join(cfg.assetsRoot, path.Base(*video.ThumbnailURL)))
+	if err != nil {
+		t.Errorf("thumbnail wasn't written to the assets directory: %v", err)
+	}
+	if names := storage.names(); len(names) != 0 {
+		t.Errorf("stored %v, want nothing in the bucket", names)
+	}
+}
+
+func TestHandlerUploadThumbnailToBucket(t *testing.T) {
+	cfg, storage, _ := newTestConfig(t)
+	cfg.s3ClassBuckets[objectClassThumbnail] = "tubely-thumbnails"
+	userID, token := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, userID)
+
+	w := httptest.NewRecorder()
+	cfg.handlerUploadThumbnail(w, newUploadRequest(t, "/api/thumbnail_upload/"+video.ID.String(), token, video.ID, "thumbnail", "thumb.png", "image/png", testPNG(t)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.ThumbnailURL == nil {
+		t.Fatal("thumbnail URL wasn't recorded")
+	}
+	bucket, key, err := parseBucketKey(*video.ThumbnailURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	object, ok := storage.object(bucket, key)
+	if bucket != "tubely-thumbnails" || !ok {
+		t.Fatalf("thumbnail stored at %s, want it in tubely-thumbnails, have %v", *video.ThumbnailURL, storage.names())
+	}
+	if object.contentType != "image/png" {
+		t.Errorf("content type = %s, want image/png", object.contentType)
+	}
+
+	var response database.Video
+	err = json.Unmarshal(w.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.ThumbnailURL == nil || !strings.HasPrefix(*response.ThumbnailURL, "https://storage.test/tubely-thumbnails/") {
+		t.Errorf("response thumbnail URL = %v, want it presigned", response.ThumbnailURL)
+	}
+}
+
+func TestHandlerUploadThumbnailRejectsOtherTypes(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, userID)
+
+	w := httptest.NewRecorder()
+	cfg.handlerUploadThumbnail(w, newUploadRequest(t, "/api/thumbnail_upload/"+video.ID.String(), token, video.ID, "thumbnail", "thumb.gif", "image/gif", []byte("GIF89a")))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.ThumbnailURL != nil {
+		t.Errorf("thumbnail URL = %s, want none", *video.ThumbnailURL)
+	}
+}
@@ -1,142 +1,166 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"crypto/rand"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
 	"os"
-	"os/exec"
-	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
 	"github.com/google/uuid"
 )
 
-type FFProbeOutput struct {
-	Streams []struct {
-		Width  int `json:"width"`
-		Height int `json:"height"`
-	} `json:"streams"`
-}
-
-func generatePresignedURL(s3Client *s3.Client, bucket, key string, expireTime time.Duration) (string, error) {
-	// Create a presign client
-	presignClient := s3.NewPresignClient(s3Client)
-
-	// Create presigned URL
-	presignedReq, err := presignClient.PresignGetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket: &bucket,
-		Key:    &key,
-	}, s3.WithPresignExpires(expireTime))
+func generatePresignedURL(presigner objectPresigner, bucket, key string, expireTime time.Duration) (string, error) {
+	presignedURL, err := presigner.presignGet(context.TODO(), bucket, key, expireTime)
 	if err != nil {
 		return "", fmt.Errorf("failed to create presigned URL: %w", err)
 	}
+	return presignedURL, nil
+}
 
-	return presignedReq.URL, nil
+// signThumbnailURL presigns a thumbnail stored in S3. Local asset URLs are
+// returned unchanged.
+func (cfg *apiConfig) signThumbnailURL(thumbnailURL, regionHint string) (string, error) {
+	if !isBucketKey(thumbnailURL) {
+		return thumbnailURL, nil
+	}
+	bucket, key, err := parseBucketKey(thumbnailURL)
+	if err != nil {
+		return "", err
+	}
+	client, bucket := cfg.presignTarget(bucket, regionHint)
+	presignedURL, err := cfg.presignGetURL(client, bucket, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate presigned thumbnail URL: %w", err)
+	}
+	return presignedURL, nil
 }
 
-func (cfg *apiConfig) dbVideoToSignedVideo(video database.Video) (database.Video, error) {
+// dbVideoToSignedVideo fills in the display fields and presigns stored S3
+// locations, except for blocked videos. regionHint, from the client's region header, picks the closest
+// replica to sign for.
+func (cfg *apiConfig) dbVideoToSignedVideo(video database.Video, regionHint string) (database.Video, error) {
+	video = withDisplayFields(video)
+	// Nothing of a blocked video is signed, even for its owner
+	if video.Blocked {
+		placeholderURL := cfg.placeholderThumbnailURL(video.ID)
+		video.ThumbnailURL = &placeholderURL
+		video.VideoURL = nil
+		return video, nil
+	}
+	if video.ThumbnailURL == nil {
+		placeholderURL := cfg.placeholderThumbnailURL(video.ID)
+		video.ThumbnailURL = &placeholderURL
+	}
+	thumbnailURL, err := cfg.signThumbnailURL(*video.ThumbnailURL, regionHint)
+	if err != nil {
+		return video, err
+	}
+	video.ThumbnailURL = &thumbnailURL
+
 	// Check if VideoURL exists and contains bucket,key format
 	if video.VideoURL == nil || *video.VideoURL == "" {
 		return video, nil // Return as-is if no VideoURL
 	}
 
 	// Split the VideoURL on comma to get bucket and key
-	parts := strings.Split(*video.VideoURL, ",")
-	if len(parts) != 2 {
-		return video, fmt.Errorf("invalid video URL format, expected 'bucket,key' but got: %s", *video.VideoURL)
+	bucket, key, err := parseBucketKey(*video.VideoURL)
+	if err != nil {
+		return video, err
 	}
 
-	bucket := parts[0]
-	key := parts[1]
-
 	// Generate presigned URL (expires in 1 hour)
-	presignedURL, err := generatePresignedURL(cfg.s3Client, bucket, key, time.Hour)
+	client, bucket := cfg.presignTarget(bucket, regionHint)
+	presignedURL, err := cfg.presignGetURL(client, bucket, key)
 	if err != nil {
 		return video, fmt.Errorf("failed to generate presigned URL: %w", err)
 	}
 
+	if cfg.presignLog != nil {
+		cfg.presignLog.record(video.ID)
+	}
+
 	// Update the video with presigned URL
 	video.VideoURL = &presignedURL
 	return video, nil
 }
 
-func processVideoForFastStart(filePath string) (string, error) {
-	// Create output file path by appending .processing
-	outputPath := filePath + ".processing"
+// maxVideoUploadSize is the largest video file accepted, 1 GB.
+const maxVideoUploadSize = 1 << 30
 
-	// Create the ffmpeg command
-	cmd := exec.Command("ffmpeg", "-i", filePath, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputPath)
-
-	// Run the command
-	err := cmd.Run()
-	if err != nil {
-		return "", fmt.Errorf("failed to process video with ffmpeg: %w", err)
+// respondWithTooLarge rejects an upload over limit bytes, telling the client
+// what the limit is.
+func respondWithTooLarge(w http.ResponseWriter, limit int64) {
+	type response struct {
+		errorResponse
+		MaxBytes int64 `json:"max_bytes"`
 	}
-
-	return outputPath, nil
+	respondWithJSON(w, http.StatusRequestEntityTooLarge, response{
+		errorResponse: errorResponse{
+			Error: localize(w, fmt.Sprintf("Upload is larger than the maximum of %d bytes", limit)),
+			Code:  errCodeVideoTooLarge,
+		},
+		MaxBytes: limit,
+	})
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
-	// Create the ffprobe command
-	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
-
-	// Create a buffer to capture stdout
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
-
-	// Run the command
-	err := cmd.Run()
-	if err != nil {
-		return "", fmt.Errorf("failed to run ffprobe: %w", err)
+// respondWithProcessingConflict rejects a request to process a video that
+// already has a job queued or running, returning that job so the client can
+// follow it instead.
+func respondWithProcessingConflict(w http.ResponseWriter, job database.Job) {
+	type response struct {
+		errorResponse
+		Job database.Job `json:"job"`
 	}
+	respondWithJSON(w, http.StatusConflict, response{
+		errorResponse: errorResponse{
+			Error: localize(w, "Video is already being processed"),
+			Code:  errCodeProcessingInProgress,
+		},
+		Job: job,
+	})
+}
 
-	// Parse the JSON output
-	var output FFProbeOutput
-	err = json.Unmarshal(stdout.Bytes(), &output)
+// checkNotProcessing responds with a 409 if the video already has a job
+// queued or running, so a second upload is turned away before it's read.
+// It returns false when the request must not go ahead. CreateJob enforces
+// this again, for requests that pass the check at the same time.
+func (cfg *apiConfig) checkNotProcessing(w http.ResponseWriter, videoID uuid.UUID) bool {
+	job, err := cfg.db.GetLatestJobForVideo(videoID)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse ffprobe output: %w", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get processing job", err)
+		return false
 	}
-
-	// Check if we have stream data
-	if len(output.Streams) == 0 {
-		return "", fmt.Errorf("no streams found in video")
+	if job.Status == database.JobStatusQueued || job.Status == database.JobStatusRunning {
+		respondWithProcessingConflict(w, job)
+		return false
 	}
+	return true
+}
 
-	// Get width and height from first stream
-	width := output.Streams[0].Width
-	height := output.Streams[0].Height
-
-	if width == 0 || height == 0 {
-		return "", fmt.Errorf("invalid dimensions: width=%d, height=%d", width, height)
-	}
-
-	// Calculate aspect ratio and determine category
-	ratio := float64(width) / float64(height)
-
-	// 16:9 = 1.777..., 9:16 = 0.5625
-	// Using tolerance for rounding errors
-	if ratio >= 1.7 && ratio <= 1.8 {
-		return "16:9", nil
-	} else if ratio >= 0.55 && ratio <= 0.58 {
-		return "9:16", nil
-	} else {
-		return "other", nil
+// limitUploadBody caps the request body at limit bytes. A Content-Length over
+// the limit is rejected before any of the body is read, and it returns false.
+// A body without a Content-Length is still cut off by the limit while reading.
+func limitUploadBody(w http.ResponseWriter, r *http.Request, limit int64) bool {
+	if r.ContentLength > limit {
+		respondWithTooLarge(w, limit)
+		return false
 	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	return true
 }
 
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	// Set upload limit to 1 GB
-	r.Body = http.MaxBytesReader(w, r.Body, 1<<30)
+	// Set upload limit to 1 GB, turning away oversized uploads up front
+	if !limitUploadBody(w, r, maxVideoUploadSize) {
+		return
+	}
 
 	// Extract videoID from URL path parameters
 	videoIDString := r.PathValue("videoID")
@@ -165,136 +189,192 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Check if authenticated user is the video owner
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "User not authorized to update this video", nil)
-		return
-	}
-
-	// Parse the form data
-	err = r.ParseMultipartForm(1 << 30) // 1 GB limit
+	// Check if authenticated user owns the video, directly or as an editor of
+	// the organization it belongs to
+	canEdit, err := cfg.userCanEditVideo(userID, video)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't parse form", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
 		return
 	}
-
-	// Get the video file from the form
-	file, header, err := r.FormFile("video")
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Couldn't get video file from form", err)
+	if !canEdit {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized to update this video", nil)
 		return
 	}
-	defer file.Close()
-
-	// Validate that it's an MP4 video
-	contentType := header.Header.Get("Content-Type")
-	mediaType, _, err := mime.ParseMediaType(contentType)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type header", err)
+	if !checkNotOnLegalHold(w, video) {
 		return
 	}
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type. Only MP4 videos are allowed", nil)
+
+	// Turn away a second upload while the video is being processed
+	if !cfg.checkNotProcessing(w, video.ID) {
 		return
 	}
 
-	// Create a temporary file
-	tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't create temporary file", err)
+	// Check the quota before accepting any of the upload
+	if !cfg.checkUploadQuota(w, userID) || !cfg.checkStorageQuota(w, userID) {
 		return
 	}
-	defer tempFile.Close()
-	defer os.Remove(tempFile.Name())
 
-	// Copy contents from multipart file to temp file
-	_, err = io.Copy(tempFile, file)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't write to temporary file", err)
+	// Stream the form straight to a temporary file rather than buffering it
+	// first, so a client that disconnects stops the copy. The file outlives
+	// the request so the job can be resumed if the server restarts before
+	// processing finishes
+	upload, ok := receiveVideoUpload(w, r)
+	if !ok {
 		return
 	}
-
-	// Get the aspect ratio of the video
-	aspectRatio, err := getVideoAspectRatio(tempFile.Name())
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't determine video aspect ratio", err)
+	keepSource := false
+	defer func() {
+		if !keepSource {
+			os.Remove(upload.path)
+		}
+	}()
+
+	// Nothing is recorded for an upload the client gave up on
+	if err := r.Context().Err(); err != nil {
+		logging.Debugf("Client disconnected before upload of video %s was recorded: %v", video.ID, err)
 		return
 	}
 
-	// Process video for fast start
-	processedFilePath, err := processVideoForFastStart(tempFile.Name())
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't process video for fast start", err)
+	// Persist the processing job before handing it to the workers
+	job, err := cfg.db.CreateJob(database.CreateJobParams{
+		VideoID:     video.ID,
+		SourcePath:  upload.path,
+		MediaType:   upload.mediaType,
+		AudioTracks: upload.audioTracks,
+	})
+	if errors.Is(err, database.ErrJobInProgress) {
+		respondWithProcessingConflict(w, job)
 		return
 	}
-	defer os.Remove(processedFilePath) // Clean up processed file
-
-	// Open the processed file for upload
-	processedFile, err := os.Open(processedFilePath)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't open processed file", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create processing job", err)
 		return
 	}
-	defer processedFile.Close()
+	keepSource = true
+	cfg.enqueueJob(job.ID)
+	cfg.recordUpload(w, userID, video.ID)
 
-	// Determine prefix based on aspect ratio
-	var prefix string
-	switch aspectRatio {
-	case "16:9":
-		prefix = "landscape"
-	case "9:16":
-		prefix = "portrait"
-	default:
-		prefix = "other"
-	}
+	respondWithJSON(w, http.StatusAccepted, job)
+}
 
-	// Reset file pointer to beginning
-	_, err = tempFile.Seek(0, io.SeekStart)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't reset file pointer", err)
-		return
+// videoUpload is a video received from an upload form, in a temporary file.
+type videoUpload struct {
+	path        string
+	mediaType   string
+	audioTracks []int
+}
+
+// maxUploadFieldSize bounds the form fields other than the video.
+const maxUploadFieldSize = 1 << 10
+
+// receiveVideoUpload reads the "video" file and optional "audio_tracks"
+// field of a multipart upload, in whatever order they come. The video is
+// copied to a temporary file as it arrives, and the copy stops as soon as
+// the client disconnects. On failure it responds, removes anything written
+// and returns false.
+func receiveVideoUpload(w http.ResponseWriter, r *http.Request) (videoUpload, bool) {
+	var upload videoUpload
+	fail := func(status int, errCode errorCode, msg string, err error) (videoUpload, bool) {
+		if upload.path != "" {
+			os.Remove(upload.path)
+		}
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			respondWithTooLarge(w, maxBytesErr.Limit)
+		case r.Context().Err() != nil:
+			// The client is gone, so there's no one to respond to
+			logging.Debugf("Client disconnected during upload: %v", err)
+		default:
+			respondWithErrorCode(w, status, errCode, msg, err)
+		}
+		return videoUpload{}, false
 	}
 
-	// Generate random key for S3 with prefix
-	randomBytes := make([]byte, 32)
-	_, err = rand.Read(randomBytes)
+	reader, err := r.MultipartReader()
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't generate random key", err)
-		return
+		return fail(http.StatusBadRequest, errCodeInvalidRequest, "Couldn't parse form", err)
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(http.StatusBadRequest, errCodeInvalidRequest, "Couldn't parse form", err)
+		}
+
+		switch part.FormName() {
+		case "audio_tracks":
+			// Optional comma-separated list of audio track indices to keep
+			value, err := io.ReadAll(io.LimitReader(part, maxUploadFieldSize))
+			if err != nil {
+				return fail(http.StatusBadRequest, errCodeInvalidRequest, "Couldn't parse form", err)
+			}
+			upload.audioTracks, err = parseTrackSelection(string(value))
+			if err != nil {
+				return fail(http.StatusBadRequest, errCodeInvalidRequest, "Invalid audio track selection", err)
+			}
+
+		case "video":
+			if upload.path != "" {
+				return fail(http.StatusBadRequest, errCodeInvalidRequest, "Only one video file can be uploaded", nil)
+			}
+
+			// Validate that it's an MP4 video before reading any of it
+			mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+			if err != nil {
+				return fail(http.StatusBadRequest, errCodeInvalidRequest, "Invalid Content-Type header", err)
+			}
+			if mediaType != "video/mp4" {
+				return fail(http.StatusBadRequest, errCodeUnsupportedContainer, "Invalid file type. Only MP4 videos are allowed", nil)
+			}
+			upload.mediaType = mediaType
+
+			tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
+			if err != nil {
+				return fail(http.StatusInternalServerError, errCodeInternal, "Couldn't create temporary file", err)
+			}
+			upload.path = tempFile.Name()
+			src := &contextReader{ctx: r.Context(), r: part}
+			_, err = io.Copy(tempFile, src)
+			closeErr := tempFile.Close()
+			if src.err != nil {
+				return fail(http.StatusBadRequest, errCodeInvalidRequest, "Couldn't read video file from form", src.err)
+			}
+			if err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fail(http.StatusInternalServerError, errCodeInternal, "Couldn't write to temporary file", err)
+			}
+		}
+		part.Close()
 	}
-	randomString := base64.RawURLEncoding.EncodeToString(randomBytes)
-	s3Key := fmt.Sprintf("%s/%s.mp4", prefix, randomString)
 
-	// Upload to S3 using the processed file
-	_, err = cfg.s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket:      &cfg.s3Bucket,
-		Key:         &s3Key,
-		Body:        processedFile,
-		ContentType: &mediaType,
-	})
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't upload to S3", err)
-		return
+	if upload.path == "" {
+		return fail(http.StatusBadRequest, errCodeInvalidRequest, "Couldn't get video file from form", nil)
 	}
+	return upload, true
+}
 
-	// Update video URL in database with bucket,key format
-	videoURL := fmt.Sprintf("%s,%s", cfg.s3Bucket, s3Key)
-	video.VideoURL = &videoURL
+// contextReader stops reading once its context is done, rather than waiting
+// for the next read of the underlying reader to fail. It keeps the error
+// that ended reading, so it can be told apart from a failure to write.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+	err error
+}
 
-	// Update the record in database
-	err = cfg.db.UpdateVideo(video)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
-		return
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		c.err = err
+		return 0, err
 	}
-
-	// Convert to signed video before responding
-	signedVideo, err := cfg.dbVideoToSignedVideo(video)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
-		return
+	n, err := c.r.Read(p)
+	if err != nil && err != io.EOF {
+		c.err = err
 	}
-
-	// Respond with updated video metadata
-	respondWithJSON(w, http.StatusOK, signedVideo)
+	return n, err
 }
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestHandlerUploadVideo(t *testing.T) {
+	cfg, storage, media := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, userID)
+	data := []byte("not really an mp4, but the stub prober doesn't mind")
+
+	w := httptest.NewRecorder()
+	cfg.handlerUploadVideo(w, newUploadRequest(t, "/api/video_upload/"+video.ID.String(), token, video.ID, "video", "clip.mp4", "video/mp4", data))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusAccepted, w.Body)
+	}
+	var job database.Job
+	err := json.Unmarshal(w.Body.Bytes(), &job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != database.JobStatusQueued || job.VideoID != video.ID {
+		t.Fatalf("job = %+v, want a queued job for video %s", job, video.ID)
+	}
+
+	cfg.runJob(job.ID)
+
+	job, err = cfg.db.GetJob(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != database.JobStatusDone {
+		t.Fatalf("job status = %s, want done (error %v)", job.Status, job.Error)
+	}
+	if len(media.probed) != 1 {
+		t.Errorf("probed %d files, want 1", len(media.probed))
+	}
+
+	video, err = cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.VideoURL == nil || video.OriginalURL == nil {
+		t.Fatalf("video URL = %v, original URL = %v, want both set", video.VideoURL, video.OriginalURL)
+	}
+	if video.Width == nil || *video.Width != 1920 || video.Height == nil || *video.Height != 1080 {
+		t.Errorf("dimensions = %v x %v, want 1920 x 1080", video.Width, video.Height)
+	}
+	for _, location := range []string{*video.VideoURL, *video.OriginalURL} {
+		bucket, key, err := parseBucketKey(location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		object, ok := storage.object(bucket, key)
+		if !ok {
+			t.Fatalf("%s wasn't stored, have %v", location, storage.names())
+		}
+		if !bytes.Equal(object.data, data) || object.contentType != "video/mp4" {
+			t.Errorf("%s = %q (%s), want the upload as video/mp4", location, object.data, object.contentType)
+		}
+	}
+	if !hasPrefixIn(storage.names(), testBucket+"/landscape/") {
+		t.Errorf("stored %v, want a landscape rendition", storage.names())
+	}
+}
+
+func TestHandlerUploadVideoRejectsOtherTypes(t *testing.T) {
+	cfg, storage, _ := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, userID)
+
+	w := httptest.NewRecorder()
+	cfg.handlerUploadVideo(w, newUploadRequest(t, "/api/video_upload/"+video.ID.String(), token, video.ID, "video", "clip.mov", "video/quicktime", []byte("movie")))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), string(errCodeUnsupportedContainer)) {
+		t.Errorf("body = %s, want error code %s", w.Body, errCodeUnsupportedContainer)
+	}
+	job, err := cfg.db.GetLatestJobForVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID != uuid.Nil {
+		t.Errorf("created job %s for a rejected upload", job.ID)
+	}
+	if names := storage.names(); len(names) != 0 {
+		t.Errorf("stored %v for a rejected upload", names)
+	}
+}
+
+func TestHandlerUploadVideoRequiresOwner(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	ownerID, _ := createTestUser(t, cfg, "owner@example.com")
+	_, otherToken := createTestUser(t, cfg, "other@example.com")
+	video := createTestVideo(t, cfg, ownerID)
+
+	w := httptest.NewRecorder()
+	cfg.handlerUploadVideo(w, newUploadRequest(t, "/api/video_upload/"+video.ID.String(), otherToken, video.ID, "video", "clip.mp4", "video/mp4", []byte("video")))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+	}
+}
+
+func TestHandlerUploadVideoRejectsTooLarge(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, userID)
+
+	r := newUploadRequest(t, "/api/video_upload/"+video.ID.String(), token, video.ID, "video", "clip.mp4", "video/mp4", []byte("video"))
+	r.ContentLength = maxVideoUploadSize + 1
+	w := httptest.NewRecorder()
+	cfg.handlerUploadVideo(w, r)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusRequestEntityTooLarge, w.Body)
+	}
+}
+
+func TestDBVideoToSignedVideo(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	userID, _ := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, userID)
+	videoURL := testBucket + ",landscape/clip.mp4"
+	thumbnailURL := testBucket + ",thumbnails/thumb.png"
+	video.VideoURL = &videoURL
+	video.ThumbnailURL = &thumbnailURL
+
+	signed, err := cfg.dbVideoToSignedVideo(video, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://storage.test/" + testBucket + "/landscape/clip.mp4?expires=3600"; signed.VideoURL == nil || *signed.VideoURL != want {
+		t.Errorf("video URL = %v, want %s", signed.VideoURL, want)
+	}
+	if want := "https://storage.test/" + testBucket + "/thumbnails/thumb.png?expires=3600"; signed.ThumbnailURL == nil || *signed.ThumbnailURL != want {
+		t.Errorf("thumbnail URL = %v, want %s", signed.ThumbnailURL, want)
+	}
+
+	// Nothing of a blocked video is signed
+	video.Blocked = true
+	signed, err = cfg.dbVideoToSignedVideo(video, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed.VideoURL != nil {
+		t.Errorf("blocked video URL = %s, want none", *signed.VideoURL)
+	}
+	if signed.ThumbnailURL == nil || *signed.ThumbnailURL != cfg.placeholderThumbnailURL(video.ID) {
+		t.Errorf("blocked thumbnail URL = %v, want the placeholder", signed.ThumbnailURL)
+	}
+}
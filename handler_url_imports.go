@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/google/uuid"
+)
+
+// handlerURLImportCreate imports the video on a page of a site like YouTube
+// as a new video of the user's, downloading it in the background with its
+// title, description, tags and thumbnail. Progress shows in the user's
+// import list and then on the video.
+func (cfg *apiConfig) handlerURLImportCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL string `json:"url"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+	if cfg.urlDownloader == nil {
+		respondWithError(w, http.StatusNotImplemented, "URL imports aren't configured", nil)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	page, err := url.Parse(params.URL)
+	if err != nil || (page.Scheme != "http" && page.Scheme != "https") || page.Host == "" {
+		respondWithError(w, http.StatusBadRequest, "URL must be an absolute http or https URL", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), cloudRequestTimeout)
+	defer cancel()
+	err = ingest.CheckPublicURL(ctx, page)
+	if errors.Is(err, ingest.ErrPrivateAddress) {
+		respondWithError(w, http.StatusBadRequest, "URL must be on a public address", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't resolve the URL's host", err)
+		return
+	}
+	if !cfg.checkUploadQuota(w, userID) || !cfg.checkStorageQuota(w, userID) {
+		return
+	}
+
+	// Every request is its own import, since pages aren't versioned the way
+	// files are and other users may import the same one
+	claimed, err := cfg.db.ClaimImport(database.CreateImportParams{
+		Source:     urlImportSource,
+		URL:        page.String(),
+		ModifiedAt: time.Now(),
+		UserID:     userID,
+	}, importMaxAttempts, time.Now().Add(-urlImportTimeout))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create import", err)
+		return
+	}
+	if claimed.ID == uuid.Nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create import", errors.New("import was already claimed"))
+		return
+	}
+
+	go cfg.importURL(claimed)
+	respondWithJSON(w, http.StatusAccepted, claimed)
+}
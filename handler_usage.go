@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+type uploadUsage struct {
+	// Limit, Remaining and ResetsAt are nil without an upload quota.
+	Used      int        `json:"used"`
+	Limit     *int       `json:"limit"`
+	Remaining *int       `json:"remaining"`
+	ResetsAt  *time.Time `json:"resets_at"`
+}
+
+type usageResponse struct {
+	Storage storageUsage `json:"storage"`
+	// Uploads counts the uploads in the last 24 hours.
+	Uploads uploadUsage `json:"uploads"`
+}
+
+// handlerUserUsage reports how much of their quotas the user has used, so
+// clients can warn before uploads start being refused.
+func (cfg *apiConfig) handlerUserUsage(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	storage, err := cfg.storageUsage(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check storage quota", err)
+		return
+	}
+
+	now := time.Now()
+	used, oldest, err := cfg.db.CountUploadsSince(userID, now.Add(-uploadQuotaWindow))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check upload quota", err)
+		return
+	}
+	uploads := uploadUsage{Used: used}
+	if cfg.uploadQuotaPerDay > 0 {
+		remaining := max(cfg.uploadQuotaPerDay-used, 0)
+		reset := now.Add(uploadQuotaWindow).UTC()
+		if oldest != nil {
+			reset = oldest.Add(uploadQuotaWindow).UTC()
+		}
+		uploads.Limit = &cfg.uploadQuotaPerDay
+		uploads.Remaining = &remaining
+		uploads.ResetsAt = &reset
+	}
+
+	respondWithJSON(w, http.StatusOK, usageResponse{Storage: storage, Uploads: uploads})
+}
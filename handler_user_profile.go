@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// handlerUserProfile serves a user's channel page: what anyone may see about
+// them and a page of their published videos. No authentication is needed,
+// so nothing private, like the email address, is included.
+func (cfg *apiConfig) handlerUserProfile(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		ID            uuid.UUID        `json:"id"`
+		CreatedAt     time.Time        `json:"created_at"`
+		AvatarURL     *string          `json:"avatar_url"`
+		BannerURL     *string          `json:"banner_url"`
+		FollowerCount int              `json:"follower_count"`
+		Videos        []database.Video `json:"videos"`
+		NextCursor    *string          `json:"next_cursor"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	user, err := cfg.db.Replica().GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+	signedUser, err := cfg.dbUserToSignedUser(*user, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+	followerCount, err := cfg.db.Replica().CountFollowers(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count followers", err)
+		return
+	}
+
+	// Newest first unless the client asks otherwise
+	query := r.URL.Query()
+	filter := database.VideoFilter{
+		Sort:      database.VideoSort(query.Get("sort")),
+		Ascending: query.Get("order") == "asc",
+		Published: true,
+	}
+	if filter.Sort == "" {
+		filter.Sort = database.VideoSortCreatedAt
+	}
+
+	// Fetch one extra to know whether there's another page
+	after, limit := pageParams(r)
+	if !checkCursorOrder(w, after, string(filter.Sort), filter.Ascending) {
+		return
+	}
+	if after != nil {
+		filter.After = &after.Cursor
+	}
+	filter.Limit = limit + 1
+
+	videos, err := cfg.db.Replica().GetVideos(userID, filter)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+	var nextCursor *string
+	if len(videos) > limit {
+		videos = videos[:limit]
+		last := videos[limit-1]
+		cursor := encodeCursor(string(filter.Sort), filter.Ascending, last.SortKey(filter.Sort), last.ID)
+		nextCursor = &cursor
+	}
+
+	signedVideos := make([]database.Video, len(videos))
+	for i, video := range videos {
+		signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+			return
+		}
+		signedVideos[i] = signedVideo
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		ID:            signedUser.ID,
+		CreatedAt:     signedUser.CreatedAt,
+		AvatarURL:     signedUser.AvatarURL,
+		BannerURL:     signedUser.BannerURL,
+		FollowerCount: followerCount,
+		Videos:        signedVideos,
+		NextCursor:    nextCursor,
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+type userSettingsResponse struct {
+	// DefaultThumbnailURL is given to new videos that have neither an
+	// uploaded thumbnail nor a frame picked from the video
+	DefaultThumbnailURL *string `json:"default_thumbnail_url"`
+}
+
+func (cfg *apiConfig) handlerUserSettingsGet(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get user", err)
+		return
+	}
+	cfg.respondWithUserSettings(w, r, *user)
+}
+
+// handlerUserDefaultThumbnailUpload sets the channel's default thumbnail
+// from an uploaded image. Videos that already have a thumbnail keep it.
+func (cfg *apiConfig) handlerUserDefaultThumbnailUpload(w http.ResponseWriter, r *http.Request) {
+	if user, ok := cfg.uploadProfileImage(w, r, profileImageDefaultThumbnail); ok {
+		cfg.respondWithUserSettings(w, r, user)
+	}
+}
+
+// handlerUserDefaultThumbnailDelete stops giving new videos a default
+// thumbnail. Videos that were given it keep it.
+func (cfg *apiConfig) handlerUserDefaultThumbnailDelete(w http.ResponseWriter, r *http.Request) {
+	if user, ok := cfg.deleteProfileImage(w, r, profileImageDefaultThumbnail); ok {
+		cfg.respondWithUserSettings(w, r, user)
+	}
+}
+
+func (cfg *apiConfig) respondWithUserSettings(w http.ResponseWriter, r *http.Request, user database.User) {
+	signedUser, err := cfg.dbUserToSignedUser(user, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, userSettingsResponse{
+		DefaultThumbnailURL: signedUser.DefaultThumbnailURL,
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// maxBatchGetVideos is how many IDs one batch get can ask for.
+const maxBatchGetVideos = 100
+
+// batchGetError is why one of the requested videos isn't in the response.
+type batchGetError struct {
+	ID    string    `json:"id"`
+	Error string    `json:"error"`
+	Code  errorCode `json:"code"`
+}
+
+// handlerVideosBatchGet returns the metadata of several videos at once, as
+// handlerVideoGet would show each of them. Videos that can't be returned are
+// listed in errors instead, so one bad ID doesn't fail the rest.
+func (cfg *apiConfig) handlerVideosBatchGet(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		IDs []string `json:"ids"`
+	}
+	type response struct {
+		// Videos are in the order they were asked for
+		Videos []database.Video `json:"videos"`
+		Errors []batchGetError  `json:"errors"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.IDs) == 0 || len(params.IDs) > maxBatchGetVideos {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Between 1 and %d video IDs are required", maxBatchGetVideos), nil)
+		return
+	}
+
+	// Authentication is optional; it only matters for the owner
+	var userID uuid.UUID
+	token, err := auth.GetBearerToken(r.Header)
+	if err == nil {
+		userID, err = auth.ValidateJWT(token, cfg.jwtSecret)
+	}
+	authenticated := err == nil
+
+	resp := response{Videos: []database.Video{}, Errors: []batchGetError{}}
+	failed := func(id, msg string, code errorCode) {
+		resp.Errors = append(resp.Errors, batchGetError{ID: id, Error: localize(w, msg), Code: code})
+	}
+
+	// Repeated IDs are only looked up and returned once
+	ids := make([]uuid.UUID, 0, len(params.IDs))
+	seen := map[uuid.UUID]bool{}
+	for _, idString := range params.IDs {
+		id, err := uuid.Parse(idString)
+		if err != nil {
+			failed(idString, "Invalid video ID", errCodeInvalidRequest)
+			continue
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	videos, err := cfg.db.GetVideosByIDs(ids)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get videos", err)
+		return
+	}
+	byID := make(map[uuid.UUID]database.Video, len(videos))
+	for _, video := range videos {
+		byID[video.ID] = video
+	}
+
+	regionHint := clientRegionHint(r)
+	for _, id := range ids {
+		video, ok := byID[id]
+		isOwner := authenticated && userID == video.UserID
+
+		// Videos held by moderation are only visible to their owner
+		held := video.ModerationStatus == database.ModerationStatusPendingReview || video.ModerationStatus == database.ModerationStatusRejected
+		if !ok || (held && !isOwner) {
+			failed(id.String(), "Couldn't get video", errCodeNotFound)
+			continue
+		}
+		if video.Blocked && !isOwner {
+			failed(id.String(), "Video is unavailable because of a takedown", errCodeVideoBlocked)
+			continue
+		}
+
+		if !isOwner {
+			cfg.showThumbnailVariant(&video)
+		}
+		signedVideo, err := cfg.dbVideoToSignedVideo(video, regionHint)
+		if err != nil {
+			logging.Errorf("Couldn't generate presigned URL for video %s: %v", id, err)
+			failed(id.String(), "Couldn't generate presigned URL", errCodeInternal)
+			continue
+		}
+		resp.Videos = append(resp.Videos, signedVideo)
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
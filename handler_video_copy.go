@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// handlerVideoCopy forks a video into a new one owned by the caller. The
+// files are copied within S3 rather than uploaded again, and the copy keeps
+// the processed metadata, so it's ready to play straight away. Title and
+// description default to the source's.
+func (cfg *apiConfig) handlerVideoCopy(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title          *string    `json:"title"`
+		Description    *string    `json:"description"`
+		OrganizationID *uuid.UUID `json:"organization_id"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	// The body is optional
+	params := parameters{}
+	err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&params)
+	if err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Title != nil && strings.TrimSpace(*params.Title) == "" {
+		respondWithError(w, http.StatusBadRequest, "Title can't be empty", nil)
+		return
+	}
+
+	source, err := cfg.db.GetVideo(videoID)
+	if err != nil || source.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	canEdit, err := cfg.userCanEditVideo(userID, source)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized to copy this video", nil)
+		return
+	}
+	// A copy wouldn't be blocked, so it would undo the takedown
+	if !checkNotBlocked(w, source) {
+		return
+	}
+	if source.VideoURL == nil || !isBucketKey(*source.VideoURL) {
+		respondWithError(w, http.StatusConflict, "Video has no processed file to copy", nil)
+		return
+	}
+
+	// Only owners and editors can add videos to an organization
+	if params.OrganizationID != nil {
+		role, err := cfg.db.GetOrganizationRole(*params.OrganizationID, userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get organization role", err)
+			return
+		}
+		if !role.CanEdit() {
+			respondWithError(w, http.StatusForbidden, "User not authorized to add videos to this organization", nil)
+			return
+		}
+	}
+	if !cfg.checkUploadQuota(w, userID) || !cfg.checkStorageQuota(w, userID) {
+		return
+	}
+
+	createParams := database.CreateVideoParams{
+		Title:          source.Title,
+		Description:    source.Description,
+		UserID:         userID,
+		OrganizationID: params.OrganizationID,
+	}
+	if params.Title != nil {
+		createParams.Title = *params.Title
+	}
+	if params.Description != nil {
+		createParams.Description = *params.Description
+	}
+	video, err := cfg.db.CreateVideo(createParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
+		return
+	}
+
+	video, err = cfg.copyVideoFiles(r.Context(), source, video)
+	if err != nil {
+		cfg.discardVideo(video)
+		respondWithErrorCode(w, http.StatusBadGateway, errCodeStorageFailed, "Couldn't copy video files", err)
+		return
+	}
+
+	// Everything processing worked out carries over, moderation included
+	video.AudioTracks = source.AudioTracks
+	video.ResolutionClass = source.ResolutionClass
+	video.HDRFormat = source.HDRFormat
+	video.Width = source.Width
+	video.Height = source.Height
+	video.SampleAspectNum = source.SampleAspectNum
+	video.SampleAspectDen = source.SampleAspectDen
+	video.VideoSize = source.VideoSize
+	video.DurationSeconds = source.DurationSeconds
+	video.ModerationStatus = source.ModerationStatus
+	video.ModerationLabels = source.ModerationLabels
+	video.CustomMetadata = source.CustomMetadata
+	video.Tags = source.Tags
+	video.Visibility = source.Visibility
+	// Thumbnails are content-addressed, so the copy shares the file
+	video.ThumbnailURL = source.ThumbnailURL
+	video.ThumbnailOriginalSize = source.ThumbnailOriginalSize
+	video.ThumbnailSize = source.ThumbnailSize
+	cfg.trackThumbnail(video)
+
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		cfg.discardVideo(video)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.recordUpload(w, userID, video.ID)
+	cfg.checkStorageAlerts(userID)
+	cfg.announceIfPublished(video.ID)
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, signedVideo)
+}
+
+// copyVideoFiles copies the source's processed file and original, if it has
+// one, to new keys for video. The copies are tracked as video's assets
+// before they're made, so a failed copy is cleaned up with it.
+func (cfg *apiConfig) copyVideoFiles(ctx context.Context, source, video database.Video) (database.Video, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	headers := cfg.videoObjectHeaders(video, "video/mp4")
+
+	rendition := "other"
+	if source.Width != nil && source.Height != nil {
+		rendition = renditionName(*source.Width, *source.Height, source.SampleAspectNum, source.SampleAspectDen)
+	}
+	key, err := cfg.objectKey(video, rendition)
+	if err != nil {
+		return video, err
+	}
+	bucket := cfg.bucketFor(objectClassRendition)
+	videoURL := fmt.Sprintf("%s,%s", bucket, key)
+	cfg.trackAsset(video.ID, database.AssetKindRendition, database.AssetStorageS3, videoURL)
+	checksum, err := cfg.copyObject(ctx, *source.VideoURL, bucket, key, headers)
+	if err != nil {
+		return video, err
+	}
+	video.VideoURL = &videoURL
+	video.VideoChecksum = checksum
+
+	if source.OriginalURL == nil || !isBucketKey(*source.OriginalURL) {
+		return video, nil
+	}
+	key, err = cfg.objectKey(video, renditionOriginal)
+	if err != nil {
+		return video, err
+	}
+	bucket = cfg.bucketFor(objectClassOriginal)
+	originalURL := fmt.Sprintf("%s,%s", bucket, key)
+	cfg.trackAsset(video.ID, database.AssetKindOriginal, database.AssetStorageS3, originalURL)
+	checksum, err = cfg.copyObject(ctx, *source.OriginalURL, bucket, key, headers)
+	if err != nil {
+		return video, err
+	}
+	video.OriginalURL = &originalURL
+	video.OriginalChecksum = checksum
+	return video, nil
+}
+
+// discardVideo deletes a video that couldn't be set up, with whatever was
+// stored for it.
+func (cfg *apiConfig) discardVideo(video database.Video) {
+	err := cfg.deleteVideoAssets(video)
+	if err != nil {
+		logging.Warnf("Couldn't delete files of discarded video %s: %v", video.ID, err)
+	}
+	err = cfg.db.DeleteVideo(video.ID)
+	if err != nil {
+		logging.Warnf("Couldn't delete discarded video %s: %v", video.ID, err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestHandlerVideoCopyRefusesBlocked(t *testing.T) {
+	cfg, storage, _ := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "owner@example.com")
+	claimantID, _ := createTestUser(t, cfg, "claimant@example.com")
+	video := createTestVideo(t, cfg, userID)
+	videoURL := testBucket + ",landscape/clip.mp4"
+	video.VideoURL = &videoURL
+	err := cfg.db.UpdateVideo(video)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	takedown, err := cfg.db.CreateTakedown(database.CreateTakedownParams{VideoID: video.ID, ClaimantUserID: claimantID, Reason: "infringing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.db.UpdateTakedownStatus(takedown.ID, database.TakedownStatusFiled, database.TakedownStatusBlocked, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/api/videos/"+video.ID.String()+"/copy", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	r.SetPathValue("videoID", video.ID.String())
+	w := httptest.NewRecorder()
+	cfg.handlerVideoCopy(w, r)
+	if w.Code != http.StatusUnavailableForLegalReasons {
+		t.Errorf("copying a blocked video: status = %d: %s, want %d", w.Code, w.Body, http.StatusUnavailableForLegalReasons)
+	}
+	videos, err := cfg.db.GetVideos(userID, database.VideoFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(videos) != 1 || len(storage.names()) != 0 {
+		t.Errorf("copying a blocked video left %d videos and objects %v, want only the source", len(videos), storage.names())
+	}
+}
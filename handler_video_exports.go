@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/google/uuid"
+)
+
+// handlerVideoExportCreate starts copying the user's videos into a bucket
+// of their own on S3 or an S3-compatible provider, with the credentials
+// they give for it, and a manifest once every file was tried. The
+// credentials are only held in memory while the export runs. Poll the
+// export for its progress.
+func (cfg *apiConfig) handlerVideoExportCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Bucket          string `json:"bucket"`
+		Region          string `json:"region"`
+		Endpoint        string `json:"endpoint"`
+		Prefix          string `json:"prefix"`
+		AccessKeyID     string `json:"access_key_id"`
+		SecretAccessKey string `json:"secret_access_key"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	fields := []fieldError{}
+	for _, required := range []struct{ field, value string }{
+		{"bucket", params.Bucket},
+		{"access_key_id", params.AccessKeyID},
+		{"secret_access_key", params.SecretAccessKey},
+	} {
+		if strings.TrimSpace(required.value) == "" {
+			fields = append(fields, fieldError{Field: required.field, In: paramInBody, Message: "is required"})
+		}
+	}
+	// The server connects to the endpoint, so it has to be on the internet
+	if params.Endpoint != "" {
+		endpoint, err := url.Parse(params.Endpoint)
+		if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+			fields = append(fields, fieldError{Field: "endpoint", In: paramInBody, Message: "must be an https URL"})
+		} else {
+			ctx, cancel := context.WithTimeout(r.Context(), cloudRequestTimeout)
+			err = ingest.CheckPublicURL(ctx, endpoint)
+			cancel()
+			if errors.Is(err, ingest.ErrPrivateAddress) {
+				fields = append(fields, fieldError{Field: "endpoint", In: paramInBody, Message: "must be a public address"})
+			} else if err != nil {
+				fields = append(fields, fieldError{Field: "endpoint", In: paramInBody, Message: "couldn't be resolved"})
+			}
+		}
+	}
+	if len(fields) > 0 {
+		respondWithFieldErrors(w, http.StatusBadRequest, "Invalid request parameters", fields)
+		return
+	}
+	if params.Region == "" {
+		params.Region = "us-east-1"
+	}
+	prefix := strings.TrimPrefix(params.Prefix, "/")
+	if prefix == "" {
+		prefix = "tubely-export-" + time.Now().UTC().Format("2006-01-02")
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	pending, err := cfg.db.HasPendingVideoExport(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video exports", err)
+		return
+	}
+	if pending {
+		respondWithError(w, http.StatusConflict, "An export is already running", nil)
+		return
+	}
+
+	exportParams := database.CreateVideoExportParams{
+		UserID:   userID,
+		Bucket:   params.Bucket,
+		Region:   params.Region,
+		Endpoint: params.Endpoint,
+		Prefix:   prefix,
+	}
+	export, err := cfg.db.CreateVideoExport(exportParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video export", err)
+		return
+	}
+	cfg.startVideoExport(export, newVideoExportClient(exportParams, params.AccessKeyID, params.SecretAccessKey))
+
+	respondWithJSON(w, http.StatusAccepted, export)
+}
+
+// handlerVideoExportsList lists the user's most recent exports, newest
+// first.
+func (cfg *apiConfig) handlerVideoExportsList(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	exports, err := cfg.db.GetVideoExportsForUser(userID, videoExportsShown)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video exports", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, exports)
+}
+
+func (cfg *apiConfig) handlerVideoExportGet(w http.ResponseWriter, r *http.Request) {
+	// The route validates the ID
+	exportID, _ := uuid.Parse(r.PathValue("exportID"))
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	export, err := cfg.db.GetVideoExport(exportID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video export", err)
+		return
+	}
+	// Other users' exports aren't revealed
+	if export.ID == uuid.Nil || export.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Video export not found", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, export)
+}
@@ -3,9 +3,14 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
 	"github.com/google/uuid"
 )
 
@@ -34,13 +39,140 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 	}
 	params.UserID = userID
 
+	// Only owners and editors can add videos to an organization
+	if params.OrganizationID != nil {
+		role, err := cfg.db.GetOrganizationRole(*params.OrganizationID, userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get organization role", err)
+			return
+		}
+		if !role.CanEdit() {
+			respondWithError(w, http.StatusForbidden, "User not authorized to add videos to this organization", nil)
+			return
+		}
+	}
+
 	video, err := cfg.db.CreateVideo(params.CreateVideoParams)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create video", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusCreated, video)
+	signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, signedVideo)
+}
+
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		// CustomMetadata is merged into the existing metadata; keys set to
+		// null are removed.
+		CustomMetadata map[string]json.RawMessage `json:"custom_metadata"`
+		// Visibility is "unlisted" or "public"; public videos are listed in
+		// the owner's feed.
+		Visibility *string `json:"visibility"`
+		// Tags replaces the video's tags.
+		Tags *[]string `json:"tags"`
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	// Hold the video until it's updated
+	videoLock, ok := cfg.lockVideo(w, videoID)
+	if !ok {
+		return
+	}
+	defer videoLock.Release()
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	canEdit, err := cfg.userCanEditVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusForbidden, "You can't update this video", nil)
+		return
+	}
+
+	if params.Title != nil {
+		if strings.TrimSpace(*params.Title) == "" {
+			respondWithError(w, http.StatusBadRequest, "Title can't be empty", nil)
+			return
+		}
+		video.Title = *params.Title
+	}
+	if params.Description != nil {
+		video.Description = *params.Description
+	}
+	if params.CustomMetadata != nil {
+		video.CustomMetadata, err = mergeCustomMetadata(video.CustomMetadata, params.CustomMetadata)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+	if params.Visibility != nil {
+		if *params.Visibility != database.VisibilityUnlisted && *params.Visibility != database.VisibilityPublic {
+			respondWithError(w, http.StatusBadRequest, "Visibility must be unlisted or public", nil)
+			return
+		}
+		video.Visibility = *params.Visibility
+	}
+	if params.Tags != nil {
+		video.Tags, err = normalizeTags(*params.Tags)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error(), err)
+			return
+		}
+	}
+
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	cfg.announceIfPublished(video.ID)
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, signedVideo)
 }
 
 func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Request) {
@@ -62,21 +194,46 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	// Hold the video until it's deleted
+	videoLock, ok := cfg.lockVideo(w, videoID)
+	if !ok {
+		return
+	}
+	defer videoLock.Release()
+
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
-	if video.UserID != userID {
-		respondWithError(w, http.StatusForbidden, "You can't delete this video", err)
+	canEdit, err := cfg.userCanEditVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canEdit {
+		respondWithError(w, http.StatusForbidden, "You can't delete this video", nil)
+		return
+	}
+	if !checkNotOnLegalHold(w, video) {
 		return
 	}
 
+	// Objects that can't be deleted now are retried by the asset GC
+	err = cfg.deleteVideoAssets(video)
+	if err != nil {
+		logging.Warnf("Couldn't delete all assets of video %s: %v", video.ID, err)
+	}
+
 	err = cfg.db.DeleteVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
+	cfg.publishEvent(events.TypeVideoDeleted, video.UserID, map[string]any{
+		"video_id": video.ID,
+		"title":    video.Title,
+	})
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -95,7 +252,39 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	signedVideo, err := cfg.dbVideoToSignedVideo(video)
+	// Authentication is optional; it only matters for the owner
+	var userID uuid.UUID
+	token, err := auth.GetBearerToken(r.Header)
+	if err == nil {
+		userID, err = auth.ValidateJWT(token, cfg.jwtSecret)
+	}
+	isOwner := err == nil && userID == video.UserID
+
+	// Videos held by moderation are only visible to their owner
+	if video.ModerationStatus == database.ModerationStatusPendingReview || video.ModerationStatus == database.ModerationStatusRejected {
+		if !isOwner {
+			respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+			return
+		}
+	}
+	// Owners still see a blocked video's metadata, to dispute the takedown
+	if !isOwner && !checkNotBlocked(w, video) {
+		return
+	}
+
+	// Viewers are shown one of the thumbnail variants being tried, if any.
+	// The owner always sees the video's own thumbnail
+	if !isOwner {
+		cfg.showThumbnailVariant(&video)
+	}
+
+	// Unchanged metadata isn't sent or signed again
+	regionHint := clientRegionHint(r)
+	if !checkNotModified(w, r, cfg.videoETag(video, isOwner, regionHint)) {
+		return
+	}
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(video, regionHint)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
 		return
@@ -105,6 +294,11 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Videos     []database.Video `json:"videos"`
+		NextCursor *string          `json:"next_cursor"`
+	}
+
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
 		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
@@ -116,15 +310,122 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	// The route validates every filter, so parse errors can't happen here
+	query := r.URL.Query()
+	filter := database.VideoFilter{
+		ResolutionClass:  query.Get("resolution_class"),
+		HDRFormat:        query.Get("hdr_format"),
+		ProcessingStatus: query.Get("status"),
+		Visibility:       query.Get("visibility"),
+		Tag:              strings.ToLower(strings.TrimSpace(query.Get("tag"))),
+	}
+	if category := query.Get("aspect_ratio"); category != "" {
+		aspectRatioFilter(&filter, category)
+	}
+	if s := query.Get("duration_min"); s != "" {
+		seconds, _ := strconv.ParseFloat(s, 64)
+		filter.MinDuration = &seconds
+	}
+	if s := query.Get("duration_max"); s != "" {
+		seconds, _ := strconv.ParseFloat(s, 64)
+		filter.MaxDuration = &seconds
+	}
+	if s := query.Get("created_after"); s != "" {
+		t, _ := time.Parse(time.RFC3339, s)
+		filter.CreatedAfter = &t
+	}
+	if s := query.Get("created_before"); s != "" {
+		t, _ := time.Parse(time.RFC3339, s)
+		filter.CreatedBefore = &t
+	}
+	if hdrString := r.URL.Query().Get("hdr"); hdrString != "" {
+		hdr, err := strconv.ParseBool(hdrString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid hdr filter", err)
+			return
+		}
+		filter.HDR = &hdr
+	}
+	// Custom metadata filters: "metadata.<key>=<value>" and "has_metadata=<key>"
+	for param, values := range r.URL.Query() {
+		key, ok := strings.CutPrefix(param, "metadata.")
+		if !ok {
+			continue
+		}
+		if !customMetadataKey.MatchString(key) {
+			respondWithFieldErrors(w, http.StatusBadRequest, "Invalid request parameters", []fieldError{
+				{Field: param, In: paramInQuery, Message: "must name a valid metadata key"},
+			})
+			return
+		}
+		if filter.Metadata == nil {
+			filter.Metadata = map[string]string{}
+		}
+		filter.Metadata[key] = values[0]
+	}
+	for _, key := range r.URL.Query()["has_metadata"] {
+		if !customMetadataKey.MatchString(key) {
+			respondWithFieldErrors(w, http.StatusBadRequest, "Invalid request parameters", []fieldError{
+				{Field: "has_metadata", In: paramInQuery, Message: "must be a valid metadata key"},
+			})
+			return
+		}
+		filter.MetadataKeys = append(filter.MetadataKeys, key)
+	}
+	// Members of any role can list an organization's videos
+	if orgIDString := r.URL.Query().Get("organization_id"); orgIDString != "" {
+		orgID, err := uuid.Parse(orgIDString)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid organization ID", err)
+			return
+		}
+		role, err := cfg.db.GetOrganizationRole(orgID, userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get organization role", err)
+			return
+		}
+		if role == "" {
+			respondWithError(w, http.StatusForbidden, "User is not a member of this organization", nil)
+			return
+		}
+		filter.OrganizationID = &orgID
+	}
+
+	// Fetch one extra to know whether there's another page
+	// Newest first unless the client asks otherwise
+	filter.Sort = database.VideoSort(query.Get("sort"))
+	if filter.Sort == "" {
+		filter.Sort = database.VideoSortCreatedAt
+	}
+	filter.Ascending = query.Get("order") == "asc"
+
+	// Fetch one extra to know whether there's another page
+	after, limit := pageParams(r)
+	if !checkCursorOrder(w, after, string(filter.Sort), filter.Ascending) {
+		return
+	}
+	if after != nil {
+		filter.After = &after.Cursor
+	}
+	filter.Limit = limit + 1
+	filter.ExcludeBlocked = true
+
+	videos, err := cfg.db.Replica().GetVideos(userID, filter)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
+	var nextCursor *string
+	if len(videos) > limit {
+		videos = videos[:limit]
+		last := videos[limit-1]
+		cursor := encodeCursor(string(filter.Sort), filter.Ascending, last.SortKey(filter.Sort), last.ID)
+		nextCursor = &cursor
+	}
 
 	signedVideos := make([]database.Video, len(videos))
 	for i, video := range videos {
-		signedVideo, err := cfg.dbVideoToSignedVideo(video)
+		signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
 		if err != nil {
 			respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
 			return
@@ -132,5 +433,8 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		signedVideos[i] = signedVideo
 	}
 
-	respondWithJSON(w, http.StatusOK, signedVideos)
+	respondWithJSON(w, http.StatusOK, response{
+		Videos:     signedVideos,
+		NextCursor: nextCursor,
+	})
 }
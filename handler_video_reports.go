@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// maxReportDetailsLength caps the free text a viewer adds to a report.
+const maxReportDetailsLength = 2000
+
+// handlerVideoReportCreate lets a viewer flag someone else's video for the
+// moderators. Each viewer has at most one open report per video.
+func (cfg *apiConfig) handlerVideoReportCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !slices.Contains(database.ReportReasons, params.Reason) {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Reason must be one of: %s", strings.Join(database.ReportReasons, ", ")), nil)
+		return
+	}
+	params.Details = strings.TrimSpace(params.Details)
+	if len(params.Details) > maxReportDetailsLength {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Details must be at most %d characters", maxReportDetailsLength), nil)
+		return
+	}
+	if params.Reason == database.ReportReasonOther && params.Details == "" {
+		respondWithError(w, http.StatusBadRequest, "Details are required when the reason is other", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	if video.UserID == userID {
+		respondWithError(w, http.StatusBadRequest, "You can't report your own video", nil)
+		return
+	}
+	open, err := cfg.db.HasOpenVideoReport(video.ID, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check reports", err)
+		return
+	}
+	if open {
+		respondWithError(w, http.StatusConflict, "You've already reported this video", nil)
+		return
+	}
+
+	report, err := cfg.db.CreateVideoReport(database.CreateVideoReportParams{
+		VideoID:        video.ID,
+		ReporterUserID: userID,
+		Reason:         params.Reason,
+		Details:        params.Details,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create report", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, report)
+}
+
+type reportedVideoResponse struct {
+	database.ReportedVideo
+	Video   database.Video         `json:"video"`
+	Reports []database.VideoReport `json:"reports"`
+}
+
+// handlerAdminVideoReportsQueue lists the videos with open reports for the
+// moderators, most reported first.
+func (cfg *apiConfig) handlerAdminVideoReportsQueue(w http.ResponseWriter, r *http.Request) {
+	reported, err := cfg.db.GetReportedVideos()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get reports", err)
+		return
+	}
+
+	responses := make([]reportedVideoResponse, 0, len(reported))
+	for _, entry := range reported {
+		video, err := cfg.db.GetVideo(entry.VideoID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		// Reports go with their video, so this only happens mid-delete
+		if video.ID == uuid.Nil {
+			continue
+		}
+		signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+			return
+		}
+		reports, err := cfg.db.GetOpenVideoReports(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get reports", err)
+			return
+		}
+		responses = append(responses, reportedVideoResponse{
+			ReportedVideo: entry,
+			Video:         signedVideo,
+			Reports:       reports,
+		})
+	}
+
+	respondWithJSON(w, http.StatusOK, responses)
+}
+
+// handlerAdminVideoReportsResolve closes a video's open reports. The
+// moderator dismisses them, hides the video the way a rejected moderation
+// review does, or deletes it.
+func (cfg *apiConfig) handlerAdminVideoReportsResolve(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		// Action is "dismiss", "hide" or "delete".
+		Action string `json:"action"`
+		Note   string `json:"note"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	var action string
+	switch params.Action {
+	case "dismiss":
+		action = database.AuditActionReportsDismissed
+	case "hide":
+		action = database.AuditActionReportedVideoHidden
+	case "delete":
+		action = database.AuditActionReportedVideoDeleted
+	default:
+		respondWithError(w, http.StatusBadRequest, "Action must be dismiss, hide or delete", nil)
+		return
+	}
+
+	// Hold the video until it's resolved
+	videoLock, ok := cfg.lockVideo(w, videoID)
+	if !ok {
+		return
+	}
+	defer videoLock.Release()
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	reports, err := cfg.db.GetOpenVideoReports(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get reports", err)
+		return
+	}
+	if len(reports) == 0 {
+		respondWithError(w, http.StatusConflict, "Video has no open reports", nil)
+		return
+	}
+	if params.Action == "delete" && !checkNotOnLegalHold(w, video) {
+		return
+	}
+
+	switch params.Action {
+	case "dismiss":
+		_, err = cfg.db.ResolveVideoReports(video.ID, database.ReportStatusDismissed)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update reports", err)
+			return
+		}
+	case "hide":
+		video.ModerationStatus = database.ModerationStatusRejected
+		err = cfg.db.UpdateVideo(video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+		_, err = cfg.db.ResolveVideoReports(video.ID, database.ReportStatusActioned)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update reports", err)
+			return
+		}
+	case "delete":
+		// Objects that can't be deleted now are retried by the asset GC
+		err = cfg.deleteVideoAssets(video)
+		if err != nil {
+			logging.Warnf("Couldn't delete all assets of video %s: %v", video.ID, err)
+		}
+		// The reports are deleted along with the video
+		err = cfg.db.DeleteVideo(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
+			return
+		}
+		cfg.publishEvent(events.TypeVideoDeleted, video.UserID, map[string]any{
+			"video_id": video.ID,
+			"title":    video.Title,
+		})
+	}
+	cfg.auditVideoReports(action, video.ID, reports, params.Note)
+
+	if params.Action == "delete" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
+
+// auditVideoReports records how a moderator resolved a video's reports. It
+// keeps only counts by reason, since reports name their reporters.
+func (cfg *apiConfig) auditVideoReports(action string, videoID uuid.UUID, reports []database.VideoReport, note string) {
+	reasons := map[string]int{}
+	for _, report := range reports {
+		reasons[report.Reason]++
+	}
+	details, err := json.Marshal(map[string]any{
+		"reports": len(reports),
+		"reasons": reasons,
+		"note":    strings.TrimSpace(note),
+	})
+	if err == nil {
+		err = cfg.db.CreateAuditEvent(database.CreateAuditEventParams{
+			Actor:     "admin",
+			Action:    action,
+			SubjectID: videoID,
+			Details:   details,
+		})
+	}
+	if err != nil {
+		logging.Warnf("Couldn't record audit event for reports on video %s: %v", videoID, err)
+	}
+}
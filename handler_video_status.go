@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerVideoStatus(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	canView, err := cfg.userCanViewVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canView {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized to view this video", nil)
+		return
+	}
+
+	job, err := cfg.db.GetLatestJobForVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get processing job", err)
+		return
+	}
+	if job.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video has not been uploaded yet", nil)
+		return
+	}
+	if !checkNotModified(w, r, jobETag(job)) {
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job)
+}
+
+// handlerVideoEvents streams the video's processing job as server-sent
+// events until the job finishes or the client goes away. EventSource can't
+// set headers, so the JWT may also be passed as a "token" query parameter.
+func (cfg *apiConfig) handlerVideoEvents(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	canView, err := cfg.userCanViewVideo(userID, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check video ownership", err)
+		return
+	}
+	if !canView {
+		respondWithError(w, http.StatusUnauthorized, "User not authorized to view this video", nil)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Streaming unsupported", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var lastSent []byte
+	for {
+		job, err := cfg.db.GetLatestJobForVideo(videoID)
+		if err != nil {
+			logging.Warnf("Couldn't get processing job for video %s: %v", videoID, err)
+			return
+		}
+		if job.ID != uuid.Nil {
+			dat, err := json.Marshal(job)
+			if err != nil {
+				logging.Errorf("Error marshalling JSON: %s", err)
+				return
+			}
+			if !bytes.Equal(dat, lastSent) {
+				fmt.Fprintf(w, "event: job\ndata: %s\n\n", dat)
+				flusher.Flush()
+				lastSent = dat
+			}
+			if job.Status == database.JobStatusDone || job.Status == database.JobStatusFailed {
+				return
+			}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
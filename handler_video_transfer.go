@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// handlerVideoTransferOffer offers the caller's video to another user or an
+// organization. The video stays where it is until the recipient accepts.
+func (cfg *apiConfig) handlerVideoTransferOffer(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	cfg.offerVideoTransfer(w, r, videoID, &userID)
+}
+
+// handlerAdminVideoTransferOffer offers any video on behalf of its owner.
+func (cfg *apiConfig) handlerAdminVideoTransferOffer(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	cfg.offerVideoTransfer(w, r, videoID, nil)
+}
+
+// offerVideoTransfer creates a transfer of a video to the recipient in the
+// request body, replacing any offer still pending. A nil userID is an admin,
+// who can offer any video.
+func (cfg *apiConfig) offerVideoTransfer(w http.ResponseWriter, r *http.Request, videoID uuid.UUID, userID *uuid.UUID) {
+	type parameters struct {
+		Email          string     `json:"email"`
+		OrganizationID *uuid.UUID `json:"organization_id"`
+	}
+
+	params := parameters{}
+	err := json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if (params.Email == "") == (params.OrganizationID == nil) {
+		respondWithError(w, http.StatusBadRequest, "Either email or organization_id is required", nil)
+		return
+	}
+
+	// Hold the video so the offer can't race an accept
+	videoLock, ok := cfg.lockVideo(w, videoID)
+	if !ok {
+		return
+	}
+	defer videoLock.Release()
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+	actor := "admin"
+	if userID != nil {
+		// Only the owner can give a video away: the uploader of a personal
+		// video, or the owners of an organization's, not its editors
+		canTransfer := video.UserID == *userID
+		if video.OrganizationID != nil {
+			role, err := cfg.db.GetOrganizationRole(*video.OrganizationID, *userID)
+			if err != nil {
+				respondWithError(w, http.StatusInternalServerError, "Couldn't get organization role", err)
+				return
+			}
+			canTransfer = role == database.OrganizationRoleOwner
+		}
+		if !canTransfer {
+			respondWithError(w, http.StatusUnauthorized, "User not authorized to transfer this video", nil)
+			return
+		}
+		actor = fmt.Sprintf("user:%s", *userID)
+	}
+
+	createParams := database.CreateVideoTransferParams{
+		VideoID:     video.ID,
+		FromUserID:  video.UserID,
+		InitiatedBy: actor,
+	}
+	if params.OrganizationID != nil {
+		org, err := cfg.db.GetOrganization(*params.OrganizationID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get organization", err)
+			return
+		}
+		if org.ID == uuid.Nil {
+			respondWithError(w, http.StatusNotFound, "Organization not found", nil)
+			return
+		}
+		if video.OrganizationID != nil && *video.OrganizationID == org.ID {
+			respondWithError(w, http.StatusBadRequest, "Video already belongs to this organization", nil)
+			return
+		}
+		createParams.ToOrganizationID = &org.ID
+	} else {
+		recipient, err := cfg.db.GetUserByEmail(params.Email)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+			return
+		}
+		if recipient.ID == uuid.Nil {
+			respondWithError(w, http.StatusNotFound, "User not found", nil)
+			return
+		}
+		if recipient.ID == video.UserID && video.OrganizationID == nil {
+			respondWithError(w, http.StatusBadRequest, "Video already belongs to this user", nil)
+			return
+		}
+		createParams.ToUserID = &recipient.ID
+	}
+
+	transfer, err := cfg.db.CreateVideoTransfer(createParams)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create video transfer", err)
+		return
+	}
+	cfg.auditVideoTransfer(actor, database.AuditActionVideoTransferOffered, transfer)
+
+	respondWithJSON(w, http.StatusCreated, transfer)
+}
+
+// handlerVideoTransfersList returns the pending transfers the caller can
+// accept, including those to organizations they own.
+func (cfg *apiConfig) handlerVideoTransfersList(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	transfers, err := cfg.db.GetPendingVideoTransfersForUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video transfers", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, transfers)
+}
+
+// handlerVideoTransferAccept moves the video to the recipient. A video
+// accepted for an organization is owned by the organization owner who
+// accepted it.
+func (cfg *apiConfig) handlerVideoTransferAccept(w http.ResponseWriter, r *http.Request) {
+	transfer, userID, ok := cfg.getTransferForRecipient(w, r)
+	if !ok {
+		return
+	}
+
+	// Hold the video until it has changed hands
+	videoLock, ok := cfg.lockVideo(w, transfer.VideoID)
+	if !ok {
+		return
+	}
+	defer videoLock.Release()
+
+	transfer, ok = cfg.reloadPendingTransfer(w, transfer.ID)
+	if !ok {
+		return
+	}
+	video, err := cfg.db.GetVideo(transfer.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	// The offer was made by whoever owned the video then
+	if video.ID == uuid.Nil || video.UserID != transfer.FromUserID {
+		_, err := cfg.db.SetVideoTransferStatus(transfer.ID, database.VideoTransferStatusCancelled)
+		if err != nil {
+			logging.Warnf("Couldn't cancel stale video transfer %s: %v", transfer.ID, err)
+		}
+		respondWithErrorCode(w, http.StatusConflict, errCodeConflict, "Video has changed owner since the transfer was offered", nil)
+		return
+	}
+
+	video.UserID = userID
+	video.OrganizationID = transfer.ToOrganizationID
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+	_, err = cfg.db.SetVideoTransferStatus(transfer.ID, database.VideoTransferStatusAccepted)
+	if err != nil {
+		logging.Warnf("Couldn't mark video transfer %s accepted: %v", transfer.ID, err)
+	}
+	transfer.Status = database.VideoTransferStatusAccepted
+	cfg.auditVideoTransfer(fmt.Sprintf("user:%s", userID), database.AuditActionVideoTransferred, transfer)
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
+
+// handlerVideoTransferDecline turns a transfer down, leaving the video with
+// its owner.
+func (cfg *apiConfig) handlerVideoTransferDecline(w http.ResponseWriter, r *http.Request) {
+	transfer, userID, ok := cfg.getTransferForRecipient(w, r)
+	if !ok {
+		return
+	}
+	cfg.closeVideoTransfer(w, transfer, database.VideoTransferStatusDeclined, fmt.Sprintf("user:%s", userID))
+}
+
+// handlerVideoTransferCancel withdraws a transfer. Only the owner who offered
+// the video can cancel it.
+func (cfg *apiConfig) handlerVideoTransferCancel(w http.ResponseWriter, r *http.Request) {
+	transferID, err := uuid.Parse(r.PathValue("transferID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid transfer ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	transfer, err := cfg.db.GetVideoTransfer(transferID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video transfer", err)
+		return
+	}
+	// Other users' transfers look the same as missing ones
+	if transfer.ID == uuid.Nil || transfer.FromUserID != userID {
+		respondWithError(w, http.StatusNotFound, "Video transfer not found", nil)
+		return
+	}
+	cfg.closeVideoTransfer(w, transfer, database.VideoTransferStatusCancelled, fmt.Sprintf("user:%s", userID))
+}
+
+// getTransferForRecipient loads the transfer in the path and checks the
+// caller can answer it: they're the recipient, or an owner of the recipient
+// organization. It returns false when it has responded.
+func (cfg *apiConfig) getTransferForRecipient(w http.ResponseWriter, r *http.Request) (database.VideoTransfer, uuid.UUID, bool) {
+	transferID, err := uuid.Parse(r.PathValue("transferID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid transfer ID", err)
+		return database.VideoTransfer{}, uuid.Nil, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.VideoTransfer{}, uuid.Nil, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.VideoTransfer{}, uuid.Nil, false
+	}
+
+	transfer, err := cfg.db.GetVideoTransfer(transferID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video transfer", err)
+		return database.VideoTransfer{}, uuid.Nil, false
+	}
+	isRecipient := transfer.ToUserID != nil && *transfer.ToUserID == userID
+	if transfer.ToOrganizationID != nil {
+		role, err := cfg.db.GetOrganizationRole(*transfer.ToOrganizationID, userID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get organization role", err)
+			return database.VideoTransfer{}, uuid.Nil, false
+		}
+		isRecipient = role == database.OrganizationRoleOwner
+	}
+	// Other users' transfers look the same as missing ones
+	if transfer.ID == uuid.Nil || !isRecipient {
+		respondWithError(w, http.StatusNotFound, "Video transfer not found", nil)
+		return database.VideoTransfer{}, uuid.Nil, false
+	}
+	return transfer, userID, true
+}
+
+// reloadPendingTransfer gets a transfer again once its video is locked,
+// checking it's still pending. It returns false when it has responded.
+func (cfg *apiConfig) reloadPendingTransfer(w http.ResponseWriter, transferID uuid.UUID) (database.VideoTransfer, bool) {
+	transfer, err := cfg.db.GetVideoTransfer(transferID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video transfer", err)
+		return database.VideoTransfer{}, false
+	}
+	if transfer.Status != database.VideoTransferStatusPending {
+		respondWithErrorCode(w, http.StatusConflict, errCodeConflict, "Video transfer is no longer pending", nil)
+		return database.VideoTransfer{}, false
+	}
+	return transfer, true
+}
+
+// closeVideoTransfer declines or cancels a pending transfer.
+func (cfg *apiConfig) closeVideoTransfer(w http.ResponseWriter, transfer database.VideoTransfer, status, actor string) {
+	videoLock, ok := cfg.lockVideo(w, transfer.VideoID)
+	if !ok {
+		return
+	}
+	defer videoLock.Release()
+
+	closed, err := cfg.db.SetVideoTransferStatus(transfer.ID, status)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video transfer", err)
+		return
+	}
+	if !closed {
+		respondWithErrorCode(w, http.StatusConflict, errCodeConflict, "Video transfer is no longer pending", nil)
+		return
+	}
+	transfer.Status = status
+
+	action := database.AuditActionVideoTransferCancelled
+	if status == database.VideoTransferStatusDeclined {
+		action = database.AuditActionVideoTransferDeclined
+	}
+	cfg.auditVideoTransfer(actor, action, transfer)
+
+	respondWithJSON(w, http.StatusOK, transfer)
+}
+
+// auditVideoTransfer records a step of a transfer against its video.
+func (cfg *apiConfig) auditVideoTransfer(actor, action string, transfer database.VideoTransfer) {
+	details, err := json.Marshal(map[string]any{
+		"transfer_id":        transfer.ID,
+		"from_user_id":       transfer.FromUserID,
+		"to_user_id":         transfer.ToUserID,
+		"to_organization_id": transfer.ToOrganizationID,
+	})
+	if err == nil {
+		err = cfg.db.CreateAuditEvent(database.CreateAuditEventParams{
+			Actor:     actor,
+			Action:    action,
+			SubjectID: transfer.VideoID,
+			Details:   details,
+		})
+	}
+	if err != nil {
+		logging.Warnf("Couldn't record audit event for video transfer %s: %v", transfer.ID, err)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestVideoTransferOfferOfOrganizationVideo(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	ownerID, ownerToken := createTestUser(t, cfg, "owner@example.com")
+	editorID, editorToken := createTestUser(t, cfg, "editor@example.com")
+	org, err := cfg.db.CreateOrganization("Studio", ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.db.SetOrganizationMember(org.ID, editorID, database.OrganizationRoleEditor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The editor uploaded it, but it's the organization's
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Launch", UserID: editorID, OrganizationID: &org.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer := func(token string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/videos/"+video.ID.String()+"/transfers", strings.NewReader(`{"email": "editor@example.com"}`))
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.SetPathValue("videoID", video.ID.String())
+		w := httptest.NewRecorder()
+		cfg.handlerVideoTransferOffer(w, r)
+		return w.Code
+	}
+
+	if code := offer(editorToken); code != http.StatusUnauthorized {
+		t.Errorf("editor's offer: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := offer(ownerToken); code != http.StatusCreated {
+		t.Errorf("organization owner's offer: status = %d, want %d", code, http.StatusCreated)
+	}
+}
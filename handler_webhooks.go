@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/google/uuid"
+)
+
+// maxWebhooksPerUser limits how many webhooks a user can register.
+const maxWebhooksPerUser = 10
+
+func (cfg *apiConfig) handlerWebhookCreate(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+	type response struct {
+		database.Webhook
+		// Secret is only returned here; receivers need it to verify the
+		// signature header
+		Secret string `json:"secret"`
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	endpoint, err := url.Parse(params.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		respondWithError(w, http.StatusBadRequest, "URL must be an absolute http or https URL", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), cloudRequestTimeout)
+	defer cancel()
+	err = ingest.CheckPublicURL(ctx, endpoint)
+	if errors.Is(err, ingest.ErrPrivateAddress) {
+		respondWithError(w, http.StatusBadRequest, "URL must be on a public address", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't resolve the URL's host", err)
+		return
+	}
+	if len(params.Events) == 0 {
+		respondWithError(w, http.StatusBadRequest, "At least one event is required", nil)
+		return
+	}
+	events := database.WebhookEvents{}
+	for _, event := range params.Events {
+		if _, ok := notificationTypes[event]; !ok {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unknown event %q", event), nil)
+			return
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+
+	webhooks, err := cfg.db.GetWebhooks(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhooks", err)
+		return
+	}
+	if len(webhooks) >= maxWebhooksPerUser {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("A user can have at most %d webhooks", maxWebhooksPerUser), nil)
+		return
+	}
+
+	secret, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook secret", err)
+		return
+	}
+
+	webhook, err := cfg.db.CreateWebhook(database.CreateWebhookParams{
+		UserID: userID,
+		URL:    endpoint.String(),
+		Events: events,
+		Secret: secret,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create webhook", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, response{Webhook: webhook, Secret: webhook.Secret})
+}
+
+func (cfg *apiConfig) handlerWebhooksList(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	webhooks, err := cfg.db.GetWebhooks(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhooks", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, webhooks)
+}
+
+// userWebhook loads the webhook named in the path and checks it belongs to
+// the authenticated user. It responds with an error and returns false if not.
+func (cfg *apiConfig) userWebhook(w http.ResponseWriter, r *http.Request) (database.Webhook, bool) {
+	webhookID, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid webhook ID", err)
+		return database.Webhook{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Webhook{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Webhook{}, false
+	}
+
+	webhook, err := cfg.db.GetWebhook(webhookID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook", err)
+		return database.Webhook{}, false
+	}
+	if webhook.ID == uuid.Nil || webhook.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "Webhook not found", nil)
+		return database.Webhook{}, false
+	}
+	return webhook, true
+}
+
+func (cfg *apiConfig) handlerWebhookDelete(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.userWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.DeleteWebhook(webhook.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete webhook", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerWebhookDeliveriesList is the delivery log: deliveries newest first
+// with the outcome of their latest attempt, a page at a time.
+func (cfg *apiConfig) handlerWebhookDeliveriesList(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Deliveries []database.WebhookDelivery `json:"deliveries"`
+		NextCursor *string                    `json:"next_cursor"`
+	}
+
+	webhook, ok := cfg.userWebhook(w, r)
+	if !ok {
+		return
+	}
+
+	// Fetch one extra to know whether there's another page
+	after, limit := pageParams(r)
+	if !checkCursorOrder(w, after, database.VideoSortCreatedAt, false) {
+		return
+	}
+	var afterCursor *database.Cursor
+	if after != nil {
+		afterCursor = &after.Cursor
+	}
+	deliveries, err := cfg.db.GetWebhookDeliveries(webhook.ID, afterCursor, limit+1)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook deliveries", err)
+		return
+	}
+
+	var nextCursor *string
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+		last := deliveries[limit-1]
+		cursor := encodeCursor(database.VideoSortCreatedAt, false, last.CreatedAt, last.ID)
+		nextCursor = &cursor
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Deliveries: deliveries,
+		NextCursor: nextCursor,
+	})
+}
+
+// handlerWebhookRedeliver queues a fresh copy of a past delivery, e.g. after
+// the receiver has been fixed.
+func (cfg *apiConfig) handlerWebhookRedeliver(w http.ResponseWriter, r *http.Request) {
+	webhook, ok := cfg.userWebhook(w, r)
+	if !ok {
+		return
+	}
+	deliveryID, err := uuid.Parse(r.PathValue("deliveryID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid delivery ID", err)
+		return
+	}
+
+	delivery, err := cfg.db.GetWebhookDelivery(deliveryID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get webhook delivery", err)
+		return
+	}
+	if delivery.ID == uuid.Nil || delivery.WebhookID != webhook.ID {
+		respondWithError(w, http.StatusNotFound, "Webhook delivery not found", nil)
+		return
+	}
+
+	redelivery, err := cfg.db.CreateWebhookDelivery(webhook.ID, delivery.Event, delivery.Payload)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue webhook delivery", err)
+		return
+	}
+	cfg.nudgeWebhooks()
+
+	respondWithJSON(w, http.StatusAccepted, redelivery)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+)
+
+// maxImagePixels caps the images decoded for resizing, so a small file
+// that claims huge dimensions can't exhaust memory.
+const maxImagePixels = 50_000_000
+
+// optimizeImage re-encodes a JPEG at the given quality or a PNG at maximum
+// compression. Re-encoding drops EXIF/ICC segments and ancillary PNG chunks.
+// The original is returned unchanged when the result isn't smaller, when
+// decoding fails, or when a JPEG relies on EXIF orientation, which would be
+// lost.
+func optimizeImage(data []byte, mediaType string, jpegQuality int) ([]byte, error) {
+	var optimized bytes.Buffer
+	switch mediaType {
+	case "image/jpeg":
+		if jpegOrientation(data) > 1 {
+			return data, nil
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode JPEG: %w", err)
+		}
+		err = jpeg.Encode(&optimized, img, &jpeg.Options{Quality: jpegQuality})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode JPEG: %w", err)
+		}
+	case "image/png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode PNG: %w", err)
+		}
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&optimized, img)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode PNG: %w", err)
+		}
+	default:
+		return data, nil
+	}
+
+	if optimized.Len() >= len(data) {
+		return data, nil
+	}
+	return optimized.Bytes(), nil
+}
+
+// fitImage scales an image down, keeping its aspect ratio, so it fits
+// within maxWidth by maxHeight, and then optimizes it like optimizeImage.
+// JPEGs are turned upright first, since their EXIF orientation doesn't
+// survive re-encoding. Images that already fit are only optimized.
+func fitImage(data []byte, mediaType string, maxWidth, maxHeight, jpegQuality int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, fmt.Errorf("image is %dx%d, which is too large", config.Width, config.Height)
+	}
+	orientation := 0
+	if mediaType == "image/jpeg" {
+		orientation = jpegOrientation(data)
+	}
+	width, height := config.Width, config.Height
+	if orientation >= 5 {
+		width, height = height, width
+	}
+	if width <= maxWidth && height <= maxHeight {
+		return optimizeImage(data, mediaType, jpegQuality)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if orientation > 1 {
+		img = orientImage(img, orientation)
+	}
+	scale := min(float64(maxWidth)/float64(width), float64(maxHeight)/float64(height))
+	img = downscaleImage(img, max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale)))
+
+	var resized bytes.Buffer
+	switch mediaType {
+	case "image/png":
+		encoder := png.Encoder{CompressionLevel: png.BestCompression}
+		err = encoder.Encode(&resized, img)
+	default:
+		err = jpeg.Encode(&resized, img, &jpeg.Options{Quality: jpegQuality})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+	return resized.Bytes(), nil
+}
+
+// downscaleImage shrinks an image to width by height, averaging each block
+// of source pixels into one.
+func downscaleImage(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8(r / n >> 8)
+			dst.Pix[offset+1] = uint8(g / n >> 8)
+			dst.Pix[offset+2] = uint8(b / n >> 8)
+			dst.Pix[offset+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
+
+// orientImage applies an EXIF orientation, returning the image the way it's
+// meant to be displayed.
+func orientImage(src image.Image, orientation int) *image.RGBA {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			dst.Set(x, y, src.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// jpegOrientation returns the EXIF orientation tag of a JPEG, or 0 if it has
+// none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0
+	}
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		marker := data[pos+1]
+		segmentLength := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == 0xDA || segmentLength < 2 || pos+2+segmentLength > len(data) {
+			return 0
+		}
+		segment := data[pos+4 : pos+2+segmentLength]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		pos += 2 + segmentLength
+	}
+	return 0
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifdOffset := int(order.Uint32(tiff[4:]))
+	if ifdOffset+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifdOffset:]))
+	for i := 0; i < entries; i++ {
+		entry := ifdOffset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// Import settings.
+const (
+	importInterval    = 5 * time.Minute
+	importMaxAttempts = 3
+	importsShown      = 50
+	// importRunTimeout bounds one pass over a directory, downloads
+	// included. An import still marked as importing after that was cut off
+	// and is claimed again.
+	importRunTimeout = 2 * time.Hour
+	// importSettleTime is how long a file must go unmodified before it's
+	// imported, so one still being uploaded isn't picked up half written
+	importSettleTime = time.Minute
+)
+
+// importableExtensions are the files imported, since the pipeline only
+// accepts MP4. Anything else in the directory is left alone.
+var importableExtensions = []string{".mp4", ".m4v"}
+
+// importWatched imports the new videos in the watched directory for the
+// user IMPORT_USER_EMAIL names.
+func (cfg *apiConfig) importWatched() error {
+	user, err := cfg.db.GetUserByEmail(cfg.importUserEmail)
+	if err != nil {
+		return err
+	}
+	if user.ID == uuid.Nil {
+		return fmt.Errorf("no user with the import email %s", cfg.importUserEmail)
+	}
+	return cfg.importDirectory(cfg.importDir, user.ID)
+}
+
+// importDirectory imports each video in a directory on the import source
+// that hasn't been imported yet, as a new video of the user's going through
+// the processing pipeline. Files that fail are left for the next pass, and
+// the pass carries on with the others.
+func (cfg *apiConfig) importDirectory(dir string, userID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), importRunTimeout)
+	defer cancel()
+
+	conn, err := cfg.importSource.Dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	files, err := conn.List(ctx, dir)
+	if err != nil {
+		return err
+	}
+
+	var failed []error
+	for _, file := range files {
+		if !importable(file) {
+			continue
+		}
+		claimed, err := cfg.db.ClaimImport(database.CreateImportParams{
+			Source:     cfg.importSource.Scheme(),
+			URL:        cfg.importSource.URL(file.Path),
+			Size:       file.Size,
+			ModifiedAt: file.ModTime,
+			UserID:     userID,
+		}, importMaxAttempts, time.Now().Add(-importRunTimeout))
+		if err != nil {
+			return fmt.Errorf("couldn't claim import of %s: %w", file.Path, err)
+		}
+		if claimed.ID == uuid.Nil {
+			continue
+		}
+
+		open := func(ctx context.Context) (io.ReadCloser, error) { return conn.Open(ctx, file.Path) }
+		status, videoID, err := cfg.importFile(ctx, claimed, path.Base(file.Path), open)
+		var importErr *string
+		if err != nil {
+			logging.Warnf("Couldn't import %s: %v", claimed.URL, err)
+			failed = append(failed, fmt.Errorf("%s: %w", file.Path, err))
+			msg := err.Error()
+			importErr = &msg
+		}
+		err = cfg.db.FinishImport(claimed.ID, status, videoID, importErr)
+		if err != nil {
+			return fmt.Errorf("couldn't record import of %s: %w", file.Path, err)
+		}
+		// A cancelled download leaves the connection unusable
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return errors.Join(failed...)
+}
+
+// importable reports whether a listed file is a video that's finished
+// uploading.
+func importable(file ingest.File) bool {
+	ext := strings.ToLower(path.Ext(file.Path))
+	return slices.Contains(importableExtensions, ext) && time.Since(file.ModTime) >= importSettleTime
+}
+
+// importFile downloads a claimed file named name and queues it for
+// processing as a new video, returning the status to record for the
+// import.
+func (cfg *apiConfig) importFile(ctx context.Context, claimed database.Import, name string, open func(context.Context) (io.ReadCloser, error)) (string, *uuid.UUID, error) {
+	if claimed.Size > maxVideoUploadSize {
+		return database.ImportStatusSkipped, nil, fmt.Errorf("file is larger than the maximum of %d bytes", maxVideoUploadSize)
+	}
+
+	sourcePath, err := downloadImport(ctx, open, claimed.Size)
+	if err != nil {
+		return database.ImportStatusFailed, nil, err
+	}
+	keepSource := false
+	defer func() {
+		if !keepSource {
+			os.Remove(sourcePath)
+		}
+	}()
+
+	video, err := cfg.createImportedVideo(claimed, database.CreateVideoParams{
+		Title:  strings.TrimSuffix(name, path.Ext(name)),
+		UserID: claimed.UserID,
+	}, database.Provenance{
+		Source:     claimed.Source,
+		URL:        claimed.URL,
+		Size:       claimed.Size,
+		ModifiedAt: claimed.ModifiedAt,
+	})
+	if err != nil {
+		return database.ImportStatusFailed, createdVideoID(video), err
+	}
+	err = cfg.queueImportedVideo(claimed, video, sourcePath)
+	if err != nil {
+		return database.ImportStatusFailed, &video.ID, err
+	}
+	keepSource = true
+	return database.ImportStatusImported, &video.ID, nil
+}
+
+// createImportedVideo creates the video a claimed import becomes, recording
+// where it came from.
+func (cfg *apiConfig) createImportedVideo(claimed database.Import, params database.CreateVideoParams, provenance database.Provenance) (database.Video, error) {
+	video, err := cfg.db.CreateVideo(params)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("couldn't create video: %w", err)
+	}
+	provenance.ImportedAt = time.Now().UTC()
+	provenance.ImportID = claimed.ID
+	err = cfg.db.SetVideoProvenance(video.ID, provenance)
+	if err != nil {
+		return video, fmt.Errorf("couldn't record provenance: %w", err)
+	}
+	return video, nil
+}
+
+// queueImportedVideo queues an imported video's source file for processing.
+// The file belongs to the job once this succeeds.
+func (cfg *apiConfig) queueImportedVideo(claimed database.Import, video database.Video, sourcePath string) error {
+	job, err := cfg.db.CreateJob(database.CreateJobParams{
+		VideoID:    video.ID,
+		SourcePath: sourcePath,
+		MediaType:  "video/mp4",
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't create processing job: %w", err)
+	}
+	cfg.enqueueJob(job.ID)
+	logging.Infof("Imported %s as video %s", claimed.URL, video.ID)
+	return nil
+}
+
+// createdVideoID is the ID to record for an import whose video may not have
+// been created.
+func createdVideoID(video database.Video) *uuid.UUID {
+	if video.ID == uuid.Nil {
+		return nil
+	}
+	return &video.ID
+}
+
+// downloadImport copies a file of the given size to a temporary file, which
+// the caller removes.
+func downloadImport(ctx context.Context, open func(context.Context) (io.ReadCloser, error), size int64) (string, error) {
+	r, err := open(ctx)
+	if err != nil {
+		return "", err
+	}
+	tempFile, err := os.CreateTemp("", "tubely-upload.mp4")
+	if err != nil {
+		r.Close()
+		return "", err
+	}
+	n, err := io.Copy(tempFile, r)
+	err = errors.Join(err, r.Close(), tempFile.Close())
+	if err == nil && n != size {
+		err = fmt.Errorf("read %d bytes of %d, the file may have changed", n, size)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("couldn't download: %w", err)
+	}
+	return tempFile.Name(), nil
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/google/uuid"
+)
+
+// memorySource is an import source of files held in memory. Opening a
+// file in failing returns an error.
+type memorySource struct {
+	files   map[string]string
+	modTime map[string]time.Time
+	failing map[string]bool
+}
+
+func (s *memorySource) Dial(ctx context.Context) (ingest.Conn, error) { return s, nil }
+func (s *memorySource) Scheme() string                                { return "sftp" }
+func (s *memorySource) URL(path string) string                        { return "sftp://partner@sftp.example.com" + path }
+func (s *memorySource) Close() error                                  { return nil }
+
+func (s *memorySource) List(ctx context.Context, dir string) ([]ingest.File, error) {
+	files := []ingest.File{}
+	for path, data := range s.files {
+		if strings.HasPrefix(path, dir+"/") {
+			files = append(files, ingest.File{Path: path, Size: int64(len(data)), ModTime: s.modTime[path]})
+		}
+	}
+	return files, nil
+}
+
+func (s *memorySource) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if s.failing[path] {
+		return nil, errors.New("connection reset")
+	}
+	return io.NopCloser(strings.NewReader(s.files[path])), nil
+}
+
+func TestImportDirectory(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	userID, _ := createTestUser(t, cfg, "partner@example.com")
+	settled := time.Now().Add(-time.Hour)
+	source := &memorySource{
+		files: map[string]string{
+			"/drop/Evening News.mp4": "video",
+			"/drop/Evening News.xml": "<sidecar/>",
+			"/drop/uploading.mp4":    "half a vid",
+			"/drop/broken.mp4":       "video",
+		},
+		modTime: map[string]time.Time{
+			"/drop/Evening News.mp4": settled,
+			"/drop/Evening News.xml": settled,
+			"/drop/uploading.mp4":    time.Now(),
+			"/drop/broken.mp4":       settled,
+		},
+		failing: map[string]bool{"/drop/broken.mp4": true},
+	}
+	cfg.importSource = source
+
+	err := cfg.importDirectory("/drop", userID)
+	if err == nil || !strings.Contains(err.Error(), "broken.mp4") {
+		t.Errorf("importDirectory returned %v, want the failure of broken.mp4", err)
+	}
+
+	imports, err := cfg.db.GetImports(importsShown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := map[string]database.Import{}
+	for _, i := range imports {
+		statuses[i.URL] = i
+	}
+	if len(imports) != 2 {
+		t.Fatalf("recorded imports %+v, want the two settled videos", imports)
+	}
+	if failed := statuses[source.URL("/drop/broken.mp4")]; failed.Status != database.ImportStatusFailed || failed.Error == nil {
+		t.Errorf("broken.mp4 import is %+v, want failed with the error", failed)
+	}
+	imported := statuses[source.URL("/drop/Evening News.mp4")]
+	if imported.Status != database.ImportStatusImported || imported.VideoID == nil {
+		t.Fatalf("Evening News.mp4 import is %+v, want imported as a video", imported)
+	}
+
+	video, err := cfg.db.GetVideo(*imported.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Title != "Evening News" || video.UserID != userID {
+		t.Errorf("imported video %q for %s, want Evening News for %s", video.Title, video.UserID, userID)
+	}
+	if video.Provenance == nil || video.Provenance.URL != imported.URL || video.Provenance.ImportID != imported.ID || video.Provenance.Size != 5 {
+		t.Errorf("provenance = %+v, want the import of %s", video.Provenance, imported.URL)
+	}
+	job, err := cfg.db.GetLatestJobForVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID == uuid.Nil || job.Status != database.JobStatusQueued {
+		t.Errorf("job = %+v, want one queued for the imported video", job)
+	}
+
+	// Only the failed file is tried again
+	delete(source.failing, "/drop/broken.mp4")
+	err = cfg.importDirectory("/drop", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imports, err = cfg.db.GetImports(importsShown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range imports {
+		if i.Status != database.ImportStatusImported {
+			t.Errorf("import of %s is %s, want imported", i.URL, i.Status)
+		}
+		if i.URL == imported.URL && (i.Attempts != 1 || *i.VideoID != video.ID) {
+			t.Errorf("%s was imported again", i.URL)
+		}
+	}
+}
+
+// fakeCloud is a cloud storage service holding one account's files in
+// memory. Its access tokens are the number of times they were refreshed.
+type fakeCloud struct {
+	files    map[string]ingest.CloudFile
+	contents map[string]string
+	mu       sync.Mutex
+	refresh  int
+}
+
+func (c *fakeCloud) Name() string { return ingest.CloudDropbox }
+
+func (c *fakeCloud) AuthURL(redirectURL, state string) string {
+	return "https://cloud.test/authorize?" + url.Values{"redirect_uri": {redirectURL}, "state": {state}}.Encode()
+}
+
+func (c *fakeCloud) Exchange(ctx context.Context, redirectURL, code string) (ingest.Token, error) {
+	if code != "good-code" {
+		return ingest.Token{}, ingest.ErrUnauthorized
+	}
+	return ingest.Token{AccessToken: "0", RefreshToken: "refresh", Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func (c *fakeCloud) Refresh(ctx context.Context, token ingest.Token) (ingest.Token, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refresh++
+	return ingest.Token{AccessToken: strconv.Itoa(c.refresh), RefreshToken: token.RefreshToken, Expiry: time.Now().Add(time.Hour)}, nil
+}
+
+func (c *fakeCloud) Revoke(ctx context.Context, token ingest.Token) error { return nil }
+
+func (c *fakeCloud) Account(ctx context.Context, token ingest.Token) (string, error) {
+	return "alice@example.com", nil
+}
+
+func (c *fakeCloud) List(ctx context.Context, token ingest.Token, folder, cursor string) (ingest.CloudPage, error) {
+	page := ingest.CloudPage{}
+	for _, file := range c.files {
+		page.Files = append(page.Files, file)
+	}
+	return page, nil
+}
+
+func (c *fakeCloud) Stat(ctx context.Context, token ingest.Token, id string) (ingest.CloudFile, error) {
+	file, ok := c.files[id]
+	if !ok {
+		return ingest.CloudFile{}, fs.ErrNotExist
+	}
+	return file, nil
+}
+
+func (c *fakeCloud) Open(ctx context.Context, token ingest.Token, id string) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(c.contents[id])), nil
+}
+
+func (c *fakeCloud) FileURL(account, id string) string {
+	return "dropbox://" + account + "/" + id
+}
+
+func TestLinkedAccountImport(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "alice@example.com")
+	modified := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	cloud := &fakeCloud{
+		files: map[string]ingest.CloudFile{
+			"id:talk":  {ID: "id:talk", Name: "Keynote.mp4", Size: 5, ModifiedAt: modified},
+			"id:notes": {ID: "id:notes", Name: "notes.txt", Size: 5, ModifiedAt: modified},
+		},
+		contents: map[string]string{"id:talk": "video"},
+	}
+	cfg.clouds = map[string]ingest.Cloud{ingest.CloudDropbox: cloud}
+	request := func(method, target, body string) *http.Request {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.SetPathValue("service", ingest.CloudDropbox)
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	cfg.handlerLinkedAccountStart(w, request(http.MethodPost, "/api/linked_accounts/dropbox", ""))
+	var start struct {
+		AuthURL string `json:"auth_url"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &start) != nil {
+		t.Fatalf("start status = %d: %s", w.Code, w.Body)
+	}
+	authURL, err := url.Parse(start.AuthURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := authURL.Query().Get("state")
+	cookies := w.Result().Cookies()
+
+	// The callback only links the account in the browser that started it
+	callback := "/api/linked_accounts/dropbox/callback?" + url.Values{"state": {state}, "code": {"good-code"}}.Encode()
+	r := httptest.NewRequest(http.MethodGet, callback, nil)
+	r.SetPathValue("service", ingest.CloudDropbox)
+	w = httptest.NewRecorder()
+	cfg.handlerLinkedAccountCallback(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("callback without the cookie: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	cfg.handlerLinkedAccountCallback(w, r)
+	if w.Code != http.StatusFound || !strings.HasSuffix(w.Header().Get("Location"), "/app/?linked_account=dropbox") {
+		t.Fatalf("callback status = %d to %q, want a redirect to the app: %s", w.Code, w.Header().Get("Location"), w.Body)
+	}
+
+	// The tokens are sealed at rest, and an expired one is refreshed before
+	// it's used
+	account, err := cfg.db.GetLinkedAccount(userID, ingest.CloudDropbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(account.AccessToken, sealedTokenPrefix) || !strings.HasPrefix(account.RefreshToken, sealedTokenPrefix) {
+		t.Errorf("stored tokens %q and %q, want them sealed", account.AccessToken, account.RefreshToken)
+	}
+	expired := time.Now().Add(-time.Minute)
+	err = cfg.db.UpdateLinkedAccountToken(account.ID, account.AccessToken, account.RefreshToken, &expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	cfg.handlerLinkedAccountFiles(w, request(http.MethodGet, "/api/linked_accounts/dropbox/files", ""))
+	var files struct {
+		Files []struct {
+			ID         string `json:"id"`
+			Importable bool   `json:"importable"`
+		} `json:"files"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &files) != nil || len(files.Files) != 2 {
+		t.Fatalf("files status = %d: %s", w.Code, w.Body)
+	}
+	for _, file := range files.Files {
+		if file.Importable != (file.ID == "id:talk") {
+			t.Errorf("file %s importable = %v", file.ID, file.Importable)
+		}
+	}
+	account, err = cfg.db.GetLinkedAccount(userID, ingest.CloudDropbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := cfg.linkedAccountTokens(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.AccessToken != "1" || tokens.RefreshToken != "refresh" || account.ExpiresAt == nil || time.Until(*account.ExpiresAt) < 30*time.Minute {
+		t.Errorf("account token %s expiring %v, want it refreshed", tokens.AccessToken, account.ExpiresAt)
+	}
+
+	// Tokens stored before they were sealed are sealed at startup
+	err = cfg.db.UpdateLinkedAccountToken(account.ID, "1", "refresh", account.ExpiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.sealStoredCloudTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err = cfg.db.GetLinkedAccount(userID, ingest.CloudDropbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err = cfg.linkedAccountTokens(account)
+	if err != nil || !strings.HasPrefix(account.AccessToken, sealedTokenPrefix) || tokens.AccessToken != "1" || tokens.RefreshToken != "refresh" {
+		t.Errorf("after sealing, stored %q opens to %+v, %v", account.AccessToken, tokens, err)
+	}
+
+	w = httptest.NewRecorder()
+	cfg.handlerLinkedAccountImport(w, request(http.MethodPost, "/api/linked_accounts/dropbox/imports", `{"file_id": "id:notes"}`))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("importing a text file: status = %d, want %d", w.Code, http.StatusUnprocessableEntity)
+	}
+	w = httptest.NewRecorder()
+	cfg.handlerLinkedAccountImport(w, request(http.MethodPost, "/api/linked_accounts/dropbox/imports", `{"file_id": "id:talk"}`))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("import status = %d: %s", w.Code, w.Body)
+	}
+
+	var imported database.Import
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		imports, err := cfg.db.GetImportsForUser(userID, importsShown)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(imports) == 1 && imports[0].Status != database.ImportStatusImporting {
+			imported = imports[0]
+			break
+		}
+	}
+	if imported.Status != database.ImportStatusImported || imported.VideoID == nil {
+		t.Fatalf("import is %+v, want imported as a video", imported)
+	}
+	video, err := cfg.db.GetVideo(*imported.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Title != "Keynote" || video.Provenance == nil || video.Provenance.URL != "dropbox://alice@example.com/id:talk" {
+		t.Errorf("imported video %q from %+v, want Keynote from the Dropbox file", video.Title, video.Provenance)
+	}
+	uploads, _, err := cfg.db.CountUploadsSince(userID, time.Now().Add(-time.Hour))
+	if err != nil || uploads != 1 {
+		t.Errorf("uploads counted = %d, %v, want the import counted", uploads, err)
+	}
+
+	// Imports are held to the upload quota like uploads are
+	cfg.uploadQuotaPerDay = 1
+	w = httptest.NewRecorder()
+	cfg.handlerLinkedAccountImport(w, request(http.MethodPost, "/api/linked_accounts/dropbox/imports", `{"file_id": "id:talk"}`))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("importing over the quota: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	cfg.uploadQuotaPerDay = 0
+
+	w = httptest.NewRecorder()
+	cfg.handlerLinkedAccountImport(w, request(http.MethodPost, "/api/linked_accounts/dropbox/imports", `{"file_id": "id:talk"}`))
+	if w.Code != http.StatusConflict {
+		t.Errorf("importing again: status = %d, want %d", w.Code, http.StatusConflict)
+	}
+}
+
+// fakeDownloader saves a page's video as given, with a JPEG thumbnail.
+type fakeDownloader struct {
+	info ingest.PageInfo
+}
+
+func (d *fakeDownloader) Download(ctx context.Context, rawURL, dir string) (ingest.Download, error) {
+	download := ingest.Download{
+		VideoPath:     filepath.Join(dir, "video.mp4"),
+		ThumbnailPath: filepath.Join(dir, "video.jpg"),
+		Info:          d.info,
+	}
+	err := os.WriteFile(download.VideoPath, []byte("page video"), 0o644)
+	if err != nil {
+		return ingest.Download{}, err
+	}
+	var thumbnail bytes.Buffer
+	err = jpeg.Encode(&thumbnail, image.NewRGBA(image.Rect(0, 0, 16, 9)), nil)
+	if err != nil {
+		return ingest.Download{}, err
+	}
+	return download, os.WriteFile(download.ThumbnailPath, thumbnail.Bytes(), 0o644)
+}
+
+func TestURLImport(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "carol@example.com")
+	request := func(body string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/imports", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	cfg.handlerURLImportCreate(w, request(`{"url": "https://93.184.215.14/watch?v=talk"}`))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("unconfigured: status = %d, want %d", w.Code, http.StatusNotImplemented)
+	}
+
+	cfg.urlDownloader = &fakeDownloader{info: ingest.PageInfo{
+		Extractor:   "youtube",
+		ID:          "talk",
+		Title:       "Conference talk",
+		Description: "Recorded on stage",
+		WebpageURL:  "https://www.youtube.com/watch?v=talk",
+		Tags:        []string{"Conference", "conference", "not a valid tag!"},
+		UploadedAt:  time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+	}}
+	for _, rawURL := range []string{"ftp://93.184.215.14/talk.mp4", "http://127.0.0.1:8091/api/videos", "http://[::1]/"} {
+		w = httptest.NewRecorder()
+		cfg.handlerURLImportCreate(w, request(`{"url": "`+rawURL+`"}`))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("importing %s: status = %d, want %d", rawURL, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	cfg.handlerURLImportCreate(w, request(`{"url": "https://93.184.215.14/watch?v=talk"}`))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("import status = %d: %s", w.Code, w.Body)
+	}
+
+	var imported database.Import
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		imports, err := cfg.db.GetImportsForUser(userID, importsShown)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(imports) == 1 && imports[0].Status != database.ImportStatusImporting {
+			imported = imports[0]
+			break
+		}
+	}
+	if imported.Status != database.ImportStatusImported || imported.VideoID == nil {
+		t.Fatalf("import is %+v, want imported as a video", imported)
+	}
+	video, err := cfg.db.GetVideo(*imported.VideoID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if video.Title != "Conference talk" || video.Description != "Recorded on stage" {
+		t.Errorf("imported video %q: %q, want the page's title and description", video.Title, video.Description)
+	}
+	if len(video.Tags) != 1 || video.Tags[0] != "conference" {
+		t.Errorf("imported tags = %v, want [conference]", video.Tags)
+	}
+	if video.ThumbnailURL == nil {
+		t.Error("imported video has no thumbnail, want the page's")
+	}
+	if video.Provenance == nil || video.Provenance.Source != urlImportSource || video.Provenance.URL != "https://www.youtube.com/watch?v=talk" {
+		t.Errorf("imported from %+v, want the page's canonical URL", video.Provenance)
+	}
+
+	// Each request is imported, rather than deduplicated like files
+	w = httptest.NewRecorder()
+	cfg.handlerURLImportCreate(w, request(`{"url": "https://93.184.215.14/watch?v=talk"}`))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("importing again: status = %d, want %d", w.Code, http.StatusAccepted)
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		imports, err := cfg.db.GetImportsForUser(userID, importsShown)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(imports) == 2 && imports[0].Status == database.ImportStatusImported {
+			return
+		}
+	}
+	t.Error("the second import didn't finish")
+}
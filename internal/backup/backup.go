@@ -0,0 +1,234 @@
+// Package backup writes and restores backups of a Tubely instance: a
+// snapshot of its database, the files under its assets root and a manifest
+// of the objects it's stored elsewhere, in one gzipped tar archive that can
+// be encrypted with a passphrase.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Names of the entries in an archive.
+const (
+	DatabaseName = "tubely.db"
+	ManifestName = "manifest.json"
+	// AssetsDir holds the files of the assets root
+	AssetsDir = "assets/"
+)
+
+// manifestVersion is bumped when the archive layout changes in a way
+// older restores can't read.
+const manifestVersion = 1
+
+// Manifest describes a backup. Objects aren't copied into the archive,
+// since they're already in durable storage; restoring checks they're
+// still there.
+type Manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	// Assets is how many files of the assets root the archive holds
+	Assets  int      `json:"assets"`
+	Objects []Object `json:"objects"`
+}
+
+// Object is a stored object the database refers to.
+type Object struct {
+	VideoID      uuid.UUID `json:"video_id"`
+	Kind         string    `json:"kind"`
+	Bucket       string    `json:"bucket"`
+	Key          string    `json:"key"`
+	Size         *int64    `json:"size"`
+	StorageClass string    `json:"storage_class,omitempty"`
+}
+
+// Writer writes an archive.
+type Writer struct {
+	tar *tar.Writer
+	gz  *gzip.Writer
+	// enc is nil for archives that aren't encrypted
+	enc io.WriteCloser
+}
+
+// NewWriter starts an archive on w, encrypted with passphrase unless it's
+// empty.
+func NewWriter(w io.Writer, passphrase string) (*Writer, error) {
+	bw := &Writer{}
+	if passphrase != "" {
+		enc, err := newEncryptWriter(w, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		bw.enc = enc
+		w = enc
+	}
+	bw.gz = gzip.NewWriter(w)
+	bw.tar = tar.NewWriter(bw.gz)
+	return bw, nil
+}
+
+// WriteManifest adds the manifest, stamped with the format version.
+func (w *Writer) WriteManifest(manifest Manifest) error {
+	manifest.Version = manifestVersion
+	dat, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = w.tar.WriteHeader(&tar.Header{
+		Name:    ManifestName,
+		Mode:    0644,
+		Size:    int64(len(dat)),
+		ModTime: manifest.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.tar.Write(dat)
+	return err
+}
+
+// AddFile adds the file at filePath as name.
+func (w *Writer) AddFile(name, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	err = w.tar.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w.tar, file)
+	return err
+}
+
+// Close finishes the archive. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	err := w.tar.Close()
+	if err != nil {
+		return err
+	}
+	err = w.gz.Close()
+	if err != nil {
+		return err
+	}
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+	return nil
+}
+
+// Restore extracts an archive read from r, writing the database to dbPath
+// and the assets into assetsDir, and returns its manifest. An encrypted
+// archive needs the passphrase it was written with, and with a passphrase
+// an archive that isn't encrypted is refused with ErrNotEncrypted.
+//
+// The database is written next to dbPath and renamed over it once it's
+// complete, and the old database's WAL is removed along with it, so
+// nothing may have the database open while restoring.
+func Restore(r io.Reader, passphrase, dbPath, assetsDir string) (Manifest, error) {
+	r, err := openArchive(r, passphrase)
+	if err != nil {
+		return Manifest{}, err
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("not a backup archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	var manifest Manifest
+	haveManifest, haveDatabase := false, false
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Manifest{}, err
+		}
+		switch name := header.Name; {
+		case name == ManifestName:
+			err = json.NewDecoder(tr).Decode(&manifest)
+			if err != nil {
+				return Manifest{}, fmt.Errorf("couldn't read manifest: %w", err)
+			}
+			if manifest.Version > manifestVersion {
+				return Manifest{}, fmt.Errorf("backup format version %d is newer than this restore supports", manifest.Version)
+			}
+			haveManifest = true
+		case name == DatabaseName:
+			err = restoreDatabase(tr, dbPath)
+			if err != nil {
+				return Manifest{}, fmt.Errorf("couldn't restore database: %w", err)
+			}
+			haveDatabase = true
+		case strings.HasPrefix(name, AssetsDir):
+			filename := strings.TrimPrefix(name, AssetsDir)
+			// Assets are flat and never hidden, so anything else was
+			// crafted to escape the directory
+			if filename == "" || filename != path.Base(filename) || strings.HasPrefix(filename, ".") {
+				return Manifest{}, fmt.Errorf("invalid asset name %q in archive", name)
+			}
+			err = writeFile(filepath.Join(assetsDir, filename), tr)
+			if err != nil {
+				return Manifest{}, fmt.Errorf("couldn't restore asset %s: %w", filename, err)
+			}
+		}
+	}
+	if !haveManifest || !haveDatabase {
+		return Manifest{}, errors.New("the archive has no manifest or no database")
+	}
+	return manifest, nil
+}
+
+func restoreDatabase(r io.Reader, dbPath string) error {
+	tempPath := dbPath + ".restoring"
+	err := writeFile(tempPath, r)
+	if err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	// A WAL left by the old database would be replayed into the new one
+	for _, suffix := range []string{"-wal", "-shm"} {
+		err = os.Remove(dbPath + suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			os.Remove(tempPath)
+			return err
+		}
+	}
+	return os.Rename(tempPath, dbPath)
+}
+
+func writeFile(filePath string, r io.Reader) error {
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,184 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted archives start with encryptedMagic, a random salt for deriving
+// the key from the passphrase and a random nonce prefix. The rest is the
+// archive in AES-256-GCM sealed chunks of chunkSize bytes. Each chunk's
+// nonce is the prefix, the chunk's index and whether it's the last one, so
+// chunks can't be reordered, dropped or cut off without failing to open.
+const (
+	encryptedMagic = "TUBELYE1"
+	saltSize       = 16
+	prefixSize     = 7
+	chunkSize      = 64 << 10
+)
+
+// ErrEncrypted is returned when restoring an encrypted archive without a
+// passphrase.
+var ErrEncrypted = errors.New("the backup is encrypted, give its passphrase")
+
+// ErrNotEncrypted is returned when restoring an archive that isn't
+// encrypted with a passphrase, so one swapped in for a real backup isn't
+// restored unnoticed.
+var ErrNotEncrypted = errors.New("the backup isn't encrypted, restore it without a passphrase if it's trusted")
+
+// ErrDecrypt is returned when an encrypted archive doesn't open with the
+// passphrase given.
+var ErrDecrypt = errors.New("couldn't decrypt the backup: wrong passphrase, or the archive is corrupt")
+
+func deriveKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 0, prefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, index)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+type encryptWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	buf    []byte
+}
+
+func newEncryptWriter(w io.Writer, passphrase string) (*encryptWriter, error) {
+	header := make([]byte, len(encryptedMagic)+saltSize+prefixSize)
+	copy(header, encryptedMagic)
+	_, err := rand.Read(header[len(encryptedMagic):])
+	if err != nil {
+		return nil, err
+	}
+	salt := header[len(encryptedMagic) : len(encryptedMagic)+saltSize]
+	aead, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(header)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptWriter{w: w, aead: aead, prefix: header[len(encryptedMagic)+saltSize:]}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	e.buf = append(e.buf, p...)
+	// A full chunk is only sealed once more follows it, since the last
+	// chunk is sealed differently
+	for len(e.buf) > chunkSize {
+		err := e.seal(e.buf[:chunkSize], false)
+		if err != nil {
+			return 0, err
+		}
+		e.buf = e.buf[chunkSize:]
+	}
+	return len(p), nil
+}
+
+// Close seals the last chunk. It doesn't close the underlying writer.
+func (e *encryptWriter) Close() error {
+	return e.seal(e.buf, true)
+}
+
+func (e *encryptWriter) seal(chunk []byte, last bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.prefix, e.index, last), chunk, nil)
+	e.index++
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+type decryptReader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	buf    []byte
+	done   bool
+}
+
+// openArchive returns the archive in r, decrypting it if it's encrypted.
+// With a passphrase, it must be.
+func openArchive(r io.Reader, passphrase string) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(encryptedMagic))
+	if err != nil || !bytes.Equal(magic, []byte(encryptedMagic)) {
+		if passphrase != "" {
+			return nil, ErrNotEncrypted
+		}
+		return br, nil
+	}
+	if passphrase == "" {
+		return nil, ErrEncrypted
+	}
+	header := make([]byte, len(encryptedMagic)+saltSize+prefixSize)
+	_, err = io.ReadFull(br, header)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	aead, err := deriveKey(passphrase, header[len(encryptedMagic):len(encryptedMagic)+saltSize])
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{r: br, aead: aead, prefix: header[len(encryptedMagic)+saltSize:]}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		err := d.open()
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	sealed := make([]byte, chunkSize+d.aead.Overhead())
+	n, err := io.ReadFull(d.r, sealed)
+	last := errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+	if err != nil && !last {
+		return err
+	}
+	if !last {
+		_, err = d.r.Peek(1)
+		last = errors.Is(err, io.EOF)
+	}
+	chunk, err := d.aead.Open(nil, chunkNonce(d.prefix, d.index, last), sealed[:n], nil)
+	if err != nil {
+		return ErrDecrypt
+	}
+	d.index++
+	d.buf, d.done = chunk, last
+	return nil
+}
@@ -0,0 +1,18 @@
+// Package cache is a key-value cache shared by every instance, for data
+// that's costly to rebuild on each request such as video metadata and
+// presigned URLs.
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Cache stores values for a limited time. Callers treat it as best effort:
+// a miss or an error just means rebuilding the value.
+type Cache interface {
+	// Get returns the value stored under key, with ok false on a miss.
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keeps the cache in a Redis server. Every key is stored under
+// prefix, so several deployments can share a server.
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedis(client *redis.Client, prefix string) *Redis {
+	return &Redis{client: client, prefix: prefix}
+}
+
+func (c *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get %s from Redis: %w", key, err)
+	}
+	return value, true, nil
+}
+
+func (c *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	err := c.client.Set(ctx, c.prefix+key, value, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("failed to set %s in Redis: %w", key, err)
+	}
+	return nil
+}
+
+func (c *Redis) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = c.prefix + key
+	}
+	err := c.client.Del(ctx, prefixed...).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete from Redis: %w", err)
+	}
+	return nil
+}
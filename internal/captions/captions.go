@@ -0,0 +1,177 @@
+// Package captions produces WebVTT caption tracks from a video's audio.
+package captions
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cue is one caption shown from Start until End.
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// Transcript is the speech found in a piece of audio. Language is an
+// ISO 639-1 code, or empty when the transcriber couldn't tell.
+type Transcript struct {
+	Language string
+	Cues     []Cue
+}
+
+// Transcriber turns speech in a 16 kHz mono WAV file into captions.
+type Transcriber interface {
+	Transcribe(ctx context.Context, audioPath string) (Transcript, error)
+}
+
+// FormatVTT renders cues as a WebVTT file.
+func FormatVTT(cues []Cue) []byte {
+	var b bytes.Buffer
+	b.WriteString("WEBVTT\n")
+	for _, cue := range cues {
+		text := strings.TrimSpace(cue.Text)
+		if text == "" {
+			continue
+		}
+		// A blank line would end the cue early
+		text = strings.ReplaceAll(text, "\n\n", "\n")
+		fmt.Fprintf(&b, "\n%s --> %s\n%s\n", formatTimestamp(cue.Start), formatTimestamp(cue.End), text)
+	}
+	return b.Bytes()
+}
+
+// ParseVTT reads the cues of a WebVTT file. Cue identifiers, settings,
+// comments and style blocks are dropped.
+func ParseVTT(data []byte) ([]Cue, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	if !scanner.Scan() || !strings.HasPrefix(strings.TrimPrefix(scanner.Text(), "\ufeff"), "WEBVTT") {
+		return nil, fmt.Errorf("missing WEBVTT header")
+	}
+
+	var cues []Cue
+	var block []string
+	flush := func() error {
+		defer func() { block = nil }()
+		// The timing line is first, or second after an identifier
+		for i, line := range block {
+			if i > 1 {
+				break
+			}
+			start, end, ok := strings.Cut(line, "-->")
+			if !ok {
+				continue
+			}
+			startTime, err := parseTimestamp(strings.TrimSpace(start))
+			if err != nil {
+				return err
+			}
+			// Cue settings follow the end time
+			endFields := strings.Fields(end)
+			if len(endFields) == 0 {
+				return fmt.Errorf("invalid cue timing %q", line)
+			}
+			endTime, err := parseTimestamp(endFields[0])
+			if err != nil {
+				return err
+			}
+			cues = append(cues, Cue{Start: startTime, End: endTime, Text: strings.Join(block[i+1:], "\n")})
+			return nil
+		}
+		return nil
+	}
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		block = append(block, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return cues, nil
+}
+
+// parseTimestamp parses "hh:mm:ss.ttt" or "mm:ss.ttt".
+func parseTimestamp(value string) (time.Duration, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", value)
+	}
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q", value)
+	}
+	d := time.Duration(seconds * float64(time.Second))
+	for i, unit := range []time.Duration{time.Minute, time.Hour}[:len(parts)-1] {
+		n, err := strconv.Atoi(parts[len(parts)-2-i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp %q", value)
+		}
+		d += time.Duration(n) * unit
+	}
+	return d.Round(time.Millisecond), nil
+}
+
+func formatTimestamp(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// languageCodes maps the language names some Whisper APIs report to
+// ISO 639-1 codes.
+var languageCodes = map[string]string{
+	"arabic":     "ar",
+	"chinese":    "zh",
+	"czech":      "cs",
+	"danish":     "da",
+	"dutch":      "nl",
+	"english":    "en",
+	"finnish":    "fi",
+	"french":     "fr",
+	"german":     "de",
+	"greek":      "el",
+	"hebrew":     "he",
+	"hindi":      "hi",
+	"hungarian":  "hu",
+	"indonesian": "id",
+	"italian":    "it",
+	"japanese":   "ja",
+	"korean":     "ko",
+	"norwegian":  "no",
+	"polish":     "pl",
+	"portuguese": "pt",
+	"romanian":   "ro",
+	"russian":    "ru",
+	"spanish":    "es",
+	"swedish":    "sv",
+	"thai":       "th",
+	"turkish":    "tr",
+	"ukrainian":  "uk",
+	"vietnamese": "vi",
+}
+
+// normalizeLanguage returns an ISO 639-1 code for a language code or name.
+// Names it doesn't know are returned lowercased.
+func normalizeLanguage(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if code, ok := languageCodes[language]; ok {
+		return code
+	}
+	return language
+}
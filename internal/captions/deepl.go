@@ -0,0 +1,71 @@
+package captions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DeepL translates with the DeepL API.
+type DeepL struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+}
+
+func NewDeepL(client *http.Client, baseURL, apiKey string) *DeepL {
+	return &DeepL{client: client, baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey}
+}
+
+func (t *DeepL) Translate(ctx context.Context, texts []string, sourceLanguage, targetLanguage string) ([]string, error) {
+	type request struct {
+		Text       []string `json:"text"`
+		SourceLang string   `json:"source_lang,omitempty"`
+		TargetLang string   `json:"target_lang"`
+	}
+	type response struct {
+		Translations []struct {
+			Text string `json:"text"`
+		} `json:"translations"`
+	}
+
+	dat, err := json.Marshal(request{
+		Text:       texts,
+		SourceLang: strings.ToUpper(sourceLanguage),
+		TargetLang: strings.ToUpper(targetLanguage),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.baseURL+"/v2/translate", bytes.NewReader(dat))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "DeepL-Auth-Key "+t.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call DeepL: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return nil, fmt.Errorf("DeepL returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var output response
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse DeepL response: %w", err)
+	}
+	results := make([]string, len(output.Translations))
+	for i, translation := range output.Translations {
+		results[i] = translation.Text
+	}
+	return results, nil
+}
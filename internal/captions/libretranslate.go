@@ -0,0 +1,73 @@
+package captions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// LibreTranslate translates with a LibreTranslate server, which can be
+// self-hosted.
+type LibreTranslate struct {
+	client  *http.Client
+	baseURL string
+	// apiKey is optional for servers that don't require one.
+	apiKey string
+}
+
+func NewLibreTranslate(client *http.Client, baseURL, apiKey string) *LibreTranslate {
+	return &LibreTranslate{client: client, baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey}
+}
+
+func (t *LibreTranslate) Translate(ctx context.Context, texts []string, sourceLanguage, targetLanguage string) ([]string, error) {
+	type request struct {
+		Q      []string `json:"q"`
+		Source string   `json:"source"`
+		Target string   `json:"target"`
+		Format string   `json:"format"`
+		APIKey string   `json:"api_key,omitempty"`
+	}
+	type response struct {
+		TranslatedText []string `json:"translatedText"`
+	}
+
+	if sourceLanguage == "" {
+		sourceLanguage = "auto"
+	}
+	dat, err := json.Marshal(request{
+		Q:      texts,
+		Source: sourceLanguage,
+		Target: targetLanguage,
+		Format: "text",
+		APIKey: t.apiKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.baseURL+"/translate", bytes.NewReader(dat))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call LibreTranslate: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return nil, fmt.Errorf("LibreTranslate returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var output response
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse LibreTranslate response: %w", err)
+	}
+	return output.TranslatedText, nil
+}
@@ -0,0 +1,96 @@
+package captions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// OpenAI transcribes with a hosted Whisper API compatible with OpenAI's
+// /audio/transcriptions endpoint.
+type OpenAI struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+	model   string
+}
+
+func NewOpenAI(client *http.Client, baseURL, apiKey, model string) *OpenAI {
+	return &OpenAI{client: client, baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey, model: model}
+}
+
+// openAITranscription is the "verbose_json" response. Times are in seconds
+// and the language is reported by name.
+type openAITranscription struct {
+	Language string `json:"language"`
+	Segments []struct {
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+		Text  string  `json:"text"`
+	} `json:"segments"`
+}
+
+func (t *OpenAI) Transcribe(ctx context.Context, audioPath string) (Transcript, error) {
+	audio, err := os.Open(audioPath)
+	if err != nil {
+		return Transcript{}, err
+	}
+	defer audio.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filepath.Base(audioPath))
+	if err != nil {
+		return Transcript{}, err
+	}
+	if _, err := io.Copy(part, audio); err != nil {
+		return Transcript{}, err
+	}
+	form.WriteField("model", t.model)
+	form.WriteField("response_format", "verbose_json")
+	form.WriteField("timestamp_granularities[]", "segment")
+	if err := form.Close(); err != nil {
+		return Transcript{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.baseURL+"/audio/transcriptions", &body)
+	if err != nil {
+		return Transcript{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return Transcript{}, fmt.Errorf("failed to call transcription API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return Transcript{}, fmt.Errorf("transcription API returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var output openAITranscription
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	if err != nil {
+		return Transcript{}, fmt.Errorf("failed to parse transcription API response: %w", err)
+	}
+
+	transcript := Transcript{Language: normalizeLanguage(output.Language)}
+	for _, segment := range output.Segments {
+		transcript.Cues = append(transcript.Cues, Cue{
+			Start: time.Duration(segment.Start * float64(time.Second)),
+			End:   time.Duration(segment.End * float64(time.Second)),
+			Text:  segment.Text,
+		})
+	}
+	return transcript, nil
+}
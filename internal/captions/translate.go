@@ -0,0 +1,40 @@
+package captions
+
+import (
+	"context"
+	"fmt"
+)
+
+// translateBatchSize is how many cues are sent to a translator at once.
+const translateBatchSize = 50
+
+// Translator machine-translates text between languages given as ISO 639-1
+// codes. The result has one entry per input text, in the same order.
+type Translator interface {
+	Translate(ctx context.Context, texts []string, sourceLanguage, targetLanguage string) ([]string, error)
+}
+
+// TranslateCues translates the text of every cue, keeping the timings.
+func TranslateCues(ctx context.Context, translator Translator, cues []Cue, sourceLanguage, targetLanguage string) ([]Cue, error) {
+	translated := make([]Cue, 0, len(cues))
+	for start := 0; start < len(cues); start += translateBatchSize {
+		batch := cues[start:min(start+translateBatchSize, len(cues))]
+		texts := make([]string, len(batch))
+		for i, cue := range batch {
+			texts[i] = cue.Text
+		}
+
+		results, err := translator.Translate(ctx, texts, sourceLanguage, targetLanguage)
+		if err != nil {
+			return nil, err
+		}
+		if len(results) != len(texts) {
+			return nil, fmt.Errorf("translator returned %d texts for %d cues", len(results), len(texts))
+		}
+		for i, cue := range batch {
+			cue.Text = results[i]
+			translated = append(translated, cue)
+		}
+	}
+	return translated, nil
+}
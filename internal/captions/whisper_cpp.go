@@ -0,0 +1,77 @@
+package captions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WhisperCPP transcribes with a local whisper.cpp binary.
+type WhisperCPP struct {
+	// binary is the whisper.cpp CLI, e.g. "whisper-cli".
+	binary string
+	// model is the path of a ggml model file.
+	model string
+}
+
+func NewWhisperCPP(binary, model string) *WhisperCPP {
+	return &WhisperCPP{binary: binary, model: model}
+}
+
+// whisperCPPOutput is the part of whisper.cpp's JSON output ("-oj") that's
+// needed. Offsets are in milliseconds.
+type whisperCPPOutput struct {
+	Result struct {
+		Language string `json:"language"`
+	} `json:"result"`
+	Transcription []struct {
+		Offsets struct {
+			From int64 `json:"from"`
+			To   int64 `json:"to"`
+		} `json:"offsets"`
+		Text string `json:"text"`
+	} `json:"transcription"`
+}
+
+func (t *WhisperCPP) Transcribe(ctx context.Context, audioPath string) (Transcript, error) {
+	dir, err := os.MkdirTemp("", "tubely-whisper")
+	if err != nil {
+		return Transcript{}, err
+	}
+	defer os.RemoveAll(dir)
+	outputPrefix := filepath.Join(dir, "transcript")
+
+	cmd := exec.CommandContext(ctx, t.binary, "-m", t.model, "-f", audioPath, "-l", "auto", "-np", "-oj", "-of", outputPrefix)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return Transcript{}, fmt.Errorf("failed to transcribe with whisper.cpp: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	dat, err := os.ReadFile(outputPrefix + ".json")
+	if err != nil {
+		return Transcript{}, fmt.Errorf("failed to read whisper.cpp output: %w", err)
+	}
+	var output whisperCPPOutput
+	err = json.Unmarshal(dat, &output)
+	if err != nil {
+		return Transcript{}, fmt.Errorf("failed to parse whisper.cpp output: %w", err)
+	}
+
+	transcript := Transcript{Language: normalizeLanguage(output.Result.Language)}
+	for _, segment := range output.Transcription {
+		transcript.Cues = append(transcript.Cues, Cue{
+			Start: time.Duration(segment.Offsets.From) * time.Millisecond,
+			End:   time.Duration(segment.Offsets.To) * time.Millisecond,
+			Text:  segment.Text,
+		})
+	}
+	return transcript, nil
+}
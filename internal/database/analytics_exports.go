@@ -0,0 +1,178 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Analytics export states. Pending exports are picked up again after a
+// restart.
+const (
+	AnalyticsExportStatusPending   = "pending"
+	AnalyticsExportStatusCompleted = "completed"
+	AnalyticsExportStatusFailed    = "failed"
+)
+
+// Analytics export datasets: the raw playback events, the daily rollups
+// made from them, or each user's billable usage.
+const (
+	AnalyticsDatasetPlaybackEvents = "playback_events"
+	AnalyticsDatasetDaily          = "daily"
+	AnalyticsDatasetBillingUsage   = "billing_usage"
+)
+
+// AnalyticsExport is a request to write one dataset's rows for a range of
+// UTC days to a file in S3.
+type AnalyticsExport struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Status    string    `json:"status"`
+	CreateAnalyticsExportParams
+	// Location is the "bucket,key" of the file once completed.
+	Location *string `json:"-"`
+	Rows     int64   `json:"rows"`
+	Error    *string `json:"error"`
+}
+
+type CreateAnalyticsExportParams struct {
+	// Dataset is one of the AnalyticsDataset* values.
+	Dataset string `json:"dataset"`
+	Format  string `json:"format"`
+	// FromDay and ToDay are formatted like 2006-01-02, and both included.
+	FromDay string `json:"from"`
+	ToDay   string `json:"to"`
+	// RequestedBy is "admin" or "schedule".
+	RequestedBy string `json:"requested_by"`
+}
+
+const analyticsExportColumns = `
+		id,
+		created_at,
+		updated_at,
+		status,
+		dataset,
+		format,
+		from_day,
+		to_day,
+		requested_by,
+		location,
+		rows,
+		error`
+
+func (c Client) CreateAnalyticsExport(params CreateAnalyticsExportParams) (AnalyticsExport, error) {
+	id := uuid.New()
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO analytics_exports (` + analyticsExportColumns + `
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, NULL, 0, NULL)
+	`
+	_, err := c.db.Exec(query, id, now, now, AnalyticsExportStatusPending, params.Dataset, params.Format,
+		params.FromDay, params.ToDay, params.RequestedBy)
+	if err != nil {
+		return AnalyticsExport{}, err
+	}
+	return c.GetAnalyticsExport(id)
+}
+
+// GetAnalyticsExport returns a zero AnalyticsExport if there's no such
+// export.
+func (c Client) GetAnalyticsExport(id uuid.UUID) (AnalyticsExport, error) {
+	query := `
+	SELECT` + analyticsExportColumns + `
+	FROM analytics_exports
+	WHERE id = ?
+	`
+	export, err := scanAnalyticsExport(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return AnalyticsExport{}, nil
+	}
+	return export, err
+}
+
+// GetAnalyticsExports returns the most recent exports, newest first.
+func (c Client) GetAnalyticsExports(limit int) ([]AnalyticsExport, error) {
+	query := `
+	SELECT` + analyticsExportColumns + `
+	FROM analytics_exports
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+	return c.queryAnalyticsExports(query, limit)
+}
+
+// GetPendingAnalyticsExports returns the exports that haven't finished,
+// oldest first.
+func (c Client) GetPendingAnalyticsExports() ([]AnalyticsExport, error) {
+	query := `
+	SELECT` + analyticsExportColumns + `
+	FROM analytics_exports
+	WHERE status = ?
+	ORDER BY created_at ASC
+	`
+	return c.queryAnalyticsExports(query, AnalyticsExportStatusPending)
+}
+
+// HasAnalyticsExport reports whether a dataset's range has an export that
+// is pending or completed.
+func (c Client) HasAnalyticsExport(dataset, fromDay, toDay string) (bool, error) {
+	var count int
+	err := c.db.QueryRow("SELECT COUNT(*) FROM analytics_exports WHERE dataset = ? AND from_day = ? AND to_day = ? AND status != ?",
+		dataset, fromDay, toDay, AnalyticsExportStatusFailed).Scan(&count)
+	return count > 0, err
+}
+
+func (c Client) queryAnalyticsExports(query string, args ...any) ([]AnalyticsExport, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []AnalyticsExport{}
+	for rows.Next() {
+		export, err := scanAnalyticsExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+	return exports, rows.Err()
+}
+
+// CompleteAnalyticsExport records where a pending export's file was
+// written.
+func (c Client) CompleteAnalyticsExport(id uuid.UUID, location string, rows int64) error {
+	_, err := c.db.Exec("UPDATE analytics_exports SET status = ?, location = ?, rows = ?, updated_at = ? WHERE id = ? AND status = ?",
+		AnalyticsExportStatusCompleted, location, rows, time.Now().UTC(), id, AnalyticsExportStatusPending)
+	return err
+}
+
+// FailAnalyticsExport records why a pending export couldn't be written.
+func (c Client) FailAnalyticsExport(id uuid.UUID, reason string) error {
+	_, err := c.db.Exec("UPDATE analytics_exports SET status = ?, error = ?, updated_at = ? WHERE id = ? AND status = ?",
+		AnalyticsExportStatusFailed, reason, time.Now().UTC(), id, AnalyticsExportStatusPending)
+	return err
+}
+
+func scanAnalyticsExport(row rowScanner) (AnalyticsExport, error) {
+	var export AnalyticsExport
+	err := row.Scan(
+		&export.ID,
+		&export.CreatedAt,
+		&export.UpdatedAt,
+		&export.Status,
+		&export.Dataset,
+		&export.Format,
+		&export.FromDay,
+		&export.ToDay,
+		&export.RequestedBy,
+		&export.Location,
+		&export.Rows,
+		&export.Error,
+	)
+	return export, err
+}
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AssetKind says what a stored object derived from a video is.
+type AssetKind string
+
+const (
+	AssetKindOriginal           AssetKind = "original"
+	AssetKindRendition          AssetKind = "rendition"
+	AssetKindThumbnail          AssetKind = "thumbnail"
+	AssetKindThumbnailCandidate AssetKind = "thumbnail_candidate"
+	AssetKindCaption            AssetKind = "caption"
+	AssetKindThumbnailVariant   AssetKind = "thumbnail_variant"
+)
+
+// AssetStorage says where an asset's Location points.
+type AssetStorage string
+
+const (
+	// AssetStorageS3 locations are "bucket,key".
+	AssetStorageS3 AssetStorage = "s3"
+	// AssetStorageLocal locations are filenames relative to the assets root.
+	AssetStorageLocal AssetStorage = "local"
+)
+
+// Asset records one stored object that belongs to a video, so that every
+// derived object can be cleaned up with it. Content-addressed objects can
+// be shared by several videos; the object itself is only deleted once no
+// asset references it any more.
+type Asset struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateAssetParams
+	// Size is the object's byte size, or nil until the asset sizing job
+	// has looked it up.
+	Size *int64 `json:"size"`
+	// StorageClass is the S3 storage class a storage migration moved the
+	// object to, or empty for the bucket's default.
+	StorageClass string `json:"storage_class"`
+}
+
+type CreateAssetParams struct {
+	VideoID  uuid.UUID    `json:"video_id"`
+	Kind     AssetKind    `json:"kind"`
+	Storage  AssetStorage `json:"storage"`
+	Location string       `json:"location"`
+}
+
+// CreateAsset records an asset. Recording the same object for the same video
+// twice is a no-op.
+func (c Client) CreateAsset(params CreateAssetParams) error {
+	query := `
+	INSERT OR IGNORE INTO assets (
+		id,
+		created_at,
+		video_id,
+		kind,
+		storage,
+		location
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, uuid.New(), params.VideoID, params.Kind, params.Storage, params.Location)
+	return err
+}
+
+func (c Client) GetAssetsForVideo(videoID uuid.UUID) ([]Asset, error) {
+	query := `
+	SELECT id, created_at, video_id, kind, storage, location, size, storage_class
+	FROM assets
+	WHERE video_id = ?
+	ORDER BY created_at ASC
+	`
+	return c.queryAssets(query, videoID)
+}
+
+// GetOrphanedAssets returns assets whose video no longer exists, left behind
+// when deleting the object failed.
+func (c Client) GetOrphanedAssets() ([]Asset, error) {
+	query := `
+	SELECT a.id, a.created_at, a.video_id, a.kind, a.storage, a.location, a.size, a.storage_class
+	FROM assets a
+	LEFT JOIN videos v ON v.id = a.video_id
+	WHERE v.id IS NULL
+	ORDER BY a.created_at ASC
+	`
+	return c.queryAssets(query)
+}
+
+// GetUnsizedAssets returns up to limit assets whose size hasn't been
+// recorded, in ID order starting after afterID.
+func (c Client) GetUnsizedAssets(afterID uuid.UUID, limit int) ([]Asset, error) {
+	query := `
+	SELECT id, created_at, video_id, kind, storage, location, size, storage_class
+	FROM assets
+	WHERE size IS NULL AND id > ?
+	ORDER BY id
+	LIMIT ?
+	`
+	return c.queryAssets(query, afterID, limit)
+}
+
+// GetAssets returns up to limit assets in ID order starting after afterID.
+func (c Client) GetAssets(afterID uuid.UUID, limit int) ([]Asset, error) {
+	query := `
+	SELECT id, created_at, video_id, kind, storage, location, size, storage_class
+	FROM assets
+	WHERE id > ?
+	ORDER BY id
+	LIMIT ?
+	`
+	return c.queryAssets(query, afterID, limit)
+}
+
+func (c Client) queryAssets(query string, args ...any) ([]Asset, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assets := []Asset{}
+	for rows.Next() {
+		var asset Asset
+		err := rows.Scan(
+			&asset.ID,
+			&asset.CreatedAt,
+			&asset.VideoID,
+			&asset.Kind,
+			&asset.Storage,
+			&asset.Location,
+			&asset.Size,
+			&asset.StorageClass,
+		)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+	return assets, rows.Err()
+}
+
+// CountOtherAssetReferences counts the records of the same object other than
+// the given one, from this or any other video.
+func (c Client) CountOtherAssetReferences(params CreateAssetParams) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM assets
+	WHERE storage = ? AND location = ?
+	AND NOT (video_id = ? AND kind = ?)
+	`
+	var count int
+	err := c.db.QueryRow(query, params.Storage, params.Location, params.VideoID, params.Kind).Scan(&count)
+	return count, err
+}
+
+func (c Client) DeleteAsset(params CreateAssetParams) error {
+	query := `
+	DELETE FROM assets
+	WHERE video_id = ? AND kind = ? AND storage = ? AND location = ?
+	`
+	_, err := c.db.Exec(query, params.VideoID, params.Kind, params.Storage, params.Location)
+	return err
+}
+
+// SetAssetSize records the size of an object on every asset referencing it.
+func (c Client) SetAssetSize(storage AssetStorage, location string, size int64) error {
+	_, err := c.db.Exec("UPDATE assets SET size = ? WHERE storage = ? AND location = ?", size, storage, location)
+	return err
+}
+
+// SetAssetStorageClass records the storage class an object was moved to on
+// every asset referencing it.
+func (c Client) SetAssetStorageClass(storage AssetStorage, location, storageClass string) error {
+	_, err := c.db.Exec("UPDATE assets SET storage_class = ? WHERE storage = ? AND location = ?", storageClass, storage, location)
+	return err
+}
+
+// AssetUsage is how many objects of one kind a user stores in one place
+// and storage class, and their total size.
+type AssetUsage struct {
+	UserID       uuid.UUID
+	Kind         AssetKind
+	Storage      AssetStorage
+	StorageClass string
+	Objects      int64
+	// Bytes only counts the sized objects; Unsized says how many aren't.
+	Bytes   int64
+	Unsized int64
+}
+
+// GetAssetUsage adds up the assets of every user's videos. An object shared
+// by several of a user's videos is counted once.
+func (c Client) GetAssetUsage() ([]AssetUsage, error) {
+	query := `
+	SELECT user_id, kind, storage, storage_class, COUNT(*), COALESCE(SUM(size), 0), COUNT(*) - COUNT(size)
+	FROM (
+		SELECT DISTINCT v.user_id, a.kind, a.storage, a.location, a.size, a.storage_class
+		FROM assets a
+		JOIN videos v ON v.id = a.video_id
+	)
+	GROUP BY user_id, kind, storage, storage_class
+	ORDER BY user_id, kind, storage, storage_class
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []AssetUsage{}
+	for rows.Next() {
+		var u AssetUsage
+		err := rows.Scan(&u.UserID, &u.Kind, &u.Storage, &u.StorageClass, &u.Objects, &u.Bytes, &u.Unsized)
+		if err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
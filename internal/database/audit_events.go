@@ -0,0 +1,93 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	AuditActionUserDeleted            = "user.deleted"
+	AuditActionVideoModerated         = "video.moderated"
+	AuditActionThumbnailModerated     = "thumbnail.moderated"
+	AuditActionVideoTransferOffered   = "video.transfer_offered"
+	AuditActionVideoTransferDeclined  = "video.transfer_declined"
+	AuditActionVideoTransferCancelled = "video.transfer_cancelled"
+	AuditActionVideoTransferred       = "video.transferred"
+	AuditActionLegalHoldPlaced        = "video.legal_hold_placed"
+	AuditActionLegalHoldLifted        = "video.legal_hold_lifted"
+	AuditActionTakedownFiled          = "takedown.filed"
+	AuditActionTakedownBlocked        = "takedown.blocked"
+	AuditActionTakedownRejected       = "takedown.rejected"
+	AuditActionTakedownDisputed       = "takedown.disputed"
+	AuditActionTakedownReinstated     = "takedown.reinstated"
+	AuditActionTakedownUpheld         = "takedown.upheld"
+	AuditActionReportsDismissed       = "video.reports_dismissed"
+	AuditActionReportedVideoHidden    = "video.reported_hidden"
+	AuditActionReportedVideoDeleted   = "video.reported_deleted"
+)
+
+// AuditEvent is an append-only record of a sensitive action. Events must not
+// hold personal data, since they outlive the records they describe.
+type AuditEvent struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateAuditEventParams
+}
+
+type CreateAuditEventParams struct {
+	// Actor is "user:<id>" or "admin".
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	SubjectID uuid.UUID       `json:"subject_id"`
+	Details   json.RawMessage `json:"details,omitempty"`
+}
+
+func (c Client) CreateAuditEvent(params CreateAuditEventParams) error {
+	details := params.Details
+	if details == nil {
+		details = json.RawMessage("{}")
+	}
+	query := `
+	INSERT INTO audit_events (
+		id,
+		created_at,
+		actor,
+		action,
+		subject_id,
+		details
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, uuid.New(), params.Actor, params.Action, params.SubjectID, string(details))
+	return err
+}
+
+// GetAuditEventsForSubject returns the events recorded against a subject,
+// oldest first. Events from the same second stay in the order they were
+// recorded.
+func (c Client) GetAuditEventsForSubject(subjectID uuid.UUID) ([]AuditEvent, error) {
+	query := `
+	SELECT id, created_at, actor, action, subject_id, details
+	FROM audit_events
+	WHERE subject_id = ?
+	ORDER BY created_at ASC, rowid ASC
+	`
+	rows, err := c.db.Query(query, subjectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	auditEvents := []AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		var details string
+		if err := rows.Scan(&event.ID, &event.CreatedAt, &event.Actor, &event.Action, &event.SubjectID, &details); err != nil {
+			return nil, err
+		}
+		event.Details = json.RawMessage(details)
+		auditEvents = append(auditEvents, event)
+	}
+	return auditEvents, rows.Err()
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Backup records an archive of the database and assets written to the
+// backup prefix, so old ones can be pruned without listing the bucket.
+type Backup struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateBackupParams
+}
+
+type CreateBackupParams struct {
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	Size      int64  `json:"size"`
+	Encrypted bool   `json:"encrypted"`
+	// Assets is how many files of the assets root the archive holds, and
+	// Objects how many stored objects its manifest lists.
+	Assets  int `json:"assets"`
+	Objects int `json:"objects"`
+	// RequestedBy is "admin" or "schedule".
+	RequestedBy string `json:"requested_by"`
+}
+
+const backupColumns = `
+		id,
+		created_at,
+		bucket,
+		key,
+		size,
+		encrypted,
+		assets,
+		objects,
+		requested_by`
+
+// CreateBackup records a backup taken at createdAt.
+func (c Client) CreateBackup(createdAt time.Time, params CreateBackupParams) (Backup, error) {
+	backup := Backup{ID: uuid.New(), CreatedAt: createdAt.UTC(), CreateBackupParams: params}
+	query := `
+	INSERT INTO backups (` + backupColumns + `
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, backup.ID, backup.CreatedAt, params.Bucket, params.Key, params.Size,
+		params.Encrypted, params.Assets, params.Objects, params.RequestedBy)
+	if err != nil {
+		return Backup{}, err
+	}
+	return backup, nil
+}
+
+// GetBackups returns the most recent backups, newest first.
+func (c Client) GetBackups(limit int) ([]Backup, error) {
+	query := `
+	SELECT` + backupColumns + `
+	FROM backups
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+	return c.queryBackups(query, limit)
+}
+
+// GetExpiredBackups returns the backups taken before cutoff, oldest first,
+// except the newest backup, which is never expired.
+func (c Client) GetExpiredBackups(cutoff time.Time) ([]Backup, error) {
+	query := `
+	SELECT` + backupColumns + `
+	FROM backups
+	WHERE created_at < ?
+		AND id != (SELECT id FROM backups ORDER BY created_at DESC LIMIT 1)
+	ORDER BY created_at ASC
+	`
+	return c.queryBackups(query, cutoff.UTC())
+}
+
+func (c Client) DeleteBackup(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM backups WHERE id = ?", id)
+	return err
+}
+
+func (c Client) queryBackups(query string, args ...any) ([]Backup, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	backups := []Backup{}
+	for rows.Next() {
+		var backup Backup
+		err := rows.Scan(
+			&backup.ID,
+			&backup.CreatedAt,
+			&backup.Bucket,
+			&backup.Key,
+			&backup.Size,
+			&backup.Encrypted,
+			&backup.Assets,
+			&backup.Objects,
+			&backup.RequestedBy,
+		)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, backup)
+	}
+	return backups, rows.Err()
+}
@@ -0,0 +1,69 @@
+package database
+
+import (
+	"bytes"
+	"encoding/gob"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cache"
+	"github.com/google/uuid"
+)
+
+// WithCache returns a client that keeps videos read by GetVideo in cache
+// for up to ttl, and forgets them when they change. The cache is best
+// effort: while it's unreachable, reads go to the database and entries that
+// couldn't be forgotten expire within ttl. Counters that change on every
+// playback or in bulk, view counts and the likes removed with an account,
+// aren't forgotten, so cached copies of them can lag by up to ttl.
+func (c Client) WithCache(videoCache cache.Cache, ttl time.Duration) Client {
+	c.cache = videoCache
+	c.cacheTTL = ttl
+	return c
+}
+
+func videoCacheKey(id uuid.UUID) string {
+	return "video:" + id.String()
+}
+
+// cachedVideo returns the cached copy of a video, if there is one. gob
+// keeps the fields the JSON API hides.
+func (c Client) cachedVideo(id uuid.UUID) (Video, bool) {
+	if c.cache == nil {
+		return Video{}, false
+	}
+	ctx, cancel := c.db.context()
+	defer cancel()
+	dat, ok, err := c.cache.Get(ctx, videoCacheKey(id))
+	if err != nil || !ok {
+		return Video{}, false
+	}
+	var video Video
+	if err := gob.NewDecoder(bytes.NewReader(dat)).Decode(&video); err != nil {
+		return Video{}, false
+	}
+	return video, true
+}
+
+func (c Client) cacheVideo(video Video) {
+	// A replica can lag behind a change that was just forgotten, so only
+	// what the primary read is cached
+	if c.cache == nil || c.db.isReplica {
+		return
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(video); err != nil {
+		return
+	}
+	ctx, cancel := c.db.context()
+	defer cancel()
+	c.cache.Set(ctx, videoCacheKey(video.ID), buf.Bytes(), c.cacheTTL)
+}
+
+func (c Client) forgetVideo(id uuid.UUID) {
+	if c.cache == nil {
+		return
+	}
+	ctx, cancel := c.db.context()
+	defer cancel()
+	c.cache.Delete(ctx, videoCacheKey(id))
+}
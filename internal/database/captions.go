@@ -0,0 +1,121 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Caption is a WebVTT caption track for a video. A video has at most one
+// track per language.
+type Caption struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateCaptionParams
+}
+
+// CaptionSource says how a caption track was made.
+type CaptionSource string
+
+const (
+	CaptionSourceTranscription CaptionSource = "transcription"
+	CaptionSourceTranslation   CaptionSource = "translation"
+)
+
+type CreateCaptionParams struct {
+	VideoID uuid.UUID `json:"video_id"`
+	// Language is an ISO 639-1 code, or empty when it couldn't be detected.
+	Language string        `json:"language"`
+	Source   CaptionSource `json:"source"`
+	// TranslatedFrom is the language of the track a translation was made
+	// from.
+	TranslatedFrom string `json:"translated_from,omitempty"`
+	// Filename is relative to the assets root.
+	Filename string `json:"-"`
+}
+
+// CreateCaption stores a caption track, replacing any existing track for
+// the same video and language.
+func (c Client) CreateCaption(params CreateCaptionParams) (Caption, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO captions (
+		id,
+		created_at,
+		video_id,
+		language,
+		source,
+		translated_from,
+		filename
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	ON CONFLICT (video_id, language) DO UPDATE SET
+		id = excluded.id,
+		created_at = excluded.created_at,
+		source = excluded.source,
+		translated_from = excluded.translated_from,
+		filename = excluded.filename
+	`
+	_, err := c.db.Exec(query, id, params.VideoID, params.Language, params.Source, params.TranslatedFrom, params.Filename)
+	if err != nil {
+		return Caption{}, err
+	}
+
+	return c.GetCaption(params.VideoID, params.Language)
+}
+
+// GetCaption returns a video's caption track in a language, or a zero
+// Caption if there is none.
+func (c Client) GetCaption(videoID uuid.UUID, language string) (Caption, error) {
+	query := `
+	SELECT id, created_at, video_id, language, source, translated_from, filename
+	FROM captions
+	WHERE video_id = ? AND language = ?
+	`
+	caption, err := scanCaption(c.db.QueryRow(query, videoID, language))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Caption{}, nil
+		}
+		return Caption{}, err
+	}
+	return caption, nil
+}
+
+func (c Client) GetCaptions(videoID uuid.UUID) ([]Caption, error) {
+	query := `
+	SELECT id, created_at, video_id, language, source, translated_from, filename
+	FROM captions
+	WHERE video_id = ?
+	ORDER BY created_at ASC
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	captions := []Caption{}
+	for rows.Next() {
+		caption, err := scanCaption(rows)
+		if err != nil {
+			return nil, err
+		}
+		captions = append(captions, caption)
+	}
+	return captions, rows.Err()
+}
+
+func scanCaption(row rowScanner) (Caption, error) {
+	var caption Caption
+	err := row.Scan(
+		&caption.ID,
+		&caption.CreatedAt,
+		&caption.VideoID,
+		&caption.Language,
+		&caption.Source,
+		&caption.TranslatedFrom,
+		&caption.Filename,
+	)
+	return caption, err
+}
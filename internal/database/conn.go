@@ -0,0 +1,236 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Options tunes the connection pool and query timeouts. Zero values pick
+// the defaults.
+type Options struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxIdleTime time.Duration
+	// QueryTimeout bounds every query, and every transaction as a whole
+	QueryTimeout time.Duration
+	// BusyTimeout is how long SQLite waits on a locked database before
+	// failing with "database is locked"
+	BusyTimeout time.Duration
+	// ReadReplica is the path of a read-only copy of the database kept in
+	// sync from outside the process, e.g. by LiteFS or Litestream. Empty
+	// means Replica reads from the primary.
+	ReadReplica string
+}
+
+const (
+	defaultMaxOpenConns    = 10
+	defaultMaxIdleConns    = 5
+	defaultConnMaxIdleTime = 5 * time.Minute
+	defaultQueryTimeout    = 5 * time.Second
+	defaultBusyTimeout     = 5 * time.Second
+)
+
+// conn is the pool behind a Client. It has the same query methods as
+// *sql.DB, but each one runs with the query timeout, so a slow disk fails
+// requests instead of wedging every handler behind it.
+//
+// SQLite allows one writer at a time, so writes don't share the pool:
+// Exec and Begin go through writer, a single connection that serializes
+// them in the process instead of having them fight over the file lock.
+// Queries read from the pool, which WAL mode lets run alongside a write.
+type conn struct {
+	*sql.DB
+	writer *sql.DB
+	// timeout is 0 while migrating, since building an index on a large
+	// table can legitimately take a while
+	timeout time.Duration
+
+	// replica is nil when no read replica is configured
+	replica   *conn
+	isReplica bool
+
+	// Prepared statements for the hot paths
+	getVideo    *sql.Stmt
+	updateVideo *sql.Stmt
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxOpenConns <= 0 {
+		o.MaxOpenConns = defaultMaxOpenConns
+	}
+	if o.MaxIdleConns <= 0 {
+		o.MaxIdleConns = defaultMaxIdleConns
+	}
+	if o.ConnMaxIdleTime <= 0 {
+		o.ConnMaxIdleTime = defaultConnMaxIdleTime
+	}
+	if o.QueryTimeout <= 0 {
+		o.QueryTimeout = defaultQueryTimeout
+	}
+	if o.BusyTimeout <= 0 {
+		o.BusyTimeout = defaultBusyTimeout
+	}
+	return o
+}
+
+// openConn opens the reader pool and the writer on the SQLite file at path.
+func openConn(path string, opts Options) (*conn, error) {
+	busyTimeout := fmt.Sprintf("_busy_timeout=%d", opts.BusyTimeout.Milliseconds())
+	readers, err := sql.Open("sqlite3", withParams(path, "_journal_mode=WAL&"+busyTimeout))
+	if err != nil {
+		return nil, err
+	}
+	readers.SetMaxOpenConns(opts.MaxOpenConns)
+	readers.SetMaxIdleConns(opts.MaxIdleConns)
+	readers.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+
+	// Transactions take the write lock up front: a deferred transaction
+	// that reads before writing can't wait out the busy timeout
+	writer, err := sql.Open("sqlite3", withParams(path, "_journal_mode=WAL&_txlock=immediate&"+busyTimeout))
+	if err != nil {
+		readers.Close()
+		return nil, err
+	}
+	writer.SetMaxOpenConns(1)
+	writer.SetMaxIdleConns(1)
+
+	c := &conn{DB: readers, writer: writer}
+	if opts.ReadReplica != "" {
+		// Writes still go to the primary
+		replica, err := sql.Open("sqlite3", withParams(opts.ReadReplica, "_query_only=true&"+busyTimeout))
+		if err != nil {
+			readers.Close()
+			writer.Close()
+			return nil, err
+		}
+		replica.SetMaxOpenConns(opts.MaxOpenConns)
+		replica.SetMaxIdleConns(opts.MaxIdleConns)
+		replica.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+		c.replica = &conn{DB: replica, writer: writer, isReplica: true}
+	}
+	return c, nil
+}
+
+// withParams adds connection parameters to a SQLite path.
+func withParams(path, params string) string {
+	if strings.Contains(path, "?") {
+		return path + "&" + params
+	}
+	return path + "?" + params
+}
+
+// setTimeout sets the query timeout of the pool and its replica.
+func (c *conn) setTimeout(timeout time.Duration) {
+	c.timeout = timeout
+	if c.replica != nil {
+		c.replica.timeout = timeout
+	}
+}
+
+func (c *conn) context() (context.Context, context.CancelFunc) {
+	if c.timeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), c.timeout)
+}
+
+// prepare compiles the hot-path statements. It runs after migrating, once
+// every column they name exists.
+func (c *conn) prepare() error {
+	var err error
+	c.getVideo, err = c.DB.Prepare(getVideoQuery)
+	if err != nil {
+		return err
+	}
+	c.updateVideo, err = c.writer.Prepare(updateVideoQuery)
+	if err != nil {
+		return err
+	}
+	if c.replica != nil {
+		c.replica.getVideo, err = c.replica.DB.Prepare(getVideoQuery)
+		c.replica.updateVideo = c.updateVideo
+	}
+	return err
+}
+
+func (c *conn) Exec(query string, args ...any) (sql.Result, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return c.writer.ExecContext(ctx, query, args...)
+}
+
+func (c *conn) Query(query string, args ...any) (*rows, error) {
+	ctx, cancel := c.context()
+	r, err := c.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &rows{Rows: r, cancel: cancel}, nil
+}
+
+func (c *conn) QueryRow(query string, args ...any) *row {
+	ctx, cancel := c.context()
+	return &row{Row: c.DB.QueryRowContext(ctx, query, args...), cancel: cancel}
+}
+
+func (c *conn) Begin() (*tx, error) {
+	ctx, cancel := c.context()
+	t, err := c.writer.BeginTx(ctx, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &tx{Tx: t, cancel: cancel}, nil
+}
+
+// execStmt and queryRowStmt run a prepared statement with the query timeout.
+func (c *conn) execStmt(stmt *sql.Stmt, args ...any) (sql.Result, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+	return stmt.ExecContext(ctx, args...)
+}
+
+func (c *conn) queryRowStmt(stmt *sql.Stmt, args ...any) *row {
+	ctx, cancel := c.context()
+	return &row{Row: stmt.QueryRowContext(ctx, args...), cancel: cancel}
+}
+
+// row, rows and tx release their timeout once they're done with.
+type row struct {
+	*sql.Row
+	cancel context.CancelFunc
+}
+
+func (r *row) Scan(dest ...any) error {
+	defer r.cancel()
+	return r.Row.Scan(dest...)
+}
+
+type rows struct {
+	*sql.Rows
+	cancel context.CancelFunc
+}
+
+func (r *rows) Close() error {
+	defer r.cancel()
+	return r.Rows.Close()
+}
+
+type tx struct {
+	*sql.Tx
+	cancel context.CancelFunc
+}
+
+func (t *tx) Commit() error {
+	defer t.cancel()
+	return t.Tx.Commit()
+}
+
+func (t *tx) Rollback() error {
+	defer t.cancel()
+	return t.Tx.Rollback()
+}
@@ -3,26 +3,56 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cache"
+	"github.com/google/uuid"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Client struct {
-	db *sql.DB
+	db *conn
+	// cache is nil unless the client was made WithCache
+	cache    cache.Cache
+	cacheTTL time.Duration
+	// onVideoChange is nil unless the client was made WithVideoChangeHook
+	onVideoChange func(id uuid.UUID)
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
-func NewClient(pathToDB string) (Client, error) {
-	db, err := sql.Open("sqlite3", pathToDB)
+func NewClient(pathToDB string, opts Options) (Client, error) {
+	db, err := openConn(pathToDB, opts.withDefaults())
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
+
+	c := Client{db: db}
 	err = c.autoMigrate()
 	if err != nil {
 		return Client{}, err
 	}
+	err = c.db.prepare()
+	if err != nil {
+		return Client{}, err
+	}
+	c.db.setTimeout(opts.QueryTimeout)
 	return c, nil
+}
 
+// Replica returns a client that reads from the read replica, if there is
+// one. Replicas lag behind the primary, so it suits listings and playback,
+// not reading back something the request just wrote.
+func (c Client) Replica() Client {
+	if c.db.replica == nil {
+		return c
+	}
+	c.db = c.db.replica
+	return c
 }
 
 func (c *Client) autoMigrate() error {
@@ -72,16 +102,930 @@ func (c *Client) autoMigrate() error {
 	if err != nil {
 		return err
 	}
+
+	jobTable := `
+	CREATE TABLE IF NOT EXISTS jobs (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		error TEXT,
+		source_path TEXT NOT NULL DEFAULT '',
+		media_type TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY(video_id) REFERENCES videos(id)
+	);
+	`
+	_, err = c.db.Exec(jobTable)
+	if err != nil {
+		return err
+	}
+
+	thumbnailCandidateTable := `
+	CREATE TABLE IF NOT EXISTS thumbnail_candidates (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		filename TEXT NOT NULL,
+		offset_ms INTEGER NOT NULL DEFAULT 0
+	);
+	`
+	_, err = c.db.Exec(thumbnailCandidateTable)
+	if err != nil {
+		return err
+	}
+
+	organizationTable := `
+	CREATE TABLE IF NOT EXISTS organizations (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		name TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS organization_members (
+		organization_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		role TEXT NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (organization_id, user_id)
+	);
+	`
+	_, err = c.db.Exec(organizationTable)
+	if err != nil {
+		return err
+	}
+
+	uploadTable := `
+	CREATE TABLE IF NOT EXISTS uploads (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		user_id TEXT NOT NULL,
+		video_id TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS uploads_user_created ON uploads(user_id, created_at);
+	`
+	_, err = c.db.Exec(uploadTable)
+	if err != nil {
+		return err
+	}
+
+	webhookTable := `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		user_id TEXT NOT NULL,
+		url TEXT NOT NULL,
+		events TEXT NOT NULL,
+		secret TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		webhook_id TEXT NOT NULL,
+		event TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP,
+		response_status INTEGER,
+		response_body TEXT,
+		error TEXT
+	);
+	`
+	_, err = c.db.Exec(webhookTable)
+	if err != nil {
+		return err
+	}
+
+	shareTable := `
+	CREATE TABLE IF NOT EXISTS shares (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		token TEXT UNIQUE NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		max_views INTEGER,
+		views INTEGER NOT NULL DEFAULT 0,
+		password_hash TEXT
+	);
+	`
+	_, err = c.db.Exec(shareTable)
+	if err != nil {
+		return err
+	}
+
+	thumbnailVariantTable := `
+	CREATE TABLE IF NOT EXISTS thumbnail_variants (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		thumbnail_url TEXT NOT NULL,
+		weight INTEGER NOT NULL DEFAULT 1,
+		impressions INTEGER NOT NULL DEFAULT 0,
+		clicks INTEGER NOT NULL DEFAULT 0
+	);
+	`
+	_, err = c.db.Exec(thumbnailVariantTable)
+	if err != nil {
+		return err
+	}
+
+	captionTable := `
+	CREATE TABLE IF NOT EXISTS captions (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		language TEXT NOT NULL,
+		source TEXT NOT NULL,
+		filename TEXT NOT NULL,
+		UNIQUE(video_id, language)
+	);
+	`
+	_, err = c.db.Exec(captionTable)
+	if err != nil {
+		return err
+	}
+
+	assetTable := `
+	CREATE TABLE IF NOT EXISTS assets (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		video_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		storage TEXT NOT NULL,
+		location TEXT NOT NULL,
+		UNIQUE(video_id, kind, storage, location)
+	);
+	CREATE INDEX IF NOT EXISTS assets_location ON assets(storage, location);
+	`
+	_, err = c.db.Exec(assetTable)
+	if err != nil {
+		return err
+	}
+
+	auditEventTable := `
+	CREATE TABLE IF NOT EXISTS audit_events (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		actor TEXT NOT NULL,
+		action TEXT NOT NULL,
+		subject_id TEXT NOT NULL,
+		details TEXT NOT NULL DEFAULT '{}'
+	);
+	`
+	_, err = c.db.Exec(auditEventTable)
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfNotExists("videos", "original_url", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("jobs", "progress", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "audio_tracks", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("jobs", "audio_tracks", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "resolution_class", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "hdr_format", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "width", "INTEGER")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "height", "INTEGER")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "sample_aspect_num", "INTEGER NOT NULL DEFAULT 1")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "sample_aspect_den", "INTEGER NOT NULL DEFAULT 1")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "thumbnail_original_size", "INTEGER")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "thumbnail_size", "INTEGER")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("jobs", "upload_id", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("jobs", "upload_key", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("jobs", "upload_path", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("jobs", "upload_bucket", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "moderation_status", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "moderation_labels", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "pending_thumbnail_url", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "pending_thumbnail_labels", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("thumbnail_candidates", "flagged", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("captions", "translated_from", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "custom_metadata", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "pending_upload_url", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "organization_id", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "visibility", "TEXT NOT NULL DEFAULT 'unlisted'")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "video_size", "INTEGER")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "duration_seconds", "REAL")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("jobs", "error_code", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "tags", "TEXT NOT NULL DEFAULT '[]'")
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("CREATE INDEX IF NOT EXISTS jobs_video_created ON jobs(video_id, created_at)")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "view_count", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "like_count", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "video_checksum", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "original_checksum", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "version", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "presigned_at", "TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "legal_hold", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("videos", "blocked", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("jobs", "version", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "avatar_url", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "banner_url", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "default_thumbnail_url", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("users", "storage_alert_percent", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("assets", "size", "INTEGER")
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("assets", "storage_class", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	// Listings are per user or per organization, sorted by one of the
+	// VideoSort keys
+	_, err = c.db.Exec(`
+	CREATE INDEX IF NOT EXISTS videos_user_created_sort ON videos(user_id, created_at, id);
+	CREATE INDEX IF NOT EXISTS videos_user_views_sort ON videos(user_id, view_count, id);
+	CREATE INDEX IF NOT EXISTS videos_user_likes_sort ON videos(user_id, like_count, id);
+	CREATE INDEX IF NOT EXISTS videos_user_duration_sort ON videos(user_id, COALESCE(duration_seconds, 0), id);
+	CREATE INDEX IF NOT EXISTS videos_user_size_sort ON videos(user_id, COALESCE(video_size, 0), id);
+	CREATE INDEX IF NOT EXISTS videos_org_created_sort ON videos(organization_id, created_at, id);
+	CREATE INDEX IF NOT EXISTS videos_org_views_sort ON videos(organization_id, view_count, id);
+	CREATE INDEX IF NOT EXISTS videos_org_likes_sort ON videos(organization_id, like_count, id);
+	CREATE INDEX IF NOT EXISTS videos_org_duration_sort ON videos(organization_id, COALESCE(duration_seconds, 0), id);
+	CREATE INDEX IF NOT EXISTS videos_org_size_sort ON videos(organization_id, COALESCE(video_size, 0), id);
+	`)
+	if err != nil {
+		return err
+	}
+	likeTable := `
+	CREATE TABLE IF NOT EXISTS likes (
+		video_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (video_id, user_id)
+	);
+	CREATE INDEX IF NOT EXISTS likes_user ON likes(user_id);
+	`
+	_, err = c.db.Exec(likeTable)
+	if err != nil {
+		return err
+	}
+	followTable := `
+	CREATE TABLE IF NOT EXISTS follows (
+		follower_id TEXT NOT NULL,
+		creator_id TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (follower_id, creator_id)
+	);
+	CREATE INDEX IF NOT EXISTS follows_creator ON follows(creator_id);
+	`
+	_, err = c.db.Exec(followTable)
+	if err != nil {
+		return err
+	}
+	// Playback events are only ever appended, so they're only indexed for
+	// rolling up a day's events and deleting a video's
+	playbackEventTable := `
+	CREATE TABLE IF NOT EXISTS playback_events (
+		id INTEGER PRIMARY KEY,
+		video_id TEXT NOT NULL,
+		session_id TEXT NOT NULL,
+		type TEXT NOT NULL,
+		quartile INTEGER NOT NULL DEFAULT 0,
+		position_seconds REAL NOT NULL,
+		occurred_at TIMESTAMP NOT NULL,
+		received_at TIMESTAMP NOT NULL
+	);
+	DROP INDEX IF EXISTS playback_events_video;
+	CREATE INDEX IF NOT EXISTS playback_events_video_occurred ON playback_events(video_id, occurred_at);
+	CREATE INDEX IF NOT EXISTS playback_events_occurred ON playback_events(occurred_at);
+	`
+	_, err = c.db.Exec(playbackEventTable)
+	if err != nil {
+		return err
+	}
+	videoAnalyticsTable := `
+	CREATE TABLE IF NOT EXISTS video_analytics_daily (
+		video_id TEXT NOT NULL,
+		day TEXT NOT NULL,
+		views INTEGER NOT NULL,
+		watch_seconds REAL NOT NULL,
+		retention TEXT NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (video_id, day)
+	);
+	`
+	_, err = c.db.Exec(videoAnalyticsTable)
+	if err != nil {
+		return err
+	}
+
+	analyticsExportTable := `
+	CREATE TABLE IF NOT EXISTS analytics_exports (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		status TEXT NOT NULL,
+		dataset TEXT NOT NULL,
+		format TEXT NOT NULL,
+		from_day TEXT NOT NULL,
+		to_day TEXT NOT NULL,
+		requested_by TEXT NOT NULL,
+		location TEXT,
+		rows INTEGER NOT NULL,
+		error TEXT
+	);
+	CREATE INDEX IF NOT EXISTS analytics_exports_created ON analytics_exports(created_at);
+	`
+	_, err = c.db.Exec(analyticsExportTable)
+	if err != nil {
+		return err
+	}
+
+	storageMigrationTable := `
+	CREATE TABLE IF NOT EXISTS storage_migrations (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		status TEXT NOT NULL,
+		storage_class TEXT NOT NULL,
+		created_before TIMESTAMP NOT NULL,
+		user_id TEXT,
+		object_class TEXT NOT NULL,
+		objects INTEGER NOT NULL,
+		migrated INTEGER NOT NULL,
+		failed INTEGER NOT NULL,
+		error TEXT
+	);
+	CREATE INDEX IF NOT EXISTS storage_migrations_created ON storage_migrations(created_at);
+	`
+	_, err = c.db.Exec(storageMigrationTable)
+	if err != nil {
+		return err
+	}
+
+	usageTable := `
+	CREATE TABLE IF NOT EXISTS usage_daily (
+		user_id TEXT NOT NULL,
+		day TEXT NOT NULL,
+		metric TEXT NOT NULL,
+		quantity REAL NOT NULL,
+		PRIMARY KEY (user_id, day, metric)
+	);
+	CREATE INDEX IF NOT EXISTS usage_daily_day ON usage_daily(day, user_id);
+	`
+	_, err = c.db.Exec(usageTable)
+	if err != nil {
+		return err
+	}
+	err = c.migrateVideoPublishedAt()
+	if err != nil {
+		return err
+	}
+	scheduledRunTable := `
+	CREATE TABLE IF NOT EXISTS scheduled_runs (
+		id TEXT PRIMARY KEY,
+		job TEXT NOT NULL,
+		instance TEXT NOT NULL,
+		started_at TIMESTAMP NOT NULL,
+		finished_at TIMESTAMP,
+		error TEXT
+	);
+	CREATE INDEX IF NOT EXISTS scheduled_runs_job_started ON scheduled_runs(job, started_at);
+	`
+	_, err = c.db.Exec(scheduledRunTable)
+	if err != nil {
+		return err
+	}
+	storageAuditTable := `
+	CREATE TABLE IF NOT EXISTS storage_audit_findings (
+		id TEXT PRIMARY KEY,
+		checked_at TIMESTAMP NOT NULL,
+		video_id TEXT NOT NULL,
+		field TEXT NOT NULL,
+		location TEXT NOT NULL,
+		problem TEXT NOT NULL,
+		recorded_size INTEGER,
+		actual_size INTEGER,
+		detail TEXT
+	);
+	`
+	_, err = c.db.Exec(storageAuditTable)
+	if err != nil {
+		return err
+	}
+	featureFlagTable := `
+	CREATE TABLE IF NOT EXISTS feature_flags (
+		name TEXT PRIMARY KEY,
+		enabled INTEGER NOT NULL DEFAULT 0,
+		percentage INTEGER NOT NULL DEFAULT 0,
+		user_ids TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMP NOT NULL
+	);
+	`
+	_, err = c.db.Exec(featureFlagTable)
+	if err != nil {
+		return err
+	}
+	uploadSessionTable := `
+	CREATE TABLE IF NOT EXISTS upload_sessions (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		user_id TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		create_video INTEGER NOT NULL DEFAULT 0,
+		title TEXT NOT NULL DEFAULT '',
+		description TEXT NOT NULL DEFAULT '',
+		organization_id TEXT,
+		size INTEGER NOT NULL,
+		media_type TEXT NOT NULL,
+		checksum_sha256 TEXT NOT NULL DEFAULT '',
+		audio_tracks TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL,
+		received INTEGER NOT NULL DEFAULT 0,
+		parts TEXT NOT NULL DEFAULT '[]',
+		job_id TEXT,
+		upload_bucket TEXT NOT NULL,
+		upload_key TEXT NOT NULL,
+		upload_id TEXT NOT NULL,
+		hash_state BLOB
+	);
+	CREATE INDEX IF NOT EXISTS upload_sessions_status_expires ON upload_sessions(status, expires_at);
+	`
+	_, err = c.db.Exec(uploadSessionTable)
+	if err != nil {
+		return err
+	}
+	err = c.addColumnIfNotExists("upload_sessions", "checksum_algorithm", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+
+	videoTransferTable := `
+	CREATE TABLE IF NOT EXISTS video_transfers (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		status TEXT NOT NULL,
+		video_id TEXT NOT NULL,
+		from_user_id TEXT NOT NULL,
+		to_user_id TEXT,
+		to_organization_id TEXT,
+		initiated_by TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS video_transfers_video_status ON video_transfers(video_id, status);
+	`
+	_, err = c.db.Exec(videoTransferTable)
+	if err != nil {
+		return err
+	}
+
+	takedownTable := `
+	CREATE TABLE IF NOT EXISTS takedowns (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		status TEXT NOT NULL,
+		dispute_reason TEXT,
+		resolution_note TEXT,
+		video_id TEXT NOT NULL,
+		claimant_user_id TEXT NOT NULL,
+		reason TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS takedowns_video ON takedowns(video_id);
+	CREATE INDEX IF NOT EXISTS takedowns_status_created ON takedowns(status, created_at);
+	`
+	_, err = c.db.Exec(takedownTable)
+	if err != nil {
+		return err
+	}
+
+	videoReportTable := `
+	CREATE TABLE IF NOT EXISTS video_reports (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		status TEXT NOT NULL,
+		resolved_at TIMESTAMP,
+		video_id TEXT NOT NULL,
+		reporter_user_id TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		details TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS video_reports_status_video ON video_reports(status, video_id);
+	`
+	_, err = c.db.Exec(videoReportTable)
+	if err != nil {
+		return err
+	}
+
+	notificationSettingsTable := `
+	CREATE TABLE IF NOT EXISTS notification_settings (
+		user_id TEXT NOT NULL,
+		event_type TEXT NOT NULL,
+		channel TEXT NOT NULL,
+		enabled INTEGER NOT NULL,
+		target TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (user_id, event_type, channel)
+	);
+	`
+	_, err = c.db.Exec(notificationSettingsTable)
+	if err != nil {
+		return err
+	}
+	err = c.migrateNotificationPreferences()
+	if err != nil {
+		return err
+	}
+
+	err = c.addColumnIfNotExists("videos", "provenance", "TEXT")
+	if err != nil {
+		return err
+	}
+	importTable := `
+	CREATE TABLE IF NOT EXISTS imports (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		status TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		source TEXT NOT NULL,
+		url TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		modified_at TIMESTAMP NOT NULL,
+		user_id TEXT NOT NULL,
+		video_id TEXT,
+		error TEXT,
+		UNIQUE (url, size, modified_at)
+	);
+	CREATE INDEX IF NOT EXISTS imports_updated ON imports(updated_at);
+	CREATE INDEX IF NOT EXISTS imports_user_updated ON imports(user_id, updated_at);
+	`
+	_, err = c.db.Exec(importTable)
+	if err != nil {
+		return err
+	}
+
+	linkedAccountTable := `
+	CREATE TABLE IF NOT EXISTS linked_accounts (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		user_id TEXT NOT NULL,
+		provider TEXT NOT NULL,
+		email TEXT NOT NULL,
+		access_token TEXT NOT NULL,
+		refresh_token TEXT NOT NULL,
+		expires_at TIMESTAMP,
+		UNIQUE (user_id, provider)
+	);
+	`
+	_, err = c.db.Exec(linkedAccountTable)
+	if err != nil {
+		return err
+	}
+
+	videoExportTable := `
+	CREATE TABLE IF NOT EXISTS video_exports (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		status TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		bucket TEXT NOT NULL,
+		region TEXT NOT NULL,
+		endpoint TEXT NOT NULL,
+		prefix TEXT NOT NULL,
+		objects INTEGER NOT NULL,
+		exported INTEGER NOT NULL,
+		failed INTEGER NOT NULL,
+		bytes INTEGER NOT NULL,
+		manifest_key TEXT,
+		error TEXT
+	);
+	CREATE INDEX IF NOT EXISTS video_exports_user_created ON video_exports(user_id, created_at);
+	`
+	_, err = c.db.Exec(videoExportTable)
+	if err != nil {
+		return err
+	}
+
+	backupTable := `
+	CREATE TABLE IF NOT EXISTS backups (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMP NOT NULL,
+		bucket TEXT NOT NULL,
+		key TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		encrypted BOOLEAN NOT NULL,
+		assets INTEGER NOT NULL,
+		objects INTEGER NOT NULL,
+		requested_by TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS backups_created ON backups(created_at);
+	`
+	_, err = c.db.Exec(backupTable)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
+// migrateNotificationPreferences moves the email choices first stored with
+// a column per event into notification_settings.
+func (c *Client) migrateNotificationPreferences() error {
+	var exists bool
+	err := c.db.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'notification_preferences')").Scan(&exists)
+	if err != nil || !exists {
+		return err
+	}
+	query := `
+	INSERT OR IGNORE INTO notification_settings (user_id, event_type, channel, enabled, target, updated_at)
+	SELECT user_id, 'video.processed', 'email', email_video_processed, '', updated_at FROM notification_preferences
+	UNION ALL
+	SELECT user_id, 'video.failed', 'email', email_video_failed, '', updated_at FROM notification_preferences;
+	DROP TABLE notification_preferences;
+	`
+	_, err = c.db.Exec(query)
+	return err
+}
+
+// migrateVideoPublishedAt adds published_at, marking the videos that are
+// already published so followers aren't told about them as if they were
+// new.
+func (c *Client) migrateVideoPublishedAt() error {
+	var exists bool
+	err := c.db.QueryRow("SELECT EXISTS (SELECT 1 FROM pragma_table_info('videos') WHERE name = 'published_at')").Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	_, err = c.db.Exec("ALTER TABLE videos ADD COLUMN published_at TIMESTAMP")
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec("UPDATE videos SET published_at = updated_at WHERE "+publishedCondition, publishedArgs...)
+	return err
+}
+
+// addColumnIfNotExists lets existing databases pick up columns added after
+// their tables were first created.
+func (c *Client) addColumnIfNotExists(table, column, definition string) error {
+	rows, err := c.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			columnType string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = c.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 func (c Client) Reset() error {
+	if _, err := c.db.Exec("DELETE FROM backups"); err != nil {
+		return fmt.Errorf("failed to reset table backups: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_exports"); err != nil {
+		return fmt.Errorf("failed to reset table video_exports: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM imports"); err != nil {
+		return fmt.Errorf("failed to reset table imports: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM linked_accounts"); err != nil {
+		return fmt.Errorf("failed to reset table linked_accounts: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM storage_migrations"); err != nil {
+		return fmt.Errorf("failed to reset table storage_migrations: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM usage_daily"); err != nil {
+		return fmt.Errorf("failed to reset table usage_daily: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM analytics_exports"); err != nil {
+		return fmt.Errorf("failed to reset table analytics_exports: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_analytics_daily"); err != nil {
+		return fmt.Errorf("failed to reset table video_analytics_daily: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM playback_events"); err != nil {
+		return fmt.Errorf("failed to reset table playback_events: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM follows"); err != nil {
+		return fmt.Errorf("failed to reset table follows: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM notification_settings"); err != nil {
+		return fmt.Errorf("failed to reset table notification_settings: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_reports"); err != nil {
+		return fmt.Errorf("failed to reset table video_reports: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM takedowns"); err != nil {
+		return fmt.Errorf("failed to reset table takedowns: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_transfers"); err != nil {
+		return fmt.Errorf("failed to reset table video_transfers: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM organization_members"); err != nil {
+		return fmt.Errorf("failed to reset table organization_members: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM organizations"); err != nil {
+		return fmt.Errorf("failed to reset table organizations: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
+	if _, err := c.db.Exec("DELETE FROM audit_events"); err != nil {
+		return fmt.Errorf("failed to reset table audit_events: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM assets"); err != nil {
+		return fmt.Errorf("failed to reset table assets: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM uploads"); err != nil {
+		return fmt.Errorf("failed to reset table uploads: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhook_deliveries"); err != nil {
+		return fmt.Errorf("failed to reset table webhook_deliveries: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM webhooks"); err != nil {
+		return fmt.Errorf("failed to reset table webhooks: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM scheduled_runs"); err != nil {
+		return fmt.Errorf("failed to reset table scheduled_runs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM storage_audit_findings"); err != nil {
+		return fmt.Errorf("failed to reset table storage_audit_findings: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM feature_flags"); err != nil {
+		return fmt.Errorf("failed to reset table feature_flags: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM upload_sessions"); err != nil {
+		return fmt.Errorf("failed to reset table upload_sessions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM likes"); err != nil {
+		return fmt.Errorf("failed to reset table likes: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM shares"); err != nil {
+		return fmt.Errorf("failed to reset table shares: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM thumbnail_variants"); err != nil {
+		return fmt.Errorf("failed to reset table thumbnail_variants: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM captions"); err != nil {
+		return fmt.Errorf("failed to reset table captions: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM thumbnail_candidates"); err != nil {
+		return fmt.Errorf("failed to reset table thumbnail_candidates: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM jobs"); err != nil {
+		return fmt.Errorf("failed to reset table jobs: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FeatureFlag rolls a feature out gradually. A disabled flag is off for
+// everyone; an enabled one is on for the listed users and for Percentage
+// percent of the rest.
+type FeatureFlag struct {
+	Name       string    `json:"name"`
+	Enabled    bool      `json:"enabled"`
+	Percentage int       `json:"percentage"`
+	UserIDs    UUIDList  `json:"user_ids"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// UUIDList is stored as a comma-separated list of UUIDs.
+type UUIDList []uuid.UUID
+
+func (l UUIDList) Value() (driver.Value, error) {
+	parts := make([]string, len(l))
+	for i, id := range l {
+		parts[i] = id.String()
+	}
+	return strings.Join(parts, ","), nil
+}
+
+func (l *UUIDList) Scan(src any) error {
+	var raw string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	default:
+		return fmt.Errorf("unsupported type for UUID list: %T", src)
+	}
+
+	*l = UUIDList{}
+	if raw == "" {
+		return nil
+	}
+	for _, part := range strings.Split(raw, ",") {
+		id, err := uuid.Parse(part)
+		if err != nil {
+			return fmt.Errorf("invalid UUID %q: %w", part, err)
+		}
+		*l = append(*l, id)
+	}
+	return nil
+}
+
+func (c Client) GetFeatureFlags() ([]FeatureFlag, error) {
+	query := `
+	SELECT name, enabled, percentage, user_ids, updated_at
+	FROM feature_flags
+	ORDER BY name
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	flags := []FeatureFlag{}
+	for rows.Next() {
+		var flag FeatureFlag
+		if err := rows.Scan(&flag.Name, &flag.Enabled, &flag.Percentage, &flag.UserIDs, &flag.UpdatedAt); err != nil {
+			return nil, err
+		}
+		flags = append(flags, flag)
+	}
+	return flags, rows.Err()
+}
+
+// GetFeatureFlag returns a zero FeatureFlag if the flag isn't stored.
+func (c Client) GetFeatureFlag(name string) (FeatureFlag, error) {
+	query := `
+	SELECT name, enabled, percentage, user_ids, updated_at
+	FROM feature_flags
+	WHERE name = ?
+	`
+	var flag FeatureFlag
+	err := c.db.QueryRow(query, name).Scan(&flag.Name, &flag.Enabled, &flag.Percentage, &flag.UserIDs, &flag.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return FeatureFlag{}, nil
+	}
+	return flag, err
+}
+
+// SetFeatureFlag stores a flag, replacing any stored under its name.
+func (c Client) SetFeatureFlag(flag FeatureFlag) (FeatureFlag, error) {
+	if flag.UserIDs == nil {
+		flag.UserIDs = UUIDList{}
+	}
+	flag.UpdatedAt = time.Now().UTC()
+	query := `
+	INSERT INTO feature_flags (name, enabled, percentage, user_ids, updated_at)
+	VALUES (?, ?, ?, ?, ?)
+	ON CONFLICT(name) DO UPDATE SET
+		enabled = excluded.enabled,
+		percentage = excluded.percentage,
+		user_ids = excluded.user_ids,
+		updated_at = excluded.updated_at
+	`
+	_, err := c.db.Exec(query, flag.Name, flag.Enabled, flag.Percentage, flag.UserIDs, flag.UpdatedAt)
+	if err != nil {
+		return FeatureFlag{}, err
+	}
+	return flag, nil
+}
+
+// DeleteFeatureFlag forgets a stored flag, so its configured default
+// applies again.
+func (c Client) DeleteFeatureFlag(name string) error {
+	_, err := c.db.Exec("DELETE FROM feature_flags WHERE name = ?", name)
+	return err
+}
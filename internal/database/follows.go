@@ -0,0 +1,63 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// FollowCreator subscribes the follower to the creator's new videos.
+// Following someone twice is a no-op.
+func (c Client) FollowCreator(followerID, creatorID uuid.UUID) error {
+	_, err := c.db.Exec(`
+	INSERT OR IGNORE INTO follows (follower_id, creator_id, created_at)
+	VALUES (?, ?, CURRENT_TIMESTAMP)
+	`, followerID, creatorID)
+	return err
+}
+
+func (c Client) UnfollowCreator(followerID, creatorID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM follows WHERE follower_id = ? AND creator_id = ?", followerID, creatorID)
+	return err
+}
+
+// IsFollowing reports whether the follower follows the creator.
+func (c Client) IsFollowing(followerID, creatorID uuid.UUID) (bool, error) {
+	var following bool
+	err := c.db.QueryRow("SELECT EXISTS (SELECT 1 FROM follows WHERE follower_id = ? AND creator_id = ?)", followerID, creatorID).Scan(&following)
+	return following, err
+}
+
+func (c Client) CountFollowers(creatorID uuid.UUID) (int, error) {
+	var count int
+	err := c.db.QueryRow("SELECT COUNT(*) FROM follows WHERE creator_id = ?", creatorID).Scan(&count)
+	return count, err
+}
+
+// GetFollowerIDs returns everyone who follows the creator.
+func (c Client) GetFollowerIDs(creatorID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := c.db.Query("SELECT follower_id FROM follows WHERE creator_id = ?", creatorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	followerIDs := []uuid.UUID{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		followerID, err := uuid.Parse(id)
+		if err != nil {
+			return nil, err
+		}
+		followerIDs = append(followerIDs, followerID)
+	}
+	return followerIDs, rows.Err()
+}
+
+// DeleteFollowsForUser removes everyone the user follows and everyone
+// following them.
+func (c Client) DeleteFollowsForUser(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM follows WHERE follower_id = ? OR creator_id = ?", userID, userID)
+	return err
+}
@@ -0,0 +1,230 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Import states. Failed imports are tried again the next time their source
+// is read, until they run out of attempts.
+const (
+	ImportStatusImporting = "importing"
+	ImportStatusImported  = "imported"
+	ImportStatusFailed    = "failed"
+	// ImportStatusSkipped files are never tried again, such as those that
+	// aren't videos the pipeline accepts.
+	ImportStatusSkipped = "skipped"
+)
+
+// Import is a file pulled from an import source, as the video it became.
+// A file is only imported again if its size or modification time change.
+type Import struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	CreateImportParams
+	VideoID *uuid.UUID `json:"video_id"`
+	Error   *string    `json:"error"`
+}
+
+type CreateImportParams struct {
+	// Source is the protocol or cloud storage service the file was pulled
+	// from, such as "sftp" or "dropbox", or "url" for the page of a site
+	// like YouTube.
+	Source string `json:"source"`
+	// URL is where the file is, without credentials.
+	URL string `json:"url"`
+	// Size and ModifiedAt are 0 and when the import was requested for
+	// pages, which aren't versioned like files.
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+	UserID     uuid.UUID `json:"user_id"`
+}
+
+// Provenance records where an imported video came from. It's only set
+// through SetVideoProvenance, never by UpdateVideo.
+type Provenance struct {
+	Source     string    `json:"source"`
+	URL        string    `json:"url"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+	ImportedAt time.Time `json:"imported_at"`
+	ImportID   uuid.UUID `json:"import_id"`
+}
+
+// Provenance is stored as a JSON object in a single column.
+func (p *Provenance) Value() (driver.Value, error) {
+	if p == nil {
+		return nil, nil
+	}
+	dat, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+// provenanceColumn scans the provenance column, which is NULL for videos
+// that weren't imported.
+type provenanceColumn struct {
+	p **Provenance
+}
+
+func (v provenanceColumn) Scan(src any) error {
+	var dat []byte
+	switch s := src.(type) {
+	case nil:
+		*v.p = nil
+		return nil
+	case string:
+		dat = []byte(s)
+	case []byte:
+		dat = s
+	default:
+		return fmt.Errorf("unsupported type for provenance: %T", src)
+	}
+	provenance := &Provenance{}
+	err := json.Unmarshal(dat, provenance)
+	if err != nil {
+		return err
+	}
+	*v.p = provenance
+	return nil
+}
+
+const importColumns = `
+		id,
+		created_at,
+		updated_at,
+		status,
+		attempts,
+		source,
+		url,
+		size,
+		modified_at,
+		user_id,
+		video_id,
+		error`
+
+func scanImport(row rowScanner) (Import, error) {
+	var i Import
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Status,
+		&i.Attempts,
+		&i.Source,
+		&i.URL,
+		&i.Size,
+		&i.ModifiedAt,
+		&i.UserID,
+		&i.VideoID,
+		&i.Error,
+	)
+	return i, err
+}
+
+// ClaimImport records that a file is being imported, or that a failed
+// import of it is being tried again while it has attempts left. Imports
+// still marked as importing since before staleBefore were cut off, and are
+// tried again too. It returns a zero Import when the file was already
+// imported, skipped, is being imported by someone else, or has run out of
+// attempts.
+func (c Client) ClaimImport(params CreateImportParams, maxAttempts int, staleBefore time.Time) (Import, error) {
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO imports (` + importColumns + `
+	) VALUES (?, ?, ?, ?, 1, ?, ?, ?, ?, ?, NULL, NULL)
+	ON CONFLICT (url, size, modified_at) DO UPDATE SET
+		status = excluded.status,
+		attempts = imports.attempts + 1,
+		updated_at = excluded.updated_at,
+		user_id = excluded.user_id,
+		error = NULL
+	WHERE imports.attempts < ?
+		AND (imports.status = ? OR imports.status = ? AND imports.updated_at < ?)
+	RETURNING` + importColumns
+	i, err := scanImport(c.db.QueryRow(query, uuid.New(), now, now, ImportStatusImporting, params.Source,
+		params.URL, params.Size, params.ModifiedAt.UTC(), params.UserID,
+		maxAttempts, ImportStatusFailed, ImportStatusImporting, staleBefore.UTC()))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Import{}, nil
+	}
+	return i, err
+}
+
+// FinishImport records the outcome of an import: the video it became, or
+// why it failed or was skipped.
+func (c Client) FinishImport(id uuid.UUID, status string, videoID *uuid.UUID, importErr *string) error {
+	_, err := c.db.Exec("UPDATE imports SET status = ?, video_id = COALESCE(?, video_id), error = ?, updated_at = ? WHERE id = ?",
+		status, videoID, importErr, time.Now().UTC(), id)
+	return err
+}
+
+// GetImports returns the most recent imports, newest first.
+func (c Client) GetImports(limit int) ([]Import, error) {
+	query := `
+	SELECT` + importColumns + `
+	FROM imports
+	ORDER BY updated_at DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	imports := []Import{}
+	for rows.Next() {
+		i, err := scanImport(rows)
+		if err != nil {
+			return nil, err
+		}
+		imports = append(imports, i)
+	}
+	return imports, rows.Err()
+}
+
+// GetImportsForUser returns a user's most recent imports, newest first.
+func (c Client) GetImportsForUser(userID uuid.UUID, limit int) ([]Import, error) {
+	query := `
+	SELECT` + importColumns + `
+	FROM imports
+	WHERE user_id = ?
+	ORDER BY updated_at DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	imports := []Import{}
+	for rows.Next() {
+		i, err := scanImport(rows)
+		if err != nil {
+			return nil, err
+		}
+		imports = append(imports, i)
+	}
+	return imports, rows.Err()
+}
+
+// SetVideoProvenance records where a video was imported from.
+func (c Client) SetVideoProvenance(id uuid.UUID, provenance Provenance) error {
+	_, err := c.db.Exec("UPDATE videos SET provenance = ?, version = version + 1 WHERE id = ?", &provenance, id)
+	c.forgetVideo(id)
+	c.videoChanged(id)
+	return err
+}
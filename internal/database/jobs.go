@@ -0,0 +1,333 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobStatus string
+
+const (
+	JobStatusQueued  JobStatus = "queued"
+	JobStatusRunning JobStatus = "running"
+	JobStatusDone    JobStatus = "done"
+	JobStatusFailed  JobStatus = "failed"
+)
+
+type Job struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Status    JobStatus `json:"status"`
+	Attempts  int       `json:"attempts"`
+	Progress  int       `json:"progress"`
+	Error     *string   `json:"error"`
+	// ErrorCode is a machine-readable classification of Error.
+	ErrorCode *string `json:"error_code"`
+	// UploadID, UploadBucket, UploadKey and UploadPath describe the S3
+	// multipart upload in flight for this job, so it can be resumed or
+	// aborted after a restart.
+	UploadID     string `json:"-"`
+	UploadBucket string `json:"-"`
+	UploadKey    string `json:"-"`
+	UploadPath   string `json:"-"`
+	// Version goes up by one with every change to the job.
+	Version int64 `json:"-"`
+	CreateJobParams
+}
+
+type CreateJobParams struct {
+	VideoID    uuid.UUID `json:"video_id"`
+	SourcePath string    `json:"-"`
+	MediaType  string    `json:"-"`
+	// AudioTracks lists the audio track indices to keep; empty keeps all.
+	AudioTracks TrackSelection `json:"audio_tracks,omitempty"`
+}
+
+// TrackSelection is stored as a comma-separated list of track indices.
+type TrackSelection []int
+
+func (t TrackSelection) Value() (driver.Value, error) {
+	parts := make([]string, len(t))
+	for i, index := range t {
+		parts[i] = strconv.Itoa(index)
+	}
+	return strings.Join(parts, ","), nil
+}
+
+func (t *TrackSelection) Scan(src any) error {
+	var raw string
+	switch v := src.(type) {
+	case nil:
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	default:
+		return fmt.Errorf("unsupported type for track selection: %T", src)
+	}
+
+	*t = nil
+	if raw == "" {
+		return nil
+	}
+	for _, part := range strings.Split(raw, ",") {
+		index, err := strconv.Atoi(part)
+		if err != nil {
+			return fmt.Errorf("invalid track index %q: %w", part, err)
+		}
+		*t = append(*t, index)
+	}
+	return nil
+}
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		status,
+		attempts,
+		progress,
+		error,
+		error_code,
+		upload_id,
+		upload_bucket,
+		upload_key,
+		upload_path,
+		source_path,
+		media_type,
+		audio_tracks,
+		version`
+
+// ErrJobInProgress is returned by CreateJob when the video already has a
+// queued or running job.
+var ErrJobInProgress = errors.New("video is already being processed")
+
+// CreateJob queues a job for a video unless it already has one queued or
+// running, in which case that job is returned with ErrJobInProgress. The
+// check and insert are one statement, so concurrent calls can't both
+// succeed.
+func (c Client) CreateJob(params CreateJobParams) (Job, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		status,
+		attempts,
+		source_path,
+		media_type,
+		audio_tracks
+	)
+	SELECT ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, 0, ?, ?, ?
+	WHERE NOT EXISTS (
+		SELECT 1 FROM jobs WHERE video_id = ? AND status IN (?, ?)
+	)
+	`
+	result, err := c.db.Exec(query, id, params.VideoID, JobStatusQueued, params.SourcePath, params.MediaType, params.AudioTracks,
+		params.VideoID, JobStatusQueued, JobStatusRunning)
+	if err != nil {
+		return Job{}, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return Job{}, err
+	}
+	if inserted == 0 {
+		job, err := c.GetLatestJobForVideo(params.VideoID)
+		if err != nil {
+			return Job{}, err
+		}
+		return job, ErrJobInProgress
+	}
+
+	return c.GetJob(id)
+}
+
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE id = ?
+	`
+
+	job, err := scanJob(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+
+	return job, nil
+}
+
+// GetLatestJobForVideo returns the most recently created job for a video, or
+// a zero Job if the video has never been processed.
+func (c Client) GetLatestJobForVideo(videoID uuid.UUID) (Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE video_id = ?
+	ORDER BY created_at DESC, rowid DESC
+	LIMIT 1
+	`
+
+	job, err := scanJob(c.db.QueryRow(query, videoID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+
+	return job, nil
+}
+
+// GetIncompleteJobs returns every job that is still queued or was running,
+// oldest first, so they can be resumed after a restart.
+func (c Client) GetIncompleteJobs() ([]Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE status IN (?, ?)
+	ORDER BY created_at ASC
+	`
+
+	rows, err := c.db.Query(query, JobStatusQueued, JobStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// GetVideoIDsWithFailedJobs returns the videos whose latest job failed,
+// oldest failure first.
+func (c Client) GetVideoIDsWithFailedJobs() ([]uuid.UUID, error) {
+	query := `
+	SELECT j.video_id
+	FROM jobs j
+	WHERE j.status = ?
+	AND j.rowid = (
+		SELECT rowid FROM jobs
+		WHERE video_id = j.video_id
+		ORDER BY created_at DESC, rowid DESC
+		LIMIT 1
+	)
+	ORDER BY j.updated_at ASC
+	`
+	rows, err := c.db.Query(query, JobStatusFailed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// UpdateJobProgress records a 0-100 completion percentage for a running job.
+func (c Client) UpdateJobProgress(id uuid.UUID, progress int) error {
+	query := `
+	UPDATE jobs
+	SET
+		updated_at = CURRENT_TIMESTAMP,
+		progress = ?,
+		version = version + 1
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, progress, id)
+	return err
+}
+
+func (c Client) UpdateJob(job Job) error {
+	query := `
+	UPDATE jobs
+	SET
+		updated_at = CURRENT_TIMESTAMP,
+		status = ?,
+		attempts = ?,
+		progress = ?,
+		error = ?,
+		error_code = ?,
+		upload_id = ?,
+		upload_bucket = ?,
+		upload_key = ?,
+		upload_path = ?,
+		source_path = ?,
+		media_type = ?,
+		audio_tracks = ?,
+		version = version + 1
+	WHERE id = ?
+	`
+
+	_, err := c.db.Exec(
+		query,
+		job.Status,
+		job.Attempts,
+		job.Progress,
+		job.Error,
+		job.ErrorCode,
+		job.UploadID,
+		job.UploadBucket,
+		job.UploadKey,
+		job.UploadPath,
+		job.SourcePath,
+		job.MediaType,
+		job.AudioTracks,
+		job.ID,
+	)
+	return err
+}
+
+func scanJob(row rowScanner) (Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.VideoID,
+		&job.Status,
+		&job.Attempts,
+		&job.Progress,
+		&job.Error,
+		&job.ErrorCode,
+		&job.UploadID,
+		&job.UploadBucket,
+		&job.UploadKey,
+		&job.UploadPath,
+		&job.SourcePath,
+		&job.MediaType,
+		&job.AudioTracks,
+		&job.Version,
+	)
+	return job, err
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// LikeVideo records that the user likes the video. Liking a video twice
+// counts once; it reports whether the like is new.
+func (c Client) LikeVideo(videoID, userID uuid.UUID) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+	INSERT OR IGNORE INTO likes (video_id, user_id, created_at)
+	VALUES (?, ?, CURRENT_TIMESTAMP)
+	`, videoID, userID)
+	if err != nil {
+		return false, err
+	}
+	added, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if added == 0 {
+		return false, nil
+	}
+	_, err = tx.Exec("UPDATE videos SET like_count = like_count + 1, version = version + 1 WHERE id = ?", videoID)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	c.forgetVideo(videoID)
+	return true, nil
+}
+
+// UnlikeVideo removes the user's like, reporting whether there was one.
+func (c Client) UnlikeVideo(videoID, userID uuid.UUID) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM likes WHERE video_id = ? AND user_id = ?", videoID, userID)
+	if err != nil {
+		return false, err
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if removed == 0 {
+		return false, nil
+	}
+	_, err = tx.Exec("UPDATE videos SET like_count = like_count - 1, version = version + 1 WHERE id = ?", videoID)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	c.forgetVideo(videoID)
+	return true, nil
+}
+
+// HasLiked reports whether the user likes the video.
+func (c Client) HasLiked(videoID, userID uuid.UUID) (bool, error) {
+	var liked bool
+	err := c.db.QueryRow("SELECT EXISTS (SELECT 1 FROM likes WHERE video_id = ? AND user_id = ?)", videoID, userID).Scan(&liked)
+	return liked, err
+}
+
+// DeleteLikesForUser removes every like the user gave, taking them off the
+// videos' counts.
+func (c Client) DeleteLikesForUser(userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE videos SET like_count = like_count - 1, version = version + 1
+	WHERE id IN (SELECT video_id FROM likes WHERE user_id = ?)
+	`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM likes WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
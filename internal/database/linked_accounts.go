@@ -0,0 +1,164 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LinkedAccount is a user's account on a cloud storage service, which they
+// can import videos from. A user links at most one account per service.
+type LinkedAccount struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UserID    uuid.UUID `json:"user_id"`
+	// Provider is the service, such as "dropbox"
+	Provider string `json:"provider"`
+	// Email is the account's address on the service
+	Email string `json:"email"`
+	// The OAuth tokens are never returned by the API, and are stored as
+	// the server sealed them
+	AccessToken  string     `json:"-"`
+	RefreshToken string     `json:"-"`
+	ExpiresAt    *time.Time `json:"-"`
+}
+
+type LinkAccountParams struct {
+	UserID       uuid.UUID
+	Provider     string
+	Email        string
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    *time.Time
+}
+
+const linkedAccountColumns = `
+		id,
+		created_at,
+		updated_at,
+		user_id,
+		provider,
+		email,
+		access_token,
+		refresh_token,
+		expires_at`
+
+func scanLinkedAccount(row rowScanner) (LinkedAccount, error) {
+	var account LinkedAccount
+	err := row.Scan(
+		&account.ID,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+		&account.UserID,
+		&account.Provider,
+		&account.Email,
+		&account.AccessToken,
+		&account.RefreshToken,
+		&account.ExpiresAt,
+	)
+	return account, err
+}
+
+// LinkAccount links a user's account on a service, replacing the one
+// linked before.
+func (c Client) LinkAccount(params LinkAccountParams) (LinkedAccount, error) {
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO linked_accounts (` + linkedAccountColumns + `
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT (user_id, provider) DO UPDATE SET
+		updated_at = excluded.updated_at,
+		email = excluded.email,
+		access_token = excluded.access_token,
+		refresh_token = excluded.refresh_token,
+		expires_at = excluded.expires_at
+	RETURNING` + linkedAccountColumns
+	return scanLinkedAccount(c.db.QueryRow(query, uuid.New(), now, now, params.UserID, params.Provider,
+		params.Email, params.AccessToken, params.RefreshToken, params.ExpiresAt))
+}
+
+// GetLinkedAccount returns a zero LinkedAccount if the user hasn't linked
+// an account on the service.
+func (c Client) GetLinkedAccount(userID uuid.UUID, provider string) (LinkedAccount, error) {
+	query := `
+	SELECT` + linkedAccountColumns + `
+	FROM linked_accounts
+	WHERE user_id = ? AND provider = ?
+	`
+	account, err := scanLinkedAccount(c.db.QueryRow(query, userID, provider))
+	if errors.Is(err, sql.ErrNoRows) {
+		return LinkedAccount{}, nil
+	}
+	return account, err
+}
+
+func (c Client) GetLinkedAccounts(userID uuid.UUID) ([]LinkedAccount, error) {
+	query := `
+	SELECT` + linkedAccountColumns + `
+	FROM linked_accounts
+	WHERE user_id = ?
+	ORDER BY provider
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []LinkedAccount{}
+	for rows.Next() {
+		account, err := scanLinkedAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// GetLinkedAccountsAfter returns up to limit linked accounts of every user
+// with IDs after the given one, in ID order, for paging through them all.
+func (c Client) GetLinkedAccountsAfter(after uuid.UUID, limit int) ([]LinkedAccount, error) {
+	query := `
+	SELECT` + linkedAccountColumns + `
+	FROM linked_accounts
+	WHERE id > ?
+	ORDER BY id ASC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []LinkedAccount{}
+	for rows.Next() {
+		account, err := scanLinkedAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// UpdateLinkedAccountToken stores a refreshed access token.
+func (c Client) UpdateLinkedAccountToken(id uuid.UUID, accessToken, refreshToken string, expiresAt *time.Time) error {
+	_, err := c.db.Exec("UPDATE linked_accounts SET access_token = ?, refresh_token = ?, expires_at = ?, updated_at = ? WHERE id = ?",
+		accessToken, refreshToken, expiresAt, time.Now().UTC(), id)
+	return err
+}
+
+func (c Client) DeleteLinkedAccount(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM linked_accounts WHERE id = ?", id)
+	return err
+}
+
+func (c Client) DeleteLinkedAccountsForUser(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM linked_accounts WHERE user_id = ?", userID)
+	return err
+}
@@ -0,0 +1,110 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NotificationSettings are the choices a user made about how they're
+// notified. Anything they haven't chosen is left to the defaults of each
+// channel and event type.
+type NotificationSettings struct {
+	Channels map[string]NotificationChannelSetting
+	// Events maps an event type to the channels the user turned it on or
+	// off for
+	Events map[string]map[string]bool
+}
+
+type NotificationChannelSetting struct {
+	Enabled bool
+	// Target is where the channel delivers, such as a Slack webhook URL,
+	// for channels that need one
+	Target string
+}
+
+// GetNotificationSettings returns empty settings if the user hasn't made
+// any choices.
+func (c Client) GetNotificationSettings(userID uuid.UUID) (NotificationSettings, error) {
+	settings := NotificationSettings{
+		Channels: map[string]NotificationChannelSetting{},
+		Events:   map[string]map[string]bool{},
+	}
+	query := `
+	SELECT event_type, channel, enabled, target
+	FROM notification_settings
+	WHERE user_id = ?
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return NotificationSettings{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			eventType string
+			channel   string
+			enabled   bool
+			target    string
+		)
+		if err := rows.Scan(&eventType, &channel, &enabled, &target); err != nil {
+			return NotificationSettings{}, err
+		}
+		// Rows without an event type are about the channel as a whole
+		if eventType == "" {
+			settings.Channels[channel] = NotificationChannelSetting{Enabled: enabled, Target: target}
+			continue
+		}
+		if settings.Events[eventType] == nil {
+			settings.Events[eventType] = map[string]bool{}
+		}
+		settings.Events[eventType][channel] = enabled
+	}
+	return settings, rows.Err()
+}
+
+// SetNotificationSettings replaces all of a user's notification choices.
+func (c Client) SetNotificationSettings(userID uuid.UUID, settings NotificationSettings) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM notification_settings WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO notification_settings (
+		user_id,
+		event_type,
+		channel,
+		enabled,
+		target,
+		updated_at
+	) VALUES (?, ?, ?, ?, ?, ?)
+	`
+	now := time.Now().UTC()
+	for channel, setting := range settings.Channels {
+		_, err = tx.Exec(query, userID, "", channel, setting.Enabled, setting.Target, now)
+		if err != nil {
+			return err
+		}
+	}
+	for eventType, channels := range settings.Events {
+		for channel, enabled := range channels {
+			_, err = tx.Exec(query, userID, eventType, channel, enabled, "", now)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func (c Client) DeleteNotificationSettingsForUser(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM notification_settings WHERE user_id = ?", userID)
+	return err
+}
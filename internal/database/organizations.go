@@ -0,0 +1,240 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Organization is a team workspace. Videos owned by an organization can be
+// managed by its members according to their role.
+type Organization struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Name      string    `json:"name"`
+}
+
+// OrganizationRole is what a member may do in an organization.
+type OrganizationRole string
+
+const (
+	// OrganizationRoleOwner members manage the membership and every video.
+	OrganizationRoleOwner OrganizationRole = "owner"
+	// OrganizationRoleEditor members upload and edit the organization's videos.
+	OrganizationRoleEditor OrganizationRole = "editor"
+	// OrganizationRoleViewer members can only see the organization's videos.
+	OrganizationRoleViewer OrganizationRole = "viewer"
+)
+
+// CanEdit reports whether the role may change the organization's videos.
+func (r OrganizationRole) CanEdit() bool {
+	return r == OrganizationRoleOwner || r == OrganizationRoleEditor
+}
+
+type OrganizationMember struct {
+	OrganizationID uuid.UUID        `json:"organization_id"`
+	UserID         uuid.UUID        `json:"user_id"`
+	Email          string           `json:"email"`
+	Role           OrganizationRole `json:"role"`
+	CreatedAt      time.Time        `json:"created_at"`
+}
+
+// OrganizationMembership is an organization as seen by one of its members.
+type OrganizationMembership struct {
+	Organization
+	Role OrganizationRole `json:"role"`
+}
+
+// CreateOrganization creates an organization with ownerID as its owner.
+func (c Client) CreateOrganization(name string, ownerID uuid.UUID) (Organization, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Organization{}, err
+	}
+	defer tx.Rollback()
+
+	id := uuid.New()
+	_, err = tx.Exec(`
+	INSERT INTO organizations (id, created_at, name)
+	VALUES (?, CURRENT_TIMESTAMP, ?)
+	`, id, name)
+	if err != nil {
+		return Organization{}, err
+	}
+	_, err = tx.Exec(`
+	INSERT INTO organization_members (organization_id, user_id, role, created_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`, id, ownerID, OrganizationRoleOwner)
+	if err != nil {
+		return Organization{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Organization{}, err
+	}
+
+	return c.GetOrganization(id)
+}
+
+func (c Client) GetOrganization(id uuid.UUID) (Organization, error) {
+	query := `
+	SELECT id, created_at, name
+	FROM organizations
+	WHERE id = ?
+	`
+	var org Organization
+	err := c.db.QueryRow(query, id).Scan(&org.ID, &org.CreatedAt, &org.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Organization{}, nil
+		}
+		return Organization{}, err
+	}
+	return org, nil
+}
+
+// GetOrganizationsForUser returns every organization the user belongs to.
+func (c Client) GetOrganizationsForUser(userID uuid.UUID) ([]OrganizationMembership, error) {
+	query := `
+	SELECT o.id, o.created_at, o.name, m.role
+	FROM organizations o
+	JOIN organization_members m ON m.organization_id = o.id
+	WHERE m.user_id = ?
+	ORDER BY o.name ASC
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []OrganizationMembership{}
+	for rows.Next() {
+		var membership OrganizationMembership
+		err := rows.Scan(&membership.ID, &membership.CreatedAt, &membership.Name, &membership.Role)
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, membership)
+	}
+	return memberships, rows.Err()
+}
+
+// GetOrganizationRole returns the user's role in the organization, or an
+// empty role if they aren't a member.
+func (c Client) GetOrganizationRole(orgID, userID uuid.UUID) (OrganizationRole, error) {
+	query := `
+	SELECT role
+	FROM organization_members
+	WHERE organization_id = ? AND user_id = ?
+	`
+	var role OrganizationRole
+	err := c.db.QueryRow(query, orgID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return role, nil
+}
+
+func (c Client) GetOrganizationMembers(orgID uuid.UUID) ([]OrganizationMember, error) {
+	query := `
+	SELECT m.organization_id, m.user_id, u.email, m.role, m.created_at
+	FROM organization_members m
+	JOIN users u ON u.id = m.user_id
+	WHERE m.organization_id = ?
+	ORDER BY m.created_at ASC
+	`
+	rows, err := c.db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []OrganizationMember{}
+	for rows.Next() {
+		var member OrganizationMember
+		err := rows.Scan(&member.OrganizationID, &member.UserID, &member.Email, &member.Role, &member.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// SetOrganizationMember adds a user to the organization or changes their
+// role.
+func (c Client) SetOrganizationMember(orgID, userID uuid.UUID, role OrganizationRole) error {
+	query := `
+	INSERT INTO organization_members (organization_id, user_id, role, created_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT (organization_id, user_id) DO UPDATE SET role = excluded.role
+	`
+	_, err := c.db.Exec(query, orgID, userID, role)
+	return err
+}
+
+func (c Client) DeleteOrganizationMember(orgID, userID uuid.UUID) error {
+	query := `
+	DELETE FROM organization_members
+	WHERE organization_id = ? AND user_id = ?
+	`
+	_, err := c.db.Exec(query, orgID, userID)
+	return err
+}
+
+// DeleteOrganizationMemberships removes a user from every organization. Where
+// they were the only owner, the longest-standing remaining member becomes the
+// owner so the organization can still be managed.
+func (c Client) DeleteOrganizationMemberships(userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	UPDATE organization_members
+	SET role = ?
+	WHERE rowid IN (
+		SELECT (
+			SELECT m.rowid FROM organization_members m
+			WHERE m.organization_id = o.organization_id AND m.user_id <> ?
+			ORDER BY m.created_at ASC, m.rowid ASC
+			LIMIT 1
+		)
+		FROM organization_members o
+		WHERE o.user_id = ? AND o.role = ?
+		AND NOT EXISTS (
+			SELECT 1 FROM organization_members other
+			WHERE other.organization_id = o.organization_id
+			AND other.user_id <> ? AND other.role = ?
+		)
+	)
+	`, OrganizationRoleOwner, userID, userID, OrganizationRoleOwner, userID, OrganizationRoleOwner)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM organization_members WHERE user_id = ?", userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CountOrganizationOwners counts the owners of an organization, so the last
+// one can't be removed.
+func (c Client) CountOrganizationOwners(orgID uuid.UUID) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM organization_members
+	WHERE organization_id = ? AND role = ?
+	`
+	var count int
+	err := c.db.QueryRow(query, orgID, OrganizationRoleOwner).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Cursor marks a row in a sorted listing: the row's sort key, and its id to
+// break ties between rows with the same key. Rows inserted while a client
+// pages through don't shift the rows after a cursor.
+type Cursor struct {
+	// Key is a time.Time for listings sorted by created_at, otherwise a
+	// float64.
+	Key any
+	ID  uuid.UUID
+}
+
+// sqliteTimestamp is the format CURRENT_TIMESTAMP stores created_at in.
+const sqliteTimestamp = "2006-01-02 15:04:05"
+
+// after is the condition selecting the rows that come after the cursor in a
+// listing ordered by keySQL, then id, in the given direction.
+func (c Cursor) after(keySQL string, ascending bool) (string, []any) {
+	op := "<"
+	if ascending {
+		op = ">"
+	}
+	key := c.Key
+	if t, ok := key.(time.Time); ok {
+		key = t.UTC().Format(sqliteTimestamp)
+	}
+	condition := fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", keySQL, op)
+	return condition, []any{key, key, c.ID}
+}
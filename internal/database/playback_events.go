@@ -0,0 +1,161 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Playback event types sent by players.
+const (
+	PlaybackEventPlay     = "play"
+	PlaybackEventPause    = "pause"
+	PlaybackEventProgress = "progress"
+	PlaybackEventComplete = "complete"
+)
+
+// PlaybackEvent is one thing that happened while a viewer watched a video.
+// Viewers are anonymous: events are only tied together by the session the
+// player made up for one playback.
+type PlaybackEvent struct {
+	ID        int64     `json:"id"`
+	VideoID   uuid.UUID `json:"video_id"`
+	SessionID uuid.UUID `json:"session_id"`
+	// Type is one of the PlaybackEvent* values.
+	Type string `json:"type"`
+	// Quartile is 25, 50 or 75 for progress events, and 0 for the others.
+	Quartile        int       `json:"quartile"`
+	PositionSeconds float64   `json:"position_seconds"`
+	OccurredAt      time.Time `json:"occurred_at"`
+	ReceivedAt      time.Time `json:"received_at"`
+}
+
+// CreatePlaybackEvents appends a batch of events with a single statement.
+// IDs are assigned in order, so readers can pick up after the last event
+// they saw.
+func (c Client) CreatePlaybackEvents(events []PlaybackEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	const columns = 7
+	args := make([]any, 0, len(events)*columns)
+	for _, event := range events {
+		args = append(args,
+			event.VideoID,
+			event.SessionID,
+			event.Type,
+			event.Quartile,
+			event.PositionSeconds,
+			event.OccurredAt.UTC(),
+			event.ReceivedAt.UTC(),
+		)
+	}
+	query := `
+	INSERT INTO playback_events (
+		video_id,
+		session_id,
+		type,
+		quartile,
+		position_seconds,
+		occurred_at,
+		received_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?)` + strings.Repeat(", (?, ?, ?, ?, ?, ?, ?)", len(events)-1)
+	_, err := c.db.Exec(query, args...)
+	return err
+}
+
+// GetPlaybackEventVideoIDs returns the videos with events that occurred in
+// [from, to).
+func (c Client) GetPlaybackEventVideoIDs(from, to time.Time) ([]uuid.UUID, error) {
+	rows, err := c.db.Query("SELECT DISTINCT video_id FROM playback_events WHERE occurred_at >= ? AND occurred_at < ?", from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetPlaybackEvents returns a video's events that occurred in [from, to),
+// grouped by session and in the order they happened.
+func (c Client) GetPlaybackEvents(videoID uuid.UUID, from, to time.Time) ([]PlaybackEvent, error) {
+	query := `
+	SELECT
+		id,
+		video_id,
+		session_id,
+		type,
+		quartile,
+		position_seconds,
+		occurred_at,
+		received_at
+	FROM playback_events
+	WHERE video_id = ? AND occurred_at >= ? AND occurred_at < ?
+	ORDER BY session_id, occurred_at, id
+	`
+	return c.queryPlaybackEvents(query, videoID, from.UTC(), to.UTC())
+}
+
+// GetPlaybackEventsAfter returns up to limit events of every video that
+// occurred in [from, to), in ID order starting after afterID.
+func (c Client) GetPlaybackEventsAfter(afterID int64, from, to time.Time, limit int) ([]PlaybackEvent, error) {
+	query := `
+	SELECT
+		id,
+		video_id,
+		session_id,
+		type,
+		quartile,
+		position_seconds,
+		occurred_at,
+		received_at
+	FROM playback_events
+	WHERE id > ? AND occurred_at >= ? AND occurred_at < ?
+	ORDER BY id
+	LIMIT ?
+	`
+	return c.queryPlaybackEvents(query, afterID, from.UTC(), to.UTC(), limit)
+}
+
+func (c Client) queryPlaybackEvents(query string, args ...any) ([]PlaybackEvent, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []PlaybackEvent{}
+	for rows.Next() {
+		var event PlaybackEvent
+		err := rows.Scan(
+			&event.ID,
+			&event.VideoID,
+			&event.SessionID,
+			&event.Type,
+			&event.Quartile,
+			&event.PositionSeconds,
+			&event.OccurredAt,
+			&event.ReceivedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// DeletePlaybackEventsBefore drops events that occurred before cutoff.
+func (c Client) DeletePlaybackEventsBefore(cutoff time.Time) error {
+	_, err := c.db.Exec("DELETE FROM playback_events WHERE occurred_at < ?", cutoff.UTC())
+	return err
+}
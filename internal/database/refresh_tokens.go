@@ -81,3 +81,12 @@ func (c Client) DeleteRefreshToken(token string) error {
 	_, err := c.db.Exec(query, token)
 	return err
 }
+
+func (c Client) DeleteRefreshTokensForUser(userID uuid.UUID) error {
+	query := `
+		DELETE FROM refresh_tokens
+		WHERE user_id = ?
+	`
+	_, err := c.db.Exec(query, userID.String())
+	return err
+}
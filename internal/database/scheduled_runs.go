@@ -0,0 +1,80 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ScheduledRun is one run of a scheduled job. FinishedAt is nil while the
+// run is in progress, or if its instance died during it.
+type ScheduledRun struct {
+	ID         uuid.UUID  `json:"id"`
+	Job        string     `json:"job"`
+	Instance   string     `json:"instance"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Error      *string    `json:"error"`
+}
+
+func (c Client) CreateScheduledRun(job, instance string) (ScheduledRun, error) {
+	run := ScheduledRun{
+		ID:        uuid.New(),
+		Job:       job,
+		Instance:  instance,
+		StartedAt: time.Now().UTC(),
+	}
+	query := `
+	INSERT INTO scheduled_runs (id, job, instance, started_at)
+	VALUES (?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, run.ID, run.Job, run.Instance, run.StartedAt)
+	if err != nil {
+		return ScheduledRun{}, err
+	}
+	return run, nil
+}
+
+// FinishScheduledRun records the end of a run, with the error it failed
+// with if any.
+func (c Client) FinishScheduledRun(id uuid.UUID, runErr *string) error {
+	query := `
+	UPDATE scheduled_runs
+	SET finished_at = ?, error = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), runErr, id)
+	return err
+}
+
+// GetScheduledRuns returns a job's most recent runs, newest first.
+func (c Client) GetScheduledRuns(job string, limit int) ([]ScheduledRun, error) {
+	query := `
+	SELECT id, job, instance, started_at, finished_at, error
+	FROM scheduled_runs
+	WHERE job = ?
+	ORDER BY started_at DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, job, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []ScheduledRun{}
+	for rows.Next() {
+		var run ScheduledRun
+		if err := rows.Scan(&run.ID, &run.Job, &run.Instance, &run.StartedAt, &run.FinishedAt, &run.Error); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// DeleteScheduledRunsBefore forgets runs that started before the cutoff.
+func (c Client) DeleteScheduledRunsBefore(before time.Time) error {
+	_, err := c.db.Exec("DELETE FROM scheduled_runs WHERE started_at < ?", before.UTC())
+	return err
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Share is a tokenized public link to a video. Anyone with the token can
+// view the video until the link expires or runs out of views.
+type Share struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Views     int64     `json:"views"`
+	CreateShareParams
+}
+
+type CreateShareParams struct {
+	VideoID   uuid.UUID `json:"video_id"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// MaxViews limits how many times the link can be opened; nil is
+	// unlimited.
+	MaxViews *int64 `json:"max_views"`
+	// PasswordHash is the bcrypt hash of the link's password, if it has one.
+	PasswordHash *string `json:"-"`
+}
+
+const shareColumns = `
+		id,
+		created_at,
+		video_id,
+		token,
+		expires_at,
+		max_views,
+		views,
+		password_hash`
+
+func (c Client) CreateShare(params CreateShareParams) (Share, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO shares (
+		id,
+		created_at,
+		video_id,
+		token,
+		expires_at,
+		max_views,
+		password_hash
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.VideoID, params.Token, params.ExpiresAt.UTC(), params.MaxViews, params.PasswordHash)
+	if err != nil {
+		return Share{}, err
+	}
+
+	return c.GetShare(id)
+}
+
+func (c Client) GetShare(id uuid.UUID) (Share, error) {
+	query := `
+	SELECT` + shareColumns + `
+	FROM shares
+	WHERE id = ?
+	`
+	share, err := scanShare(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Share{}, nil
+		}
+		return Share{}, err
+	}
+	return share, nil
+}
+
+func (c Client) GetShareByToken(token string) (Share, error) {
+	query := `
+	SELECT` + shareColumns + `
+	FROM shares
+	WHERE token = ?
+	`
+	share, err := scanShare(c.db.QueryRow(query, token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Share{}, nil
+		}
+		return Share{}, err
+	}
+	return share, nil
+}
+
+func (c Client) GetShares(videoID uuid.UUID) ([]Share, error) {
+	query := `
+	SELECT` + shareColumns + `
+	FROM shares
+	WHERE video_id = ?
+	ORDER BY created_at DESC
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shares := []Share{}
+	for rows.Next() {
+		share, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// GetActiveShareCounts returns how many links that still work each video
+// has. Videos without any are left out.
+func (c Client) GetActiveShareCounts(now time.Time) (map[uuid.UUID]int64, error) {
+	query := `
+	SELECT video_id, COUNT(*)
+	FROM shares
+	WHERE expires_at > ? AND (max_views IS NULL OR views < max_views)
+	GROUP BY video_id
+	`
+	rows, err := c.db.Query(query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[uuid.UUID]int64{}
+	for rows.Next() {
+		var id uuid.UUID
+		var count int64
+		if err := rows.Scan(&id, &count); err != nil {
+			return nil, err
+		}
+		counts[id] = count
+	}
+	return counts, rows.Err()
+}
+
+// RecordShareView counts a view of the share if it hasn't expired or used up
+// its views, reporting whether it did. Checking and counting happen in one
+// statement so concurrent requests can't go over the limit.
+func (c Client) RecordShareView(id uuid.UUID, now time.Time) (bool, error) {
+	query := `
+	UPDATE shares
+	SET views = views + 1
+	WHERE id = ? AND expires_at > ? AND (max_views IS NULL OR views < max_views)
+	`
+	result, err := c.db.Exec(query, id, now.UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (c Client) DeleteShare(id uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM shares WHERE id = ?", id)
+	return err
+}
+
+func scanShare(row rowScanner) (Share, error) {
+	var share Share
+	err := row.Scan(
+		&share.ID,
+		&share.CreatedAt,
+		&share.VideoID,
+		&share.Token,
+		&share.ExpiresAt,
+		&share.MaxViews,
+		&share.Views,
+		&share.PasswordHash,
+	)
+	return share, err
+}
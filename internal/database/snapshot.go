@@ -0,0 +1,11 @@
+package database
+
+import "context"
+
+// Snapshot writes a consistent copy of the database to path, which must not
+// exist yet. It reads alongside writes rather than blocking them, so it
+// runs without the query timeout, bounded by ctx instead.
+func (c Client) Snapshot(ctx context.Context, path string) error {
+	_, err := c.db.DB.ExecContext(ctx, "VACUUM INTO ?", path)
+	return err
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StorageProblem says how a stored object disagrees with its video record.
+type StorageProblem string
+
+const (
+	// StorageProblemMissing objects are recorded on a video but not in S3.
+	StorageProblemMissing StorageProblem = "missing"
+	// StorageProblemSizeMismatch objects exist but aren't the recorded size.
+	StorageProblemSizeMismatch StorageProblem = "size_mismatch"
+	// StorageProblemChecksumMismatch objects exist but S3 holds a different
+	// checksum for them than the one recorded.
+	StorageProblemChecksumMismatch StorageProblem = "checksum_mismatch"
+	// StorageProblemUnencrypted objects match their record but aren't
+	// encrypted at rest.
+	StorageProblemUnencrypted StorageProblem = "unencrypted"
+	// StorageProblemCheckFailed objects couldn't be checked at all.
+	StorageProblemCheckFailed StorageProblem = "check_failed"
+)
+
+// StorageAuditFinding is one object found by the storage audit not to match
+// what a video record says about it. Field names the video field holding the
+// location.
+type StorageAuditFinding struct {
+	ID           uuid.UUID      `json:"id"`
+	CheckedAt    time.Time      `json:"checked_at"`
+	VideoID      uuid.UUID      `json:"video_id"`
+	Field        string         `json:"field"`
+	Location     string         `json:"location"`
+	Problem      StorageProblem `json:"problem"`
+	RecordedSize *int64         `json:"recorded_size"`
+	ActualSize   *int64         `json:"actual_size"`
+	Detail       *string        `json:"detail"`
+}
+
+// ReplaceStorageAuditFindings swaps the findings of the previous audit for
+// those of the latest one.
+func (c Client) ReplaceStorageAuditFindings(findings []StorageAuditFinding) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM storage_audit_findings")
+	if err != nil {
+		return err
+	}
+	query := `
+	INSERT INTO storage_audit_findings (
+		id,
+		checked_at,
+		video_id,
+		field,
+		location,
+		problem,
+		recorded_size,
+		actual_size,
+		detail
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	for _, finding := range findings {
+		_, err = tx.Exec(query,
+			uuid.New(),
+			finding.CheckedAt.UTC(),
+			finding.VideoID,
+			finding.Field,
+			finding.Location,
+			finding.Problem,
+			finding.RecordedSize,
+			finding.ActualSize,
+			finding.Detail,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetStorageAuditFindings returns the findings of the latest audit, by video.
+func (c Client) GetStorageAuditFindings() ([]StorageAuditFinding, error) {
+	query := `
+	SELECT id, checked_at, video_id, field, location, problem, recorded_size, actual_size, detail
+	FROM storage_audit_findings
+	ORDER BY video_id, field
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	findings := []StorageAuditFinding{}
+	for rows.Next() {
+		var finding StorageAuditFinding
+		err := rows.Scan(
+			&finding.ID,
+			&finding.CheckedAt,
+			&finding.VideoID,
+			&finding.Field,
+			&finding.Location,
+			&finding.Problem,
+			&finding.RecordedSize,
+			&finding.ActualSize,
+			&finding.Detail,
+		)
+		if err != nil {
+			return nil, err
+		}
+		findings = append(findings, finding)
+	}
+	return findings, rows.Err()
+}
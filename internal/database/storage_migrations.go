@@ -0,0 +1,233 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Storage migration states. Pending migrations are picked up again after a
+// restart.
+const (
+	StorageMigrationStatusPending   = "pending"
+	StorageMigrationStatusCompleted = "completed"
+	StorageMigrationStatusFailed    = "failed"
+)
+
+// StorageMigration is a request to move the S3 objects of matching videos
+// to another storage class, with its progress.
+type StorageMigration struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Status    string    `json:"status"`
+	CreateStorageMigrationParams
+	// Objects is how many objects matched when the migration started.
+	Objects  int64   `json:"objects"`
+	Migrated int64   `json:"migrated"`
+	Failed   int64   `json:"failed"`
+	Error    *string `json:"error"`
+}
+
+type CreateStorageMigrationParams struct {
+	// StorageClass is the S3 storage class objects are moved to.
+	StorageClass string `json:"storage_class"`
+	// Only objects of videos created before CreatedBefore are moved, and
+	// only those of UserID's videos if it's set.
+	CreatedBefore time.Time  `json:"created_before"`
+	UserID        *uuid.UUID `json:"user_id"`
+	// ObjectClass limits the migration to originals, renditions or
+	// thumbnails if it's set.
+	ObjectClass string `json:"object_class"`
+}
+
+const storageMigrationColumns = `
+		id,
+		created_at,
+		updated_at,
+		status,
+		storage_class,
+		created_before,
+		user_id,
+		object_class,
+		objects,
+		migrated,
+		failed,
+		error`
+
+func (c Client) CreateStorageMigration(params CreateStorageMigrationParams) (StorageMigration, error) {
+	id := uuid.New()
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO storage_migrations (` + storageMigrationColumns + `
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, 0, 0, 0, NULL)
+	`
+	_, err := c.db.Exec(query, id, now, now, StorageMigrationStatusPending, params.StorageClass,
+		params.CreatedBefore.UTC(), params.UserID, params.ObjectClass)
+	if err != nil {
+		return StorageMigration{}, err
+	}
+	return c.GetStorageMigration(id)
+}
+
+// GetStorageMigration returns a zero StorageMigration if there's no such
+// migration.
+func (c Client) GetStorageMigration(id uuid.UUID) (StorageMigration, error) {
+	query := `
+	SELECT` + storageMigrationColumns + `
+	FROM storage_migrations
+	WHERE id = ?
+	`
+	migration, err := scanStorageMigration(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return StorageMigration{}, nil
+	}
+	return migration, err
+}
+
+// GetStorageMigrations returns the most recent migrations, newest first.
+func (c Client) GetStorageMigrations(limit int) ([]StorageMigration, error) {
+	query := `
+	SELECT` + storageMigrationColumns + `
+	FROM storage_migrations
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+	return c.queryStorageMigrations(query, limit)
+}
+
+// GetPendingStorageMigrations returns the migrations that haven't finished,
+// oldest first.
+func (c Client) GetPendingStorageMigrations() ([]StorageMigration, error) {
+	query := `
+	SELECT` + storageMigrationColumns + `
+	FROM storage_migrations
+	WHERE status = ?
+	ORDER BY created_at ASC
+	`
+	return c.queryStorageMigrations(query, StorageMigrationStatusPending)
+}
+
+func (c Client) queryStorageMigrations(query string, args ...any) ([]StorageMigration, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	migrations := []StorageMigration{}
+	for rows.Next() {
+		migration, err := scanStorageMigration(rows)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration)
+	}
+	return migrations, rows.Err()
+}
+
+// StartStorageMigration records how many objects a migration has to move.
+func (c Client) StartStorageMigration(id uuid.UUID, objects int64) error {
+	_, err := c.db.Exec("UPDATE storage_migrations SET objects = ?, updated_at = ? WHERE id = ?",
+		objects, time.Now().UTC(), id)
+	return err
+}
+
+// RecordStorageMigrationProgress adds to a migration's counts of moved and
+// failed objects.
+func (c Client) RecordStorageMigrationProgress(id uuid.UUID, migrated, failed int64) error {
+	_, err := c.db.Exec("UPDATE storage_migrations SET migrated = migrated + ?, failed = failed + ?, updated_at = ? WHERE id = ?",
+		migrated, failed, time.Now().UTC(), id)
+	return err
+}
+
+// CompleteStorageMigration marks a pending migration as done.
+func (c Client) CompleteStorageMigration(id uuid.UUID) error {
+	_, err := c.db.Exec("UPDATE storage_migrations SET status = ?, updated_at = ? WHERE id = ? AND status = ?",
+		StorageMigrationStatusCompleted, time.Now().UTC(), id, StorageMigrationStatusPending)
+	return err
+}
+
+// FailStorageMigration records why a pending migration stopped.
+func (c Client) FailStorageMigration(id uuid.UUID, reason string) error {
+	_, err := c.db.Exec("UPDATE storage_migrations SET status = ?, error = ?, updated_at = ? WHERE id = ? AND status = ?",
+		StorageMigrationStatusFailed, reason, time.Now().UTC(), id, StorageMigrationStatusPending)
+	return err
+}
+
+func scanStorageMigration(row rowScanner) (StorageMigration, error) {
+	var migration StorageMigration
+	err := row.Scan(
+		&migration.ID,
+		&migration.CreatedAt,
+		&migration.UpdatedAt,
+		&migration.Status,
+		&migration.StorageClass,
+		&migration.CreatedBefore,
+		&migration.UserID,
+		&migration.ObjectClass,
+		&migration.Objects,
+		&migration.Migrated,
+		&migration.Failed,
+		&migration.Error,
+	)
+	return migration, err
+}
+
+// storageMigrationConditions matches the sized S3 assets a migration still
+// has to move: objects not already in its storage class, of videos that
+// aren't under legal hold.
+func storageMigrationConditions(params CreateStorageMigrationParams, kinds []AssetKind) (string, []any) {
+	conditions := []string{
+		"a.storage = ?",
+		"a.size IS NOT NULL",
+		"a.storage_class <> ?",
+		"v.created_at < ?",
+		"v.legal_hold = 0",
+	}
+	args := []any{AssetStorageS3, params.StorageClass, params.CreatedBefore.UTC()}
+	if params.UserID != nil {
+		conditions = append(conditions, "v.user_id = ?")
+		args = append(args, *params.UserID)
+	}
+	if len(kinds) > 0 {
+		conditions = append(conditions, "a.kind IN (?"+strings.Repeat(", ?", len(kinds)-1)+")")
+		for _, kind := range kinds {
+			args = append(args, kind)
+		}
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// CountStorageMigrationObjects counts the objects a migration still has to
+// move. kinds limits it to assets of those kinds if it isn't empty.
+func (c Client) CountStorageMigrationObjects(params CreateStorageMigrationParams, kinds []AssetKind) (int64, error) {
+	conditions, args := storageMigrationConditions(params, kinds)
+	query := `
+	SELECT COUNT(DISTINCT a.location)
+	FROM assets a
+	JOIN videos v ON v.id = a.video_id
+	WHERE ` + conditions
+	var count int64
+	err := c.db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+// GetStorageMigrationAssets returns up to limit assets a migration still
+// has to move, in ID order starting after afterID. Assets sharing an object
+// drop out once SetAssetStorageClass has recorded its move.
+func (c Client) GetStorageMigrationAssets(params CreateStorageMigrationParams, kinds []AssetKind, afterID uuid.UUID, limit int) ([]Asset, error) {
+	conditions, args := storageMigrationConditions(params, kinds)
+	query := `
+	SELECT a.id, a.created_at, a.video_id, a.kind, a.storage, a.location, a.size, a.storage_class
+	FROM assets a
+	JOIN videos v ON v.id = a.video_id
+	WHERE ` + conditions + ` AND a.id > ?
+	ORDER BY a.id
+	LIMIT ?
+	`
+	return c.queryAssets(query, append(args, afterID, limit)...)
+}
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// GetUserStorageUsed adds up the recorded sizes of the processed videos and
+// thumbnails a user owns, in bytes. Originals have no recorded size and
+// aren't counted.
+func (c Client) GetUserStorageUsed(userID uuid.UUID) (int64, error) {
+	query := `
+	SELECT COALESCE(SUM(COALESCE(video_size, 0) + COALESCE(thumbnail_size, 0)), 0)
+	FROM videos
+	WHERE user_id = ?
+	`
+	var used int64
+	err := c.db.QueryRow(query, userID).Scan(&used)
+	return used, err
+}
+
+// SetStorageAlertPercent records the highest storage alert threshold a
+// user's usage has reached, or 0 for none. It reports whether the
+// threshold went up, so that only one caller alerts for each crossing.
+func (c Client) SetStorageAlertPercent(userID uuid.UUID, percent int) (bool, error) {
+	result, err := c.db.Exec("UPDATE users SET storage_alert_percent = ? WHERE id = ? AND storage_alert_percent < ?",
+		percent, userID, percent)
+	if err != nil {
+		return false, err
+	}
+	raised, err := result.RowsAffected()
+	if err != nil || raised > 0 {
+		return raised > 0, err
+	}
+	_, err = c.db.Exec("UPDATE users SET storage_alert_percent = ? WHERE id = ? AND storage_alert_percent > ?",
+		percent, userID, percent)
+	return false, err
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Takedown states. A filed takedown waits for an admin, who either blocks
+// the video or rejects the claim. The owner can dispute a block, and an
+// admin then reinstates the video or upholds the block for good.
+const (
+	TakedownStatusFiled      = "filed"
+	TakedownStatusBlocked    = "blocked"
+	TakedownStatusRejected   = "rejected"
+	TakedownStatusDisputed   = "disputed"
+	TakedownStatusReinstated = "reinstated"
+	TakedownStatusUpheld     = "upheld"
+)
+
+// blockingTakedownStatuses are the states that keep a video blocked.
+var blockingTakedownStatuses = []any{TakedownStatusBlocked, TakedownStatusDisputed, TakedownStatusUpheld}
+
+// Takedown is a claim that a video shouldn't be available, and where its
+// review stands.
+type Takedown struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Status    string    `json:"status"`
+	// DisputeReason is the owner's answer to a block.
+	DisputeReason *string `json:"dispute_reason"`
+	// ResolutionNote is the latest note an admin gave with a decision.
+	ResolutionNote *string `json:"resolution_note"`
+	CreateTakedownParams
+}
+
+type CreateTakedownParams struct {
+	VideoID        uuid.UUID `json:"video_id"`
+	ClaimantUserID uuid.UUID `json:"claimant_user_id"`
+	Reason         string    `json:"reason"`
+}
+
+const takedownColumns = `
+		id,
+		created_at,
+		updated_at,
+		status,
+		dispute_reason,
+		resolution_note,
+		video_id,
+		claimant_user_id,
+		reason`
+
+func (c Client) CreateTakedown(params CreateTakedownParams) (Takedown, error) {
+	id := uuid.New()
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO takedowns (` + takedownColumns + `
+	) VALUES (?, ?, ?, ?, NULL, NULL, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, now, now, TakedownStatusFiled, params.VideoID, params.ClaimantUserID, params.Reason)
+	if err != nil {
+		return Takedown{}, err
+	}
+	return c.GetTakedown(id)
+}
+
+// GetTakedown returns a zero Takedown if there's no such takedown.
+func (c Client) GetTakedown(id uuid.UUID) (Takedown, error) {
+	query := `
+	SELECT` + takedownColumns + `
+	FROM takedowns
+	WHERE id = ?
+	`
+	takedown, err := scanTakedown(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Takedown{}, nil
+	}
+	return takedown, err
+}
+
+// GetTakedowns returns takedowns in a state, or all of them for an empty
+// status, oldest first so the review queue is worked in order.
+func (c Client) GetTakedowns(status string) ([]Takedown, error) {
+	query := `
+	SELECT` + takedownColumns + `
+	FROM takedowns
+	WHERE ? = '' OR status = ?
+	ORDER BY created_at ASC
+	`
+	return c.queryTakedowns(query, status, status)
+}
+
+// GetTakedownsForVideo returns every takedown filed against a video, oldest
+// first.
+func (c Client) GetTakedownsForVideo(videoID uuid.UUID) ([]Takedown, error) {
+	query := `
+	SELECT` + takedownColumns + `
+	FROM takedowns
+	WHERE video_id = ?
+	ORDER BY created_at ASC
+	`
+	return c.queryTakedowns(query, videoID)
+}
+
+// HasOpenTakedown reports whether a claimant already has a takedown against
+// the video that hasn't been rejected or settled by reinstatement.
+func (c Client) HasOpenTakedown(videoID, claimantUserID uuid.UUID) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM takedowns
+		WHERE video_id = ? AND claimant_user_id = ? AND status NOT IN (?, ?)
+	)
+	`
+	var exists bool
+	err := c.db.QueryRow(query, videoID, claimantUserID, TakedownStatusRejected, TakedownStatusReinstated).Scan(&exists)
+	return exists, err
+}
+
+// UpdateTakedownStatus moves a takedown from one state to another, with the
+// dispute reason or admin note that came with the move, and updates whether
+// its video is blocked to match. It returns false if the takedown wasn't in
+// the expected state.
+func (c Client) UpdateTakedownStatus(id uuid.UUID, from, to string, disputeReason, resolutionNote *string) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE takedowns
+	SET status = ?,
+		dispute_reason = COALESCE(?, dispute_reason),
+		resolution_note = COALESCE(?, resolution_note),
+		updated_at = ?
+	WHERE id = ? AND status = ?
+	`
+	result, err := tx.Exec(query, to, disputeReason, resolutionNote, time.Now().UTC(), id, from)
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil || updated == 0 {
+		return false, err
+	}
+
+	var videoID uuid.UUID
+	err = tx.QueryRow("SELECT video_id FROM takedowns WHERE id = ?", id).Scan(&videoID)
+	if err != nil {
+		return false, err
+	}
+	// A video stays blocked while any of its takedowns says so
+	query = `
+	UPDATE videos
+	SET blocked = EXISTS (
+		SELECT 1 FROM takedowns WHERE video_id = videos.id AND status IN (?, ?, ?)
+	), version = version + 1
+	WHERE id = ?
+	`
+	_, err = tx.Exec(query, append(blockingTakedownStatuses, videoID)...)
+	if err != nil {
+		return false, err
+	}
+	err = tx.Commit()
+	c.forgetVideo(videoID)
+	c.videoChanged(videoID)
+	return err == nil, err
+}
+
+// DeleteFiledTakedownsForUser withdraws a user's takedowns no admin has
+// acted on yet. Decided ones are kept as the record of the decision.
+func (c Client) DeleteFiledTakedownsForUser(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM takedowns WHERE claimant_user_id = ? AND status = ?", userID, TakedownStatusFiled)
+	return err
+}
+
+func (c Client) queryTakedowns(query string, args ...any) ([]Takedown, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	takedowns := []Takedown{}
+	for rows.Next() {
+		takedown, err := scanTakedown(rows)
+		if err != nil {
+			return nil, err
+		}
+		takedowns = append(takedowns, takedown)
+	}
+	return takedowns, rows.Err()
+}
+
+func scanTakedown(row rowScanner) (Takedown, error) {
+	var takedown Takedown
+	err := row.Scan(
+		&takedown.ID,
+		&takedown.CreatedAt,
+		&takedown.UpdatedAt,
+		&takedown.Status,
+		&takedown.DisputeReason,
+		&takedown.ResolutionNote,
+		&takedown.VideoID,
+		&takedown.ClaimantUserID,
+		&takedown.Reason,
+	)
+	return takedown, err
+}
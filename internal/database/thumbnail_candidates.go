@@ -0,0 +1,128 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ThumbnailCandidate is a frame extracted during processing that the owner
+// can pick as the video's thumbnail.
+type ThumbnailCandidate struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateThumbnailCandidateParams
+}
+
+type CreateThumbnailCandidateParams struct {
+	VideoID uuid.UUID `json:"video_id"`
+	// Filename is relative to the assets root.
+	Filename string        `json:"-"`
+	Offset   time.Duration `json:"-"`
+	// Flagged candidates were caught by the image classifier and need
+	// review before they can be published.
+	Flagged bool `json:"flagged"`
+}
+
+func (c Client) CreateThumbnailCandidate(params CreateThumbnailCandidateParams) (ThumbnailCandidate, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO thumbnail_candidates (
+		id,
+		created_at,
+		video_id,
+		filename,
+		offset_ms,
+		flagged
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.VideoID, params.Filename, params.Offset.Milliseconds(), params.Flagged)
+	if err != nil {
+		return ThumbnailCandidate{}, err
+	}
+
+	return c.GetThumbnailCandidate(id)
+}
+
+func (c Client) GetThumbnailCandidate(id uuid.UUID) (ThumbnailCandidate, error) {
+	query := `
+	SELECT id, created_at, video_id, filename, offset_ms, flagged
+	FROM thumbnail_candidates
+	WHERE id = ?
+	`
+	candidate, err := scanThumbnailCandidate(c.db.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ThumbnailCandidate{}, nil
+		}
+		return ThumbnailCandidate{}, err
+	}
+	return candidate, nil
+}
+
+func (c Client) GetThumbnailCandidates(videoID uuid.UUID) ([]ThumbnailCandidate, error) {
+	query := `
+	SELECT id, created_at, video_id, filename, offset_ms, flagged
+	FROM thumbnail_candidates
+	WHERE video_id = ?
+	ORDER BY created_at DESC, offset_ms ASC
+	`
+	return c.queryThumbnailCandidates(query, videoID)
+}
+
+// GetExpiredThumbnailCandidates returns candidates created before the cutoff
+// that aren't the current thumbnail of a video, including those whose video
+// has since been deleted.
+func (c Client) GetExpiredThumbnailCandidates(createdBefore time.Time, thumbnailURLPrefix string) ([]ThumbnailCandidate, error) {
+	query := `
+	SELECT tc.id, tc.created_at, tc.video_id, tc.filename, tc.offset_ms, tc.flagged
+	FROM thumbnail_candidates tc
+	LEFT JOIN videos v ON v.id = tc.video_id
+	WHERE tc.created_at < ?
+	AND (v.id IS NULL OR v.thumbnail_url IS NULL OR v.thumbnail_url <> ? || tc.filename)
+	`
+	return c.queryThumbnailCandidates(query, createdBefore.UTC(), thumbnailURLPrefix)
+}
+
+func (c Client) DeleteThumbnailCandidate(id uuid.UUID) error {
+	query := `
+	DELETE FROM thumbnail_candidates
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
+func (c Client) queryThumbnailCandidates(query string, args ...any) ([]ThumbnailCandidate, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []ThumbnailCandidate{}
+	for rows.Next() {
+		candidate, err := scanThumbnailCandidate(rows)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
+
+func scanThumbnailCandidate(row rowScanner) (ThumbnailCandidate, error) {
+	var candidate ThumbnailCandidate
+	var offsetMs int64
+	err := row.Scan(
+		&candidate.ID,
+		&candidate.CreatedAt,
+		&candidate.VideoID,
+		&candidate.Filename,
+		&offsetMs,
+		&candidate.Flagged,
+	)
+	candidate.Offset = time.Duration(offsetMs) * time.Millisecond
+	return candidate, err
+}
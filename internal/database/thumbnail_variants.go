@@ -0,0 +1,131 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ThumbnailVariant is one of several thumbnails tried against each other
+// for a video. Impressions and Clicks count how often it was shown and
+// clicked through.
+type ThumbnailVariant struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Impressions int64     `json:"impressions"`
+	Clicks      int64     `json:"clicks"`
+	CreateThumbnailVariantParams
+}
+
+type CreateThumbnailVariantParams struct {
+	VideoID uuid.UUID `json:"video_id"`
+	// ThumbnailURL is a local asset URL or an S3 "bucket,key" location.
+	ThumbnailURL string `json:"thumbnail_url"`
+	// Weight is the variant's relative share of impressions when variants
+	// are picked by weight.
+	Weight int `json:"weight"`
+}
+
+const thumbnailVariantColumns = `
+		id,
+		created_at,
+		video_id,
+		thumbnail_url,
+		weight,
+		impressions,
+		clicks`
+
+func (c Client) CreateThumbnailVariant(params CreateThumbnailVariantParams) (ThumbnailVariant, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO thumbnail_variants (
+		id,
+		created_at,
+		video_id,
+		thumbnail_url,
+		weight
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.VideoID, params.ThumbnailURL, params.Weight)
+	if err != nil {
+		return ThumbnailVariant{}, err
+	}
+
+	return c.GetThumbnailVariant(id)
+}
+
+func (c Client) GetThumbnailVariant(id uuid.UUID) (ThumbnailVariant, error) {
+	query := `
+	SELECT` + thumbnailVariantColumns + `
+	FROM thumbnail_variants
+	WHERE id = ?
+	`
+	variant, err := scanThumbnailVariant(c.db.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ThumbnailVariant{}, nil
+		}
+		return ThumbnailVariant{}, err
+	}
+	return variant, nil
+}
+
+func (c Client) GetThumbnailVariants(videoID uuid.UUID) ([]ThumbnailVariant, error) {
+	query := `
+	SELECT` + thumbnailVariantColumns + `
+	FROM thumbnail_variants
+	WHERE video_id = ?
+	ORDER BY created_at ASC
+	`
+	rows, err := c.db.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := []ThumbnailVariant{}
+	for rows.Next() {
+		variant, err := scanThumbnailVariant(rows)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, variant)
+	}
+	return variants, rows.Err()
+}
+
+// RecordThumbnailImpression counts one showing of a variant.
+func (c Client) RecordThumbnailImpression(id uuid.UUID) error {
+	_, err := c.db.Exec("UPDATE thumbnail_variants SET impressions = impressions + 1 WHERE id = ?", id)
+	return err
+}
+
+// RecordThumbnailClick counts one click through a variant.
+func (c Client) RecordThumbnailClick(id uuid.UUID) error {
+	_, err := c.db.Exec("UPDATE thumbnail_variants SET clicks = clicks + 1 WHERE id = ?", id)
+	return err
+}
+
+func (c Client) DeleteThumbnailVariant(id uuid.UUID) error {
+	query := `
+	DELETE FROM thumbnail_variants
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
+
+func scanThumbnailVariant(row rowScanner) (ThumbnailVariant, error) {
+	var variant ThumbnailVariant
+	err := row.Scan(
+		&variant.ID,
+		&variant.CreatedAt,
+		&variant.VideoID,
+		&variant.ThumbnailURL,
+		&variant.Weight,
+		&variant.Impressions,
+		&variant.Clicks,
+	)
+	return variant, err
+}
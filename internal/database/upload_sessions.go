@@ -0,0 +1,300 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Upload session states. Only open sessions accept data.
+const (
+	UploadSessionStatusOpen      = "open"
+	UploadSessionStatusFinalized = "finalized"
+	UploadSessionStatusAborted   = "aborted"
+	UploadSessionStatusExpired   = "expired"
+)
+
+// UploadSession is an upload declared up front and sent in one or more
+// parts. Nothing changes on the video until it's finalized.
+type UploadSession struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UserID    uuid.UUID `json:"user_id"`
+	// VideoID is the video the upload is for. When CreateVideo is set it's
+	// reserved for a video created on finalizing, from Title, Description
+	// and OrganizationID.
+	VideoID        uuid.UUID      `json:"video_id"`
+	CreateVideo    bool           `json:"create_video"`
+	Title          string         `json:"title,omitempty"`
+	Description    string         `json:"description,omitempty"`
+	OrganizationID *uuid.UUID     `json:"organization_id,omitempty"`
+	Size           int64          `json:"size"`
+	MediaType      string         `json:"media_type"`
+	ChecksumSHA256 string         `json:"checksum_sha256,omitempty"`
+	AudioTracks    TrackSelection `json:"audio_tracks,omitempty"`
+	Status         string         `json:"status"`
+	Received       int64          `json:"received"`
+	Parts          UploadParts    `json:"parts"`
+	// JobID is the processing job started by finalizing.
+	JobID *uuid.UUID `json:"job_id"`
+
+	// The S3 multipart upload the parts are stored in
+	UploadBucket string `json:"-"`
+	UploadKey    string `json:"-"`
+	UploadID     string `json:"-"`
+	// ChecksumAlgorithm is the S3 checksum the upload was started with, or
+	// empty for none.
+	ChecksumAlgorithm string `json:"-"`
+	// HashState is the SHA-256 of the parts received so far, carried from
+	// one part to the next.
+	HashState []byte `json:"-"`
+}
+
+// UploadPart is a part of an upload session stored in S3.
+type UploadPart struct {
+	Number int32  `json:"number"`
+	Size   int64  `json:"size"`
+	ETag   string `json:"etag"`
+	// Checksum is the part's checksum computed by S3 with the session's
+	// ChecksumAlgorithm.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// UploadParts is stored as a JSON array in a single column.
+type UploadParts []UploadPart
+
+func (p UploadParts) Value() (driver.Value, error) {
+	if p == nil {
+		p = UploadParts{}
+	}
+	dat, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (p *UploadParts) Scan(src any) error {
+	*p = UploadParts{}
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	case []byte:
+		return json.Unmarshal(v, p)
+	default:
+		return fmt.Errorf("unsupported type for upload parts: %T", src)
+	}
+}
+
+type CreateUploadSessionParams struct {
+	UserID         uuid.UUID
+	VideoID        uuid.UUID
+	CreateVideo    bool
+	Title          string
+	Description    string
+	OrganizationID *uuid.UUID
+	Size           int64
+	MediaType      string
+	ChecksumSHA256 string
+	AudioTracks    TrackSelection
+	ExpiresAt      time.Time
+	UploadBucket   string
+	UploadKey      string
+	UploadID       string
+	// ChecksumAlgorithm is the S3 checksum the upload was started with
+	ChecksumAlgorithm string
+}
+
+const uploadSessionColumns = `
+		id,
+		created_at,
+		updated_at,
+		expires_at,
+		user_id,
+		video_id,
+		create_video,
+		title,
+		description,
+		organization_id,
+		size,
+		media_type,
+		checksum_sha256,
+		audio_tracks,
+		status,
+		received,
+		parts,
+		job_id,
+		upload_bucket,
+		upload_key,
+		upload_id,
+		checksum_algorithm,
+		hash_state`
+
+func (c Client) CreateUploadSession(params CreateUploadSessionParams) (UploadSession, error) {
+	id := uuid.New()
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO upload_sessions (` + uploadSessionColumns + `
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, '[]', NULL, ?, ?, ?, ?, NULL)
+	`
+	_, err := c.db.Exec(query, id, now, now, params.ExpiresAt.UTC(), params.UserID, params.VideoID, params.CreateVideo,
+		params.Title, params.Description, params.OrganizationID, params.Size, params.MediaType, params.ChecksumSHA256,
+		params.AudioTracks, UploadSessionStatusOpen, params.UploadBucket, params.UploadKey, params.UploadID,
+		params.ChecksumAlgorithm)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	return c.GetUploadSession(id)
+}
+
+// GetUploadSession returns a zero UploadSession if there's no such session.
+func (c Client) GetUploadSession(id uuid.UUID) (UploadSession, error) {
+	query := `
+	SELECT` + uploadSessionColumns + `
+	FROM upload_sessions
+	WHERE id = ?
+	`
+	session, err := scanUploadSession(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return UploadSession{}, nil
+	}
+	return session, err
+}
+
+func (c Client) UpdateUploadSession(session UploadSession) error {
+	query := `
+	UPDATE upload_sessions
+	SET
+		updated_at = ?,
+		status = ?,
+		received = ?,
+		parts = ?,
+		job_id = ?,
+		upload_id = ?,
+		hash_state = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, time.Now().UTC(), session.Status, session.Received, session.Parts, session.JobID,
+		session.UploadID, session.HashState, session.ID)
+	return err
+}
+
+// GetExpiredUploadSessions returns the open sessions that expired before
+// now.
+func (c Client) GetExpiredUploadSessions(now time.Time) ([]UploadSession, error) {
+	query := `
+	SELECT` + uploadSessionColumns + `
+	FROM upload_sessions
+	WHERE status = ? AND expires_at < ?
+	ORDER BY expires_at ASC
+	`
+	return c.queryUploadSessions(query, UploadSessionStatusOpen, now.UTC())
+}
+
+// GetOpenUploadSessionsForUser returns the user's sessions that still accept
+// data.
+func (c Client) GetOpenUploadSessionsForUser(userID uuid.UUID) ([]UploadSession, error) {
+	query := `
+	SELECT` + uploadSessionColumns + `
+	FROM upload_sessions
+	WHERE user_id = ? AND status = ?
+	ORDER BY created_at ASC
+	`
+	return c.queryUploadSessions(query, userID, UploadSessionStatusOpen)
+}
+
+// DeleteUploadSessionsForUser forgets every session a user created.
+func (c Client) DeleteUploadSessionsForUser(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM upload_sessions WHERE user_id = ?", userID)
+	return err
+}
+
+// DeleteClosedUploadSessionsBefore forgets sessions that stopped accepting
+// data before before.
+func (c Client) DeleteClosedUploadSessionsBefore(before time.Time) error {
+	_, err := c.db.Exec("DELETE FROM upload_sessions WHERE status != ? AND updated_at < ?", UploadSessionStatusOpen, before.UTC())
+	return err
+}
+
+func (c Client) queryUploadSessions(query string, args ...any) ([]UploadSession, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []UploadSession{}
+	for rows.Next() {
+		session, err := scanUploadSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func scanUploadSession(row rowScanner) (UploadSession, error) {
+	var session UploadSession
+	err := row.Scan(
+		&session.ID,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+		&session.ExpiresAt,
+		&session.UserID,
+		&session.VideoID,
+		&session.CreateVideo,
+		&session.Title,
+		&session.Description,
+		&session.OrganizationID,
+		&session.Size,
+		&session.MediaType,
+		&session.ChecksumSHA256,
+		&session.AudioTracks,
+		&session.Status,
+		&session.Received,
+		&session.Parts,
+		&session.JobID,
+		&session.UploadBucket,
+		&session.UploadKey,
+		&session.UploadID,
+		&session.ChecksumAlgorithm,
+		&session.HashState,
+	)
+	return session, err
+}
+
+// GetActiveMultipartUploadIDs returns the S3 multipart uploads still in use
+// by an open upload session or an unfinished job.
+func (c Client) GetActiveMultipartUploadIDs() (map[string]bool, error) {
+	query := `
+	SELECT upload_id FROM upload_sessions WHERE status = ? AND upload_id != ''
+	UNION
+	SELECT upload_id FROM jobs WHERE status IN (?, ?) AND upload_id != ''
+	`
+	rows, err := c.db.Query(query, UploadSessionStatusOpen, JobStatusQueued, JobStatusRunning)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RecordUpload logs that a user uploaded a video, for upload quotas.
+func (c Client) RecordUpload(userID, videoID uuid.UUID) error {
+	query := `
+	INSERT INTO uploads (id, created_at, user_id, video_id)
+	VALUES (?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, uuid.New(), time.Now().UTC(), userID, videoID)
+	return err
+}
+
+// CountUploadsSince counts a user's uploads after since, also returning when
+// the oldest of them happened so callers can tell when it stops counting.
+func (c Client) CountUploadsSince(userID uuid.UUID, since time.Time) (int, *time.Time, error) {
+	query := `
+	SELECT created_at
+	FROM uploads
+	WHERE user_id = ? AND created_at > ?
+	ORDER BY created_at ASC
+	`
+	rows, err := c.db.Query(query, userID, since.UTC())
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	count := 0
+	var oldest *time.Time
+	for rows.Next() {
+		var createdAt time.Time
+		if err := rows.Scan(&createdAt); err != nil {
+			return 0, nil, err
+		}
+		if oldest == nil {
+			oldest = &createdAt
+		}
+		count++
+	}
+	return count, oldest, rows.Err()
+}
+
+// DeleteUploadsBefore forgets uploads too old to count towards any quota.
+func (c Client) DeleteUploadsBefore(before time.Time) error {
+	_, err := c.db.Exec("DELETE FROM uploads WHERE created_at < ?", before.UTC())
+	return err
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// Billable usage metrics, metered per user and UTC day.
+const (
+	// UsageMetricStorageByteDays is the most a user stored on a day, in
+	// bytes, so a month's total is in byte-days.
+	UsageMetricStorageByteDays = "storage_byte_days"
+	// UsageMetricTranscodeSeconds is the length of the videos processed.
+	UsageMetricTranscodeSeconds = "transcode_seconds"
+	// UsageMetricEgressBytes is the size of the videos presigned URLs were
+	// handed out for. Not every URL is downloaded in full, so it's an
+	// upper bound on egress.
+	UsageMetricEgressBytes = "egress_bytes"
+)
+
+// UsageRecord is an amount of one metric a user used on a day, formatted
+// like 2006-01-02.
+type UsageRecord struct {
+	UserID   uuid.UUID
+	Day      string
+	Metric   string
+	Quantity float64
+}
+
+// AddUsage adds each record to the user's total for its day and metric.
+func (c Client) AddUsage(records []UsageRecord) error {
+	return c.upsertUsage(records, "usage_daily.quantity + excluded.quantity")
+}
+
+// RecordStorageUsage keeps the most each user has stored on a day, which
+// is what storage is billed on.
+func (c Client) RecordStorageUsage(day string, bytes map[uuid.UUID]int64) error {
+	records := make([]UsageRecord, 0, len(bytes))
+	for userID, n := range bytes {
+		records = append(records, UsageRecord{UserID: userID, Day: day, Metric: UsageMetricStorageByteDays, Quantity: float64(n)})
+	}
+	return c.upsertUsage(records, "MAX(usage_daily.quantity, excluded.quantity)")
+}
+
+func (c Client) upsertUsage(records []UsageRecord, merge string) error {
+	if len(records) == 0 {
+		return nil
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+	INSERT INTO usage_daily (user_id, day, metric, quantity) VALUES (?, ?, ?, ?)
+	ON CONFLICT (user_id, day, metric) DO UPDATE SET quantity = ` + merge
+	for _, record := range records {
+		_, err := tx.Exec(query, record.UserID, record.Day, record.Metric, record.Quantity)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetStorageUsedByUser adds up, like GetUserStorageUsed, what every user
+// with videos stores.
+func (c Client) GetStorageUsedByUser() (map[uuid.UUID]int64, error) {
+	query := `
+	SELECT user_id, SUM(COALESCE(video_size, 0) + COALESCE(thumbnail_size, 0))
+	FROM videos
+	GROUP BY user_id
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	used := map[uuid.UUID]int64{}
+	for rows.Next() {
+		var userID uuid.UUID
+		var bytes int64
+		if err := rows.Scan(&userID, &bytes); err != nil {
+			return nil, err
+		}
+		used[userID] = bytes
+	}
+	return used, rows.Err()
+}
+
+// UsageTotals is a user's usage of every metric over a range of days.
+type UsageTotals struct {
+	UserID           uuid.UUID `json:"user_id"`
+	StorageByteDays  float64   `json:"storage_byte_days"`
+	TranscodeSeconds float64   `json:"transcode_seconds"`
+	EgressBytes      float64   `json:"egress_bytes"`
+}
+
+// GetUsageTotals returns up to limit users' totals for the days from first
+// to last, inclusive, in user ID order starting after afterUserID. Users
+// without usage in the range are left out.
+func (c Client) GetUsageTotals(first, last string, afterUserID uuid.UUID, limit int) ([]UsageTotals, error) {
+	query := `
+	SELECT
+		user_id,
+		SUM(CASE WHEN metric = ? THEN quantity ELSE 0 END),
+		SUM(CASE WHEN metric = ? THEN quantity ELSE 0 END),
+		SUM(CASE WHEN metric = ? THEN quantity ELSE 0 END)
+	FROM usage_daily
+	WHERE day >= ? AND day <= ? AND user_id > ?
+	GROUP BY user_id
+	ORDER BY user_id
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query,
+		UsageMetricStorageByteDays, UsageMetricTranscodeSeconds, UsageMetricEgressBytes,
+		first, last, afterUserID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := []UsageTotals{}
+	for rows.Next() {
+		var total UsageTotals
+		err := rows.Scan(&total.UserID, &total.StorageByteDays, &total.TranscodeSeconds, &total.EgressBytes)
+		if err != nil {
+			return nil, err
+		}
+		totals = append(totals, total)
+	}
+	return totals, rows.Err()
+}
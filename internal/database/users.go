@@ -12,6 +12,13 @@ type User struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// AvatarURL and BannerURL are the user's profile images, as a
+	// "bucket,key" S3 location or an asset URL
+	AvatarURL *string `json:"avatar_url"`
+	BannerURL *string `json:"banner_url"`
+	// DefaultThumbnailURL is given to the user's new videos that end up
+	// without a thumbnail of their own
+	DefaultThumbnailURL *string `json:"default_thumbnail_url"`
 	CreateUserParams
 }
 
@@ -53,13 +60,13 @@ func (c Client) GetUsers() ([]User, error) {
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, avatar_url, banner_url, default_thumbnail_url
 		FROM users
 		WHERE email = ?
 	`
 	var user User
 	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.AvatarURL, &user.BannerURL, &user.DefaultThumbnailURL)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
@@ -75,7 +82,7 @@ func (c Client) GetUserByEmail(email string) (User, error) {
 
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT u.id, u.email, u.created_at, u.updated_at, u.password, u.avatar_url, u.banner_url, u.default_thumbnail_url
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
 		WHERE rt.token = ?
@@ -83,7 +90,7 @@ func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 
 	var user User
 	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password, &user.AvatarURL, &user.BannerURL, &user.DefaultThumbnailURL)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -117,13 +124,13 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
+		SELECT id, created_at, updated_at, email, password, avatar_url, banner_url, default_thumbnail_url
 		FROM users
 		WHERE id = ?
 	`
 	var user User
 	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password, &user.AvatarURL, &user.BannerURL, &user.DefaultThumbnailURL)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
@@ -137,6 +144,32 @@ func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	return &user, nil
 }
 
+// UpdateUserProfileImages saves the user's avatar, banner and default
+// thumbnail.
+func (c Client) UpdateUserProfileImages(user User) error {
+	query := `
+		UPDATE users
+		SET avatar_url = ?, banner_url = ?, default_thumbnail_url = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.db.Exec(query, user.AvatarURL, user.BannerURL, user.DefaultThumbnailURL, user.ID.String())
+	return err
+}
+
+// CountProfileImageReferences counts the avatars, banners and default
+// thumbnails, of any user, stored at a location.
+func (c Client) CountProfileImageReferences(location string) (int, error) {
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM users WHERE avatar_url = ?) +
+			(SELECT COUNT(*) FROM users WHERE banner_url = ?) +
+			(SELECT COUNT(*) FROM users WHERE default_thumbnail_url = ?)
+	`
+	var count int
+	err := c.db.QueryRow(query, location, location, location).Scan(&count)
+	return count, err
+}
+
 func (c Client) DeleteUser(id uuid.UUID) error {
 	query := `
 		DELETE FROM users
@@ -0,0 +1,104 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RetentionPoints is how many points a retention curve has: one for every
+// twentieth of the video, from its start to its end.
+const RetentionPoints = 21
+
+// VideoAnalyticsDay is a video's playback on one UTC day, rolled up from
+// its playback events.
+type VideoAnalyticsDay struct {
+	VideoID uuid.UUID `json:"video_id"`
+	// Day is formatted like 2006-01-02.
+	Day          string  `json:"day"`
+	Views        int     `json:"views"`
+	WatchSeconds float64 `json:"watch_seconds"`
+	// Retention counts the views that got at least i twentieths of the way
+	// through the video at index i.
+	Retention []int     `json:"retention"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ReplaceVideoAnalyticsDay swaps every video's rollup for a day for the
+// given ones, so videos that no longer have events that day lose theirs.
+func (c Client) ReplaceVideoAnalyticsDay(day string, rollups []VideoAnalyticsDay) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM video_analytics_daily WHERE day = ?", day)
+	if err != nil {
+		return err
+	}
+	for _, rollup := range rollups {
+		retention, err := json.Marshal(rollup.Retention)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+		INSERT INTO video_analytics_daily (video_id, day, views, watch_seconds, retention, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		`, rollup.VideoID, day, rollup.Views, rollup.WatchSeconds, string(retention), rollup.UpdatedAt.UTC())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetVideoAnalyticsDays returns a video's rollups for the days from first
+// to last, inclusive, oldest first. Days without views have no rollup.
+func (c Client) GetVideoAnalyticsDays(videoID uuid.UUID, first, last string) ([]VideoAnalyticsDay, error) {
+	query := `
+	SELECT video_id, day, views, watch_seconds, retention, updated_at
+	FROM video_analytics_daily
+	WHERE video_id = ? AND day >= ? AND day <= ?
+	ORDER BY day
+	`
+	return c.queryVideoAnalyticsDays(query, videoID, first, last)
+}
+
+// GetVideoAnalyticsDaysAfter returns up to limit rollups of every video for
+// a day, in video ID order starting after afterVideoID.
+func (c Client) GetVideoAnalyticsDaysAfter(day string, afterVideoID uuid.UUID, limit int) ([]VideoAnalyticsDay, error) {
+	query := `
+	SELECT video_id, day, views, watch_seconds, retention, updated_at
+	FROM video_analytics_daily
+	WHERE day = ? AND video_id > ?
+	ORDER BY video_id
+	LIMIT ?
+	`
+	return c.queryVideoAnalyticsDays(query, day, afterVideoID, limit)
+}
+
+func (c Client) queryVideoAnalyticsDays(query string, args ...any) ([]VideoAnalyticsDay, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := []VideoAnalyticsDay{}
+	for rows.Next() {
+		var day VideoAnalyticsDay
+		var retention string
+		err := rows.Scan(&day.VideoID, &day.Day, &day.Views, &day.WatchSeconds, &retention, &day.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(retention), &day.Retention)
+		if err != nil {
+			return nil, err
+		}
+		days = append(days, day)
+	}
+	return days, rows.Err()
+}
@@ -0,0 +1,19 @@
+package database
+
+import "github.com/google/uuid"
+
+// WithVideoChangeHook returns a client that calls hook with the ID of each
+// video it creates, updates, deletes or blocks, once the write is done, so
+// copies of videos kept elsewhere, like a search index, can follow along.
+// Counters such as views and likes don't call it. The hook runs on the
+// writing goroutine, so it should hand the work off rather than do it.
+func (c Client) WithVideoChangeHook(hook func(id uuid.UUID)) Client {
+	c.onVideoChange = hook
+	return c
+}
+
+func (c Client) videoChanged(id uuid.UUID) {
+	if c.onVideoChange != nil {
+		c.onVideoChange(id)
+	}
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Video export states. An export's credentials are never stored, so one
+// that was pending when the server stopped is failed rather than resumed.
+const (
+	VideoExportStatusPending   = "pending"
+	VideoExportStatusCompleted = "completed"
+	VideoExportStatusFailed    = "failed"
+)
+
+// VideoExport is a request to copy a user's videos into a bucket of their
+// own, with its progress.
+type VideoExport struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Status    string    `json:"status"`
+	CreateVideoExportParams
+	// Objects is how many objects the export has to copy.
+	Objects  int64 `json:"objects"`
+	Exported int64 `json:"exported"`
+	Failed   int64 `json:"failed"`
+	Bytes    int64 `json:"bytes"`
+	// ManifestKey is where the manifest listing what was copied was
+	// written, once the export completed.
+	ManifestKey *string `json:"manifest_key"`
+	Error       *string `json:"error"`
+}
+
+type CreateVideoExportParams struct {
+	UserID uuid.UUID `json:"user_id"`
+	Bucket string    `json:"bucket"`
+	Region string    `json:"region"`
+	// Endpoint is set for S3-compatible providers other than AWS.
+	Endpoint string `json:"endpoint"`
+	// Prefix is empty or ends in a slash.
+	Prefix string `json:"prefix"`
+}
+
+const videoExportColumns = `
+		id,
+		created_at,
+		updated_at,
+		status,
+		user_id,
+		bucket,
+		region,
+		endpoint,
+		prefix,
+		objects,
+		exported,
+		failed,
+		bytes,
+		manifest_key,
+		error`
+
+func (c Client) CreateVideoExport(params CreateVideoExportParams) (VideoExport, error) {
+	id := uuid.New()
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO video_exports (` + videoExportColumns + `
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 0, 0, 0, 0, NULL, NULL)
+	`
+	_, err := c.db.Exec(query, id, now, now, VideoExportStatusPending, params.UserID, params.Bucket,
+		params.Region, params.Endpoint, params.Prefix)
+	if err != nil {
+		return VideoExport{}, err
+	}
+	return c.GetVideoExport(id)
+}
+
+// GetVideoExport returns a zero VideoExport if there's no such export.
+func (c Client) GetVideoExport(id uuid.UUID) (VideoExport, error) {
+	query := `
+	SELECT` + videoExportColumns + `
+	FROM video_exports
+	WHERE id = ?
+	`
+	export, err := scanVideoExport(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return VideoExport{}, nil
+	}
+	return export, err
+}
+
+// GetVideoExportsForUser returns a user's most recent exports, newest
+// first.
+func (c Client) GetVideoExportsForUser(userID uuid.UUID, limit int) ([]VideoExport, error) {
+	query := `
+	SELECT` + videoExportColumns + `
+	FROM video_exports
+	WHERE user_id = ?
+	ORDER BY created_at DESC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []VideoExport{}
+	for rows.Next() {
+		export, err := scanVideoExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+	return exports, rows.Err()
+}
+
+// HasPendingVideoExport reports whether one of the user's exports is still
+// running.
+func (c Client) HasPendingVideoExport(userID uuid.UUID) (bool, error) {
+	var exists bool
+	err := c.db.QueryRow("SELECT EXISTS (SELECT 1 FROM video_exports WHERE user_id = ? AND status = ?)",
+		userID, VideoExportStatusPending).Scan(&exists)
+	return exists, err
+}
+
+// StartVideoExport records how many objects an export has to copy.
+func (c Client) StartVideoExport(id uuid.UUID, objects int64) error {
+	_, err := c.db.Exec("UPDATE video_exports SET objects = ?, updated_at = ? WHERE id = ?",
+		objects, time.Now().UTC(), id)
+	return err
+}
+
+// RecordVideoExportProgress adds to an export's counts of copied and
+// failed objects and of bytes copied.
+func (c Client) RecordVideoExportProgress(id uuid.UUID, exported, failed, bytes int64) error {
+	_, err := c.db.Exec("UPDATE video_exports SET exported = exported + ?, failed = failed + ?, bytes = bytes + ?, updated_at = ? WHERE id = ?",
+		exported, failed, bytes, time.Now().UTC(), id)
+	return err
+}
+
+// CompleteVideoExport marks a pending export as done, with the key of its
+// manifest.
+func (c Client) CompleteVideoExport(id uuid.UUID, manifestKey string) error {
+	_, err := c.db.Exec("UPDATE video_exports SET status = ?, manifest_key = ?, updated_at = ? WHERE id = ? AND status = ?",
+		VideoExportStatusCompleted, manifestKey, time.Now().UTC(), id, VideoExportStatusPending)
+	return err
+}
+
+// FailVideoExport records why a pending export stopped.
+func (c Client) FailVideoExport(id uuid.UUID, reason string) error {
+	_, err := c.db.Exec("UPDATE video_exports SET status = ?, error = ?, updated_at = ? WHERE id = ? AND status = ?",
+		VideoExportStatusFailed, reason, time.Now().UTC(), id, VideoExportStatusPending)
+	return err
+}
+
+// FailPendingVideoExports fails every pending export, returning how many
+// there were.
+func (c Client) FailPendingVideoExports(reason string) (int64, error) {
+	result, err := c.db.Exec("UPDATE video_exports SET status = ?, error = ?, updated_at = ? WHERE status = ?",
+		VideoExportStatusFailed, reason, time.Now().UTC(), VideoExportStatusPending)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (c Client) DeleteVideoExportsForUser(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM video_exports WHERE user_id = ?", userID)
+	return err
+}
+
+func scanVideoExport(row rowScanner) (VideoExport, error) {
+	var export VideoExport
+	err := row.Scan(
+		&export.ID,
+		&export.CreatedAt,
+		&export.UpdatedAt,
+		&export.Status,
+		&export.UserID,
+		&export.Bucket,
+		&export.Region,
+		&export.Endpoint,
+		&export.Prefix,
+		&export.Objects,
+		&export.Exported,
+		&export.Failed,
+		&export.Bytes,
+		&export.ManifestKey,
+		&export.Error,
+	)
+	return export, err
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reasons a viewer can give when reporting a video. Copyright claims go
+// through takedowns instead.
+const (
+	ReportReasonSpam           = "spam"
+	ReportReasonHarassment     = "harassment"
+	ReportReasonHateSpeech     = "hate_speech"
+	ReportReasonViolence       = "violence"
+	ReportReasonSexualContent  = "sexual_content"
+	ReportReasonMisinformation = "misinformation"
+	ReportReasonOther          = "other"
+)
+
+var ReportReasons = []string{
+	ReportReasonSpam,
+	ReportReasonHarassment,
+	ReportReasonHateSpeech,
+	ReportReasonViolence,
+	ReportReasonSexualContent,
+	ReportReasonMisinformation,
+	ReportReasonOther,
+}
+
+// Report states. Open reports wait in the moderator queue until an admin
+// dismisses them or acts on the video.
+const (
+	ReportStatusOpen      = "open"
+	ReportStatusDismissed = "dismissed"
+	ReportStatusActioned  = "actioned"
+)
+
+// VideoReport is one viewer flagging a video for moderators.
+type VideoReport struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Status     string     `json:"status"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	CreateVideoReportParams
+}
+
+type CreateVideoReportParams struct {
+	VideoID        uuid.UUID `json:"video_id"`
+	ReporterUserID uuid.UUID `json:"reporter_user_id"`
+	Reason         string    `json:"reason"`
+	Details        string    `json:"details"`
+}
+
+// ReportedVideo sums up the open reports against one video.
+type ReportedVideo struct {
+	VideoID         uuid.UUID        `json:"video_id"`
+	OpenReports     int64            `json:"open_reports"`
+	Reasons         map[string]int64 `json:"reasons"`
+	FirstReportedAt time.Time        `json:"first_reported_at"`
+	LastReportedAt  time.Time        `json:"last_reported_at"`
+}
+
+const videoReportColumns = `
+		id,
+		created_at,
+		status,
+		resolved_at,
+		video_id,
+		reporter_user_id,
+		reason,
+		details`
+
+func (c Client) CreateVideoReport(params CreateVideoReportParams) (VideoReport, error) {
+	report := VideoReport{
+		ID:                      uuid.New(),
+		CreatedAt:               time.Now().UTC(),
+		Status:                  ReportStatusOpen,
+		CreateVideoReportParams: params,
+	}
+	query := `
+	INSERT INTO video_reports (` + videoReportColumns + `
+	) VALUES (?, ?, ?, NULL, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, report.ID, report.CreatedAt, report.Status, params.VideoID, params.ReporterUserID, params.Reason, params.Details)
+	if err != nil {
+		return VideoReport{}, err
+	}
+	return report, nil
+}
+
+// HasOpenVideoReport reports whether a user already has a report against
+// the video waiting for a moderator.
+func (c Client) HasOpenVideoReport(videoID, reporterUserID uuid.UUID) (bool, error) {
+	query := `
+	SELECT EXISTS (
+		SELECT 1 FROM video_reports
+		WHERE video_id = ? AND reporter_user_id = ? AND status = ?
+	)
+	`
+	var exists bool
+	err := c.db.QueryRow(query, videoID, reporterUserID, ReportStatusOpen).Scan(&exists)
+	return exists, err
+}
+
+// GetOpenVideoReports returns the reports against a video waiting for a
+// moderator, oldest first.
+func (c Client) GetOpenVideoReports(videoID uuid.UUID) ([]VideoReport, error) {
+	query := `
+	SELECT` + videoReportColumns + `
+	FROM video_reports
+	WHERE video_id = ? AND status = ?
+	ORDER BY created_at ASC
+	`
+	rows, err := c.db.Query(query, videoID, ReportStatusOpen)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []VideoReport{}
+	for rows.Next() {
+		report, err := scanVideoReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+	return reports, rows.Err()
+}
+
+// GetReportedVideos returns every video with open reports, most reported
+// first and then longest waiting.
+func (c Client) GetReportedVideos() ([]ReportedVideo, error) {
+	query := `
+	SELECT video_id, reason, created_at
+	FROM video_reports
+	WHERE status = ?
+	ORDER BY created_at ASC
+	`
+	rows, err := c.db.Query(query, ReportStatusOpen)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reported := []ReportedVideo{}
+	index := map[uuid.UUID]int{}
+	for rows.Next() {
+		var (
+			videoID   uuid.UUID
+			reason    string
+			createdAt time.Time
+		)
+		if err := rows.Scan(&videoID, &reason, &createdAt); err != nil {
+			return nil, err
+		}
+		i, ok := index[videoID]
+		if !ok {
+			i = len(reported)
+			index[videoID] = i
+			reported = append(reported, ReportedVideo{
+				VideoID:         videoID,
+				Reasons:         map[string]int64{},
+				FirstReportedAt: createdAt,
+			})
+		}
+		reported[i].OpenReports++
+		reported[i].Reasons[reason]++
+		reported[i].LastReportedAt = createdAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Videos were added in order of their first report, so a stable sort
+	// keeps the longest waiting first among equals
+	sort.SliceStable(reported, func(i, j int) bool {
+		return reported[i].OpenReports > reported[j].OpenReports
+	})
+	return reported, nil
+}
+
+// ResolveVideoReports closes every open report against a video with the
+// given status and returns how many there were.
+func (c Client) ResolveVideoReports(videoID uuid.UUID, status string) (int64, error) {
+	query := `
+	UPDATE video_reports
+	SET status = ?, resolved_at = ?
+	WHERE video_id = ? AND status = ?
+	`
+	result, err := c.db.Exec(query, status, time.Now().UTC(), videoID, ReportStatusOpen)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (c Client) DeleteVideoReportsForUser(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM video_reports WHERE reporter_user_id = ?", userID)
+	return err
+}
+
+func scanVideoReport(row rowScanner) (VideoReport, error) {
+	var report VideoReport
+	err := row.Scan(
+		&report.ID,
+		&report.CreatedAt,
+		&report.Status,
+		&report.ResolvedAt,
+		&report.VideoID,
+		&report.ReporterUserID,
+		&report.Reason,
+		&report.Details,
+	)
+	return report, err
+}
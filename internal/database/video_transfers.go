@@ -0,0 +1,164 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Video transfer states. Only pending transfers can be accepted.
+const (
+	VideoTransferStatusPending   = "pending"
+	VideoTransferStatusAccepted  = "accepted"
+	VideoTransferStatusDeclined  = "declined"
+	VideoTransferStatusCancelled = "cancelled"
+)
+
+// VideoTransfer is an offer to move a video to another user or organization.
+// The video only changes hands once the recipient accepts.
+type VideoTransfer struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Status    string    `json:"status"`
+	CreateVideoTransferParams
+}
+
+type CreateVideoTransferParams struct {
+	VideoID uuid.UUID `json:"video_id"`
+	// FromUserID is the owner when the transfer was offered. Accepting fails
+	// if the video has changed hands since.
+	FromUserID uuid.UUID `json:"from_user_id"`
+	// Exactly one of ToUserID and ToOrganizationID is set.
+	ToUserID         *uuid.UUID `json:"to_user_id"`
+	ToOrganizationID *uuid.UUID `json:"to_organization_id"`
+	// InitiatedBy is "user:<id>" or "admin", as on audit events.
+	InitiatedBy string `json:"initiated_by"`
+}
+
+const videoTransferColumns = `
+		id,
+		created_at,
+		updated_at,
+		status,
+		video_id,
+		from_user_id,
+		to_user_id,
+		to_organization_id,
+		initiated_by`
+
+// CreateVideoTransfer offers a video to its recipient, cancelling any offer
+// of the same video still pending.
+func (c Client) CreateVideoTransfer(params CreateVideoTransferParams) (VideoTransfer, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return VideoTransfer{}, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	_, err = tx.Exec("UPDATE video_transfers SET status = ?, updated_at = ? WHERE video_id = ? AND status = ?",
+		VideoTransferStatusCancelled, now, params.VideoID, VideoTransferStatusPending)
+	if err != nil {
+		return VideoTransfer{}, err
+	}
+
+	id := uuid.New()
+	query := `
+	INSERT INTO video_transfers (` + videoTransferColumns + `
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err = tx.Exec(query, id, now, now, VideoTransferStatusPending, params.VideoID, params.FromUserID,
+		params.ToUserID, params.ToOrganizationID, params.InitiatedBy)
+	if err != nil {
+		return VideoTransfer{}, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return VideoTransfer{}, err
+	}
+
+	return c.GetVideoTransfer(id)
+}
+
+// GetVideoTransfer returns a zero VideoTransfer if there's no such transfer.
+func (c Client) GetVideoTransfer(id uuid.UUID) (VideoTransfer, error) {
+	query := `
+	SELECT` + videoTransferColumns + `
+	FROM video_transfers
+	WHERE id = ?
+	`
+	transfer, err := scanVideoTransfer(c.db.QueryRow(query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return VideoTransfer{}, nil
+	}
+	return transfer, err
+}
+
+// GetPendingVideoTransfersForUser returns the pending transfers a user can
+// answer: those offered to them and those offered to an organization they
+// own.
+func (c Client) GetPendingVideoTransfersForUser(userID uuid.UUID) ([]VideoTransfer, error) {
+	query := `
+	SELECT` + videoTransferColumns + `
+	FROM video_transfers
+	WHERE status = ? AND (
+		to_user_id = ?
+		OR to_organization_id IN (
+			SELECT organization_id FROM organization_members WHERE user_id = ? AND role = ?
+		)
+	)
+	ORDER BY created_at ASC
+	`
+	rows, err := c.db.Query(query, VideoTransferStatusPending, userID, userID, OrganizationRoleOwner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	transfers := []VideoTransfer{}
+	for rows.Next() {
+		transfer, err := scanVideoTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, transfer)
+	}
+	return transfers, rows.Err()
+}
+
+// SetVideoTransferStatus closes a pending transfer. It returns false if the
+// transfer was no longer pending.
+func (c Client) SetVideoTransferStatus(id uuid.UUID, status string) (bool, error) {
+	result, err := c.db.Exec("UPDATE video_transfers SET status = ?, updated_at = ? WHERE id = ? AND status = ?",
+		status, time.Now().UTC(), id, VideoTransferStatusPending)
+	if err != nil {
+		return false, err
+	}
+	updated, err := result.RowsAffected()
+	return updated > 0, err
+}
+
+// DeleteVideoTransfersForUser forgets every transfer from or to a user.
+func (c Client) DeleteVideoTransfersForUser(userID uuid.UUID) error {
+	_, err := c.db.Exec("DELETE FROM video_transfers WHERE from_user_id = ? OR to_user_id = ?", userID, userID)
+	return err
+}
+
+func scanVideoTransfer(row rowScanner) (VideoTransfer, error) {
+	var transfer VideoTransfer
+	err := row.Scan(
+		&transfer.ID,
+		&transfer.CreatedAt,
+		&transfer.UpdatedAt,
+		&transfer.Status,
+		&transfer.VideoID,
+		&transfer.FromUserID,
+		&transfer.ToUserID,
+		&transfer.ToOrganizationID,
+		&transfer.InitiatedBy,
+	)
+	return transfer, err
+}
@@ -2,7 +2,11 @@ package database
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,33 +17,708 @@ type Video struct {
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	// ThumbnailOriginalSize and ThumbnailSize are the byte sizes of the
+	// uploaded thumbnail before and after optimization.
+	ThumbnailOriginalSize *int64      `json:"thumbnail_original_size"`
+	ThumbnailSize         *int64      `json:"thumbnail_size"`
+	VideoURL              *string     `json:"video_url"`
+	OriginalURL           *string     `json:"-"`
+	AudioTracks           AudioTracks `json:"audio_tracks"`
+	// ResolutionClass is one of the ResolutionClass* values once processed.
+	ResolutionClass *string `json:"resolution_class"`
+	// HDRFormat is one of the HDRFormat* values once processed.
+	HDRFormat *string `json:"hdr_format"`
+	// Width and Height are the coded dimensions of the first video stream;
+	// the sample aspect ratio says how to stretch them for display.
+	Width           *int `json:"width"`
+	Height          *int `json:"height"`
+	SampleAspectNum int  `json:"-"`
+	SampleAspectDen int  `json:"-"`
+	// AspectRatio is computed by the API layer from the stored dimensions
+	// and is never persisted.
+	AspectRatio string `json:"aspect_ratio,omitempty"`
+	// ModerationStatus is one of the ModerationStatus* values, or empty for
+	// videos processed before moderation existed.
+	ModerationStatus string           `json:"moderation_status,omitempty"`
+	ModerationLabels ModerationLabels `json:"moderation_labels,omitempty"`
+	// PendingThumbnailURL is a thumbnail held for review after being flagged.
+	// It replaces ThumbnailURL once a moderator approves it.
+	PendingThumbnailURL    *string          `json:"-"`
+	PendingThumbnailLabels ModerationLabels `json:"-"`
+	// ThumbnailPendingReview is computed by the API layer and never persisted.
+	ThumbnailPendingReview bool `json:"thumbnail_pending_review,omitempty"`
+	// ThumbnailVariantID is set when ThumbnailURL was picked from the video's
+	// thumbnail variants, so the client can report clicks on it.
+	ThumbnailVariantID *uuid.UUID `json:"thumbnail_variant_id,omitempty"`
+	// CustomMetadata holds fields teams attach for their own use, such as a
+	// campaign ID. Values are strings, numbers or booleans.
+	CustomMetadata CustomMetadata `json:"custom_metadata"`
+	// Tags are short lowercase labels for organizing a library.
+	Tags VideoTags `json:"tags"`
+	// ViewCount and LikeCount are only changed through RecordVideoView and
+	// the like methods, never by UpdateVideo, so concurrent updates can't
+	// lose counts.
+	ViewCount int64 `json:"view_count"`
+	LikeCount int64 `json:"like_count"`
+	// PendingUploadURL is the "bucket,key" a client was given a presigned
+	// upload URL for, until the upload is finalized.
+	PendingUploadURL *string `json:"-"`
+	// Visibility is one of the Visibility* values.
+	Visibility string `json:"visibility"`
+	// VideoSize is the byte size of the processed rendition and
+	// DurationSeconds its length, both recorded during processing.
+	VideoSize       *int64   `json:"video_size"`
+	DurationSeconds *float64 `json:"duration_seconds"`
+	// VideoChecksum and OriginalChecksum are the checksums S3 computed for
+	// the stored objects, as "ALGORITHM:value", so their integrity can be
+	// checked without downloading them.
+	VideoChecksum    *string `json:"video_checksum"`
+	OriginalChecksum *string `json:"-"`
+	// Version goes up by one with every change to the record, including
+	// its counts, so clients can tell whether it changed.
+	Version int64 `json:"-"`
+	// LegalHold preserves the video and its stored objects: while it's set
+	// nothing of the video can be deleted or replaced. It's only changed
+	// through SetVideoLegalHold, never by UpdateVideo, so an update can't
+	// clear it.
+	LegalHold bool `json:"legal_hold"`
+	// Blocked videos were taken down: they're left out of listings and
+	// their files aren't signed for anyone. It follows the video's
+	// takedowns and is never written by UpdateVideo.
+	Blocked bool `json:"blocked"`
+	// Provenance is set for videos imported from a partner's server.
+	Provenance *Provenance `json:"provenance,omitempty"`
 	CreateVideoParams
 }
 
+const (
+	ResolutionClassSD = "SD"
+	ResolutionClassHD = "HD"
+	ResolutionClass4K = "4K"
+)
+
+const (
+	HDRFormatSDR         = "SDR"
+	HDRFormatHDR10       = "HDR10"
+	HDRFormatHLG         = "HLG"
+	HDRFormatDolbyVision = "DolbyVision"
+)
+
+const (
+	// VisibilityUnlisted videos can be viewed by anyone with their ID but
+	// aren't listed anywhere public.
+	VisibilityUnlisted = "unlisted"
+	// VisibilityPublic videos also appear in their owner's feed.
+	VisibilityPublic = "public"
+)
+
+const (
+	ModerationStatusApproved      = "approved"
+	ModerationStatusPendingReview = "pending_review"
+	ModerationStatusRejected      = "rejected"
+)
+
+// ModerationLabel is one reason a video was flagged for review.
+type ModerationLabel struct {
+	Name       string  `json:"name"`
+	Confidence float64 `json:"confidence"`
+}
+
+// ModerationLabels is stored as a JSON array in a single column.
+type ModerationLabels []ModerationLabel
+
+func (l ModerationLabels) Value() (driver.Value, error) {
+	if l == nil {
+		return nil, nil
+	}
+	dat, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (l *ModerationLabels) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	case []byte:
+		return json.Unmarshal(v, l)
+	default:
+		return fmt.Errorf("unsupported type for moderation labels: %T", src)
+	}
+}
+
+// CustomMetadata is stored as a JSON object in a single column.
+type CustomMetadata map[string]any
+
+func (m CustomMetadata) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	dat, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (m *CustomMetadata) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	case []byte:
+		return json.Unmarshal(v, m)
+	default:
+		return fmt.Errorf("unsupported type for custom metadata: %T", src)
+	}
+}
+
+// VideoTags is stored as a JSON array in a single column.
+type VideoTags []string
+
+func (t VideoTags) Value() (driver.Value, error) {
+	if t == nil {
+		t = VideoTags{}
+	}
+	dat, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (t *VideoTags) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*t = VideoTags{}
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	case []byte:
+		return json.Unmarshal(v, t)
+	default:
+		return fmt.Errorf("unsupported type for video tags: %T", src)
+	}
+}
+
+// AudioTrack describes one audio stream found in the uploaded original.
+// Index is the position among the audio streams, as used by ffmpeg's "0:a:N".
+type AudioTrack struct {
+	Index    int    `json:"index"`
+	Codec    string `json:"codec"`
+	Channels int    `json:"channels"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Default  bool   `json:"default"`
+	Kept     bool   `json:"kept"`
+}
+
+// AudioTracks is stored as a JSON array in a single column.
+type AudioTracks []AudioTrack
+
+func (t AudioTracks) Value() (driver.Value, error) {
+	if t == nil {
+		return nil, nil
+	}
+	dat, err := json.Marshal(t)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (t *AudioTracks) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	case []byte:
+		return json.Unmarshal(v, t)
+	default:
+		return fmt.Errorf("unsupported type for audio tracks: %T", src)
+	}
+}
+
 type CreateVideoParams struct {
 	Title       string    `json:"title"`
 	Description string    `json:"description"`
 	UserID      uuid.UUID `json:"user_id"`
+	// OrganizationID is set for videos owned by an organization rather than
+	// by the user who created them.
+	OrganizationID *uuid.UUID `json:"organization_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+// VideoFilter narrows a video listing. Zero-value fields don't filter.
+type VideoFilter struct {
+	ResolutionClass string
+	HDRFormat       string
+	// HDR selects only HDR (true) or only SDR (false) videos when set.
+	HDR *bool
+	// Metadata selects videos whose custom metadata has each key set to the
+	// given value. Numbers and booleans match their JSON text.
+	Metadata map[string]string
+	// MetadataKeys selects videos whose custom metadata has every key.
+	MetadataKeys []string
+	// OrganizationID lists an organization's videos instead of the user's.
+	OrganizationID *uuid.UUID
+	// AspectRatios selects videos whose display aspect ratio (width over
+	// height, corrected for the sample aspect ratio) falls in any of the
+	// ranges. ExcludeAspectRatios selects processed videos whose ratio
+	// falls in none of them.
+	AspectRatios        []RatioRange
+	ExcludeAspectRatios []RatioRange
+	// MinDuration and MaxDuration bound the length in seconds.
+	MinDuration *float64
+	MaxDuration *float64
+	// ProcessingStatus is one of the ProcessingStatus* values.
+	ProcessingStatus string
+	Visibility       string
+	// Tag selects videos carrying the tag.
+	Tag string
+	// CreatedAfter and CreatedBefore bound created_at, inclusively.
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// ExcludeBlocked leaves out videos blocked by a takedown.
+	ExcludeBlocked bool
+	// Published selects only what anyone can watch: public, processed
+	// videos that moderation hasn't held back and no takedown blocked.
+	Published bool
+	// FollowedBy lists the videos of every creator the user follows
+	// instead of the user's own.
+	FollowedBy *uuid.UUID
+	// Sort orders the listing, newest first by default.
+	Sort      VideoSort
+	Ascending bool
+	// After continues a listing after the video it points at.
+	After *Cursor
+	// Limit caps the number of videos returned; 0 returns them all.
+	Limit int
+}
+
+// publishedCondition selects what anyone can watch: public, processed
+// videos that moderation hasn't held back and no takedown blocked. It takes
+// publishedArgs.
+const publishedCondition = `visibility = ?
+	AND video_url IS NOT NULL AND video_url <> ''
+	AND moderation_status NOT IN (?, ?)
+	AND blocked = 0`
+
+var publishedArgs = []any{VisibilityPublic, ModerationStatusPendingReview, ModerationStatusRejected}
+
+// IsPublished reports whether anyone can watch the video, by the same rules
+// as publishedCondition.
+func (v Video) IsPublished() bool {
+	return v.Visibility == VisibilityPublic &&
+		v.VideoURL != nil && *v.VideoURL != "" &&
+		v.ModerationStatus != ModerationStatusPendingReview &&
+		v.ModerationStatus != ModerationStatusRejected &&
+		!v.Blocked
+}
+
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
 		title,
 		description,
 		thumbnail_url,
+		thumbnail_original_size,
+		thumbnail_size,
 		video_url,
-		user_id
+		original_url,
+		audio_tracks,
+		resolution_class,
+		hdr_format,
+		width,
+		height,
+		sample_aspect_num,
+		sample_aspect_den,
+		moderation_status,
+		moderation_labels,
+		pending_thumbnail_url,
+		pending_thumbnail_labels,
+		custom_metadata,
+		pending_upload_url,
+		organization_id,
+		visibility,
+		video_size,
+		duration_seconds,
+		tags,
+		view_count,
+		like_count,
+		video_checksum,
+		original_checksum,
+		version,
+		legal_hold,
+		blocked,
+		provenance,
+		user_id`
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.ThumbnailOriginalSize,
+		&video.ThumbnailSize,
+		&video.VideoURL,
+		&video.OriginalURL,
+		&video.AudioTracks,
+		&video.ResolutionClass,
+		&video.HDRFormat,
+		&video.Width,
+		&video.Height,
+		&video.SampleAspectNum,
+		&video.SampleAspectDen,
+		&video.ModerationStatus,
+		&video.ModerationLabels,
+		&video.PendingThumbnailURL,
+		&video.PendingThumbnailLabels,
+		&video.CustomMetadata,
+		&video.PendingUploadURL,
+		&video.OrganizationID,
+		&video.Visibility,
+		&video.VideoSize,
+		&video.DurationSeconds,
+		&video.Tags,
+		&video.ViewCount,
+		&video.LikeCount,
+		&video.VideoChecksum,
+		&video.OriginalChecksum,
+		&video.Version,
+		&video.LegalHold,
+		&video.Blocked,
+		provenanceColumn{&video.Provenance},
+		&video.UserID,
+	)
+	return video, err
+}
+
+func (c Client) GetVideos(userID uuid.UUID, filter VideoFilter) ([]Video, error) {
+	conditions := []string{"user_id = ?"}
+	args := []any{userID}
+	if filter.OrganizationID != nil {
+		conditions = []string{"organization_id = ?"}
+		args = []any{*filter.OrganizationID}
+	}
+	if filter.FollowedBy != nil {
+		conditions = []string{"user_id IN (SELECT creator_id FROM follows WHERE follower_id = ?)"}
+		args = []any{*filter.FollowedBy}
+	}
+	if filter.ResolutionClass != "" {
+		conditions = append(conditions, "resolution_class = ?")
+		args = append(args, filter.ResolutionClass)
+	}
+	if filter.HDRFormat != "" {
+		conditions = append(conditions, "hdr_format = ?")
+		args = append(args, filter.HDRFormat)
+	}
+	if filter.HDR != nil {
+		if *filter.HDR {
+			conditions = append(conditions, "hdr_format IS NOT NULL AND hdr_format <> ?")
+		} else {
+			conditions = append(conditions, "hdr_format = ?")
+		}
+		args = append(args, HDRFormatSDR)
+	}
+	for key, value := range filter.Metadata {
+		conditions = append(conditions, `EXISTS (
+			SELECT 1 FROM json_each(custom_metadata)
+			WHERE json_each.key = ?
+			AND CASE json_each.type WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' ELSE CAST(json_each.value AS TEXT) END = ?
+		)`)
+		args = append(args, key, value)
+	}
+	for _, key := range filter.MetadataKeys {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM json_each(custom_metadata) WHERE json_each.key = ?)")
+		args = append(args, key)
+	}
+	if len(filter.AspectRatios) > 0 {
+		ranges, rangeArgs := ratioRangesCondition(filter.AspectRatios)
+		conditions = append(conditions, ranges)
+		args = append(args, rangeArgs...)
+	}
+	if len(filter.ExcludeAspectRatios) > 0 {
+		ranges, rangeArgs := ratioRangesCondition(filter.ExcludeAspectRatios)
+		conditions = append(conditions, "width > 0 AND height > 0 AND NOT "+ranges)
+		args = append(args, rangeArgs...)
+	}
+	if filter.MinDuration != nil {
+		conditions = append(conditions, "duration_seconds >= ?")
+		args = append(args, *filter.MinDuration)
+	}
+	if filter.MaxDuration != nil {
+		conditions = append(conditions, "duration_seconds <= ?")
+		args = append(args, *filter.MaxDuration)
+	}
+	if filter.ProcessingStatus != "" {
+		condition, statusArgs := processingStatusCondition(filter.ProcessingStatus)
+		conditions = append(conditions, condition)
+		args = append(args, statusArgs...)
+	}
+	if filter.Visibility != "" {
+		conditions = append(conditions, "visibility = ?")
+		args = append(args, filter.Visibility)
+	}
+	if filter.Tag != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM json_each(tags) WHERE json_each.value = ?)")
+		args = append(args, filter.Tag)
+	}
+	if filter.ExcludeBlocked {
+		conditions = append(conditions, "blocked = 0")
+	}
+	if filter.Published {
+		conditions = append(conditions, publishedCondition)
+		args = append(args, publishedArgs...)
+	}
+	if filter.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.CreatedAfter.UTC().Format(sqliteTimestamp))
+	}
+	if filter.CreatedBefore != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, filter.CreatedBefore.UTC().Format(sqliteTimestamp))
+	}
+	sortKey := filter.Sort.keySQL()
+	if filter.After != nil {
+		condition, cursorArgs := filter.After.after(sortKey, filter.Ascending)
+		conditions = append(conditions, condition)
+		args = append(args, cursorArgs...)
+	}
+	direction := "DESC"
+	if filter.Ascending {
+		direction = "ASC"
+	}
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY ` + sortKey + ` ` + direction + `, id ` + direction + `
+	`
+	if filter.Limit > 0 {
+		query += "LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return videos, nil
+}
+
+// VideoSort is the key a video listing is ordered by.
+type VideoSort string
+
+const (
+	VideoSortCreatedAt = "created_at"
+	VideoSortViews     = "views"
+	VideoSortLikes     = "likes"
+	VideoSortDuration  = "duration"
+	VideoSortSize      = "size"
+)
+
+// keySQL is the expression a sort orders by. Unprocessed videos sort as if
+// their duration and size were 0. The videos_*_sort indexes are built on
+// these exact expressions.
+func (s VideoSort) keySQL() string {
+	switch s {
+	case VideoSortViews:
+		return "view_count"
+	case VideoSortLikes:
+		return "like_count"
+	case VideoSortDuration:
+		return "COALESCE(duration_seconds, 0)"
+	case VideoSortSize:
+		return "COALESCE(video_size, 0)"
+	}
+	return "created_at"
+}
+
+// SortKey returns the video's value of the sort key, for building a cursor.
+func (v Video) SortKey(s VideoSort) any {
+	switch s {
+	case VideoSortViews:
+		return float64(v.ViewCount)
+	case VideoSortLikes:
+		return float64(v.LikeCount)
+	case VideoSortDuration:
+		if v.DurationSeconds == nil {
+			return 0.0
+		}
+		return *v.DurationSeconds
+	case VideoSortSize:
+		if v.VideoSize == nil {
+			return 0.0
+		}
+		return float64(*v.VideoSize)
+	}
+	return v.CreatedAt
+}
+
+// RecordVideoView counts one playback of a video.
+func (c Client) RecordVideoView(id uuid.UUID) error {
+	_, err := c.db.Exec("UPDATE videos SET view_count = view_count + 1, version = version + 1 WHERE id = ?", id)
+	return err
+}
+
+// RecordVideosPresigned notes when each video's URL was last presigned.
+// It's kept apart from Video and doesn't change its version, since signing
+// doesn't change the video.
+func (c Client) RecordVideosPresigned(presignedAt map[uuid.UUID]time.Time) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for id, at := range presignedAt {
+		_, err := tx.Exec("UPDATE videos SET presigned_at = ? WHERE id = ? AND (presigned_at IS NULL OR presigned_at < ?)", at, id, at)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetVideosPresignedSince returns when each video presigned since the given
+// time was last presigned.
+func (c Client) GetVideosPresignedSince(since time.Time) (map[uuid.UUID]time.Time, error) {
+	rows, err := c.db.Query("SELECT id, presigned_at FROM videos WHERE presigned_at >= ?", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	presignedAt := map[uuid.UUID]time.Time{}
+	for rows.Next() {
+		var id uuid.UUID
+		var at time.Time
+		if err := rows.Scan(&id, &at); err != nil {
+			return nil, err
+		}
+		presignedAt[id] = at
+	}
+	return presignedAt, rows.Err()
+}
+
+// RatioRange is an inclusive range of display aspect ratios.
+type RatioRange struct {
+	Min float64
+	Max float64
+}
+
+// displayRatioSQL computes a video's display aspect ratio. An unknown sample
+// aspect ratio is treated as square pixels.
+const displayRatioSQL = `(width * CASE WHEN sample_aspect_num > 0 AND sample_aspect_den > 0
+	THEN sample_aspect_num * 1.0 / sample_aspect_den ELSE 1.0 END / height)`
+
+func ratioRangesCondition(ranges []RatioRange) (string, []any) {
+	parts := make([]string, len(ranges))
+	args := []any{}
+	for i, r := range ranges {
+		parts[i] = displayRatioSQL + " BETWEEN ? AND ?"
+		args = append(args, r.Min, r.Max)
+	}
+	return "(" + strings.Join(parts, " OR ") + ")", args
+}
+
+// A video's processing status follows its latest job.
+const (
+	// ProcessingStatusDraft videos have never had anything uploaded.
+	ProcessingStatusDraft      = "draft"
+	ProcessingStatusProcessing = "processing"
+	ProcessingStatusReady      = "ready"
+	ProcessingStatusFailed     = "failed"
+)
+
+const latestJobStatusSQL = `(SELECT status FROM jobs WHERE jobs.video_id = videos.id ORDER BY created_at DESC, rowid DESC LIMIT 1)`
+
+func processingStatusCondition(status string) (string, []any) {
+	switch status {
+	case ProcessingStatusDraft:
+		return "NOT EXISTS (SELECT 1 FROM jobs WHERE jobs.video_id = videos.id)", nil
+	case ProcessingStatusProcessing:
+		return latestJobStatusSQL + " IN (?, ?)", []any{JobStatusQueued, JobStatusRunning}
+	case ProcessingStatusReady:
+		return latestJobStatusSQL + " = ?", []any{JobStatusDone}
+	case ProcessingStatusFailed:
+		return latestJobStatusSQL + " = ?", []any{JobStatusFailed}
+	}
+	return "0", nil
+}
+
+// GetFeedVideos returns a user's newest public videos that have finished
+// processing and aren't held by moderation or blocked.
+func (c Client) GetFeedVideos(userID uuid.UUID, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE user_id = ?
+	AND ` + publishedCondition + `
 	ORDER BY created_at DESC
+	LIMIT ?
 	`
+	args := append([]any{userID}, publishedArgs...)
+	rows, err := c.db.Query(query, append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-	rows, err := c.db.Query(query, userID)
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+	return videos, rows.Err()
+}
+
+// GetPublishedVideos returns up to limit published videos of every user,
+// ordered by ID and starting after the given one, for walking the whole
+// catalog a page at a time.
+func (c Client) GetPublishedVideos(after uuid.UUID, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id > ?
+	AND ` + publishedCondition + `
+	ORDER BY id
+	LIMIT ?
+	`
+	args := append([]any{after}, publishedArgs...)
+	rows, err := c.db.Query(query, append(args, limit)...)
 	if err != nil {
 		return nil, err
 	}
@@ -47,27 +726,141 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
+	return videos, rows.Err()
+}
 
-	return videos, nil
+// GetVideosByModerationStatus returns every video in a moderation state,
+// oldest first, for the review queue.
+func (c Client) GetVideosByModerationStatus(status string) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE moderation_status = ?
+	ORDER BY created_at ASC
+	`
+
+	rows, err := c.db.Query(query, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
+
+// GetVideosWithPendingThumbnail returns every video with a thumbnail held
+// for review, oldest first.
+func (c Client) GetVideosWithPendingThumbnail() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE pending_thumbnail_url IS NOT NULL
+	ORDER BY created_at ASC
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
+
+// GetVideosByIDs returns the videos with the given IDs that exist, in no
+// particular order.
+func (c Client) GetVideosByIDs(ids []uuid.UUID) ([]Video, error) {
+	if len(ids) == 0 {
+		return []Video{}, nil
+	}
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)
+	`
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
+}
+
+// GetVideosAfter returns up to limit videos with IDs after the given one,
+// in ID order, for walking every video in batches. Pass uuid.Nil to start.
+func (c Client) GetVideosAfter(after uuid.UUID, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id > ?
+	ORDER BY id ASC
+	LIMIT ?
+	`
+
+	rows, err := c.db.Query(query, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	videos := []Video{}
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, err
+		}
+		videos = append(videos, video)
+	}
+
+	return videos, rows.Err()
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
-	id := uuid.New()
+	return c.CreateVideoWithID(uuid.New(), params)
+}
+
+// CreateVideoWithID creates a video with an ID reserved ahead of creating
+// it.
+func (c Client) CreateVideoWithID(id uuid.UUID, params CreateVideoParams) (Video, error) {
 	query := `
 	INSERT INTO videos (
 		id,
@@ -75,42 +868,30 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		organization_id
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID, params.OrganizationID)
 	if err != nil {
 		return Video{}, err
 	}
+	c.videoChanged(id)
 
 	return c.GetVideo(id)
 }
 
-func (c Client) GetVideo(id uuid.UUID) (Video, error) {
-	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+const getVideoQuery = `
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+func (c Client) GetVideo(id uuid.UUID) (Video, error) {
+	if video, ok := c.cachedVideo(id); ok {
+		return video, nil
+	}
+	video, err := scanVideo(c.db.queryRowStmt(c.db.getVideo, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -118,38 +899,157 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 		return Video{}, err
 	}
 
+	c.cacheVideo(video)
 	return video, nil
 }
 
-func (c Client) UpdateVideo(video Video) error {
-	query := `
+const updateVideoQuery = `
 	UPDATE videos
 	SET
 		title = ?,
 		description = ?,
 		thumbnail_url = ?,
+		thumbnail_original_size = ?,
+		thumbnail_size = ?,
 		video_url = ?,
-		user_id = ?
+		original_url = ?,
+		audio_tracks = ?,
+		resolution_class = ?,
+		hdr_format = ?,
+		width = ?,
+		height = ?,
+		sample_aspect_num = ?,
+		sample_aspect_den = ?,
+		moderation_status = ?,
+		moderation_labels = ?,
+		pending_thumbnail_url = ?,
+		pending_thumbnail_labels = ?,
+		custom_metadata = ?,
+		pending_upload_url = ?,
+		organization_id = ?,
+		visibility = ?,
+		video_size = ?,
+		duration_seconds = ?,
+		tags = ?,
+		video_checksum = ?,
+		original_checksum = ?,
+		user_id = ?,
+		version = version + 1
 	WHERE id = ?
 	`
 
-	_, err := c.db.Exec(
-		query,
+// MarkVideoPublished records that a video can now be watched by anyone,
+// reporting whether that's the first time. Videos that aren't published
+// yet, or were already marked, report false.
+func (c Client) MarkVideoPublished(id uuid.UUID) (bool, error) {
+	query := `
+	UPDATE videos
+	SET published_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND published_at IS NULL
+	AND ` + publishedCondition
+	result, err := c.db.Exec(query, append([]any{id}, publishedArgs...)...)
+	if err != nil {
+		return false, err
+	}
+	marked, err := result.RowsAffected()
+	return marked > 0, err
+}
+
+func (c Client) UpdateVideo(video Video) error {
+	_, err := c.db.execStmt(
+		c.db.updateVideo,
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
+		&video.ThumbnailOriginalSize,
+		&video.ThumbnailSize,
 		&video.VideoURL,
+		&video.OriginalURL,
+		video.AudioTracks,
+		video.ResolutionClass,
+		video.HDRFormat,
+		video.Width,
+		video.Height,
+		video.SampleAspectNum,
+		video.SampleAspectDen,
+		video.ModerationStatus,
+		video.ModerationLabels,
+		video.PendingThumbnailURL,
+		video.PendingThumbnailLabels,
+		video.CustomMetadata,
+		video.PendingUploadURL,
+		video.OrganizationID,
+		video.Visibility,
+		video.VideoSize,
+		video.DurationSeconds,
+		video.Tags,
+		video.VideoChecksum,
+		video.OriginalChecksum,
 		video.UserID,
 		video.ID,
 	)
+	c.forgetVideo(video.ID)
+	c.videoChanged(video.ID)
 	return err
 }
 
+// ErrLegalHold is returned by DeleteVideo when the video is under legal hold.
+var ErrLegalHold = errors.New("video is under legal hold")
+
+// SetVideoLegalHold places a video under legal hold or lifts it.
+func (c Client) SetVideoLegalHold(id uuid.UUID, hold bool) error {
+	_, err := c.db.Exec("UPDATE videos SET legal_hold = ?, version = version + 1 WHERE id = ?", hold, id)
+	c.forgetVideo(id)
+	return err
+}
+
+// DeleteVideo returns ErrLegalHold without deleting anything if the video is
+// under legal hold.
 func (c Client) DeleteVideo(id uuid.UUID) error {
+	var held bool
+	err := c.db.QueryRow("SELECT legal_hold FROM videos WHERE id = ?", id).Scan(&held)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if held {
+		return ErrLegalHold
+	}
+	if _, err := c.db.Exec("DELETE FROM jobs WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM thumbnail_candidates WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM captions WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM thumbnail_variants WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM likes WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM shares WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM video_transfers WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM video_reports WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM playback_events WHERE video_id = ?", id); err != nil {
+		return err
+	}
+	if _, err := c.db.Exec("DELETE FROM video_analytics_daily WHERE video_id = ?", id); err != nil {
+		return err
+	}
 	query := `
 	DELETE FROM videos
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	_, err = c.db.Exec(query, id)
+	c.forgetVideo(id)
+	c.videoChanged(id)
 	return err
 }
@@ -0,0 +1,337 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// Webhook is a user's subscription to events about their videos.
+type Webhook struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreateWebhookParams
+}
+
+type CreateWebhookParams struct {
+	UserID uuid.UUID     `json:"user_id"`
+	URL    string        `json:"url"`
+	Events WebhookEvents `json:"events"`
+	// Secret signs every delivery. It is only shown when the webhook is
+	// created.
+	Secret string `json:"-"`
+}
+
+// WebhookEvents is stored as a JSON array in a single column.
+type WebhookEvents []string
+
+func (e WebhookEvents) Value() (driver.Value, error) {
+	dat, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return string(dat), nil
+}
+
+func (e *WebhookEvents) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*e = nil
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), e)
+	case []byte:
+		return json.Unmarshal(v, e)
+	default:
+		return fmt.Errorf("unsupported type for webhook events: %T", src)
+	}
+}
+
+// WebhookDelivery is one event sent, or being sent, to a webhook. It keeps
+// the outcome of the latest attempt for debugging.
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+	WebhookID      uuid.UUID       `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"`
+	ResponseStatus *int            `json:"response_status"`
+	ResponseBody   *string         `json:"response_body"`
+	Error          *string         `json:"error"`
+}
+
+const webhookColumns = `
+		id,
+		created_at,
+		user_id,
+		url,
+		events,
+		secret`
+
+const webhookDeliveryColumns = `
+		id,
+		created_at,
+		updated_at,
+		webhook_id,
+		event,
+		payload,
+		status,
+		attempts,
+		next_attempt_at,
+		response_status,
+		response_body,
+		error`
+
+func (c Client) CreateWebhook(params CreateWebhookParams) (Webhook, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO webhooks (
+		id,
+		created_at,
+		user_id,
+		url,
+		events,
+		secret
+	) VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, params.UserID, params.URL, params.Events, params.Secret)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	return c.GetWebhook(id)
+}
+
+func (c Client) GetWebhook(id uuid.UUID) (Webhook, error) {
+	query := `
+	SELECT` + webhookColumns + `
+	FROM webhooks
+	WHERE id = ?
+	`
+	webhook, err := scanWebhook(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Webhook{}, nil
+		}
+		return Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func (c Client) GetWebhooks(userID uuid.UUID) ([]Webhook, error) {
+	query := `
+	SELECT` + webhookColumns + `
+	FROM webhooks
+	WHERE user_id = ?
+	ORDER BY created_at ASC
+	`
+	rows, err := c.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook removes a webhook and its delivery log.
+func (c Client) DeleteWebhook(id uuid.UUID) error {
+	if _, err := c.db.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		return err
+	}
+	_, err := c.db.Exec("DELETE FROM webhooks WHERE id = ?", id)
+	return err
+}
+
+// DeleteWebhooksForUser removes every webhook a user has, with their
+// delivery logs.
+func (c Client) DeleteWebhooksForUser(userID uuid.UUID) error {
+	query := `
+	DELETE FROM webhook_deliveries
+	WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?)
+	`
+	if _, err := c.db.Exec(query, userID); err != nil {
+		return err
+	}
+	_, err := c.db.Exec("DELETE FROM webhooks WHERE user_id = ?", userID)
+	return err
+}
+
+// CreateWebhookDelivery queues an event for a webhook, due immediately.
+func (c Client) CreateWebhookDelivery(webhookID uuid.UUID, event string, payload json.RawMessage) (WebhookDelivery, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO webhook_deliveries (
+		id,
+		created_at,
+		updated_at,
+		webhook_id,
+		event,
+		payload,
+		status,
+		next_attempt_at
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, webhookID, event, string(payload), WebhookDeliveryPending, time.Now().UTC())
+	if err != nil {
+		return WebhookDelivery{}, err
+	}
+
+	return c.GetWebhookDelivery(id)
+}
+
+func (c Client) GetWebhookDelivery(id uuid.UUID) (WebhookDelivery, error) {
+	query := `
+	SELECT` + webhookDeliveryColumns + `
+	FROM webhook_deliveries
+	WHERE id = ?
+	`
+	delivery, err := scanWebhookDelivery(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return WebhookDelivery{}, nil
+		}
+		return WebhookDelivery{}, err
+	}
+	return delivery, nil
+}
+
+// GetWebhookDeliveries returns a webhook's newest deliveries first,
+// continuing after the given cursor if there is one.
+func (c Client) GetWebhookDeliveries(webhookID uuid.UUID, after *Cursor, limit int) ([]WebhookDelivery, error) {
+	conditions := "webhook_id = ?"
+	args := []any{webhookID}
+	if after != nil {
+		condition, cursorArgs := after.after("created_at", false)
+		conditions += " AND " + condition
+		args = append(args, cursorArgs...)
+	}
+	query := `
+	SELECT` + webhookDeliveryColumns + `
+	FROM webhook_deliveries
+	WHERE ` + conditions + `
+	ORDER BY created_at DESC, id DESC
+	LIMIT ?
+	`
+	args = append(args, limit)
+	return c.queryWebhookDeliveries(query, args...)
+}
+
+// GetDueWebhookDeliveries returns pending deliveries whose next attempt is
+// due, oldest first.
+func (c Client) GetDueWebhookDeliveries(now time.Time, limit int) ([]WebhookDelivery, error) {
+	query := `
+	SELECT` + webhookDeliveryColumns + `
+	FROM webhook_deliveries
+	WHERE status = ? AND next_attempt_at <= ?
+	ORDER BY next_attempt_at ASC
+	LIMIT ?
+	`
+	return c.queryWebhookDeliveries(query, WebhookDeliveryPending, now.UTC(), limit)
+}
+
+func (c Client) queryWebhookDeliveries(query string, args ...any) ([]WebhookDelivery, error) {
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+func (c Client) UpdateWebhookDelivery(delivery WebhookDelivery) error {
+	var nextAttemptAt *time.Time
+	if delivery.NextAttemptAt != nil {
+		t := delivery.NextAttemptAt.UTC()
+		nextAttemptAt = &t
+	}
+	query := `
+	UPDATE webhook_deliveries
+	SET
+		updated_at = CURRENT_TIMESTAMP,
+		status = ?,
+		attempts = ?,
+		next_attempt_at = ?,
+		response_status = ?,
+		response_body = ?,
+		error = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(
+		query,
+		delivery.Status,
+		delivery.Attempts,
+		nextAttemptAt,
+		delivery.ResponseStatus,
+		delivery.ResponseBody,
+		delivery.Error,
+		delivery.ID,
+	)
+	return err
+}
+
+func scanWebhook(row rowScanner) (Webhook, error) {
+	var webhook Webhook
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.CreatedAt,
+		&webhook.UserID,
+		&webhook.URL,
+		&webhook.Events,
+		&webhook.Secret,
+	)
+	return webhook, err
+}
+
+func scanWebhookDelivery(row rowScanner) (WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	var payload string
+	err := row.Scan(
+		&delivery.ID,
+		&delivery.CreatedAt,
+		&delivery.UpdatedAt,
+		&delivery.WebhookID,
+		&delivery.Event,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.NextAttemptAt,
+		&delivery.ResponseStatus,
+		&delivery.ResponseBody,
+		&delivery.Error,
+	)
+	delivery.Payload = json.RawMessage(payload)
+	return delivery, err
+}
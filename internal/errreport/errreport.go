@@ -0,0 +1,46 @@
+// Package errreport sends errors and panics to an error tracking service.
+package errreport
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Reporter sends events somewhere they'll be noticed. Report must not block
+// the caller on the network.
+type Reporter interface {
+	Report(event Event)
+}
+
+// Event is one error or panic. Tags carry the IDs needed to find what the
+// error happened to, such as request_id, user_id and video_id.
+type Event struct {
+	Message string
+	Err     error
+	Tags    map[string]string
+
+	// Method and URL are set for errors handling a request.
+	Method string
+	URL    string
+
+	// Stack is set for panics, from runtime.Callers.
+	Stack []uintptr
+	Panic bool
+}
+
+// ChainLink is one error in a chain of wrapped errors.
+type ChainLink struct {
+	Type    string
+	Message string
+}
+
+// Chain unwraps err, outermost error first. Joined errors end the chain,
+// since their own message already includes every error joined.
+func Chain(err error) []ChainLink {
+	var links []ChainLink
+	for err != nil {
+		links = append(links, ChainLink{Type: fmt.Sprintf("%T", err), Message: err.Error()})
+		err = errors.Unwrap(err)
+	}
+	return links
+}
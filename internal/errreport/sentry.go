@@ -0,0 +1,198 @@
+package errreport
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+)
+
+// sentryQueueSize is how many events can wait to be sent before new ones are
+// dropped.
+const sentryQueueSize = 64
+
+// Sentry sends events to Sentry, or any service accepting its envelope API,
+// from a background goroutine.
+type Sentry struct {
+	dsn         string
+	endpoint    string
+	auth        string
+	environment string
+	client      *http.Client
+	queue       chan Event
+}
+
+// NewSentry parses a DSN of the form https://<key>@<host>/<project> and
+// starts sending.
+func NewSentry(dsn, environment string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sentry DSN: %w", err)
+	}
+	key := u.User.Username()
+	path := strings.TrimSuffix(u.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	if key == "" || u.Host == "" || slash < 0 || slash == len(path)-1 {
+		return nil, fmt.Errorf("invalid Sentry DSN %q, expected https://<key>@<host>/<project>", u.Redacted())
+	}
+	prefix, project := path[:slash], path[slash+1:]
+
+	s := &Sentry{
+		dsn:         dsn,
+		endpoint:    fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project),
+		auth:        fmt.Sprintf("Sentry sentry_version=7, sentry_client=tubely/1.0, sentry_key=%s", key),
+		environment: environment,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan Event, sentryQueueSize),
+	}
+	go s.send()
+	return s, nil
+}
+
+// Report queues the event, dropping it if the queue is full.
+func (s *Sentry) Report(event Event) {
+	select {
+	case s.queue <- event:
+	default:
+		logging.Warnf("Dropping error report, queue is full: %s", event.Message)
+	}
+}
+
+func (s *Sentry) send() {
+	for event := range s.queue {
+		if err := s.post(event); err != nil {
+			logging.Warnf("Couldn't send error report: %v", err)
+		}
+	}
+}
+
+type sentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Stacktrace *sentryStacktrace `json:"stacktrace,omitempty"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+}
+
+type sentryRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Platform    string            `json:"platform"`
+	Level       string            `json:"level"`
+	Logger      string            `json:"logger"`
+	Environment string            `json:"environment,omitempty"`
+	Logentry    map[string]string `json:"logentry"`
+	Exception   map[string]any    `json:"exception,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Request     *sentryRequest    `json:"request,omitempty"`
+}
+
+func (s *Sentry) post(event Event) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	payload := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   now,
+		Platform:    "go",
+		Level:       "error",
+		Logger:      "tubely",
+		Environment: s.environment,
+		Logentry:    map[string]string{"formatted": event.Message},
+		Tags:        event.Tags,
+	}
+	if event.Panic {
+		payload.Level = "fatal"
+	}
+	if event.Method != "" {
+		payload.Request = &sentryRequest{Method: event.Method, URL: event.URL}
+	}
+
+	// Sentry lists chained exceptions oldest first, so the root cause leads
+	// and the stack goes on the last one
+	chain := Chain(event.Err)
+	exceptions := make([]sentryException, len(chain))
+	for i, link := range chain {
+		exceptions[len(chain)-1-i] = sentryException{Type: link.Type, Value: link.Message}
+	}
+	if len(event.Stack) > 0 {
+		stacktrace := &sentryStacktrace{Frames: stackFrames(event.Stack)}
+		if len(exceptions) == 0 {
+			exceptions = append(exceptions, sentryException{Type: "panic", Value: event.Message})
+		}
+		exceptions[len(exceptions)-1].Stacktrace = stacktrace
+	}
+	if len(exceptions) > 0 {
+		payload.Exception = map[string]any{"values": exceptions}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	header, err := json.Marshal(map[string]string{"event_id": payload.EventID, "sent_at": now, "dsn": s.dsn})
+	if err != nil {
+		return err
+	}
+	var envelope bytes.Buffer
+	envelope.Write(header)
+	fmt.Fprintf(&envelope, "\n{\"type\":\"event\",\"length\":%d}\n", len(body))
+	envelope.Write(body)
+	envelope.WriteByte('\n')
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, &envelope)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sentry responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// stackFrames lists the frames calling first, the order Sentry expects.
+func stackFrames(pcs []uintptr) []sentryFrame {
+	var frames []sentryFrame
+	iter := runtime.CallersFrames(pcs)
+	for {
+		frame, more := iter.Next()
+		frames = append(frames, sentryFrame{Function: frame.Function, Filename: frame.File, Lineno: frame.Line})
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	return frames
+}
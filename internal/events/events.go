@@ -0,0 +1,104 @@
+// Package events is an internal event bus. Publishers announce what
+// happened, such as a video finishing processing, and subscribers like
+// webhooks react to it without the publisher knowing about them.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types
+const (
+	TypeVideoProcessed = "video.processed"
+	TypeVideoFailed    = "video.failed"
+	TypeVideoDeleted   = "video.deleted"
+	// TypeQuotaExceeded is published when a user is refused for being over
+	// a quota.
+	TypeQuotaExceeded = "quota.exceeded"
+	// TypeStorageQuotaWarning and TypeStorageQuotaFull are published when a
+	// user's stored videos first reach 80% and 100% of their storage quota.
+	TypeStorageQuotaWarning = "quota.storage_warning"
+	TypeStorageQuotaFull    = "quota.storage_full"
+	// TypeVideoBlocked and TypeVideoReinstated tell an owner a takedown
+	// has blocked their video or been lifted.
+	TypeVideoBlocked    = "video.blocked"
+	TypeVideoReinstated = "video.reinstated"
+	// TypeVideoPublished is published the first time a video can be
+	// watched by anyone, and TypeSubscriptionVideoPublished then tells
+	// each of the owner's followers.
+	TypeVideoPublished             = "video.published"
+	TypeSubscriptionVideoPublished = "subscription.video_published"
+)
+
+// Event is something that happened to a user's resources. Data is the
+// type-specific payload.
+type Event struct {
+	ID        uuid.UUID       `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	UserID    uuid.UUID       `json:"user_id"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// New creates an event with a fresh ID, encoding data as its payload.
+func New(eventType string, userID uuid.UUID, data any) (Event, error) {
+	dat, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to encode %s event: %w", eventType, err)
+	}
+	return Event{
+		ID:        uuid.New(),
+		Type:      eventType,
+		CreatedAt: time.Now().UTC(),
+		UserID:    userID,
+		Data:      dat,
+	}, nil
+}
+
+// Handler reacts to an event. Transports that support redelivery retry
+// events a handler returns an error for.
+type Handler func(ctx context.Context, event Event) error
+
+// Bus carries events from publishers to subscribers. Each event is handled
+// by the subscribers of one process, even when several share a transport.
+type Bus interface {
+	Publish(ctx context.Context, event Event) error
+	// Subscribe registers a handler for every event. Handlers should return
+	// quickly; slow work belongs in a queue of its own.
+	Subscribe(handler Handler)
+	Close() error
+}
+
+// subscribers fans an event out to the registered handlers.
+type subscribers struct {
+	mu       sync.RWMutex
+	handlers []Handler
+}
+
+func (s *subscribers) add(handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append(s.handlers, handler)
+}
+
+// dispatch runs every handler, even after one fails, and joins their errors.
+func (s *subscribers) dispatch(ctx context.Context, event Event) error {
+	s.mu.RLock()
+	handlers := s.handlers
+	s.mu.RUnlock()
+
+	var errs []error
+	for _, handler := range handlers {
+		if err := handler(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,34 @@
+package events
+
+import (
+	"context"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+)
+
+// InProcess delivers events to subscribers in the publishing process, in
+// the publisher's goroutine. It is the default for a single instance.
+type InProcess struct {
+	subscribers
+}
+
+func NewInProcess() *InProcess {
+	return &InProcess{}
+}
+
+// Publish runs the handlers before returning. Handler errors are logged
+// rather than returned, since there is nothing to redeliver.
+func (b *InProcess) Publish(ctx context.Context, event Event) error {
+	if err := b.dispatch(ctx, event); err != nil {
+		logging.Errorf("Handling %s event %s failed: %v", event.Type, event.ID, err)
+	}
+	return nil
+}
+
+func (b *InProcess) Subscribe(handler Handler) {
+	b.add(handler)
+}
+
+func (b *InProcess) Close() error {
+	return nil
+}
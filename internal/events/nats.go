@@ -0,0 +1,84 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/nats-io/nats.go"
+)
+
+// natsQueueGroup makes every instance share one subscription, so each event
+// is handled once.
+const natsQueueGroup = "tubely"
+
+// NATS sends events over a NATS subject. Core NATS delivers at most once:
+// events published while no instance is subscribed, or whose handlers fail,
+// are not redelivered.
+type NATS struct {
+	subscribers
+	conn    *nats.Conn
+	subject string
+
+	mu           sync.Mutex
+	subscription *nats.Subscription
+}
+
+func NewNATS(conn *nats.Conn, subject string) *NATS {
+	return &NATS{conn: conn, subject: subject}
+}
+
+func (b *NATS) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	err = b.conn.Publish(b.subject, body)
+	if err != nil {
+		return fmt.Errorf("failed to publish event to NATS: %w", err)
+	}
+	return nil
+}
+
+// Subscribe registers a handler and joins the queue group on the first
+// call.
+func (b *NATS) Subscribe(handler Handler) {
+	b.add(handler)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscription != nil {
+		return
+	}
+	subscription, err := b.conn.QueueSubscribe(b.subject, natsQueueGroup, func(msg *nats.Msg) {
+		var event Event
+		if err := json.Unmarshal(msg.Data, &event); err != nil {
+			logging.Warnf("Dropping malformed event from NATS: %v", err)
+			return
+		}
+		if err := b.dispatch(context.Background(), event); err != nil {
+			logging.Errorf("Handling %s event %s failed: %v", event.Type, event.ID, err)
+		}
+	})
+	if err != nil {
+		logging.Warnf("Couldn't subscribe to NATS subject %s: %v", b.subject, err)
+		return
+	}
+	b.subscription = subscription
+}
+
+// Close drains the subscription, letting in-flight handlers finish, and
+// closes the connection.
+func (b *NATS) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscription != nil {
+		if err := b.subscription.Drain(); err != nil {
+			return err
+		}
+	}
+	b.conn.Close()
+	return nil
+}
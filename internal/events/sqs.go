@@ -0,0 +1,103 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+)
+
+// SQS sends events through an Amazon SQS queue shared by every instance.
+// Each message is received by one instance and deleted once its handlers
+// succeed; otherwise SQS redelivers it after the visibility timeout.
+type SQS struct {
+	subscribers
+	client   *sqs.Client
+	queueURL string
+
+	start  sync.Once
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewSQS(client *sqs.Client, queueURL string) *SQS {
+	return &SQS{client: client, queueURL: queueURL, done: make(chan struct{})}
+}
+
+func (b *SQS) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	_, err = b.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(b.queueURL),
+		MessageBody: aws.String(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send event to SQS: %w", err)
+	}
+	return nil
+}
+
+// Subscribe registers a handler and starts receiving from the queue on the
+// first call.
+func (b *SQS) Subscribe(handler Handler) {
+	b.add(handler)
+	b.start.Do(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		b.cancel = cancel
+		go b.receive(ctx)
+	})
+}
+
+func (b *SQS) receive(ctx context.Context) {
+	defer close(b.done)
+	for ctx.Err() == nil {
+		output, err := b.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(b.queueURL),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     20,
+		})
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return
+			}
+			logging.Warnf("Couldn't receive events from SQS: %v", err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		for _, message := range output.Messages {
+			var event Event
+			if err := json.Unmarshal([]byte(aws.ToString(message.Body)), &event); err != nil {
+				// A malformed message will never succeed, so don't redeliver it
+				logging.Warnf("Dropping malformed event %s from SQS: %v", aws.ToString(message.MessageId), err)
+			} else if err := b.dispatch(ctx, event); err != nil {
+				logging.Errorf("Handling %s event %s failed, leaving it for redelivery: %v", event.Type, event.ID, err)
+				continue
+			}
+			_, err := b.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(b.queueURL),
+				ReceiptHandle: message.ReceiptHandle,
+			})
+			if err != nil {
+				logging.Warnf("Couldn't delete event message %s from SQS: %v", aws.ToString(message.MessageId), err)
+			}
+		}
+	}
+}
+
+// Close stops receiving and waits for the handlers of in-flight messages.
+func (b *SQS) Close() error {
+	if b.cancel == nil {
+		return nil
+	}
+	b.cancel()
+	<-b.done
+	return nil
+}
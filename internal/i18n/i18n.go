@@ -0,0 +1,163 @@
+// Package i18n translates user-facing API messages. Catalogs are keyed by the
+// English message, so code keeps writing messages in English and anything
+// without a translation falls back to it.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLanguage is the language messages are written in.
+const DefaultLanguage = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalog holds one language's translations. Keys containing format verbs
+// (%d, %s, %q, %v) match formatted messages; the translation uses the same
+// verbs in the same order and gets the original's values substituted in.
+type catalog struct {
+	messages map[string]string
+	formats  []formatEntry
+}
+
+type formatEntry struct {
+	pattern     *regexp.Regexp
+	translation string
+}
+
+var catalogs = mustLoadCatalogs()
+
+var formatVerb = regexp.MustCompile(`%[dsqv]`)
+
+func mustLoadCatalogs() map[string]catalog {
+	files, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	catalogs := map[string]catalog{}
+	for _, file := range files {
+		data, err := localeFiles.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			panic(err)
+		}
+		messages := map[string]string{}
+		err = json.Unmarshal(data, &messages)
+		if err != nil {
+			panic(fmt.Sprintf("i18n: invalid catalog %s: %v", file.Name(), err))
+		}
+
+		c := catalog{messages: messages}
+		for message, translation := range messages {
+			if !formatVerb.MatchString(message) {
+				continue
+			}
+			if len(formatVerb.FindAllString(message, -1)) != len(formatVerb.FindAllString(translation, -1)) {
+				panic(fmt.Sprintf("i18n: %s: translation of %q has different format verbs", file.Name(), message))
+			}
+			c.formats = append(c.formats, formatEntry{
+				pattern:     formatPattern(message),
+				translation: translation,
+			})
+		}
+		// Longer formats are more specific, so try them first
+		sort.Slice(c.formats, func(i, j int) bool {
+			return len(c.formats[i].pattern.String()) > len(c.formats[j].pattern.String())
+		})
+		catalogs[strings.TrimSuffix(file.Name(), ".json")] = c
+	}
+	return catalogs
+}
+
+// formatPattern turns a format string into a regexp matching its output,
+// capturing each formatted value.
+func formatPattern(format string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	last := 0
+	for _, loc := range formatVerb.FindAllStringIndex(format, -1) {
+		b.WriteString(regexp.QuoteMeta(format[last:loc[0]]))
+		switch format[loc[1]-1] {
+		case 'd':
+			b.WriteString(`(-?\d+)`)
+		case 'q':
+			b.WriteString(`("(?:[^"\\]|\\.)*")`)
+		default:
+			b.WriteString(`(.+?)`)
+		}
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(format[last:]))
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// Languages lists the languages with a catalog, plus the default.
+func Languages() []string {
+	languages := []string{DefaultLanguage}
+	for language := range catalogs {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages[1:])
+	return languages
+}
+
+// Translate returns message in language, or message itself if there's no
+// translation for it.
+func Translate(language, message string) string {
+	c, ok := catalogs[language]
+	if !ok {
+		return message
+	}
+	if translation, ok := c.messages[message]; ok {
+		return translation
+	}
+	for _, entry := range c.formats {
+		values := entry.pattern.FindStringSubmatch(message)
+		if values == nil {
+			continue
+		}
+		values = values[1:]
+		return formatVerb.ReplaceAllStringFunc(entry.translation, func(string) string {
+			value := values[0]
+			values = values[1:]
+			return value
+		})
+	}
+	return message
+}
+
+// Match picks the best supported language for an Accept-Language header,
+// falling back to DefaultLanguage. Regional variants match their base
+// language, so "fr-CA" gets French.
+func Match(acceptLanguage string) string {
+	best := DefaultLanguage
+	bestQuality := 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality <= bestQuality {
+			continue
+		}
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if _, ok := catalogs[base]; ok || base == DefaultLanguage {
+			best = base
+			bestQuality = quality
+		}
+	}
+	return best
+}
@@ -0,0 +1,179 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The cloud storage services users can link their accounts to.
+const (
+	CloudGoogleDrive = "google_drive"
+	CloudDropbox     = "dropbox"
+)
+
+// ErrUnauthorized means a linked account's tokens were revoked or have
+// expired, so the user has to link it again.
+var ErrUnauthorized = errors.New("the linked account's authorization was revoked or has expired")
+
+// Token is an OAuth token for a user's cloud storage account. Expiry is
+// zero for tokens that don't expire.
+type Token struct {
+	AccessToken  string
+	RefreshToken string
+	Expiry       time.Time
+}
+
+// CloudFile is a file or folder in a cloud storage account.
+type CloudFile struct {
+	// ID names the file to the service, and stays the same if it's renamed
+	// or moved
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Folder bool   `json:"folder"`
+	Size   int64  `json:"size"`
+	// MimeType is empty when the service doesn't say
+	MimeType   string    `json:"mime_type,omitempty"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// CloudPage is part of a folder's listing. Cursor is empty on the last
+// page.
+type CloudPage struct {
+	Files  []CloudFile
+	Cursor string
+}
+
+// Cloud is a cloud storage service users link their accounts to with
+// OAuth, so the files they pick are downloaded by the server instead of
+// through their own connection. Errors for missing files match
+// fs.ErrNotExist, and those for tokens that no longer work ErrUnauthorized.
+type Cloud interface {
+	// Name is one of the Cloud constants.
+	Name() string
+	// AuthURL is where to send a user to link their account. The service
+	// sends them back to redirectURL with the state and a code for
+	// Exchange.
+	AuthURL(redirectURL, state string) string
+	Exchange(ctx context.Context, redirectURL, code string) (Token, error)
+	// Refresh returns a new access token, keeping the refresh token if the
+	// service didn't issue a new one.
+	Refresh(ctx context.Context, token Token) (Token, error)
+	Revoke(ctx context.Context, token Token) error
+	// Account returns the email address of the linked account.
+	Account(ctx context.Context, token Token) (string, error)
+	// List returns a page of a folder, the top one if folder is empty, from
+	// the start or from an earlier page's cursor.
+	List(ctx context.Context, token Token, folder, cursor string) (CloudPage, error)
+	Stat(ctx context.Context, token Token, id string) (CloudFile, error)
+	// Open downloads a file, which can take as long as ctx allows.
+	Open(ctx context.Context, token Token, id string) (io.ReadCloser, error)
+	// FileURL identifies a file in an account, for recording where an
+	// import came from.
+	FileURL(account, id string) string
+}
+
+// oauthClient is the authorization code flow the services share.
+type oauthClient struct {
+	clientID     string
+	clientSecret string
+	authURL      string
+	tokenURL     string
+	// authParams are added to the authorization URL, such as the scopes
+	authParams url.Values
+	httpClient *http.Client
+}
+
+func (o *oauthClient) authCodeURL(redirectURL, state string) string {
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.clientID},
+		"redirect_uri":  {redirectURL},
+		"state":         {state},
+	}
+	for key, values := range o.authParams {
+		query[key] = values
+	}
+	return o.authURL + "?" + query.Encode()
+}
+
+func (o *oauthClient) exchange(ctx context.Context, redirectURL, code string) (Token, error) {
+	return o.token(ctx, url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURL},
+	})
+}
+
+func (o *oauthClient) refresh(ctx context.Context, token Token) (Token, error) {
+	if token.RefreshToken == "" {
+		return Token{}, ErrUnauthorized
+	}
+	refreshed, err := o.token(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+	})
+	if err != nil {
+		return Token{}, err
+	}
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = token.RefreshToken
+	}
+	return refreshed, nil
+}
+
+// token requests a token from the token endpoint.
+func (o *oauthClient) token(ctx context.Context, form url.Values) (Token, error) {
+	form.Set("client_id", o.clientID)
+	form.Set("client_secret", o.clientSecret)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Token{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := o.httpClient.Do(req)
+	if err != nil {
+		return Token{}, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		RefreshToken     string `json:"refresh_token"`
+		ExpiresIn        int64  `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body)
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		// A code or refresh token that's been used, revoked or has
+		// expired is invalid_grant
+		if body.Error == "invalid_grant" {
+			return Token{}, fmt.Errorf("%w: %s", ErrUnauthorized, body.ErrorDescription)
+		}
+		return Token{}, fmt.Errorf("token request failed with status %d: %s %s", resp.StatusCode, body.Error, body.ErrorDescription)
+	}
+	if err != nil {
+		return Token{}, fmt.Errorf("couldn't decode token response: %w", err)
+	}
+	if body.AccessToken == "" {
+		return Token{}, errors.New("token response has no access token")
+	}
+	token := Token{AccessToken: body.AccessToken, RefreshToken: body.RefreshToken}
+	if body.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second).UTC()
+	}
+	return token, nil
+}
+
+// cloudFileURL returns a URL like "dropbox://user@example.com/id:abc" for a
+// file in the account with that email address.
+func cloudFileURL(service, account, id string) string {
+	return strings.ReplaceAll(service, "_", "-") + "://" + url.PathEscape(account) + "/" + url.PathEscape(id)
+}
@@ -0,0 +1,224 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	driveFolderMimeType = "application/vnd.google-apps.folder"
+	driveFileFields     = "id,name,mimeType,size,modifiedTime"
+	drivePageSize       = 100
+)
+
+// GoogleDrive reads the files in users' Google Drives, including shared
+// drives, with read-only access.
+type GoogleDrive struct {
+	oauth oauthClient
+	// apiURL and revokeURL are fields so tests can point them elsewhere
+	apiURL    string
+	revokeURL string
+}
+
+func NewGoogleDrive(clientID, clientSecret string, httpClient *http.Client) *GoogleDrive {
+	return &GoogleDrive{
+		oauth: oauthClient{
+			clientID:     clientID,
+			clientSecret: clientSecret,
+			authURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+			tokenURL:     "https://oauth2.googleapis.com/token",
+			authParams: url.Values{
+				"scope": {"https://www.googleapis.com/auth/drive.readonly"},
+				// A refresh token is only issued for offline access, and
+				// only the first time unless the user is asked again
+				"access_type": {"offline"},
+				"prompt":      {"consent"},
+			},
+			httpClient: httpClient,
+		},
+		apiURL:    "https://www.googleapis.com/drive/v3",
+		revokeURL: "https://oauth2.googleapis.com/revoke",
+	}
+}
+
+func (d *GoogleDrive) Name() string { return CloudGoogleDrive }
+
+func (d *GoogleDrive) AuthURL(redirectURL, state string) string {
+	return d.oauth.authCodeURL(redirectURL, state)
+}
+
+func (d *GoogleDrive) Exchange(ctx context.Context, redirectURL, code string) (Token, error) {
+	return d.oauth.exchange(ctx, redirectURL, code)
+}
+
+func (d *GoogleDrive) Refresh(ctx context.Context, token Token) (Token, error) {
+	return d.oauth.refresh(ctx, token)
+}
+
+func (d *GoogleDrive) Revoke(ctx context.Context, token Token) error {
+	// Revoking the refresh token revokes its access tokens too
+	revoked := token.RefreshToken
+	if revoked == "" {
+		revoked = token.AccessToken
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.revokeURL, strings.NewReader(url.Values{"token": {revoked}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := d.oauth.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// A token that's already invalid is as good as revoked
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("revoking token failed with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (d *GoogleDrive) Account(ctx context.Context, token Token) (string, error) {
+	var about struct {
+		User struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"user"`
+	}
+	err := d.get(ctx, token, "/about", url.Values{"fields": {"user(emailAddress)"}}, &about)
+	if err != nil {
+		return "", err
+	}
+	return about.User.EmailAddress, nil
+}
+
+// driveFile is a file as the Drive API returns it.
+type driveFile struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	MimeType     string    `json:"mimeType"`
+	Size         string    `json:"size"`
+	ModifiedTime time.Time `json:"modifiedTime"`
+}
+
+func (f driveFile) cloudFile() CloudFile {
+	// Google Docs and folders have no size
+	size, _ := strconv.ParseInt(f.Size, 10, 64)
+	return CloudFile{
+		ID:         f.ID,
+		Name:       f.Name,
+		Folder:     f.MimeType == driveFolderMimeType,
+		Size:       size,
+		MimeType:   f.MimeType,
+		ModifiedAt: f.ModifiedTime.UTC(),
+	}
+}
+
+func (d *GoogleDrive) List(ctx context.Context, token Token, folder, cursor string) (CloudPage, error) {
+	if folder == "" {
+		folder = "root"
+	}
+	query := url.Values{
+		"q":                         {fmt.Sprintf("'%s' in parents and trashed = false", driveQueryEscape(folder))},
+		"fields":                    {"nextPageToken,files(" + driveFileFields + ")"},
+		"orderBy":                   {"folder,name"},
+		"pageSize":                  {strconv.Itoa(drivePageSize)},
+		"supportsAllDrives":         {"true"},
+		"includeItemsFromAllDrives": {"true"},
+	}
+	if cursor != "" {
+		query.Set("pageToken", cursor)
+	}
+	var list struct {
+		NextPageToken string      `json:"nextPageToken"`
+		Files         []driveFile `json:"files"`
+	}
+	err := d.get(ctx, token, "/files", query, &list)
+	if err != nil {
+		return CloudPage{}, err
+	}
+	page := CloudPage{Files: []CloudFile{}, Cursor: list.NextPageToken}
+	for _, file := range list.Files {
+		page.Files = append(page.Files, file.cloudFile())
+	}
+	return page, nil
+}
+
+func (d *GoogleDrive) Stat(ctx context.Context, token Token, id string) (CloudFile, error) {
+	var file driveFile
+	err := d.get(ctx, token, "/files/"+url.PathEscape(id), url.Values{"fields": {driveFileFields}, "supportsAllDrives": {"true"}}, &file)
+	if err != nil {
+		return CloudFile{}, err
+	}
+	return file.cloudFile(), nil
+}
+
+func (d *GoogleDrive) Open(ctx context.Context, token Token, id string) (io.ReadCloser, error) {
+	resp, err := d.do(ctx, token, "/files/"+url.PathEscape(id), url.Values{"alt": {"media"}, "supportsAllDrives": {"true"}})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (d *GoogleDrive) FileURL(account, id string) string {
+	return cloudFileURL(CloudGoogleDrive, account, id)
+}
+
+func (d *GoogleDrive) get(ctx context.Context, token Token, path string, query url.Values, v any) error {
+	resp, err := d.do(ctx, token, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("couldn't decode Google Drive response: %w", err)
+	}
+	return nil
+}
+
+// do sends a GET request to the API, returning the response if it
+// succeeded.
+func (d *GoogleDrive) do(ctx context.Context, token Token, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.apiURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	resp, err := d.oauth.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body)
+	err = fmt.Errorf("Google Drive request failed with status %d: %s", resp.StatusCode, body.Error.Message)
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+	}
+	return nil, err
+}
+
+// driveQueryEscape escapes a value quoted in a Drive search query.
+func driveQueryEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
@@ -0,0 +1,235 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const dropboxPageSize = 100
+
+// Dropbox reads the files in users' Dropboxes. The app needs the
+// files.metadata.read, files.content.read and account_info.read scopes.
+type Dropbox struct {
+	oauth oauthClient
+	// apiURL and contentURL are fields so tests can point them elsewhere
+	apiURL     string
+	contentURL string
+}
+
+func NewDropbox(appKey, appSecret string, httpClient *http.Client) *Dropbox {
+	return &Dropbox{
+		oauth: oauthClient{
+			clientID:     appKey,
+			clientSecret: appSecret,
+			authURL:      "https://www.dropbox.com/oauth2/authorize",
+			tokenURL:     "https://api.dropboxapi.com/oauth2/token",
+			authParams: url.Values{
+				"scope": {"files.metadata.read files.content.read account_info.read"},
+				// Without offline access there's no refresh token, and
+				// access tokens only last a few hours
+				"token_access_type": {"offline"},
+			},
+			httpClient: httpClient,
+		},
+		apiURL:     "https://api.dropboxapi.com/2",
+		contentURL: "https://content.dropboxapi.com/2",
+	}
+}
+
+func (d *Dropbox) Name() string { return CloudDropbox }
+
+func (d *Dropbox) AuthURL(redirectURL, state string) string {
+	return d.oauth.authCodeURL(redirectURL, state)
+}
+
+func (d *Dropbox) Exchange(ctx context.Context, redirectURL, code string) (Token, error) {
+	return d.oauth.exchange(ctx, redirectURL, code)
+}
+
+func (d *Dropbox) Refresh(ctx context.Context, token Token) (Token, error) {
+	return d.oauth.refresh(ctx, token)
+}
+
+func (d *Dropbox) Revoke(ctx context.Context, token Token) error {
+	err := d.rpc(ctx, token, "/auth/token/revoke", nil, nil)
+	// A token that's already invalid is as good as revoked
+	if err != nil && !errors.Is(err, ErrUnauthorized) {
+		return err
+	}
+	return nil
+}
+
+func (d *Dropbox) Account(ctx context.Context, token Token) (string, error) {
+	var account struct {
+		Email string `json:"email"`
+	}
+	err := d.rpc(ctx, token, "/users/get_current_account", nil, &account)
+	if err != nil {
+		return "", err
+	}
+	return account.Email, nil
+}
+
+// dropboxEntry is a file or folder as the Dropbox API returns it.
+type dropboxEntry struct {
+	Tag            string    `json:".tag"`
+	ID             string    `json:"id"`
+	Name           string    `json:"name"`
+	Size           int64     `json:"size"`
+	ServerModified time.Time `json:"server_modified"`
+}
+
+func (e dropboxEntry) cloudFile() CloudFile {
+	return CloudFile{
+		ID:         e.ID,
+		Name:       e.Name,
+		Folder:     e.Tag == "folder",
+		Size:       e.Size,
+		ModifiedAt: e.ServerModified.UTC(),
+	}
+}
+
+func (d *Dropbox) List(ctx context.Context, token Token, folder, cursor string) (CloudPage, error) {
+	var list struct {
+		Entries []dropboxEntry `json:"entries"`
+		Cursor  string         `json:"cursor"`
+		HasMore bool           `json:"has_more"`
+	}
+	var err error
+	if cursor != "" {
+		err = d.rpc(ctx, token, "/files/list_folder/continue", map[string]any{"cursor": cursor}, &list)
+	} else {
+		// The top folder is the empty path
+		err = d.rpc(ctx, token, "/files/list_folder", map[string]any{"path": folder, "limit": dropboxPageSize}, &list)
+	}
+	if err != nil {
+		return CloudPage{}, err
+	}
+	page := CloudPage{Files: []CloudFile{}}
+	if list.HasMore {
+		page.Cursor = list.Cursor
+	}
+	for _, entry := range list.Entries {
+		// Deleted entries only appear in listings that ask for them
+		if entry.Tag == "file" || entry.Tag == "folder" {
+			page.Files = append(page.Files, entry.cloudFile())
+		}
+	}
+	return page, nil
+}
+
+func (d *Dropbox) Stat(ctx context.Context, token Token, id string) (CloudFile, error) {
+	var entry dropboxEntry
+	err := d.rpc(ctx, token, "/files/get_metadata", map[string]any{"path": id}, &entry)
+	if err != nil {
+		return CloudFile{}, err
+	}
+	return entry.cloudFile(), nil
+}
+
+func (d *Dropbox) Open(ctx context.Context, token Token, id string) (io.ReadCloser, error) {
+	arg, err := json.Marshal(map[string]any{"path": id})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.contentURL+"/files/download", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Dropbox-API-Arg", asciiJSON(arg))
+	resp, err := d.do(req, token)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (d *Dropbox) FileURL(account, id string) string {
+	return cloudFileURL(CloudDropbox, account, id)
+}
+
+// rpc calls an endpoint taking and returning JSON. Those without
+// arguments take a null body.
+func (d *Dropbox) rpc(ctx context.Context, token Token, path string, arg, v any) error {
+	body, err := json.Marshal(arg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.apiURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.do(req, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("couldn't decode Dropbox response: %w", err)
+	}
+	return nil
+}
+
+// do sends an authorized request, returning the response if it succeeded.
+func (d *Dropbox) do(req *http.Request, token Token) (*http.Response, error) {
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	resp, err := d.oauth.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	// Errors are JSON with a summary like "path/not_found/..", except for
+	// some bad requests, which are plain text
+	dat, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var body struct {
+		ErrorSummary string `json:"error_summary"`
+	}
+	summary := string(dat)
+	if json.Unmarshal(dat, &body) == nil {
+		summary = body.ErrorSummary
+	}
+	err = fmt.Errorf("Dropbox request failed with status %d: %s", resp.StatusCode, summary)
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	case resp.StatusCode == http.StatusConflict && strings.Contains(summary, "not_found"):
+		return nil, fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+	}
+	return nil, err
+}
+
+// asciiJSON escapes everything outside ASCII in JSON, which headers can't
+// hold.
+func asciiJSON(dat []byte) string {
+	var b strings.Builder
+	for _, r := range string(dat) {
+		if r < utf8.RuneSelf {
+			b.WriteRune(r)
+			continue
+		}
+		for _, unit := range utf16.Encode([]rune{r}) {
+			fmt.Fprintf(&b, "\\u%04x", unit)
+		}
+	}
+	return b.String()
+}
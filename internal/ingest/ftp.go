@@ -0,0 +1,318 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ftpDefaultPort = "21"
+	// ftpTimeFormat is how MLSD and MDTM give modification times, in UTC
+	ftpTimeFormat = "20060102150405"
+)
+
+// FTP reads files over plain FTP in passive mode, logging in anonymously
+// when the URL has no user. Nothing is encrypted, so it's only for servers
+// on a trusted network.
+type FTP struct {
+	u       *url.URL
+	addr    string
+	opts    Options
+	timeout time.Duration
+}
+
+func newFTP(u *url.URL, opts Options) *FTP {
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), ftpDefaultPort)
+	}
+	return &FTP{u: u, addr: addr, opts: opts, timeout: opts.Timeout}
+}
+
+func (f *FTP) Scheme() string { return "ftp" }
+
+func (f *FTP) URL(path string) string { return fileURL(f.u, path) }
+
+func (f *FTP) Dial(ctx context.Context) (Conn, error) {
+	dialer := &net.Dialer{Timeout: f.timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", f.addr)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to FTP server: %w", err)
+	}
+	c := &ftpConn{
+		text:    textproto.NewConn(netConn),
+		netConn: netConn,
+		host:    f.u.Hostname(),
+		dialer:  dialer,
+	}
+	err = c.login(ctx, f.u, f.opts)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// ftpConn is a logged in control connection. Each listing and download
+// opens a passive data connection of its own.
+type ftpConn struct {
+	text    *textproto.Conn
+	netConn net.Conn
+	host    string
+	dialer  *net.Dialer
+	// noMLSD is set once the server has turned down MLSD
+	noMLSD bool
+}
+
+func (c *ftpConn) Close() error {
+	c.text.Cmd("QUIT")
+	return c.text.Close()
+}
+
+// cmd sends a command and reads its reply, which must have a code starting
+// with expect.
+func (c *ftpConn) cmd(ctx context.Context, expect int, format string, args ...any) (int, string, error) {
+	stop := context.AfterFunc(ctx, func() { c.netConn.SetDeadline(time.Now()) })
+	defer stop()
+	_, err := c.text.Cmd(format, args...)
+	if err != nil {
+		return 0, "", contextError(ctx, err)
+	}
+	code, msg, err := c.text.ReadResponse(expect)
+	var protoErr *textproto.Error
+	if err != nil && !errors.As(err, &protoErr) {
+		err = contextError(ctx, err)
+	}
+	return code, msg, err
+}
+
+func (c *ftpConn) login(ctx context.Context, u *url.URL, opts Options) error {
+	stop := context.AfterFunc(ctx, func() { c.netConn.SetDeadline(time.Now()) })
+	_, _, err := c.text.ReadResponse(2)
+	stop()
+	if err != nil {
+		return fmt.Errorf("FTP server didn't greet: %w", contextError(ctx, err))
+	}
+
+	user, pass := u.User.Username(), password(u, opts)
+	if user == "" {
+		user, pass = "anonymous", "anonymous@"
+	}
+	code, _, err := c.cmd(ctx, 0, "USER %s", user)
+	if err == nil && code == 331 {
+		code, _, err = c.cmd(ctx, 0, "PASS %s", pass)
+	}
+	if err == nil && code != 230 && code != 202 {
+		err = fmt.Errorf("reply %d", code)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't log in to FTP server: %w", err)
+	}
+	_, _, err = c.cmd(ctx, 2, "TYPE I")
+	return err
+}
+
+// passive opens a data connection, with EPSV or else PASV. The address in
+// PASV's reply is ignored for the control connection's host, which is what
+// servers behind NAT need.
+func (c *ftpConn) passive(ctx context.Context) (net.Conn, error) {
+	port := 0
+	_, msg, err := c.cmd(ctx, 229, "EPSV")
+	if err == nil {
+		// "Entering Extended Passive Mode (|||port|)"
+		start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+		if start < 0 || end < start+4 {
+			return nil, fmt.Errorf("couldn't parse EPSV reply %q", msg)
+		}
+		port, err = strconv.Atoi(msg[start+4 : end])
+	} else {
+		_, msg, err = c.cmd(ctx, 227, "PASV")
+		if err != nil {
+			return nil, err
+		}
+		// "Entering Passive Mode (h1,h2,h3,h4,p1,p2)"
+		start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+		fields := []string{}
+		if start >= 0 && end > start {
+			fields = strings.Split(msg[start+1:end], ",")
+		}
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("couldn't parse PASV reply %q", msg)
+		}
+		high, err1 := strconv.Atoi(strings.TrimSpace(fields[4]))
+		low, err2 := strconv.Atoi(strings.TrimSpace(fields[5]))
+		port, err = high*256+low, errors.Join(err1, err2)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.dialer.DialContext(ctx, "tcp", net.JoinHostPort(c.host, strconv.Itoa(port)))
+}
+
+// transfer starts a command that sends its result over a data connection.
+func (c *ftpConn) transfer(ctx context.Context, format string, args ...any) (net.Conn, error) {
+	data, err := c.passive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_, _, err = c.cmd(ctx, 1, format, args...)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		data.SetDeadline(deadline)
+	}
+	return data, nil
+}
+
+// finishTransfer reads the reply sent once a data connection is done.
+func (c *ftpConn) finishTransfer(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { c.netConn.SetDeadline(time.Now()) })
+	defer stop()
+	_, _, err := c.text.ReadResponse(2)
+	return err
+}
+
+func (c *ftpConn) List(ctx context.Context, dir string) ([]File, error) {
+	if !c.noMLSD {
+		files, err := c.listMLSD(ctx, dir)
+		var protoErr *textproto.Error
+		if !errors.As(err, &protoErr) || protoErr.Code != 500 && protoErr.Code != 502 {
+			return files, err
+		}
+		c.noMLSD = true
+	}
+	return c.listNLST(ctx, dir)
+}
+
+// listMLSD lists with MLSD, whose facts say which entries are files.
+func (c *ftpConn) listMLSD(ctx context.Context, dir string) ([]File, error) {
+	data, err := c.transfer(ctx, "MLSD %s", dir)
+	if err != nil {
+		return nil, err
+	}
+	files := []File{}
+	scanner := bufio.NewScanner(data)
+	for scanner.Scan() {
+		// "type=file;size=1024;modify=20240102030405; name.mp4"
+		facts, name, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		file := File{Path: path.Join(dir, name)}
+		isFile := false
+		for _, fact := range strings.Split(facts, ";") {
+			key, value, _ := strings.Cut(fact, "=")
+			switch strings.ToLower(key) {
+			case "type":
+				isFile = strings.EqualFold(value, "file")
+			case "size":
+				file.Size, _ = strconv.ParseInt(value, 10, 64)
+			case "modify":
+				// Fractions of a second are dropped
+				value, _, _ = strings.Cut(value, ".")
+				file.ModTime, _ = time.Parse(ftpTimeFormat, value)
+			}
+		}
+		if isFile {
+			files = append(files, file)
+		}
+	}
+	data.Close()
+	err = errors.Join(scanner.Err(), c.finishTransfer(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list %s: %w", dir, err)
+	}
+	return files, nil
+}
+
+// listNLST lists with NLST and SIZE and MDTM for each name, for servers
+// without MLSD. Names without a size are taken to be directories.
+func (c *ftpConn) listNLST(ctx context.Context, dir string) ([]File, error) {
+	data, err := c.transfer(ctx, "NLST %s", dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	scanner := bufio.NewScanner(data)
+	for scanner.Scan() {
+		if name := strings.TrimSpace(scanner.Text()); name != "" {
+			names = append(names, name)
+		}
+	}
+	data.Close()
+	err = errors.Join(scanner.Err(), c.finishTransfer(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list %s: %w", dir, err)
+	}
+
+	files := []File{}
+	for _, name := range names {
+		// Some servers list bare names and others paths
+		file := File{Path: path.Join(dir, path.Base(name))}
+		_, msg, err := c.cmd(ctx, 213, "SIZE %s", file.Path)
+		if err != nil {
+			continue
+		}
+		file.Size, err = strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, msg, err := c.cmd(ctx, 213, "MDTM %s", file.Path); err == nil {
+			file.ModTime, _ = time.Parse(ftpTimeFormat, strings.TrimSpace(msg))
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func (c *ftpConn) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	data, err := c.transfer(ctx, "RETR %s", path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open %s: %w", path, err)
+	}
+	return &ftpFile{ctx: ctx, conn: c, data: data}, nil
+}
+
+// ftpFile is a download over a data connection.
+type ftpFile struct {
+	ctx    context.Context
+	conn   *ftpConn
+	data   net.Conn
+	eof    bool
+	closed bool
+}
+
+func (f *ftpFile) Read(p []byte) (int, error) {
+	n, err := f.data.Read(p)
+	if err == io.EOF {
+		f.eof = true
+	}
+	return n, err
+}
+
+// Close ends the transfer. The server's reply to one stopped early is an
+// error, so it's only checked for a file read to the end.
+func (f *ftpFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	f.data.Close()
+	err := f.conn.finishTransfer(f.ctx)
+	if !f.eof {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+// Package ingest pulls video files from servers partners drop them on, and
+// from the cloud storage accounts users link, so they can be imported.
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+// File is a regular file found on a source.
+type File struct {
+	// Path is absolute, with forward slashes
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Conn is a connection to a source, used by one goroutine at a time.
+type Conn interface {
+	// List returns the regular files in a directory, not descending into
+	// subdirectories.
+	List(ctx context.Context, dir string) ([]File, error)
+	// Open reads a file from the start. It must be closed before the
+	// connection is used again.
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+	Close() error
+}
+
+// Source is a server files are imported from.
+type Source interface {
+	Dial(ctx context.Context) (Conn, error)
+	// Scheme is the protocol, such as "sftp".
+	Scheme() string
+	// URL identifies a file on the source without its credentials, for
+	// recording where an import came from.
+	URL(path string) string
+}
+
+// Options are the credentials and checks for connecting to a source.
+// A password in the source's URL takes precedence over Password.
+type Options struct {
+	Password string
+	// PrivateKeyFile is a PEM private key to authenticate to SFTP servers
+	// with.
+	PrivateKeyFile string
+	// KnownHostsFile lists the SFTP host keys that are trusted. SFTP
+	// sources are rejected without one.
+	KnownHostsFile string
+	Timeout        time.Duration
+}
+
+// NewSource returns the source at a URL like "sftp://user@host/dir" or
+// "ftp://user@host/dir", and the directory it names.
+func NewSource(rawURL string, opts Options) (Source, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.Host == "" {
+		return nil, "", fmt.Errorf("no host in source URL %q", u.Redacted())
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	dir := u.Path
+	if dir == "" {
+		dir = "/"
+	}
+
+	switch u.Scheme {
+	case "sftp":
+		source, err := newSFTP(u, opts)
+		return source, dir, err
+	case "ftp":
+		return newFTP(u, opts), dir, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported source scheme %q, expected sftp or ftp", u.Scheme)
+	}
+}
+
+// password returns the password for a URL, from the URL if it has one.
+func password(u *url.URL, opts Options) string {
+	if p, ok := u.User.Password(); ok {
+		return p
+	}
+	return opts.Password
+}
+
+// fileURL returns a URL for a file on the server at u, without credentials
+// other than the user name.
+func fileURL(u *url.URL, path string) string {
+	file := url.URL{Scheme: u.Scheme, Host: u.Host, Path: path}
+	if u.User != nil {
+		file.User = url.User(u.User.Username())
+	}
+	return file.String()
+}
@@ -0,0 +1,451 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// The parts of version 3 of the SFTP protocol needed to list and read
+// files, from draft-ietf-secsh-filexfer-02.
+const (
+	sftpVersion = 3
+
+	sftpPacketInit     = 1
+	sftpPacketVersion  = 2
+	sftpPacketOpen     = 3
+	sftpPacketClose    = 4
+	sftpPacketRead     = 5
+	sftpPacketOpenDir  = 11
+	sftpPacketReadDir  = 12
+	sftpPacketStatus   = 101
+	sftpPacketHandle   = 102
+	sftpPacketData     = 103
+	sftpPacketName     = 104
+	sftpOpenRead       = 0x1
+	sftpAttrSize       = 0x1
+	sftpAttrUIDGID     = 0x2
+	sftpAttrPerms      = 0x4
+	sftpAttrTimes      = 0x8
+	sftpAttrExtended   = 0x80000000
+	sftpStatusOK       = 0
+	sftpStatusEOF      = 1
+	sftpStatusNoSuch   = 2
+	sftpModeType       = 0o170000
+	sftpModeRegular    = 0o100000
+	sftpMaxRead        = 32 << 10
+	sftpMaxPacket      = 256 << 10
+	sftpDefaultSSHPort = "22"
+)
+
+// SFTP reads files over SFTP, authenticating with a password or private
+// key and checking the server's host key against a known hosts file.
+type SFTP struct {
+	u      *url.URL
+	addr   string
+	config *ssh.ClientConfig
+}
+
+func newSFTP(u *url.URL, opts Options) (*SFTP, error) {
+	if u.User.Username() == "" {
+		return nil, errors.New("no user in SFTP source URL")
+	}
+	if opts.KnownHostsFile == "" {
+		return nil, errors.New("a known hosts file is needed to check the SFTP server's host key")
+	}
+	hostKeyCallback, err := knownhosts.New(opts.KnownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read known hosts: %w", err)
+	}
+
+	var auth []ssh.AuthMethod
+	if opts.PrivateKeyFile != "" {
+		pemBytes, err := os.ReadFile(opts.PrivateKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read private key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if p := password(u, opts); p != "" {
+		auth = append(auth, ssh.Password(p))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("no password or private key to authenticate to the SFTP server with")
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), sftpDefaultSSHPort)
+	}
+	return &SFTP{
+		u:    u,
+		addr: addr,
+		config: &ssh.ClientConfig{
+			User:            u.User.Username(),
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         opts.Timeout,
+		},
+	}, nil
+}
+
+func (s *SFTP) Scheme() string { return "sftp" }
+
+func (s *SFTP) URL(path string) string { return fileURL(s.u, path) }
+
+func (s *SFTP) Dial(ctx context.Context) (Conn, error) {
+	dialer := &net.Dialer{Timeout: s.config.Timeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to SFTP server: %w", err)
+	}
+	// The handshake has no context of its own
+	stop := context.AfterFunc(ctx, func() { netConn.Close() })
+	defer stop()
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, s.addr, s.config)
+	if err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("couldn't connect to SFTP server: %w", err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+
+	conn, err := startSFTP(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// sftpConn sends one request at a time, waiting for its response.
+type sftpConn struct {
+	client  *ssh.Client
+	session *ssh.Session
+	w       io.WriteCloser
+	r       *bufio.Reader
+	id      uint32
+}
+
+func startSFTP(client *ssh.Client) (*sftpConn, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	w, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	err = session.RequestSubsystem("sftp")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't start SFTP: %w", err)
+	}
+	c := &sftpConn{client: client, session: session, w: w, r: bufio.NewReader(r)}
+
+	// The version exchange is the one packet without a request ID
+	err = c.writePacket(sftpPacketInit, binary.BigEndian.AppendUint32(nil, sftpVersion))
+	if err != nil {
+		return nil, err
+	}
+	packetType, _, err := c.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	if packetType != sftpPacketVersion {
+		return nil, fmt.Errorf("unexpected SFTP packet %d instead of the version", packetType)
+	}
+	return c, nil
+}
+
+func (c *sftpConn) Close() error {
+	c.session.Close()
+	return c.client.Close()
+}
+
+func (c *sftpConn) writePacket(packetType byte, payload []byte) error {
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+1))
+	packet = append(packet, packetType)
+	_, err := c.w.Write(append(packet, payload...))
+	return err
+}
+
+func (c *sftpConn) readPacket() (byte, []byte, error) {
+	var length uint32
+	err := binary.Read(c.r, binary.BigEndian, &length)
+	if err != nil {
+		return 0, nil, err
+	}
+	if length < 1 || length > sftpMaxPacket {
+		return 0, nil, fmt.Errorf("invalid SFTP packet length %d", length)
+	}
+	packet := make([]byte, length)
+	_, err = io.ReadFull(c.r, packet)
+	if err != nil {
+		return 0, nil, err
+	}
+	return packet[0], packet[1:], nil
+}
+
+// request sends a request and returns the type and body of its response,
+// after the request ID. A status other than OK is returned as an error.
+func (c *sftpConn) request(ctx context.Context, packetType byte, payload []byte) (byte, *sftpBuffer, error) {
+	// A cancelled request can't be abandoned halfway, so the connection
+	// is closed instead
+	stop := context.AfterFunc(ctx, func() { c.client.Close() })
+	defer stop()
+
+	c.id++
+	err := c.writePacket(packetType, append(binary.BigEndian.AppendUint32(nil, c.id), payload...))
+	if err != nil {
+		return 0, nil, contextError(ctx, err)
+	}
+	responseType, response, err := c.readPacket()
+	if err != nil {
+		return 0, nil, contextError(ctx, err)
+	}
+	b := &sftpBuffer{b: response}
+	if id := b.uint32(); b.err == nil && id != c.id {
+		return 0, nil, fmt.Errorf("SFTP response to request %d instead of %d", id, c.id)
+	}
+	if responseType == sftpPacketStatus {
+		status := &StatusError{Code: b.uint32(), Message: b.string()}
+		if b.err != nil {
+			return 0, nil, b.err
+		}
+		if status.Code != sftpStatusOK {
+			return 0, nil, status
+		}
+	}
+	return responseType, b, b.err
+}
+
+// handle sends a request answered with a handle.
+func (c *sftpConn) handle(ctx context.Context, packetType byte, payload []byte) (string, error) {
+	responseType, b, err := c.request(ctx, packetType, payload)
+	if err != nil {
+		return "", err
+	}
+	if responseType != sftpPacketHandle {
+		return "", fmt.Errorf("unexpected SFTP packet %d instead of a handle", responseType)
+	}
+	handle := b.string()
+	return handle, b.err
+}
+
+func (c *sftpConn) closeHandle(ctx context.Context, handle string) error {
+	_, _, err := c.request(ctx, sftpPacketClose, appendString(nil, handle))
+	return err
+}
+
+func (c *sftpConn) List(ctx context.Context, dir string) ([]File, error) {
+	handle, err := c.handle(ctx, sftpPacketOpenDir, appendString(nil, dir))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open %s: %w", dir, err)
+	}
+	defer c.closeHandle(ctx, handle)
+
+	files := []File{}
+	for {
+		responseType, b, err := c.request(ctx, sftpPacketReadDir, appendString(nil, handle))
+		var status *StatusError
+		if errors.As(err, &status) && status.Code == sftpStatusEOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list %s: %w", dir, err)
+		}
+		if responseType != sftpPacketName {
+			return nil, fmt.Errorf("unexpected SFTP packet %d instead of names", responseType)
+		}
+		for count := b.uint32(); count > 0 && b.err == nil; count-- {
+			name := b.string()
+			b.string() // the ls -l style long name
+			attrs := b.attrs()
+			if name == "." || name == ".." || !attrs.regular {
+				continue
+			}
+			files = append(files, File{Path: path.Join(dir, name), Size: attrs.size, ModTime: attrs.modTime})
+		}
+		if b.err != nil {
+			return nil, b.err
+		}
+	}
+}
+
+func (c *sftpConn) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	payload := appendString(nil, path)
+	payload = binary.BigEndian.AppendUint32(payload, sftpOpenRead)
+	// No attributes
+	payload = binary.BigEndian.AppendUint32(payload, 0)
+	handle, err := c.handle(ctx, sftpPacketOpen, payload)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open %s: %w", path, err)
+	}
+	return &sftpFile{ctx: ctx, conn: c, handle: handle}, nil
+}
+
+// sftpFile reads a file in order, one block at a time.
+type sftpFile struct {
+	ctx    context.Context
+	conn   *sftpConn
+	handle string
+	offset uint64
+
+	closeOnce sync.Once
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	if len(p) > sftpMaxRead {
+		p = p[:sftpMaxRead]
+	}
+	payload := appendString(nil, f.handle)
+	payload = binary.BigEndian.AppendUint64(payload, f.offset)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(len(p)))
+	responseType, b, err := f.conn.request(f.ctx, sftpPacketRead, payload)
+	var status *StatusError
+	if errors.As(err, &status) && status.Code == sftpStatusEOF {
+		return 0, io.EOF
+	}
+	if err != nil {
+		return 0, err
+	}
+	if responseType != sftpPacketData {
+		return 0, fmt.Errorf("unexpected SFTP packet %d instead of data", responseType)
+	}
+	data := b.string()
+	if b.err != nil {
+		return 0, b.err
+	}
+	n := copy(p, data)
+	f.offset += uint64(n)
+	return n, nil
+}
+
+func (f *sftpFile) Close() error {
+	err := error(nil)
+	f.closeOnce.Do(func() {
+		err = f.conn.closeHandle(f.ctx, f.handle)
+	})
+	return err
+}
+
+// StatusError is an SFTP request's failure.
+type StatusError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("SFTP status %d: %s", e.Code, e.Message)
+}
+
+// Is makes a missing file fs.ErrNotExist.
+func (e *StatusError) Is(target error) bool {
+	return target == fs.ErrNotExist && e.Code == sftpStatusNoSuch
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// sftpBuffer reads the fields of a packet, keeping the first error.
+type sftpBuffer struct {
+	b   []byte
+	err error
+}
+
+func (b *sftpBuffer) next(n int) []byte {
+	if b.err != nil {
+		return nil
+	}
+	if n < 0 || len(b.b) < n {
+		b.err = errors.New("short SFTP packet")
+		return nil
+	}
+	field := b.b[:n]
+	b.b = b.b[n:]
+	return field
+}
+
+func (b *sftpBuffer) uint32() uint32 {
+	if field := b.next(4); field != nil {
+		return binary.BigEndian.Uint32(field)
+	}
+	return 0
+}
+
+func (b *sftpBuffer) uint64() uint64 {
+	if field := b.next(8); field != nil {
+		return binary.BigEndian.Uint64(field)
+	}
+	return 0
+}
+
+func (b *sftpBuffer) string() string {
+	n := b.uint32()
+	return string(b.next(int(n)))
+}
+
+type sftpAttrs struct {
+	size    int64
+	modTime time.Time
+	regular bool
+}
+
+func (b *sftpBuffer) attrs() sftpAttrs {
+	var attrs sftpAttrs
+	flags := b.uint32()
+	if flags&sftpAttrSize != 0 {
+		attrs.size = int64(b.uint64())
+	}
+	if flags&sftpAttrUIDGID != 0 {
+		b.uint32()
+		b.uint32()
+	}
+	// Without permissions the type isn't known, so it's taken to be a file
+	attrs.regular = true
+	if flags&sftpAttrPerms != 0 {
+		attrs.regular = b.uint32()&sftpModeType == sftpModeRegular
+	}
+	if flags&sftpAttrTimes != 0 {
+		b.uint32() // atime
+		attrs.modTime = time.Unix(int64(b.uint32()), 0).UTC()
+	}
+	if flags&sftpAttrExtended != 0 {
+		for count := b.uint32(); count > 0 && b.err == nil; count-- {
+			b.string()
+			b.string()
+		}
+	}
+	return attrs
+}
+
+// contextError prefers the context's error to the one its cancellation
+// caused.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
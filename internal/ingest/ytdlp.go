@@ -0,0 +1,201 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// StepYTDLP is the step reported to the yt-dlp observer.
+const StepYTDLP = "ytdlp"
+
+// ErrPrivateAddress is returned for URLs of hosts that aren't on the public
+// internet, which videos are never fetched from.
+var ErrPrivateAddress = errors.New("the host isn't a public address")
+
+// Downloader fetches videos from the pages of the sites it supports.
+type Downloader interface {
+	// Download saves the video on the page at rawURL, and its thumbnail if
+	// it has one, in dir.
+	Download(ctx context.Context, rawURL, dir string) (Download, error)
+}
+
+// Download is a video saved by a Downloader.
+type Download struct {
+	// VideoPath is an MP4 file
+	VideoPath string
+	// ThumbnailPath is a JPEG file, or empty when the page has no thumbnail
+	ThumbnailPath string
+	Info          PageInfo
+}
+
+// PageInfo is what a site says about a video.
+type PageInfo struct {
+	// Extractor is the site, such as "youtube"
+	Extractor   string
+	ID          string
+	Title       string
+	Description string
+	// WebpageURL is the canonical URL of the page
+	WebpageURL string
+	Tags       []string
+	// UploadedAt is zero when the site doesn't say
+	UploadedAt time.Time
+}
+
+// YTDLP downloads videos with yt-dlp.
+type YTDLP struct {
+	binary  string
+	maxSize int64
+	observe func(step string, err error)
+}
+
+// NewYTDLP runs the yt-dlp binary given, which is looked up in PATH unless
+// it's a path. Videos larger than maxSize aren't downloaded. observe, if not
+// nil, is called after every download with the error yt-dlp exited with.
+func NewYTDLP(binary string, maxSize int64, observe func(step string, err error)) *YTDLP {
+	return &YTDLP{binary: binary, maxSize: maxSize, observe: observe}
+}
+
+func (y *YTDLP) Download(ctx context.Context, rawURL, dir string) (Download, error) {
+	args := []string{
+		// Only the options here apply, whatever config the host has
+		"--ignore-config",
+		"--no-cache-dir",
+		"--quiet",
+		"--no-progress",
+		"--no-playlist",
+		"--no-mtime",
+		// The generic extractor fetches any page, so only sites yt-dlp
+		// knows are supported
+		"--use-extractors", "default,-generic",
+		"--match-filter", "!is_live",
+		"--max-filesize", strconv.FormatInt(y.maxSize, 10),
+		"--socket-timeout", "30",
+		"--format", "bv*[ext=mp4]+ba[ext=m4a]/b[ext=mp4]/bv*+ba/b",
+		"--merge-output-format", "mp4",
+		"--remux-video", "mp4",
+		"--write-info-json",
+		"--write-thumbnail",
+		"--convert-thumbnails", "jpg",
+		"--paths", dir,
+		"--output", "video.%(ext)s",
+		"--", rawURL,
+	}
+	cmd := exec.CommandContext(ctx, y.binary, args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if y.observe != nil {
+		y.observe(StepYTDLP, err)
+	}
+	if err != nil {
+		return Download{}, fmt.Errorf("failed to download with yt-dlp: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	download := Download{VideoPath: filepath.Join(dir, "video.mp4")}
+	info, err := os.Stat(download.VideoPath)
+	if err != nil {
+		// Live streams and videos over the size limit are skipped without an
+		// error
+		return Download{}, errors.New("yt-dlp didn't download a video, it may be live or too large")
+	}
+	if info.Size() > y.maxSize {
+		return Download{}, fmt.Errorf("video is larger than the maximum of %d bytes", y.maxSize)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "video.jpg")); err == nil {
+		download.ThumbnailPath = filepath.Join(dir, "video.jpg")
+	}
+	download.Info, err = readPageInfo(filepath.Join(dir, "video.info.json"))
+	if err != nil {
+		return Download{}, err
+	}
+	return download, nil
+}
+
+func readPageInfo(path string) (PageInfo, error) {
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return PageInfo{}, fmt.Errorf("failed to read yt-dlp info: %w", err)
+	}
+	var info struct {
+		Extractor   string   `json:"extractor_key"`
+		ID          string   `json:"id"`
+		Title       string   `json:"title"`
+		Description string   `json:"description"`
+		WebpageURL  string   `json:"webpage_url"`
+		Tags        []string `json:"tags"`
+		Timestamp   *int64   `json:"timestamp"`
+		UploadDate  string   `json:"upload_date"`
+	}
+	err = json.Unmarshal(dat, &info)
+	if err != nil {
+		return PageInfo{}, fmt.Errorf("failed to parse yt-dlp info: %w", err)
+	}
+
+	pageInfo := PageInfo{
+		Extractor:   strings.ToLower(info.Extractor),
+		ID:          info.ID,
+		Title:       info.Title,
+		Description: info.Description,
+		WebpageURL:  info.WebpageURL,
+		Tags:        info.Tags,
+	}
+	if info.Timestamp != nil {
+		pageInfo.UploadedAt = time.Unix(*info.Timestamp, 0).UTC()
+	} else if day, err := time.Parse("20060102", info.UploadDate); err == nil {
+		pageInfo.UploadedAt = day
+	}
+	return pageInfo, nil
+}
+
+// CheckPublicURL returns ErrPrivateAddress if the URL's host resolves to a
+// loopback, private, link-local or otherwise non-public address. The
+// downloader resolves the host again and follows redirects on its own, so
+// this keeps out mistakes and casual probing rather than a determined
+// attacker controlling DNS.
+func CheckPublicURL(ctx context.Context, u *url.URL) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return fmt.Errorf("couldn't resolve %s: %w", u.Hostname(), err)
+	}
+	for _, addr := range addrs {
+		if !isPublicIP(addr.IP) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+// PublicDialControl is a net.Dialer Control hook that refuses connections to
+// the addresses CheckPublicURL rejects. It sees the address after DNS
+// resolution, so a host that resolves differently by the time it's dialed
+// can't get around the check.
+func PublicDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
@@ -0,0 +1,73 @@
+package lock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Local locks within the process. It is the default for a single instance.
+type Local struct {
+	mu   sync.Mutex
+	held map[string]chan struct{}
+}
+
+func NewLocal() *Local {
+	return &Local{held: map[string]chan struct{}{}}
+}
+
+func (l *Local) Acquire(ctx context.Context, key string, ttl time.Duration) (Lock, error) {
+	for {
+		held, released := l.take(key)
+		if held != nil {
+			return held, nil
+		}
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ErrTimeout
+		}
+	}
+}
+
+func (l *Local) TryAcquire(ctx context.Context, key string, ttl time.Duration) (Lock, error) {
+	held, _ := l.take(key)
+	if held == nil {
+		return nil, nil
+	}
+	return held, nil
+}
+
+// take takes the lock for key if it's free. Otherwise it returns a channel
+// closed when the holder releases it.
+func (l *Local) take(key string) (*localLock, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if released, ok := l.held[key]; ok {
+		return nil, released
+	}
+	released := make(chan struct{})
+	l.held[key] = released
+	return &localLock{locker: l, key: key, released: released}, nil
+}
+
+type localLock struct {
+	locker   *Local
+	key      string
+	released chan struct{}
+	once     sync.Once
+}
+
+// Lost is never closed, since a local lock is held until it's released.
+func (l *localLock) Lost() <-chan struct{} {
+	return nil
+}
+
+func (l *localLock) Release() {
+	l.once.Do(func() {
+		l.locker.mu.Lock()
+		delete(l.locker.held, l.key)
+		l.locker.mu.Unlock()
+		close(l.released)
+	})
+}
@@ -0,0 +1,36 @@
+// Package lock provides mutual exclusion keyed by name, so that only one
+// goroutine, on any instance, works on the same resource at a time.
+package lock
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrTimeout is returned when a lock couldn't be acquired before the
+// context was done.
+var ErrTimeout = errors.New("timed out waiting for lock")
+
+// ErrLost is the cause of work stopped because its lock was lost.
+var ErrLost = errors.New("lock was lost")
+
+// Locker hands out locks by key.
+type Locker interface {
+	// Acquire blocks until it holds the lock for key or ctx is done. The
+	// lock is held until Release is called; ttl only bounds how long it
+	// outlives a process that died holding it.
+	Acquire(ctx context.Context, key string, ttl time.Duration) (Lock, error)
+	// TryAcquire takes the lock for key only if it is free, returning a
+	// nil Lock if it isn't.
+	TryAcquire(ctx context.Context, key string, ttl time.Duration) (Lock, error)
+}
+
+// Lock is a held lock.
+type Lock interface {
+	Release()
+	// Lost is closed if the lock stops being held before it's released,
+	// when it expired or was taken by another holder. Work relying on the
+	// lock should stop, since another holder may have started it too.
+	Lost() <-chan struct{}
+}
@@ -0,0 +1,141 @@
+package lock
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/redis/go-redis/v9"
+)
+
+// redisRetryInterval is how often a waiting Acquire tries again.
+const redisRetryInterval = 50 * time.Millisecond
+
+// Only the holder's token may release or extend a lock, so a holder whose
+// lock expired can't release the next holder's.
+var (
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+	extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+)
+
+// Redis locks across every instance sharing a Redis server. A held lock is
+// extended in the background until released, so sections longer than the
+// ttl stay exclusive; if its process dies, the lock expires after the ttl.
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedis(client *redis.Client, prefix string) *Redis {
+	return &Redis{client: client, prefix: prefix}
+}
+
+func (r *Redis) Acquire(ctx context.Context, key string, ttl time.Duration) (Lock, error) {
+	for {
+		l, err := r.TryAcquire(ctx, key, ttl)
+		if err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+		if l != nil {
+			return l, nil
+		}
+
+		select {
+		case <-time.After(redisRetryInterval):
+		case <-ctx.Done():
+			return nil, ErrTimeout
+		}
+	}
+}
+
+func (r *Redis) TryAcquire(ctx context.Context, key string, ttl time.Duration) (Lock, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(tokenBytes)
+	key = r.prefix + key
+
+	acquired, err := r.client.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock %s: %w", key, err)
+	}
+	if !acquired {
+		return nil, nil
+	}
+	l := &redisLock{client: r.client, key: key, token: token, done: make(chan struct{}), lost: make(chan struct{})}
+	go l.keepAlive(ttl)
+	return l, nil
+}
+
+type redisLock struct {
+	client *redis.Client
+	key    string
+	token  string
+	done   chan struct{}
+	lost   chan struct{}
+	once   sync.Once
+}
+
+// keepAlive extends the lock every third of its ttl. The lock is lost
+// when the key no longer holds its token, or when it couldn't be extended
+// for a whole ttl, by when it may have expired.
+func (l *redisLock) keepAlive(ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	extendedAt := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), ttl/3)
+			extended, err := extendScript.Run(ctx, l.client, []string{l.key}, l.token, ttl.Milliseconds()).Int64()
+			cancel()
+			switch {
+			case err == nil && extended == 0:
+				logging.Warnf("Lost lock %s, it expired or another holder took it", l.key)
+				close(l.lost)
+				return
+			case err != nil && time.Since(extendedAt) >= ttl:
+				logging.Warnf("Lost lock %s, it couldn't be extended for %s: %v", l.key, ttl, err)
+				close(l.lost)
+				return
+			case err != nil:
+				logging.Warnf("Couldn't extend lock %s: %v", l.key, err)
+			default:
+				extendedAt = time.Now()
+			}
+		case <-l.done:
+			return
+		}
+	}
+}
+
+func (l *redisLock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *redisLock) Release() {
+	l.once.Do(func() {
+		close(l.done)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := releaseScript.Run(ctx, l.client, []string{l.key}, l.token).Err()
+		if err != nil {
+			logging.Warnf("Couldn't release lock %s: %v", l.key, err)
+		}
+	})
+}
@@ -0,0 +1,102 @@
+// Package logging adds levels and sampling on top of the standard logger.
+// Lines are written with log.Output, so they go wherever log.SetOutput
+// points, in the standard format, with their level in front.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name, case-insensitively.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q, expected one of %s", s, strings.Join(levelNames, ", "))
+}
+
+var (
+	minLevel    atomic.Int32
+	sampleEvery atomic.Int64
+
+	sampleMu     sync.Mutex
+	sampleCounts = map[string]uint64{}
+)
+
+func init() {
+	minLevel.Store(int32(LevelInfo))
+	sampleEvery.Store(1)
+}
+
+// SetLevel drops lines below the level from then on.
+func SetLevel(l Level) {
+	minLevel.Store(int32(l))
+}
+
+func GetLevel() Level {
+	return Level(minLevel.Load())
+}
+
+// SetSampling keeps only the first of every n debug and info lines with the
+// same format string. n of 1 or less keeps every line. Warnings and errors
+// are never sampled.
+func SetSampling(n int) {
+	sampleEvery.Store(int64(n))
+}
+
+func GetSampling() int {
+	return int(sampleEvery.Load())
+}
+
+func Debugf(format string, args ...any) { logf(LevelDebug, format, args...) }
+func Infof(format string, args ...any)  { logf(LevelInfo, format, args...) }
+func Warnf(format string, args ...any)  { logf(LevelWarn, format, args...) }
+func Errorf(format string, args ...any) { logf(LevelError, format, args...) }
+
+func logf(l Level, format string, args ...any) {
+	if l < GetLevel() {
+		return
+	}
+	if l <= LevelInfo && !sampled(format) {
+		return
+	}
+	// Skip logf and the level function so the caller's file is reported
+	// when log.Lshortfile is set
+	log.Output(3, strings.ToUpper(l.String())+" "+fmt.Sprintf(format, args...))
+}
+
+func sampled(format string) bool {
+	every := uint64(sampleEvery.Load())
+	if every <= 1 {
+		return true
+	}
+	sampleMu.Lock()
+	defer sampleMu.Unlock()
+	count := sampleCounts[format]
+	sampleCounts[format] = count + 1
+	return count%every == 0
+}
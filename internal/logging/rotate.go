@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is a log file that's moved aside once it reaches maxBytes.
+// The current file is path, the newest old one path.1, and so on up to
+// path.<maxBackups>; older ones are deleted.
+type RotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func OpenRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	f.file, f.size = file, info.Size()
+	return f, nil
+}
+
+// Write rotates before a write that would take the file past maxBytes, so
+// a line is never split across files.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			// Keep logging to the current file rather than losing lines
+			fmt.Fprintf(os.Stderr, "Couldn't rotate log file %s: %v\n", f.path, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *RotatingFile) rotate() error {
+	if f.maxBackups < 1 {
+		if err := f.file.Truncate(0); err != nil {
+			return err
+		}
+		f.size = 0
+		return nil
+	}
+
+	os.Remove(f.backupPath(f.maxBackups))
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(f.backupPath(i), f.backupPath(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(f.path, f.backupPath(1)); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	f.file.Close()
+	f.file, f.size = file, 0
+	return nil
+}
+
+func (f *RotatingFile) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
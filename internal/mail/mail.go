@@ -0,0 +1,15 @@
+// Package mail sends plain text email to users.
+package mail
+
+import "context"
+
+// Message is one plain text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
@@ -0,0 +1,115 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// implicitTLSPort is the submission port that expects TLS from the first
+// byte rather than upgrading with STARTTLS.
+const implicitTLSPort = "465"
+
+// SMTP sends email through an SMTP server. Connections on port 465 use TLS
+// from the start; on other ports STARTTLS is used when the server offers
+// it. Credentials are only sent when a username is set.
+type SMTP struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func NewSMTP(host, port, username, password, from string) *SMTP {
+	return &SMTP{host: host, port: port, username: username, password: password, from: from}
+}
+
+func (m *SMTP) Send(ctx context.Context, message Message) error {
+	addr := net.JoinHostPort(m.host, m.port)
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	var err error
+	if m.port == implicitTLSPort {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: m.host}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't connect to SMTP server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if m.port != implicitTLSPort {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			err = client.StartTLS(&tls.Config{ServerName: m.host})
+			if err != nil {
+				return fmt.Errorf("couldn't start TLS: %w", err)
+			}
+		}
+	}
+	if m.username != "" {
+		err = client.Auth(smtp.PlainAuth("", m.username, m.password, m.host))
+		if err != nil {
+			return fmt.Errorf("couldn't authenticate: %w", err)
+		}
+	}
+
+	err = client.Mail(m.from)
+	if err != nil {
+		return err
+	}
+	err = client.Rcpt(message.To)
+	if err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(m.format(message))
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// format renders the message with its headers. Lines end in CRLF as SMTP
+// requires.
+func (m *SMTP) format(message Message) []byte {
+	domain := m.from[strings.LastIndex(m.from, "@")+1:]
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", message.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", uuid.New(), domain)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	body := strings.ReplaceAll(message.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,152 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Steps of processing reported to the FFmpeg observer.
+const (
+	StepProbe     = "probe"
+	StepFastStart = "faststart"
+)
+
+// FFmpeg probes with ffprobe and transcodes with ffmpeg.
+type FFmpeg struct {
+	ffprobe string
+	ffmpeg  string
+	observe func(step string, err error)
+}
+
+// NewFFmpeg runs the ffprobe and ffmpeg binaries given, which are looked up
+// in PATH unless they're paths. observe, if not nil, is called after every
+// probe and fast start remux with the error the command exited with.
+func NewFFmpeg(ffprobe, ffmpeg string, observe func(step string, err error)) *FFmpeg {
+	return &FFmpeg{ffprobe: ffprobe, ffmpeg: ffmpeg, observe: observe}
+}
+
+func (f *FFmpeg) observed(step string, err error) {
+	if f.observe != nil {
+		f.observe(step, err)
+	}
+}
+
+func (f *FFmpeg) Probe(ctx context.Context, path string) (ProbeResult, error) {
+	cmd := exec.CommandContext(ctx, f.ffprobe, "-v", "error", "-print_format", "json", "-show_streams", "-show_format", path)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	f.observed(StepProbe, err)
+	if err != nil {
+		return ProbeResult{}, fmt.Errorf("failed to run ffprobe: %w", err)
+	}
+
+	var result ProbeResult
+	err = json.Unmarshal(stdout.Bytes(), &result)
+	if err != nil {
+		return ProbeResult{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	return result, nil
+}
+
+func (f *FFmpeg) FastStart(ctx context.Context, path string, audioTracks []int, duration time.Duration, onProgress func(percent int)) (string, error) {
+	outputPath := path + ".processing"
+
+	args := []string{"-nostats", "-progress", "pipe:1", "-i", path, "-map", "0:v?"}
+	if len(audioTracks) == 0 {
+		args = append(args, "-map", "0:a?")
+	}
+	for _, index := range audioTracks {
+		args = append(args, "-map", fmt.Sprintf("0:a:%d", index))
+	}
+	args = append(args, "-c", "copy", "-movflags", "faststart", "-f", "mp4", outputPath)
+
+	// Machine-readable progress is reported on stdout
+	cmd := exec.CommandContext(ctx, f.ffmpeg, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", fmt.Errorf("failed to capture ffmpeg progress: %w", err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		f.observed(StepFastStart, err)
+		return "", fmt.Errorf("failed to process video with ffmpeg: %w", err)
+	}
+	readProgress(stdout, duration, onProgress)
+	err = cmd.Wait()
+	f.observed(StepFastStart, err)
+	if err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("failed to process video with ffmpeg: %w", err)
+	}
+	return outputPath, nil
+}
+
+func (f *FFmpeg) ExtractFrame(ctx context.Context, path string, offset time.Duration, outputPath string) error {
+	cmd := exec.CommandContext(ctx, f.ffmpeg, "-v", "error", "-y", "-ss", fmt.Sprintf("%.3f", offset.Seconds()), "-i", path, "-frames:v", "1", "-q:v", "2", outputPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("failed to extract frame with ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (f *FFmpeg) ExtractAudio(ctx context.Context, path string) (string, error) {
+	outputPath := path + ".wav"
+	cmd := exec.CommandContext(ctx, f.ffmpeg, "-v", "error", "-y", "-i", path, "-map", "0:a:0", "-vn", "-ac", "1", "-ar", "16000", "-c:a", "pcm_s16le", outputPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("failed to extract audio with ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return outputPath, nil
+}
+
+// readProgress consumes the key=value stream written by "ffmpeg -progress"
+// until it is closed.
+func readProgress(r io.Reader, duration time.Duration, onProgress func(percent int)) {
+	lastPercent := -1
+	report := func(percent int) {
+		if onProgress == nil || percent == lastPercent {
+			return
+		}
+		lastPercent = percent
+		onProgress(percent)
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_us":
+			outTime, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || outTime < 0 || duration <= 0 {
+				continue
+			}
+			percent := int(time.Duration(outTime) * time.Microsecond * 100 / duration)
+			report(min(percent, 99))
+		case "progress":
+			if value == "end" {
+				report(100)
+			}
+		}
+	}
+	// Drain anything left so ffmpeg never blocks on a full pipe
+	io.Copy(io.Discard, r)
+}
@@ -0,0 +1,112 @@
+// Package media reads and rewrites video files.
+package media
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stream is one stream of a media file, as ffprobe describes it.
+type Stream struct {
+	Index     int    `json:"index"`
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Channels  int    `json:"channels"`
+	// SampleAspectRatio is "N:D", e.g. "4:3" for anamorphic 1440x1080
+	SampleAspectRatio string `json:"sample_aspect_ratio"`
+	// Color metadata used to detect HDR
+	ColorTransfer string `json:"color_transfer"`
+	SideDataList  []struct {
+		SideDataType string `json:"side_data_type"`
+	} `json:"side_data_list"`
+	Tags struct {
+		Language string `json:"language"`
+		Title    string `json:"title"`
+	} `json:"tags"`
+	Disposition struct {
+		Default int `json:"default"`
+	} `json:"disposition"`
+}
+
+// ProbeResult is the streams and container format of a media file.
+type ProbeResult struct {
+	Streams []Stream `json:"streams"`
+	Format  struct {
+		Duration string `json:"duration"`
+		// Tags are the container's metadata, e.g. "title" or "comment".
+		// Their case depends on the muxer that wrote them.
+		Tags map[string]string `json:"tags"`
+	} `json:"format"`
+}
+
+// Prober reads the streams and container format of a video file.
+type Prober interface {
+	Probe(ctx context.Context, path string) (ProbeResult, error)
+}
+
+// Transcoder writes new files from a video file.
+type Transcoder interface {
+	// FastStart remuxes the file with the moov atom up front, keeping every
+	// video stream and the audio tracks listed in audioTracks (all of them
+	// when it is empty), and returns the path of the new file. onProgress
+	// is called with a 0-100 percentage as the remux goes; it is only
+	// called once the end is reached when the duration is unknown.
+	FastStart(ctx context.Context, path string, audioTracks []int, duration time.Duration, onProgress func(percent int)) (string, error)
+	// ExtractFrame writes a single JPEG frame taken at offset into
+	// outputPath.
+	ExtractFrame(ctx context.Context, path string, offset time.Duration, outputPath string) error
+	// ExtractAudio writes the first audio track to a temporary 16 kHz mono
+	// WAV file, the input speech recognizers expect, and returns its path.
+	ExtractAudio(ctx context.Context, path string) (string, error)
+}
+
+// Duration returns the container duration, or zero if it is unknown.
+func (r ProbeResult) Duration() time.Duration {
+	seconds, err := strconv.ParseFloat(r.Format.Duration, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// VideoStream returns the first video stream, if any.
+func (r ProbeResult) VideoStream() (Stream, bool) {
+	for _, stream := range r.Streams {
+		if stream.CodecType == "video" {
+			return stream, true
+		}
+	}
+	return Stream{}, false
+}
+
+// FormatTag returns the first non-empty container tag among names, matched
+// case-insensitively, with surrounding whitespace removed.
+func (r ProbeResult) FormatTag(names ...string) string {
+	for _, name := range names {
+		for key, value := range r.Format.Tags {
+			if strings.EqualFold(key, name) && strings.TrimSpace(value) != "" {
+				return strings.TrimSpace(value)
+			}
+		}
+	}
+	return ""
+}
+
+// SampleAspect parses the "N:D" sample aspect ratio. Missing or unknown
+// ("0:1") values are reported as square pixels.
+func (s Stream) SampleAspect() (num, den int) {
+	numString, denString, ok := strings.Cut(s.SampleAspectRatio, ":")
+	if !ok {
+		return 1, 1
+	}
+	num, errNum := strconv.Atoi(numString)
+	den, errDen := strconv.Atoi(denString)
+	if errNum != nil || errDen != nil || num <= 0 || den <= 0 {
+		return 1, 1
+	}
+	return num, den
+}
@@ -0,0 +1,77 @@
+package media
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbeResult(t *testing.T) {
+	var result ProbeResult
+	err := json.Unmarshal([]byte(`{
+		"streams": [
+			{"index": 0, "codec_type": "audio", "codec_name": "aac", "channels": 2},
+			{"index": 1, "codec_type": "video", "codec_name": "h264", "width": 1440, "height": 1080, "sample_aspect_ratio": "4:3"}
+		],
+		"format": {"duration": "12.500000", "tags": {"TITLE": "  Holiday  ", "comment": ""}}
+	}`), &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := result.Duration(); got != 12500*time.Millisecond {
+		t.Errorf("Duration() = %s, want 12.5s", got)
+	}
+	stream, ok := result.VideoStream()
+	if !ok || stream.Index != 1 {
+		t.Fatalf("VideoStream() = %+v, %v, want stream 1", stream, ok)
+	}
+	if num, den := stream.SampleAspect(); num != 4 || den != 3 {
+		t.Errorf("SampleAspect() = %d:%d, want 4:3", num, den)
+	}
+	if got := result.FormatTag("title"); got != "Holiday" {
+		t.Errorf("FormatTag(title) = %q, want Holiday", got)
+	}
+	if got := result.FormatTag("comment", "description"); got != "" {
+		t.Errorf("FormatTag(comment, description) = %q, want none", got)
+	}
+}
+
+func TestSampleAspectUnknown(t *testing.T) {
+	for _, value := range []string{"", "0:1", "N/A", "4:0"} {
+		if num, den := (Stream{SampleAspectRatio: value}).SampleAspect(); num != 1 || den != 1 {
+			t.Errorf("SampleAspect() of %q = %d:%d, want 1:1", value, num, den)
+		}
+	}
+}
+
+func TestReadProgress(t *testing.T) {
+	output := strings.Join([]string{
+		"out_time_us=1000000",
+		"progress=continue",
+		"out_time_us=1000000",
+		"out_time_us=5000000",
+		"out_time_us=N/A",
+		"out_time_us=9999999",
+		"progress=end",
+	}, "\n")
+
+	var reported []int
+	readProgress(strings.NewReader(output), 10*time.Second, func(percent int) {
+		reported = append(reported, percent)
+	})
+	// Repeats aren't reported, and 100 waits for the end
+	if want := []int{10, 50, 99, 100}; !slices.Equal(reported, want) {
+		t.Errorf("reported %v, want %v", reported, want)
+	}
+
+	reported = nil
+	readProgress(strings.NewReader(output), 0, func(percent int) {
+		reported = append(reported, percent)
+	})
+	if want := []int{100}; !slices.Equal(reported, want) {
+		t.Errorf("without a duration, reported %v, want %v", reported, want)
+	}
+}
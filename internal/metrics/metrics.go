@@ -0,0 +1,270 @@
+// Package metrics keeps in-process counters and histograms and renders them
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type metric interface {
+	write(w io.Writer)
+}
+
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// ServeHTTP writes every registered metric in registration order.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	name, help string
+	labels     string
+
+	mu    sync.Mutex
+	value float64
+}
+
+func (r *Registry) NewCounter(name, help string) *Counter {
+	c := &Counter{name: name, help: help}
+	r.register(c)
+	return c
+}
+
+func (c *Counter) Add(v float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value += v
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.writeSamples(w)
+}
+
+func (c *Counter) writeSamples(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "%s%s %s\n", c.name, wrapLabels(c.labels), formatFloat(c.value))
+}
+
+// Histogram counts observations into cumulative buckets by upper bound.
+type Histogram struct {
+	name, help string
+	labels     string
+	buckets    []float64
+
+	mu     sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := newHistogram(name, help, "", buckets)
+	r.register(h)
+	return h
+}
+
+func newHistogram(name, help, labels string, buckets []float64) *Histogram {
+	return &Histogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.writeSamples(w)
+}
+
+func (h *Histogram) writeSamples(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	prefix := h.labels
+	if prefix != "" {
+		prefix += ","
+	}
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", h.name, prefix, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", h.name, prefix, h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", h.name, wrapLabels(h.labels), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", h.name, wrapLabels(h.labels), h.count)
+}
+
+// vec holds one child metric per combination of label values, created on
+// first use.
+type vec[T any] struct {
+	name, help, kind string
+	labelNames       []string
+	newChild         func(labels string) T
+	writeChild       func(w io.Writer, child T)
+
+	mu       sync.Mutex
+	children map[string]T
+}
+
+func (v *vec[T]) with(values []string) T {
+	if len(values) != len(v.labelNames) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.name, len(v.labelNames), len(values)))
+	}
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = fmt.Sprintf("%s=%s", v.labelNames[i], strconv.Quote(value))
+	}
+	labels := strings.Join(pairs, ",")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	child, ok := v.children[labels]
+	if !ok {
+		child = v.newChild(labels)
+		v.children[labels] = child
+	}
+	return child
+}
+
+// write renders children sorted by their labels so output is stable.
+func (v *vec[T]) write(w io.Writer) {
+	v.mu.Lock()
+	labels := make([]string, 0, len(v.children))
+	for l := range v.children {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	children := make([]T, len(labels))
+	for i, l := range labels {
+		children[i] = v.children[l]
+	}
+	v.mu.Unlock()
+
+	writeHeader(w, v.name, v.help, v.kind)
+	for _, child := range children {
+		v.writeChild(w, child)
+	}
+}
+
+// CounterVec is a family of counters told apart by label values.
+type CounterVec struct {
+	vec[*Counter]
+}
+
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{vec[*Counter]{
+		name:       name,
+		help:       help,
+		kind:       "counter",
+		labelNames: labelNames,
+		newChild: func(labels string) *Counter {
+			return &Counter{name: name, help: help, labels: labels}
+		},
+		writeChild: func(w io.Writer, child *Counter) { child.writeSamples(w) },
+		children:   map[string]*Counter{},
+	}}
+	r.register(c)
+	return c
+}
+
+// With returns the counter for the label values, given in the order the
+// label names were.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.with(values)
+}
+
+// HistogramVec is a family of histograms with the same buckets, told apart
+// by label values.
+type HistogramVec struct {
+	vec[*Histogram]
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{vec[*Histogram]{
+		name:       name,
+		help:       help,
+		kind:       "histogram",
+		labelNames: labelNames,
+		newChild: func(labels string) *Histogram {
+			return newHistogram(name, help, labels, buckets)
+		},
+		writeChild: func(w io.Writer, child *Histogram) { child.writeSamples(w) },
+		children:   map[string]*Histogram{},
+	}}
+	r.register(h)
+	return h
+}
+
+// With returns the histogram for the label values, given in the order the
+// label names were.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.with(values)
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
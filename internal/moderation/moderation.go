@@ -0,0 +1,46 @@
+// Package moderation decides whether uploaded content may be published.
+package moderation
+
+import "context"
+
+// Input is what a moderator gets to look at for one video.
+type Input struct {
+	Title       string
+	Description string
+	// Frames are JPEG images sampled evenly across the video.
+	Frames [][]byte
+}
+
+// Label is one reason content was flagged, with the provider's confidence
+// as a percentage.
+type Label struct {
+	Name       string  `json:"name"`
+	Confidence float64 `json:"confidence"`
+}
+
+// Result is a moderator's verdict. Flagged content is held for review.
+type Result struct {
+	Flagged bool
+	Labels  []Label
+}
+
+type Moderator interface {
+	ModerateVideo(ctx context.Context, input Input) (Result, error)
+}
+
+// ImageClassifier checks a single image, such as a thumbnail, for content
+// that shouldn't be published without review.
+type ImageClassifier interface {
+	ClassifyImage(ctx context.Context, image []byte) (Result, error)
+}
+
+// Noop approves everything.
+type Noop struct{}
+
+func (Noop) ModerateVideo(ctx context.Context, input Input) (Result, error) {
+	return Result{}, nil
+}
+
+func (Noop) ClassifyImage(ctx context.Context, image []byte) (Result, error) {
+	return Result{}, nil
+}
@@ -0,0 +1,67 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rekognition"
+	"github.com/aws/aws-sdk-go-v2/service/rekognition/types"
+)
+
+// Rekognition flags images, and videos by their frames, that AWS Rekognition
+// finds moderation labels in. Video titles and descriptions aren't checked.
+type Rekognition struct {
+	client *rekognition.Client
+	// minConfidence is the percentage below which labels are ignored.
+	minConfidence float32
+}
+
+func NewRekognition(client *rekognition.Client, minConfidence float32) *Rekognition {
+	return &Rekognition{client: client, minConfidence: minConfidence}
+}
+
+func (m *Rekognition) ModerateVideo(ctx context.Context, input Input) (Result, error) {
+	// Keep the highest confidence seen for each label across frames
+	confidence := map[string]float64{}
+	var order []string
+	for i, frame := range input.Frames {
+		frameResult, err := m.ClassifyImage(ctx, frame)
+		if err != nil {
+			return Result{}, fmt.Errorf("failed to moderate frame %d: %w", i, err)
+		}
+		for _, label := range frameResult.Labels {
+			if _, ok := confidence[label.Name]; !ok {
+				order = append(order, label.Name)
+			}
+			confidence[label.Name] = max(confidence[label.Name], label.Confidence)
+		}
+	}
+
+	result := Result{Flagged: len(order) > 0}
+	for _, name := range order {
+		result.Labels = append(result.Labels, Label{Name: name, Confidence: confidence[name]})
+	}
+	return result, nil
+}
+
+func (m *Rekognition) ClassifyImage(ctx context.Context, image []byte) (Result, error) {
+	output, err := m.client.DetectModerationLabels(ctx, &rekognition.DetectModerationLabelsInput{
+		Image:         &types.Image{Bytes: image},
+		MinConfidence: aws.Float32(m.minConfidence),
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	var result Result
+	for _, label := range output.ModerationLabels {
+		name := aws.ToString(label.Name)
+		if name == "" {
+			continue
+		}
+		result.Labels = append(result.Labels, Label{Name: name, Confidence: float64(aws.ToFloat32(label.Confidence))})
+	}
+	result.Flagged = len(result.Labels) > 0
+	return result, nil
+}
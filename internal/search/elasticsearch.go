@@ -0,0 +1,194 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Elasticsearch keeps the index in an Elasticsearch (or OpenSearch) cluster
+// shared by every instance. Changes become searchable after the cluster's
+// refresh interval, a second by default.
+type Elasticsearch struct {
+	client  *http.Client
+	baseURL string
+	index   string
+	// apiKey is optional for clusters that don't require one.
+	apiKey string
+}
+
+func NewElasticsearch(client *http.Client, baseURL, index, apiKey string) *Elasticsearch {
+	return &Elasticsearch{client: client, baseURL: strings.TrimSuffix(baseURL, "/"), index: index, apiKey: apiKey}
+}
+
+// elasticsearchMappings are applied when EnsureIndex creates the index.
+const elasticsearchMappings = `{
+	"mappings": {
+		"properties": {
+			"title": {"type": "text"},
+			"description": {"type": "text"},
+			"tags": {"type": "text"}
+		}
+	}
+}`
+
+type elasticsearchDocument struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+}
+
+// EnsureIndex creates the index with its mappings if it doesn't exist yet.
+func (e *Elasticsearch) EnsureIndex(ctx context.Context) error {
+	resp, err := e.do(ctx, http.MethodHead, "", nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("Elasticsearch returned %s checking index %s", resp.Status, e.index)
+	}
+
+	resp, err = e.do(ctx, http.MethodPut, "", []byte(elasticsearchMappings))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkElasticsearchResponse(resp)
+}
+
+func (e *Elasticsearch) Put(ctx context.Context, doc Document) error {
+	tags := doc.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	dat, err := json.Marshal(elasticsearchDocument{
+		Title:       doc.Title,
+		Description: doc.Description,
+		Tags:        tags,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := e.do(ctx, http.MethodPut, "/_doc/"+doc.ID.String(), dat)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkElasticsearchResponse(resp)
+}
+
+func (e *Elasticsearch) Delete(ctx context.Context, id uuid.UUID) error {
+	resp, err := e.do(ctx, http.MethodDelete, "/_doc/"+id.String(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return checkElasticsearchResponse(resp)
+}
+
+func (e *Elasticsearch) Search(ctx context.Context, query string, offset, limit int) ([]Hit, error) {
+	type response struct {
+		Hits struct {
+			Hits []struct {
+				ID    string  `json:"_id"`
+				Score float64 `json:"_score"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+
+	// most_fields adds up the matches in each field, weighted like Memory
+	// weights them
+	dat, err := json.Marshal(map[string]any{
+		"from":    offset,
+		"size":    limit,
+		"_source": false,
+		"query": map[string]any{
+			"multi_match": map[string]any{
+				"query": query,
+				"type":  "most_fields",
+				"fields": []string{
+					fmt.Sprintf("title^%d", titleBoost),
+					fmt.Sprintf("tags^%d", tagsBoost),
+					fmt.Sprintf("description^%d", descriptionBoost),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := e.do(ctx, http.MethodPost, "/_search", dat)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkElasticsearchResponse(resp); err != nil {
+		return nil, err
+	}
+
+	var output response
+	err = json.NewDecoder(resp.Body).Decode(&output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Elasticsearch response: %w", err)
+	}
+	hits := make([]Hit, 0, len(output.Hits.Hits))
+	for _, hit := range output.Hits.Hits {
+		id, err := uuid.Parse(hit.ID)
+		if err != nil {
+			continue
+		}
+		hits = append(hits, Hit{ID: id, Score: hit.Score})
+	}
+	return hits, nil
+}
+
+func (e *Elasticsearch) Clear(ctx context.Context) error {
+	body := []byte(`{"query": {"match_all": {}}}`)
+	resp, err := e.do(ctx, http.MethodPost, "/_delete_by_query?conflicts=proceed&refresh=true", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkElasticsearchResponse(resp)
+}
+
+// do sends a request about the index to path under it.
+func (e *Elasticsearch) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, e.baseURL+"/"+url.PathEscape(e.index)+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+e.apiKey)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Elasticsearch: %w", err)
+	}
+	return resp, nil
+}
+
+func checkElasticsearchResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	return fmt.Errorf("Elasticsearch returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
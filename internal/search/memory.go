@@ -0,0 +1,179 @@
+package search
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// BM25 parameters: k1 limits how much repeating a word helps, and b how
+// much longer fields are penalized.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Index fields, in the order memoryDocument keeps them.
+const (
+	fieldTitle = iota
+	fieldTags
+	fieldDescription
+	fieldCount
+)
+
+var fieldBoosts = [fieldCount]float64{titleBoost, tagsBoost, descriptionBoost}
+
+// Memory is an inverted index kept in this process and ranked with BM25.
+// Nothing is saved, so it has to be filled again on every start, and each
+// instance keeps its own.
+type Memory struct {
+	mu   sync.RWMutex
+	docs map[uuid.UUID]memoryDocument
+	// postings lists the documents each word appears in, in any field
+	postings map[string]map[uuid.UUID]struct{}
+	// fieldLengths sums each field's word count over every document
+	fieldLengths [fieldCount]int
+}
+
+// memoryDocument counts the words in each of a document's fields.
+type memoryDocument struct {
+	terms   [fieldCount]map[string]int
+	lengths [fieldCount]int
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		docs:     map[uuid.UUID]memoryDocument{},
+		postings: map[string]map[uuid.UUID]struct{}{},
+	}
+}
+
+func (m *Memory) Put(ctx context.Context, doc Document) error {
+	var indexed memoryDocument
+	fields := [fieldCount]string{doc.Title, strings.Join(doc.Tags, " "), doc.Description}
+	for i, text := range fields {
+		indexed.terms[i] = map[string]int{}
+		for _, term := range tokenize(text) {
+			indexed.terms[i][term]++
+			indexed.lengths[i]++
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(doc.ID)
+	m.docs[doc.ID] = indexed
+	for i, terms := range indexed.terms {
+		m.fieldLengths[i] += indexed.lengths[i]
+		for term := range terms {
+			if m.postings[term] == nil {
+				m.postings[term] = map[uuid.UUID]struct{}{}
+			}
+			m.postings[term][doc.ID] = struct{}{}
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.remove(id)
+	return nil
+}
+
+// remove takes a document out of the index. The caller holds the lock.
+func (m *Memory) remove(id uuid.UUID) {
+	doc, ok := m.docs[id]
+	if !ok {
+		return
+	}
+	for i, terms := range doc.terms {
+		m.fieldLengths[i] -= doc.lengths[i]
+		for term := range terms {
+			delete(m.postings[term], id)
+			if len(m.postings[term]) == 0 {
+				delete(m.postings, term)
+			}
+		}
+	}
+	delete(m.docs, id)
+}
+
+func (m *Memory) Search(ctx context.Context, query string, offset, limit int) ([]Hit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	total := float64(len(m.docs))
+	var avgLengths [fieldCount]float64
+	for i, length := range m.fieldLengths {
+		if total > 0 {
+			avgLengths[i] = float64(length) / total
+		}
+	}
+
+	scores := map[uuid.UUID]float64{}
+	seen := map[string]bool{}
+	for _, term := range tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		matches := m.postings[term]
+		if len(matches) == 0 {
+			continue
+		}
+		n := float64(len(matches))
+		idf := math.Log(1 + (total-n+0.5)/(n+0.5))
+		for id := range matches {
+			doc := m.docs[id]
+			for i := range fieldCount {
+				tf := float64(doc.terms[i][term])
+				if tf == 0 {
+					continue
+				}
+				norm := 1 - bm25B
+				if avgLengths[i] > 0 {
+					norm += bm25B * float64(doc.lengths[i]) / avgLengths[i]
+				}
+				scores[id] += fieldBoosts[i] * idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, Hit{ID: id, Score: score})
+	}
+	// Ties are broken by ID so pages don't shift between requests
+	slices.SortFunc(hits, func(a, b Hit) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.ID.String(), b.ID.String())
+	})
+	if offset >= len(hits) {
+		return []Hit{}, nil
+	}
+	hits = hits[offset:]
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+func (m *Memory) Clear(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.docs = map[uuid.UUID]memoryDocument{}
+	m.postings = map[string]map[uuid.UUID]struct{}{}
+	m.fieldLengths = [fieldCount]int{}
+	return nil
+}
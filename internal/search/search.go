@@ -0,0 +1,54 @@
+// Package search indexes the text of published videos so they can be found
+// by relevance.
+package search
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// Document is what's indexed about one video.
+type Document struct {
+	ID          uuid.UUID
+	Title       string
+	Description string
+	Tags        []string
+}
+
+// Hit is one matching video, with a score that only means something
+// relative to the other hits of the same search.
+type Hit struct {
+	ID    uuid.UUID
+	Score float64
+}
+
+// Field weights: a match in the title counts for more than one in the tags,
+// which counts for more than one in the description.
+const (
+	titleBoost       = 3
+	tagsBoost        = 2
+	descriptionBoost = 1
+)
+
+type Index interface {
+	// Put adds a document, or replaces the one with the same ID.
+	Put(ctx context.Context, doc Document) error
+	// Delete removes a document. Deleting one that isn't there isn't an
+	// error.
+	Delete(ctx context.Context, id uuid.UUID) error
+	// Search returns up to limit hits matching any word of the query, best
+	// first, after skipping offset of them.
+	Search(ctx context.Context, query string, offset, limit int) ([]Hit, error)
+	// Clear removes every document, ahead of indexing them all again.
+	Clear(ctx context.Context) error
+}
+
+// tokenize splits text into lowercase words.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
@@ -2,22 +2,48 @@ package main
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
 )
 
+// errorResponse is the body of every error response. Code is one of the
+// errCode* values. Fields is only set when the error is about specific request
+// parameters.
+type errorResponse struct {
+	Error  string       `json:"error"`
+	Code   errorCode    `json:"code"`
+	Fields []fieldError `json:"fields,omitempty"`
+}
+
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
-	if err != nil {
-		log.Println(err)
-	}
-	if code > 499 {
-		log.Printf("Responding with 5XX error: %s", msg)
+	respondWithErrorCode(w, code, defaultErrorCode(code), msg, err)
+}
+
+// respondWithErrorCode responds with a more specific error code than the
+// status implies. The message is translated for the client; the code never is.
+func respondWithErrorCode(w http.ResponseWriter, status int, errCode errorCode, msg string, err error) {
+	// Client errors are routine, so their cause is only logged at debug level
+	if status > 499 {
+		logging.Errorf("Responding with 5XX error: %s: %v", msg, err)
+		reportResponseError(w, msg, err)
+	} else if err != nil {
+		logging.Debugf("Responding with %d error: %s: %v", status, msg, err)
 	}
-	type errorResponse struct {
-		Error string `json:"error"`
+	respondWithJSON(w, status, errorResponse{
+		Error: localize(w, msg),
+		Code:  errCode,
+	})
+}
+
+func respondWithFieldErrors(w http.ResponseWriter, code int, msg string, fields []fieldError) {
+	for i := range fields {
+		fields[i].Message = localize(w, fields[i].Message)
 	}
 	respondWithJSON(w, code, errorResponse{
-		Error: msg,
+		Error:  localize(w, msg),
+		Code:   errCodeInvalidParameters,
+		Fields: fields,
 	})
 }
 
@@ -25,7 +51,7 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	dat, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("Error marshalling JSON: %s", err)
+		logging.Errorf("Error marshalling JSON: %s", err)
 		w.WriteHeader(500)
 		return
 	}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// checkNotOnLegalHold refuses to change a video under legal hold. It returns
+// false when it has responded.
+func checkNotOnLegalHold(w http.ResponseWriter, video database.Video) bool {
+	if !video.LegalHold {
+		return true
+	}
+	respondWithErrorCode(w, http.StatusConflict, errCodeLegalHold, "Video is under legal hold", nil)
+	return false
+}
+
+// handlerAdminVideoLegalHold places a video under legal hold or lifts it.
+// Only admins can change the hold; owners see it on the video and have
+// their deletes and replacements refused.
+func (cfg *apiConfig) handlerAdminVideoLegalHold(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		LegalHold *bool `json:"legal_hold"`
+	}
+
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	params := parameters{}
+	err = json.NewDecoder(r.Body).Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.LegalHold == nil {
+		respondWithError(w, http.StatusBadRequest, "legal_hold is required", nil)
+		return
+	}
+
+	// Wait out any change already in progress
+	videoLock, ok := cfg.lockVideo(w, videoID)
+	if !ok {
+		return
+	}
+	defer videoLock.Release()
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil || video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		return
+	}
+
+	if video.LegalHold != *params.LegalHold {
+		err = cfg.db.SetVideoLegalHold(video.ID, *params.LegalHold)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+		video.LegalHold = *params.LegalHold
+
+		action := database.AuditActionLegalHoldLifted
+		if video.LegalHold {
+			action = database.AuditActionLegalHoldPlaced
+		}
+		err = cfg.db.CreateAuditEvent(database.CreateAuditEventParams{
+			Actor:     "admin",
+			Action:    action,
+			SubjectID: video.ID,
+		})
+		if err != nil {
+			logging.Warnf("Couldn't record audit event for legal hold on video %s: %v", video.ID, err)
+		}
+	}
+
+	signedVideo, err := cfg.dbVideoToSignedVideo(video, clientRegionHint(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't generate presigned URL", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, signedVideo)
+}
@@ -0,0 +1,770 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Directories under the local storage root that aren't buckets. Bucket
+// names can't start with a dot.
+const (
+	localMetaDir    = ".meta"
+	localUploadsDir = ".uploads"
+)
+
+// localStorage is objectStorage on the filesystem, so the whole upload and
+// processing flow works offline. Objects are stored under
+// root/<bucket>/<key>, with their headers and checksum in root/.meta, and
+// the parts of multipart uploads under root/.uploads until they're
+// completed. Presigned URLs point at the app itself, which checks their
+// signature and serves or accepts the object.
+type localStorage struct {
+	root    string
+	baseURL string
+	secret  []byte
+}
+
+// localObjectMeta is what's kept alongside a stored object.
+type localObjectMeta struct {
+	ContentType        string                  `json:"content_type,omitempty"`
+	CacheControl       string                  `json:"cache_control,omitempty"`
+	ContentDisposition string                  `json:"content_disposition,omitempty"`
+	ContentLanguage    string                  `json:"content_language,omitempty"`
+	StorageClass       string                  `json:"storage_class,omitempty"`
+	ETag               string                  `json:"etag"`
+	ChecksumAlgorithm  types.ChecksumAlgorithm `json:"checksum_algorithm,omitempty"`
+	Checksum           string                  `json:"checksum,omitempty"`
+}
+
+// localUpload is an in-flight multipart upload.
+type localUpload struct {
+	Bucket            string                  `json:"bucket"`
+	Key               string                  `json:"key"`
+	Initiated         time.Time               `json:"initiated"`
+	Meta              localObjectMeta         `json:"meta"`
+	ChecksumAlgorithm types.ChecksumAlgorithm `json:"checksum_algorithm,omitempty"`
+	ChecksumType      types.ChecksumType      `json:"checksum_type,omitempty"`
+}
+
+// newLocalStorage stores objects under root, creating it if needed.
+// Presigned URLs point at baseURL, signed with a key derived from
+// signingSecret so it isn't used for anything else.
+func newLocalStorage(root, baseURL, signingSecret string) (*localStorage, error) {
+	err := os.MkdirAll(root, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte("tubely local storage"))
+	return &localStorage{root: root, baseURL: strings.TrimSuffix(baseURL, "/"), secret: mac.Sum(nil)}, nil
+}
+
+// objectPath returns where an object is stored, refusing keys that would
+// leave its bucket.
+func (s *localStorage) objectPath(dir, bucket, key string) (string, error) {
+	if bucket == "" || strings.ContainsAny(bucket, `/\`) || strings.HasPrefix(bucket, ".") {
+		return "", storageResponseError(http.StatusBadRequest, fmt.Errorf("invalid bucket name %q", bucket))
+	}
+	if key == "" || path.Clean("/"+key) != "/"+key || strings.Contains(key, `\`) {
+		return "", storageResponseError(http.StatusBadRequest, fmt.Errorf("invalid key %q", key))
+	}
+	return filepath.Join(s.root, dir, bucket, filepath.FromSlash(key)), nil
+}
+
+func (s *localStorage) paths(bucket, key string) (objectPath, metaPath string, err error) {
+	objectPath, err = s.objectPath("", bucket, key)
+	if err != nil {
+		return "", "", err
+	}
+	metaPath, err = s.objectPath(localMetaDir, bucket, key)
+	if err != nil {
+		return "", "", err
+	}
+	return objectPath, metaPath + ".json", nil
+}
+
+// newChecksumHash returns a hash computing algorithm's checksum, or nil for
+// algorithms the local store doesn't compute.
+func newChecksumHash(algorithm types.ChecksumAlgorithm) hash.Hash {
+	switch algorithm {
+	case types.ChecksumAlgorithmCrc32c:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case types.ChecksumAlgorithmSha256:
+		return sha256.New()
+	}
+	return nil
+}
+
+// checksums returns a stored checksum in the field S3 would return it in.
+func (m localObjectMeta) checksums() s3Checksums {
+	if m.Checksum == "" {
+		return s3Checksums{}
+	}
+	checksum := m.Checksum
+	switch m.ChecksumAlgorithm {
+	case types.ChecksumAlgorithmCrc32c:
+		return s3Checksums{CRC32C: &checksum}
+	case types.ChecksumAlgorithmSha256:
+		return s3Checksums{SHA256: &checksum}
+	}
+	return s3Checksums{}
+}
+
+// writeFile atomically replaces path with what's read from r, and returns
+// its size, its quoted MD5 ETag and, if algorithm is one the local store
+// computes, its checksum. A limit above zero refuses bodies larger than it.
+func writeFile(filePath string, r io.Reader, algorithm types.ChecksumAlgorithm, limit int64) (int64, string, string, error) {
+	err := os.MkdirAll(filepath.Dir(filePath), 0o755)
+	if err != nil {
+		return 0, "", "", err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(filePath), ".tmp-*")
+	if err != nil {
+		return 0, "", "", err
+	}
+	defer os.Remove(temp.Name())
+
+	md5Hash := md5.New()
+	writers := []io.Writer{temp, md5Hash}
+	checksumHash := newChecksumHash(algorithm)
+	if checksumHash != nil {
+		writers = append(writers, checksumHash)
+	}
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	size, err := io.Copy(io.MultiWriter(writers...), r)
+	closeErr := temp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, "", "", err
+	}
+	if limit > 0 && size > limit {
+		return 0, "", "", errLocalUploadTooLarge
+	}
+	err = os.Rename(temp.Name(), filePath)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	checksum := ""
+	if checksumHash != nil {
+		checksum = base64.StdEncoding.EncodeToString(checksumHash.Sum(nil))
+	}
+	return size, `"` + hex.EncodeToString(md5Hash.Sum(nil)) + `"`, checksum, nil
+}
+
+var errLocalUploadTooLarge = errors.New("upload is larger than allowed")
+
+func writeJSONFile(filePath string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, _, _, err = writeFile(filePath, bytes.NewReader(data), "", 0)
+	return err
+}
+
+func readJSONFile(filePath string, v any) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// stat returns an object's file info and metadata.
+func (s *localStorage) stat(bucket, key string) (string, fs.FileInfo, localObjectMeta, error) {
+	objectPath, metaPath, err := s.paths(bucket, key)
+	if err != nil {
+		return "", nil, localObjectMeta{}, err
+	}
+	info, err := os.Stat(objectPath)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return "", nil, localObjectMeta{}, noSuchKey(key)
+	}
+	if err != nil {
+		return "", nil, localObjectMeta{}, err
+	}
+	meta := localObjectMeta{}
+	err = readJSONFile(metaPath, &meta)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", nil, localObjectMeta{}, err
+	}
+	return objectPath, info, meta, nil
+}
+
+// store writes an object and its metadata, and returns the metadata with
+// the object's ETag and checksum filled in, and its size.
+func (s *localStorage) store(bucket, key string, body io.Reader, meta localObjectMeta, limit int64) (localObjectMeta, int64, error) {
+	objectPath, metaPath, err := s.paths(bucket, key)
+	if err != nil {
+		return meta, 0, err
+	}
+	if body == nil {
+		body = strings.NewReader("")
+	}
+	size, etag, checksum, err := writeFile(objectPath, body, meta.ChecksumAlgorithm, limit)
+	if err != nil {
+		return meta, 0, err
+	}
+	meta.ETag = etag
+	meta.Checksum = checksum
+	if checksum == "" {
+		meta.ChecksumAlgorithm = ""
+	}
+	return meta, size, writeJSONFile(metaPath, meta)
+}
+
+func (s *localStorage) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	objectPath, info, meta, err := s.stat(aws.ToString(params.Bucket), aws.ToString(params.Key))
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(objectPath)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.GetObjectOutput{
+		Body:               file,
+		ContentLength:      aws.Int64(info.Size()),
+		ContentType:        optionalString(meta.ContentType),
+		CacheControl:       optionalString(meta.CacheControl),
+		ContentDisposition: optionalString(meta.ContentDisposition),
+		ContentLanguage:    optionalString(meta.ContentLanguage),
+		ETag:               aws.String(meta.ETag),
+		LastModified:       aws.Time(info.ModTime()),
+	}, nil
+}
+
+func (s *localStorage) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	meta, _, err := s.store(aws.ToString(params.Bucket), aws.ToString(params.Key), params.Body, localObjectMeta{
+		ContentType:        aws.ToString(params.ContentType),
+		CacheControl:       aws.ToString(params.CacheControl),
+		ContentDisposition: aws.ToString(params.ContentDisposition),
+		ContentLanguage:    aws.ToString(params.ContentLanguage),
+		StorageClass:       string(params.StorageClass),
+		ChecksumAlgorithm:  params.ChecksumAlgorithm,
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	checksums := meta.checksums()
+	return &s3.PutObjectOutput{
+		ETag:           aws.String(meta.ETag),
+		ChecksumCRC32C: checksums.CRC32C,
+		ChecksumSHA256: checksums.SHA256,
+	}, nil
+}
+
+func (s *localStorage) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	_, info, meta, err := s.stat(aws.ToString(params.Bucket), aws.ToString(params.Key))
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusNotFound {
+		// HEAD responses have no body, so S3 can't say which key is missing
+		return nil, storageResponseError(http.StatusNotFound, &types.NotFound{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	output := &s3.HeadObjectOutput{
+		ContentLength:      aws.Int64(info.Size()),
+		ContentType:        optionalString(meta.ContentType),
+		CacheControl:       optionalString(meta.CacheControl),
+		ContentDisposition: optionalString(meta.ContentDisposition),
+		ContentLanguage:    optionalString(meta.ContentLanguage),
+		ETag:               aws.String(meta.ETag),
+		LastModified:       aws.Time(info.ModTime()),
+	}
+	// Like S3, the standard storage class isn't reported
+	if meta.StorageClass != "" && meta.StorageClass != string(types.StorageClassStandard) {
+		output.StorageClass = types.StorageClass(meta.StorageClass)
+	}
+	if params.ChecksumMode == types.ChecksumModeEnabled {
+		checksums := meta.checksums()
+		output.ChecksumCRC32C, output.ChecksumSHA256 = checksums.CRC32C, checksums.SHA256
+	}
+	return output, nil
+}
+
+func (s *localStorage) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	objectPath, metaPath, err := s.paths(aws.ToString(params.Bucket), aws.ToString(params.Key))
+	if err != nil {
+		return nil, err
+	}
+	// Deleting a missing object succeeds, as it does on S3
+	for _, filePath := range []string{objectPath, metaPath} {
+		err := os.Remove(filePath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (s *localStorage) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	source, err := url.PathUnescape(aws.ToString(params.CopySource))
+	if err != nil {
+		return nil, storageResponseError(http.StatusBadRequest, err)
+	}
+	srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	srcPath, _, srcMeta, err := s.stat(srcBucket, srcKey)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	meta := srcMeta
+	if params.MetadataDirective == types.MetadataDirectiveReplace {
+		meta = localObjectMeta{
+			ContentType:        aws.ToString(params.ContentType),
+			CacheControl:       aws.ToString(params.CacheControl),
+			ContentDisposition: aws.ToString(params.ContentDisposition),
+			ContentLanguage:    aws.ToString(params.ContentLanguage),
+		}
+	}
+	// A copy is in the standard class unless it asks for another
+	meta.StorageClass = string(params.StorageClass)
+	meta.ChecksumAlgorithm = params.ChecksumAlgorithm
+	if meta.ChecksumAlgorithm == "" {
+		meta.ChecksumAlgorithm = srcMeta.ChecksumAlgorithm
+	}
+	meta, _, err = s.store(aws.ToString(params.Bucket), aws.ToString(params.Key), file, meta, 0)
+	if err != nil {
+		return nil, err
+	}
+	checksums := meta.checksums()
+	return &s3.CopyObjectOutput{CopyObjectResult: &types.CopyObjectResult{
+		ETag:           aws.String(meta.ETag),
+		LastModified:   aws.Time(time.Now()),
+		ChecksumCRC32C: checksums.CRC32C,
+		ChecksumSHA256: checksums.SHA256,
+	}}, nil
+}
+
+func (s *localStorage) uploadDir(uploadID string) (string, error) {
+	if uploadID == "" || strings.ContainsAny(uploadID, `./\`) {
+		return "", noSuchUpload(uploadID)
+	}
+	dir := filepath.Join(s.root, localUploadsDir, uploadID)
+	if _, err := os.Stat(dir); err != nil {
+		return "", noSuchUpload(uploadID)
+	}
+	return dir, nil
+}
+
+func (s *localStorage) getUpload(uploadID string) (string, localUpload, error) {
+	dir, err := s.uploadDir(uploadID)
+	if err != nil {
+		return "", localUpload{}, err
+	}
+	upload := localUpload{}
+	err = readJSONFile(filepath.Join(dir, "upload.json"), &upload)
+	if err != nil {
+		return "", localUpload{}, err
+	}
+	return dir, upload, nil
+}
+
+func (s *localStorage) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	bucket, key := aws.ToString(params.Bucket), aws.ToString(params.Key)
+	_, _, err := s.paths(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	uploadID := rand.Text()
+	err = writeJSONFile(filepath.Join(s.root, localUploadsDir, uploadID, "upload.json"), localUpload{
+		Bucket:    bucket,
+		Key:       key,
+		Initiated: time.Now().UTC(),
+		Meta: localObjectMeta{
+			ContentType:        aws.ToString(params.ContentType),
+			CacheControl:       aws.ToString(params.CacheControl),
+			ContentDisposition: aws.ToString(params.ContentDisposition),
+			ContentLanguage:    aws.ToString(params.ContentLanguage),
+			StorageClass:       string(params.StorageClass),
+		},
+		ChecksumAlgorithm: params.ChecksumAlgorithm,
+		ChecksumType:      params.ChecksumType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3.CreateMultipartUploadOutput{Bucket: &bucket, Key: &key, UploadId: &uploadID}, nil
+}
+
+func (s *localStorage) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	dir, upload, err := s.getUpload(aws.ToString(params.UploadId))
+	if err != nil {
+		return nil, err
+	}
+	partNumber := aws.ToInt32(params.PartNumber)
+	if partNumber < 1 || partNumber > 10000 {
+		return nil, storageResponseError(http.StatusBadRequest, fmt.Errorf("invalid part number %d", partNumber))
+	}
+	body := params.Body
+	if body == nil {
+		body = strings.NewReader("")
+	}
+	_, etag, checksum, err := writeFile(filepath.Join(dir, strconv.Itoa(int(partNumber))), body, upload.ChecksumAlgorithm, 0)
+	if err != nil {
+		return nil, err
+	}
+	checksums := localObjectMeta{ChecksumAlgorithm: upload.ChecksumAlgorithm, Checksum: checksum}.checksums()
+	return &s3.UploadPartOutput{ETag: &etag, ChecksumCRC32C: checksums.CRC32C, ChecksumSHA256: checksums.SHA256}, nil
+}
+
+// uploadedParts returns the part numbers of an upload's parts, in order.
+func uploadedParts(dir string) ([]int32, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var parts []int32
+	for _, entry := range entries {
+		partNumber, err := strconv.Atoi(entry.Name())
+		if err == nil {
+			parts = append(parts, int32(partNumber))
+		}
+	}
+	slices.Sort(parts)
+	return parts, nil
+}
+
+func (s *localStorage) ListParts(ctx context.Context, params *s3.ListPartsInput, optFns ...func(*s3.Options)) (*s3.ListPartsOutput, error) {
+	dir, upload, err := s.getUpload(aws.ToString(params.UploadId))
+	if err != nil {
+		return nil, err
+	}
+	partNumbers, err := uploadedParts(dir)
+	if err != nil {
+		return nil, err
+	}
+	output := &s3.ListPartsOutput{
+		Bucket:            params.Bucket,
+		Key:               params.Key,
+		UploadId:          params.UploadId,
+		ChecksumAlgorithm: upload.ChecksumAlgorithm,
+		ChecksumType:      upload.ChecksumType,
+		IsTruncated:       aws.Bool(false),
+	}
+	for _, partNumber := range partNumbers {
+		file, err := os.Open(filepath.Join(dir, strconv.Itoa(int(partNumber))))
+		if err != nil {
+			return nil, err
+		}
+		size, etag, checksum, err := hashReader(file, upload.ChecksumAlgorithm)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		checksums := localObjectMeta{ChecksumAlgorithm: upload.ChecksumAlgorithm, Checksum: checksum}.checksums()
+		output.Parts = append(output.Parts, types.Part{
+			PartNumber:     aws.Int32(partNumber),
+			Size:           aws.Int64(size),
+			ETag:           aws.String(etag),
+			ChecksumCRC32C: checksums.CRC32C,
+			ChecksumSHA256: checksums.SHA256,
+		})
+	}
+	return output, nil
+}
+
+// hashReader returns the size, ETag and checksum of what's read from r.
+func hashReader(r io.Reader, algorithm types.ChecksumAlgorithm) (int64, string, string, error) {
+	md5Hash := md5.New()
+	writers := []io.Writer{md5Hash}
+	checksumHash := newChecksumHash(algorithm)
+	if checksumHash != nil {
+		writers = append(writers, checksumHash)
+	}
+	size, err := io.Copy(io.MultiWriter(writers...), r)
+	if err != nil {
+		return 0, "", "", err
+	}
+	checksum := ""
+	if checksumHash != nil {
+		checksum = base64.StdEncoding.EncodeToString(checksumHash.Sum(nil))
+	}
+	return size, `"` + hex.EncodeToString(md5Hash.Sum(nil)) + `"`, checksum, nil
+}
+
+// CompleteMultipartUpload joins the listed parts into the object. A
+// composite checksum is a checksum of the part checksums with the number of
+// parts appended, as on S3.
+func (s *localStorage) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	dir, upload, err := s.getUpload(aws.ToString(params.UploadId))
+	if err != nil {
+		return nil, err
+	}
+	if params.MultipartUpload == nil || len(params.MultipartUpload.Parts) == 0 {
+		return nil, storageResponseError(http.StatusBadRequest, errors.New("no parts to complete the upload with"))
+	}
+
+	var readers []io.Reader
+	partChecksums := newChecksumHash(upload.ChecksumAlgorithm)
+	previous := int32(0)
+	for _, part := range params.MultipartUpload.Parts {
+		partNumber := aws.ToInt32(part.PartNumber)
+		if partNumber <= previous {
+			return nil, storageResponseError(http.StatusBadRequest, errors.New("parts must be in ascending order"))
+		}
+		previous = partNumber
+		file, err := os.Open(filepath.Join(dir, strconv.Itoa(int(partNumber))))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, storageResponseError(http.StatusBadRequest, fmt.Errorf("part %d hasn't been uploaded", partNumber))
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if partChecksums != nil {
+			partHash := newChecksumHash(upload.ChecksumAlgorithm)
+			_, err = io.Copy(partHash, file)
+			if err == nil {
+				_, err = file.Seek(0, io.SeekStart)
+			}
+			if err != nil {
+				return nil, err
+			}
+			partChecksums.Write(partHash.Sum(nil))
+		}
+		readers = append(readers, file)
+	}
+
+	meta := upload.Meta
+	meta.ChecksumAlgorithm = upload.ChecksumAlgorithm
+	meta, _, err = s.store(upload.Bucket, upload.Key, io.MultiReader(readers...), meta, 0)
+	if err != nil {
+		return nil, err
+	}
+	if upload.ChecksumType == types.ChecksumTypeComposite && partChecksums != nil {
+		meta.Checksum = fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(partChecksums.Sum(nil)), len(readers))
+		_, metaPath, _ := s.paths(upload.Bucket, upload.Key)
+		err = writeJSONFile(metaPath, meta)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := meta.checksums()
+	return &s3.CompleteMultipartUploadOutput{
+		Bucket:         &upload.Bucket,
+		Key:            &upload.Key,
+		ETag:           aws.String(meta.ETag),
+		ChecksumCRC32C: checksums.CRC32C,
+		ChecksumSHA256: checksums.SHA256,
+	}, nil
+}
+
+func (s *localStorage) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	dir, err := s.uploadDir(aws.ToString(params.UploadId))
+	if err != nil {
+		return nil, err
+	}
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (s *localStorage) ListMultipartUploads(ctx context.Context, params *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	entries, err := os.ReadDir(filepath.Join(s.root, localUploadsDir))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	output := &s3.ListMultipartUploadsOutput{Bucket: params.Bucket, IsTruncated: aws.Bool(false)}
+	for _, entry := range entries {
+		_, upload, err := s.getUpload(entry.Name())
+		if err != nil || upload.Bucket != aws.ToString(params.Bucket) {
+			continue
+		}
+		output.Uploads = append(output.Uploads, types.MultipartUpload{
+			Key:       aws.String(upload.Key),
+			UploadId:  aws.String(entry.Name()),
+			Initiated: aws.Time(upload.Initiated),
+		})
+	}
+	return output, nil
+}
+
+// sign returns the signature of a presigned request.
+func (s *localStorage) sign(parts ...string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// objectURL returns the app's URL for an object.
+func (s *localStorage) objectURL(bucket, key string) string {
+	return s.baseURL + "/storage/" + url.PathEscape(bucket) + "/" + (&url.URL{Path: key}).EscapedPath()
+}
+
+func (s *localStorage) presignGet(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
+	_, _, err := s.paths(bucket, key)
+	if err != nil {
+		return "", err
+	}
+	expiresAt := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+	query := url.Values{
+		"expires":   {expiresAt},
+		"signature": {s.sign(http.MethodGet, bucket, key, expiresAt)},
+	}
+	return s.objectURL(bucket, key) + "?" + query.Encode(), nil
+}
+
+// verifyGet checks the signature and expiry of a presigned download.
+func (s *localStorage) verifyGet(bucket, key string, query url.Values) bool {
+	expiresAt, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return false
+	}
+	expected := s.sign(http.MethodGet, bucket, key, query.Get("expires"))
+	return hmac.Equal([]byte(expected), []byte(query.Get("signature")))
+}
+
+// localPostPolicy is what a presigned upload may contain, like an S3 POST
+// policy.
+type localPostPolicy struct {
+	Expiration time.Time `json:"expiration"`
+	Conditions []any     `json:"conditions"`
+}
+
+func (s *localStorage) presignPost(ctx context.Context, bucket, key string, expires time.Duration, conditions []any) (*s3.PresignedPostRequest, error) {
+	_, _, err := s.paths(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	policyJSON, err := json.Marshal(localPostPolicy{
+		Expiration: time.Now().Add(expires).UTC(),
+		Conditions: append(slices.Clone(conditions), map[string]string{"bucket": bucket}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	policy := base64.StdEncoding.EncodeToString(policyJSON)
+	return &s3.PresignedPostRequest{
+		URL: s.baseURL + "/storage/" + url.PathEscape(bucket),
+		Values: map[string]string{
+			"key":       key,
+			"policy":    policy,
+			"signature": s.sign(http.MethodPost, policy),
+		},
+	}, nil
+}
+
+// localSizeRange is the smallest and largest upload a policy allows. A
+// zero max allows any size.
+type localSizeRange struct {
+	min, max int64
+}
+
+// verifyPost checks a presigned upload's signature and expiry, and that
+// its form fields, keyed by their lower-case name, are within its policy.
+// Like S3 it refuses fields the policy doesn't mention. It returns the
+// sizes the policy allows.
+func (s *localStorage) verifyPost(bucket string, fields map[string]string) (localSizeRange, error) {
+	sizes := localSizeRange{}
+	policyString := fields["policy"]
+	expected := s.sign(http.MethodPost, policyString)
+	if !hmac.Equal([]byte(expected), []byte(fields["signature"])) {
+		return sizes, errors.New("invalid signature")
+	}
+	policyJSON, err := base64.StdEncoding.DecodeString(policyString)
+	if err != nil {
+		return sizes, err
+	}
+	policy := localPostPolicy{}
+	err = json.Unmarshal(policyJSON, &policy)
+	if err != nil {
+		return sizes, err
+	}
+	if time.Now().After(policy.Expiration) {
+		return sizes, errors.New("policy has expired")
+	}
+
+	values := maps.Clone(fields)
+	values["bucket"] = bucket
+	checked := map[string]bool{"policy": true, "signature": true}
+	for _, condition := range policy.Conditions {
+		switch condition := condition.(type) {
+		case map[string]any:
+			for name, want := range condition {
+				name = strings.ToLower(name)
+				if values[name] != fmt.Sprint(want) {
+					return sizes, fmt.Errorf("%s doesn't match the policy", name)
+				}
+				checked[name] = true
+			}
+		case []any:
+			if len(condition) == 3 && condition[0] == "content-length-range" {
+				minSize, minOK := condition[1].(float64)
+				maxSize, maxOK := condition[2].(float64)
+				if !minOK || !maxOK {
+					return sizes, errors.New("invalid content-length-range")
+				}
+				sizes = localSizeRange{min: int64(minSize), max: int64(maxSize)}
+				continue
+			}
+			if len(condition) != 3 {
+				return sizes, fmt.Errorf("unsupported condition %v", condition)
+			}
+			op, _ := condition[0].(string)
+			field, _ := condition[1].(string)
+			want, _ := condition[2].(string)
+			name := strings.ToLower(strings.TrimPrefix(field, "$"))
+			switch {
+			case op == "eq" && values[name] == want:
+			case op == "starts-with" && strings.HasPrefix(values[name], want):
+			default:
+				return sizes, fmt.Errorf("%s doesn't match the policy", name)
+			}
+			checked[name] = true
+		}
+	}
+	for name := range values {
+		if !checked[name] && name != "bucket" {
+			return sizes, fmt.Errorf("%s isn't allowed by the policy", name)
+		}
+	}
+	return sizes, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/i18n"
+)
+
+// localizedWriter carries the language negotiated for a request to the code
+// writing its error responses.
+type localizedWriter struct {
+	http.ResponseWriter
+	language string
+}
+
+func (w *localizedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush and Hijack pass through for the event stream and WebSocket handlers,
+// which check for them directly.
+func (w *localizedWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *localizedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// localeMiddleware picks the language for error messages from the request's
+// Accept-Language header.
+func localeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		language := i18n.Match(r.Header.Get("Accept-Language"))
+		next.ServeHTTP(&localizedWriter{ResponseWriter: w, language: language}, r)
+	})
+}
+
+// localize translates a user-facing message into the language negotiated for
+// the response being written. The response varies by Accept-Language from
+// then on.
+func localize(w http.ResponseWriter, message string) string {
+	for {
+		if lw, ok := w.(*localizedWriter); ok {
+			w.Header().Add("Vary", "Accept-Language")
+			w.Header().Set("Content-Language", lw.language)
+			return i18n.Translate(lw.language, message)
+		}
+		unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return message
+		}
+		w = unwrapper.Unwrap()
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/lock"
+	"github.com/google/uuid"
+)
+
+// Changes to a video read the record, modify it and write all of it back.
+// They hold the video's lock throughout, so two of them, on any instance,
+// can't overwrite each other's fields. Processing holds a separate lock for
+// its whole run, so a video is only processed by one worker at a time
+// without blocking edits meanwhile.
+const (
+	// videoLockTTL bounds how long a lock outlives an instance that died
+	// holding it
+	videoLockTTL = 30 * time.Second
+	// videoLockWait is how long a request waits for another change to the
+	// same video to finish
+	videoLockWait = 10 * time.Second
+)
+
+// lockContext returns a context canceled with lock.ErrLost if l is lost,
+// for long work that mustn't go on once another holder may have started.
+func lockContext(parent context.Context, l lock.Lock) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	go func() {
+		select {
+		case <-l.Lost():
+			cancel(lock.ErrLost)
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+func videoLockKey(videoID uuid.UUID) string {
+	return "video:" + videoID.String()
+}
+
+func videoProcessingLockKey(videoID uuid.UUID) string {
+	return "video-processing:" + videoID.String()
+}
+
+func uploadSessionLockKey(sessionID uuid.UUID) string {
+	return "upload-session:" + sessionID.String()
+}
+
+// lockUploadSession takes the lock for changing an upload session without
+// waiting, responding with a 409 if another request holds it.
+func (cfg *apiConfig) lockUploadSession(w http.ResponseWriter, sessionID uuid.UUID) (lock.Lock, bool) {
+	l, err := cfg.locks.TryAcquire(context.Background(), uploadSessionLockKey(sessionID), videoLockTTL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't lock upload session", err)
+		return nil, false
+	}
+	if l == nil {
+		respondWithError(w, http.StatusConflict, "Upload session is being changed by another request", nil)
+		return nil, false
+	}
+	return l, true
+}
+
+// lockVideo takes the lock for changing a video, responding with an error
+// if it can't.
+func (cfg *apiConfig) lockVideo(w http.ResponseWriter, videoID uuid.UUID) (lock.Lock, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), videoLockWait)
+	defer cancel()
+	l, err := cfg.locks.Acquire(ctx, videoLockKey(videoID), videoLockTTL)
+	if errors.Is(err, lock.ErrTimeout) {
+		respondWithError(w, http.StatusConflict, "Video is being changed by another request", err)
+		return nil, false
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't lock video", err)
+		return nil, false
+	}
+	return l, true
+}
+
+// changeVideo applies change to the current record of a video under its
+// lock, for work that ran long enough that its own copy may be stale. change
+// returns false to leave the video as it is. It returns the current video.
+func (cfg *apiConfig) changeVideo(videoID uuid.UUID, change func(video *database.Video) bool) (database.Video, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), videoLockWait)
+	defer cancel()
+	l, err := cfg.locks.Acquire(ctx, videoLockKey(videoID), videoLockTTL)
+	if err != nil {
+		return database.Video{}, fmt.Errorf("couldn't lock video: %w", err)
+	}
+	defer l.Release()
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return database.Video{}, err
+	}
+	if video.ID == uuid.Nil {
+		return database.Video{}, errors.New("video no longer exists")
+	}
+	if !change(&video) {
+		return video, nil
+	}
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		return database.Video{}, err
+	}
+	return video, nil
+}
@@ -1,14 +1,27 @@
 package main
 
 import (
-	"context"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cache"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captions"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/errreport"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/lock"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/search"
+	"github.com/google/uuid"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
@@ -17,6 +30,7 @@ import (
 type apiConfig struct {
 	db               database.Client
 	jwtSecret        string
+	adminAPIKey      string
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
@@ -24,7 +38,101 @@ type apiConfig struct {
 	s3Region         string
 	s3CfDistribution string
 	port             string
-	s3Client         *s3.Client
+	// storageBackend is what STORAGE_BACKEND names
+	storageBackend string
+	storage        objectStorage
+	// localStorage is set when objects are stored on the filesystem, to
+	// serve and accept its presigned URLs
+	localStorage *localStorage
+	// prober and transcoder read and rewrite uploaded videos
+	prober     media.Prober
+	transcoder media.Transcoder
+	jobQueue   chan uuid.UUID
+	workers    *workerPool
+	settings   *runtimeSettings
+	moderator  moderation.Moderator
+	// thumbnailClassifier screens thumbnails before they're published
+	thumbnailClassifier moderation.ImageClassifier
+	// transcriber is nil when captions aren't generated
+	transcriber captions.Transcriber
+	// translator is nil when captions can't be translated
+	translator captions.Translator
+	// events carries notifications between the features that publish and
+	// react to them
+	events        events.Bus
+	notifications *notificationHub
+	webhookClient *http.Client
+	// webhookNudge wakes the webhook dispatcher when a delivery is queued
+	webhookNudge chan struct{}
+	// notifier sends users the events they want on each channel
+	notifier *notificationService
+	// appBaseURL is where links in notifications point, without a trailing
+	// slash
+	appBaseURL string
+
+	// s3Provider is what S3_PROVIDER names, AWS unless it's set
+	s3Provider           s3Provider
+	s3ClassBuckets       map[objectClass]string
+	s3Replicas           []s3Replica
+	s3KeyTemplate        string
+	s3CacheControl       string
+	s3ContentLanguage    string
+	s3ContentDisposition string
+	uploadPartSize       int64
+	uploadConcurrency    int
+	// checksumAlgorithm is the checksum S3 computes and stores with each
+	// object, or empty to leave it to the SDK's defaults
+	checksumAlgorithm types.ChecksumAlgorithm
+
+	thumbnailJPEGQuality      int
+	thumbnailVariantSelection string
+
+	// cache is nil when no shared cache is configured
+	cache     cache.Cache
+	locks     lock.Locker
+	scheduler *scheduler
+	// errorReporter is nil unless SENTRY_DSN is set
+	errorReporter errreport.Reporter
+	features      *featureFlags
+	// presignLog records which videos' URLs were signed, for the exposure
+	// report
+	presignLog *presignLog
+	// usageMeter adds up billable usage until it's flushed
+	usageMeter *usageMeter
+	// searchIndexer is nil when search is disabled
+	searchIndexer *searchIndexer
+	// analyticsExportPrefix ends in a slash unless it's empty
+	analyticsExportBucket string
+	analyticsExportPrefix string
+	analyticsExportDaily  bool
+	// backupPrefix ends in a slash unless it's empty. Backups are only
+	// encrypted when backupPassphrase is set
+	backupBucket     string
+	backupPrefix     string
+	backupPassphrase string
+	// backupDaily is whether backups are also taken on a schedule, and
+	// pruned once they're older than backupRetention
+	backupDaily     bool
+	backupRetention time.Duration
+
+	// importSource is nil unless IMPORT_SOURCE_URL is set. importDir is the
+	// directory it names, watched when importUserEmail is set
+	importSource    ingest.Source
+	importDir       string
+	importUserEmail string
+	// clouds are the cloud storage services users can link accounts on
+	// and import from, by name
+	clouds map[string]ingest.Cloud
+	// urlDownloader is nil unless YTDLP_BINARY is set
+	urlDownloader ingest.Downloader
+
+	// rateLimiter is nil when API requests aren't rate limited
+	rateLimiter       *rateLimiter
+	uploadQuotaPerDay int
+	storageQuotaBytes int64
+	// storagePricesPerGB prices a GB-month of each object class and S3
+	// storage class for the storage cost report
+	storagePricesPerGB map[string]float64
 }
 
 // type thumbnail struct {
@@ -35,28 +143,171 @@ type apiConfig struct {
 // var videoThumbnails = map[uuid.UUID]thumbnail{}
 
 func main() {
-	godotenv.Load(".env")
+	// Optional: CONFIG_FILE is read for settings not in the environment, and
+	// watched for changes to the reloadable ones
+	configFile := os.Getenv("CONFIG_FILE")
+	if configFile == "" {
+		configFile = ".env"
+	}
+	env := processEnv()
+	godotenv.Load(configFile)
+
+	// Optional: a rotated log file instead of stderr
+	if logPath := os.Getenv("LOG_FILE"); logPath != "" {
+		var logMaxBytes int64 = 100 << 20
+		if v := os.Getenv("LOG_FILE_MAX_BYTES"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 1 {
+				log.Fatal("LOG_FILE_MAX_BYTES must be a positive number of bytes")
+			}
+			logMaxBytes = n
+		}
+		logMaxBackups := 5
+		if v := os.Getenv("LOG_FILE_MAX_BACKUPS"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				log.Fatal("LOG_FILE_MAX_BACKUPS must be a non-negative integer")
+			}
+			logMaxBackups = n
+		}
+		logFile, err := logging.OpenRotatingFile(logPath, logMaxBytes, logMaxBackups)
+		if err != nil {
+			log.Fatalf("Couldn't open LOG_FILE: %v", err)
+		}
+		log.SetOutput(logFile)
+	}
 
 	pathToDB := os.Getenv("DB_PATH")
 	if pathToDB == "" {
 		log.Fatal("DB_URL must be set")
 	}
 
-	db, err := database.NewClient(pathToDB)
+	// Optional: pool limits and the query timeout default in the database
+	// package
+	var dbOptions database.Options
+	if v := os.Getenv("DB_MAX_OPEN_CONNS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatal("DB_MAX_OPEN_CONNS must be a positive integer")
+		}
+		dbOptions.MaxOpenConns = n
+	}
+	if v := os.Getenv("DB_MAX_IDLE_CONNS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatal("DB_MAX_IDLE_CONNS must be a positive integer")
+		}
+		dbOptions.MaxIdleConns = n
+	}
+	if v := os.Getenv("DB_QUERY_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatal("DB_QUERY_TIMEOUT must be a positive duration")
+		}
+		dbOptions.QueryTimeout = d
+	}
+	if v := os.Getenv("DB_BUSY_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatal("DB_BUSY_TIMEOUT must be a positive duration")
+		}
+		dbOptions.BusyTimeout = d
+	}
+	dbOptions.ReadReplica = os.Getenv("DB_READ_REPLICA_PATH")
+
+	db, err := database.NewClient(pathToDB, dbOptions)
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
 
+	// Optional: Redis shared by every instance, for caching video metadata
+	// and presigned URLs, and for locking videos across instances. Without
+	// it, locks only cover this instance
+	var sharedCache cache.Cache
+	var locks lock.Locker = lock.NewLocal()
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		redisClient, err := newRedisClient(redisURL)
+		if err != nil {
+			log.Fatalf("Couldn't connect to Redis: %v", err)
+		}
+		sharedCache = cache.NewRedis(redisClient, "tubely:")
+		locks = lock.NewRedis(redisClient, "tubely:lock:")
+		videoCacheTTL := time.Minute
+		if v := os.Getenv("VIDEO_CACHE_TTL"); v != "" {
+			videoCacheTTL, err = time.ParseDuration(v)
+			if err != nil || videoCacheTTL <= 0 {
+				log.Fatal("VIDEO_CACHE_TTL must be a positive duration")
+			}
+		}
+		db = db.WithCache(sharedCache, videoCacheTTL)
+	}
+
+	// Optional: search index ("memory", "elasticsearch" or "none") behind
+	// video search. The memory index is rebuilt on every start and only
+	// sees this instance's changes, so use Elasticsearch when running more
+	// than one instance
+	elasticsearchIndex := os.Getenv("ELASTICSEARCH_INDEX")
+	if elasticsearchIndex == "" {
+		elasticsearchIndex = "tubely-videos"
+	}
+	searchIndex, err := newSearchIndex(searchIndexOptions{
+		Provider:            os.Getenv("SEARCH_INDEX"),
+		ElasticsearchURL:    os.Getenv("ELASTICSEARCH_URL"),
+		ElasticsearchIndex:  elasticsearchIndex,
+		ElasticsearchAPIKey: os.Getenv("ELASTICSEARCH_API_KEY"),
+	})
+	if err != nil {
+		log.Fatalf("Couldn't set up search index: %v", err)
+	}
+	var searchIndexer *searchIndexer
+	if searchIndex != nil {
+		searchIndexer = newSearchIndexer(searchIndex)
+		db = db.WithVideoChangeHook(searchIndexer.videoChanged)
+	}
+
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
 		log.Fatal("JWT_SECRET environment variable is not set")
 	}
 
+	// Optional: admin endpoints are disabled unless a key is configured
+	adminAPIKey := os.Getenv("ADMIN_API_KEY")
+
+	// Optional: pprof and runtime stats for admins, off unless asked for
+	diagnosticsEnabled := false
+	if v := os.Getenv("DIAGNOSTICS_ENABLED"); v != "" {
+		diagnosticsEnabled, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatal("DIAGNOSTICS_ENABLED must be true or false")
+		}
+	}
+
 	platform := os.Getenv("PLATFORM")
 	if platform == "" {
 		log.Fatal("PLATFORM environment variable is not set")
 	}
 
+	// Optional: default feature flag settings, e.g. "player_v2=10,chapters=off".
+	// Flags set through the admin API take precedence
+	featureFlagDefaults, err := parseFeatureFlagDefaults(os.Getenv("FEATURE_FLAGS"))
+	if err != nil {
+		log.Fatalf("Invalid FEATURE_FLAGS: %v", err)
+	}
+
+	// Optional: report panics, 5XX responses and failed jobs to Sentry or a
+	// service compatible with it
+	var errorReporter errreport.Reporter
+	if dsn := os.Getenv("SENTRY_DSN"); dsn != "" {
+		environment := os.Getenv("SENTRY_ENVIRONMENT")
+		if environment == "" {
+			environment = platform
+		}
+		errorReporter, err = errreport.NewSentry(dsn, environment)
+		if err != nil {
+			log.Fatalf("Invalid SENTRY_DSN: %v", err)
+		}
+	}
+
 	filepathRoot := os.Getenv("FILEPATH_ROOT")
 	if filepathRoot == "" {
 		log.Fatal("FILEPATH_ROOT environment variable is not set")
@@ -67,18 +318,31 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
+	// Optional: "local" stores objects on the filesystem under
+	// LOCAL_STORAGE_ROOT instead of in S3, to run without AWS, "gcs" in
+	// Google Cloud Storage and "azure" in Azure Blob Storage. Buckets become
+	// directories, GCS buckets or Azure containers, and S3_REGION and
+	// S3_CF_DISTRO aren't needed.
+	storageBackend, err := parseStorageBackend(os.Getenv("STORAGE_BACKEND"))
+	if err != nil {
+		log.Fatalf("Invalid STORAGE_BACKEND: %v", err)
+	}
+
 	s3Bucket := os.Getenv("S3_BUCKET")
+	if s3Bucket == "" && storageBackend == storageBackendLocal {
+		s3Bucket = "tubely"
+	}
 	if s3Bucket == "" {
 		log.Fatal("S3_BUCKET environment variable is not set")
 	}
 
 	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
+	if s3Region == "" && storageBackend == storageBackendS3 {
 		log.Fatal("S3_REGION environment variable is not set")
 	}
 
 	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
+	if s3CfDistribution == "" && storageBackend == storageBackendS3 {
 		log.Fatal("S3_CF_DISTRO environment variable is not set")
 	}
 
@@ -87,58 +351,811 @@ func main() {
 		log.Fatal("PORT environment variable is not set")
 	}
 
-	sdkConfig, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(s3Region))
+	// Optional: serve HTTPS, and HTTP/2 over TLS or cleartext
+	tlsCertFile := os.Getenv("TLS_CERT_FILE")
+	tlsKeyFile := os.Getenv("TLS_KEY_FILE")
+	if (tlsCertFile == "") != (tlsKeyFile == "") {
+		log.Fatal("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	serveTLS := tlsCertFile != ""
+	protocols, err := serverProtocols(os.Getenv("HTTP2_MODE"), serveTLS)
 	if err != nil {
-		log.Fatal("Couldn't load default config")
+		log.Fatalf("Invalid HTTP2_MODE: %v", err)
+	}
+
+	thumbnailCandidateRetention := 7 * 24 * time.Hour
+	if v := os.Getenv("THUMBNAIL_CANDIDATE_RETENTION"); v != "" {
+		thumbnailCandidateRetention, err = time.ParseDuration(v)
+		if err != nil || thumbnailCandidateRetention <= 0 {
+			log.Fatal("THUMBNAIL_CANDIDATE_RETENTION must be a positive duration")
+		}
 	}
-	s3Client := s3.NewFromConfig(sdkConfig)
+
+	// Optional: how long temporary files left by crashes are kept
+	tempFileMaxAge := 24 * time.Hour
+	if v := os.Getenv("TEMP_FILE_MAX_AGE"); v != "" {
+		tempFileMaxAge, err = time.ParseDuration(v)
+		if err != nil || tempFileMaxAge <= 0 {
+			log.Fatal("TEMP_FILE_MAX_AGE must be a positive duration")
+		}
+	}
+
+	thumbnailJPEGQuality := 85
+	if v := os.Getenv("THUMBNAIL_JPEG_QUALITY"); v != "" {
+		thumbnailJPEGQuality, err = strconv.Atoi(v)
+		if err != nil || thumbnailJPEGQuality < 1 || thumbnailJPEGQuality > 100 {
+			log.Fatal("THUMBNAIL_JPEG_QUALITY must be between 1 and 100")
+		}
+	}
+
+	thumbnailVariantSelection := variantSelectionRoundRobin
+	if v := os.Getenv("THUMBNAIL_VARIANT_SELECTION"); v != "" {
+		if v != variantSelectionRoundRobin && v != variantSelectionWeighted {
+			log.Fatal("THUMBNAIL_VARIANT_SELECTION must be round_robin or weighted")
+		}
+		thumbnailVariantSelection = v
+	}
+
+	// Optional: separate buckets per object class, so each can have its own
+	// lifecycle policy. Thumbnails are only stored in S3 when given a bucket.
+	s3ClassBuckets := map[objectClass]string{}
+	for class, env := range map[objectClass]string{
+		objectClassOriginal:  "S3_ORIGINALS_BUCKET",
+		objectClassRendition: "S3_RENDITIONS_BUCKET",
+		objectClassThumbnail: "S3_THUMBNAILS_BUCKET",
+	} {
+		if bucket := os.Getenv(env); bucket != "" {
+			s3ClassBuckets[class] = bucket
+		}
+	}
+
+	// Optional: layout of object keys in the bucket, e.g.
+	// "{env}/{userID}/{videoID}/{rendition}.mp4"
+	s3KeyTemplate := os.Getenv("S3_KEY_TEMPLATE")
+	if s3KeyTemplate != "" {
+		err = validateKeyTemplate(s3KeyTemplate)
+		if err != nil {
+			log.Fatalf("Invalid S3_KEY_TEMPLATE: %v", err)
+		}
+	}
+
+	// Optional: headers stored with uploaded videos. Cache-Control and
+	// Content-Language are sent as given; Content-Disposition is "inline"
+	// (default), "attachment" or "none", with a filename from the title
+	s3CacheControl := os.Getenv("S3_CACHE_CONTROL")
+	s3ContentLanguage := os.Getenv("S3_CONTENT_LANGUAGE")
+	s3ContentDisposition := contentDispositionInline
+	if v := os.Getenv("S3_CONTENT_DISPOSITION"); v != "" {
+		if v != contentDispositionInline && v != contentDispositionAttachment && v != contentDispositionNone {
+			log.Fatal("S3_CONTENT_DISPOSITION must be inline, attachment or none")
+		}
+		s3ContentDisposition = v
+	}
+
+	var uploadPartSize int64 = 8 << 20
+	if v := os.Getenv("S3_UPLOAD_PART_SIZE"); v != "" {
+		uploadPartSize, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || uploadPartSize < minUploadPartSize {
+			log.Fatalf("S3_UPLOAD_PART_SIZE must be at least %d bytes", minUploadPartSize)
+		}
+	}
+
+	uploadConcurrency := 4
+	if v := os.Getenv("S3_UPLOAD_CONCURRENCY"); v != "" {
+		uploadConcurrency, err = strconv.Atoi(v)
+		if err != nil || uploadConcurrency < 1 {
+			log.Fatal("S3_UPLOAD_CONCURRENCY must be a positive integer")
+		}
+	}
+
+	// Optional: cap on upload bandwidth in bytes per second, shared by all uploads
+	var uploadBandwidth int64
+	if v := os.Getenv("S3_UPLOAD_BANDWIDTH"); v != "" {
+		uploadBandwidth, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || uploadBandwidth < 0 {
+			log.Fatal("S3_UPLOAD_BANDWIDTH must be a non-negative number of bytes per second")
+		}
+	}
+
+	// Optional: requester-pays buckets and networks that need a proxy or
+	// their own CA to reach S3
+	s3RequesterPays := false
+	if v := os.Getenv("S3_REQUESTER_PAYS"); v != "" {
+		s3RequesterPays, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatal("S3_REQUESTER_PAYS must be true or false")
+		}
+	}
+
+	// Optional: the checksum S3 stores with uploaded objects, recorded on
+	// videos for later integrity checks
+	checksumAlgorithm, err := parseChecksumAlgorithm(os.Getenv("S3_CHECKSUM_ALGORITHM"))
+	if err != nil {
+		log.Fatalf("Invalid S3_CHECKSUM_ALGORITHM: %v", err)
+	}
+
+	// Optional: an S3-compatible provider to use instead of AWS, "b2" for
+	// Backblaze B2 or "spaces" for DigitalOcean Spaces, with S3_REGION set
+	// to one of its regions. S3_PATH_STYLE puts bucket names in the path,
+	// for providers without virtual-hosted buckets
+	s3Provider, err := parseS3Provider(os.Getenv("S3_PROVIDER"))
+	if err != nil {
+		log.Fatalf("Invalid S3_PROVIDER: %v", err)
+	}
+	// Checksums are on by default, so they're only an error on a provider
+	// without them when asked for
+	if checksumAlgorithm != "" && !s3Provider.checksums {
+		if os.Getenv("S3_CHECKSUM_ALGORITHM") != "" {
+			log.Fatalf("S3_CHECKSUM_ALGORITHM isn't supported by the %s provider", s3Provider.name)
+		}
+		checksumAlgorithm = ""
+	}
+	if s3RequesterPays && !s3Provider.requesterPays {
+		log.Fatalf("S3_REQUESTER_PAYS isn't supported by the %s provider", s3Provider.name)
+	}
+	s3PathStyle := false
+	if v := os.Getenv("S3_PATH_STYLE"); v != "" {
+		s3PathStyle, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatal("S3_PATH_STYLE must be true or false")
+		}
+	}
+
+	var storage objectStorage
+	var localObjects *localStorage
+	var s3Replicas []s3Replica
+	switch storageBackend {
+	case storageBackendLocal:
+		localRoot := os.Getenv("LOCAL_STORAGE_ROOT")
+		if localRoot == "" {
+			localRoot = "storage"
+		}
+		localObjects, err = newLocalStorage(localRoot, "http://localhost:"+port, jwtSecret)
+		if err != nil {
+			log.Fatalf("Couldn't create local storage: %v", err)
+		}
+		if os.Getenv("S3_REPLICAS") != "" {
+			log.Fatal("S3_REPLICAS needs the s3 storage backend")
+		}
+		storage = localObjects
+
+	case storageBackendGCS:
+		// The service account key in GOOGLE_APPLICATION_CREDENTIALS also
+		// signs presigned URLs. GCS_ENDPOINT is for emulators
+		credentialsPath := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		if credentialsPath == "" {
+			log.Fatal("GOOGLE_APPLICATION_CREDENTIALS must be set for the gcs storage backend")
+		}
+		if checksumAlgorithm != types.ChecksumAlgorithmCrc32c {
+			log.Fatal("GCS only stores CRC32C checksums, so S3_CHECKSUM_ALGORITHM must be crc32c")
+		}
+		if s3RequesterPays || os.Getenv("S3_REPLICAS") != "" {
+			log.Fatal("S3_REQUESTER_PAYS and S3_REPLICAS need the s3 storage backend")
+		}
+		gcsObjects, err := newGCSStorage(credentialsPath, os.Getenv("GCS_ENDPOINT"))
+		if err != nil {
+			log.Fatalf("Couldn't create GCS storage: %v", err)
+		}
+		storage = gcsObjects
+
+	case storageBackendAzure:
+		// A connection string authorizes with the account key. Otherwise the
+		// managed identity of the VM or App Service is used, with
+		// AZURE_CLIENT_ID choosing a user-assigned one
+		if os.Getenv("S3_CHECKSUM_ALGORITHM") != "" && checksumAlgorithm != "" {
+			log.Fatal("Azure doesn't store S3's checksums, so S3_CHECKSUM_ALGORITHM must be none")
+		}
+		checksumAlgorithm = ""
+		if s3RequesterPays || os.Getenv("S3_REPLICAS") != "" {
+			log.Fatal("S3_REQUESTER_PAYS and S3_REPLICAS need the s3 storage backend")
+		}
+		var azureObjects *azureStorage
+		if connectionString := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); connectionString != "" {
+			azureObjects, err = newAzureStorageFromConnectionString(connectionString)
+		} else {
+			account := os.Getenv("AZURE_STORAGE_ACCOUNT")
+			if account == "" {
+				log.Fatal("AZURE_STORAGE_CONNECTION_STRING or AZURE_STORAGE_ACCOUNT must be set for the azure storage backend")
+			}
+			identity := newAzureManagedIdentity(os.Getenv("AZURE_CLIENT_ID"), os.Getenv("IDENTITY_ENDPOINT"), os.Getenv("IDENTITY_HEADER"))
+			azureObjects, err = newAzureStorage("https://"+account+".blob.core.windows.net", account, nil, identity)
+		}
+		if err != nil {
+			log.Fatalf("Couldn't create Azure storage: %v", err)
+		}
+		storage = azureObjects
+
+	default:
+		s3Client, err := newS3Client(s3ClientOptions{
+			Region:                s3Region,
+			CABundlePath:          os.Getenv("S3_CA_BUNDLE"),
+			ProxyURL:              os.Getenv("S3_PROXY_URL"),
+			RequesterPays:         s3RequesterPays,
+			UploadBandwidth:       uploadBandwidth,
+			OnlyRequiredChecksums: checksumAlgorithm == "",
+			Provider:              s3Provider,
+			PathStyle:             s3PathStyle,
+		})
+		if err != nil {
+			log.Fatalf("Couldn't create S3 client: %v", err)
+		}
+		storage = newS3Storage(s3Client, s3Provider)
+
+		// Optional: replicas of S3_BUCKET in other regions, as "region=bucket,..."
+		s3Replicas, err = parseS3Replicas(os.Getenv("S3_REPLICAS"), s3Client, s3Provider)
+		if err != nil {
+			log.Fatalf("Invalid S3_REPLICAS: %v", err)
+		}
+	}
+
+	// Optional: where analytics exports are written, S3_BUCKET under
+	// "analytics-exports/" by default, and whether both datasets are
+	// exported every day
+	analyticsExportBucket := os.Getenv("ANALYTICS_EXPORT_BUCKET")
+	if analyticsExportBucket == "" {
+		analyticsExportBucket = s3Bucket
+	}
+	analyticsExportPrefix, ok := os.LookupEnv("ANALYTICS_EXPORT_PREFIX")
+	if !ok {
+		analyticsExportPrefix = "analytics-exports/"
+	}
+	if analyticsExportPrefix != "" && !strings.HasSuffix(analyticsExportPrefix, "/") {
+		analyticsExportPrefix += "/"
+	}
+	analyticsExportDaily := false
+	if v := os.Getenv("ANALYTICS_EXPORT_DAILY"); v != "" {
+		analyticsExportDaily, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatal("ANALYTICS_EXPORT_DAILY must be true or false")
+		}
+	}
+
+	// Optional: where backups are written, S3_BUCKET under "backups/" by
+	// default, the passphrase they're encrypted with, and whether one is
+	// taken every day and how long they're kept, 30 days by default
+	backupBucket := os.Getenv("BACKUP_BUCKET")
+	if backupBucket == "" {
+		backupBucket = s3Bucket
+	}
+	backupPrefix, ok := os.LookupEnv("BACKUP_PREFIX")
+	if !ok {
+		backupPrefix = "backups/"
+	}
+	if backupPrefix != "" && !strings.HasSuffix(backupPrefix, "/") {
+		backupPrefix += "/"
+	}
+	backupDaily := false
+	if v := os.Getenv("BACKUP_DAILY"); v != "" {
+		backupDaily, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatal("BACKUP_DAILY must be true or false")
+		}
+	}
+	// Backups hold every user's account, password hash and linked accounts
+	if backupDaily && os.Getenv("BACKUP_PASSPHRASE") == "" {
+		log.Fatal("BACKUP_DAILY requires BACKUP_PASSPHRASE, so scheduled backups are encrypted")
+	}
+	backupRetention := 30 * 24 * time.Hour
+	if v := os.Getenv("BACKUP_RETENTION"); v != "" {
+		backupRetention, err = time.ParseDuration(v)
+		if err != nil || backupRetention <= 0 {
+			log.Fatal("BACKUP_RETENTION must be a positive duration")
+		}
+	}
+
+	// Optional: IMPORT_SOURCE_URL is an SFTP or FTP directory partners drop
+	// videos in, like "sftp://tubely@partner.example.com/outgoing". Its new
+	// videos are imported every few minutes for IMPORT_USER_EMAIL when
+	// that's set, and admins can import other directories on the server.
+	// SFTP host keys are checked against IMPORT_KNOWN_HOSTS
+	var importSource ingest.Source
+	importDir := ""
+	if importSourceURL := os.Getenv("IMPORT_SOURCE_URL"); importSourceURL != "" {
+		knownHosts := os.Getenv("IMPORT_KNOWN_HOSTS")
+		if home, err := os.UserHomeDir(); knownHosts == "" && err == nil {
+			knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		importSource, importDir, err = ingest.NewSource(importSourceURL, ingest.Options{
+			Password:       os.Getenv("IMPORT_PASSWORD"),
+			PrivateKeyFile: os.Getenv("IMPORT_SSH_KEY_FILE"),
+			KnownHostsFile: knownHosts,
+		})
+		if err != nil {
+			log.Fatalf("Invalid IMPORT_SOURCE_URL: %v", err)
+		}
+	}
+
+	// Optional: OAuth apps for users to import from Google Drive and
+	// Dropbox. Their redirect URI is APP_BASE_URL followed by
+	// /api/linked_accounts/google_drive/callback or
+	// /api/linked_accounts/dropbox/callback
+	clouds := map[string]ingest.Cloud{}
+	cloudClient := &http.Client{}
+	if clientID := os.Getenv("GOOGLE_DRIVE_CLIENT_ID"); clientID != "" {
+		clouds[ingest.CloudGoogleDrive] = ingest.NewGoogleDrive(clientID, os.Getenv("GOOGLE_DRIVE_CLIENT_SECRET"), cloudClient)
+	}
+	if appKey := os.Getenv("DROPBOX_APP_KEY"); appKey != "" {
+		clouds[ingest.CloudDropbox] = ingest.NewDropbox(appKey, os.Getenv("DROPBOX_APP_SECRET"), cloudClient)
+	}
+
+	// Optional: YTDLP_BINARY is the yt-dlp binary, looked up in PATH unless
+	// it's a path, for users to import videos from the pages of the sites
+	// it supports. Like ffprobe and ffmpeg, it runs as this process's user
+	var urlDownloader ingest.Downloader
+	if ytdlpBinary := os.Getenv("YTDLP_BINARY"); ytdlpBinary != "" {
+		urlDownloader = ingest.NewYTDLP(ytdlpBinary, maxVideoUploadSize, observeMediaCommand)
+	}
+
+	// Optional: moderation provider ("none" or "rekognition") run on every
+	// processed video
+	var moderationMinConfidence float64 = 80
+	if v := os.Getenv("MODERATION_MIN_CONFIDENCE"); v != "" {
+		moderationMinConfidence, err = strconv.ParseFloat(v, 32)
+		if err != nil || moderationMinConfidence < 0 || moderationMinConfidence > 100 {
+			log.Fatal("MODERATION_MIN_CONFIDENCE must be a percentage between 0 and 100")
+		}
+	}
+	moderator, err := newModerationProvider(os.Getenv("MODERATION_PROVIDER"), s3Region, float32(moderationMinConfidence))
+	if err != nil {
+		log.Fatalf("Couldn't create moderator: %v", err)
+	}
+
+	// Optional: image classifier ("none" or "rekognition") for uploaded and
+	// extracted thumbnails
+	thumbnailClassifier, err := newModerationProvider(os.Getenv("THUMBNAIL_CLASSIFIER"), s3Region, float32(moderationMinConfidence))
+	if err != nil {
+		log.Fatalf("Couldn't create thumbnail classifier: %v", err)
+	}
+
+	// Optional: speech-to-text provider ("none", "whisper_cpp" or "openai")
+	// that adds a caption track to every processed video
+	whisperBinary := os.Getenv("WHISPER_CPP_BINARY")
+	if whisperBinary == "" {
+		whisperBinary = "whisper-cli"
+	}
+	openAIBaseURL := os.Getenv("OPENAI_BASE_URL")
+	if openAIBaseURL == "" {
+		openAIBaseURL = "https://api.openai.com/v1"
+	}
+	transcriptionModel := os.Getenv("TRANSCRIPTION_MODEL")
+	if transcriptionModel == "" {
+		transcriptionModel = "whisper-1"
+	}
+	transcriber, err := newTranscriber(transcriberOptions{
+		Provider:       os.Getenv("TRANSCRIPTION_PROVIDER"),
+		WhisperBinary:  whisperBinary,
+		WhisperModel:   os.Getenv("WHISPER_CPP_MODEL"),
+		OpenAIBaseURL:  openAIBaseURL,
+		OpenAIAPIKey:   os.Getenv("OPENAI_API_KEY"),
+		OpenAIModel:    transcriptionModel,
+		RequestTimeout: 10 * time.Minute,
+	})
+	if err != nil {
+		log.Fatalf("Couldn't create transcriber: %v", err)
+	}
+
+	// Optional: machine translation provider ("none", "deepl" or
+	// "libretranslate") for translating caption tracks on request
+	deepLBaseURL := os.Getenv("DEEPL_API_URL")
+	if deepLBaseURL == "" {
+		deepLBaseURL = "https://api-free.deepl.com"
+	}
+	translator, err := newTranslator(translatorOptions{
+		Provider:          os.Getenv("TRANSLATION_PROVIDER"),
+		DeepLBaseURL:      deepLBaseURL,
+		DeepLAPIKey:       os.Getenv("DEEPL_API_KEY"),
+		LibreTranslateURL: os.Getenv("LIBRETRANSLATE_URL"),
+		LibreTranslateKey: os.Getenv("LIBRETRANSLATE_API_KEY"),
+		RequestTimeout:    time.Minute,
+	})
+	if err != nil {
+		log.Fatalf("Couldn't create translator: %v", err)
+	}
+
+	// Optional: API requests allowed per client per minute, and videos each
+	// user can upload per day. Unset or 0 means unlimited
+	var rateLimiter *rateLimiter
+	if v := os.Getenv("API_RATE_LIMIT"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			log.Fatal("API_RATE_LIMIT must be a non-negative number of requests per minute")
+		}
+		if limit > 0 {
+			rateLimiter = newRateLimiter(limit, time.Minute)
+		}
+	}
+	uploadQuotaPerDay := 0
+	if v := os.Getenv("UPLOAD_QUOTA_PER_DAY"); v != "" {
+		uploadQuotaPerDay, err = strconv.Atoi(v)
+		if err != nil || uploadQuotaPerDay < 0 {
+			log.Fatal("UPLOAD_QUOTA_PER_DAY must be a non-negative number of uploads")
+		}
+	}
+	// Optional: bytes of processed video and thumbnails each user can
+	// store. Users are notified at 80% and 100%, and uploads are refused
+	// once it's full. Unset or 0 means unlimited
+	var storageQuotaBytes int64
+	if v := os.Getenv("STORAGE_QUOTA_BYTES"); v != "" {
+		storageQuotaBytes, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || storageQuotaBytes < 0 {
+			log.Fatal("STORAGE_QUOTA_BYTES must be a non-negative number of bytes")
+		}
+	}
+	// Optional: what a GB-month costs per object class or S3 storage class
+	// in the storage cost report, e.g. "originals=0.0125,GLACIER_IR=0.004".
+	// Classes left out are priced like S3 in us-east-1
+	storagePricesPerGB, err := parseStoragePrices(os.Getenv("STORAGE_PRICES_PER_GB"))
+	if err != nil {
+		log.Fatalf("Invalid STORAGE_PRICES_PER_GB: %v", err)
+	}
+
+	// Optional: event bus transport ("inprocess", "sqs" or "nats"). Use a
+	// shared transport when running more than one instance
+	natsSubject := os.Getenv("NATS_SUBJECT")
+	if natsSubject == "" {
+		natsSubject = "tubely.events"
+	}
+	eventBus, err := newEventBus(eventBusOptions{
+		Transport:   os.Getenv("EVENT_BUS"),
+		Region:      s3Region,
+		SQSQueueURL: os.Getenv("EVENT_BUS_SQS_QUEUE_URL"),
+		NATSURL:     os.Getenv("NATS_URL"),
+		NATSSubject: natsSubject,
+	})
+	if err != nil {
+		log.Fatalf("Couldn't create event bus: %v", err)
+	}
+
+	// Optional: mailer ("none" or "smtp") for email notifications.
+	// APP_BASE_URL is where links in notifications point
+	smtpPort := os.Getenv("SMTP_PORT")
+	if smtpPort == "" {
+		smtpPort = "587"
+	}
+	mailer, err := newMailer(mailerOptions{
+		Provider:     os.Getenv("MAILER"),
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     smtpPort,
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		From:         os.Getenv("MAIL_FROM"),
+	})
+	if err != nil {
+		log.Fatalf("Couldn't create mailer: %v", err)
+	}
+	appBaseURL := strings.TrimSuffix(os.Getenv("APP_BASE_URL"), "/")
+	if appBaseURL == "" {
+		appBaseURL = "http://localhost:" + port
+	}
+
+	ffmpeg := media.NewFFmpeg("ffprobe", "ffmpeg", observeMediaCommand)
 
 	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
-		s3Client:         s3Client,
+		db:                  db,
+		jwtSecret:           jwtSecret,
+		adminAPIKey:         adminAPIKey,
+		platform:            platform,
+		filepathRoot:        filepathRoot,
+		assetsRoot:          assetsRoot,
+		s3Bucket:            s3Bucket,
+		s3Region:            s3Region,
+		s3CfDistribution:    s3CfDistribution,
+		port:                port,
+		storageBackend:      storageBackend,
+		storage:             storage,
+		localStorage:        localObjects,
+		prober:              ffmpeg,
+		transcoder:          ffmpeg,
+		jobQueue:            make(chan uuid.UUID),
+		moderator:           moderator,
+		thumbnailClassifier: thumbnailClassifier,
+		transcriber:         transcriber,
+		translator:          translator,
+		events:              eventBus,
+		notifications:       newNotificationHub(),
+		webhookClient:       newWebhookClient(),
+		webhookNudge:        make(chan struct{}, 1),
+		appBaseURL:          appBaseURL,
+
+		s3Provider:           s3Provider,
+		s3ClassBuckets:       s3ClassBuckets,
+		s3Replicas:           s3Replicas,
+		s3KeyTemplate:        s3KeyTemplate,
+		s3CacheControl:       s3CacheControl,
+		s3ContentLanguage:    s3ContentLanguage,
+		s3ContentDisposition: s3ContentDisposition,
+		uploadPartSize:       uploadPartSize,
+		uploadConcurrency:    uploadConcurrency,
+		checksumAlgorithm:    checksumAlgorithm,
+
+		thumbnailJPEGQuality:      thumbnailJPEGQuality,
+		thumbnailVariantSelection: thumbnailVariantSelection,
+
+		cache:         sharedCache,
+		locks:         locks,
+		errorReporter: errorReporter,
+		features:      newFeatureFlags(db, featureFlagDefaults),
+		presignLog:    newPresignLog(),
+		usageMeter:    newUsageMeter(),
+		searchIndexer: searchIndexer,
+
+		analyticsExportBucket: analyticsExportBucket,
+		analyticsExportPrefix: analyticsExportPrefix,
+		analyticsExportDaily:  analyticsExportDaily,
+
+		backupBucket:     backupBucket,
+		backupPrefix:     backupPrefix,
+		backupPassphrase: os.Getenv("BACKUP_PASSPHRASE"),
+		backupDaily:      backupDaily,
+		backupRetention:  backupRetention,
+
+		importSource:    importSource,
+		importDir:       importDir,
+		importUserEmail: os.Getenv("IMPORT_USER_EMAIL"),
+		clouds:          clouds,
+		urlDownloader:   urlDownloader,
+
+		rateLimiter:       rateLimiter,
+		uploadQuotaPerDay: uploadQuotaPerDay,
+		storageQuotaBytes: storageQuotaBytes,
+
+		storagePricesPerGB: storagePricesPerGB,
+	}
+
+	// Optional: LOG_LEVEL, LOG_SAMPLE_EVERY, WORKER_CONCURRENCY and
+	// PRESIGN_EXPIRY, which are reloaded on SIGHUP or when CONFIG_FILE
+	// changes. This also starts the workers
+	cfg.workers = newWorkerPool(&cfg)
+	cfg.settings = &runtimeSettings{}
+	settings := map[string]string{}
+	for _, setting := range reloadableSettings {
+		settings[setting.name] = os.Getenv(setting.name)
+	}
+	err = cfg.applySettings(settings)
+	if err != nil {
+		log.Fatal(err)
 	}
+	cfg.watchSettings(configFile, env)
 
 	err = cfg.ensureAssetsDir()
 	if err != nil {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	// Optional: SCHEDULE_INTERVALS overrides how often scheduled jobs run,
+	// e.g. "orphaned_asset_gc=15m,upload_log_prune=6h"
+	cfg.scheduler = newScheduler(db, locks)
+	cfg.registerScheduledJobs(cfg.scheduler, thumbnailCandidateRetention)
+	err = cfg.scheduler.setIntervals(os.Getenv("SCHEDULE_INTERVALS"))
+	if err != nil {
+		log.Fatalf("Invalid SCHEDULE_INTERVALS: %v", err)
+	}
+	cfg.scheduler.start()
+	cfg.notifier = cfg.newNotificationService(mailer)
+	cfg.subscribeEventHandlers()
+	cfg.startWebhookDispatcher()
+	err = cfg.resumeJobs()
+	if err != nil {
+		log.Fatalf("Couldn't resume processing jobs: %v", err)
+	}
+	err = cfg.resumeAnalyticsExports()
+	if err != nil {
+		log.Fatalf("Couldn't resume analytics exports: %v", err)
+	}
+	err = cfg.resumeStorageMigrations()
+	if err != nil {
+		log.Fatalf("Couldn't resume storage migrations: %v", err)
+	}
+	err = cfg.failInterruptedVideoExports()
+	if err != nil {
+		log.Fatalf("Couldn't fail interrupted video exports: %v", err)
+	}
+	err = cfg.sealStoredCloudTokens()
+	if err != nil {
+		log.Fatalf("Couldn't seal linked account tokens: %v", err)
+	}
+	cfg.startTempSweeper(tempFileMaxAge)
+	if searchIndexer != nil {
+		searchIndexer.start(db)
+		if _, ok := searchIndex.(*search.Memory); ok {
+			searchIndexer.rebuildInBackground(db)
+		}
+	}
+	startPresignLogFlusher(cfg.presignLog, db)
+	startUsageMeterFlusher(cfg.usageMeter, db)
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	mux.HandleFunc("GET /assets/{filename}", cfg.handlerAssets)
+	if cfg.localStorage != nil {
+		mux.HandleFunc("GET /storage/{bucket}/{key...}", cfg.handlerLocalStorageGet)
+		mux.HandleFunc("POST /storage/{bucket}", cfg.handlerLocalStoragePost)
+	}
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
+	mux.HandleFunc("DELETE /api/users/me", cfg.handlerUsersDeleteMe)
+	mux.HandleFunc("POST /api/users/me/avatar", cfg.handlerUploadAvatar)
+	mux.HandleFunc("DELETE /api/users/me/avatar", cfg.handlerDeleteAvatar)
+	mux.HandleFunc("POST /api/users/me/banner", cfg.handlerUploadBanner)
+	mux.HandleFunc("DELETE /api/users/me/banner", cfg.handlerDeleteBanner)
+	mux.HandleFunc("GET /api/users/me/settings", cfg.handlerUserSettingsGet)
+	mux.HandleFunc("GET /api/users/me/usage", cfg.handlerUserUsage)
+	mux.HandleFunc("PUT /api/users/me/settings/default_thumbnail", cfg.handlerUserDefaultThumbnailUpload)
+	mux.HandleFunc("DELETE /api/users/me/settings/default_thumbnail", cfg.handlerUserDefaultThumbnailDelete)
+	mux.HandleFunc("GET /api/users/me/notification_preferences", cfg.handlerNotificationPreferencesGet)
+	mux.HandleFunc("PUT /api/users/me/notification_preferences", cfg.handlerNotificationPreferencesUpdate)
+	mux.HandleFunc("GET /api/users/{userID}/feed.rss", validateParams(cfg.handlerUserFeed, pathUUID("userID")))
+	mux.HandleFunc("POST /api/users/{userID}/follow", validateParams(cfg.handlerFollow, pathUUID("userID")))
+	mux.HandleFunc("DELETE /api/users/{userID}/follow", validateParams(cfg.handlerUnfollow, pathUUID("userID")))
+	mux.HandleFunc("GET /api/users/me/subscriptions/videos", validateParams(cfg.handlerSubscriptionVideos, queryCursor(), queryPageLimit()))
+	mux.HandleFunc("GET /api/users/{userID}/profile", validateParams(cfg.handlerUserProfile,
+		pathUUID("userID"),
+		queryOneOf("sort", database.VideoSortCreatedAt, database.VideoSortViews, database.VideoSortLikes),
+		queryOneOf("order", "asc", "desc"),
+		queryCursor(),
+		queryPageLimit(),
+	))
+
+	mux.HandleFunc("GET /api/features", cfg.handlerFeatures)
+	mux.HandleFunc("GET /api/notifications", cfg.handlerNotifications)
+
+	mux.HandleFunc("POST /api/webhooks", cfg.handlerWebhookCreate)
+	mux.HandleFunc("GET /api/webhooks", cfg.handlerWebhooksList)
+	mux.HandleFunc("DELETE /api/webhooks/{webhookID}", validateParams(cfg.handlerWebhookDelete, pathUUID("webhookID")))
+	mux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries", validateParams(cfg.handlerWebhookDeliveriesList, pathUUID("webhookID"), queryCursor(), queryPageLimit()))
+	mux.HandleFunc("POST /api/webhooks/{webhookID}/deliveries/{deliveryID}/redeliver", validateParams(cfg.handlerWebhookRedeliver, pathUUID("webhookID"), pathUUID("deliveryID")))
+
+	mux.HandleFunc("GET /api/linked_accounts", cfg.handlerLinkedAccountsList)
+	mux.HandleFunc("POST /api/linked_accounts/{service}", validateParams(cfg.handlerLinkedAccountStart, pathOneOf("service", cloudServices...)))
+	mux.HandleFunc("GET /api/linked_accounts/{service}/callback", validateParams(cfg.handlerLinkedAccountCallback, pathOneOf("service", cloudServices...)))
+	mux.HandleFunc("DELETE /api/linked_accounts/{service}", validateParams(cfg.handlerLinkedAccountDelete, pathOneOf("service", cloudServices...)))
+	mux.HandleFunc("GET /api/linked_accounts/{service}/files", validateParams(cfg.handlerLinkedAccountFiles, pathOneOf("service", cloudServices...)))
+	mux.HandleFunc("POST /api/linked_accounts/{service}/imports", validateParams(cfg.handlerLinkedAccountImport, pathOneOf("service", cloudServices...)))
+	mux.HandleFunc("GET /api/imports", cfg.handlerImportsList)
+	mux.HandleFunc("POST /api/imports", cfg.handlerURLImportCreate)
+
+	mux.HandleFunc("POST /api/video_exports", cfg.handlerVideoExportCreate)
+	mux.HandleFunc("GET /api/video_exports", cfg.handlerVideoExportsList)
+	mux.HandleFunc("GET /api/video_exports/{exportID}", validateParams(cfg.handlerVideoExportGet, pathUUID("exportID")))
+
+	mux.HandleFunc("POST /api/organizations", cfg.handlerOrganizationCreate)
+	mux.HandleFunc("GET /api/organizations", cfg.handlerOrganizationsList)
+	mux.HandleFunc("GET /api/organizations/{orgID}/members", validateParams(cfg.handlerOrganizationMembersList, pathUUID("orgID")))
+	mux.HandleFunc("PUT /api/organizations/{orgID}/members", validateParams(cfg.handlerOrganizationMemberSet, pathUUID("orgID")))
+	mux.HandleFunc("DELETE /api/organizations/{orgID}/members/{userID}", validateParams(cfg.handlerOrganizationMemberDelete, pathUUID("orgID"), pathUUID("userID")))
 
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", validateParams(cfg.handlerUploadThumbnail, pathUUID("videoID")))
+	mux.HandleFunc("POST /api/video_upload/{videoID}", validateParams(cfg.handlerUploadVideo, pathUUID("videoID")))
+	mux.HandleFunc("POST /api/videos/{videoID}/upload_url", validateParams(cfg.handlerVideoUploadURL, pathUUID("videoID")))
+	mux.HandleFunc("POST /api/videos/{videoID}/finalize", validateParams(cfg.handlerVideoFinalize, pathUUID("videoID")))
+	mux.HandleFunc("POST /api/upload_sessions", cfg.handlerUploadSessionCreate)
+	mux.HandleFunc("GET /api/upload_sessions/{sessionID}", validateParams(cfg.handlerUploadSessionGet, pathUUID("sessionID")))
+	mux.HandleFunc("PUT /api/upload_sessions/{sessionID}/parts/{partNumber}", validateParams(cfg.handlerUploadSessionPart, pathUUID("sessionID"), pathInt("partNumber", 1, maxUploadParts)))
+	mux.HandleFunc("POST /api/upload_sessions/{sessionID}/finalize", validateParams(cfg.handlerUploadSessionFinalize, pathUUID("sessionID")))
+	mux.HandleFunc("DELETE /api/upload_sessions/{sessionID}", validateParams(cfg.handlerUploadSessionAbort, pathUUID("sessionID")))
+	mux.HandleFunc("GET /api/videos", validateParams(cfg.handlerVideosRetrieve,
+		queryOneOf("resolution_class", database.ResolutionClassSD, database.ResolutionClassHD, database.ResolutionClass4K),
+		queryOneOf("hdr_format", database.HDRFormatSDR, database.HDRFormatHDR10, database.HDRFormatHLG, database.HDRFormatDolbyVision),
+		queryBool("hdr"),
+		queryUUID("organization_id"),
+		queryOneOf("aspect_ratio", aspectRatioCategoryNames()...),
+		queryDuration("duration_min"),
+		queryDuration("duration_max"),
+		queryOneOf("status", database.ProcessingStatusDraft, database.ProcessingStatusProcessing, database.ProcessingStatusReady, database.ProcessingStatusFailed),
+		queryOneOf("visibility", database.VisibilityUnlisted, database.VisibilityPublic),
+		queryTag("tag"),
+		queryTime("created_after"),
+		queryTime("created_before"),
+		queryOneOf("sort", database.VideoSortCreatedAt, database.VideoSortViews, database.VideoSortLikes, database.VideoSortDuration, database.VideoSortSize),
+		queryOneOf("order", "asc", "desc"),
+		queryCursor(),
+		queryPageLimit(),
+	))
+	mux.HandleFunc("POST /api/videos/batch-get", cfg.handlerVideosBatchGet)
+	mux.HandleFunc("POST /api/analytics/events", cfg.handlerAnalyticsEvents)
+	mux.HandleFunc("GET /api/search/videos", validateParams(cfg.handlerSearchVideos, querySearchText("q"), queryCursor(), queryPageLimit()))
+	mux.HandleFunc("POST /api/videos/{videoID}/copy", validateParams(cfg.handlerVideoCopy, pathUUID("videoID")))
+	mux.HandleFunc("POST /api/videos/{videoID}/takedowns", validateParams(cfg.handlerTakedownCreate, pathUUID("videoID")))
+	mux.HandleFunc("GET /api/videos/{videoID}/takedowns", validateParams(cfg.handlerVideoTakedownsList, pathUUID("videoID")))
+	mux.HandleFunc("GET /api/takedowns/{takedownID}", validateParams(cfg.handlerTakedownGet, pathUUID("takedownID")))
+	mux.HandleFunc("POST /api/takedowns/{takedownID}/dispute", validateParams(cfg.handlerTakedownDispute, pathUUID("takedownID")))
+	mux.HandleFunc("POST /api/videos/{videoID}/transfer", validateParams(cfg.handlerVideoTransferOffer, pathUUID("videoID")))
+	mux.HandleFunc("GET /api/video_transfers", cfg.handlerVideoTransfersList)
+	mux.HandleFunc("POST /api/video_transfers/{transferID}/accept", validateParams(cfg.handlerVideoTransferAccept, pathUUID("transferID")))
+	mux.HandleFunc("POST /api/video_transfers/{transferID}/decline", validateParams(cfg.handlerVideoTransferDecline, pathUUID("transferID")))
+	mux.HandleFunc("DELETE /api/video_transfers/{transferID}", validateParams(cfg.handlerVideoTransferCancel, pathUUID("transferID")))
+	mux.HandleFunc("GET /api/videos/{videoID}", validateParams(cfg.handlerVideoGet, pathUUID("videoID")))
+	mux.HandleFunc("PATCH /api/videos/{videoID}", validateParams(cfg.handlerVideoMetaUpdate, pathUUID("videoID")))
+	mux.HandleFunc("POST /api/videos/{videoID}/report", validateParams(cfg.handlerVideoReportCreate, pathUUID("videoID")))
+	mux.HandleFunc("PUT /api/videos/{videoID}/like", validateParams(cfg.handlerVideoLike, pathUUID("videoID")))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/like", validateParams(cfg.handlerVideoUnlike, pathUUID("videoID")))
+	mux.HandleFunc("GET /api/videos/{videoID}/media", validateParams(cfg.handlerVideoMedia, pathUUID("videoID")))
+	mux.HandleFunc("GET /api/videos/{videoID}/analytics", validateParams(cfg.handlerVideoAnalytics, pathUUID("videoID"), queryDate("from"), queryDate("to")))
+	mux.HandleFunc("GET /api/videos/{videoID}/status", validateParams(cfg.handlerVideoStatus, pathUUID("videoID")))
+	mux.HandleFunc("GET /api/videos/{videoID}/events", validateParams(cfg.handlerVideoEvents, pathUUID("videoID")))
+	mux.HandleFunc("GET /api/videos/{videoID}/placeholder.svg", validateParams(cfg.handlerThumbnailPlaceholder, pathUUID("videoID")))
+	mux.HandleFunc("GET /api/videos/{videoID}/captions", validateParams(cfg.handlerCaptionsList, pathUUID("videoID")))
+	mux.HandleFunc("POST /api/videos/{videoID}/captions/translations", validateParams(cfg.handlerCaptionsTranslate, pathUUID("videoID")))
+	mux.HandleFunc("POST /api/videos/{videoID}/shares", validateParams(cfg.handlerShareCreate, pathUUID("videoID")))
+	mux.HandleFunc("GET /api/videos/{videoID}/shares", validateParams(cfg.handlerSharesList, pathUUID("videoID")))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/shares/{shareID}", validateParams(cfg.handlerShareDelete, pathUUID("videoID"), pathUUID("shareID")))
+	mux.HandleFunc("GET /api/shares/{token}", cfg.handlerShareGet)
+	mux.HandleFunc("GET /api/shares/{token}/stream", cfg.handlerShareStream)
+	mux.HandleFunc("GET /api/videos/{videoID}/thumbnail_candidates", validateParams(cfg.handlerThumbnailCandidatesList, pathUUID("videoID")))
+	mux.HandleFunc("POST /api/videos/{videoID}/thumbnail_variants", validateParams(cfg.handlerThumbnailVariantCreate,
+		pathUUID("videoID"),
+		formInt("weight", 1, maxThumbnailVariantWeight),
+	))
+	mux.HandleFunc("GET /api/videos/{videoID}/thumbnail_variants", validateParams(cfg.handlerThumbnailVariantsList, pathUUID("videoID")))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/thumbnail_variants/{variantID}", validateParams(cfg.handlerThumbnailVariantDelete, pathUUID("videoID"), pathUUID("variantID")))
+	mux.HandleFunc("POST /api/videos/{videoID}/thumbnail_variants/{variantID}/click", validateParams(cfg.handlerThumbnailVariantClick, pathUUID("videoID"), pathUUID("variantID")))
+	mux.HandleFunc("POST /api/videos/{videoID}/thumbnail_candidates/{candidateID}/select", validateParams(cfg.handlerThumbnailCandidateSelect, pathUUID("videoID"), pathUUID("candidateID")))
 	// mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("DELETE /api/videos/{videoID}", validateParams(cfg.handlerVideoMetaDelete, pathUUID("videoID")))
+
+	mux.HandleFunc("GET /api/admin/metrics", cfg.adminMiddleware(metricsRegistry.ServeHTTP))
+	mux.HandleFunc("POST /api/admin/videos/reprocess_failed", cfg.adminMiddleware(cfg.handlerAdminVideosReprocessFailed))
+	mux.HandleFunc("POST /api/admin/videos/{videoID}/reprocess", cfg.adminMiddleware(validateParams(cfg.handlerAdminVideoReprocess, pathUUID("videoID"))))
+	mux.HandleFunc("GET /api/admin/moderation/queue", cfg.adminMiddleware(cfg.handlerAdminModerationQueue))
+	mux.HandleFunc("GET /api/admin/moderation/thumbnails", cfg.adminMiddleware(cfg.handlerAdminThumbnailModerationQueue))
+	mux.HandleFunc("GET /api/admin/moderation/reports", cfg.adminMiddleware(cfg.handlerAdminVideoReportsQueue))
+	mux.HandleFunc("POST /api/admin/videos/{videoID}/reports/resolution", cfg.adminMiddleware(validateParams(cfg.handlerAdminVideoReportsResolve, pathUUID("videoID"))))
+	mux.HandleFunc("POST /api/admin/videos/{videoID}/moderation", cfg.adminMiddleware(validateParams(cfg.handlerAdminVideoModerate, pathUUID("videoID"))))
+	mux.HandleFunc("POST /api/admin/videos/{videoID}/thumbnail_moderation", cfg.adminMiddleware(validateParams(cfg.handlerAdminThumbnailModerate, pathUUID("videoID"))))
+	mux.HandleFunc("GET /api/admin/takedowns", cfg.adminMiddleware(validateParams(cfg.handlerAdminTakedownsList,
+		queryOneOf("status", database.TakedownStatusFiled, database.TakedownStatusBlocked, database.TakedownStatusRejected,
+			database.TakedownStatusDisputed, database.TakedownStatusReinstated, database.TakedownStatusUpheld),
+	)))
+	mux.HandleFunc("GET /api/admin/takedowns/{takedownID}", cfg.adminMiddleware(validateParams(cfg.handlerAdminTakedownGet, pathUUID("takedownID"))))
+	mux.HandleFunc("POST /api/admin/takedowns/{takedownID}/decision", cfg.adminMiddleware(validateParams(cfg.handlerAdminTakedownDecide, pathUUID("takedownID"))))
+	mux.HandleFunc("PUT /api/admin/videos/{videoID}/legal_hold", cfg.adminMiddleware(validateParams(cfg.handlerAdminVideoLegalHold, pathUUID("videoID"))))
+	mux.HandleFunc("POST /api/admin/videos/{videoID}/transfer", cfg.adminMiddleware(validateParams(cfg.handlerAdminVideoTransferOffer, pathUUID("videoID"))))
+	mux.HandleFunc("GET /api/admin/scheduled_jobs", cfg.adminMiddleware(cfg.handlerAdminScheduledJobs))
+	mux.HandleFunc("POST /api/admin/scheduled_jobs/{name}/run", cfg.adminMiddleware(cfg.handlerAdminScheduledJobRun))
+	mux.HandleFunc("GET /api/admin/storage_audit", cfg.adminMiddleware(cfg.handlerAdminStorageAudit))
+	mux.HandleFunc("GET /api/admin/storage_cost_report", cfg.adminMiddleware(cfg.handlerAdminStorageCostReport))
+	mux.HandleFunc("GET /api/admin/storage_migrations", cfg.adminMiddleware(cfg.handlerAdminStorageMigrationsList))
+	mux.HandleFunc("POST /api/admin/storage_migrations", cfg.adminMiddleware(cfg.handlerAdminStorageMigrationCreate))
+	mux.HandleFunc("GET /api/admin/storage_migrations/{migrationID}", cfg.adminMiddleware(validateParams(cfg.handlerAdminStorageMigrationGet, pathUUID("migrationID"))))
+	mux.HandleFunc("POST /api/admin/search/reindex", cfg.adminMiddleware(cfg.handlerAdminSearchReindex))
+	mux.HandleFunc("GET /api/admin/imports", cfg.adminMiddleware(cfg.handlerAdminImportsList))
+	mux.HandleFunc("POST /api/admin/imports", cfg.adminMiddleware(cfg.handlerAdminImportCreate))
+	mux.HandleFunc("GET /api/admin/analytics/exports", cfg.adminMiddleware(cfg.handlerAdminAnalyticsExportsList))
+	mux.HandleFunc("POST /api/admin/analytics/exports", cfg.adminMiddleware(cfg.handlerAdminAnalyticsExportCreate))
+	mux.HandleFunc("GET /api/admin/analytics/exports/{exportID}", cfg.adminMiddleware(validateParams(cfg.handlerAdminAnalyticsExportGet, pathUUID("exportID"))))
+	mux.HandleFunc("GET /api/admin/backups", cfg.adminMiddleware(cfg.handlerAdminBackupsList))
+	mux.HandleFunc("POST /api/admin/backups", cfg.adminMiddleware(cfg.handlerAdminBackupCreate))
+	mux.HandleFunc("GET /api/admin/billing/usage", cfg.adminMiddleware(validateParams(cfg.handlerAdminBillingUsage, queryMonth("month"), queryCursor(), queryPageLimit())))
+	mux.HandleFunc("GET /api/admin/exposure_report", cfg.adminMiddleware(validateParams(cfg.handlerAdminExposureReport, queryDuration("presigned_within"))))
+	mux.HandleFunc("GET /api/admin/feature_flags", cfg.adminMiddleware(cfg.handlerAdminFeatureFlagsList))
+	mux.HandleFunc("PUT /api/admin/feature_flags/{name}", cfg.adminMiddleware(cfg.handlerAdminFeatureFlagSet))
+	mux.HandleFunc("DELETE /api/admin/feature_flags/{name}", cfg.adminMiddleware(cfg.handlerAdminFeatureFlagDelete))
+	mux.HandleFunc("GET /api/admin/log_level", cfg.adminMiddleware(cfg.handlerAdminLogLevelGet))
+	mux.HandleFunc("PUT /api/admin/log_level", cfg.adminMiddleware(cfg.handlerAdminLogLevelSet))
+	mux.HandleFunc("DELETE /api/admin/users/{userID}", cfg.adminMiddleware(validateParams(cfg.handlerAdminUserDelete, pathUUID("userID"))))
+	if diagnosticsEnabled {
+		cfg.registerDiagnosticsRoutes(mux)
+	}
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
 
 	srv := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+		Addr:      ":" + port,
+		Handler:   localeMiddleware(cfg.errorReportingMiddleware(cfg.rateLimitMiddleware(mux))),
+		Protocols: protocols,
 	}
 
+	if serveTLS {
+		log.Printf("Serving on: https://localhost:%s/app/\n", port)
+		log.Fatal(srv.ListenAndServeTLS(tlsCertFile, tlsKeyFile))
+	}
 	log.Printf("Serving on: http://localhost:%s/app/\n", port)
 	log.Fatal(srv.ListenAndServe())
 }
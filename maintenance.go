@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// staleMultipartAge is how long a multipart upload nothing refers to is
+// kept before it's aborted, so an upload that's only just been started
+// isn't mistaken for an abandoned one.
+const staleMultipartAge = 24 * time.Hour
+
+// recountUsage corrects the recorded sizes storage quotas are counted from
+// against what's actually stored, then samples storage for metering and
+// raises or lowers every user's storage alert to match.
+func (cfg *apiConfig) recountUsage() error {
+	after := uuid.Nil
+	for {
+		videos, err := cfg.db.GetVideosAfter(after, storageAuditBatchSize)
+		if err != nil {
+			return fmt.Errorf("couldn't list videos: %w", err)
+		}
+		for _, video := range videos {
+			cfg.recountVideoSizes(video)
+		}
+		if len(videos) < storageAuditBatchSize {
+			break
+		}
+		after = videos[len(videos)-1].ID
+	}
+
+	err := cfg.meterStorage()
+	if err != nil {
+		return fmt.Errorf("couldn't meter storage: %w", err)
+	}
+	used, err := cfg.db.GetStorageUsedByUser()
+	if err != nil {
+		return err
+	}
+	for userID := range used {
+		cfg.checkStorageAlerts(userID)
+	}
+	return nil
+}
+
+// recountVideoSizes records the stored size of a video's rendition and
+// thumbnail where it's missing or wrong.
+func (cfg *apiConfig) recountVideoSizes(video database.Video) {
+	var videoSize, thumbnailSize *int64
+	if video.VideoURL != nil && isBucketKey(*video.VideoURL) {
+		size, err := cfg.storedObjectSize(database.AssetStorageS3, *video.VideoURL)
+		if err == nil {
+			videoSize = &size
+		} else if !errors.Is(err, os.ErrNotExist) {
+			logging.Warnf("Couldn't size video of %s: %v", video.ID, err)
+		}
+	}
+	if video.ThumbnailURL != nil {
+		if storage, location, ok := cfg.thumbnailAsset(*video.ThumbnailURL); ok {
+			size, err := cfg.storedObjectSize(storage, location)
+			if err == nil {
+				thumbnailSize = &size
+			} else if !errors.Is(err, os.ErrNotExist) {
+				logging.Warnf("Couldn't size thumbnail of %s: %v", video.ID, err)
+			}
+		}
+	}
+	if !sizeChanged(video.VideoSize, videoSize) && !sizeChanged(video.ThumbnailSize, thumbnailSize) {
+		return
+	}
+
+	_, err := cfg.changeVideo(video.ID, func(current *database.Video) bool {
+		// The objects may have been replaced since they were sized
+		changed := false
+		if videoSize != nil && current.VideoURL != nil && *current.VideoURL == *video.VideoURL && sizeChanged(current.VideoSize, videoSize) {
+			current.VideoSize = videoSize
+			changed = true
+		}
+		if thumbnailSize != nil && current.ThumbnailURL != nil && *current.ThumbnailURL == *video.ThumbnailURL && sizeChanged(current.ThumbnailSize, thumbnailSize) {
+			current.ThumbnailSize = thumbnailSize
+			changed = true
+		}
+		return changed
+	})
+	if err != nil {
+		logging.Warnf("Couldn't record recounted sizes of video %s: %v", video.ID, err)
+		return
+	}
+	logging.Infof("Recounted stored sizes of video %s", video.ID)
+}
+
+// sizeChanged reports whether a stored size, if it could be found, differs
+// from the recorded one.
+func sizeChanged(recorded, stored *int64) bool {
+	return stored != nil && (recorded == nil || *recorded != *stored)
+}
+
+// abortStaleMultipartUploads aborts the multipart uploads in every bucket
+// videos are stored in that no open upload session or unfinished job
+// refers to, once they're older than staleMultipartAge. S3 keeps charging
+// for the parts of an upload until it's completed or aborted.
+func (cfg *apiConfig) abortStaleMultipartUploads() error {
+	active, err := cfg.db.GetActiveMultipartUploadIDs()
+	if err != nil {
+		return fmt.Errorf("couldn't list active uploads: %w", err)
+	}
+	buckets := []string{cfg.s3Bucket}
+	for _, bucket := range cfg.s3ClassBuckets {
+		if !slices.Contains(buckets, bucket) {
+			buckets = append(buckets, bucket)
+		}
+	}
+
+	cutoff := time.Now().Add(-staleMultipartAge)
+	aborted := 0
+	for _, bucket := range buckets {
+		paginator := s3.NewListMultipartUploadsPaginator(cfg.storage, &s3.ListMultipartUploadsInput{Bucket: &bucket})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(context.TODO())
+			if err != nil {
+				return fmt.Errorf("couldn't list uploads in %s: %w", bucket, err)
+			}
+			for _, upload := range page.Uploads {
+				uploadID := aws.ToString(upload.UploadId)
+				if active[uploadID] || upload.Initiated == nil || upload.Initiated.After(cutoff) {
+					continue
+				}
+				_, err := cfg.storage.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+					Bucket:   &bucket,
+					Key:      upload.Key,
+					UploadId: upload.UploadId,
+				})
+				if err != nil {
+					logging.Warnf("Couldn't abort upload %s of %s: %v", uploadID, aws.ToString(upload.Key), err)
+					continue
+				}
+				aborted++
+			}
+		}
+	}
+	if aborted > 0 {
+		logging.Infof("Aborted %d stale multipart uploads", aborted)
+	}
+	return nil
+}
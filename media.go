@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+)
+
+// Limits on metadata copied from container tags
+const (
+	maxTagTitleLength       = 256
+	maxTagDescriptionLength = 5000
+)
+
+// fillFromContainerTags pre-fills an empty title or description from the
+// title and comment tags an editor embedded in the file. It reports whether
+// anything changed.
+func fillFromContainerTags(video *database.Video, probe media.ProbeResult) bool {
+	changed := false
+	if strings.TrimSpace(video.Title) == "" {
+		if title := truncateRunes(probe.FormatTag("title"), maxTagTitleLength); title != "" {
+			video.Title = title
+			changed = true
+		}
+	}
+	if strings.TrimSpace(video.Description) == "" {
+		if description := truncateRunes(probe.FormatTag("comment", "description"), maxTagDescriptionLength); description != "" {
+			video.Description = description
+			changed = true
+		}
+	}
+	return changed
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return strings.TrimSpace(string(runes[:n]))
+}
+
+// probeAudioTracks lists the audio streams in order, marking the ones in keep as
+// kept. An empty keep list keeps every track.
+func probeAudioTracks(probe media.ProbeResult, keep []int) database.AudioTracks {
+	tracks := database.AudioTracks{}
+	for _, stream := range probe.Streams {
+		if stream.CodecType != "audio" {
+			continue
+		}
+		index := len(tracks)
+		tracks = append(tracks, database.AudioTrack{
+			Index:    index,
+			Codec:    stream.CodecName,
+			Channels: stream.Channels,
+			Language: stream.Tags.Language,
+			Title:    stream.Tags.Title,
+			Default:  stream.Disposition.Default == 1,
+			Kept:     len(keep) == 0 || slices.Contains(keep, index),
+		})
+	}
+	return tracks
+}
+
+// parseTrackSelection parses a comma-separated list of track indices such as
+// "0,2". An empty string selects nothing, which means "keep everything".
+func parseTrackSelection(value string) ([]int, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+	var indices []int
+	for _, part := range strings.Split(value, ",") {
+		index, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("invalid track index %q", part)
+		}
+		if !slices.Contains(indices, index) {
+			indices = append(indices, index)
+		}
+	}
+	return indices, nil
+}
+
+// getResolutionClass buckets the first video stream into SD, HD or 4K by its
+// long and short edges, so portrait videos classify the same as landscape.
+func getResolutionClass(probe media.ProbeResult) (string, error) {
+	stream, ok := probe.VideoStream()
+	if !ok {
+		return "", fmt.Errorf("no video stream found in video")
+	}
+	long, short := max(stream.Width, stream.Height), min(stream.Width, stream.Height)
+	switch {
+	case long >= 3840 || short >= 2160:
+		return database.ResolutionClass4K, nil
+	case long >= 1280 || short >= 720:
+		return database.ResolutionClassHD, nil
+	default:
+		return database.ResolutionClassSD, nil
+	}
+}
+
+// getHDRFormat inspects the transfer characteristics of the first video
+// stream: PQ is HDR10 (or Dolby Vision when its configuration record is
+// present) and ARIB STD-B67 is HLG. Everything else is SDR.
+func getHDRFormat(probe media.ProbeResult) string {
+	stream, ok := probe.VideoStream()
+	if !ok {
+		return database.HDRFormatSDR
+	}
+	for _, sideData := range stream.SideDataList {
+		if strings.HasPrefix(sideData.SideDataType, "DOVI") {
+			return database.HDRFormatDolbyVision
+		}
+	}
+	switch stream.ColorTransfer {
+	case "smpte2084":
+		return database.HDRFormatHDR10
+	case "arib-std-b67":
+		return database.HDRFormatHLG
+	default:
+		return database.HDRFormatSDR
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// usageMeterFlushInterval is how often the usage metered on this instance
+// is added to the database, so streaming a video doesn't write usage on
+// the request. Usage not yet flushed is lost on a crash.
+const usageMeterFlushInterval = 30 * time.Second
+
+// Billing units the metered usage is reported in, and billing periods.
+const (
+	bytesPerGB         = 1e9
+	secondsPerMinute   = 60
+	billingMonthFormat = "2006-01"
+)
+
+type usageMeterKey struct {
+	userID uuid.UUID
+	day    string
+	metric string
+}
+
+// usageMeter adds up billable usage until it's flushed.
+type usageMeter struct {
+	mu      sync.Mutex
+	pending map[usageMeterKey]float64
+}
+
+func newUsageMeter() *usageMeter {
+	return &usageMeter{pending: map[usageMeterKey]float64{}}
+}
+
+// add meters quantity of a metric for a user, on the current UTC day.
+func (m *usageMeter) add(userID uuid.UUID, metric string, quantity float64) {
+	if quantity <= 0 {
+		return
+	}
+	key := usageMeterKey{userID: userID, day: time.Now().UTC().Format(analyticsDayFormat), metric: metric}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending[key] += quantity
+}
+
+// meterEgress meters a video's whole file as egress for its owner, when
+// it's streamed.
+func (cfg *apiConfig) meterEgress(video database.Video) {
+	if cfg.usageMeter != nil && video.VideoSize != nil {
+		cfg.usageMeter.add(video.UserID, database.UsageMetricEgressBytes, float64(*video.VideoSize))
+	}
+}
+
+// take returns the pending usage and starts adding up afresh.
+func (m *usageMeter) take() []database.UsageRecord {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = map[usageMeterKey]float64{}
+	m.mu.Unlock()
+
+	records := make([]database.UsageRecord, 0, len(pending))
+	for key, quantity := range pending {
+		records = append(records, database.UsageRecord{UserID: key.userID, Day: key.day, Metric: key.metric, Quantity: quantity})
+	}
+	return records
+}
+
+// putBack adds usage taken but not recorded back to the pending usage.
+func (m *usageMeter) putBack(records []database.UsageRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, record := range records {
+		m.pending[usageMeterKey{userID: record.UserID, day: record.Day, metric: record.Metric}] += record.Quantity
+	}
+}
+
+// startUsageMeterFlusher adds the metered usage to db every
+// usageMeterFlushInterval.
+func startUsageMeterFlusher(m *usageMeter, db database.Client) {
+	go func() {
+		ticker := time.NewTicker(usageMeterFlushInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			records := m.take()
+			if len(records) == 0 {
+				continue
+			}
+			err := db.AddUsage(records)
+			if err != nil {
+				// Kept for the next flush, so a database outage doesn't
+				// go unbilled
+				logging.Warnf("Couldn't record %d usage records, retrying on the next flush: %v", len(records), err)
+				m.putBack(records)
+			}
+		}
+	}()
+}
+
+// meterStorage samples what every user stores, keeping each user's most
+// for the day.
+func (cfg *apiConfig) meterStorage() error {
+	used, err := cfg.db.GetStorageUsedByUser()
+	if err != nil {
+		return err
+	}
+	return cfg.db.RecordStorageUsage(time.Now().UTC().Format(analyticsDayFormat), used)
+}
+
+// billingUsage is a user's usage over a range of days in the units it's
+// billed in, alongside the metered amounts.
+type billingUsage struct {
+	database.UsageTotals
+	StorageGBDays    float64 `json:"storage_gb_days"`
+	TranscodeMinutes float64 `json:"transcode_minutes"`
+	EgressGB         float64 `json:"egress_gb"`
+}
+
+func newBillingUsage(totals database.UsageTotals) billingUsage {
+	return billingUsage{
+		UsageTotals:      totals,
+		StorageGBDays:    totals.StorageByteDays / bytesPerGB,
+		TranscodeMinutes: totals.TranscodeSeconds / secondsPerMinute,
+		EgressGB:         totals.EgressBytes / bytesPerGB,
+	}
+}
+
+// billingMonthDays returns the first and last day of a month formatted
+// like 2006-01.
+func billingMonthDays(month string) (string, string, error) {
+	start, err := time.Parse(billingMonthFormat, month)
+	if err != nil {
+		return "", "", err
+	}
+	return start.Format(analyticsDayFormat), start.AddDate(0, 1, -1).Format(analyticsDayFormat), nil
+}
+
+// exportBillingUsage exports last month's usage once, after the first day
+// of this month, by when the last of it is long flushed.
+func (cfg *apiConfig) exportBillingUsage() error {
+	now := time.Now().UTC()
+	if now.Day() == 1 {
+		return nil
+	}
+	lastMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0).Format(billingMonthFormat)
+	first, last, err := billingMonthDays(lastMonth)
+	if err != nil {
+		return err
+	}
+	exported, err := cfg.db.HasAnalyticsExport(database.AnalyticsDatasetBillingUsage, first, last)
+	if err != nil || exported {
+		return err
+	}
+
+	export, err := cfg.db.CreateAnalyticsExport(database.CreateAnalyticsExportParams{
+		Dataset:     database.AnalyticsDatasetBillingUsage,
+		Format:      analyticsExportCSV,
+		FromDay:     first,
+		ToDay:       last,
+		RequestedBy: "schedule",
+	})
+	if err != nil {
+		return err
+	}
+	_, err = cfg.runAnalyticsExport(export)
+	if err != nil {
+		cfg.db.FailAnalyticsExport(export.ID, err.Error())
+		return fmt.Errorf("couldn't export usage for %s: %w", lastMonth, err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestEgressMetering(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	cfg.usageMeter = newUsageMeter()
+	userID, _ := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, userID)
+	videoURL := testBucket + ",landscape/clip.mp4"
+	size := int64(1000)
+	video.VideoURL, video.VideoSize = &videoURL, &size
+	err := cfg.db.UpdateVideo(video)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Listing videos presigns them without anything being downloaded
+	_, err = cfg.dbVideoToSignedVideo(video, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records := cfg.usageMeter.take(); len(records) != 0 {
+		t.Errorf("presigning metered %+v, want nothing", records)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/videos/"+video.ID.String()+"/media", nil)
+	r.SetPathValue("videoID", video.ID.String())
+	w := httptest.NewRecorder()
+	cfg.handlerVideoMedia(w, r)
+	if w.Code != http.StatusFound {
+		t.Fatalf("media status = %d: %s", w.Code, w.Body)
+	}
+	records := cfg.usageMeter.take()
+	if len(records) != 1 || records[0].Metric != database.UsageMetricEgressBytes || records[0].Quantity != float64(size) {
+		t.Fatalf("streaming metered %+v, want %d bytes of egress", records, size)
+	}
+
+	// Usage that couldn't be recorded is flushed again with what's metered since
+	cfg.usageMeter.putBack(records)
+	cfg.meterEgress(video)
+	records = cfg.usageMeter.take()
+	if len(records) != 1 || records[0].Quantity != float64(2*size) {
+		t.Errorf("metered after putting back %+v, want %d bytes of egress", records, 2*size)
+	}
+}
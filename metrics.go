@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/metrics"
+)
+
+var metricsRegistry = metrics.NewRegistry()
+
+var (
+	uploadPartDuration = metricsRegistry.NewHistogram(
+		"tubely_s3_upload_part_duration_seconds",
+		"Time taken to upload one part of a multipart upload to S3.",
+		[]float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	)
+	uploadPartBytes = metricsRegistry.NewCounter(
+		"tubely_s3_upload_part_bytes_total",
+		"Bytes uploaded to S3 in multipart upload parts.",
+	)
+	uploadPartFailures = metricsRegistry.NewCounter(
+		"tubely_s3_upload_part_failures_total",
+		"Multipart upload parts that failed to upload.",
+	)
+)
+
+// Processing stages timed by processingStageDuration
+const (
+	processingStageProbe     = media.StepProbe
+	processingStageFastStart = media.StepFastStart
+	processingStageUpload    = "upload"
+)
+
+var (
+	processingStageDuration = metricsRegistry.NewHistogramVec(
+		"tubely_processing_stage_duration_seconds",
+		"Time taken by each stage of processing a video, successful or not.",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+		"stage",
+	)
+	processingInputBytes = metricsRegistry.NewHistogram(
+		"tubely_processing_input_bytes",
+		"Size of the source files videos are processed from.",
+		[]float64{1 << 20, 10 << 20, 50 << 20, 100 << 20, 250 << 20, 500 << 20, 1 << 30},
+	)
+	mediaCommandExits = metricsRegistry.NewCounterVec(
+		"tubely_media_command_exits_total",
+		"Runs of ffprobe and ffmpeg during processing by stage and exit code. Commands that couldn't be started have exit code \"none\".",
+		"stage", "exit_code",
+	)
+)
+
+var (
+	tempSweepFiles = metricsRegistry.NewCounter(
+		"tubely_temp_sweep_files_removed_total",
+		"Stale temporary files removed by the temporary file sweeper.",
+	)
+	tempSweepBytes = metricsRegistry.NewCounter(
+		"tubely_temp_sweep_reclaimed_bytes_total",
+		"Bytes reclaimed by the temporary file sweeper.",
+	)
+)
+
+var playbackEventsRecorded = metricsRegistry.NewCounterVec(
+	"tubely_playback_events_total",
+	"Playback events recorded from video players by type.",
+	"type",
+)
+
+// observeStage records how long a processing stage took since start.
+func observeStage(stage string, start time.Time) {
+	processingStageDuration.With(stage).Observe(time.Since(start).Seconds())
+}
+
+// observeMediaCommand records the exit code of the ffprobe or ffmpeg run
+// behind a stage, from the error it returned.
+func observeMediaCommand(stage string, err error) {
+	exitCode := "0"
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = strconv.Itoa(exitErr.ExitCode())
+	} else if err != nil {
+		exitCode = "none"
+	}
+	mediaCommandExits.With(stage, exitCode).Inc()
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/rekognition"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/moderation"
+	"github.com/google/uuid"
+)
+
+// moderationFrameCount is how many frames are sampled from each video for
+// moderation.
+const moderationFrameCount = 5
+
+// moderationProvider can moderate both whole videos and single images.
+type moderationProvider interface {
+	moderation.Moderator
+	moderation.ImageClassifier
+}
+
+// newModerationProvider builds a provider by name: "none" or "rekognition".
+func newModerationProvider(provider, region string, minConfidence float32) (moderationProvider, error) {
+	switch provider {
+	case "", "none":
+		return moderation.Noop{}, nil
+	case "rekognition":
+		sdkConfig, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+		if err != nil {
+			return nil, err
+		}
+		return moderation.NewRekognition(rekognition.NewFromConfig(sdkConfig), minConfidence), nil
+	default:
+		return nil, fmt.Errorf("unknown moderation provider %q", provider)
+	}
+}
+
+// moderateVideo samples frames from the source and asks the moderator about
+// them. Flagged videos, and videos the moderator couldn't check, are held
+// for review rather than published. A rejection by a moderator sticks
+// across reprocessing.
+func (cfg *apiConfig) moderateVideo(video database.Video, sourcePath string, duration time.Duration) (string, database.ModerationLabels) {
+	if video.ModerationStatus == database.ModerationStatusRejected {
+		return video.ModerationStatus, video.ModerationLabels
+	}
+
+	frames, err := cfg.sampleFrames(sourcePath, duration, moderationFrameCount)
+	if err != nil {
+		logging.Warnf("Couldn't sample frames to moderate video %s, holding for review: %v", video.ID, err)
+		return database.ModerationStatusPendingReview, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	result, err := cfg.moderator.ModerateVideo(ctx, moderation.Input{
+		Title:       video.Title,
+		Description: video.Description,
+		Frames:      frames,
+	})
+	if err != nil {
+		logging.Warnf("Couldn't moderate video %s, holding for review: %v", video.ID, err)
+		return database.ModerationStatusPendingReview, nil
+	}
+	if !result.Flagged {
+		return database.ModerationStatusApproved, nil
+	}
+
+	labels := make(database.ModerationLabels, len(result.Labels))
+	for i, label := range result.Labels {
+		labels[i] = database.ModerationLabel{Name: label.Name, Confidence: label.Confidence}
+	}
+	logging.Infof("Video %s was flagged for review: %v", video.ID, labels)
+	return database.ModerationStatusPendingReview, labels
+}
+
+// sampleFrames extracts count evenly spaced frames from a video as JPEGs.
+func (cfg *apiConfig) sampleFrames(sourcePath string, duration time.Duration, count int) ([][]byte, error) {
+	dir, err := os.MkdirTemp("", "tubely-frames")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	var frames [][]byte
+	for i := 1; i <= count; i++ {
+		// Without a known duration, fall back to the first few seconds
+		offset := time.Duration(i) * time.Second
+		if duration > 0 {
+			offset = duration * time.Duration(i) / time.Duration(count+1)
+		}
+
+		framePath := filepath.Join(dir, fmt.Sprintf("%d.jpg", i))
+		err := cfg.transcoder.ExtractFrame(context.TODO(), sourcePath, offset, framePath)
+		if err != nil {
+			return nil, err
+		}
+		frame, err := os.ReadFile(framePath)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// classifyThumbnail reports whether a thumbnail needs review before it can
+// be published. Images the classifier couldn't check are held too.
+func (cfg *apiConfig) classifyThumbnail(videoID uuid.UUID, image []byte) (bool, database.ModerationLabels) {
+	return cfg.classifyImage(fmt.Sprintf("thumbnail for video %s", videoID), image)
+}
+
+// classifyImage screens an uploaded image, described in logs by subject.
+// Images the classifier couldn't check count as flagged.
+func (cfg *apiConfig) classifyImage(subject string, image []byte) (bool, database.ModerationLabels) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := cfg.thumbnailClassifier.ClassifyImage(ctx, image)
+	if err != nil {
+		logging.Warnf("Couldn't classify %s, treating it as flagged: %v", subject, err)
+		return true, nil
+	}
+	if !result.Flagged {
+		return false, nil
+	}
+
+	labels := make(database.ModerationLabels, len(result.Labels))
+	for i, label := range result.Labels {
+		labels[i] = database.ModerationLabel{Name: label.Name, Confidence: label.Confidence}
+	}
+	logging.Infof("Flagged %s: %v", subject, labels)
+	return true, labels
+}
+
+// holdThumbnail records a flagged thumbnail as pending review in place of
+// publishing it, replacing any thumbnail that was already pending. The
+// caller saves the video.
+func (cfg *apiConfig) holdThumbnail(video *database.Video, thumbnailURL string, labels database.ModerationLabels) {
+	previousPendingURL := video.PendingThumbnailURL
+	video.PendingThumbnailURL = &thumbnailURL
+	video.PendingThumbnailLabels = labels
+	if storage, location, ok := cfg.thumbnailAsset(thumbnailURL); ok {
+		cfg.trackAsset(video.ID, database.AssetKindThumbnail, storage, location)
+	}
+	if previousPendingURL != nil && *previousPendingURL != thumbnailURL &&
+		(video.ThumbnailURL == nil || *previousPendingURL != *video.ThumbnailURL) {
+		cfg.releaseThumbnail(video.ID, previousPendingURL)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mail"
+)
+
+// Notification channels, in the order they're delivered to.
+const (
+	channelInApp   = "in_app"
+	channelEmail   = "email"
+	channelSlack   = "slack"
+	channelWebhook = "webhook"
+)
+
+var notificationChannels = []string{channelInApp, channelEmail, channelSlack, channelWebhook}
+
+// notificationType is an event users can be notified about. Subject and
+// body are templates over the event's data, plus "link" for events about a
+// video. Channels that deliver to people use them; webhooks and in-app
+// notifications get the event's data as is.
+type notificationType struct {
+	subject *template.Template
+	body    *template.Template
+	// defaultChannels get the event unless the user turns them off for it
+	defaultChannels []string
+}
+
+var notificationTypes = map[string]notificationType{
+	events.TypeVideoProcessed: newNotificationType(
+		`Your video "{{.title}}" is ready`,
+		"Your video \"{{.title}}\" has finished processing and is ready to watch:\n\n{{.link}}",
+		channelInApp, channelEmail, channelSlack, channelWebhook,
+	),
+	events.TypeVideoFailed: newNotificationType(
+		`Your video "{{.title}}" couldn't be processed`,
+		"Your video \"{{.title}}\" couldn't be processed:\n\n{{.error}}\n\nYou can upload it again here:\n\n{{.link}}",
+		channelInApp, channelEmail, channelSlack, channelWebhook,
+	),
+	events.TypeVideoDeleted: newNotificationType(
+		`Your video "{{.title}}" was deleted`,
+		`Your video "{{.title}}" was deleted.`,
+		channelWebhook,
+	),
+	events.TypeQuotaExceeded: newNotificationType(
+		"You've reached your upload quota",
+		"You've uploaded {{.limit}} videos today, which is your daily limit. You can upload again tomorrow.",
+		channelInApp, channelWebhook,
+	),
+	events.TypeStorageQuotaWarning: newNotificationType(
+		"You've used {{.threshold_percent}}% of your storage",
+		"Your videos take up {{.used}} of your {{.limit}} storage quota. Once it's full you won't be able to upload more until you delete some.",
+		channelInApp, channelEmail, channelWebhook,
+	),
+	events.TypeStorageQuotaFull: newNotificationType(
+		"Your storage is full",
+		"Your videos take up {{.used}}, which fills your {{.limit}} storage quota. Delete some videos to upload more.",
+		channelInApp, channelEmail, channelWebhook,
+	),
+	events.TypeVideoBlocked: newNotificationType(
+		`Your video "{{.title}}" was blocked`,
+		"Your video \"{{.title}}\" is unavailable to viewers because of a takedown. You can see the takedown and dispute it here:\n\n{{.link}}",
+		channelInApp, channelWebhook,
+	),
+	events.TypeVideoPublished: newNotificationType(
+		`Your video "{{.title}}" is published`,
+		"Your video \"{{.title}}\" is now public, and your followers have been told about it:\n\n{{.link}}",
+		channelWebhook,
+	),
+	events.TypeSubscriptionVideoPublished: newNotificationType(
+		`New video: "{{.title}}"`,
+		"A channel you follow published \"{{.title}}\":\n\n{{.link}}",
+		channelInApp, channelWebhook,
+	),
+	events.TypeVideoReinstated: newNotificationType(
+		`Your video "{{.title}}" was reinstated`,
+		"Your video \"{{.title}}\" is available to viewers again:\n\n{{.link}}",
+		channelInApp, channelWebhook,
+	),
+}
+
+func newNotificationType(subject, body string, defaultChannels ...string) notificationType {
+	return notificationType{
+		subject:         template.Must(template.New("subject").Parse(subject)),
+		body:            template.Must(template.New("body").Parse(body)),
+		defaultChannels: defaultChannels,
+	}
+}
+
+// notification is one event on its way to a user, worded for people.
+type notification struct {
+	event    events.Event
+	user     database.User
+	settings database.NotificationSettings
+	subject  string
+	body     string
+}
+
+// notificationChannel delivers notifications one way. Channels that are
+// slow to deliver should queue rather than hold up the event handler.
+type notificationChannel interface {
+	deliver(ctx context.Context, n notification) error
+}
+
+// notificationService sends each event a user can be notified about to the
+// channels they want it on. Adding an event type only takes an entry in
+// notificationTypes.
+type notificationService struct {
+	db         database.Client
+	channels   map[string]notificationChannel
+	appBaseURL string
+}
+
+// wantsNotification reports whether a user gets an event type on a
+// channel. Turning a channel off overrides their choices for event types.
+func wantsNotification(settings database.NotificationSettings, eventType, channel string) bool {
+	if setting, ok := settings.Channels[channel]; ok && !setting.Enabled {
+		return false
+	}
+	if enabled, ok := settings.Events[eventType][channel]; ok {
+		return enabled
+	}
+	return slices.Contains(notificationTypes[eventType].defaultChannels, channel)
+}
+
+// handleEvent notifies the event's user on each channel they want it on.
+// A failing channel is logged and doesn't stop the others; retrying the
+// event would repeat the notifications that did go out.
+func (s *notificationService) handleEvent(ctx context.Context, event events.Event) error {
+	notificationType, ok := notificationTypes[event.Type]
+	if !ok {
+		return nil
+	}
+
+	settings, err := s.db.GetNotificationSettings(event.UserID)
+	if err != nil {
+		return err
+	}
+	channels := []string{}
+	for _, name := range notificationChannels {
+		if s.channels[name] != nil && wantsNotification(settings, event.Type, name) {
+			channels = append(channels, name)
+		}
+	}
+	if len(channels) == 0 {
+		return nil
+	}
+	user, err := s.db.GetUser(event.UserID)
+	if err != nil {
+		return err
+	}
+	// The account was deleted after the event was published
+	if user == nil {
+		return nil
+	}
+
+	data := map[string]any{}
+	err = json.Unmarshal(event.Data, &data)
+	if err != nil {
+		return fmt.Errorf("couldn't decode %s event: %w", event.Type, err)
+	}
+	if videoID, ok := data["video_id"].(string); ok {
+		data["link"] = s.videoLink(videoID)
+	}
+	n := notification{event: event, user: *user, settings: settings}
+	n.subject, err = renderNotification(notificationType.subject, data)
+	if err != nil {
+		return fmt.Errorf("couldn't render %s subject: %w", event.Type, err)
+	}
+	n.body, err = renderNotification(notificationType.body, data)
+	if err != nil {
+		return fmt.Errorf("couldn't render %s body: %w", event.Type, err)
+	}
+
+	for _, name := range channels {
+		err := s.channels[name].deliver(ctx, n)
+		if err != nil {
+			logging.Warnf("Couldn't deliver %s event %s on channel %s: %v", event.Type, event.ID, name, err)
+		}
+	}
+	return nil
+}
+
+// videoLink is where a notification sends a user to see their video in the
+// app.
+func (s *notificationService) videoLink(videoID string) string {
+	return fmt.Sprintf("%s/app/?video=%s", s.appBaseURL, videoID)
+}
+
+func renderNotification(tmpl *template.Template, data map[string]any) (string, error) {
+	var b strings.Builder
+	err := tmpl.Execute(&b, data)
+	return b.String(), err
+}
+
+// inAppChannel pushes the event to the user's connected clients.
+type inAppChannel struct {
+	hub *notificationHub
+}
+
+func (c inAppChannel) deliver(ctx context.Context, n notification) error {
+	message, err := json.Marshal(n.event)
+	if err != nil {
+		return err
+	}
+	c.hub.notify(n.event.UserID, message)
+	return nil
+}
+
+// webhookChannel queues a delivery to each of the user's webhooks that
+// subscribed to the event.
+type webhookChannel struct {
+	cfg *apiConfig
+}
+
+func (c webhookChannel) deliver(ctx context.Context, n notification) error {
+	return c.cfg.fireWebhookEvent(n.event.UserID, n.event.Type, n.event.Data)
+}
+
+// deliveryQueue runs slow sends, like email, off the event handlers, one at
+// a time. Sends that can't be queued are dropped rather than holding up
+// events.
+type deliveryQueue struct {
+	name    string
+	sends   chan deliveryQueueSend
+	timeout time.Duration
+}
+
+type deliveryQueueSend struct {
+	description string
+	send        func(ctx context.Context) error
+}
+
+func startDeliveryQueue(name string, size int, timeout time.Duration) *deliveryQueue {
+	q := &deliveryQueue{name: name, sends: make(chan deliveryQueueSend, size), timeout: timeout}
+	go func() {
+		for s := range q.sends {
+			ctx, cancel := context.WithTimeout(context.Background(), q.timeout)
+			err := s.send(ctx)
+			cancel()
+			if err != nil {
+				logging.Warnf("Couldn't send %s %s: %v", q.name, s.description, err)
+			}
+		}
+	}()
+	return q
+}
+
+func (q *deliveryQueue) enqueue(description string, send func(ctx context.Context) error) error {
+	select {
+	case q.sends <- deliveryQueueSend{description: description, send: send}:
+		return nil
+	default:
+		return fmt.Errorf("%s queue is full", q.name)
+	}
+}
+
+// newNotificationService sets up the channels that are configured. Email
+// needs a mailer; the others are always available.
+func (cfg *apiConfig) newNotificationService(mailer mail.Mailer) *notificationService {
+	s := &notificationService{
+		db: cfg.db,
+		channels: map[string]notificationChannel{
+			channelInApp:   inAppChannel{hub: cfg.notifications},
+			channelSlack:   newSlackChannel(),
+			channelWebhook: webhookChannel{cfg: cfg},
+		},
+		appBaseURL: cfg.appBaseURL,
+	}
+	if mailer != nil {
+		s.channels[channelEmail] = newEmailChannel(mailer)
+	}
+	return s
+}
+
+// availableNotificationChannels are the channels this instance can deliver
+// on.
+func (s *notificationService) availableNotificationChannels() []string {
+	channels := []string{}
+	for _, name := range notificationChannels {
+		if s.channels[name] != nil {
+			channels = append(channels, name)
+		}
+	}
+	return channels
+}
+
+// notificationEventTypes returns the event types users can be notified
+// about, sorted.
+func notificationEventTypes() []string {
+	types := make([]string, 0, len(notificationTypes))
+	for eventType := range notificationTypes {
+		types = append(types, eventType)
+	}
+	slices.Sort(types)
+	return types
+}
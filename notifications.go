@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// notificationBufferSize is how many notifications can wait for a slow
+// client before it is disconnected.
+const notificationBufferSize = 32
+
+// notificationHub tracks the notification sockets open on this instance by
+// user. With a shared event bus transport each event is handled by a single
+// instance, so only clients connected to that instance are notified.
+type notificationHub struct {
+	mu      sync.Mutex
+	clients map[uuid.UUID]map[*notificationClient]struct{}
+}
+
+type notificationClient struct {
+	send chan []byte
+	// closed is closed when the hub drops the client for falling behind
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newNotificationHub() *notificationHub {
+	return &notificationHub{clients: map[uuid.UUID]map[*notificationClient]struct{}{}}
+}
+
+func (h *notificationHub) register(userID uuid.UUID) *notificationClient {
+	client := &notificationClient{
+		send:   make(chan []byte, notificationBufferSize),
+		closed: make(chan struct{}),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[userID] == nil {
+		h.clients[userID] = map[*notificationClient]struct{}{}
+	}
+	h.clients[userID][client] = struct{}{}
+	return client
+}
+
+func (h *notificationHub) unregister(userID uuid.UUID, client *notificationClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients[userID], client)
+	if len(h.clients[userID]) == 0 {
+		delete(h.clients, userID)
+	}
+}
+
+// notify queues a message for every client of the user without blocking.
+// Clients whose buffer is full are disconnected rather than slowing
+// everyone else down.
+func (h *notificationHub) notify(userID uuid.UUID, message []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients[userID] {
+		select {
+		case client.send <- message:
+		default:
+			client.closeOnce.Do(func() { close(client.closed) })
+		}
+	}
+}
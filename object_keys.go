@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// renditionOriginal is the rendition name of the untouched upload. Processed
+// output uses its aspect ratio class (landscape, portrait or other).
+const renditionOriginal = "original"
+
+// renditionName names a processed rendition after its aspect ratio.
+func renditionName(width, height, sarNum, sarDen int) string {
+	switch aspectRatioCategory(width, height, sarNum, sarDen) {
+	case "16:9":
+		return "landscape"
+	case "9:16":
+		return "portrait"
+	default:
+		return "other"
+	}
+}
+
+var keyTemplatePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// validateKeyTemplate checks an S3_KEY_TEMPLATE value. Supported placeholders
+// are {env}, {userID}, {videoID}, {rendition} and {random}. {rendition} is
+// required so the original and processed output never share a key.
+func validateKeyTemplate(template string) error {
+	for _, placeholder := range keyTemplatePlaceholder.FindAllString(template, -1) {
+		switch placeholder {
+		case "{env}", "{userID}", "{videoID}", "{rendition}", "{random}":
+		default:
+			return fmt.Errorf("unknown placeholder %s", placeholder)
+		}
+	}
+	if !strings.Contains(template, "{rendition}") {
+		return fmt.Errorf("template must contain {rendition}")
+	}
+	if strings.HasPrefix(template, "/") {
+		return fmt.Errorf("template must not start with /")
+	}
+	return nil
+}
+
+// objectKey returns the S3 key for a rendition of a video. Without a
+// configured template, keys keep the original "<rendition>/<random>.mp4"
+// layout with originals under "originals/". Keys are stored in full on the
+// video record, so changing the template never breaks existing videos.
+func (cfg *apiConfig) objectKey(video database.Video, rendition string) (string, error) {
+	if cfg.s3KeyTemplate == "" {
+		prefix := rendition
+		if rendition == renditionOriginal {
+			prefix = "originals"
+		}
+		return randomObjectKey(prefix)
+	}
+
+	random := ""
+	if strings.Contains(cfg.s3KeyTemplate, "{random}") {
+		randomBytes := make([]byte, 32)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return "", fmt.Errorf("couldn't generate random key: %w", err)
+		}
+		random = base64.RawURLEncoding.EncodeToString(randomBytes)
+	}
+
+	replacer := strings.NewReplacer(
+		"{env}", cfg.platform,
+		"{userID}", video.UserID.String(),
+		"{videoID}", video.ID.String(),
+		"{rendition}", rendition,
+		"{random}", random,
+	)
+	return replacer.Replace(cfg.s3KeyTemplate), nil
+}
+
+// randomObjectKey returns "<prefix>/<random>.mp4".
+func randomObjectKey(prefix string) (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", fmt.Errorf("couldn't generate random key: %w", err)
+	}
+	randomString := base64.RawURLEncoding.EncodeToString(randomBytes)
+	return fmt.Sprintf("%s/%s.mp4", prefix, randomString), nil
+}
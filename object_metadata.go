@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// objectHeaders are the HTTP headers S3 stores with an object and sends back
+// when it's downloaded. Empty fields aren't set.
+type objectHeaders struct {
+	ContentType        string
+	CacheControl       string
+	ContentDisposition string
+	ContentLanguage    string
+}
+
+// Content-Disposition modes for uploaded videos (S3_CONTENT_DISPOSITION)
+const (
+	contentDispositionInline     = "inline"
+	contentDispositionAttachment = "attachment"
+	contentDispositionNone       = "none"
+)
+
+// videoObjectHeaders returns the headers to store an uploaded video with.
+// The download filename comes from the video's title.
+func (cfg *apiConfig) videoObjectHeaders(video database.Video, contentType string) objectHeaders {
+	headers := objectHeaders{
+		ContentType:     contentType,
+		CacheControl:    cfg.s3CacheControl,
+		ContentLanguage: cfg.s3ContentLanguage,
+	}
+	if cfg.s3ContentDisposition != contentDispositionNone {
+		headers.ContentDisposition = contentDisposition(cfg.s3ContentDisposition, videoFilename(video.Title)+".mp4")
+	}
+	return headers
+}
+
+// contentDisposition formats a Content-Disposition header. Non-ASCII names
+// get an ASCII filename for old clients and the real one as an RFC 5987
+// filename*.
+func contentDisposition(disposition, filename string) string {
+	ascii := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII {
+			return '_'
+		}
+		return r
+	}, filename)
+	header := mime.FormatMediaType(disposition, map[string]string{"filename": ascii})
+	if ascii == filename {
+		return header
+	}
+
+	var encoded strings.Builder
+	for _, b := range []byte(filename) {
+		if b < utf8.RuneSelf && (unicode.IsLetter(rune(b)) || unicode.IsDigit(rune(b)) || strings.IndexByte("!#$&+-.^_`|~", b) >= 0) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return header + "; filename*=UTF-8''" + encoded.String()
+}
+
+// videoFilename turns a title into a safe file name, without an extension.
+func videoFilename(title string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || r == '"':
+			return '_'
+		case unicode.IsControl(r):
+			return -1
+		default:
+			return r
+		}
+	}, title)
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	name = truncateRunes(name, 200)
+	if name == "" {
+		return "video"
+	}
+	return name
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// userCanEditVideo reports whether the user may change the video: they
+// created it, or it belongs to an organization where they are now an owner
+// or editor. An organization's video is decided by the role alone, so a
+// creator who leaves the organization or becomes a viewer loses it.
+func (cfg *apiConfig) userCanEditVideo(userID uuid.UUID, video database.Video) (bool, error) {
+	if video.OrganizationID == nil {
+		return video.UserID == userID, nil
+	}
+	role, err := cfg.db.GetOrganizationRole(*video.OrganizationID, userID)
+	if err != nil {
+		return false, fmt.Errorf("couldn't get organization role: %w", err)
+	}
+	return role.CanEdit(), nil
+}
+
+// userCanViewVideo reports whether the user may see the video's private
+// details: they created it, or it belongs to an organization they're now a
+// member of.
+func (cfg *apiConfig) userCanViewVideo(userID uuid.UUID, video database.Video) (bool, error) {
+	if video.OrganizationID == nil {
+		return video.UserID == userID, nil
+	}
+	role, err := cfg.db.GetOrganizationRole(*video.OrganizationID, userID)
+	if err != nil {
+		return false, fmt.Errorf("couldn't get organization role: %w", err)
+	}
+	return role != "", nil
+}
+
+func validOrganizationRole(role database.OrganizationRole) bool {
+	switch role {
+	case database.OrganizationRoleOwner, database.OrganizationRoleEditor, database.OrganizationRoleViewer:
+		return true
+	default:
+		return false
+	}
+}
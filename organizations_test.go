@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestOrganizationVideoAfterMemberRemoved(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	ownerID, ownerToken := createTestUser(t, cfg, "owner@example.com")
+	editorID, editorToken := createTestUser(t, cfg, "editor@example.com")
+	org, err := cfg.db.CreateOrganization("Studio", ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.db.SetOrganizationMember(org.ID, editorID, database.OrganizationRoleEditor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Launch", UserID: editorID, OrganizationID: &org.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := func(method, token, body string) *http.Request {
+		r := httptest.NewRequest(method, "/api/videos/"+video.ID.String(), strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.SetPathValue("videoID", video.ID.String())
+		return r
+	}
+
+	canEdit, err := cfg.userCanEditVideo(editorID, video)
+	if err != nil || !canEdit {
+		t.Fatalf("editor can edit = %v, %v, want true", canEdit, err)
+	}
+	w := httptest.NewRecorder()
+	cfg.handlerVideoMetaUpdate(w, request(http.MethodPatch, ownerToken, `{"title": "Launch day"}`))
+	if w.Code != http.StatusOK {
+		t.Errorf("owner's update: status = %d: %s", w.Code, w.Body)
+	}
+
+	// The creator loses the video along with their membership
+	err = cfg.db.DeleteOrganizationMember(org.ID, editorID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canEdit, err = cfg.userCanEditVideo(editorID, video)
+	if err != nil || canEdit {
+		t.Errorf("removed creator can edit = %v, %v, want false", canEdit, err)
+	}
+	w = httptest.NewRecorder()
+	cfg.handlerVideoMetaUpdate(w, request(http.MethodPatch, editorToken, `{"title": "Mine now"}`))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("removed creator's update: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w = httptest.NewRecorder()
+	cfg.handlerVideoMetaDelete(w, request(http.MethodDelete, editorToken, ""))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("removed creator's delete: status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w = httptest.NewRecorder()
+	cfg.handlerVideoMetaDelete(w, request(http.MethodDelete, ownerToken, ""))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("owner's delete: status = %d: %s", w.Code, w.Body)
+	}
+}
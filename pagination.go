@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Page sizes for listing endpoints
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// pageCursor is a decoded next_cursor. It remembers the order it was issued
+// for, since a key from one sort means nothing in another.
+type pageCursor struct {
+	sort      string
+	ascending bool
+	database.Cursor
+}
+
+// encodeCursor turns a row's position into the opaque next_cursor handed to
+// clients. Clients must pass it back unchanged.
+func encodeCursor(sort string, ascending bool, key any, id uuid.UUID) string {
+	order := "desc"
+	if ascending {
+		order = "asc"
+	}
+	var keyString string
+	switch k := key.(type) {
+	case time.Time:
+		keyString = "t" + strconv.FormatInt(k.Unix(), 10)
+	case float64:
+		keyString = "n" + strconv.FormatFloat(k, 'g', -1, 64)
+	}
+	raw := strings.Join([]string{sort, order, keyString, id.String()}, ":")
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return pageCursor{}, err
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 4 || len(parts[2]) < 2 || (parts[1] != "asc" && parts[1] != "desc") {
+		return pageCursor{}, errors.New("malformed cursor")
+	}
+	id, err := uuid.Parse(parts[3])
+	if err != nil {
+		return pageCursor{}, err
+	}
+
+	var key any
+	switch keyString := parts[2]; keyString[0] {
+	case 't':
+		unix, err := strconv.ParseInt(keyString[1:], 10, 64)
+		if err != nil {
+			return pageCursor{}, err
+		}
+		key = time.Unix(unix, 0)
+	case 'n':
+		n, err := strconv.ParseFloat(keyString[1:], 64)
+		if err != nil {
+			return pageCursor{}, err
+		}
+		key = n
+	default:
+		return pageCursor{}, errors.New("malformed cursor key")
+	}
+
+	return pageCursor{
+		sort:      parts[0],
+		ascending: parts[1] == "asc",
+		Cursor:    database.Cursor{Key: key, ID: id},
+	}, nil
+}
+
+// pageParams reads the cursor and limit query parameters of a listing.
+// Routes declare queryCursor and queryPageLimit, so both are valid here.
+func pageParams(r *http.Request) (*pageCursor, int) {
+	limit := defaultPageSize
+	if limitString := r.URL.Query().Get("limit"); limitString != "" {
+		limit, _ = strconv.Atoi(limitString)
+	}
+	var after *pageCursor
+	if cursorString := r.URL.Query().Get("cursor"); cursorString != "" {
+		cursor, err := decodeCursor(cursorString)
+		if err == nil {
+			after = &cursor
+		}
+	}
+	return after, limit
+}
+
+// checkCursorOrder rejects a cursor issued for a different order than the
+// request's.
+func checkCursorOrder(w http.ResponseWriter, cursor *pageCursor, sort string, ascending bool) bool {
+	if cursor == nil || (cursor.sort == sort && cursor.ascending == ascending) {
+		return true
+	}
+	respondWithFieldErrors(w, http.StatusBadRequest, "Invalid request parameters", []fieldError{
+		{Field: "cursor", In: paramInQuery, Message: "was issued for a different sort order"},
+	})
+	return false
+}
+
+// queryCursor allows an optional cursor returned by an earlier page.
+func queryCursor() paramRule {
+	return paramRule{in: paramInQuery, name: "cursor", check: func(value string) string {
+		if _, err := decodeCursor(value); err != nil {
+			return "must be a next_cursor from an earlier page"
+		}
+		return ""
+	}}
+}
+
+// queryPageLimit allows an optional page size up to maxPageSize.
+func queryPageLimit() paramRule {
+	return paramRule{in: paramInQuery, name: "limit", check: checkIntRange(1, maxPageSize)}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// presignLogFlushInterval is how often the videos presigned on this instance
+// are written to the database, so listing many videos doesn't write each of
+// them on the request.
+const presignLogFlushInterval = 30 * time.Second
+
+// presignLog collects when videos were last presigned until they're flushed.
+type presignLog struct {
+	mu      sync.Mutex
+	pending map[uuid.UUID]time.Time
+}
+
+func newPresignLog() *presignLog {
+	return &presignLog{pending: map[uuid.UUID]time.Time{}}
+}
+
+func (l *presignLog) record(videoID uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending[videoID] = time.Now().UTC()
+}
+
+// take returns the pending times and starts collecting afresh.
+func (l *presignLog) take() map[uuid.UUID]time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pending := l.pending
+	l.pending = map[uuid.UUID]time.Time{}
+	return pending
+}
+
+// startPresignLogFlusher writes the presign log to db every
+// presignLogFlushInterval.
+func startPresignLogFlusher(l *presignLog, db database.Client) {
+	go func() {
+		ticker := time.NewTicker(presignLogFlushInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			pending := l.take()
+			if len(pending) == 0 {
+				continue
+			}
+			err := db.RecordVideosPresigned(pending)
+			if err != nil {
+				logging.Warnf("Couldn't record presigned URLs of %d videos: %v", len(pending), err)
+			}
+		}
+	}()
+}
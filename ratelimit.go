@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// Rate limit and quota headers. Reset headers are Unix timestamps in
+// seconds; Retry-After on a 429 is a number of seconds.
+const (
+	rateLimitLimitHeader       = "X-RateLimit-Limit"
+	rateLimitRemainingHeader   = "X-RateLimit-Remaining"
+	rateLimitResetHeader       = "X-RateLimit-Reset"
+	uploadQuotaLimitHeader     = "X-Upload-Quota-Limit"
+	uploadQuotaRemainingHeader = "X-Upload-Quota-Remaining"
+	uploadQuotaResetHeader     = "X-Upload-Quota-Reset"
+)
+
+// uploadQuotaWindow is the trailing period uploads are counted over.
+const uploadQuotaWindow = 24 * time.Hour
+
+// rateLimiter counts requests per client in fixed windows.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, windows: map[string]*rateWindow{}}
+}
+
+// allow counts a request from key, reporting whether it is within the
+// limit, how many requests are left and when the window resets.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, int, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	window := l.windows[key]
+	if window == nil || now.Sub(window.start) >= l.window {
+		// Forget finished windows now and then so the map doesn't grow
+		// with every client ever seen
+		if len(l.windows) > 10000 {
+			for k, w := range l.windows {
+				if now.Sub(w.start) >= l.window {
+					delete(l.windows, k)
+				}
+			}
+		}
+		window = &rateWindow{start: now}
+		l.windows[key] = window
+	}
+	reset := window.start.Add(l.window)
+	if window.count >= l.limit {
+		return false, 0, reset
+	}
+	window.count++
+	return true, l.limit - window.count, reset
+}
+
+// rateLimitKey identifies the client: the user for requests with a valid
+// JWT, otherwise the remote address.
+func (cfg *apiConfig) rateLimitKey(r *http.Request) string {
+	if token, err := auth.GetBearerToken(r.Header); err == nil {
+		if userID, err := auth.ValidateJWT(token, cfg.jwtSecret); err == nil {
+			return "user:" + userID.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimitMiddleware limits API requests per client and reports the limit
+// in X-RateLimit-* headers. It does nothing unless a limit is configured.
+func (cfg *apiConfig) rateLimitMiddleware(next http.Handler) http.Handler {
+	if cfg.rateLimiter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		ok, remaining, reset := cfg.rateLimiter.allow(cfg.rateLimitKey(r), now)
+		w.Header().Set(rateLimitLimitHeader, strconv.Itoa(cfg.rateLimiter.limit))
+		w.Header().Set(rateLimitRemainingHeader, strconv.Itoa(remaining))
+		w.Header().Set(rateLimitResetHeader, strconv.FormatInt(reset.Unix(), 10))
+		if !ok {
+			w.Header().Set("Retry-After", retryAfter(reset, now))
+			respondWithError(w, http.StatusTooManyRequests, "Rate limit exceeded", nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkUploadQuota reports the user's upload quota in X-Upload-Quota-*
+// headers and responds with a 429 if it is used up. It returns false when
+// the upload must not go ahead.
+func (cfg *apiConfig) checkUploadQuota(w http.ResponseWriter, userID uuid.UUID) bool {
+	if cfg.uploadQuotaPerDay <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	used, oldest, err := cfg.db.CountUploadsSince(userID, now.Add(-uploadQuotaWindow))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check upload quota", err)
+		return false
+	}
+	// The quota frees up as the oldest counted upload leaves the window
+	reset := now.Add(uploadQuotaWindow)
+	if oldest != nil {
+		reset = oldest.Add(uploadQuotaWindow)
+	}
+	remaining := max(cfg.uploadQuotaPerDay-used, 0)
+
+	w.Header().Set(uploadQuotaLimitHeader, strconv.Itoa(cfg.uploadQuotaPerDay))
+	w.Header().Set(uploadQuotaRemainingHeader, strconv.Itoa(remaining))
+	w.Header().Set(uploadQuotaResetHeader, strconv.FormatInt(reset.Unix(), 10))
+	if remaining > 0 {
+		return true
+	}
+
+	cfg.publishEvent(events.TypeQuotaExceeded, userID, map[string]any{
+		"quota": "uploads_per_day",
+		"limit": cfg.uploadQuotaPerDay,
+	})
+	w.Header().Set("Retry-After", retryAfter(reset, now))
+	respondWithErrorCode(w, http.StatusTooManyRequests, errCodeQuotaExceeded, fmt.Sprintf("Daily upload quota of %d exceeded", cfg.uploadQuotaPerDay), nil)
+	return false
+}
+
+// recordUpload counts an upload towards the user's quota, updating the
+// remaining-quota header for the response.
+func (cfg *apiConfig) recordUpload(w http.ResponseWriter, userID, videoID uuid.UUID) {
+	err := cfg.db.RecordUpload(userID, videoID)
+	if err != nil {
+		logging.Warnf("Couldn't record upload of video %s: %v", videoID, err)
+		return
+	}
+	if remaining, err := strconv.Atoi(w.Header().Get(uploadQuotaRemainingHeader)); err == nil {
+		w.Header().Set(uploadQuotaRemainingHeader, strconv.Itoa(max(remaining-1, 0)))
+	}
+}
+
+// retryAfter is the Retry-After value for waiting until reset, at least one
+// second.
+func retryAfter(reset, now time.Time) string {
+	seconds := int64(reset.Sub(now).Seconds() + 0.999)
+	return strconv.FormatInt(max(seconds, 1), 10)
+}
+
+// pruneUploadLog forgets uploads that no longer count towards the quota.
+func (cfg *apiConfig) pruneUploadLog() error {
+	return cfg.db.DeleteUploadsBefore(time.Now().Add(-uploadQuotaWindow))
+}
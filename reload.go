@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/joho/godotenv"
+)
+
+// configWatchInterval is how often the config file is checked for changes.
+const configWatchInterval = 5 * time.Second
+
+// runtimeSettings hold the reloadable settings that aren't kept elsewhere.
+type runtimeSettings struct {
+	presignExpiry atomic.Int64
+}
+
+// reloadableSetting is a setting that's applied again, without a restart,
+// on SIGHUP or when the config file changes. apply gets "" when the setting
+// isn't set, and must leave the current value alone when it returns an
+// error.
+type reloadableSetting struct {
+	name  string
+	apply func(cfg *apiConfig, value string) error
+}
+
+var reloadableSettings = []reloadableSetting{
+	{name: "LOG_LEVEL", apply: func(cfg *apiConfig, value string) error {
+		level := logging.LevelInfo
+		if value != "" {
+			var err error
+			level, err = logging.ParseLevel(value)
+			if err != nil {
+				return err
+			}
+		}
+		logging.SetLevel(level)
+		return nil
+	}},
+	{name: "LOG_SAMPLE_EVERY", apply: func(cfg *apiConfig, value string) error {
+		n := 1
+		if value != "" {
+			var err error
+			n, err = strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return errors.New("must be a positive integer")
+			}
+		}
+		logging.SetSampling(n)
+		return nil
+	}},
+	{name: "WORKER_CONCURRENCY", apply: func(cfg *apiConfig, value string) error {
+		n := 2
+		if value != "" {
+			var err error
+			n, err = strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return errors.New("must be a positive integer")
+			}
+		}
+		cfg.workers.resize(n)
+		return nil
+	}},
+	// S3 won't honour a presigned URL for more than a week
+	{name: "PRESIGN_EXPIRY", apply: func(cfg *apiConfig, value string) error {
+		expiry := time.Hour
+		if value != "" {
+			var err error
+			expiry, err = time.ParseDuration(value)
+			if err != nil || expiry < time.Minute || expiry > 7*24*time.Hour {
+				return errors.New("must be a duration between 1m and 168h")
+			}
+		}
+		cfg.settings.presignExpiry.Store(int64(expiry))
+		return nil
+	}},
+}
+
+// processEnv returns the reloadable settings set in the process
+// environment. As at startup, these take precedence over the config file,
+// so they can only be changed by a restart.
+func processEnv() map[string]string {
+	env := map[string]string{}
+	for _, setting := range reloadableSettings {
+		if value, ok := os.LookupEnv(setting.name); ok {
+			env[setting.name] = value
+		}
+	}
+	return env
+}
+
+// applySettings applies every reloadable setting from values, returning the
+// errors of those that were invalid.
+func (cfg *apiConfig) applySettings(values map[string]string) error {
+	var errs []error
+	for _, setting := range reloadableSettings {
+		if err := setting.apply(cfg, values[setting.name]); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", setting.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reloadSettings re-reads the config file and applies the reloadable
+// settings in it. Invalid ones keep their current value.
+func (cfg *apiConfig) reloadSettings(configFile string, env map[string]string) {
+	values, err := godotenv.Read(configFile)
+	if err != nil && !os.IsNotExist(err) {
+		logging.Warnf("Couldn't read config file %s: %v", configFile, err)
+		return
+	}
+	if values == nil {
+		values = map[string]string{}
+	}
+	for name, value := range env {
+		values[name] = value
+	}
+	if err := cfg.applySettings(values); err != nil {
+		logging.Warnf("Some settings kept their current value on reload: %v", err)
+	}
+	logging.Infof("Reloaded settings from %s", configFile)
+}
+
+// watchSettings reloads the settings on SIGHUP, and whenever the config
+// file's modification time changes.
+func (cfg *apiConfig) watchSettings(configFile string, env map[string]string) {
+	modTime := func() time.Time {
+		info, err := os.Stat(configFile)
+		if err != nil {
+			return time.Time{}
+		}
+		return info.ModTime()
+	}
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go func() {
+		ticker := time.NewTicker(configWatchInterval)
+		defer ticker.Stop()
+		lastModTime := modTime()
+		for {
+			select {
+			case <-hangups:
+			case <-ticker.C:
+				current := modTime()
+				if current.Equal(lastModTime) {
+					continue
+				}
+				lastModTime = current
+			}
+			cfg.reloadSettings(configFile, env)
+		}
+	}()
+}
+
+func (cfg *apiConfig) presignExpiry() time.Duration {
+	return time.Duration(cfg.settings.presignExpiry.Load())
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// clientRegionHeader lets clients (or a CDN in front of the API) say which
+// AWS region they're closest to, e.g. "eu-west-1".
+const clientRegionHeader = "X-Client-Region"
+
+// s3Replica is a copy of the primary bucket kept in sync by S3 replication.
+type s3Replica struct {
+	region string
+	bucket string
+	client objectPresigner
+}
+
+// parseS3Replicas parses S3_REPLICAS, a comma-separated list of
+// "region=bucket" pairs, building a client for each region.
+func parseS3Replicas(value string, primary *s3.Client, provider s3Provider) ([]s3Replica, error) {
+	var replicas []s3Replica
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		region, bucket, ok := strings.Cut(entry, "=")
+		region, bucket = strings.TrimSpace(region), strings.TrimSpace(bucket)
+		if !ok || region == "" || bucket == "" {
+			return nil, fmt.Errorf("invalid replica %q, expected region=bucket", entry)
+		}
+		client := newS3Storage(s3.New(primary.Options(), func(o *s3.Options) {
+			o.Region = region
+			if provider.endpoint != nil {
+				o.BaseEndpoint = aws.String(provider.endpoint(region))
+			}
+		}), provider)
+		replicas = append(replicas, s3Replica{region: region, bucket: bucket, client: client})
+	}
+	return replicas, nil
+}
+
+func clientRegionHint(r *http.Request) string {
+	return strings.ToLower(strings.TrimSpace(r.Header.Get(clientRegionHeader)))
+}
+
+// presignTarget picks the presigner and bucket to presign an object with. Only
+// objects in the primary bucket are replicated; for those, a replica in the
+// hinted region wins, then one in the same part of the world ("eu", "ap",
+// ...), falling back to the primary.
+func (cfg *apiConfig) presignTarget(bucket, regionHint string) (objectPresigner, string) {
+	if bucket != cfg.s3Bucket || regionHint == "" || len(cfg.s3Replicas) == 0 {
+		return cfg.storage, bucket
+	}
+
+	for _, replica := range cfg.s3Replicas {
+		if replica.region == regionHint {
+			return replica.client, replica.bucket
+		}
+	}
+	if regionHint == cfg.s3Region {
+		return cfg.storage, bucket
+	}
+
+	area, _, _ := strings.Cut(regionHint, "-")
+	if primaryArea, _, _ := strings.Cut(cfg.s3Region, "-"); primaryArea == area {
+		return cfg.storage, bucket
+	}
+	for _, replica := range cfg.s3Replicas {
+		if replicaArea, _, _ := strings.Cut(replica.region, "-"); replicaArea == area {
+			return replica.client, replica.bucket
+		}
+	}
+	return cfg.storage, bucket
+}
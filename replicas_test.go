@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// regionPresigner presigns URLs that name its region.
+type regionPresigner string
+
+func (p regionPresigner) presignGet(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
+	return "https://" + string(p) + ".storage.test/" + bucket + "/" + key, nil
+}
+
+func (p regionPresigner) presignPost(ctx context.Context, bucket, key string, expires time.Duration, conditions []any) (*s3.PresignedPostRequest, error) {
+	return &s3.PresignedPostRequest{URL: "https://" + string(p) + ".storage.test/" + bucket}, nil
+}
+
+func TestPresignTarget(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	cfg.s3Replicas = []s3Replica{
+		{region: "eu-west-1", bucket: "tubely-eu", client: regionPresigner("eu-west-1")},
+		{region: "ap-southeast-2", bucket: "tubely-ap", client: regionPresigner("ap-southeast-2")},
+	}
+
+	tests := []struct {
+		name       string
+		bucket     string
+		regionHint string
+		want       string
+	}{
+		{"no hint", testBucket, "", "https://storage.test/" + testBucket + "/key?expires=3600"},
+		{"replica region", testBucket, "eu-west-1", "https://eu-west-1.storage.test/tubely-eu/key"},
+		{"same area as a replica", testBucket, "ap-northeast-1", "https://ap-southeast-2.storage.test/tubely-ap/key"},
+		{"primary region", testBucket, "us-east-2", "https://storage.test/" + testBucket + "/key?expires=3600"},
+		{"same area as the primary", testBucket, "us-west-2", "https://storage.test/" + testBucket + "/key?expires=3600"},
+		{"no replica nearby", testBucket, "sa-east-1", "https://storage.test/" + testBucket + "/key?expires=3600"},
+		{"bucket that isn't replicated", "tubely-thumbnails", "eu-west-1", "https://storage.test/tubely-thumbnails/key?expires=3600"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			presigner, bucket := cfg.presignTarget(tt.bucket, tt.regionHint)
+			got, err := cfg.presignGetURL(presigner, bucket, "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("presigned %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -14,6 +14,13 @@ func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't reset database", err)
 		return
 	}
+	if cfg.searchIndexer != nil {
+		err = cfg.searchIndexer.index.Clear(r.Context())
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't reset search index", err)
+			return
+		}
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("Database reset to initial state"))
 }
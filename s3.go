@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+)
+
+// parseBucketKey splits a stored "bucket,key" location into its parts.
+func parseBucketKey(location string) (bucket, key string, err error) {
+	parts := strings.Split(location, ",")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid video URL format, expected 'bucket,key' but got: %s", location)
+	}
+	return parts[0], parts[1], nil
+}
+
+// downloadObjectToTemp copies an S3 object into a new temporary file and
+// returns its path. The caller is responsible for removing the file.
+func (cfg *apiConfig) downloadObjectToTemp(bucket, key, pattern string) (string, error) {
+	output, err := cfg.storage.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get object %s: %w", key, err)
+	}
+	defer output.Body.Close()
+
+	tempFile, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	defer tempFile.Close()
+
+	_, err = io.Copy(tempFile, output.Body)
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", fmt.Errorf("failed to download object %s: %w", key, err)
+	}
+	return tempFile.Name(), nil
+}
+
+func (cfg *apiConfig) deleteObject(bucket, key string) error {
+	_, err := cfg.storage.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete object %s: %w", key, err)
+	}
+	return nil
+}
+
+// minUploadPartSize is the smallest part S3 accepts for every part of a
+// multipart upload except the last.
+const minUploadPartSize = 5 << 20
+
+// uploadJobFile uploads a local file to S3 as a multipart upload and returns
+// the key it was stored under, with headers stored as its metadata, and the
+// checksum S3 computed for it, if any. The upload ID is recorded on the job while the
+// upload is in flight; if the job already has an upload of the same file,
+// parts S3 already holds are skipped and the original key is kept.
+func (cfg *apiConfig) uploadJobFile(job *database.Job, path, bucket, key string, headers objectHeaders) (string, *string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", nil, err
+	}
+	size := info.Size()
+
+	uploaded := map[int32]types.CompletedPart{}
+	if job.UploadID != "" && job.UploadPath == path && job.UploadBucket == bucket {
+		uploaded, err = cfg.listUploadedParts(bucket, job.UploadKey, job.UploadID, size)
+		if err != nil {
+			logging.Warnf("Couldn't resume upload %s for job %s, starting over: %v", job.UploadID, job.ID, err)
+			cfg.abortJobUpload(job)
+			uploaded = map[int32]types.CompletedPart{}
+		} else {
+			key = job.UploadKey
+			logging.Infof("Resuming upload of %s for job %s with %d parts already uploaded", key, job.ID, len(uploaded))
+		}
+	} else if job.UploadID != "" {
+		cfg.abortJobUpload(job)
+	}
+
+	if job.UploadID == "" {
+		output, err := cfg.storage.CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{
+			Bucket:             &bucket,
+			Key:                &key,
+			ContentType:        optionalString(headers.ContentType),
+			CacheControl:       optionalString(headers.CacheControl),
+			ContentDisposition: optionalString(headers.ContentDisposition),
+			ContentLanguage:    optionalString(headers.ContentLanguage),
+			ChecksumAlgorithm:  cfg.checksumAlgorithm,
+			ChecksumType:       multipartChecksumType(cfg.checksumAlgorithm),
+		})
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to start upload of %s: %w", key, err)
+		}
+		job.UploadID = *output.UploadId
+		job.UploadBucket = bucket
+		job.UploadKey = key
+		job.UploadPath = path
+		err = cfg.db.UpdateJob(*job)
+		if err != nil {
+			return "", nil, fmt.Errorf("couldn't record upload: %w", err)
+		}
+	}
+
+	parts, err := cfg.uploadParts(file, size, bucket, key, job.UploadID, uploaded)
+	if err != nil {
+		return "", nil, err
+	}
+
+	output, err := cfg.storage.CompleteMultipartUpload(context.TODO(), &s3.CompleteMultipartUploadInput{
+		Bucket:          &bucket,
+		Key:             &key,
+		UploadId:        &job.UploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		ChecksumType:    multipartChecksumType(cfg.checksumAlgorithm),
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to complete upload of %s: %w", key, err)
+	}
+
+	job.UploadID, job.UploadBucket, job.UploadKey, job.UploadPath = "", "", "", ""
+	err = cfg.db.UpdateJob(*job)
+	if err != nil {
+		return "", nil, fmt.Errorf("couldn't clear finished upload: %w", err)
+	}
+	checksum := s3Checksums{
+		CRC32:     output.ChecksumCRC32,
+		CRC32C:    output.ChecksumCRC32C,
+		CRC64NVME: output.ChecksumCRC64NVME,
+		SHA1:      output.ChecksumSHA1,
+		SHA256:    output.ChecksumSHA256,
+	}.format()
+	return key, checksum, nil
+}
+
+// uploadParts uploads every part of the file that isn't already in uploaded,
+// up to cfg.uploadConcurrency at a time, and returns all parts in order. The
+// first failure stops parts that haven't started yet.
+func (cfg *apiConfig) uploadParts(file io.ReaderAt, size int64, bucket, key, uploadID string, uploaded map[int32]types.CompletedPart) ([]types.CompletedPart, error) {
+	partCount := int32((size + cfg.uploadPartSize - 1) / cfg.uploadPartSize)
+	if partCount == 0 {
+		// An empty file is still uploaded as one empty part
+		partCount = 1
+	}
+	parts := make([]types.CompletedPart, partCount)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var uploadErr error
+	sem := make(chan struct{}, cfg.uploadConcurrency)
+	for partNumber := int32(1); partNumber <= partCount; partNumber++ {
+		if part, ok := uploaded[partNumber]; ok {
+			parts[partNumber-1] = part
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(partNumber int32) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			offset := int64(partNumber-1) * cfg.uploadPartSize
+			length := min(cfg.uploadPartSize, size-offset)
+			start := time.Now()
+			output, err := cfg.storage.UploadPart(ctx, &s3.UploadPartInput{
+				Bucket:            &bucket,
+				Key:               &key,
+				UploadId:          &uploadID,
+				PartNumber:        aws.Int32(partNumber),
+				Body:              io.NewSectionReader(file, offset, length),
+				ChecksumAlgorithm: cfg.checksumAlgorithm,
+			})
+			if err != nil {
+				uploadPartFailures.Inc()
+				errOnce.Do(func() {
+					uploadErr = fmt.Errorf("failed to upload part %d of %s: %w", partNumber, key, err)
+					cancel()
+				})
+				return
+			}
+			uploadPartDuration.Observe(time.Since(start).Seconds())
+			uploadPartBytes.Add(float64(length))
+			checksum := s3Checksums{CRC32C: output.ChecksumCRC32C, SHA256: output.ChecksumSHA256}.value(cfg.checksumAlgorithm)
+			parts[partNumber-1] = completedPart(partNumber, output.ETag, cfg.checksumAlgorithm, checksum)
+		}(partNumber)
+	}
+	wg.Wait()
+
+	if uploadErr != nil {
+		return nil, uploadErr
+	}
+	return parts, nil
+}
+
+// listUploadedParts returns the parts of an in-flight upload that S3 already
+// has, keyed by part number. Parts whose size doesn't match what this file
+// would produce with the configured part size are left out so they get
+// uploaded again. An upload started with a different checksum algorithm
+// can't be resumed.
+func (cfg *apiConfig) listUploadedParts(bucket, key, uploadID string, size int64) (map[int32]types.CompletedPart, error) {
+	partSize := cfg.uploadPartSize
+	uploaded := map[int32]types.CompletedPart{}
+	paginator := s3.NewListPartsPaginator(cfg.storage, &s3.ListPartsInput{
+		Bucket:   &bucket,
+		Key:      &key,
+		UploadId: &uploadID,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list parts of %s: %w", key, err)
+		}
+		if page.ChecksumAlgorithm != cfg.checksumAlgorithm {
+			return nil, fmt.Errorf("upload of %s uses checksum algorithm %q, not %q", key, page.ChecksumAlgorithm, cfg.checksumAlgorithm)
+		}
+		for _, part := range page.Parts {
+			if part.PartNumber == nil || part.Size == nil {
+				continue
+			}
+			offset := int64(*part.PartNumber-1) * partSize
+			if offset >= size && !(offset == 0 && size == 0) {
+				continue
+			}
+			if *part.Size != min(partSize, size-offset) {
+				continue
+			}
+			checksum := s3Checksums{CRC32C: part.ChecksumCRC32C, SHA256: part.ChecksumSHA256}.value(cfg.checksumAlgorithm)
+			uploaded[*part.PartNumber] = completedPart(*part.PartNumber, part.ETag, cfg.checksumAlgorithm, checksum)
+		}
+	}
+	return uploaded, nil
+}
+
+// abortJobUpload aborts the job's in-flight multipart upload, if any, so S3
+// discards its parts, and clears it from the job.
+func (cfg *apiConfig) abortJobUpload(job *database.Job) {
+	if job.UploadID == "" {
+		return
+	}
+	// Uploads recorded before buckets were routed all went to the default bucket
+	bucket := job.UploadBucket
+	if bucket == "" {
+		bucket = cfg.s3Bucket
+	}
+	_, err := cfg.storage.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+		Bucket:   &bucket,
+		Key:      &job.UploadKey,
+		UploadId: &job.UploadID,
+	})
+	if err != nil {
+		logging.Warnf("Couldn't abort upload %s for job %s: %v", job.UploadID, job.ID, err)
+	}
+
+	job.UploadID, job.UploadBucket, job.UploadKey, job.UploadPath = "", "", "", ""
+	err = cfg.db.UpdateJob(*job)
+	if err != nil {
+		logging.Warnf("Couldn't clear aborted upload for job %s: %v", job.ID, err)
+	}
+}
+
+// optionalString returns nil for an empty string, for request fields the SDK
+// would otherwise send empty.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// copyObject copies a stored "bucket,key" object to bucket and key within
+// S3, replacing its headers, and returns the checksum S3 computed for the
+// copy, if any. CopyObject is limited to objects of up to 5 GB.
+func (cfg *apiConfig) copyObject(ctx context.Context, location, bucket, key string, headers objectHeaders) (*string, error) {
+	srcBucket, srcKey, err := parseBucketKey(location)
+	if err != nil {
+		return nil, err
+	}
+	copySource := (&url.URL{Path: srcBucket + "/" + srcKey}).EscapedPath()
+	output, err := cfg.storage.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:             &bucket,
+		Key:                &key,
+		CopySource:         &copySource,
+		MetadataDirective:  types.MetadataDirectiveReplace,
+		ContentType:        optionalString(headers.ContentType),
+		CacheControl:       optionalString(headers.CacheControl),
+		ContentDisposition: optionalString(headers.ContentDisposition),
+		ContentLanguage:    optionalString(headers.ContentLanguage),
+		ChecksumAlgorithm:  cfg.checksumAlgorithm,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy %s to %s: %w", srcKey, key, err)
+	}
+	return copyResultChecksum(output), nil
+}
+
+// changeStorageClass moves a stored "bucket,key" object to another storage
+// class by copying it onto itself, keeping its headers, and returns the
+// checksum S3 computed for the copy, if any. Like copyObject it's limited
+// to objects of up to 5 GB.
+func (cfg *apiConfig) changeStorageClass(ctx context.Context, location, storageClass string) (*string, error) {
+	bucket, key, err := parseBucketKey(location)
+	if err != nil {
+		return nil, err
+	}
+	copySource := (&url.URL{Path: bucket + "/" + key}).EscapedPath()
+	output, err := cfg.storage.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            &bucket,
+		Key:               &key,
+		CopySource:        &copySource,
+		MetadataDirective: types.MetadataDirectiveCopy,
+		StorageClass:      types.StorageClass(storageClass),
+		ChecksumAlgorithm: cfg.checksumAlgorithm,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to move %s to %s: %w", key, storageClass, err)
+	}
+	return copyResultChecksum(output), nil
+}
+
+func copyResultChecksum(output *s3.CopyObjectOutput) *string {
+	if output.CopyObjectResult == nil {
+		return nil
+	}
+	result := output.CopyObjectResult
+	return s3Checksums{
+		CRC32:     result.ChecksumCRC32,
+		CRC32C:    result.ChecksumCRC32C,
+		CRC64NVME: result.ChecksumCRC64NVME,
+		SHA1:      result.ChecksumSHA1,
+		SHA256:    result.ChecksumSHA256,
+	}.format()
+}
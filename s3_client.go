@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// s3ClientOptions are the operator-tunable knobs for talking to S3.
+type s3ClientOptions struct {
+	Region string
+	// CABundlePath is a PEM file of extra root CAs to trust, e.g. for a
+	// TLS-inspecting proxy.
+	CABundlePath string
+	// ProxyURL overrides the proxy from the environment when set.
+	ProxyURL string
+	// RequesterPays marks every request as accepting requester-pays charges.
+	RequesterPays bool
+	// UploadBandwidth caps request body bytes per second; 0 is unlimited.
+	UploadBandwidth int64
+	// OnlyRequiredChecksums stops the SDK computing and validating checksums
+	// on requests that don't require them.
+	OnlyRequiredChecksums bool
+	// Provider adapts the client to an S3-compatible provider.
+	Provider s3Provider
+	// PathStyle puts the bucket in the path instead of the host name.
+	PathStyle bool
+}
+
+func newS3Client(opts s3ClientOptions) (*s3.Client, error) {
+	var tlsConfig *tls.Config
+	if opts.CABundlePath != "" {
+		pem, err := os.ReadFile(opts.CABundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read CA bundle: %w", err)
+		}
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", opts.CABundlePath)
+		}
+		tlsConfig = &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+	}
+
+	var proxyURL *url.URL
+	if opts.ProxyURL != "" {
+		var err error
+		proxyURL, err = url.Parse(opts.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+	}
+
+	httpClient := awshttp.NewBuildableClient().WithTransportOptions(func(tr *http.Transport) {
+		if tlsConfig != nil {
+			tr.TLSClientConfig = tlsConfig
+		}
+		if proxyURL != nil {
+			tr.Proxy = http.ProxyURL(proxyURL)
+		}
+	})
+
+	sdkConfig, err := config.LoadDefaultConfig(
+		context.TODO(),
+		config.WithRegion(opts.Region),
+		config.WithHTTPClient(httpClient),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return s3.NewFromConfig(sdkConfig, func(o *s3.Options) {
+		// AWS_ENDPOINT_URL_S3 still wins over the provider's endpoint
+		if opts.Provider.endpoint != nil && o.BaseEndpoint == nil {
+			o.BaseEndpoint = aws.String(opts.Provider.endpoint(opts.Region))
+		}
+		o.UsePathStyle = opts.PathStyle
+		if opts.OnlyRequiredChecksums || !opts.Provider.checksums {
+			o.RequestChecksumCalculation = aws.RequestChecksumCalculationWhenRequired
+			o.ResponseChecksumValidation = aws.ResponseChecksumValidationWhenRequired
+		}
+		if opts.RequesterPays {
+			o.APIOptions = append(o.APIOptions, smithyhttp.AddHeaderValue("x-amz-request-payer", "requester"))
+		}
+		if opts.UploadBandwidth > 0 {
+			o.HTTPClient = throttledHTTPClient{
+				client:  o.HTTPClient,
+				limiter: &bandwidthLimiter{bytesPerSecond: opts.UploadBandwidth},
+			}
+		}
+	}), nil
+}
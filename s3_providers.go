@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// S3-compatible providers, chosen with S3_PROVIDER.
+const (
+	s3ProviderAWS    = "aws"
+	s3ProviderB2     = "b2"
+	s3ProviderSpaces = "spaces"
+)
+
+// s3Provider describes how a provider's S3-compatible API differs from S3's,
+// so the client can be set up to work with it.
+type s3Provider struct {
+	name string
+	// endpoint returns the API endpoint of a region. It's nil for AWS, whose
+	// endpoints the SDK resolves itself.
+	endpoint func(region string) string
+	// checksums is whether the x-amz-checksum-* headers and trailers the SDK
+	// sends by default, and S3_CHECKSUM_ALGORITHM relies on, are accepted
+	checksums bool
+	// requesterPays is whether S3_REQUESTER_PAYS is supported
+	requesterPays bool
+	// storageClasses is whether objects can be moved between storage
+	// classes; without them everything is STANDARD
+	storageClasses bool
+	// presignedPost is whether browser uploads with a POST policy are
+	// supported
+	presignedPost bool
+}
+
+var s3Providers = []s3Provider{
+	{
+		name:           s3ProviderAWS,
+		checksums:      true,
+		requesterPays:  true,
+		storageClasses: true,
+		presignedPost:  true,
+	},
+	// Backblaze B2 regions look like "us-west-004". B2 has no POST policy
+	// uploads, and rejects the checksum trailers of newer SDKs
+	{
+		name: s3ProviderB2,
+		endpoint: func(region string) string {
+			return "https://s3." + region + ".backblazeb2.com"
+		},
+	},
+	// DigitalOcean Spaces regions are datacenters like "nyc3"
+	{
+		name: s3ProviderSpaces,
+		endpoint: func(region string) string {
+			return "https://" + region + ".digitaloceanspaces.com"
+		},
+		presignedPost: true,
+	},
+}
+
+// errPresignedPostUnsupported is returned when presigning a POST policy for
+// a provider without POST policy uploads.
+var errPresignedPostUnsupported = errors.New("the storage provider doesn't support POST policy uploads")
+
+// parseS3Provider parses S3_PROVIDER, AWS when it's empty.
+func parseS3Provider(value string) (s3Provider, error) {
+	name := strings.ToLower(strings.TrimSpace(value))
+	if name == "" {
+		name = s3ProviderAWS
+	}
+	index := slices.IndexFunc(s3Providers, func(p s3Provider) bool { return p.name == name })
+	if index < 0 {
+		names := make([]string, len(s3Providers))
+		for i, p := range s3Providers {
+			names[i] = p.name
+		}
+		return s3Provider{}, fmt.Errorf("unknown provider %q, expected one of %s", value, strings.Join(names, ", "))
+	}
+	return s3Providers[index], nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+func TestParseS3Provider(t *testing.T) {
+	for value, want := range map[string]string{"": s3ProviderAWS, "B2": s3ProviderB2, " spaces ": s3ProviderSpaces} {
+		provider, err := parseS3Provider(value)
+		if err != nil {
+			t.Fatalf("parseS3Provider(%q): %v", value, err)
+		}
+		if provider.name != want {
+			t.Errorf("parseS3Provider(%q) = %s, want %s", value, provider.name, want)
+		}
+	}
+	if _, err := parseS3Provider("wasabi"); err == nil {
+		t.Error("parseS3Provider accepted an unknown provider")
+	}
+}
+
+func TestS3ProviderClient(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_ENDPOINT_URL", "")
+	t.Setenv("AWS_ENDPOINT_URL_S3", "")
+
+	tests := []struct {
+		provider  string
+		region    string
+		pathStyle bool
+		wantHost  string
+		wantPath  string
+	}{
+		{s3ProviderB2, "us-west-004", false, "tubely-test.s3.us-west-004.backblazeb2.com", "/key"},
+		{s3ProviderSpaces, "nyc3", false, "tubely-test.nyc3.digitaloceanspaces.com", "/key"},
+		{s3ProviderSpaces, "nyc3", true, "nyc3.digitaloceanspaces.com", "/tubely-test/key"},
+	}
+	for _, tt := range tests {
+		provider, err := parseS3Provider(tt.provider)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client, err := newS3Client(s3ClientOptions{Region: tt.region, Provider: provider, PathStyle: tt.pathStyle})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := client.Options().RequestChecksumCalculation; got != aws.RequestChecksumCalculationWhenRequired {
+			t.Errorf("%s: checksums are calculated %v, want only when required", tt.provider, got)
+		}
+
+		storage := newS3Storage(client, provider)
+		presigned, err := storage.presignGet(context.Background(), testBucket, "key", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := url.Parse(presigned)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Host != tt.wantHost || u.Path != tt.wantPath {
+			t.Errorf("%s: presigned %s%s, want %s%s", tt.provider, u.Host, u.Path, tt.wantHost, tt.wantPath)
+		}
+
+		_, err = storage.presignPost(context.Background(), testBucket, "key", time.Hour, nil)
+		if provider.presignedPost == errors.Is(err, errPresignedPostUnsupported) {
+			t.Errorf("%s: presignPost returned %v", tt.provider, err)
+		}
+	}
+}
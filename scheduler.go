@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/lock"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// Scheduled jobs only run on the leader, the instance holding the leader
+// lock. The others try to take it over every schedulerElectionInterval, so
+// when the leader dies its jobs move to another instance within about
+// schedulerLeaderTTL.
+const (
+	schedulerLeaderKey        = "scheduler-leader"
+	schedulerLeaderTTL        = 30 * time.Second
+	schedulerElectionInterval = 10 * time.Second
+)
+
+// Run history is kept for scheduledRunRetention, and the admin endpoint
+// shows the last scheduledRunsShown runs of each job.
+const (
+	scheduledRunRetention = 30 * 24 * time.Hour
+	scheduledRunsShown    = 20
+)
+
+// scheduledJob is periodic work run by the scheduler. Runs of a job never
+// overlap on one instance.
+type scheduledJob struct {
+	name     string
+	interval time.Duration
+	run      func() error
+
+	// mu is held while the job runs
+	mu sync.Mutex
+}
+
+type scheduler struct {
+	db       database.Client
+	locks    lock.Locker
+	instance string
+	jobs     []*scheduledJob
+
+	mu     sync.Mutex
+	leader bool
+}
+
+func newScheduler(db database.Client, locks lock.Locker) *scheduler {
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "unknown"
+	}
+	return &scheduler{
+		db:       db,
+		locks:    locks,
+		instance: fmt.Sprintf("%s/%d", instance, os.Getpid()),
+	}
+}
+
+// register adds a job, run every interval unless configured otherwise.
+func (s *scheduler) register(name string, interval time.Duration, run func() error) {
+	s.jobs = append(s.jobs, &scheduledJob{name: name, interval: interval, run: run})
+}
+
+// setIntervals parses SCHEDULE_INTERVALS, a comma-separated list of
+// "job=duration" pairs overriding the default intervals.
+func (s *scheduler) setIntervals(value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, durationString, ok := strings.Cut(entry, "=")
+		if !ok {
+			return fmt.Errorf("invalid interval %q, expected job=duration", entry)
+		}
+		interval, err := time.ParseDuration(strings.TrimSpace(durationString))
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid interval %q, expected a positive duration", entry)
+		}
+		job := s.job(strings.TrimSpace(name))
+		if job == nil {
+			return fmt.Errorf("unknown scheduled job %q", name)
+		}
+		job.interval = interval
+	}
+	return nil
+}
+
+func (s *scheduler) job(name string) *scheduledJob {
+	for _, job := range s.jobs {
+		if job.name == name {
+			return job
+		}
+	}
+	return nil
+}
+
+func (s *scheduler) isLeader() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.leader
+}
+
+// start campaigns for leadership in the background, and runs every job on
+// its interval once elected. A leader that loses the lock stops starting
+// runs and campaigns again; a run already going finishes.
+func (s *scheduler) start() {
+	go func() {
+		for {
+			leaderLock := s.campaign()
+			logging.Infof("Instance %s is the scheduler leader", s.instance)
+			s.setLeader(true)
+			stop := make(chan struct{})
+			for _, job := range s.jobs {
+				go s.runEvery(job, stop)
+			}
+
+			<-leaderLock.Lost()
+			logging.Warnf("Instance %s lost the scheduler leader lock, stopping scheduled jobs", s.instance)
+			s.setLeader(false)
+			close(stop)
+			leaderLock.Release()
+		}
+	}()
+}
+
+// campaign tries to take the leader lock every schedulerElectionInterval
+// until it does.
+func (s *scheduler) campaign() lock.Lock {
+	ticker := time.NewTicker(schedulerElectionInterval)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), schedulerElectionInterval)
+		leaderLock, err := s.locks.TryAcquire(ctx, schedulerLeaderKey, schedulerLeaderTTL)
+		cancel()
+		if err != nil {
+			logging.Warnf("Couldn't campaign for scheduler leader: %v", err)
+		}
+		if leaderLock != nil {
+			return leaderLock
+		}
+		<-ticker.C
+	}
+}
+
+func (s *scheduler) setLeader(leader bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leader = leader
+}
+
+// runEvery runs a job every interval until stop is closed.
+func (s *scheduler) runEvery(job *scheduledJob, stop <-chan struct{}) {
+	ticker := time.NewTicker(job.interval)
+	defer ticker.Stop()
+	for {
+		s.runOnce(job)
+		// A run that finishes after stop doesn't start another
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// runOnce runs a job, recording the run and its outcome, and returns the
+// run. A run asked for while the job is already running waits for it.
+func (s *scheduler) runOnce(job *scheduledJob) database.ScheduledRun {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	run, err := s.db.CreateScheduledRun(job.name, s.instance)
+	if err != nil {
+		logging.Warnf("Couldn't record run of scheduled job %s: %v", job.name, err)
+		run = database.ScheduledRun{Job: job.name, Instance: s.instance, StartedAt: time.Now().UTC()}
+	}
+
+	if err := job.run(); err != nil {
+		logging.Errorf("Scheduled job %s failed: %v", job.name, err)
+		msg := err.Error()
+		run.Error = &msg
+	}
+	finishedAt := time.Now().UTC()
+	run.FinishedAt = &finishedAt
+
+	if run.ID != uuid.Nil {
+		err = s.db.FinishScheduledRun(run.ID, run.Error)
+		if err != nil {
+			logging.Warnf("Couldn't record end of scheduled job %s: %v", job.name, err)
+		}
+	}
+	return run
+}
+
+// registerScheduledJobs adds the periodic maintenance jobs to the scheduler.
+func (cfg *apiConfig) registerScheduledJobs(s *scheduler, thumbnailCandidateRetention time.Duration) {
+	s.register("thumbnail_candidate_gc", time.Hour, func() error {
+		return cfg.collectThumbnailCandidates(thumbnailCandidateRetention)
+	})
+	s.register("orphaned_asset_gc", time.Hour, cfg.collectOrphanedAssets)
+	s.register("upload_log_prune", time.Hour, cfg.pruneUploadLog)
+	s.register("upload_session_prune", time.Hour, cfg.pruneUploadSessions)
+	s.register("storage_audit", 24*time.Hour, cfg.auditStorage)
+	s.register("asset_sizing", time.Hour, cfg.sizeAssets)
+	s.register("usage_recount", 24*time.Hour, cfg.recountUsage)
+	s.register("stale_multipart_abort", 24*time.Hour, cfg.abortStaleMultipartUploads)
+	s.register("playback_analytics_rollup", time.Hour, cfg.rollUpPlaybackAnalytics)
+	s.register("playback_event_prune", 24*time.Hour, cfg.prunePlaybackEvents)
+	s.register("storage_metering", time.Hour, cfg.meterStorage)
+	s.register("billing_usage_export", 24*time.Hour, cfg.exportBillingUsage)
+	if cfg.analyticsExportDaily {
+		s.register("analytics_export", 24*time.Hour, cfg.exportAnalyticsDaily)
+	}
+	if cfg.backupDaily {
+		s.register("backup", 24*time.Hour, cfg.backUpOnSchedule)
+	}
+	if cfg.importSource != nil && cfg.importUserEmail != "" {
+		s.register("source_import", importInterval, cfg.importWatched)
+	}
+	s.register("scheduled_run_prune", 24*time.Hour, func() error {
+		return cfg.db.DeleteScheduledRunsBefore(time.Now().Add(-scheduledRunRetention))
+	})
+}
+
+func (cfg *apiConfig) handlerAdminScheduledJobs(w http.ResponseWriter, r *http.Request) {
+	type job struct {
+		Name            string                  `json:"name"`
+		IntervalSeconds float64                 `json:"interval_seconds"`
+		Runs            []database.ScheduledRun `json:"runs"`
+	}
+	type response struct {
+		Instance string `json:"instance"`
+		Leader   bool   `json:"leader"`
+		Jobs     []job  `json:"jobs"`
+	}
+
+	jobs := make([]job, 0, len(cfg.scheduler.jobs))
+	for _, scheduled := range cfg.scheduler.jobs {
+		runs, err := cfg.db.GetScheduledRuns(scheduled.name, scheduledRunsShown)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get scheduled runs", err)
+			return
+		}
+		jobs = append(jobs, job{
+			Name:            scheduled.name,
+			IntervalSeconds: scheduled.interval.Seconds(),
+			Runs:            runs,
+		})
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+
+	respondWithJSON(w, http.StatusOK, response{
+		Instance: cfg.scheduler.instance,
+		Leader:   cfg.scheduler.isLeader(),
+		Jobs:     jobs,
+	})
+}
+
+// handlerAdminScheduledJobRun runs a scheduled job now, on this instance
+// whether or not it's the leader, and responds with the run once it's
+// finished. A failed run is still a 200; its error is in the run.
+func (cfg *apiConfig) handlerAdminScheduledJobRun(w http.ResponseWriter, r *http.Request) {
+	job := cfg.scheduler.job(r.PathValue("name"))
+	if job == nil {
+		respondWithError(w, http.StatusNotFound, "Scheduled job not found", nil)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, cfg.scheduler.runOnce(job))
+}
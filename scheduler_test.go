@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/lock"
+)
+
+// losableLocker hands out locks the test can take away, as when a Redis
+// lock expires.
+type losableLocker struct {
+	mu    sync.Mutex
+	locks []*losableLock
+}
+
+func (l *losableLocker) Acquire(ctx context.Context, key string, ttl time.Duration) (lock.Lock, error) {
+	return l.TryAcquire(ctx, key, ttl)
+}
+
+func (l *losableLocker) TryAcquire(ctx context.Context, key string, ttl time.Duration) (lock.Lock, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	held := &losableLock{lost: make(chan struct{})}
+	l.locks = append(l.locks, held)
+	return held, nil
+}
+
+func (l *losableLocker) acquired() []*losableLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*losableLock{}, l.locks...)
+}
+
+type losableLock struct {
+	lost chan struct{}
+}
+
+func (l *losableLock) Release()              {}
+func (l *losableLock) Lost() <-chan struct{} { return l.lost }
+
+func TestSchedulerLosesLeadership(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	locker := &losableLocker{}
+	s := newScheduler(cfg.db, locker)
+	var runs atomic.Int32
+	s.register("count", time.Hour, func() error {
+		runs.Add(1)
+		return nil
+	})
+	waitFor := func(what string, done func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !done(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+
+	s.start()
+	waitFor("the first run", func() bool { return s.isLeader() && runs.Load() == 1 })
+
+	// Losing the lock means campaigning again, and leading anew once it's
+	// retaken
+	close(locker.acquired()[0].lost)
+	waitFor("the lock to be retaken", func() bool { return len(locker.acquired()) == 2 && s.isLeader() })
+	waitFor("the run as the new leader", func() bool { return runs.Load() == 2 })
+}
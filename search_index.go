@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/search"
+	"github.com/google/uuid"
+)
+
+// Search index upkeep limits.
+const (
+	searchIndexQueueSize = 1000
+	searchIndexTimeout   = 10 * time.Second
+	searchRebuildPage    = 500
+)
+
+// searchIndexOptions configures the search index picked by provider.
+type searchIndexOptions struct {
+	Provider            string
+	ElasticsearchURL    string
+	ElasticsearchIndex  string
+	ElasticsearchAPIKey string
+}
+
+// newSearchIndex builds a search index by name: "none", "memory" or
+// "elasticsearch". It returns nil when search is disabled.
+func newSearchIndex(opts searchIndexOptions) (search.Index, error) {
+	switch opts.Provider {
+	case "none":
+		return nil, nil
+	case "", "memory":
+		return search.NewMemory(), nil
+	case "elasticsearch":
+		if opts.ElasticsearchURL == "" {
+			return nil, errors.New("ELASTICSEARCH_URL must be set")
+		}
+		client := &http.Client{Timeout: searchIndexTimeout}
+		index := search.NewElasticsearch(client, opts.ElasticsearchURL, opts.ElasticsearchIndex, opts.ElasticsearchAPIKey)
+		ctx, cancel := context.WithTimeout(context.Background(), searchIndexTimeout)
+		defer cancel()
+		err := index.EnsureIndex(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return index, nil
+	default:
+		return nil, fmt.Errorf("unknown search index %q", opts.Provider)
+	}
+}
+
+// searchIndexer keeps the search index in step with the videos table. The
+// database calls videoChanged after each write to a video, and the indexer
+// reads the video back and indexes it if it's published or removes it if
+// not, so it never has to know what changed.
+type searchIndexer struct {
+	index   search.Index
+	changes chan uuid.UUID
+	// mu keeps a rebuild page and a single change from indexing stale
+	// copies over each other
+	mu         sync.Mutex
+	rebuilding atomic.Bool
+}
+
+func newSearchIndexer(index search.Index) *searchIndexer {
+	return &searchIndexer{index: index, changes: make(chan uuid.UUID, searchIndexQueueSize)}
+}
+
+// videoChanged queues a video to be indexed again. Changes that don't fit
+// in the queue are dropped; a rebuild catches the index up.
+func (s *searchIndexer) videoChanged(id uuid.UUID) {
+	select {
+	case s.changes <- id:
+	default:
+		logging.Warnf("Search index queue is full, dropped change to video %s", id)
+	}
+}
+
+func (s *searchIndexer) start(db database.Client) {
+	go func() {
+		for id := range s.changes {
+			err := s.sync(db, id)
+			if err != nil {
+				logging.Warnf("Couldn't update search index for video %s: %v", id, err)
+			}
+		}
+	}()
+}
+
+func (s *searchIndexer) sync(db database.Client, id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), searchIndexTimeout)
+	defer cancel()
+
+	video, err := db.GetVideo(id)
+	if err != nil {
+		return err
+	}
+	if !video.IsPublished() {
+		return s.index.Delete(ctx, id)
+	}
+	return s.index.Put(ctx, searchDocument(video))
+}
+
+// rebuild empties the index and indexes every published video again. It
+// returns how many it indexed. Searches made meanwhile miss the videos it
+// hasn't reached yet.
+func (s *searchIndexer) rebuild(db database.Client) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), searchIndexTimeout)
+	err := s.index.Clear(ctx)
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+
+	indexed := 0
+	after := uuid.Nil
+	for {
+		n, last, err := s.rebuildPage(db, after)
+		indexed += n
+		if err != nil || n < searchRebuildPage {
+			return indexed, err
+		}
+		after = last
+	}
+}
+
+// rebuildPage indexes the page of published videos after the given one,
+// returning how many there were and the last one's ID.
+func (s *searchIndexer) rebuildPage(db database.Client, after uuid.UUID) (int, uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	videos, err := db.GetPublishedVideos(after, searchRebuildPage)
+	if err != nil {
+		return 0, after, err
+	}
+	for i, video := range videos {
+		ctx, cancel := context.WithTimeout(context.Background(), searchIndexTimeout)
+		err := s.index.Put(ctx, searchDocument(video))
+		cancel()
+		if err != nil {
+			return i, video.ID, err
+		}
+	}
+	if len(videos) == 0 {
+		return 0, after, nil
+	}
+	return len(videos), videos[len(videos)-1].ID, nil
+}
+
+// rebuildInBackground starts a rebuild unless one is already running,
+// reporting whether it started one.
+func (s *searchIndexer) rebuildInBackground(db database.Client) bool {
+	if !s.rebuilding.CompareAndSwap(false, true) {
+		return false
+	}
+	go func() {
+		defer s.rebuilding.Store(false)
+		start := time.Now()
+		indexed, err := s.rebuild(db)
+		if err != nil {
+			logging.Errorf("Search index rebuild failed after %d videos: %v", indexed, err)
+			return
+		}
+		logging.Infof("Indexed %d videos for search in %s", indexed, time.Since(start).Round(time.Millisecond))
+	}()
+	return true
+}
+
+func searchDocument(video database.Video) search.Document {
+	return search.Document{
+		ID:          video.ID,
+		Title:       video.Title,
+		Description: video.Description,
+		Tags:        video.Tags,
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// HTTP2_MODE values. HTTP/1.1 is always served alongside HTTP/2.
+const (
+	http2ModeOff = "off"
+	// http2ModeTLS negotiates HTTP/2 over TLS with ALPN.
+	http2ModeTLS = "tls"
+	// http2ModeH2C accepts HTTP/2 over cleartext from clients that know to
+	// use it, such as a load balancer or gRPC gateway in front of the API.
+	http2ModeH2C = "h2c"
+)
+
+// serverProtocols returns the protocols to serve for HTTP2_MODE. It defaults
+// to HTTP/2 over TLS when the server has a certificate and HTTP/1.1 only
+// when it doesn't.
+func serverProtocols(mode string, hasTLS bool) (*http.Protocols, error) {
+	if mode == "" {
+		mode = http2ModeOff
+		if hasTLS {
+			mode = http2ModeTLS
+		}
+	}
+
+	protocols := &http.Protocols{}
+	protocols.SetHTTP1(true)
+	switch mode {
+	case http2ModeOff:
+	case http2ModeTLS:
+		if !hasTLS {
+			return nil, fmt.Errorf("%q needs TLS_CERT_FILE and TLS_KEY_FILE", mode)
+		}
+		protocols.SetHTTP2(true)
+	case http2ModeH2C:
+		if hasTLS {
+			return nil, fmt.Errorf("%q is for cleartext connections; use %q with TLS", mode, http2ModeTLS)
+		}
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		return nil, fmt.Errorf("unknown mode %q, expected %s, %s or %s", mode, http2ModeOff, http2ModeTLS, http2ModeH2C)
+	}
+	return protocols, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Slack delivery limits.
+const (
+	slackQueueSize      = 100
+	slackRequestTimeout = 10 * time.Second
+)
+
+// slackWebhookHost is where Slack's incoming webhooks live. Users can only
+// point the Slack channel there, so it can't be used to make requests
+// anywhere else.
+const slackWebhookHost = "hooks.slack.com"
+
+// slackChannel posts to the incoming webhook the user connected. Users who
+// haven't connected Slack get nothing.
+type slackChannel struct {
+	client *http.Client
+	queue  *deliveryQueue
+}
+
+func newSlackChannel() slackChannel {
+	return slackChannel{
+		client: &http.Client{Timeout: slackRequestTimeout},
+		queue:  startDeliveryQueue("Slack message", slackQueueSize, slackRequestTimeout),
+	}
+}
+
+// validSlackWebhookURL reports whether rawURL is a Slack incoming webhook.
+func validSlackWebhookURL(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Scheme == "https" && u.Host == slackWebhookHost && u.User == nil
+}
+
+func (c slackChannel) deliver(ctx context.Context, n notification) error {
+	webhookURL := n.settings.Channels[channelSlack].Target
+	if webhookURL == "" {
+		return nil
+	}
+	payload, err := json.Marshal(map[string]string{
+		"text": fmt.Sprintf("*%s*\n%s", n.subject, n.body),
+	})
+	if err != nil {
+		return err
+	}
+	return c.queue.enqueue(fmt.Sprintf("for %s event %s", n.event.Type, n.event.ID), func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("slack responded %s", resp.Status)
+		}
+		return nil
+	})
+}
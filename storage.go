@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// Storage backends, chosen with STORAGE_BACKEND.
+const (
+	storageBackendS3    = "s3"
+	storageBackendLocal = "local"
+	storageBackendGCS   = "gcs"
+	storageBackendAzure = "azure"
+)
+
+// objectStorage is the part of the S3 API videos and images are stored
+// through, plus presigning. s3Storage implements it on S3 and S3-compatible
+// stores, gcsStorage on Google Cloud Storage, azureStorage on Azure Blob
+// Storage, and localStorage on the
+// filesystem for running without a cloud provider. Code that only needs
+// some of it takes one of the narrower interfaces below.
+type objectStorage interface {
+	objectStore
+	multipartUploader
+	objectPresigner
+}
+
+// objectStore reads, writes and removes whole objects.
+type objectStore interface {
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+}
+
+// multipartUploader uploads objects in parts, and finds and cleans up the
+// uploads left unfinished.
+type multipartUploader interface {
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+	s3.ListPartsAPIClient
+	s3.ListMultipartUploadsAPIClient
+}
+
+// objectPresigner hands out URLs that let clients transfer an object
+// without going through the API.
+type objectPresigner interface {
+	// presignGet returns a URL anyone can download the object from until
+	// it expires.
+	presignGet(ctx context.Context, bucket, key string, expires time.Duration) (string, error)
+	// presignPost returns a URL and form fields a browser can upload the
+	// object with, within the policy conditions, until it expires.
+	presignPost(ctx context.Context, bucket, key string, expires time.Duration, conditions []any) (*s3.PresignedPostRequest, error)
+}
+
+// parseStorageBackend parses STORAGE_BACKEND.
+func parseStorageBackend(value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "", storageBackendS3:
+		return storageBackendS3, nil
+	case storageBackendLocal:
+		return storageBackendLocal, nil
+	case storageBackendGCS:
+		return storageBackendGCS, nil
+	case storageBackendAzure:
+		return storageBackendAzure, nil
+	default:
+		return "", fmt.Errorf("unsupported storage backend %q, expected s3, local, gcs or azure", value)
+	}
+}
+
+// storageResponseError wraps err in the response error the SDK would return
+// for status, so callers can check for missing objects the same way on
+// every backend.
+func storageResponseError(status int, err error) error {
+	return &awshttp.ResponseError{ResponseError: &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+		Err:      err,
+	}}
+}
+
+func noSuchKey(key string) error {
+	return storageResponseError(http.StatusNotFound, &types.NoSuchKey{Message: aws.String("no such key " + key)})
+}
+
+func noSuchUpload(uploadID string) error {
+	return storageResponseError(http.StatusNotFound, &types.NoSuchUpload{Message: aws.String("no such upload " + uploadID)})
+}
+
+// requestBodyLength returns how many bytes a request body has left, the
+// given content length if there is one, or -1 if it can't tell.
+func requestBodyLength(body io.Reader, contentLength *int64) int64 {
+	if contentLength != nil {
+		return *contentLength
+	}
+	switch body := body.(type) {
+	case nil:
+		return 0
+	case interface{ Len() int }:
+		return int64(body.Len())
+	case io.Seeker:
+		current, err := body.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		end, err := body.Seek(0, io.SeekEnd)
+		if err != nil {
+			return -1
+		}
+		_, err = body.Seek(current, io.SeekStart)
+		if err != nil {
+			return -1
+		}
+		return end - current
+	}
+	return -1
+}
+
+// objectProperties is what a GET or HEAD response from a backend that isn't
+// S3 says about an object, for building the responses S3 would return.
+type objectProperties struct {
+	contentLength        int64
+	contentRange         *string
+	contentType          *string
+	cacheControl         *string
+	contentDisposition   *string
+	contentLanguage      *string
+	etag                 *string
+	lastModified         *time.Time
+	storageClass         types.StorageClass
+	serverSideEncryption types.ServerSideEncryption
+	kmsKeyID             *string
+}
+
+// parseObjectProperties reads the standard HTTP headers of a GET or HEAD
+// response, leaving the backend to fill in the rest.
+func parseObjectProperties(resp *http.Response) objectProperties {
+	object := objectProperties{
+		contentLength:      resp.ContentLength,
+		contentRange:       optionalString(resp.Header.Get("Content-Range")),
+		contentType:        optionalString(resp.Header.Get("Content-Type")),
+		cacheControl:       optionalString(resp.Header.Get("Cache-Control")),
+		contentDisposition: optionalString(resp.Header.Get("Content-Disposition")),
+		contentLanguage:    optionalString(resp.Header.Get("Content-Language")),
+		etag:               optionalString(resp.Header.Get("ETag")),
+	}
+	if lastModified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		object.lastModified = &lastModified
+	}
+	return object
+}
+
+func (p objectProperties) getObjectOutput(body io.ReadCloser) *s3.GetObjectOutput {
+	return &s3.GetObjectOutput{
+		Body:                 body,
+		ContentLength:        aws.Int64(p.contentLength),
+		ContentRange:         p.contentRange,
+		ContentType:          p.contentType,
+		CacheControl:         p.cacheControl,
+		ContentDisposition:   p.contentDisposition,
+		ContentLanguage:      p.contentLanguage,
+		ETag:                 p.etag,
+		LastModified:         p.lastModified,
+		StorageClass:         p.storageClass,
+		ServerSideEncryption: p.serverSideEncryption,
+		SSEKMSKeyId:          p.kmsKeyID,
+	}
+}
+
+func (p objectProperties) headObjectOutput() *s3.HeadObjectOutput {
+	return &s3.HeadObjectOutput{
+		ContentLength:        aws.Int64(p.contentLength),
+		ContentType:          p.contentType,
+		CacheControl:         p.cacheControl,
+		ContentDisposition:   p.contentDisposition,
+		ContentLanguage:      p.contentLanguage,
+		ETag:                 p.etag,
+		LastModified:         p.lastModified,
+		StorageClass:         p.storageClass,
+		ServerSideEncryption: p.serverSideEncryption,
+		SSEKMSKeyId:          p.kmsKeyID,
+	}
+}
+
+// s3Storage is objectStorage on S3, or on the S3-compatible API of provider.
+type s3Storage struct {
+	*s3.Client
+	presigner *s3.PresignClient
+	provider  s3Provider
+}
+
+func newS3Storage(client *s3.Client, provider s3Provider) s3Storage {
+	return s3Storage{Client: client, presigner: s3.NewPresignClient(client), provider: provider}
+}
+
+func (s s3Storage) presignGet(ctx context.Context, bucket, key string, expires time.Duration) (string, error) {
+	presigned, err := s.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+	return presigned.URL, nil
+}
+
+func (s s3Storage) presignPost(ctx context.Context, bucket, key string, expires time.Duration, conditions []any) (*s3.PresignedPostRequest, error) {
+	if !s.provider.presignedPost {
+		return nil, errPresignedPostUnsupported
+	}
+	return s.presigner.PresignPostObject(ctx, &s3.PutObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	}, func(opts *s3.PresignPostOptions) {
+		opts.Expires = expires
+		opts.Conditions = conditions
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// storageAuditBatchSize is how many videos the storage audit reads at a time.
+const storageAuditBatchSize = 100
+
+// storedObject is an S3 location recorded on a video, with the size and
+// checksum recorded for it if there are any.
+type storedObject struct {
+	field            string
+	location         string
+	recordedSize     *int64
+	recordedChecksum *string
+}
+
+func videoStoredObjects(video database.Video) []storedObject {
+	var objects []storedObject
+	if video.VideoURL != nil && isBucketKey(*video.VideoURL) {
+		objects = append(objects, storedObject{
+			field:            "video_url",
+			location:         *video.VideoURL,
+			recordedSize:     video.VideoSize,
+			recordedChecksum: video.VideoChecksum,
+		})
+	}
+	if video.OriginalURL != nil && isBucketKey(*video.OriginalURL) {
+		objects = append(objects, storedObject{field: "original_url", location: *video.OriginalURL, recordedChecksum: video.OriginalChecksum})
+	}
+	if video.ThumbnailURL != nil && isBucketKey(*video.ThumbnailURL) {
+		objects = append(objects, storedObject{field: "thumbnail_url", location: *video.ThumbnailURL, recordedSize: video.ThumbnailSize})
+	}
+	return objects
+}
+
+// auditStorage checks that every S3 object recorded on a video exists, has
+// the recorded size and checksum and is encrypted, replacing the previous
+// audit's findings with what it finds.
+func (cfg *apiConfig) auditStorage() error {
+	checkedAt := time.Now().UTC()
+	findings := []database.StorageAuditFinding{}
+	after := uuid.Nil
+	for {
+		videos, err := cfg.db.GetVideosAfter(after, storageAuditBatchSize)
+		if err != nil {
+			return fmt.Errorf("couldn't list videos: %w", err)
+		}
+		for _, video := range videos {
+			for _, object := range videoStoredObjects(video) {
+				finding, ok := cfg.auditStoredObject(object)
+				if !ok {
+					continue
+				}
+				finding.CheckedAt = checkedAt
+				finding.VideoID = video.ID
+				findings = append(findings, finding)
+				logging.Warnf("Storage audit: %s of video %s (%s) is %s", object.field, video.ID, object.location, finding.Problem)
+			}
+		}
+		if len(videos) < storageAuditBatchSize {
+			break
+		}
+		after = videos[len(videos)-1].ID
+	}
+
+	err := cfg.db.ReplaceStorageAuditFindings(findings)
+	if err != nil {
+		return fmt.Errorf("couldn't save storage audit findings: %w", err)
+	}
+	return nil
+}
+
+// auditStoredObject checks one object, returning a finding if it doesn't
+// match the record.
+func (cfg *apiConfig) auditStoredObject(object storedObject) (database.StorageAuditFinding, bool) {
+	finding := database.StorageAuditFinding{
+		Field:        object.field,
+		Location:     object.location,
+		RecordedSize: object.recordedSize,
+	}
+	bucket, key, err := parseBucketKey(object.location)
+	if err != nil {
+		detail := err.Error()
+		finding.Problem, finding.Detail = database.StorageProblemCheckFailed, &detail
+		return finding, true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	input := &s3.HeadObjectInput{
+		Bucket: &bucket,
+		Key:    &key,
+	}
+	if object.recordedChecksum != nil {
+		input.ChecksumMode = types.ChecksumModeEnabled
+	}
+	head, err := cfg.storage.HeadObject(ctx, input)
+	var responseErr *awshttp.ResponseError
+	if errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusNotFound {
+		finding.Problem = database.StorageProblemMissing
+		return finding, true
+	}
+	if err != nil {
+		detail := err.Error()
+		finding.Problem, finding.Detail = database.StorageProblemCheckFailed, &detail
+		return finding, true
+	}
+
+	// Objects without a recorded size or checksum only need to exist
+	if object.recordedSize != nil {
+		actualSize := aws.ToInt64(head.ContentLength)
+		if actualSize != *object.recordedSize {
+			finding.Problem, finding.ActualSize = database.StorageProblemSizeMismatch, &actualSize
+			return finding, true
+		}
+	}
+	if object.recordedChecksum != nil {
+		actualChecksum := headChecksums(head).format()
+		if actualChecksum == nil || *actualChecksum != *object.recordedChecksum {
+			stored := "none"
+			if actualChecksum != nil {
+				stored = *actualChecksum
+			}
+			detail := fmt.Sprintf("recorded checksum %s, stored checksum %s", *object.recordedChecksum, stored)
+			finding.Problem, finding.Detail = database.StorageProblemChecksumMismatch, &detail
+			return finding, true
+		}
+	}
+	// Local storage has no encryption at rest to check
+	if head.ServerSideEncryption == "" && cfg.localStorage == nil {
+		finding.Problem = database.StorageProblemUnencrypted
+		return finding, true
+	}
+	return finding, false
+}
+
+func (cfg *apiConfig) handlerAdminStorageAudit(w http.ResponseWriter, r *http.Request) {
+	findings, err := cfg.db.GetStorageAuditFindings()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get storage audit findings", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, findings)
+}
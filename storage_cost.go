@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// defaultStoragePricePerGB is what a GB-month costs in an object class
+// without a price of its own: S3 Standard in us-east-1, in USD.
+const defaultStoragePricePerGB = 0.023
+
+// defaultStorageClassPrices are what a GB-month costs in each S3 storage
+// class in us-east-1, in USD, for objects a storage migration has moved.
+var defaultStorageClassPrices = map[string]float64{
+	string(types.StorageClassStandard):           0.023,
+	string(types.StorageClassStandardIa):         0.0125,
+	string(types.StorageClassOnezoneIa):          0.01,
+	string(types.StorageClassIntelligentTiering): 0.023,
+	string(types.StorageClassGlacierIr):          0.004,
+	string(types.StorageClassGlacier):            0.0036,
+	string(types.StorageClassDeepArchive):        0.00099,
+}
+
+var objectClasses = []objectClass{objectClassOriginal, objectClassRendition, objectClassThumbnail}
+
+// assetObjectClass returns the class an asset's objects are stored in.
+// Captions are only ever stored locally.
+func assetObjectClass(kind database.AssetKind) (objectClass, bool) {
+	switch kind {
+	case database.AssetKindOriginal:
+		return objectClassOriginal, true
+	case database.AssetKindRendition:
+		return objectClassRendition, true
+	case database.AssetKindThumbnail, database.AssetKindThumbnailCandidate, database.AssetKindThumbnailVariant:
+		return objectClassThumbnail, true
+	}
+	return "", false
+}
+
+// parseStoragePrices parses prices per object class or S3 storage class
+// like "originals=0.0125,GLACIER_IR=0.004". Object classes price the
+// objects kept in their bucket's default storage class, and those left out
+// cost defaultStoragePricePerGB. Storage classes left out cost their
+// default price.
+func parseStoragePrices(value string) (map[string]float64, error) {
+	prices := maps.Clone(defaultStorageClassPrices)
+	for _, class := range objectClasses {
+		prices[string(class)] = defaultStoragePricePerGB
+	}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, priceString, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid price %q, expected class=price", entry)
+		}
+		class := strings.TrimSpace(name)
+		if _, ok := prices[class]; !ok {
+			return nil, fmt.Errorf("unknown object or storage class %q", name)
+		}
+		price, err := strconv.ParseFloat(strings.TrimSpace(priceString), 64)
+		if err != nil || price < 0 {
+			return nil, fmt.Errorf("invalid price %q, expected a non-negative number", entry)
+		}
+		prices[class] = price
+	}
+	return prices, nil
+}
+
+// sizeAssets records the size of every asset that doesn't have one yet.
+// Objects that don't exist yet, like direct uploads that haven't been
+// finalized, are tried again on the next run.
+func (cfg *apiConfig) sizeAssets() error {
+	after := uuid.Nil
+	for {
+		assets, err := cfg.db.GetUnsizedAssets(after, storageAuditBatchSize)
+		if err != nil {
+			return fmt.Errorf("couldn't list unsized assets: %w", err)
+		}
+		for _, asset := range assets {
+			size, err := cfg.storedObjectSize(asset.Storage, asset.Location)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				logging.Warnf("Couldn't size %s %s: %v", asset.Kind, asset.Location, err)
+				continue
+			}
+			err = cfg.db.SetAssetSize(asset.Storage, asset.Location, size)
+			if err != nil {
+				return fmt.Errorf("couldn't record size of %s: %w", asset.Location, err)
+			}
+		}
+		if len(assets) < storageAuditBatchSize {
+			return nil
+		}
+		after = assets[len(assets)-1].ID
+	}
+}
+
+// storedObjectSize returns the byte size of a stored object, or an error
+// wrapping os.ErrNotExist if there's no such object.
+func (cfg *apiConfig) storedObjectSize(storage database.AssetStorage, location string) (int64, error) {
+	switch storage {
+	case database.AssetStorageS3:
+		bucket, key, err := parseBucketKey(location)
+		if err != nil {
+			return 0, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		head, err := cfg.storage.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: &bucket,
+			Key:    &key,
+		})
+		var responseErr *awshttp.ResponseError
+		if errors.As(err, &responseErr) && responseErr.HTTPStatusCode() == http.StatusNotFound {
+			return 0, fmt.Errorf("%s: %w", location, os.ErrNotExist)
+		}
+		if err != nil {
+			return 0, err
+		}
+		return aws.ToInt64(head.ContentLength), nil
+	case database.AssetStorageLocal:
+		if location != filepath.Base(location) {
+			return 0, fmt.Errorf("invalid asset filename %q", location)
+		}
+		info, err := os.Stat(filepath.Join(cfg.assetsRoot, location))
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	default:
+		return 0, fmt.Errorf("unknown asset storage %q", storage)
+	}
+}
+
+// storageCost is what the objects of one class cost to keep for a month.
+type storageCost struct {
+	Class   objectClass `json:"class"`
+	Objects int64       `json:"objects"`
+	Bytes   int64       `json:"bytes"`
+	// UnsizedObjects aren't in Bytes or the cost until the asset sizing
+	// job has looked them up
+	UnsizedObjects       int64   `json:"unsized_objects"`
+	EstimatedMonthlyCost float64 `json:"estimated_monthly_cost"`
+}
+
+type userStorageCost struct {
+	UserID               uuid.UUID     `json:"user_id"`
+	Bytes                int64         `json:"bytes"`
+	EstimatedMonthlyCost float64       `json:"estimated_monthly_cost"`
+	Classes              []storageCost `json:"classes"`
+}
+
+type storageCostReport struct {
+	GeneratedAt      time.Time          `json:"generated_at"`
+	PricesPerGBMonth map[string]float64 `json:"prices_per_gb_month"`
+	Bytes            int64              `json:"bytes"`
+	// EstimatedMonthlyCost is in the currency of the prices
+	EstimatedMonthlyCost float64       `json:"estimated_monthly_cost"`
+	Classes              []storageCost `json:"classes"`
+	// Users are ordered by cost, most expensive first
+	Users []userStorageCost `json:"users"`
+}
+
+// add counts an asset usage row towards the class's totals.
+func (c *storageCost) add(usage database.AssetUsage, pricePerGB float64) {
+	c.Objects += usage.Objects
+	c.Bytes += usage.Bytes
+	c.UnsizedObjects += usage.Unsized
+	c.EstimatedMonthlyCost += float64(usage.Bytes) / bytesPerGB * pricePerGB
+}
+
+// handlerAdminStorageCostReport estimates what the S3 objects recorded as
+// assets cost per month, per object class and per user, to weigh lifecycle
+// policies against. Objects moved to another storage class are priced by
+// it. Objects stored in the local assets root aren't counted.
+func (cfg *apiConfig) handlerAdminStorageCostReport(w http.ResponseWriter, r *http.Request) {
+	usage, err := cfg.db.Replica().GetAssetUsage()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get asset usage", err)
+		return
+	}
+
+	report := storageCostReport{
+		GeneratedAt:      time.Now().UTC(),
+		PricesPerGBMonth: cfg.storagePricesPerGB,
+		Classes:          make([]storageCost, len(objectClasses)),
+		Users:            []userStorageCost{},
+	}
+	for i, class := range objectClasses {
+		report.Classes[i].Class = class
+	}
+	for _, u := range usage {
+		class, ok := assetObjectClass(u.Kind)
+		if !ok || u.Storage != database.AssetStorageS3 {
+			continue
+		}
+		price := cfg.storagePricesPerGB[string(class)]
+		if u.StorageClass != "" {
+			price = cfg.storagePricesPerGB[u.StorageClass]
+		}
+		report.Classes[slices.Index(objectClasses, class)].add(u, price)
+
+		if len(report.Users) == 0 || report.Users[len(report.Users)-1].UserID != u.UserID {
+			report.Users = append(report.Users, userStorageCost{UserID: u.UserID, Classes: []storageCost{}})
+		}
+		user := &report.Users[len(report.Users)-1]
+		i := slices.IndexFunc(user.Classes, func(c storageCost) bool { return c.Class == class })
+		if i < 0 {
+			user.Classes = append(user.Classes, storageCost{Class: class})
+			i = len(user.Classes) - 1
+		}
+		user.Classes[i].add(u, price)
+	}
+
+	for _, class := range report.Classes {
+		report.Bytes += class.Bytes
+		report.EstimatedMonthlyCost += class.EstimatedMonthlyCost
+	}
+	for i := range report.Users {
+		user := &report.Users[i]
+		for _, class := range user.Classes {
+			user.Bytes += class.Bytes
+			user.EstimatedMonthlyCost += class.EstimatedMonthlyCost
+		}
+	}
+	slices.SortStableFunc(report.Users, func(a, b userStorageCost) int {
+		switch {
+		case a.EstimatedMonthlyCost > b.EstimatedMonthlyCost:
+			return -1
+		case a.EstimatedMonthlyCost < b.EstimatedMonthlyCost:
+			return 1
+		}
+		return 0
+	})
+
+	respondWithJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// Storage migration settings.
+const (
+	storageMigrationPage    = 100
+	storageMigrationsShown  = 50
+	storageMigrationTimeout = 5 * time.Minute
+)
+
+// storageClasses are the S3 storage classes objects can be moved to.
+var storageClasses = []string{
+	string(types.StorageClassStandard),
+	string(types.StorageClassStandardIa),
+	string(types.StorageClassOnezoneIa),
+	string(types.StorageClassIntelligentTiering),
+	string(types.StorageClassGlacierIr),
+	string(types.StorageClassGlacier),
+	string(types.StorageClassDeepArchive),
+}
+
+// archiveStorageClasses can't be read without restoring the object first,
+// so only originals, which are only read for reprocessing, are moved there.
+var archiveStorageClasses = []string{
+	string(types.StorageClassGlacier),
+	string(types.StorageClassDeepArchive),
+}
+
+// objectClassKinds returns the kinds of asset stored in a class, the
+// reverse of assetObjectClass.
+func objectClassKinds(class objectClass) []database.AssetKind {
+	switch class {
+	case objectClassOriginal:
+		return []database.AssetKind{database.AssetKindOriginal}
+	case objectClassRendition:
+		return []database.AssetKind{database.AssetKindRendition}
+	case objectClassThumbnail:
+		return []database.AssetKind{database.AssetKindThumbnail, database.AssetKindThumbnailCandidate, database.AssetKindThumbnailVariant}
+	}
+	return nil
+}
+
+// startStorageMigration moves a migration's objects in the background.
+func (cfg *apiConfig) startStorageMigration(migration database.StorageMigration) {
+	go func() {
+		start := time.Now()
+		err := cfg.runStorageMigration(migration)
+		if err != nil {
+			logging.Errorf("Storage migration %s failed: %v", migration.ID, err)
+			err = cfg.db.FailStorageMigration(migration.ID, err.Error())
+			if err != nil {
+				logging.Warnf("Couldn't record failure of storage migration %s: %v", migration.ID, err)
+			}
+			return
+		}
+		logging.Infof("Storage migration %s to %s finished in %s", migration.ID, migration.StorageClass,
+			time.Since(start).Round(time.Millisecond))
+	}()
+}
+
+// resumeStorageMigrations starts again every migration left pending by a
+// previous process. Objects already moved no longer match, so it carries on
+// where it stopped.
+func (cfg *apiConfig) resumeStorageMigrations() error {
+	migrations, err := cfg.db.GetPendingStorageMigrations()
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		logging.Infof("Resuming storage migration %s", migration.ID)
+		cfg.startStorageMigration(migration)
+	}
+	return nil
+}
+
+// runStorageMigration moves every matching object, a page at a time,
+// counting what was moved and what couldn't be. An object that can't be
+// moved doesn't stop the rest. Objects of videos under legal hold are left
+// as they are and counted as not moved.
+func (cfg *apiConfig) runStorageMigration(migration database.StorageMigration) error {
+	params := migration.CreateStorageMigrationParams
+	kinds := objectClassKinds(objectClass(params.ObjectClass))
+	// A resumed migration keeps the count it started with
+	if migration.Objects == 0 {
+		objects, err := cfg.db.CountStorageMigrationObjects(params, kinds)
+		if err != nil {
+			return err
+		}
+		err = cfg.db.StartStorageMigration(migration.ID, objects)
+		if err != nil {
+			return err
+		}
+	}
+
+	after := uuid.Nil
+	for {
+		assets, err := cfg.db.GetStorageMigrationAssets(params, kinds, after, storageMigrationPage)
+		if err != nil {
+			return err
+		}
+		for _, asset := range assets {
+			var migrated, failed int64 = 1, 0
+			err := cfg.migrateAsset(asset, params.StorageClass)
+			switch {
+			case errors.Is(err, database.ErrLegalHold):
+				logging.Infof("Storage migration %s skipped %s %s, since video %s is under legal hold", migration.ID, asset.Kind, asset.Location, asset.VideoID)
+				migrated, failed = 0, 1
+			case err != nil:
+				logging.Warnf("Storage migration %s couldn't move %s %s: %v", migration.ID, asset.Kind, asset.Location, err)
+				migrated, failed = 0, 1
+			}
+			err = cfg.db.RecordStorageMigrationProgress(migration.ID, migrated, failed)
+			if err != nil {
+				return err
+			}
+		}
+		if len(assets) < storageMigrationPage {
+			break
+		}
+		after = assets[len(assets)-1].ID
+	}
+	return cfg.db.CompleteStorageMigration(migration.ID)
+}
+
+// migrateAsset moves one object to storageClass and records the move. The
+// copy gets a checksum of its own, which replaces the one recorded on the
+// video for its original or rendition. An object of a video under legal
+// hold isn't moved, returning database.ErrLegalHold.
+func (cfg *apiConfig) migrateAsset(asset database.Asset, storageClass string) error {
+	video, err := cfg.db.GetVideo(asset.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.LegalHold {
+		return database.ErrLegalHold
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), storageMigrationTimeout)
+	defer cancel()
+	checksum, err := cfg.changeStorageClass(ctx, asset.Location, storageClass)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.SetAssetStorageClass(asset.Storage, asset.Location, storageClass)
+	if err != nil {
+		return err
+	}
+	if checksum == nil || (asset.Kind != database.AssetKindOriginal && asset.Kind != database.AssetKindRendition) {
+		return nil
+	}
+	_, err = cfg.changeVideo(asset.VideoID, func(video *database.Video) bool {
+		switch {
+		case video.VideoURL != nil && *video.VideoURL == asset.Location:
+			video.VideoChecksum = checksum
+		case video.OriginalURL != nil && *video.OriginalURL == asset.Location:
+			video.OriginalChecksum = checksum
+		default:
+			return false
+		}
+		return true
+	})
+	return err
+}
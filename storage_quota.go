@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// storageAlertThresholds are the shares of the storage quota, in percent,
+// that users are notified on reaching. Uploads are refused at the last.
+var storageAlertThresholds = []int{80, 100}
+
+// Storage usage states, from how many thresholds usage has reached.
+const (
+	storageStateOK      = "ok"
+	storageStateWarning = "warning"
+	storageStateFull    = "full"
+)
+
+type storageUsage struct {
+	UsedBytes int64 `json:"used_bytes"`
+	// LimitBytes and UsedPercent are nil without a storage quota.
+	LimitBytes               *int64   `json:"limit_bytes"`
+	UsedPercent              *float64 `json:"used_percent"`
+	AlertThresholdsPercent   []int    `json:"alert_thresholds_percent"`
+	ReachedThresholdsPercent []int    `json:"reached_thresholds_percent"`
+	// State is one of the storageState* values.
+	State string `json:"state"`
+}
+
+// storageUsage works out how much of their storage quota a user has used.
+func (cfg *apiConfig) storageUsage(userID uuid.UUID) (storageUsage, error) {
+	used, err := cfg.db.GetUserStorageUsed(userID)
+	if err != nil {
+		return storageUsage{}, err
+	}
+	usage := storageUsage{
+		UsedBytes:                used,
+		AlertThresholdsPercent:   storageAlertThresholds,
+		ReachedThresholdsPercent: []int{},
+		State:                    storageStateOK,
+	}
+	if cfg.storageQuotaBytes <= 0 {
+		usage.AlertThresholdsPercent = []int{}
+		return usage, nil
+	}
+
+	limit := cfg.storageQuotaBytes
+	percent := float64(used) * 100 / float64(limit)
+	usage.LimitBytes = &limit
+	usage.UsedPercent = &percent
+	for _, threshold := range storageAlertThresholds {
+		if used*100 >= limit*int64(threshold) {
+			usage.ReachedThresholdsPercent = append(usage.ReachedThresholdsPercent, threshold)
+		}
+	}
+	switch len(usage.ReachedThresholdsPercent) {
+	case 0:
+	case len(storageAlertThresholds):
+		usage.State = storageStateFull
+	default:
+		usage.State = storageStateWarning
+	}
+	return usage, nil
+}
+
+// highestThreshold is the highest alert threshold usage has reached, or 0.
+func (u storageUsage) highestThreshold() int {
+	if len(u.ReachedThresholdsPercent) == 0 {
+		return 0
+	}
+	return u.ReachedThresholdsPercent[len(u.ReachedThresholdsPercent)-1]
+}
+
+// checkStorageAlerts notifies a user whose stored videos have reached a
+// higher alert threshold than when last checked. It's called after
+// anything that changes how much a user stores; usage going down lowers
+// the threshold, so crossing it again notifies them again.
+func (cfg *apiConfig) checkStorageAlerts(userID uuid.UUID) {
+	if cfg.storageQuotaBytes <= 0 {
+		return
+	}
+	usage, err := cfg.storageUsage(userID)
+	if err != nil {
+		logging.Warnf("Couldn't check storage usage of user %s: %v", userID, err)
+		return
+	}
+	threshold := usage.highestThreshold()
+	raised, err := cfg.db.SetStorageAlertPercent(userID, threshold)
+	if err != nil {
+		logging.Warnf("Couldn't record storage alert of user %s: %v", userID, err)
+		return
+	}
+	if !raised {
+		return
+	}
+
+	eventType := events.TypeStorageQuotaWarning
+	if usage.State == storageStateFull {
+		eventType = events.TypeStorageQuotaFull
+	}
+	cfg.publishEvent(eventType, userID, map[string]any{
+		"quota":             "storage_bytes",
+		"threshold_percent": threshold,
+		"used_bytes":        usage.UsedBytes,
+		"limit_bytes":       cfg.storageQuotaBytes,
+		"used":              formatBytes(usage.UsedBytes),
+		"limit":             formatBytes(cfg.storageQuotaBytes),
+	})
+}
+
+// checkStorageQuota responds with a 403 if the user's storage is full. It
+// returns false when the upload must not go ahead.
+func (cfg *apiConfig) checkStorageQuota(w http.ResponseWriter, userID uuid.UUID) bool {
+	if cfg.storageQuotaBytes <= 0 {
+		return true
+	}
+	usage, err := cfg.storageUsage(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check storage quota", err)
+		return false
+	}
+	if usage.State != storageStateFull {
+		return true
+	}
+	respondWithErrorCode(w, http.StatusForbidden, errCodeQuotaExceeded,
+		fmt.Sprintf("Storage quota of %s is full", formatBytes(cfg.storageQuotaBytes)), nil)
+	return false
+}
+
+// formatBytes writes a byte count for people, e.g. "1.5 GB".
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value := float64(n)
+	for _, suffix := range []string{"kB", "MB", "GB", "TB"} {
+		value /= unit
+		if value < unit || suffix == "TB" {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// handleStorageChanged checks the storage alerts of a user whose video was
+// processed or deleted, the changes that move their usage the most.
+func (cfg *apiConfig) handleStorageChanged(ctx context.Context, event events.Event) error {
+	if event.Type != events.TypeVideoProcessed && event.Type != events.TypeVideoDeleted {
+		return nil
+	}
+	cfg.checkStorageAlerts(event.UserID)
+	return nil
+}
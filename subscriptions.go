@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// announceIfPublished publishes TypeVideoPublished the first time a video
+// can be watched by anyone. It's called wherever a video may have just
+// become public, processed or approved; the database makes sure only the
+// first call announces it.
+func (cfg *apiConfig) announceIfPublished(videoID uuid.UUID) {
+	first, err := cfg.db.MarkVideoPublished(videoID)
+	if err != nil {
+		logging.Warnf("Couldn't mark video %s as published: %v", videoID, err)
+		return
+	}
+	if first {
+		cfg.publishVideoEvent(videoID, events.TypeVideoPublished, map[string]any{})
+	}
+}
+
+// handleVideoPublished tells each of the creator's followers about their
+// new video.
+func (cfg *apiConfig) handleVideoPublished(ctx context.Context, event events.Event) error {
+	if event.Type != events.TypeVideoPublished {
+		return nil
+	}
+	var data struct {
+		VideoID uuid.UUID `json:"video_id"`
+		Title   string    `json:"title"`
+	}
+	err := json.Unmarshal(event.Data, &data)
+	if err != nil {
+		return fmt.Errorf("couldn't decode %s event: %w", event.Type, err)
+	}
+
+	followerIDs, err := cfg.db.GetFollowerIDs(event.UserID)
+	if err != nil {
+		return err
+	}
+	for _, followerID := range followerIDs {
+		cfg.publishEvent(events.TypeSubscriptionVideoPublished, followerID, map[string]any{
+			"video_id":   data.VideoID,
+			"title":      data.Title,
+			"creator_id": event.UserID,
+		})
+	}
+	return nil
+}
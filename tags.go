@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const maxVideoTags = 20
+
+// videoTag matches a normalized tag: lowercase letters, digits, spaces, '_'
+// or '-', starting with a letter or digit.
+var videoTag = regexp.MustCompile(`^[a-z0-9][a-z0-9 _-]{0,49}$`)
+
+// normalizeTags lowercases and trims tags, drops duplicates and validates
+// the result.
+func normalizeTags(tags []string) (database.VideoTags, error) {
+	normalized := database.VideoTags{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !videoTag.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q: use up to 50 lowercase letters, digits, spaces, '_' or '-'", tag)
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxVideoTags {
+		return nil, fmt.Errorf("a video can have at most %d tags", maxVideoTags)
+	}
+	return normalized, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+)
+
+// tempSweepInterval is how often stale temporary files are looked for.
+// Temporary files are local, so every instance sweeps its own rather than
+// this being a scheduled job run by the leader.
+const tempSweepInterval = time.Hour
+
+// Temporary files are named with this prefix, including the working files
+// derived from an upload such as its ".processing" and ".wav" outputs.
+const tempFilePrefix = "tubely-"
+
+// Partially written assets are named with this prefix in the assets
+// directory until they're renamed into place.
+const assetTempFilePrefix = ".upload-"
+
+// startTempSweeper removes temporary files left behind by crashes, now and
+// then every tempSweepInterval.
+func (cfg *apiConfig) startTempSweeper(maxAge time.Duration) {
+	go func() {
+		ticker := time.NewTicker(tempSweepInterval)
+		defer ticker.Stop()
+		for {
+			cfg.sweepTempFiles(maxAge)
+			<-ticker.C
+		}
+	}()
+}
+
+// sweepTempFiles removes temporary files and directories not modified in
+// maxAge. The source files of incomplete jobs, and the working files
+// derived from them, are kept however old they are so the jobs can still
+// be resumed.
+func (cfg *apiConfig) sweepTempFiles(maxAge time.Duration) {
+	jobs, err := cfg.db.GetIncompleteJobs()
+	if err != nil {
+		logging.Warnf("Couldn't list incomplete jobs to sweep temporary files: %v", err)
+		return
+	}
+	inUse := make([]string, 0, len(jobs))
+	for _, job := range jobs {
+		if job.SourcePath != "" {
+			inUse = append(inUse, job.SourcePath)
+		}
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	files, bytes := sweepDir(os.TempDir(), tempFilePrefix, cutoff, inUse)
+	assetFiles, assetBytes := sweepDir(cfg.assetsRoot, assetTempFilePrefix, cutoff, nil)
+	files += assetFiles
+	bytes += assetBytes
+
+	tempSweepFiles.Add(float64(files))
+	tempSweepBytes.Add(float64(bytes))
+	if files > 0 {
+		logging.Infof("Removed %d stale temporary files, reclaiming %d bytes", files, bytes)
+	}
+}
+
+// sweepDir removes the entries of dir named with prefix that weren't
+// modified since cutoff and don't belong to one of the inUse paths. It
+// returns how many files were removed and their total size.
+func sweepDir(dir, prefix string, cutoff time.Time, inUse []string) (int, int64) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		logging.Warnf("Couldn't read %s to sweep temporary files: %v", dir, err)
+		return 0, 0
+	}
+
+	var files int
+	var bytes int64
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if belongsToAny(path, inUse) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+
+		// Count what a directory holds before it's removed
+		n, size := 1, info.Size()
+		if entry.IsDir() {
+			n, size = 0, 0
+			filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return nil
+				}
+				if info, err := d.Info(); err == nil {
+					n++
+					size += info.Size()
+				}
+				return nil
+			})
+		}
+		if err := os.RemoveAll(path); err != nil {
+			logging.Warnf("Couldn't remove stale temporary file %s: %v", path, err)
+			continue
+		}
+		files += n
+		bytes += size
+	}
+	return files, bytes
+}
+
+// belongsToAny reports whether path is one of paths or a working file
+// derived from one, such as path + ".processing".
+func belongsToAny(path string, paths []string) bool {
+	for _, p := range paths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// bandwidthLimiter spreads writes over time so that all readers sharing it
+// together stay under bytesPerSecond.
+type bandwidthLimiter struct {
+	bytesPerSecond int64
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// wait blocks until n more bytes may be sent.
+func (l *bandwidthLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.bytesPerSecond))
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	limiter *bandwidthLimiter
+}
+
+func (r throttledReader) Read(p []byte) (int, error) {
+	// Small reads keep the flow smooth instead of bursting whole buffers
+	if len(p) > 32<<10 {
+		p = p[:32<<10]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.limiter.wait(n)
+	}
+	return n, err
+}
+
+// throttledHTTPClient limits the rate at which request bodies are sent. It
+// wraps the transport rather than the upload body so the SDK's own reads for
+// signing and checksums aren't throttled.
+type throttledHTTPClient struct {
+	client  s3.HTTPClient
+	limiter *bandwidthLimiter
+}
+
+func (c throttledHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = throttledReader{ReadCloser: req.Body, limiter: c.limiter}
+	}
+	return c.client.Do(req)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+)
+
+// thumbnailCandidateCount is how many frames are extracted per processed video.
+const thumbnailCandidateCount = 4
+
+// assetURL is the public URL of a file in the assets root.
+func (cfg *apiConfig) assetURL(filename string) string {
+	return fmt.Sprintf("http://localhost:%s/assets/%s", cfg.port, filename)
+}
+
+// generateThumbnailCandidates extracts evenly spaced frames from the source
+// and records them as candidates, flagging any the image classifier objects
+// to. If the video has no thumbnail yet, the first unflagged candidate
+// becomes its thumbnail, or without one the owner's default thumbnail.
+// Failures are logged rather than failing the job, since the video itself
+// is already processed.
+func (cfg *apiConfig) generateThumbnailCandidates(video database.Video, sourcePath string, duration time.Duration) {
+	var candidates []database.ThumbnailCandidate
+	for i := 1; i <= thumbnailCandidateCount; i++ {
+		// Without a known duration, fall back to the first few seconds
+		offset := time.Duration(i) * time.Second
+		if duration > 0 {
+			offset = duration * time.Duration(i) / (thumbnailCandidateCount + 1)
+		}
+
+		randomBytes := make([]byte, 32)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			logging.Warnf("Couldn't generate thumbnail candidate filename: %v", err)
+			return
+		}
+		filename := base64.RawURLEncoding.EncodeToString(randomBytes) + ".jpg"
+
+		framePath := filepath.Join(cfg.assetsRoot, filename)
+		err = cfg.transcoder.ExtractFrame(context.TODO(), sourcePath, offset, framePath)
+		if err != nil {
+			logging.Warnf("Couldn't extract thumbnail candidate for video %s: %v", video.ID, err)
+			continue
+		}
+		frame, err := os.ReadFile(framePath)
+		if err != nil {
+			os.Remove(framePath)
+			logging.Warnf("Couldn't read thumbnail candidate for video %s: %v", video.ID, err)
+			continue
+		}
+		flagged, _ := cfg.classifyThumbnail(video.ID, frame)
+
+		candidate, err := cfg.db.CreateThumbnailCandidate(database.CreateThumbnailCandidateParams{
+			VideoID:  video.ID,
+			Filename: filename,
+			Offset:   offset,
+			Flagged:  flagged,
+		})
+		if err != nil {
+			os.Remove(framePath)
+			logging.Warnf("Couldn't save thumbnail candidate for video %s: %v", video.ID, err)
+			continue
+		}
+		cfg.trackAsset(video.ID, database.AssetKindThumbnailCandidate, database.AssetStorageLocal, filename)
+		// Flagged frames are never picked automatically
+		if !candidate.Flagged {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	if video.ThumbnailURL != nil {
+		return
+	}
+	if len(candidates) == 0 {
+		cfg.applyDefaultThumbnail(video)
+		return
+	}
+
+	// Re-read the video so a thumbnail uploaded meanwhile isn't overwritten
+	set := false
+	updated, err := cfg.changeVideo(video.ID, func(video *database.Video) bool {
+		if video.ThumbnailURL != nil {
+			return false
+		}
+		thumbnailURL := cfg.assetURL(candidates[0].Filename)
+		video.ThumbnailURL = &thumbnailURL
+		set = true
+		return true
+	})
+	if err != nil {
+		logging.Warnf("Couldn't set default thumbnail for video %s: %v", video.ID, err)
+		return
+	}
+	if set {
+		cfg.trackThumbnail(updated)
+	}
+}
+
+// applyDefaultThumbnail gives a video without a thumbnail its owner's
+// default thumbnail, if they've set one. The video shares the stored image
+// with the owner's settings.
+func (cfg *apiConfig) applyDefaultThumbnail(video database.Video) {
+	owner, err := cfg.db.GetUser(video.UserID)
+	if err != nil {
+		logging.Warnf("Couldn't get owner of video %s: %v", video.ID, err)
+		return
+	}
+	if owner == nil || owner.DefaultThumbnailURL == nil {
+		return
+	}
+
+	set := false
+	updated, err := cfg.changeVideo(video.ID, func(video *database.Video) bool {
+		if video.ThumbnailURL != nil {
+			return false
+		}
+		video.ThumbnailURL = owner.DefaultThumbnailURL
+		set = true
+		return true
+	})
+	if err != nil {
+		logging.Warnf("Couldn't set default thumbnail for video %s: %v", video.ID, err)
+		return
+	}
+	if set {
+		cfg.trackThumbnail(updated)
+	}
+}
+
+// collectThumbnailCandidates deletes candidates that weren't picked as the
+// thumbnail once they're older than the retention period.
+func (cfg *apiConfig) collectThumbnailCandidates(retention time.Duration) error {
+	candidates, err := cfg.db.GetExpiredThumbnailCandidates(time.Now().Add(-retention), cfg.assetURL(""))
+	if err != nil {
+		return fmt.Errorf("couldn't list expired thumbnail candidates: %w", err)
+	}
+	for _, candidate := range candidates {
+		err := cfg.releaseAsset(database.CreateAssetParams{
+			VideoID:  candidate.VideoID,
+			Kind:     database.AssetKindThumbnailCandidate,
+			Storage:  database.AssetStorageLocal,
+			Location: candidate.Filename,
+		})
+		// Candidates of held videos expire once the hold is lifted
+		if errors.Is(err, database.ErrLegalHold) {
+			continue
+		}
+		if err != nil {
+			logging.Warnf("Couldn't delete thumbnail candidate %s: %v", candidate.ID, err)
+			continue
+		}
+		err = cfg.db.DeleteThumbnailCandidate(candidate.ID)
+		if err != nil {
+			logging.Warnf("Couldn't delete thumbnail candidate %s: %v", candidate.ID, err)
+		}
+	}
+	return nil
+}
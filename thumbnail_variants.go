@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math/rand/v2"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+)
+
+// maxThumbnailVariants is how many thumbnails can be tried against each
+// other for one video.
+const maxThumbnailVariants = 3
+
+// How thumbnail variants are picked for each view of a video.
+const (
+	// variantSelectionRoundRobin shows the least shown variant next, so
+	// every variant gets the same number of impressions.
+	variantSelectionRoundRobin = "round_robin"
+	// variantSelectionWeighted picks at random in proportion to the
+	// variants' weights.
+	variantSelectionWeighted = "weighted"
+)
+
+func pickThumbnailVariant(variants []database.ThumbnailVariant, selection string) database.ThumbnailVariant {
+	if selection == variantSelectionWeighted {
+		total := 0
+		for _, variant := range variants {
+			total += variant.Weight
+		}
+		n := rand.IntN(total)
+		for _, variant := range variants {
+			n -= variant.Weight
+			if n < 0 {
+				return variant
+			}
+		}
+	}
+
+	picked := variants[0]
+	for _, variant := range variants[1:] {
+		if variant.Impressions < picked.Impressions {
+			picked = variant
+		}
+	}
+	return picked
+}
+
+// showThumbnailVariant swaps in one of the video's thumbnail variants, if it
+// has any, and counts an impression for it. Failures leave the video's own
+// thumbnail in place.
+func (cfg *apiConfig) showThumbnailVariant(video *database.Video) {
+	variants, err := cfg.db.GetThumbnailVariants(video.ID)
+	if err != nil {
+		logging.Warnf("Couldn't get thumbnail variants for video %s: %v", video.ID, err)
+		return
+	}
+	if len(variants) == 0 {
+		return
+	}
+
+	variant := pickThumbnailVariant(variants, cfg.thumbnailVariantSelection)
+	video.ThumbnailURL = &variant.ThumbnailURL
+	video.ThumbnailVariantID = &variant.ID
+	err = cfg.db.RecordThumbnailImpression(variant.ID)
+	if err != nil {
+		logging.Warnf("Couldn't record impression of thumbnail variant %s: %v", variant.ID, err)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// URL import settings.
+const (
+	// urlImportSource is the source recorded for videos imported from the
+	// pages of sites like YouTube
+	urlImportSource = "url"
+	// urlImportTimeout bounds downloading a video and its thumbnail
+	urlImportTimeout = 30 * time.Minute
+	// maxImportedThumbnailSize matches the limit on uploaded thumbnails
+	maxImportedThumbnailSize = 10 << 20
+)
+
+// importURL downloads the video on a claimed page and queues it for
+// processing, recording the outcome on the import.
+func (cfg *apiConfig) importURL(claimed database.Import) {
+	ctx, cancel := context.WithTimeout(context.Background(), urlImportTimeout)
+	defer cancel()
+
+	status, videoID, err := cfg.downloadURLImport(ctx, claimed)
+	var importErr *string
+	if err != nil {
+		logging.Warnf("Couldn't import %s: %v", claimed.URL, err)
+		msg := err.Error()
+		importErr = &msg
+	}
+	err = cfg.db.FinishImport(claimed.ID, status, videoID, importErr)
+	if err != nil {
+		logging.Errorf("Couldn't record import of %s: %v", claimed.URL, err)
+	}
+}
+
+// downloadURLImport creates a video from what the downloader fetched from
+// the page, with the page's title, description, tags and thumbnail, and
+// returns the status to record for the import.
+func (cfg *apiConfig) downloadURLImport(ctx context.Context, claimed database.Import) (string, *uuid.UUID, error) {
+	dir, err := os.MkdirTemp("", "tubely-url-import-")
+	if err != nil {
+		return database.ImportStatusFailed, nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	download, err := cfg.urlDownloader.Download(ctx, claimed.URL, dir)
+	if err != nil {
+		return database.ImportStatusFailed, nil, err
+	}
+	// Moved out of the download directory, which is removed, for the job
+	sourcePath := dir + ".mp4"
+	err = os.Rename(download.VideoPath, sourcePath)
+	if err != nil {
+		return database.ImportStatusFailed, nil, err
+	}
+	keepSource := false
+	defer func() {
+		if !keepSource {
+			os.Remove(sourcePath)
+		}
+	}()
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return database.ImportStatusFailed, nil, err
+	}
+	if info.Size() > maxVideoUploadSize {
+		return database.ImportStatusSkipped, nil, fmt.Errorf("video is larger than the maximum of %d bytes", maxVideoUploadSize)
+	}
+
+	page := download.Info
+	pageURL := claimed.URL
+	if page.WebpageURL != "" {
+		pageURL = page.WebpageURL
+	}
+	video, err := cfg.createImportedVideo(claimed, database.CreateVideoParams{
+		Title:       truncateRunes(strings.TrimSpace(page.Title), maxTagTitleLength),
+		Description: truncateRunes(strings.TrimSpace(page.Description), maxTagDescriptionLength),
+		UserID:      claimed.UserID,
+	}, database.Provenance{
+		Source:     claimed.Source,
+		URL:        pageURL,
+		Size:       info.Size(),
+		ModifiedAt: page.UploadedAt,
+	})
+	if err != nil {
+		return database.ImportStatusFailed, createdVideoID(video), err
+	}
+	// Counted like an upload, since the user asked for it
+	err = cfg.db.RecordUpload(claimed.UserID, video.ID)
+	if err != nil {
+		logging.Warnf("Couldn't record upload of video %s: %v", video.ID, err)
+	}
+
+	tags := importedTags(page.Tags)
+	var thumbnail []byte
+	if download.ThumbnailPath != "" {
+		thumbnail, err = readImportedThumbnail(download.ThumbnailPath)
+		if err != nil {
+			logging.Warnf("Couldn't use the thumbnail of %s: %v", claimed.URL, err)
+		}
+	}
+	if len(tags) > 0 || thumbnail != nil {
+		cfg.applyImportedPage(video.ID, tags, thumbnail)
+	}
+
+	err = cfg.queueImportedVideo(claimed, video, sourcePath)
+	if err != nil {
+		return database.ImportStatusFailed, &video.ID, err
+	}
+	keepSource = true
+	return database.ImportStatusImported, &video.ID, nil
+}
+
+// importedTags keeps the tags from a page that are valid video tags, up to
+// the limit.
+func importedTags(tags []string) database.VideoTags {
+	imported := database.VideoTags{}
+	for _, tag := range tags {
+		normalized, err := normalizeTags([]string{tag})
+		if err != nil || slices.Contains(imported, normalized[0]) {
+			continue
+		}
+		imported = append(imported, normalized[0])
+		if len(imported) == maxVideoTags {
+			break
+		}
+	}
+	return imported
+}
+
+// readImportedThumbnail reads a downloaded thumbnail, which must be a JPEG
+// or PNG image.
+func readImportedThumbnail(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > maxImportedThumbnailSize {
+		return nil, fmt.Errorf("thumbnail is larger than the maximum of %d bytes", maxImportedThumbnailSize)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mediaType := http.DetectContentType(data)
+	if mediaType != "image/jpeg" && mediaType != "image/png" {
+		return nil, fmt.Errorf("thumbnail is %s, not a JPEG or PNG image", mediaType)
+	}
+	return data, nil
+}
+
+// applyImportedPage sets the tags and thumbnail taken from an imported
+// video's page. A thumbnail moderation flags waits for review, like an
+// uploaded one.
+func (cfg *apiConfig) applyImportedPage(videoID uuid.UUID, tags database.VideoTags, thumbnail []byte) {
+	var thumbnailURL string
+	var flagged bool
+	var labels database.ModerationLabels
+	var originalSize, optimizedSize int64
+	if thumbnail != nil {
+		mediaType, fileExtension := "image/jpeg", "jpg"
+		if http.DetectContentType(thumbnail) == "image/png" {
+			mediaType, fileExtension = "image/png", "png"
+		}
+		data, err := optimizeImage(thumbnail, mediaType, cfg.thumbnailJPEGQuality)
+		if err == nil {
+			thumbnailURL, err = cfg.storeThumbnailImage(data, mediaType, fileExtension)
+		}
+		if err != nil {
+			logging.Warnf("Couldn't store imported thumbnail for video %s: %v", videoID, err)
+		} else {
+			flagged, labels = cfg.classifyThumbnail(videoID, data)
+			originalSize, optimizedSize = int64(len(thumbnail)), int64(len(data))
+		}
+	}
+
+	updated, err := cfg.changeVideo(videoID, func(video *database.Video) bool {
+		if len(tags) > 0 {
+			video.Tags = tags
+		}
+		if thumbnailURL == "" {
+			return len(tags) > 0
+		}
+		if flagged {
+			cfg.holdThumbnail(video, thumbnailURL, labels)
+			return true
+		}
+		video.ThumbnailURL = &thumbnailURL
+		video.ThumbnailOriginalSize = &originalSize
+		video.ThumbnailSize = &optimizedSize
+		return true
+	})
+	if err != nil {
+		logging.Warnf("Couldn't update imported video %s: %v", videoID, err)
+		return
+	}
+	cfg.trackThumbnail(updated)
+}
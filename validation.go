@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// paramLocation is the part of the request a parameter is read from.
+type paramLocation string
+
+const (
+	paramInPath  paramLocation = "path"
+	paramInQuery paramLocation = "query"
+	paramInForm  paramLocation = "form"
+	paramInBody  paramLocation = "body"
+)
+
+// fieldError describes one invalid request parameter.
+type fieldError struct {
+	Field   string        `json:"field"`
+	In      paramLocation `json:"in"`
+	Message string        `json:"message"`
+}
+
+// paramRule declares the constraints on one request parameter. check returns
+// a message describing what's wrong with a present value, or "" if it's valid.
+type paramRule struct {
+	in       paramLocation
+	name     string
+	required bool
+	check    func(value string) string
+}
+
+// validateParams checks a route's declared parameters before calling next.
+// Every invalid parameter is reported at once, so clients can point at each
+// offending field. Form rules parse the request body, so they shouldn't be
+// declared on routes that limit the body themselves.
+func validateParams(next http.HandlerFunc, rules ...paramRule) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fields := []fieldError{}
+		for _, rule := range rules {
+			value, err := paramValue(r, rule)
+			if err != nil {
+				respondWithError(w, http.StatusBadRequest, "Couldn't parse form", err)
+				return
+			}
+			if value == "" {
+				if rule.required {
+					fields = append(fields, fieldError{Field: rule.name, In: rule.in, Message: "is required"})
+				}
+				continue
+			}
+			if msg := rule.check(value); msg != "" {
+				fields = append(fields, fieldError{Field: rule.name, In: rule.in, Message: msg})
+			}
+		}
+		if len(fields) > 0 {
+			respondWithFieldErrors(w, http.StatusBadRequest, "Invalid request parameters", fields)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func paramValue(r *http.Request, rule paramRule) (string, error) {
+	switch rule.in {
+	case paramInPath:
+		return r.PathValue(rule.name), nil
+	case paramInQuery:
+		return r.URL.Query().Get(rule.name), nil
+	case paramInForm:
+		if r.Form == nil {
+			const maxMemory = 10 << 20
+			err := r.ParseMultipartForm(maxMemory)
+			if err != nil && err != http.ErrNotMultipart {
+				return "", err
+			}
+		}
+		return r.FormValue(rule.name), nil
+	}
+	return "", fmt.Errorf("unknown parameter location %q", rule.in)
+}
+
+// pathUUID requires a path parameter to be a UUID.
+func pathUUID(name string) paramRule {
+	return paramRule{in: paramInPath, name: name, required: true, check: checkUUID}
+}
+
+// pathInt requires a path parameter to be an integer in [min, max].
+func pathInt(name string, min, max int) paramRule {
+	return paramRule{in: paramInPath, name: name, required: true, check: checkIntRange(min, max)}
+}
+
+// pathOneOf requires a path parameter to be one of the given values.
+func pathOneOf(name string, values ...string) paramRule {
+	return paramRule{in: paramInPath, name: name, required: true, check: checkOneOf(values)}
+}
+
+// queryUUID allows an optional query parameter that must be a UUID.
+func queryUUID(name string) paramRule {
+	return paramRule{in: paramInQuery, name: name, check: checkUUID}
+}
+
+// queryBool allows an optional query parameter that must be a boolean.
+func queryBool(name string) paramRule {
+	return paramRule{in: paramInQuery, name: name, check: func(value string) string {
+		if _, err := strconv.ParseBool(value); err != nil {
+			return "must be true or false"
+		}
+		return ""
+	}}
+}
+
+// queryOneOf allows an optional query parameter with one of the given values.
+func queryOneOf(name string, values ...string) paramRule {
+	return paramRule{in: paramInQuery, name: name, check: checkOneOf(values)}
+}
+
+// queryDuration allows an optional query parameter that must be a
+// non-negative number of seconds.
+func queryDuration(name string) paramRule {
+	return paramRule{in: paramInQuery, name: name, check: func(value string) string {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds < 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+			return "must be a number of seconds"
+		}
+		return ""
+	}}
+}
+
+// queryTime allows an optional query parameter that must be an RFC 3339
+// timestamp.
+func queryTime(name string) paramRule {
+	return paramRule{in: paramInQuery, name: name, check: func(value string) string {
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return "must be an RFC 3339 timestamp"
+		}
+		return ""
+	}}
+}
+
+// queryDate allows an optional query parameter that must be a date like
+// 2006-01-02.
+func queryDate(name string) paramRule {
+	return paramRule{in: paramInQuery, name: name, check: func(value string) string {
+		if _, err := time.Parse(time.DateOnly, value); err != nil {
+			return "must be a date like 2006-01-02"
+		}
+		return ""
+	}}
+}
+
+// queryMonth requires a query parameter that must be a month like 2006-01.
+func queryMonth(name string) paramRule {
+	return paramRule{in: paramInQuery, name: name, required: true, check: func(value string) string {
+		if _, err := time.Parse(billingMonthFormat, value); err != nil {
+			return "must be a month like 2006-01"
+		}
+		return ""
+	}}
+}
+
+// queryTag allows an optional query parameter that must be a valid tag.
+func queryTag(name string) paramRule {
+	return paramRule{in: paramInQuery, name: name, check: func(value string) string {
+		if !videoTag.MatchString(strings.ToLower(strings.TrimSpace(value))) {
+			return "must be a valid tag"
+		}
+		return ""
+	}}
+}
+
+// formInt allows an optional form field that must be an integer in
+// [min, max].
+func formInt(name string, min, max int) paramRule {
+	return paramRule{in: paramInForm, name: name, check: checkIntRange(min, max)}
+}
+
+func checkUUID(value string) string {
+	if _, err := uuid.Parse(value); err != nil {
+		return "must be a UUID"
+	}
+	return ""
+}
+
+func checkOneOf(values []string) func(string) string {
+	return func(value string) string {
+		for _, v := range values {
+			if value == v {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %s", strings.Join(values, ", "))
+	}
+}
+
+func checkIntRange(min, max int) func(string) string {
+	return func(value string) string {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < min || n > max {
+			return fmt.Sprintf("must be an integer between %d and %d", min, max)
+		}
+		return ""
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// Video export settings.
+const (
+	videoExportsShown = 50
+	// videoExportObjectTimeout bounds copying one object
+	videoExportObjectTimeout = 30 * time.Minute
+	// videoExportManifestName is written at the export's prefix once every
+	// object has been tried
+	videoExportManifestName = "manifest.json"
+)
+
+// videoExportRefusals are the errors a destination bucket answers with when
+// nothing can be exported to it, which stop the export rather than failing
+// every object in turn.
+var videoExportRefusals = []string{
+	"AccessDenied",
+	"InvalidAccessKeyId",
+	"SignatureDoesNotMatch",
+	"NoSuchBucket",
+	"PermanentRedirect",
+	"AuthorizationHeaderMalformed",
+}
+
+// exportObject is a stored object copied into an export's bucket.
+type exportObject struct {
+	videoID  uuid.UUID
+	kind     database.AssetKind
+	storage  database.AssetStorage
+	location string
+	// name is the object's key below its video's folder in the export
+	name        string
+	contentType string
+	language    string
+}
+
+// exportManifest lists every exported video with its metadata and the keys
+// its files were copied to, so the export can be imported elsewhere.
+type exportManifest struct {
+	ExportID   uuid.UUID             `json:"export_id"`
+	UserID     uuid.UUID             `json:"user_id"`
+	ExportedAt time.Time             `json:"exported_at"`
+	Videos     []exportManifestVideo `json:"videos"`
+}
+
+type exportManifestVideo struct {
+	ID          uuid.UUID            `json:"id"`
+	CreatedAt   time.Time            `json:"created_at"`
+	Title       string               `json:"title"`
+	Description string               `json:"description"`
+	Tags        database.VideoTags   `json:"tags"`
+	Files       []exportManifestFile `json:"files"`
+	// Skipped is why none of the video's files were exported
+	Skipped string `json:"skipped,omitempty"`
+}
+
+type exportManifestFile struct {
+	Kind     database.AssetKind `json:"kind"`
+	Key      string             `json:"key"`
+	Language string             `json:"language,omitempty"`
+	Size     int64              `json:"size"`
+	// Error is why the file couldn't be copied
+	Error string `json:"error,omitempty"`
+}
+
+// newVideoExportClient returns a client for an export's bucket, with the
+// credentials the user gave for it.
+func newVideoExportClient(params database.CreateVideoExportParams, accessKeyID, secretAccessKey string) *s3.Client {
+	return s3.New(s3.Options{
+		Region:      params.Region,
+		Credentials: aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(accessKeyID, secretAccessKey, "")),
+	}, func(o *s3.Options) {
+		if params.Endpoint != "" {
+			o.BaseEndpoint = aws.String(params.Endpoint)
+			o.UsePathStyle = true
+		}
+	})
+}
+
+// startVideoExport copies an export's objects in the background.
+func (cfg *apiConfig) startVideoExport(export database.VideoExport, dest objectStore) {
+	go func() {
+		start := time.Now()
+		err := cfg.runVideoExport(export, dest)
+		if err != nil {
+			logging.Errorf("Video export %s failed: %v", export.ID, err)
+			err = cfg.db.FailVideoExport(export.ID, err.Error())
+			if err != nil {
+				logging.Warnf("Couldn't record failure of video export %s: %v", export.ID, err)
+			}
+			return
+		}
+		logging.Infof("Video export %s to %s finished in %s", export.ID, export.Bucket,
+			time.Since(start).Round(time.Millisecond))
+	}()
+}
+
+// failInterruptedVideoExports fails the exports left pending by a previous
+// process. Their credentials were only held in memory, so they can't be
+// resumed.
+func (cfg *apiConfig) failInterruptedVideoExports() error {
+	failed, err := cfg.db.FailPendingVideoExports("the export was interrupted by a restart, start it again")
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		logging.Warnf("Failed %d video exports interrupted by a restart", failed)
+	}
+	return nil
+}
+
+// runVideoExport copies every object of the user's videos into the export's
+// bucket and then writes the manifest. An object that can't be copied
+// doesn't stop the rest, unless the bucket refuses the export altogether.
+func (cfg *apiConfig) runVideoExport(export database.VideoExport, dest objectStore) error {
+	videos, objects, err := cfg.videoExportObjects(export.UserID)
+	if err != nil {
+		return err
+	}
+	err = cfg.db.StartVideoExport(export.ID, int64(len(objects)))
+	if err != nil {
+		return err
+	}
+
+	manifest := exportManifest{ExportID: export.ID, UserID: export.UserID, Videos: []exportManifestVideo{}}
+	index := map[uuid.UUID]int{}
+	for _, video := range videos {
+		index[video.ID] = len(manifest.Videos)
+		entry := exportManifestVideo{
+			ID:          video.ID,
+			CreatedAt:   video.CreatedAt,
+			Title:       video.Title,
+			Description: video.Description,
+			Tags:        video.Tags,
+			Files:       []exportManifestFile{},
+		}
+		if video.Blocked {
+			logging.Infof("Video export %s skipped video %s, which is blocked by a takedown", export.ID, video.ID)
+			entry.Skipped = "the video is blocked by a takedown"
+		}
+		manifest.Videos = append(manifest.Videos, entry)
+	}
+
+	for _, object := range objects {
+		key := export.Prefix + object.videoID.String() + "/" + object.name
+		size, err := cfg.copyExportObject(dest, export.Bucket, key, object)
+		file := exportManifestFile{Kind: object.kind, Key: key, Language: object.language, Size: size}
+		var exported, failed int64 = 1, 0
+		if err != nil {
+			if exportRefused(err) {
+				return fmt.Errorf("the bucket refused the export: %w", err)
+			}
+			logging.Warnf("Video export %s couldn't copy %s %s: %v", export.ID, object.kind, object.location, err)
+			file.Size, file.Error = 0, err.Error()
+			exported, failed, size = 0, 1, 0
+		}
+		i := index[object.videoID]
+		manifest.Videos[i].Files = append(manifest.Videos[i].Files, file)
+		err = cfg.db.RecordVideoExportProgress(export.ID, exported, failed, size)
+		if err != nil {
+			return err
+		}
+	}
+
+	manifest.ExportedAt = time.Now().UTC()
+	dat, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	manifestKey := export.Prefix + videoExportManifestName
+	ctx, cancel := context.WithTimeout(context.Background(), videoExportObjectTimeout)
+	defer cancel()
+	_, err = dest.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(export.Bucket),
+		Key:         aws.String(manifestKey),
+		Body:        bytes.NewReader(dat),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't write manifest: %w", err)
+	}
+	return cfg.db.CompleteVideoExport(export.ID, manifestKey)
+}
+
+// videoExportObjects returns the user's own videos, not those they made for
+// an organization, and the objects exported for them: the original, or the
+// processed video for one without an original, the thumbnail and the
+// captions. Videos blocked by a takedown have none, since copying them out
+// would undo the takedown.
+func (cfg *apiConfig) videoExportObjects(userID uuid.UUID) ([]database.Video, []exportObject, error) {
+	allVideos, err := cfg.db.GetVideos(userID, database.VideoFilter{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't get videos: %w", err)
+	}
+	videos := []database.Video{}
+	objects := []exportObject{}
+	for _, video := range allVideos {
+		if video.OrganizationID != nil {
+			continue
+		}
+		videos = append(videos, video)
+		if video.Blocked {
+			continue
+		}
+
+		switch {
+		case video.OriginalURL != nil:
+			ext := path.Ext(*video.OriginalURL)
+			if ext == "" {
+				ext = ".mp4"
+			}
+			objects = append(objects, exportObject{videoID: video.ID, kind: database.AssetKindOriginal, storage: database.AssetStorageS3,
+				location: *video.OriginalURL, name: "original" + ext, contentType: "video/mp4"})
+		case video.VideoURL != nil && *video.VideoURL != "":
+			objects = append(objects, exportObject{videoID: video.ID, kind: database.AssetKindRendition, storage: database.AssetStorageS3,
+				location: *video.VideoURL, name: "video.mp4", contentType: "video/mp4"})
+		}
+
+		if video.ThumbnailURL != nil {
+			if storage, location, ok := cfg.thumbnailAsset(*video.ThumbnailURL); ok {
+				ext, contentType := ".jpg", "image/jpeg"
+				if strings.EqualFold(path.Ext(location), ".png") {
+					ext, contentType = ".png", "image/png"
+				}
+				objects = append(objects, exportObject{videoID: video.ID, kind: database.AssetKindThumbnail, storage: storage,
+					location: location, name: "thumbnail" + ext, contentType: contentType})
+			}
+		}
+
+		captions, err := cfg.db.GetCaptions(video.ID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("couldn't get captions of video %s: %w", video.ID, err)
+		}
+		for _, caption := range captions {
+			language := caption.Language
+			if language == "" {
+				language = "unknown"
+			}
+			objects = append(objects, exportObject{videoID: video.ID, kind: database.AssetKindCaption, storage: database.AssetStorageLocal,
+				location: caption.Filename, name: "captions/" + language + ".vtt", contentType: "text/vtt", language: caption.Language})
+		}
+	}
+	return videos, objects, nil
+}
+
+// copyExportObject uploads one stored object to key in the export's bucket,
+// going through a temporary file for objects that aren't local, and returns
+// its size.
+func (cfg *apiConfig) copyExportObject(dest objectStore, bucket, key string, object exportObject) (int64, error) {
+	var filePath string
+	switch object.storage {
+	case database.AssetStorageS3:
+		sourceBucket, sourceKey, err := parseBucketKey(object.location)
+		if err != nil {
+			return 0, err
+		}
+		filePath, err = cfg.downloadObjectToTemp(sourceBucket, sourceKey, "tubely-export-*")
+		if err != nil {
+			return 0, err
+		}
+		defer os.Remove(filePath)
+	case database.AssetStorageLocal:
+		if object.location != filepath.Base(object.location) {
+			return 0, fmt.Errorf("invalid asset filename %q", object.location)
+		}
+		filePath = filepath.Join(cfg.assetsRoot, object.location)
+	default:
+		return 0, fmt.Errorf("unknown asset storage %q", object.storage)
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	ctx, cancel := context.WithTimeout(context.Background(), videoExportObjectTimeout)
+	defer cancel()
+	_, err = dest.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(bucket),
+		Key:           aws.String(key),
+		Body:          file,
+		ContentLength: aws.Int64(size),
+		ContentType:   aws.String(object.contentType),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// exportRefused reports whether the destination bucket refused a copy in a
+// way every other copy would be refused too.
+func exportRefused(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && slices.Contains(videoExportRefusals, apiErr.ErrorCode())
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// refusingBucket refuses every upload, like a bucket the credentials can't
+// write to.
+type refusingBucket struct {
+	*memoryStorage
+}
+
+func (refusingBucket) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	return nil, &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied"}
+}
+
+func TestVideoExport(t *testing.T) {
+	cfg, storage, _ := newTestConfig(t)
+	userID, token := createTestUser(t, cfg, "dana@example.com")
+
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Keynote", UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = storage.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String("originals/keynote.mp4"),
+		Body:   strings.NewReader("original video"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	originalURL := testBucket + ",originals/keynote.mp4"
+	video.OriginalURL = &originalURL
+	thumbnailURL, err := cfg.storeThumbnailImage([]byte("thumbnail image"), "image/png", "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	video.ThumbnailURL = &thumbnailURL
+	err = cfg.db.UpdateVideo(video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename, err := cfg.writeContentAddressedAsset([]byte("WEBVTT\n"), "vtt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.db.CreateCaption(database.CreateCaptionParams{VideoID: video.ID, Language: "en", Source: database.CaptionSourceTranscription, Filename: filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The processed video of this one is gone from storage
+	missing, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Lost", UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	videoURL := testBucket + ",videos/lost.mp4"
+	missing.VideoURL = &videoURL
+	err = cfg.db.UpdateVideo(missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing of a video blocked by a takedown leaves
+	blocked, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "Taken down", UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocked.OriginalURL = &originalURL
+	err = cfg.db.UpdateVideo(blocked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimantID, _ := createTestUser(t, cfg, "claimant@example.com")
+	takedown, err := cfg.db.CreateTakedown(database.CreateTakedownParams{VideoID: blocked.ID, ClaimantUserID: claimantID, Reason: "infringing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.db.UpdateTakedownStatus(takedown.ID, database.TakedownStatusFiled, database.TakedownStatusBlocked, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := database.CreateVideoExportParams{UserID: userID, Bucket: "customer", Region: "us-east-1", Prefix: "tubely/"}
+	export, err := cfg.db.CreateVideoExport(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dest := newMemoryStorage()
+	err = cfg.runVideoExport(export, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export, err = cfg.db.GetVideoExport(export.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.Status != database.VideoExportStatusCompleted || export.Objects != 4 || export.Exported != 3 || export.Failed != 1 {
+		t.Errorf("export is %s with %d of %d objects exported and %d failed, want completed with 3 of 4 and 1 failed",
+			export.Status, export.Exported, export.Objects, export.Failed)
+	}
+	prefix := "customer/tubely/" + video.ID.String() + "/"
+	for name, want := range map[string]string{"original.mp4": "original video", "thumbnail.png": "thumbnail image", "captions/en.vtt": "WEBVTT\n"} {
+		object, ok := dest.object("customer", "tubely/"+video.ID.String()+"/"+name)
+		if !ok || string(object.data) != want {
+			t.Errorf("%s%s = %q, want %q", prefix, name, object.data, want)
+		}
+	}
+
+	if export.ManifestKey == nil || *export.ManifestKey != "tubely/manifest.json" {
+		t.Fatalf("manifest key = %v, want tubely/manifest.json", export.ManifestKey)
+	}
+	object, ok := dest.object("customer", *export.ManifestKey)
+	if !ok {
+		t.Fatalf("no manifest in %v", dest.names())
+	}
+	var manifest exportManifest
+	err = json.Unmarshal(object.data, &manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Videos) != 3 {
+		t.Fatalf("manifest has %d videos, want 3", len(manifest.Videos))
+	}
+	for _, v := range manifest.Videos {
+		switch v.ID {
+		case video.ID:
+			if v.Title != "Keynote" || len(v.Files) != 3 {
+				t.Errorf("manifest entry for %s: %+v", v.Title, v)
+			}
+		case missing.ID:
+			if len(v.Files) != 1 || v.Files[0].Kind != database.AssetKindRendition || v.Files[0].Error == "" {
+				t.Errorf("manifest entry for the missing video: %+v, want its rendition failed", v)
+			}
+		case blocked.ID:
+			if len(v.Files) != 0 || v.Skipped == "" {
+				t.Errorf("manifest entry for the blocked video: %+v, want it skipped", v)
+			}
+		}
+	}
+
+	// A bucket that refuses uploads fails the export right away
+	export, err = cfg.db.CreateVideoExport(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.runVideoExport(export, refusingBucket{dest})
+	if err == nil {
+		t.Error("exporting to a refusing bucket succeeded")
+	}
+
+	request := func(method, target, body string) *http.Request {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		return r
+	}
+	for _, body := range []string{
+		`{"bucket": "customer"}`,
+		`{"bucket": "customer", "access_key_id": "AKIA", "secret_access_key": "secret", "endpoint": "http://s3.example.com"}`,
+		`{"bucket": "customer", "access_key_id": "AKIA", "secret_access_key": "secret", "endpoint": "https://169.254.169.254"}`,
+	} {
+		w := httptest.NewRecorder()
+		cfg.handlerVideoExportCreate(w, request(http.MethodPost, "/api/video_exports", body))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("creating %s: status = %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+
+	_, otherToken := createTestUser(t, cfg, "eve@example.com")
+	r := httptest.NewRequest(http.MethodGet, "/api/video_exports/"+export.ID.String(), nil)
+	r.Header.Set("Authorization", "Bearer "+otherToken)
+	r.SetPathValue("exportID", export.ID.String())
+	w := httptest.NewRecorder()
+	cfg.handlerVideoExportGet(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("getting another user's export: status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	r = request(http.MethodGet, "/api/video_exports/"+export.ID.String(), "")
+	r.SetPathValue("exportID", export.ID.String())
+	w = httptest.NewRecorder()
+	cfg.handlerVideoExportGet(w, r)
+	var got database.VideoExport
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &got) != nil || got.ID == uuid.Nil {
+		t.Errorf("getting own export: status = %d: %s", w.Code, w.Body)
+	}
+}
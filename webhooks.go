@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// Webhook delivery settings. A failed delivery is retried with exponential
+// backoff, 30s doubling up to an hour, until maxWebhookAttempts is reached.
+const (
+	maxWebhookAttempts      = 8
+	webhookInitialBackoff   = 30 * time.Second
+	webhookMaxBackoff       = time.Hour
+	webhookRequestTimeout   = 10 * time.Second
+	webhookPollInterval     = 10 * time.Second
+	webhookBatchSize        = 50
+	maxWebhookResponseBytes = 1024
+)
+
+// Headers sent with every delivery. The signature header is
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>" keyed by the secret>".
+const (
+	webhookEventHeader     = "X-Tubely-Event"
+	webhookDeliveryHeader  = "X-Tubely-Delivery"
+	webhookSignatureHeader = "X-Tubely-Signature"
+)
+
+// webhookPayload is the body of every delivery.
+type webhookPayload struct {
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// fireWebhookEvent queues a delivery of event to each of the user's webhooks
+// subscribed to it.
+func (cfg *apiConfig) fireWebhookEvent(userID uuid.UUID, event string, data any) error {
+	webhooks, err := cfg.db.GetWebhooks(userID)
+	if err != nil {
+		return fmt.Errorf("couldn't get webhooks for user %s: %w", userID, err)
+	}
+
+	payload, err := json.Marshal(webhookPayload{
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't encode %s webhook payload: %w", event, err)
+	}
+
+	queued := false
+	for _, webhook := range webhooks {
+		if !slices.Contains(webhook.Events, event) {
+			continue
+		}
+		_, err := cfg.db.CreateWebhookDelivery(webhook.ID, event, payload)
+		if err != nil {
+			logging.Warnf("Couldn't queue %s delivery for webhook %s: %v", event, webhook.ID, err)
+			continue
+		}
+		queued = true
+	}
+	if queued {
+		cfg.nudgeWebhooks()
+	}
+	return nil
+}
+
+// nudgeWebhooks wakes the dispatcher without blocking the caller.
+func (cfg *apiConfig) nudgeWebhooks() {
+	select {
+	case cfg.webhookNudge <- struct{}{}:
+	default:
+	}
+}
+
+// startWebhookDispatcher sends due deliveries whenever one is queued and
+// polls for retries that have come due.
+func (cfg *apiConfig) startWebhookDispatcher() {
+	go func() {
+		ticker := time.NewTicker(webhookPollInterval)
+		defer ticker.Stop()
+		for {
+			cfg.deliverDueWebhooks()
+			select {
+			case <-ticker.C:
+			case <-cfg.webhookNudge:
+			}
+		}
+	}()
+}
+
+func (cfg *apiConfig) deliverDueWebhooks() {
+	for {
+		deliveries, err := cfg.db.GetDueWebhookDeliveries(time.Now(), webhookBatchSize)
+		if err != nil {
+			logging.Warnf("Couldn't list due webhook deliveries: %v", err)
+			return
+		}
+		for _, delivery := range deliveries {
+			cfg.deliverWebhook(delivery)
+		}
+		if len(deliveries) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+// deliverWebhook makes one attempt at a delivery and records the outcome,
+// scheduling a retry on failure until the attempts run out.
+func (cfg *apiConfig) deliverWebhook(delivery database.WebhookDelivery) {
+	webhook, err := cfg.db.GetWebhook(delivery.WebhookID)
+	if err != nil {
+		logging.Warnf("Couldn't get webhook %s: %v", delivery.WebhookID, err)
+		return
+	}
+
+	delivery.Attempts++
+	delivery.ResponseStatus = nil
+	delivery.ResponseBody = nil
+	delivery.Error = nil
+	if webhook.ID == uuid.Nil {
+		msg := "webhook was deleted"
+		delivery.Error = &msg
+		delivery.Attempts = maxWebhookAttempts
+	} else {
+		status, body, err := cfg.sendWebhook(webhook, delivery)
+		if status != 0 {
+			delivery.ResponseStatus = &status
+			delivery.ResponseBody = &body
+		}
+		if err != nil {
+			msg := err.Error()
+			delivery.Error = &msg
+		}
+	}
+
+	switch {
+	case delivery.Error == nil:
+		delivery.Status = database.WebhookDeliverySucceeded
+		delivery.NextAttemptAt = nil
+	case delivery.Attempts >= maxWebhookAttempts:
+		delivery.Status = database.WebhookDeliveryFailed
+		delivery.NextAttemptAt = nil
+	default:
+		next := time.Now().Add(webhookBackoff(delivery.Attempts))
+		delivery.NextAttemptAt = &next
+	}
+
+	err = cfg.db.UpdateWebhookDelivery(delivery)
+	if err != nil {
+		logging.Warnf("Couldn't record webhook delivery %s: %v", delivery.ID, err)
+	}
+}
+
+// newWebhookClient returns the client deliveries are sent with. Users pick
+// the URLs and can read the start of each response in the delivery log, so
+// it only connects to public addresses, without a proxy that would connect
+// for it, and doesn't follow redirects: a redirect fails the delivery with
+// its status.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: webhookRequestTimeout, Control: ingest.PublicDialControl}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   webhookRequestTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// sendWebhook posts a signed delivery. Any response other than 2xx is an
+// error; the status and the start of the response body are returned for the
+// delivery log either way.
+func (cfg *apiConfig) sendWebhook(webhook database.Webhook, delivery database.WebhookDelivery) (int, string, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, "", fmt.Errorf("couldn't create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Tubely-Webhooks/1.0")
+	req.Header.Set(webhookEventHeader, delivery.Event)
+	req.Header.Set(webhookDeliveryHeader, delivery.ID.String())
+	req.Header.Set(webhookSignatureHeader, fmt.Sprintf("t=%s,v1=%s", timestamp, signWebhook(webhook.Secret, timestamp, delivery.Payload)))
+
+	resp, err := cfg.webhookClient.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxWebhookResponseBytes))
+	// Drain the rest so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, string(body), fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, string(body), nil
+}
+
+// signWebhook computes the hex HMAC-SHA256 of "<timestamp>.<payload>".
+// Including the timestamp lets receivers reject replayed deliveries.
+func signWebhook(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff is the wait before the next attempt after the given number
+// of failed attempts.
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookInitialBackoff
+	for i := 1; i < attempts && backoff < webhookMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, webhookMaxBackoff)
+}
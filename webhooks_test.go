@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/ingest"
+)
+
+func TestWebhookPrivateAddresses(t *testing.T) {
+	cfg, _, _ := newTestConfig(t)
+	_, token := createTestUser(t, cfg, "grace@example.com")
+
+	for _, endpoint := range []string{"http://127.0.0.1:8091/hook", "http://169.254.169.254/latest/meta-data/"} {
+		r := httptest.NewRequest(http.MethodPost, "/api/webhooks", strings.NewReader(`{"url": "`+endpoint+`", "events": ["`+events.TypeVideoProcessed+`"]}`))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		cfg.handlerWebhookCreate(w, r)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "public address") {
+			t.Errorf("creating a webhook for %s: status = %d: %s, want %d", endpoint, w.Code, w.Body, http.StatusBadRequest)
+		}
+	}
+
+	// Addresses are checked again when connecting, in case the host
+	// resolves to a different one by then
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+	_, err := newWebhookClient().Post(server.URL, "application/json", strings.NewReader("{}"))
+	if !errors.Is(err, ingest.ErrPrivateAddress) {
+		t.Errorf("delivering to %s: err = %v, want %v", server.URL, err, ingest.ErrPrivateAddress)
+	}
+}
+
+func TestWebhookClientRefusesRedirects(t *testing.T) {
+	client := newWebhookClient()
+	client.Transport = http.DefaultTransport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hook" {
+			http.Redirect(w, r, "/internal", http.StatusFound)
+			return
+		}
+		t.Errorf("the redirect to %s was followed", r.URL.Path)
+	}))
+	defer server.Close()
+	resp, err := client.Post(server.URL+"/hook", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/errreport"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/events"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/logging"
+	"github.com/google/uuid"
+)
+
+// maxJobAttempts is how many times a job is started before it is given up on.
+const maxJobAttempts = 3
+
+// workerPool is the goroutines processing jobs from the queue. It can be
+// resized while running; a worker told to stop finishes its current job
+// first.
+type workerPool struct {
+	cfg *apiConfig
+
+	mu   sync.Mutex
+	size int
+	stop chan struct{}
+}
+
+func newWorkerPool(cfg *apiConfig) *workerPool {
+	return &workerPool{cfg: cfg, stop: make(chan struct{})}
+}
+
+func (p *workerPool) resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for ; p.size < n; p.size++ {
+		go p.work()
+	}
+	for ; p.size > n; p.size-- {
+		// Busy workers only take this once their job is done
+		go func() { p.stop <- struct{}{} }()
+	}
+}
+
+func (p *workerPool) work() {
+	for {
+		select {
+		case jobID := <-p.cfg.jobQueue:
+			p.cfg.runJob(jobID)
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// enqueueJob hands a persisted job to the workers without blocking the caller.
+func (cfg *apiConfig) enqueueJob(jobID uuid.UUID) {
+	go func() {
+		cfg.jobQueue <- jobID
+	}()
+}
+
+// resumeJobs re-queues every job left queued or running by a previous process.
+// Jobs whose source file is gone, or that have exhausted their attempts, are
+// marked as failed so the video doesn't look like it's processing forever.
+// Multipart uploads that can't be resumed are aborted so their parts aren't
+// left behind in the bucket.
+func (cfg *apiConfig) resumeJobs() error {
+	jobs, err := cfg.db.GetIncompleteJobs()
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if job.Status == database.JobStatusRunning && job.Attempts >= maxJobAttempts {
+			cfg.failJob(job, errors.New("interrupted too many times"))
+			continue
+		}
+		if _, err := os.Stat(job.SourcePath); job.SourcePath != "" && err != nil {
+			// Fall back to the copy in S3 if one was stored before the restart
+			video, err := cfg.db.GetVideo(job.VideoID)
+			if err != nil {
+				return err
+			}
+			if video.OriginalURL == nil && video.VideoURL == nil {
+				cfg.failJob(job, errors.New("source file unavailable"))
+				continue
+			}
+			job.SourcePath = ""
+		}
+
+		// Only an upload of the untouched source can be resumed; processed
+		// output is regenerated, so its half-finished upload is thrown away
+		if job.UploadID != "" {
+			_, statErr := os.Stat(job.UploadPath)
+			if statErr != nil || job.UploadPath != job.SourcePath {
+				cfg.abortJobUpload(&job)
+			}
+		}
+
+		job.Status = database.JobStatusQueued
+		err = cfg.db.UpdateJob(job)
+		if err != nil {
+			return err
+		}
+		logging.Infof("Resuming job %s for video %s", job.ID, job.VideoID)
+		cfg.enqueueJob(job.ID)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) runJob(jobID uuid.UUID) {
+	job, err := cfg.db.GetJob(jobID)
+	if err != nil {
+		logging.Warnf("Couldn't load job %s: %v", jobID, err)
+		return
+	}
+	if job.Status != database.JobStatusQueued {
+		return
+	}
+
+	job.Status = database.JobStatusRunning
+	job.Attempts++
+	job.Progress = 0
+	err = cfg.db.UpdateJob(job)
+	if err != nil {
+		logging.Errorf("Couldn't mark job %s as running: %v", job.ID, err)
+		return
+	}
+
+	// Another instance may be processing the same video; wait for it
+	processingLock, err := cfg.locks.Acquire(context.Background(), videoProcessingLockKey(job.VideoID), videoLockTTL)
+	if err != nil {
+		logging.Warnf("Couldn't lock video %s for job %s: %v", job.VideoID, job.ID, err)
+		cfg.failJob(job, err)
+		return
+	}
+	ctx, cancel := lockContext(context.Background(), processingLock)
+	err = cfg.processVideo(ctx, &job)
+	cancel()
+	processingLock.Release()
+	if err != nil {
+		logging.Errorf("Job %s for video %s failed: %v", job.ID, job.VideoID, err)
+		cfg.failJob(job, err)
+		return
+	}
+
+	job.Status = database.JobStatusDone
+	job.Progress = 100
+	err = cfg.db.UpdateJob(job)
+	if err != nil {
+		logging.Errorf("Couldn't mark job %s as done: %v", job.ID, err)
+	}
+	os.Remove(job.SourcePath)
+	cfg.publishVideoEvent(job.VideoID, events.TypeVideoProcessed, map[string]any{"job_id": job.ID})
+	cfg.announceIfPublished(job.VideoID)
+}
+
+func (cfg *apiConfig) failJob(job database.Job, jobErr error) {
+	cfg.abortJobUpload(&job)
+	msg := jobErr.Error()
+	code := string(jobErrorCode(jobErr))
+	job.Status = database.JobStatusFailed
+	job.Error = &msg
+	job.ErrorCode = &code
+	err := cfg.db.UpdateJob(job)
+	if err != nil {
+		logging.Errorf("Couldn't mark job %s as failed: %v", job.ID, err)
+	}
+	os.Remove(job.SourcePath)
+	cfg.reportError(errreport.Event{
+		Message: "Processing job failed",
+		Err:     jobErr,
+		Tags: map[string]string{
+			"job_id":     job.ID.String(),
+			"video_id":   job.VideoID.String(),
+			"error_code": code,
+		},
+	})
+	cfg.publishVideoEvent(job.VideoID, events.TypeVideoFailed, map[string]any{"job_id": job.ID, "error": msg, "error_code": code})
+}
+
+// processVideo probes the source file, rewrites it for fast start, uploads the
+// result to S3 and points the video record at it, then transcribes captions
+// when a transcriber is configured. Jobs without a local source
+// file (reprocessing, or resumed after the temp file was lost) fetch the stored
+// original from S3 first. The previous output is only deleted once the record
+// points at the new one. Once ctx is done, when the processing lock is lost,
+// it stops without changing the record.
+func (cfg *apiConfig) processVideo(ctx context.Context, job *database.Job) error {
+	video, err := cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		return fmt.Errorf("couldn't get video: %w", err)
+	}
+	if video.ID == uuid.Nil {
+		return errors.New("video no longer exists")
+	}
+
+	isNewUpload := job.SourcePath != ""
+	if !isNewUpload {
+		sourcePath, err := cfg.downloadVideoSource(video)
+		if err != nil {
+			return err
+		}
+		job.SourcePath = sourcePath
+		err = cfg.db.UpdateJob(*job)
+		if err != nil {
+			return fmt.Errorf("couldn't record downloaded source: %w", err)
+		}
+	}
+
+	if info, err := os.Stat(job.SourcePath); err == nil {
+		processingInputBytes.Observe(float64(info.Size()))
+	}
+
+	// Keep the untouched upload so the video can be reprocessed later
+	if isNewUpload && video.OriginalURL == nil {
+		originalKey, err := cfg.objectKey(video, renditionOriginal)
+		if err != nil {
+			return err
+		}
+		originalBucket := cfg.bucketFor(objectClassOriginal)
+		uploadStart := time.Now()
+		originalKey, originalChecksum, err := cfg.uploadJobFile(job, job.SourcePath, originalBucket, originalKey, cfg.videoObjectHeaders(video, job.MediaType))
+		observeStage(processingStageUpload, uploadStart)
+		if err != nil {
+			return withJobErrorCode(errCodeStorageFailed, fmt.Errorf("couldn't upload original to S3: %w", err))
+		}
+		originalURL := fmt.Sprintf("%s,%s", originalBucket, originalKey)
+		cfg.trackAsset(video.ID, database.AssetKindOriginal, database.AssetStorageS3, originalURL)
+		video, err = cfg.changeVideo(video.ID, func(video *database.Video) bool {
+			video.OriginalURL = &originalURL
+			video.OriginalChecksum = originalChecksum
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't update video: %w", err)
+		}
+	}
+
+	probeStart := time.Now()
+	probe, err := cfg.prober.Probe(ctx, job.SourcePath)
+	observeStage(processingStageProbe, probeStart)
+	if err != nil {
+		return withJobErrorCode(errCodeUnsupportedContainer, fmt.Errorf("couldn't probe video: %w", err))
+	}
+	if fillFromContainerTags(&video, probe) {
+		logging.Infof("Filled in title/description of video %s from container metadata", video.ID)
+	}
+
+	// Get the dimensions of the video
+	stream, ok := probe.VideoStream()
+	if !ok {
+		return withJobErrorCode(errCodeUnsupportedContainer, errors.New("no video stream found in video"))
+	}
+	if stream.Width == 0 || stream.Height == 0 {
+		return withJobErrorCode(errCodeUnsupportedContainer, fmt.Errorf("invalid dimensions: width=%d, height=%d", stream.Width, stream.Height))
+	}
+	sarNum, sarDen := stream.SampleAspect()
+
+	resolutionClass, err := getResolutionClass(probe)
+	if err != nil {
+		return fmt.Errorf("couldn't determine video resolution: %w", err)
+	}
+	hdrFormat := getHDRFormat(probe)
+
+	audioTracks := probeAudioTracks(probe, job.AudioTracks)
+	for _, index := range job.AudioTracks {
+		if index < 0 || index >= len(audioTracks) {
+			return withJobErrorCode(errCodeInvalidAudioTrack, fmt.Errorf("audio track %d doesn't exist, video has %d audio tracks", index, len(audioTracks)))
+		}
+	}
+
+	// Process video for fast start, recording ffmpeg's progress on the job
+	fastStartStart := time.Now()
+	processedFilePath, err := cfg.transcoder.FastStart(ctx, job.SourcePath, job.AudioTracks, probe.Duration(), func(percent int) {
+		job.Progress = percent
+		if err := cfg.db.UpdateJobProgress(job.ID, percent); err != nil {
+			logging.Warnf("Couldn't record progress for job %s: %v", job.ID, err)
+		}
+	})
+	observeStage(processingStageFastStart, fastStartStart)
+	if err != nil {
+		return fmt.Errorf("couldn't process video for fast start: %w", err)
+	}
+	defer os.Remove(processedFilePath) // Clean up processed file
+
+	s3Key, err := cfg.objectKey(video, renditionName(stream.Width, stream.Height, sarNum, sarDen))
+	if err != nil {
+		return err
+	}
+
+	// Upload to S3 using the processed file
+	s3Bucket := cfg.bucketFor(objectClassRendition)
+	uploadStart := time.Now()
+	s3Key, videoChecksum, err := cfg.uploadJobFile(job, processedFilePath, s3Bucket, s3Key, cfg.videoObjectHeaders(video, job.MediaType))
+	observeStage(processingStageUpload, uploadStart)
+	if err != nil {
+		return withJobErrorCode(errCodeStorageFailed, fmt.Errorf("couldn't upload to S3: %w", err))
+	}
+
+	// Update video URL in database with bucket,key format. The record is
+	// re-read, since it may have been edited while the video was processing
+	var previousVideoURL *string
+	videoURL := fmt.Sprintf("%s,%s", s3Bucket, s3Key)
+	cfg.trackAsset(video.ID, database.AssetKindRendition, database.AssetStorageS3, videoURL)
+	var videoSize *int64
+	if info, err := os.Stat(processedFilePath); err == nil {
+		size := info.Size()
+		videoSize = &size
+	}
+	moderationStatus, moderationLabels := cfg.moderateVideo(video, job.SourcePath, probe.Duration())
+
+	// Another worker may be processing the video by now, so the record is
+	// left to it
+	if err := context.Cause(ctx); err != nil {
+		return fmt.Errorf("stopped processing: %w", err)
+	}
+	video, err = cfg.changeVideo(video.ID, func(video *database.Video) bool {
+		fillFromContainerTags(video, probe)
+		previousVideoURL = video.VideoURL
+		video.VideoURL = &videoURL
+		video.VideoChecksum = videoChecksum
+		video.AudioTracks = audioTracks
+		video.ResolutionClass = &resolutionClass
+		video.HDRFormat = &hdrFormat
+		video.Width = &stream.Width
+		video.Height = &stream.Height
+		video.SampleAspectNum = sarNum
+		video.SampleAspectDen = sarDen
+		video.VideoSize = videoSize
+		if duration := probe.Duration(); duration > 0 {
+			seconds := duration.Seconds()
+			video.DurationSeconds = &seconds
+		}
+		video.ModerationStatus, video.ModerationLabels = moderationStatus, moderationLabels
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't update video: %w", err)
+	}
+	if cfg.usageMeter != nil {
+		cfg.usageMeter.add(video.UserID, database.UsageMetricTranscodeSeconds, probe.Duration().Seconds())
+	}
+
+	cfg.generateThumbnailCandidates(video, job.SourcePath, probe.Duration())
+	cfg.transcribeVideo(video, processedFilePath)
+
+	// A template without {random} reuses the key, which now holds the new output
+	if previousVideoURL != nil && *previousVideoURL != videoURL {
+		err := cfg.releaseAsset(database.CreateAssetParams{
+			VideoID:  video.ID,
+			Kind:     database.AssetKindRendition,
+			Storage:  database.AssetStorageS3,
+			Location: *previousVideoURL,
+		})
+		if err != nil {
+			logging.Warnf("Couldn't delete previous output for video %s: %v", video.ID, err)
+		}
+	}
+	return nil
+}
+
+// downloadVideoSource fetches the best available source for a video from S3:
+// the stored original, or the processed output for videos uploaded before
+// originals were kept.
+func (cfg *apiConfig) downloadVideoSource(video database.Video) (string, error) {
+	location := video.OriginalURL
+	if location == nil {
+		location = video.VideoURL
+	}
+	if location == nil {
+		return "", errors.New("video has no stored source")
+	}
+
+	bucket, key, err := parseBucketKey(*location)
+	if err != nil {
+		return "", err
+	}
+	sourcePath, err := cfg.downloadObjectToTemp(bucket, key, "tubely-upload.mp4")
+	if err != nil {
+		return "", fmt.Errorf("couldn't download source: %w", err)
+	}
+	return sourcePath, nil
+}